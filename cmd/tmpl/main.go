@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/acebelowzero/tmpl/internal/cli"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := cli.NewRootCmd(ctx, nil).ExecuteContext(ctx); err != nil {
+		stop()
+		os.Exit(cli.ExitCode(err))
+	}
+}
@@ -0,0 +1,214 @@
+// Package audit records the operations that change a swarm, such as
+// applies, rollbacks and uninstalls, to an append-only sink.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+)
+
+// Environment variables read by the package.
+const (
+	// EnvSink overrides the configured sink location.
+	EnvSink = "TMPL_AUDIT_SINK"
+	// EnvUser overrides the user recorded for operations, such as the
+	// identity of a CI job.
+	EnvUser = "TMPL_AUDIT_USER"
+	// EnvToken is sent as a bearer token to webhook sinks.
+	EnvToken = "TMPL_AUDIT_TOKEN"
+)
+
+// Results of an operation.
+const (
+	ResultSucceeded = "succeeded"
+	ResultFailed    = "failed"
+	// ResultAborted marks an operation declined at the confirmation
+	// prompt.
+	ResultAborted = "aborted"
+)
+
+// Record describes one operation.
+type Record struct {
+	Time time.Time `json:"time"`
+	User string    `json:"user"`
+	Host string    `json:"host,omitempty"`
+	// Operation is the command, such as apply or rollback, and Command
+	// the arguments tmpl ran with.
+	Operation string   `json:"operation"`
+	Command   []string `json:"command"`
+	Release   string   `json:"release,omitempty"`
+	Namespace string   `json:"namespace,omitempty"`
+	Revision  int      `json:"revision,omitempty"`
+	Chart     string   `json:"chart,omitempty"`
+	// ChartDigest and ValuesDigest identify what was deployed.
+	ChartDigest  string `json:"chartDigest,omitempty"`
+	ValuesDigest string `json:"valuesDigest,omitempty"`
+	// Annotations are those of the revision, e.g. a ticket.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Result      string            `json:"result"`
+	Error       string            `json:"error,omitempty"`
+	DurationMS  int64             `json:"durationMs"`
+}
+
+// Sink stores records. Records are never changed or removed once written.
+type Sink interface {
+	Write(ctx context.Context, r *Record) error
+}
+
+// Open returns the sink of location:
+//
+//   - a path or file://PATH appends JSON lines to a file,
+//   - s3://BUCKET/PREFIX writes one object per record,
+//   - http:// or https:// URLs receive each record as a JSON POST.
+//
+// An empty location disables auditing and yields a nil Sink.
+func Open(ctx context.Context, location string) (Sink, error) {
+	switch {
+	case location == "":
+		return nil, nil
+	case strings.HasPrefix(location, "s3://"):
+		return newS3Sink(ctx, location)
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		if _, err := url.Parse(location); err != nil {
+			return nil, fmt.Errorf("parse audit webhook %s: %w", location, err)
+		}
+		return &webhookSink{url: location, token: os.Getenv(EnvToken), client: &http.Client{Timeout: 10 * time.Second}}, nil
+	default:
+		return &fileSink{path: strings.TrimPrefix(location, "file://")}, nil
+	}
+}
+
+// Validate checks that location names a supported sink.
+func Validate(location string) error {
+	scheme, _, ok := strings.Cut(location, "://")
+	if !ok {
+		return nil
+	}
+	switch scheme {
+	case "file", "s3", "http", "https":
+		return nil
+	}
+	return fmt.Errorf("unsupported audit sink %s: use a path, file://, s3:// or an http(s) URL", location)
+}
+
+// CurrentUser returns $TMPL_AUDIT_USER or the name of the operating system
+// user.
+func CurrentUser() string {
+	if name := os.Getenv(EnvUser); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+type fileSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Write appends r as a single line, so records of concurrent writers are
+// not interleaved.
+func (s *fileSink) Write(_ context.Context, r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("encode audit record: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("write audit log %s: %w", s.path, err)
+	}
+	return f.Close()
+}
+
+type s3Sink struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func newS3Sink(ctx context.Context, location string) (*s3Sink, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("parse audit sink %s: %w", location, err)
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load aws config: %w", err)
+	}
+	return &s3Sink{client: s3.NewFromConfig(cfg), bucket: u.Host, prefix: strings.Trim(u.Path, "/")}, nil
+}
+
+// Write stores r as PREFIX/YYYY/MM/DD/<time>-<id>.json, refusing to
+// replace an existing object.
+func (s *s3Sink) Write(ctx context.Context, r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("encode audit record: %w", err)
+	}
+	t := r.Time.UTC()
+	key := path.Join(s.prefix, t.Format("2006/01/02"), t.Format("20060102T150405.000000000Z")+"-"+uuid.NewString()+".json")
+	contentType, absent := "application/json", "*"
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &s.bucket,
+		Key:         &key,
+		Body:        bytes.NewReader(data),
+		ContentType: &contentType,
+		IfNoneMatch: &absent,
+	})
+	if err != nil {
+		return fmt.Errorf("put s3://%s/%s: %w", s.bucket, key, err)
+	}
+	return nil
+}
+
+type webhookSink struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (s *webhookSink) Write(ctx context.Context, r *Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("encode audit record: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post audit record: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("post audit record to %s: %s", s.url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package chart
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxArchiveFileSize bounds individual files extracted from an archive.
+const maxArchiveFileSize = 64 << 20
+
+// Archive is a packaged chart.
+type Archive struct {
+	Path     string
+	Digest   string
+	Metadata *Metadata
+}
+
+// ArchiveName returns the conventional file name for a packaged chart.
+func ArchiveName(meta *Metadata) string {
+	return fmt.Sprintf("%s-%s.tgz", meta.Name, meta.Version)
+}
+
+// Package writes the chart in dir to destDir as <name>-<version>.tgz. The
+// archive is reproducible: entries are sorted and timestamps are zeroed so
+// identical chart contents always yield the same digest.
+func Package(dir, destDir string) (*Archive, error) {
+	meta, err := LoadMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Version == "" {
+		return nil, fmt.Errorf("chart %s has no version", meta.Name)
+	}
+	// Packages are installed without access to what local dependencies
+	// point to, so every dependency has to be vendored.
+	for _, dep := range meta.Dependencies {
+		if _, err := os.Stat(filepath.Join(dir, DependenciesDir, dep.Name, MetadataFile)); err != nil {
+			return nil, fmt.Errorf("chart %s: dependency %s is not vendored in %s, run 'tmpl dependency update'", meta.Name, dep.Name, DependenciesDir)
+		}
+	}
+
+	data, err := archiveDir(dir, meta.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create destination %s: %w", destDir, err)
+	}
+	target := filepath.Join(destDir, ArchiveName(meta))
+	if err := os.WriteFile(target, data, 0o644); err != nil {
+		return nil, fmt.Errorf("write archive %s: %w", target, err)
+	}
+	return &Archive{Path: target, Digest: Digest(data), Metadata: meta}, nil
+}
+
+// Digest returns the sha256 digest of data in OCI notation.
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// DirDigest returns the digest Package gives the chart in dir, identifying
+// its content without writing an archive.
+func DirDigest(dir string) (string, error) {
+	meta, err := LoadMetadata(dir)
+	if err != nil {
+		return "", err
+	}
+	data, err := archiveDir(dir, meta.Name)
+	if err != nil {
+		return "", err
+	}
+	return Digest(data), nil
+}
+
+func archiveDir(dir, prefix string) ([]byte, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk chart %s: %w", dir, err)
+	}
+	sort.Strings(files)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", file, err)
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		hdr := &tar.Header{
+			Name:    path.Join(prefix, filepath.ToSlash(rel)),
+			Mode:    int64(info.Mode().Perm()),
+			Size:    int64(len(data)),
+			ModTime: time.Unix(0, 0),
+			Format:  tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Extract unpacks a packaged chart into destDir, stripping the leading
+// chart-name directory. It returns the directory containing Chart.yaml.
+func Extract(data []byte, destDir string) (string, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("open chart archive: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	found := false
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("read chart archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if _, rest, ok := strings.Cut(name, "/"); ok {
+			name = rest
+		}
+		// IsLocal also rejects names that only escape the directory on
+		// Windows, such as ones with a drive letter or a backslash traversal.
+		if name == "" || name == "." || strings.HasPrefix(name, "../") || path.IsAbs(name) ||
+			!filepath.IsLocal(filepath.FromSlash(name)) {
+			return "", fmt.Errorf("invalid path %q in chart archive", hdr.Name)
+		}
+		if hdr.Size > maxArchiveFileSize {
+			return "", fmt.Errorf("file %s in chart archive exceeds %d bytes", hdr.Name, maxArchiveFileSize)
+		}
+		target := filepath.Join(destDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return "", err
+		}
+		contents, err := io.ReadAll(io.LimitReader(tr, maxArchiveFileSize))
+		if err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(target, contents, os.FileMode(hdr.Mode).Perm()|0o600); err != nil {
+			return "", fmt.Errorf("write %s: %w", target, err)
+		}
+		if name == MetadataFile {
+			found = true
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("chart archive does not contain %s", MetadataFile)
+	}
+	return destDir, nil
+}
@@ -0,0 +1,192 @@
+package chart
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/semver"
+)
+
+// MetadataFile is the name of the chart metadata file at the chart root.
+const MetadataFile = "Chart.yaml"
+
+// Metadata mirrors the fields of Chart.yaml.
+type Metadata struct {
+	APIVersion  string `yaml:"apiVersion" json:"apiVersion"`
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	Version     string `yaml:"version" json:"version"`
+	AppVersion  string `yaml:"appVersion,omitempty" json:"appVersion,omitempty"`
+	// Type is TypeApplication, the default, or TypeLibrary.
+	Type         string       `yaml:"type,omitempty" json:"type,omitempty"`
+	Dependencies []Dependency `yaml:"dependencies,omitempty" json:"dependencies,omitempty"`
+	Home         string       `yaml:"home,omitempty" json:"home,omitempty"`
+	Icon         string       `yaml:"icon,omitempty" json:"icon,omitempty"`
+	Sources      []string     `yaml:"sources,omitempty" json:"sources,omitempty"`
+	Keywords     []string     `yaml:"keywords,omitempty" json:"keywords,omitempty"`
+	Maintainers  []Maintainer `yaml:"maintainers,omitempty" json:"maintainers,omitempty"`
+	// TmplVersion is a semver constraint on the tmpl versions that can
+	// render the chart.
+	TmplVersion string `yaml:"tmplVersion,omitempty" json:"tmplVersion,omitempty"`
+	// EngineVersion is a semver constraint on the Docker Engine versions
+	// the chart can be applied to.
+	EngineVersion string `yaml:"engineVersion,omitempty" json:"engineVersion,omitempty"`
+	// Deprecated marks a chart that should no longer be used, and
+	// ReplacedBy names the chart succeeding it.
+	Deprecated bool   `yaml:"deprecated,omitempty" json:"deprecated,omitempty"`
+	ReplacedBy string `yaml:"replacedBy,omitempty" json:"replacedBy,omitempty"`
+	// TemplateExtensions are the suffixes of the files under templates/
+	// that are executed as templates, DefaultTemplateExtension when empty.
+	TemplateExtensions []string `yaml:"templateExtensions,omitempty" json:"templateExtensions,omitempty"`
+	// RawCopy holds path.Match patterns, relative to templates/, of files
+	// that are part of the rendered stack as they are, without being
+	// executed, such as pre-rendered configs.
+	RawCopy []string `yaml:"rawCopy,omitempty" json:"rawCopy,omitempty"`
+	// Validations are checked against the merged values before the chart
+	// is rendered.
+	Validations []Validation `yaml:"validations,omitempty" json:"validations,omitempty"`
+}
+
+// Validation is a rule the merged values of a chart must follow, such as
+// "ingress.host must be set when ingress.enabled is true". Its expressions
+// are template pipelines, as in {{ if ... }}, which may call helpers.
+type Validation struct {
+	// Each, when set, yields a list or map the rule is checked for item by
+	// item, with the item as .Item and its index or key as .Key.
+	Each string `yaml:"each,omitempty" json:"each,omitempty"`
+	// When, when set, limits the rule to values for which it is true.
+	When string `yaml:"when,omitempty" json:"when,omitempty"`
+	// Assert must be true for the values to be valid.
+	Assert string `yaml:"assert" json:"assert"`
+	// Message is a template describing a failure. It defaults to the
+	// assert expression.
+	Message string `yaml:"message,omitempty" json:"message,omitempty"`
+}
+
+// Maintainer is a person or team maintaining a chart.
+type Maintainer struct {
+	Name  string `yaml:"name" json:"name"`
+	Email string `yaml:"email,omitempty" json:"email,omitempty"`
+	URL   string `yaml:"url,omitempty" json:"url,omitempty"`
+}
+
+// String formats the maintainer as "Name <email> (url)", leaving out what
+// is not set.
+func (m Maintainer) String() string {
+	s := m.Name
+	if m.Email != "" {
+		s += " <" + m.Email + ">"
+	}
+	if m.URL != "" {
+		s += " (" + m.URL + ")"
+	}
+	return s
+}
+
+// LoadMetadata reads and decodes Chart.yaml from the chart directory.
+func LoadMetadata(dir string) (*Metadata, error) {
+	path := filepath.Join(dir, MetadataFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	meta := &Metadata{}
+	if err := yaml.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if meta.Name == "" {
+		return nil, fmt.Errorf("%s: name is required", path)
+	}
+	switch meta.Type {
+	case "", TypeApplication, TypeLibrary:
+	default:
+		return nil, fmt.Errorf("%s: unknown type %q, must be %s or %s", path, meta.Type, TypeApplication, TypeLibrary)
+	}
+	for i, m := range meta.Maintainers {
+		if m.Name == "" {
+			return nil, fmt.Errorf("%s: maintainers[%d]: name is required", path, i)
+		}
+	}
+	if meta.ReplacedBy != "" && !meta.Deprecated {
+		return nil, fmt.Errorf("%s: replacedBy requires deprecated: true", path)
+	}
+	for field, constraint := range map[string]string{"tmplVersion": meta.TmplVersion, "engineVersion": meta.EngineVersion} {
+		if constraint == "" {
+			continue
+		}
+		if _, err := semver.ParseConstraint(constraint); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, field, err)
+		}
+	}
+	if err := meta.checkTemplateFiles(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, v := range meta.Validations {
+		if strings.TrimSpace(v.Assert) == "" {
+			return nil, fmt.Errorf("%s: validations[%d]: assert is required", path, i)
+		}
+	}
+	for i, dep := range meta.Dependencies {
+		if dep.Name == "" {
+			return nil, fmt.Errorf("%s: dependencies[%d]: name is required", path, i)
+		}
+		if strings.ContainsAny(dep.Name, `/\`) || !filepath.IsLocal(dep.Name) {
+			return nil, fmt.Errorf("%s: dependencies[%d]: invalid name %q", path, i, dep.Name)
+		}
+	}
+	return meta, nil
+}
+
+// SetVersion rewrites the version of Chart.yaml in the chart directory and,
+// unless appVersion is empty, its appVersion. Other fields and comments are
+// kept.
+func SetVersion(dir, version, appVersion string) error {
+	path := filepath.Join(dir, MetadataFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	if data, err = setField(data, "version", version); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if appVersion != "" {
+		if data, err = setField(data, "appVersion", appVersion); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// Contains reports whether path, with symlinks resolved, is inside the
+// chart directory dir, so files of untrusted charts can be kept from
+// reaching the rest of the host.
+func Contains(dir, path string) (bool, error) {
+	root, err := resolve(dir)
+	if err != nil {
+		return false, err
+	}
+	resolved, err := resolve(path)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil {
+		return false, nil
+	}
+	return filepath.IsLocal(rel), nil
+}
+
+func resolve(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
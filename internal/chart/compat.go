@@ -0,0 +1,85 @@
+package chart
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/acebelowzero/tmpl/internal/semver"
+)
+
+// CheckTmplVersion reports whether tmpl at version current satisfies the
+// tmplVersion constraint of the chart. Development builds, whose version
+// is not a semantic version, are not checked.
+func (m *Metadata) CheckTmplVersion(current string) error {
+	if m.TmplVersion == "" {
+		return nil
+	}
+	v, err := semver.Parse(current)
+	if err != nil {
+		return nil
+	}
+	return checkVersion(m.TmplVersion, v, fmt.Sprintf("chart %s requires tmpl %s, this is tmpl %s", m.Name, m.TmplVersion, current))
+}
+
+// CheckEngineVersion reports whether a Docker Engine at version current
+// satisfies the engineVersion constraint of the chart.
+func (m *Metadata) CheckEngineVersion(current string) error {
+	if m.EngineVersion == "" {
+		return nil
+	}
+	v, err := parseEngineVersion(current)
+	if err != nil {
+		return fmt.Errorf("chart %s requires Docker Engine %s: %w", m.Name, m.EngineVersion, err)
+	}
+	return checkVersion(m.EngineVersion, v, fmt.Sprintf("chart %s requires Docker Engine %s, the engine is %s", m.Name, m.EngineVersion, current))
+}
+
+// CheckDeprecated returns an error naming the successor of the chart when
+// it is deprecated.
+func (m *Metadata) CheckDeprecated() error {
+	if !m.Deprecated {
+		return nil
+	}
+	kind := "chart"
+	if m.IsLibrary() {
+		kind = "library chart"
+	}
+	if m.ReplacedBy != "" {
+		return fmt.Errorf("%s %s %s is deprecated, use %s instead", kind, m.Name, m.Version, m.ReplacedBy)
+	}
+	return fmt.Errorf("%s %s %s is deprecated", kind, m.Name, m.Version)
+}
+
+// parseEngineVersion parses a Docker Engine version such as 24.0.7,
+// 17.06.2-ce or 28.0.0-rc.1. Suffixes are ignored and leading zeros
+// allowed, as engine versions are not strictly semantic versions.
+func parseEngineVersion(s string) (semver.Version, error) {
+	core, _, _ := strings.Cut(strings.TrimPrefix(s, "v"), "-")
+	core, _, _ = strings.Cut(core, "+")
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return semver.Version{}, fmt.Errorf("invalid engine version %q", s)
+	}
+	var nums [3]uint64
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return semver.Version{}, fmt.Errorf("invalid engine version %q", s)
+		}
+		nums[i] = n
+	}
+	return semver.Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}, nil
+}
+
+func checkVersion(constraint string, v semver.Version, msg string) error {
+	c, err := semver.ParseConstraint(constraint)
+	if err != nil {
+		return err
+	}
+	if !c.Check(v) {
+		return errors.New(msg)
+	}
+	return nil
+}
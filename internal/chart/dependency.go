@@ -0,0 +1,211 @@
+package chart
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/acebelowzero/tmpl/internal/semver"
+)
+
+const (
+	// TypeApplication charts render a stack.
+	TypeApplication = "application"
+	// TypeLibrary charts only export named templates and default values
+	// to the charts depending on them, and cannot be rendered themselves.
+	TypeLibrary = "library"
+
+	// DependenciesDir is the directory dependencies are vendored into,
+	// relative to the chart root.
+	DependenciesDir = "charts"
+	// fileScheme marks a dependency repository as a path relative to the
+	// chart.
+	fileScheme = "file://"
+)
+
+// Dependency is a library chart the chart uses, as listed in Chart.yaml.
+type Dependency struct {
+	Name string `yaml:"name" json:"name"`
+	// Version is a semver constraint the dependency version must satisfy.
+	Version string `yaml:"version,omitempty" json:"version,omitempty"`
+	// Repository is the URL or name of a configured repository, or a
+	// file:// path relative to the chart.
+	Repository string `yaml:"repository,omitempty" json:"repository,omitempty"`
+}
+
+// IsLocal reports whether the dependency is read from a path next to the
+// chart rather than from a repository.
+func (d Dependency) IsLocal() bool {
+	return strings.HasPrefix(d.Repository, fileScheme)
+}
+
+// LocalPath returns the directory of a local dependency, resolved against
+// the chart directory dir.
+func (d Dependency) LocalPath(dir string) string {
+	p := filepath.FromSlash(strings.TrimPrefix(d.Repository, fileScheme))
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+// IsLibrary reports whether the chart is a library chart.
+func (m *Metadata) IsLibrary() bool {
+	return m.Type == TypeLibrary
+}
+
+// Library is a resolved library chart dependency.
+type Library struct {
+	Dir      string
+	Metadata *Metadata
+}
+
+// DependencyDir returns the directory dep of the chart in dir is read
+// from: its vendored copy under charts/ or, for local dependencies that
+// are not vendored, the path they point to.
+func DependencyDir(dir string, dep Dependency) (string, error) {
+	vendored := filepath.Join(dir, DependenciesDir, dep.Name)
+	if _, err := os.Stat(filepath.Join(vendored, MetadataFile)); err == nil {
+		return vendored, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	if dep.IsLocal() {
+		local := dep.LocalPath(dir)
+		if _, err := os.Stat(filepath.Join(local, MetadataFile)); err != nil {
+			return "", fmt.Errorf("dependency %s: no chart at %s", dep.Name, local)
+		}
+		return local, nil
+	}
+	return "", fmt.Errorf("dependency %s is missing from %s, run 'tmpl dependency update'", dep.Name, filepath.Join(dir, DependenciesDir))
+}
+
+// Libraries resolves the library charts the chart in dir depends on,
+// directly or through other libraries. Dependencies come before the
+// charts using them, so merging in order lets users override what they
+// build on.
+func Libraries(dir string) ([]Library, error) {
+	meta, err := LoadMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	r := &resolver{seen: map[string]bool{}, active: map[string]bool{meta.Name: true}}
+	if err := r.resolve(dir, meta); err != nil {
+		return nil, err
+	}
+	return r.libs, nil
+}
+
+type resolver struct {
+	libs []Library
+	seen map[string]bool
+	// active holds the charts being resolved, to detect cycles.
+	active map[string]bool
+}
+
+func (r *resolver) resolve(dir string, meta *Metadata) error {
+	for _, dep := range meta.Dependencies {
+		if r.seen[dep.Name] {
+			continue
+		}
+		if r.active[dep.Name] {
+			return fmt.Errorf("chart %s: dependency cycle through %s", meta.Name, dep.Name)
+		}
+		depDir, err := DependencyDir(dir, dep)
+		if err != nil {
+			return fmt.Errorf("chart %s: %w", meta.Name, err)
+		}
+		depMeta, err := LoadMetadata(depDir)
+		if err != nil {
+			return fmt.Errorf("chart %s: dependency %s: %w", meta.Name, dep.Name, err)
+		}
+		if err := CheckDependency(dep, depMeta); err != nil {
+			return fmt.Errorf("chart %s: %w", meta.Name, err)
+		}
+		r.active[dep.Name] = true
+		if err := r.resolve(depDir, depMeta); err != nil {
+			return err
+		}
+		delete(r.active, dep.Name)
+		r.seen[dep.Name] = true
+		r.libs = append(r.libs, Library{Dir: depDir, Metadata: depMeta})
+	}
+	return nil
+}
+
+// CheckDependency reports whether meta, the Chart.yaml of a dependency,
+// is the library chart dep asks for.
+func CheckDependency(dep Dependency, meta *Metadata) error {
+	if meta.Name != dep.Name {
+		return fmt.Errorf("dependency %s: found chart %s", dep.Name, meta.Name)
+	}
+	if !meta.IsLibrary() {
+		return fmt.Errorf("dependency %s: not a library chart", dep.Name)
+	}
+	if dep.Version == "" {
+		return nil
+	}
+	c, err := semver.ParseConstraint(dep.Version)
+	if err != nil {
+		return fmt.Errorf("dependency %s: %w", dep.Name, err)
+	}
+	v, err := semver.Parse(meta.Version)
+	if err != nil {
+		return fmt.Errorf("dependency %s: %w", dep.Name, err)
+	}
+	if !c.Check(v) {
+		return fmt.Errorf("dependency %s: version %s does not match %q", dep.Name, meta.Version, dep.Version)
+	}
+	return nil
+}
+
+// Vendor copies the chart in src into the chart in dir as dependency dep,
+// replacing an earlier copy. Hidden files are left out, as when packaging.
+func Vendor(dir string, dep Dependency, src string) (*Metadata, error) {
+	data, err := archiveDir(src, dep.Name)
+	if err != nil {
+		return nil, err
+	}
+	return VendorArchive(dir, dep, data)
+}
+
+// VendorArchive extracts the packaged chart data into the chart in dir as
+// dependency dep, replacing an earlier copy once it is checked to be the
+// library dep asks for.
+func VendorArchive(dir string, dep Dependency, data []byte) (*Metadata, error) {
+	parent := filepath.Join(dir, DependenciesDir)
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return nil, fmt.Errorf("create %s: %w", parent, err)
+	}
+	// Extract next to the target so the final rename stays on one
+	// filesystem.
+	tmp, err := os.MkdirTemp(parent, ".tmpl-dependency-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	if _, err := Extract(data, tmp); err != nil {
+		return nil, fmt.Errorf("dependency %s: %w", dep.Name, err)
+	}
+	meta, err := LoadMetadata(tmp)
+	if err != nil {
+		return nil, fmt.Errorf("dependency %s: %w", dep.Name, err)
+	}
+	if err := CheckDependency(dep, meta); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmp, 0o755); err != nil {
+		return nil, err
+	}
+	target := filepath.Join(parent, dep.Name)
+	if err := os.RemoveAll(target); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return nil, fmt.Errorf("move dependency %s into place: %w", dep.Name, err)
+	}
+	return meta, nil
+}
@@ -0,0 +1,109 @@
+package chart
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StarterPlaceholder is replaced by the name of the new chart in every
+// file of a starter.
+const StarterPlaceholder = "<CHARTNAME>"
+
+// CreateFromStarter unpacks a starter, a packaged chart used as a
+// skeleton, into dir as a chart called name. The placeholder is replaced
+// in all files and the name of Chart.yaml is set. Existing files of dir
+// are never overwritten.
+func CreateFromStarter(data []byte, dir, name string) error {
+	tmp, err := os.MkdirTemp("", "tmpl-starter-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if _, err := Extract(data, tmp); err != nil {
+		return fmt.Errorf("unpack starter: %w", err)
+	}
+
+	err = filepath.WalkDir(tmp, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(tmp, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		contents = bytes.ReplaceAll(contents, []byte(StarterPlaceholder), []byte(name))
+		if rel == MetadataFile {
+			if contents, err = setField(contents, "name", name); err != nil {
+				return fmt.Errorf("starter %s: %w", MetadataFile, err)
+			}
+		}
+
+		target := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return fmt.Errorf("create directories for %s: %w", rel, err)
+		}
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+		if err != nil {
+			return fmt.Errorf("create %s: %w", rel, err)
+		}
+		if _, err := f.Write(contents); err != nil {
+			f.Close()
+			return fmt.Errorf("write %s: %w", rel, err)
+		}
+		return f.Close()
+	})
+	if err != nil {
+		return err
+	}
+	_, err = LoadMetadata(dir)
+	return err
+}
+
+// setField sets a top-level field of Chart.yaml, keeping its other fields
+// and comments.
+func setField(data []byte, key, value string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("expected a mapping")
+	}
+	root := doc.Content[0]
+	found := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == key {
+			root.Content[i+1].SetString(value)
+			found = true
+		}
+	}
+	if !found {
+		root.Content = append(root.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: key},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: value})
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
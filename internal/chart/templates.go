@@ -0,0 +1,55 @@
+package chart
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DefaultTemplateExtension is the suffix of templates unless Chart.yaml
+// sets templateExtensions.
+const DefaultTemplateExtension = ".tmpl"
+
+// helperExtension is the suffix of helpers, which templateExtensions
+// cannot claim.
+const helperExtension = ".tpl"
+
+// IsTemplate reports whether the file name under templates/ is executed
+// as a template, by its extension.
+func (m *Metadata) IsTemplate(name string) bool {
+	if len(m.TemplateExtensions) == 0 {
+		return strings.HasSuffix(name, DefaultTemplateExtension)
+	}
+	for _, ext := range m.TemplateExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRawCopy reports whether the file at rel, a slash-separated path
+// relative to templates/, is written to the stack as it is.
+func (m *Metadata) IsRawCopy(rel string) bool {
+	for _, pattern := range m.RawCopy {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTemplateFiles validates templateExtensions and rawCopy.
+func (m *Metadata) checkTemplateFiles() error {
+	for i, ext := range m.TemplateExtensions {
+		if !strings.HasPrefix(ext, ".") || ext == "." || ext == helperExtension {
+			return fmt.Errorf("templateExtensions[%d]: invalid extension %q, must start with a dot and not be %s, the helper extension", i, ext, helperExtension)
+		}
+	}
+	for i, pattern := range m.RawCopy {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("rawCopy[%d]: invalid pattern %q: %w", i, pattern, err)
+		}
+	}
+	return nil
+}
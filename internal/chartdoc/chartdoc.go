@@ -0,0 +1,159 @@
+// Package chartdoc generates the documentation of a chart: a reference of
+// its values, from values.yaml and the values schema, and an inventory of
+// its templates.
+package chartdoc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/render"
+	"github.com/acebelowzero/tmpl/internal/schema"
+	"github.com/acebelowzero/tmpl/internal/values"
+)
+
+// Doc is the documentation of a chart.
+type Doc struct {
+	Chart     *chart.Metadata
+	Values    []Value
+	Inventory *render.Inventory
+}
+
+// Value documents a values key.
+type Value struct {
+	// Key is the path of the key, as written after .Values.
+	Key  string
+	Type string
+	// Default is the value of values.yaml in JSON, empty when it has
+	// none.
+	Default     string
+	Description string
+	// Required is set for keys the schema of the chart requires.
+	Required bool
+	// Enum lists the values the schema allows, in JSON.
+	Enum []string
+}
+
+// Load documents the chart in dir. The values schema of the chart is
+// optional.
+func Load(dir string) (*Doc, error) {
+	meta, err := chart.LoadMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "values.yaml"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read values: %w", err)
+	}
+	var s *schema.Schema
+	if _, err := os.Stat(filepath.Join(dir, schema.FileName)); err == nil {
+		if s, err = schema.Load(dir); err != nil {
+			return nil, err
+		}
+	}
+	vals, err := Values(data, s)
+	if err != nil {
+		return nil, err
+	}
+	r, err := render.New(render.Config{ChartPath: dir})
+	if err != nil {
+		return nil, err
+	}
+	inv, err := r.Inventory()
+	if err != nil {
+		return nil, err
+	}
+	return &Doc{Chart: meta, Values: vals, Inventory: inv}, nil
+}
+
+// Values documents the leaf keys of data, the values.yaml of a chart, and
+// of s, its schema, which may be nil. Types and descriptions of the
+// schema take precedence over those inferred from the defaults and their
+// comments. Objects without properties and lists are leaves. The keys are
+// sorted.
+func Values(data []byte, s *schema.Schema) ([]Value, error) {
+	inferred, err := schema.Generate(data, schema.GenerateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defaults := map[string]any{}
+	if err := yaml.Unmarshal(data, &defaults); err != nil {
+		return nil, fmt.Errorf("decode values: %w", err)
+	}
+	var vals []Value
+	walk(nil, inferred, s, false, defaults, &vals)
+	sort.Slice(vals, func(i, j int) bool { return vals[i].Key < vals[j].Key })
+	return vals, nil
+}
+
+// walk documents the key at path, described by inferred and declared, the
+// schemas of the defaults and of the chart, either of which may be nil.
+func walk(path []string, inferred, declared *schema.Schema, required bool, defaults map[string]any, vals *[]Value) {
+	props := map[string]bool{}
+	for _, s := range []*schema.Schema{inferred, declared} {
+		if s != nil {
+			for key := range s.Properties {
+				props[key] = true
+			}
+		}
+	}
+	if len(props) > 0 {
+		var requiredKeys []string
+		if declared != nil {
+			requiredKeys = declared.Required
+		}
+		for key := range props {
+			walk(append(slices.Clip(path), key), property(inferred, key), property(declared, key), slices.Contains(requiredKeys, key), defaults, vals)
+		}
+		return
+	}
+	if len(path) == 0 {
+		return
+	}
+
+	v := Value{Key: values.JoinPath(path), Required: required}
+	for _, s := range []*schema.Schema{inferred, declared} {
+		if s == nil {
+			continue
+		}
+		if len(s.Type) > 0 {
+			v.Type = strings.Join(s.Type, " or ")
+		}
+		if s.Description != "" {
+			v.Description = s.Description
+		}
+	}
+	if declared != nil {
+		for _, e := range declared.Enum {
+			v.Enum = append(v.Enum, encode(e))
+		}
+	}
+	if def, ok := values.Lookup(defaults, path); ok {
+		v.Default = encode(def)
+	}
+	*vals = append(*vals, v)
+}
+
+func property(s *schema.Schema, key string) *schema.Schema {
+	if s == nil {
+		return nil
+	}
+	return s.Properties[key]
+}
+
+// encode formats a value as compact JSON.
+func encode(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,130 @@
+package chartdoc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Header starts generated documents, so they can be told from documents
+// written by hand.
+const Header = "<!-- Generated by tmpl docs gen from the chart; do not edit. -->"
+
+// WriteMarkdown writes d as a Markdown document.
+func (d *Doc) WriteMarkdown(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	meta := d.Chart
+	fmt.Fprintf(bw, "%s\n\n# %s\n\n", Header, meta.Name)
+	if meta.Description != "" {
+		fmt.Fprintf(bw, "%s\n\n", meta.Description)
+	}
+	facts := []string{"**Version:** " + meta.Version}
+	if meta.AppVersion != "" {
+		facts = append(facts, "**App version:** "+meta.AppVersion)
+	}
+	if meta.IsLibrary() {
+		facts = append(facts, "**Type:** library")
+	}
+	if meta.TmplVersion != "" {
+		facts = append(facts, "**tmpl:** "+meta.TmplVersion)
+	}
+	fmt.Fprintf(bw, "%s\n", strings.Join(facts, " · "))
+	if meta.Home != "" {
+		fmt.Fprintf(bw, "\nHome: %s\n", meta.Home)
+	}
+
+	if len(meta.Dependencies) > 0 {
+		fmt.Fprint(bw, "\n## Dependencies\n\n")
+		table(bw, []string{"Name", "Version", "Repository"}, func(row func(...string)) {
+			for _, dep := range meta.Dependencies {
+				row(dep.Name, dep.Version, dep.Repository)
+			}
+		})
+	}
+
+	fmt.Fprint(bw, "\n## Values\n\n")
+	if len(d.Values) == 0 {
+		fmt.Fprint(bw, "The chart has no values.\n")
+	} else {
+		table(bw, []string{"Key", "Type", "Default", "Description"}, func(row func(...string)) {
+			for _, v := range d.Values {
+				desc := v.Description
+				if len(v.Enum) > 0 {
+					desc = strings.TrimSpace(desc + " One of " + strings.Join(v.Enum, ", ") + ".")
+				}
+				if v.Required {
+					desc = strings.TrimSpace("**Required.** " + desc)
+				}
+				row(code(v.Key), v.Type, code(v.Default), desc)
+			}
+		})
+	}
+
+	inv := d.Inventory
+	if len(inv.Templates) > 0 {
+		fmt.Fprint(bw, "\n## Templates\n\n")
+		table(bw, []string{"Template", "Output", "Description"}, func(row func(...string)) {
+			for _, t := range inv.Templates {
+				output := "stack"
+				if t.Generate != "" {
+					output = "a document per item of " + code(t.Generate)
+				}
+				row(code(t.Name), output, t.Description)
+			}
+		})
+	}
+	if len(inv.Hooks) > 0 {
+		fmt.Fprint(bw, "\n## Hooks\n\n")
+		table(bw, []string{"Template", "Events"}, func(row func(...string)) {
+			for _, h := range inv.Hooks {
+				row(code(h.Name), h.Events)
+			}
+		})
+	}
+	if inv.Notes != "" {
+		fmt.Fprintf(bw, "\n%s is shown after deploys.\n", code(inv.Notes))
+	}
+	if len(inv.Defines) > 0 {
+		fmt.Fprint(bw, "\n## Named templates\n\n")
+		table(bw, []string{"Name", "Defined in", "Description"}, func(row func(...string)) {
+			for _, def := range inv.Defines {
+				row(code(def.Name), code(def.File), def.Description)
+			}
+		})
+	}
+	return bw.Flush()
+}
+
+// table writes a Markdown table with the rows added by rows.
+func table(w io.Writer, header []string, rows func(row func(...string))) {
+	line := func(cells ...string) {
+		for i, c := range cells {
+			cells[i] = escape(c)
+		}
+		fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | "))
+	}
+	line(header...)
+	sep := make([]string, len(header))
+	for i := range sep {
+		sep[i] = "---"
+	}
+	fmt.Fprintf(w, "|%s|\n", strings.Join(sep, "|"))
+	rows(line)
+}
+
+// escape keeps a cell on its line and its pipes from ending it.
+func escape(cell string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(cell, "\n", " "), "|", `\|`)
+}
+
+// code formats s as inline code, empty strings as nothing.
+func code(s string) string {
+	if s == "" {
+		return ""
+	}
+	if strings.Contains(s, "`") {
+		return "`` " + s + " ``"
+	}
+	return "`" + s + "`"
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/deploy"
+	"github.com/acebelowzero/tmpl/internal/release"
+)
+
+func newAdoptCmd() *cobra.Command {
+	var store string
+	var dryRun bool
+	var dockerOpts dockerOptions
+	var lockTimeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "adopt STACK",
+		Short: "Take ownership of a stack deployed without tmpl",
+		Long: `Label the services, configs and secrets of a stack deployed with
+'docker stack deploy' as owned by the release of the same name, so that
+apply may update them. Objects owned by another release are refused.
+
+When the stack has no recorded history a first revision is recorded
+without a stored stack; it cannot be rolled back to.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runAdopt(cmd, namespaced(cmd, args[0]), store, dryRun, lockTimeout, &dockerOpts)
+		},
+	}
+
+	addReleaseStoreFlag(cmd, &store)
+	addLockFlag(cmd, &lockTimeout)
+	addDockerFlags(cmd, &dockerOpts)
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the objects that would be adopted without changing them")
+
+	return cmd
+}
+
+func runAdopt(cmd *cobra.Command, name, location string, dryRun bool, lockTimeout time.Duration, dockerOpts *dockerOptions) error {
+	client, err := newDockerClient(dockerOpts)
+	if err != nil {
+		return err
+	}
+	store, err := openReleaseStore(cmd, location, client)
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		unlock, err := lockRelease(cmd, store, name, "adopt", lockTimeout)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	changes, err := deploy.New(client).Adopt(cmd.Context(), name, dryRun)
+	w := cmd.OutOrStdout()
+	adopted := 0
+	for _, c := range changes {
+		if c.Action != deploy.ActionUpdate {
+			continue
+		}
+		adopted++
+		fmt.Fprintf(w, "~ adopt %s %s\n", c.Kind, c.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return fmt.Errorf("stack %s has no services, configs or secrets", name)
+	}
+	if dryRun {
+		fmt.Fprintf(w, "Stack %s: %d objects would be adopted\n", name, adopted)
+		return nil
+	}
+
+	if _, err := release.Latest(cmd.Context(), store, name); errors.Is(err, release.ErrNotFound) {
+		rel := &release.Release{Name: name, Status: release.StatusDeployed, Description: "Adopted"}
+		if err := release.Append(cmd.Context(), store, rel); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Stack %s adopted: %d objects labelled\n", name, adopted)
+	return nil
+}
@@ -0,0 +1,455 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/deploy"
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/hook"
+	"github.com/acebelowzero/tmpl/internal/logx"
+	"github.com/acebelowzero/tmpl/internal/release"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// applyOptions holds the flags of the apply command.
+type applyOptions struct {
+	valuesFiles      []string
+	envFiles         []string
+	stackName        string
+	render           renderFlags
+	planFile         string
+	store            string
+	wait             bool
+	timeout          time.Duration
+	noHooks          bool
+	prune            bool
+	docker           dockerOptions
+	update           updateOptions
+	services         deploy.Selector
+	skipImageCheck   bool
+	autoApprove      bool
+	allowDestructive bool
+	lockTimeout      time.Duration
+	historyMax       int
+	description      string
+	annotations      map[string]string
+	format           string
+	// events receives the progress of --output json, nil for text.
+	events *eventWriter
+}
+
+func newApplyCmd() *cobra.Command {
+	opts := &applyOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "apply [[RELEASE] CHART]",
+		Short: "Render a chart and deploy it as a swarm stack",
+		Long: `Render a chart and deploy it to Docker Swarm through the Engine API.
+
+The release names the stack and its record in the release store, and
+defaults to the chart name. The planned changes are shown and confirmed
+before the swarm is modified, unless --auto-approve is set or a plan saved
+by 'tmpl plan --out' is applied with --plan. Objects no longer in the chart
+are only removed with --prune.
+
+docs/deploying.md describes engines, ownership, image checks, hooks,
+tmplfiles and notifications.`,
+		Example: `  tmpl apply ./charts/web -f values-prod.yaml --wait
+  tmpl apply prod-blue ./charts/web -f values-prod.yaml
+  tmpl apply --only web,worker --description "Deploy v1.4.2 hotfix" --annotation ticket=OPS-123
+  tmpl apply -f tmplfile.yaml --wait`,
+		Args:              cobra.MaximumNArgs(2),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chartDir, err := releaseArgs(args, &opts.stackName)
+			if err != nil {
+				return err
+			}
+			if opts.planFile != "" && opts.stackName != "" {
+				return withExit(ExitConfig, errors.New("a release name or --stack cannot be combined with --plan"))
+			}
+			if opts.planFile != "" && opts.prune {
+				return withExit(ExitConfig, errors.New("--prune cannot be combined with --plan; pass it to 'tmpl plan'"))
+			}
+			if opts.planFile != "" && updateFlagsChanged(cmd) {
+				return withExit(ExitConfig, errors.New("update policy flags cannot be combined with --plan; pass them to 'tmpl plan'"))
+			}
+			if opts.planFile != "" && opts.render.pinDigests {
+				return withExit(ExitConfig, errors.New("--pin-digests cannot be combined with --plan; pass it to 'tmpl plan'"))
+			}
+			if opts.planFile != "" && !opts.services.IsZero() {
+				return withExit(ExitConfig, errors.New("--only and --exclude cannot be combined with --plan; pass them to 'tmpl plan'"))
+			}
+			if err := checkSelector(opts.services, opts.prune); err != nil {
+				return err
+			}
+			if _, ok := opts.annotations[""]; ok {
+				return withExit(ExitConfig, errors.New("--annotation needs a KEY=VALUE pair with a key"))
+			}
+			switch opts.format {
+			case "text":
+			case "json":
+				opts.events = newEventWriter(cmd)
+			default:
+				return withExit(ExitConfig, fmt.Errorf("unknown output format %q", opts.format))
+			}
+			if files := tmplfiles(opts.valuesFiles); len(files) > 0 {
+				if len(files) != len(opts.valuesFiles) || len(args) > 0 {
+					return withExit(ExitConfig, errors.New("a tmplfile cannot be combined with a release, a chart or values files"))
+				}
+				if opts.planFile != "" || opts.stackName != "" || !opts.services.IsZero() {
+					return withExit(ExitConfig, errors.New("--plan, --stack, --only and --exclude cannot be combined with a tmplfile"))
+				}
+				return withExit(ExitApply, runApplyFiles(cmd, files, opts))
+			}
+			return withExit(ExitApply, runApply(cmd, chartDir, opts))
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&opts.valuesFiles, "values", "f", nil, "Values files")
+	cmd.Flags().StringSliceVar(&opts.envFiles, "env-file", nil, "Environment files for value expansion")
+	cmd.Flags().StringVar(&opts.stackName, "stack", "", "Release and stack name (defaults to the chart name)")
+	addRenderFlags(cmd, &opts.render)
+	cmd.Flags().StringVar(&opts.planFile, "plan", "", "Apply a plan saved with 'tmpl plan --out' as planned; plans creating secrets need the chart and values again")
+	cmd.Flags().BoolVar(&opts.wait, "wait", false, "Wait until all services have converged, and stayed healthy for those labelled tmpl.wait: healthy")
+	cmd.Flags().DurationVar(&opts.timeout, "timeout", 5*time.Minute, "How long --wait waits for convergence")
+	cmd.Flags().BoolVar(&opts.noHooks, "no-hooks", false, "Do not run chart hooks")
+	cmd.Flags().BoolVar(&opts.prune, "prune", false, "Remove tmpl-owned objects that are no longer in the chart")
+	cmd.Flags().BoolVar(&opts.skipImageCheck, "skip-image-check", false, "Do not check that service images exist for the platforms of the swarm nodes")
+	cmd.Flags().BoolVar(&opts.autoApprove, "auto-approve", false, "Apply without asking for confirmation")
+	cmd.Flags().BoolVar(&opts.allowDestructive, "allow-destructive", false, "Allow removing services that mount named volumes")
+	cmd.Flags().StringVar(&opts.description, "description", "", "Note recorded with the revision and shown in history, e.g. \"Deploy v1.4.2 hotfix\"")
+	cmd.Flags().StringToStringVar(&opts.annotations, "annotation", nil, "KEY=VALUE recorded with the revision and its audit record, e.g. ticket=OPS-123 (repeatable)")
+	cmd.Flags().StringVarP(&opts.format, "output", "o", "text", "Output format: text, or json for a stream of progress events")
+	addReleaseStoreFlag(cmd, &opts.store)
+	addLockFlag(cmd, &opts.lockTimeout)
+	addHistoryMaxFlag(cmd, &opts.historyMax)
+	addUpdateFlags(cmd, &opts.update)
+	addSelectorFlags(cmd, &opts.services)
+	addDockerFlags(cmd, &opts.docker)
+
+	return cmd
+}
+
+func runApply(cmd *cobra.Command, chartDir string, opts *applyOptions) error {
+	client, err := newDockerClient(&opts.docker)
+	if err != nil {
+		return err
+	}
+	return applyRelease(cmd, client, chartDir, opts)
+}
+
+// checkEngineVersion fails when the engineVersion constraint of the chart
+// in chartDir excludes the engine of client.
+func checkEngineVersion(cmd *cobra.Command, client *docker.Client, chartDir string) error {
+	meta, err := chart.LoadMetadata(chartDir)
+	if err != nil {
+		return err
+	}
+	if meta.EngineVersion == "" {
+		return nil
+	}
+	info, err := client.Info(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("engine info: %w", err)
+	}
+	return meta.CheckEngineVersion(info.ServerVersion)
+}
+
+// applyRelease deploys chartDir, or the saved plan of opts, through client
+// and records the release.
+func applyRelease(cmd *cobra.Command, client *docker.Client, chartDir string, opts *applyOptions) (err error) {
+	started := time.Now()
+	var name string
+	var rel *release.Release
+	var audited *auditEntry
+	var notified *notification
+	defer func() {
+		opts.events.finished(name, stepApply, "", started, err)
+		audited.finish(name, rel, err)
+		notified.finish(name, rel, err)
+	}()
+	if audited, err = startAudit(cmd, "apply"); err != nil {
+		return err
+	}
+	if notified, err = startNotification(cmd, "apply"); err != nil {
+		return err
+	}
+	store, err := openReleaseStore(cmd, opts.store, client)
+	if err != nil {
+		return err
+	}
+	deployer := deploy.New(client)
+	if opts.render.capabilities, err = swarmCapabilities(cmd.Context(), client); err != nil {
+		return err
+	}
+
+	var applied *deploy.Result
+	if opts.planFile != "" {
+		p, perr := deploy.ReadPlan(opts.planFile)
+		if perr != nil {
+			return perr
+		}
+		name = p.Stack
+		opts.events.observe(deployer, name)
+		unlock, lerr := lockRelease(cmd, store, p.Stack, "apply", opts.lockTimeout)
+		if lerr != nil {
+			return lerr
+		}
+		defer unlock()
+		rel, applied, err = applySavedPlan(cmd, deployer, p, chartDir, opts, notified)
+	} else {
+		built, berr := buildStack(cmd, chartDir, opts.valuesFiles, opts.envFiles, opts.stackName, opts.render)
+		if berr != nil {
+			return berr
+		}
+		if err := checkEngineVersion(cmd, client, chartDir); err != nil {
+			return err
+		}
+		updates, uerr := opts.update.policy(cmd)
+		if uerr != nil {
+			return uerr
+		}
+		built.stack.SetUpdatePolicy(updates)
+		if rel, err = newRelease(built); err != nil {
+			return err
+		}
+		annotateRelease(rel, opts)
+		name = rel.Name
+		opts.events.observe(deployer, name)
+		unlock, lerr := lockRelease(cmd, store, rel.Name, "apply", opts.lockTimeout)
+		if lerr != nil {
+			return lerr
+		}
+		defer unlock()
+		if err := release.LabelOwner(cmd.Context(), store, rel); err != nil {
+			return err
+		}
+		planned := time.Now()
+		p, perr := deployer.Plan(cmd.Context(), built.stack, deploy.Options{Prune: opts.prune, Services: opts.services, CheckImages: !opts.skipImageCheck})
+		opts.events.planned(name, p, planned, perr)
+		if perr != nil {
+			return perr
+		}
+		if len(p.Skipped) > 0 {
+			rel.Stack = p.Desired
+		}
+		if err := checkDestructive(p, opts.allowDestructive); err != nil {
+			return err
+		}
+		if err := confirmPlan(cmd, p, opts.autoApprove); err != nil {
+			return err
+		}
+		notified.start(rel, p)
+		if !opts.noHooks {
+			if err := runApplyHooks(cmd, deployer, rel, hook.PreApply, opts.events); err != nil {
+				return err
+			}
+		}
+		// The swarm is planned again, so changes made while the plan
+		// was shown are refused rather than overwritten.
+		ctx, stop := cmd.Context(), func() {}
+		if opts.events == nil {
+			ctx, stop = startProgress(cmd, deployer, p)
+		}
+		applied, err = deployer.ApplyPlan(ctx, p, built.stack.Secrets)
+		stop()
+	}
+	if rel == nil {
+		return err
+	}
+	recorded := time.Now()
+	if err := recordRelease(cmd, store, rel, applied, err, opts.historyMax); err != nil {
+		return err
+	}
+	opts.events.emit(applyEvent{Stack: name, Step: stepRecord, Revision: rel.Revision, DurationMS: time.Since(recorded).Milliseconds()})
+	if opts.wait {
+		waited := time.Now()
+		err := waitForRelease(cmd, deployer, store, rel, started, opts.timeout)
+		opts.events.finished(name, stepWait, "", waited, err)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.noHooks {
+		return nil
+	}
+	hooked := time.Now()
+	err = runPostHooks(cmd, deployer, store, rel, hook.PostApply)
+	opts.events.finished(name, stepHook, string(hook.PostApply), hooked, err)
+	return err
+}
+
+// annotateRelease sets the description and annotations given on the
+// command line on rel.
+func annotateRelease(rel *release.Release, opts *applyOptions) {
+	if opts.description != "" {
+		rel.Description = opts.description
+	}
+	for key, value := range opts.annotations {
+		if rel.Annotations == nil {
+			rel.Annotations = map[string]string{}
+		}
+		rel.Annotations[key] = value
+	}
+}
+
+// runApplyHooks runs the hooks of rel registered for event and reports
+// the step to events.
+func runApplyHooks(cmd *cobra.Command, deployer *deploy.Deployer, rel *release.Release, event hook.Event, events *eventWriter) error {
+	started := time.Now()
+	err := runHooks(cmd, deployer, rel, event)
+	events.finished(rel.Name, stepHook, string(event), started, err)
+	return err
+}
+
+// recordRelease prints the apply result and stores rel as deployed, then
+// prunes the history beyond historyMax revisions. When applyErr is set the
+// revision is stored as failed, provided the attempt changed the swarm, and
+// applyErr is returned.
+func recordRelease(cmd *cobra.Command, store release.Store, rel *release.Release, applied *deploy.Result, applyErr error, historyMax int) error {
+	name := rel.Name
+	warnUnencrypted(cmd, store, rel)
+	if werr := writeApplyResult(cmd, name, applied); werr != nil && applyErr == nil {
+		applyErr = werr
+	}
+	if applyErr != nil {
+		changed := applied != nil && applied.Count(deploy.ActionCreate)+applied.Count(deploy.ActionUpdate) > 0
+		if changed {
+			// Record the partial apply even when the command was
+			// cancelled or timed out.
+			rel.Status = release.StatusFailed
+			if rel.Description != "" {
+				rel.Description += ": " + applyErr.Error()
+			} else {
+				rel.Description = applyErr.Error()
+			}
+			if err := release.Append(context.WithoutCancel(cmd.Context()), store, rel); err != nil {
+				logx.FromContext(cmd.Context()).Warn("could not record failed release", "stack", name, "error", err)
+			} else {
+				release.PruneHistory(cmd.Context(), store, name, historyMax)
+			}
+		}
+		err := fmt.Errorf("apply stack %s: %w", name, applyErr)
+		if changed || (applied != nil && applied.Count(deploy.ActionDelete) > 0) {
+			return withExit(ExitPartialApply, err)
+		}
+		return withExit(ExitApply, err)
+	}
+
+	rel.Status = release.StatusDeployed
+	if err := release.Append(cmd.Context(), store, rel); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Recorded revision %d of %s\n", rel.Revision, name)
+	release.PruneHistory(cmd.Context(), store, name, historyMax)
+	if rel.Notes != "" {
+		fmt.Fprintf(cmd.OutOrStdout(), "\nNOTES:\n%s\n", strings.TrimRight(rel.Notes, "\n"))
+	}
+	return nil
+}
+
+// waitForRelease waits for the services of rel to converge and marks the
+// revision failed when they do not.
+func waitForRelease(cmd *cobra.Command, deployer *deploy.Deployer, store release.Store, rel *release.Release, since time.Time, timeout time.Duration) error {
+	fmt.Fprintf(cmd.OutOrStdout(), "Waiting up to %s for services to converge\n", timeout)
+	ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+	defer cancel()
+	if err := deployer.Wait(ctx, rel.Stack.ServiceNames(), deploy.WaitOptions{Since: since}); err != nil {
+		if serr := store.SetStatus(context.WithoutCancel(cmd.Context()), rel.Name, rel.Revision, release.StatusFailed); serr != nil {
+			logx.FromContext(cmd.Context()).Warn("could not mark release failed", "stack", rel.Name, "error", serr)
+		}
+		return fmt.Errorf("stack %s: %w", rel.Name, err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Stack %s converged\n", rel.Name)
+	return nil
+}
+
+// applySavedPlan executes a plan saved by 'tmpl plan --out', re-rendering
+// the chart only to recover the content of secrets the plan creates.
+func applySavedPlan(cmd *cobra.Command, deployer *deploy.Deployer, p *deploy.Plan, chartDir string, opts *applyOptions, notified *notification) (*release.Release, *deploy.Result, error) {
+	opts.events.planned(p.Stack, p, time.Now(), nil)
+	if err := checkDestructive(p, opts.allowDestructive); err != nil {
+		return nil, nil, err
+	}
+	// The plan was made in a namespace, which prefixes its stack name.
+	var namespace string
+	if p.Release != nil {
+		namespace = p.Release.Namespace
+	}
+	if current := globalOptions(cmd.Context()).Namespace; current != namespace {
+		return nil, nil, withExit(ExitConfig, fmt.Errorf("plan %s was made in namespace %q, not %q", opts.planFile, namespace, current))
+	}
+	var secrets map[string]docker.ObjectSpec
+	var built *builtStack
+	if p.NeedsSecrets() {
+		var err error
+		name := strings.TrimPrefix(p.Stack, stack.Namespaced(namespace, ""))
+		if built, err = buildStack(cmd, chartDir, opts.valuesFiles, opts.envFiles, name, opts.render); err != nil {
+			return nil, nil, fmt.Errorf("render secrets for plan: %w", err)
+		}
+		secrets = built.stack.Secrets
+	}
+	if !p.HasChanges() {
+		fmt.Fprintln(cmd.OutOrStdout(), "Plan has no changes")
+	}
+	rel := &release.Release{Name: p.Stack}
+	if p.Release != nil {
+		copied := *p.Release
+		rel = &copied
+	}
+	annotateRelease(rel, opts)
+	notified.start(rel, p)
+	if !opts.noHooks {
+		if err := runApplyHooks(cmd, deployer, rel, hook.PreApply, opts.events); err != nil {
+			return nil, nil, err
+		}
+	}
+	ctx, stop := cmd.Context(), func() {}
+	if opts.events == nil {
+		ctx, stop = startProgress(cmd, deployer, p)
+	}
+	applied, err := deployer.ApplyPlan(ctx, p, secrets)
+	stop()
+	rel.Stack = p.Desired
+	// The plan holds the values with the content decrypted from sops files
+	// redacted, which rollback decrypts again; a chart rendered for the
+	// secrets has it whole.
+	if built != nil {
+		rel.Values, rel.UserValues, rel.Encrypted = built.values, built.userValues, built.encrypted
+	}
+	return rel, applied, err
+}
+
+func writeApplyResult(cmd *cobra.Command, stackName string, result *deploy.Result) error {
+	out := cmd.OutOrStdout()
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, c := range result.Changes {
+		if c.Action == deploy.ActionUnchanged {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Action, c.Kind, c.Name)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, c := range result.Changes {
+		for _, w := range c.Warnings {
+			fmt.Fprintf(cmd.ErrOrStderr(), "warning: %s %s: %s\n", c.Kind, c.Name, w)
+		}
+	}
+	summary := fmt.Sprintf("Stack %s: %d created, %d updated", stackName, result.Count(deploy.ActionCreate), result.Count(deploy.ActionUpdate))
+	if removed := result.Count(deploy.ActionDelete); removed > 0 {
+		summary += fmt.Sprintf(", %d removed", removed)
+	}
+	_, err := fmt.Fprintf(out, "%s, %d unchanged\n", summary, result.Count(deploy.ActionUnchanged))
+	return err
+}
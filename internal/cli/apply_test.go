@@ -0,0 +1,246 @@
+package cli
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/acebelowzero/tmpl/internal/release"
+)
+
+func TestApplySavedPlanThenRollBack(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the sops stand-in is a shell script")
+	}
+	engine := newFakeEngine()
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	// The sops stand-in decrypts a file by printing it.
+	bin := t.TempDir()
+	writeFiles(t, bin, map[string]string{"sops": "#!/bin/sh\nfor f; do :; done\ncat \"$f\"\n"})
+	if err := os.Chmod(filepath.Join(bin, "sops"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("DOCKER_HOST", strings.Replace(server.URL, "http://", "tcp://", 1))
+
+	dir := t.TempDir()
+	chartDir := filepath.Join(dir, "app")
+	writeFiles(t, chartDir, map[string]string{
+		"Chart.yaml":       "apiVersion: v1\nname: app\nversion: 0.1.0\n",
+		"values.yaml":      "image: nginx:1\npassword: first.enc\n",
+		"first.enc":        "first",
+		"templates/s.tmpl": "services:\n  web:\n    image: {{ .Values.image }}\n    secrets: [db]\nsecrets:\n  db:\n    content: {{ .Values.password | quote }}\n",
+	})
+	writeFiles(t, dir, map[string]string{
+		"update.yaml": "image: nginx:2\n",
+		"second.yaml": "image: nginx:2\npassword: second\n",
+	})
+	storeURL := "file://" + filepath.Join(dir, "releases")
+	planFile := filepath.Join(dir, "plan.json")
+	common := []string{"--release-store", storeURL, "--skip-image-check", "--log-level", "error"}
+
+	runCommand(t, append([]string{"apply", chartDir, "--auto-approve"}, common...)...)
+	// The saved plan only updates the image: apply does not render the
+	// chart again for the secret, the values come from the plan.
+	runCommand(t, append([]string{"plan", chartDir, "-f", filepath.Join(dir, "update.yaml"), "--out", planFile}, common...)...)
+	runCommand(t, append([]string{"apply", chartDir, "--plan", planFile, "--auto-approve"}, common...)...)
+
+	store, err := release.New(context.Background(), release.Config{Location: storeURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	planned, err := store.Get(context.Background(), "app", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if planned.Values["image"] != "nginx:2" {
+		t.Fatalf("revision 2 values = %v, want those of the plan", planned.Values)
+	}
+	if _, ok := planned.Encrypted["password"]; !ok {
+		t.Fatalf("revision 2 encrypted = %v, want password", planned.Encrypted)
+	}
+
+	runCommand(t, append([]string{"apply", chartDir, "-f", filepath.Join(dir, "second.yaml"), "--auto-approve"}, common...)...)
+	if got := engine.lastSecret(); got != "second" {
+		t.Fatalf("secret after the third apply = %q, want %q", got, "second")
+	}
+	runCommand(t, "rollback", "app", "2", "--release-store", storeURL, "--log-level", "error")
+	if got := engine.lastSecret(); got != "first" {
+		t.Errorf("secret after rolling back = %q, want %q", got, "first")
+	}
+}
+
+func runCommand(t *testing.T, args ...string) {
+	t.Helper()
+	cmd := NewRootCmd(context.Background(), nil)
+	cmd.SetArgs(args)
+	cmd.SetOut(io.Discard)
+	var stderr strings.Builder
+	cmd.SetErr(&stderr)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("tmpl %s: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fakeEngine is a single node swarm manager that keeps the services,
+// networks, configs and secrets created through it.
+type fakeEngine struct {
+	mu      sync.Mutex
+	next    int
+	objects map[string]map[string]map[string]any
+	secret  string
+}
+
+func newFakeEngine() *fakeEngine {
+	return &fakeEngine{objects: map[string]map[string]map[string]any{
+		"services": {}, "networks": {}, "configs": {}, "secrets": {},
+	}}
+}
+
+// lastSecret returns the content of the secret created last.
+func (e *fakeEngine) lastSecret() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.secret
+}
+
+func (e *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	segs := strings.FieldsFunc(r.URL.Path, func(c rune) bool { return c == '/' })
+	if len(segs) > 0 && strings.HasPrefix(segs[0], "v1") {
+		segs = segs[1:]
+	}
+	path := strings.Join(segs, "/")
+	reply := func(code int, body any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(body)
+	}
+	switch {
+	case r.Method == http.MethodGet && path == "_ping":
+		reply(http.StatusOK, "OK")
+	case r.Method == http.MethodGet && path == "version":
+		reply(http.StatusOK, map[string]any{"Version": "27.0.0", "ApiVersion": "1.45"})
+	case r.Method == http.MethodGet && path == "info":
+		reply(http.StatusOK, map[string]any{"ServerVersion": "27.0.0", "Swarm": map[string]any{"LocalNodeState": "active", "ControlAvailable": true, "NodeID": "n1"}})
+	case r.Method == http.MethodGet && path == "nodes":
+		reply(http.StatusOK, []any{map[string]any{
+			"ID":     "n1",
+			"Spec":   map[string]any{"Role": "manager", "Availability": "active"},
+			"Status": map[string]any{"State": "ready"},
+			"Description": map[string]any{
+				"Hostname":  "node",
+				"Platform":  map[string]any{"OS": "linux", "Architecture": "x86_64"},
+				"Resources": map[string]any{"NanoCPUs": 2000000000, "MemoryBytes": 4 << 30},
+				"Engine":    map[string]any{"EngineVersion": "27.0.0"},
+			},
+		}})
+	case r.Method == http.MethodGet && path == "tasks":
+		reply(http.StatusOK, []any{})
+	case r.Method == http.MethodGet && len(segs) == 1 && e.objects[segs[0]] != nil:
+		reply(http.StatusOK, e.list(segs[0], r.URL.Query().Get("filters")))
+	case r.Method == http.MethodGet && len(segs) == 2 && e.objects[segs[0]][segs[1]] != nil:
+		reply(http.StatusOK, e.objects[segs[0]][segs[1]])
+	case r.Method == http.MethodPost && len(segs) == 2 && segs[1] == "create" && e.objects[segs[0]] != nil:
+		var spec map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+			reply(http.StatusBadRequest, map[string]any{"message": err.Error()})
+			return
+		}
+		e.next++
+		id := fmt.Sprintf("id%d", e.next)
+		if segs[0] == "networks" {
+			e.objects["networks"][id] = map[string]any{"Id": id, "Name": spec["Name"], "Labels": spec["Labels"], "Driver": spec["Driver"]}
+			reply(http.StatusCreated, map[string]any{"Id": id})
+			return
+		}
+		if segs[0] == "secrets" {
+			data, _ := base64.StdEncoding.DecodeString(fmt.Sprint(spec["Data"]))
+			e.secret = string(data)
+		}
+		e.objects[segs[0]][id] = map[string]any{"ID": id, "Version": map[string]any{"Index": 1}, "Spec": spec}
+		reply(http.StatusCreated, map[string]any{"ID": id})
+	case r.Method == http.MethodPost && len(segs) == 3 && segs[2] == "update" && e.objects[segs[0]][segs[1]] != nil:
+		var spec map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+			reply(http.StatusBadRequest, map[string]any{"message": err.Error()})
+			return
+		}
+		obj := e.objects[segs[0]][segs[1]]
+		obj["Spec"] = spec
+		obj["Version"] = map[string]any{"Index": obj["Version"].(map[string]any)["Index"].(int) + 1}
+		reply(http.StatusOK, map[string]any{})
+	case r.Method == http.MethodDelete && len(segs) == 2 && e.objects[segs[0]] != nil:
+		delete(e.objects[segs[0]], segs[1])
+		reply(http.StatusOK, map[string]any{})
+	default:
+		reply(http.StatusNotFound, map[string]any{"message": "not found: " + r.URL.Path})
+	}
+}
+
+// list returns the objects of kind that match the label and name filters,
+// without secret data as the engine does.
+func (e *fakeEngine) list(kind, filters string) []any {
+	var f map[string]map[string]bool
+	_ = json.Unmarshal([]byte(filters), &f)
+	out := []any{}
+	for _, obj := range e.objects[kind] {
+		name, labels := obj["Name"], obj["Labels"]
+		if spec, ok := obj["Spec"].(map[string]any); ok {
+			name, labels = spec["Name"], spec["Labels"]
+		}
+		objLabels, _ := labels.(map[string]any)
+		matched := true
+		for l := range f["label"] {
+			key, val, hasVal := strings.Cut(l, "=")
+			got, ok := objLabels[key]
+			if !ok || (hasVal && got != val) {
+				matched = false
+			}
+		}
+		if names := f["name"]; len(names) > 0 && !names[fmt.Sprint(name)] {
+			matched = false
+		}
+		if !matched {
+			continue
+		}
+		if kind == "secrets" {
+			spec := map[string]any{}
+			for k, v := range obj["Spec"].(map[string]any) {
+				if k != "Data" {
+					spec[k] = v
+				}
+			}
+			obj = map[string]any{"ID": obj["ID"], "Version": obj["Version"], "Spec": spec}
+		}
+		out = append(out, obj)
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/audit"
+	"github.com/acebelowzero/tmpl/internal/config"
+	"github.com/acebelowzero/tmpl/internal/logx"
+	"github.com/acebelowzero/tmpl/internal/release"
+)
+
+// auditEntry records one mutating operation once it finished. A nil
+// auditEntry records nothing, for dry runs and when no sink is configured.
+type auditEntry struct {
+	cmd     *cobra.Command
+	sink    audit.Sink
+	record  audit.Record
+	started time.Time
+}
+
+// startAudit opens the audit sink for operation, so a misconfigured sink
+// fails the command before the swarm is changed.
+func startAudit(cmd *cobra.Command, operation string) (*auditEntry, error) {
+	location := os.Getenv(audit.EnvSink)
+	if location == "" {
+		location = config.FromContext(cmd.Context()).Audit.Sink
+	}
+	if err := audit.Validate(location); err != nil {
+		return nil, withExit(ExitConfig, err)
+	}
+	sink, err := audit.Open(cmd.Context(), location)
+	if err != nil || sink == nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	return &auditEntry{
+		cmd:  cmd,
+		sink: sink,
+		record: audit.Record{
+			User:      audit.CurrentUser(),
+			Host:      host,
+			Operation: operation,
+			Command:   os.Args[1:],
+			Namespace: globalOptions(cmd.Context()).Namespace,
+		},
+		started: time.Now(),
+	}, nil
+}
+
+// finish writes the outcome err of the operation on the release name.
+// rel describes the revision it recorded and may be nil. Failing to write
+// the record is logged, not returned, as the swarm has already changed.
+func (a *auditEntry) finish(name string, rel *release.Release, err error) {
+	if a == nil {
+		return
+	}
+	r := a.record
+	r.Time = time.Now().UTC()
+	r.DurationMS = time.Since(a.started).Milliseconds()
+	r.Release = name
+	if rel != nil {
+		r.Release, r.Revision = rel.Name, rel.Revision
+		if rel.Chart.Name != "" {
+			r.Chart = rel.Chart.Name + "-" + rel.Chart.Version
+		}
+		r.ChartDigest, r.ValuesDigest = rel.ChartDigest, rel.ValuesDigest
+		r.Annotations = rel.Annotations
+	}
+	switch {
+	case errors.Is(err, errNotApproved):
+		r.Result = audit.ResultAborted
+	case err != nil:
+		r.Result, r.Error = audit.ResultFailed, err.Error()
+	default:
+		r.Result = audit.ResultSucceeded
+	}
+	ctx := context.WithoutCancel(a.cmd.Context())
+	if werr := a.sink.Write(ctx, &r); werr != nil {
+		logx.FromContext(ctx).Warn("could not write audit record", "operation", r.Operation, "stack", r.Release, "error", werr)
+	}
+}
@@ -0,0 +1,386 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/render"
+	"github.com/acebelowzero/tmpl/internal/stack"
+	"github.com/acebelowzero/tmpl/internal/timing"
+	"github.com/acebelowzero/tmpl/internal/values"
+)
+
+// benchTotal names the whole render cycle in bench reports.
+const benchTotal timing.Phase = "total"
+
+// benchPhases are the phases of a render cycle, in the order reported.
+// Fetch and Decrypt are part of Values and report no allocations.
+var benchPhases = []timing.Phase{timing.Values, timing.Fetch, timing.Decrypt, timing.Render, timing.Validate, benchTotal}
+
+// benchOptions holds the flags of the bench command.
+type benchOptions struct {
+	valuesFiles []string
+	envFiles    []string
+	stackName   string
+	iterations  int
+	warmup      int
+	format      string
+	cpuProfile  string
+	memProfile  string
+	maxP95      time.Duration
+}
+
+func newBenchCmd() *cobra.Command {
+	opts := &benchOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "bench [CHART]",
+		Short: "Measure how long a chart takes to render",
+		Long: `Render a chart repeatedly and report the latency and allocations of each
+phase of a render: loading values, with the fetches and decryptions they
+need, rendering the templates and validating the rendered stack. The swarm
+is not queried.
+
+Latencies are reported as the 50th and 95th percentile and the maximum over
+--iterations renders, after --warmup renders that are not measured.
+Allocations are the mean count and size per render. Values sources are
+fetched and decrypted on every render, as by every tmpl command.
+
+--max-p95 fails the command when the 95th percentile of whole renders
+exceeds it, to keep the render time of a chart inside a CI budget:
+
+  tmpl bench --iterations 100 --max-p95 250ms ./charts/web
+
+--cpuprofile writes a pprof CPU profile of the measured renders and
+--memprofile one of the allocations of all renders, to be read with
+'go tool pprof'.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chartDir := "."
+			if len(args) == 1 {
+				chartDir = args[0]
+			}
+			if opts.iterations < 1 {
+				return withExit(ExitConfig, errors.New("--iterations must be at least 1"))
+			}
+			if opts.warmup < 0 {
+				return withExit(ExitConfig, errors.New("--warmup cannot be negative"))
+			}
+			if opts.format != "text" && opts.format != "json" {
+				return withExit(ExitConfig, fmt.Errorf("unknown output format %q", opts.format))
+			}
+			return runBench(cmd, chartDir, opts)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&opts.valuesFiles, "values", "f", nil, "Values files")
+	cmd.Flags().StringSliceVar(&opts.envFiles, "env-file", nil, "Environment files for value expansion")
+	cmd.Flags().StringVar(&opts.stackName, "stack", "", "Release and stack name (defaults to the chart name)")
+	cmd.Flags().IntVar(&opts.iterations, "iterations", 50, "Number of measured renders")
+	cmd.Flags().IntVar(&opts.warmup, "warmup", 3, "Number of renders before measuring")
+	cmd.Flags().StringVarP(&opts.format, "output", "o", "text", "Output format: text or json")
+	cmd.Flags().StringVar(&opts.cpuProfile, "cpuprofile", "", "Write a CPU profile of the measured renders to this file")
+	cmd.Flags().StringVar(&opts.memProfile, "memprofile", "", "Write an allocation profile of the renders to this file")
+	cmd.Flags().DurationVar(&opts.maxP95, "max-p95", 0, "Fail when the 95th percentile of whole renders exceeds this duration")
+
+	return cmd
+}
+
+// benchSample is what one phase of one render cost.
+type benchSample struct {
+	duration time.Duration
+	allocs   uint64
+	bytes    uint64
+}
+
+// benchResult summarizes the samples of a phase.
+type benchResult struct {
+	Phase timing.Phase  `json:"phase"`
+	P50   time.Duration `json:"p50"`
+	P95   time.Duration `json:"p95"`
+	Max   time.Duration `json:"max"`
+	// Allocs and Bytes are the mean allocations per render; they are not
+	// measured for phases nested in another.
+	Allocs uint64 `json:"allocs,omitempty"`
+	Bytes  uint64 `json:"bytes,omitempty"`
+	// Count is the mean number of times the phase ran per render, e.g.
+	// one fetch per remote source.
+	Count float64 `json:"count"`
+}
+
+// benchReport is the outcome of the bench command.
+type benchReport struct {
+	Chart      string        `json:"chart"`
+	Iterations int           `json:"iterations"`
+	Warmup     int           `json:"warmup"`
+	Phases     []benchResult `json:"phases"`
+}
+
+func runBench(cmd *cobra.Command, chartDir string, opts *benchOptions) error {
+	meta, err := chart.LoadMetadata(chartDir)
+	if err != nil {
+		return withExit(ExitConfig, err)
+	}
+	cfg, err := loaderConfig(cmd, chartDir, opts.envFiles)
+	if err != nil {
+		return err
+	}
+	name := opts.stackName
+	if name == "" {
+		name = meta.Name
+	}
+	namespace := globalOptions(cmd.Context()).Namespace
+	cycle := &benchCycle{
+		chartDir:    chartDir,
+		cfg:         cfg,
+		valuesFiles: opts.valuesFiles,
+		rcfg:        render.Config{ChartPath: chartDir, ReleaseName: name, Namespace: namespace, Functions: functionPolicy(cmd.Context())},
+		stack:       stack.Options{Name: stack.Namespaced(namespace, name), BaseDir: chartDir, Namespace: namespace},
+	}
+
+	for i := 0; i < opts.warmup; i++ {
+		if _, err := cycle.run(cmd.Context()); err != nil {
+			return err
+		}
+	}
+
+	if opts.cpuProfile != "" {
+		f, err := os.Create(opts.cpuProfile)
+		if err != nil {
+			return fmt.Errorf("create CPU profile: %w", err)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return fmt.Errorf("start CPU profile: %w", err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+	samples := map[timing.Phase][]benchSample{}
+	counts := map[timing.Phase]int{}
+	for i := 0; i < opts.iterations; i++ {
+		if err := cmd.Context().Err(); err != nil {
+			return err
+		}
+		recorded, err := cycle.run(cmd.Context())
+		if err != nil {
+			return err
+		}
+		for phase, s := range recorded {
+			samples[phase] = append(samples[phase], s.sample)
+			counts[phase] += s.count
+		}
+	}
+	pprof.StopCPUProfile()
+	if opts.memProfile != "" {
+		if err := writeAllocProfile(opts.memProfile); err != nil {
+			return err
+		}
+	}
+
+	report := benchReport{Chart: meta.Name, Iterations: opts.iterations, Warmup: opts.warmup}
+	for _, phase := range benchPhases {
+		if len(samples[phase]) == 0 {
+			continue
+		}
+		result := summarize(phase, samples[phase])
+		result.Count = float64(counts[phase]) / float64(opts.iterations)
+		report.Phases = append(report.Phases, result)
+	}
+	if err := writeBenchReport(cmd.OutOrStdout(), report, opts.format); err != nil {
+		return err
+	}
+	if opts.maxP95 > 0 {
+		if total := report.Phases[len(report.Phases)-1]; total.P95 > opts.maxP95 {
+			return withExit(ExitValidation, fmt.Errorf("95th percentile of renders is %s, over the budget of %s", roundDuration(total.P95), opts.maxP95))
+		}
+	}
+	return nil
+}
+
+// writeAllocProfile writes the allocations sampled since the start of the
+// process; the warm-up renders are part of it.
+func writeAllocProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create memory profile: %w", err)
+	}
+	defer f.Close()
+	runtime.GC()
+	if err := pprof.Lookup("allocs").WriteTo(f, 0); err != nil {
+		return fmt.Errorf("write memory profile: %w", err)
+	}
+	return f.Close()
+}
+
+// benchCycle renders a chart as the commands do, measuring each phase.
+type benchCycle struct {
+	chartDir    string
+	cfg         values.LoaderConfig
+	valuesFiles []string
+	rcfg        render.Config
+	stack       stack.Options
+}
+
+// benchRecord is the cost of a phase in one render.
+type benchRecord struct {
+	sample benchSample
+	count  int
+}
+
+// run renders the chart once. Phases nested in values loading are
+// recorded through the timing recorder of the loader.
+func (c *benchCycle) run(ctx context.Context) (map[timing.Phase]benchRecord, error) {
+	recorder := timing.New()
+	ctx = timing.WithContext(ctx, recorder)
+	records := map[timing.Phase]benchRecord{}
+	var mergedValues map[string]any
+	var result *render.Result
+	total, err := measure(func() error {
+		loaded, err := measure(func() error {
+			loader, err := values.NewLoader(c.cfg)
+			if err != nil {
+				return withExit(ExitRender, fmt.Errorf("setup values loader: %w", err))
+			}
+			if mergedValues, err = loader.Load(ctx, c.chartDir, c.valuesFiles...); err != nil {
+				return withExit(ExitRender, fmt.Errorf("load values: %w", err))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		records[timing.Values] = benchRecord{sample: loaded, count: 1}
+
+		rendered, err := measure(func() error {
+			renderer, err := render.New(c.rcfg)
+			if err != nil {
+				return withExit(ExitRender, fmt.Errorf("setup renderer: %w", err))
+			}
+			if result, err = renderer.Render(ctx, mergedValues); err != nil {
+				return withExit(ExitRender, fmt.Errorf("render templates: %w", err))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		records[timing.Render] = benchRecord{sample: rendered, count: 1}
+
+		validated, err := measure(func() error {
+			parsed, err := render.ParseStack(result.Output, result.SourceMap)
+			if err != nil {
+				return withExit(ExitRender, err)
+			}
+			if _, err := stack.Convert(parsed, c.stack); err != nil {
+				return withExit(ExitRender, fmt.Errorf("convert stack: %w", err))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		records[timing.Validate] = benchRecord{sample: validated, count: 1}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	records[benchTotal] = benchRecord{sample: total, count: 1}
+	for _, t := range recorder.Totals() {
+		if t.Phase == timing.Fetch || t.Phase == timing.Decrypt {
+			records[t.Phase] = benchRecord{sample: benchSample{duration: t.Duration}, count: t.Count}
+		}
+	}
+	return records, nil
+}
+
+// measure runs fn and returns its duration and the allocations it made.
+func measure(fn func() error) (benchSample, error) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	err := fn()
+	d := time.Since(start)
+	runtime.ReadMemStats(&after)
+	return benchSample{duration: d, allocs: after.Mallocs - before.Mallocs, bytes: after.TotalAlloc - before.TotalAlloc}, err
+}
+
+// summarize computes the percentiles of the durations of samples, by the
+// nearest-rank method, and their mean allocations.
+func summarize(phase timing.Phase, samples []benchSample) benchResult {
+	durations := make([]time.Duration, len(samples))
+	var allocs, bytes uint64
+	for i, s := range samples {
+		durations[i] = s.duration
+		allocs += s.allocs
+		bytes += s.bytes
+	}
+	slices.Sort(durations)
+	percentile := func(p float64) time.Duration {
+		rank := int(math.Ceil(p * float64(len(durations))))
+		return durations[max(rank, 1)-1]
+	}
+	n := uint64(len(samples))
+	return benchResult{
+		Phase:  phase,
+		P50:    percentile(0.50),
+		P95:    percentile(0.95),
+		Max:    durations[len(durations)-1],
+		Allocs: allocs / n,
+		Bytes:  bytes / n,
+	}
+}
+
+func writeBenchReport(w io.Writer, report benchReport, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	fmt.Fprintf(w, "Chart %s: %d renders after %d warm-up\n\n", report.Chart, report.Iterations, report.Warmup)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tP50\tP95\tMAX\tALLOCS/OP\tBYTES/OP")
+	for _, r := range report.Phases {
+		name := string(r.Phase)
+		if r.Phase == timing.Fetch || r.Phase == timing.Decrypt {
+			name = "  " + name
+			if r.Count != 1 {
+				name += fmt.Sprintf(" (%g)", r.Count)
+			}
+		}
+		allocs, bytes := "-", "-"
+		if r.Allocs > 0 {
+			allocs, bytes = fmt.Sprint(r.Allocs), formatBytes(r.Bytes)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", name, roundDuration(r.P50), roundDuration(r.P95), roundDuration(r.Max), allocs, bytes)
+	}
+	return tw.Flush()
+}
+
+// formatBytes formats n in binary units.
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
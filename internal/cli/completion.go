@@ -0,0 +1,186 @@
+package cli
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/release"
+	"github.com/acebelowzero/tmpl/internal/repo"
+)
+
+// completionTimeout bounds the engine and store queries of a completion,
+// so a missing swarm does not hang the shell.
+const completionTimeout = 3 * time.Second
+
+type completionFunc func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// completeReleases completes the first argument with the names of the
+// releases in the store selected by the --release-store and docker flags.
+func completeReleases(location *string, dockerOpts *dockerOptions) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		names, err := releaseNames(cmd, *location, dockerOpts)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		return filterPrefix(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeRevisions completes a release name, then its revision numbers.
+func completeRevisions(location *string, dockerOpts *dockerOptions) completionFunc {
+	releases := completeReleases(location, dockerOpts)
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) != 1 {
+			return releases(cmd, args, toComplete)
+		}
+		ctx, cancel := context.WithTimeout(cmd.Context(), completionTimeout)
+		defer cancel()
+		store, err := completionStore(ctx, *location, dockerOpts)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		revisions, err := store.List(ctx, args[0])
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		var out []string
+		for _, r := range revisions {
+			if rev := strconv.Itoa(r.Revision); strings.HasPrefix(rev, toComplete) {
+				out = append(out, rev+"\t"+string(r.Status))
+			}
+		}
+		return out, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveKeepOrder
+	}
+}
+
+func releaseNames(cmd *cobra.Command, location string, dockerOpts *dockerOptions) ([]string, error) {
+	ctx, cancel := context.WithTimeout(cmd.Context(), completionTimeout)
+	defer cancel()
+	store, err := completionStore(ctx, location, dockerOpts)
+	if err != nil {
+		return nil, err
+	}
+	return store.Names(ctx)
+}
+
+func completionStore(ctx context.Context, location string, dockerOpts *dockerOptions) (release.Store, error) {
+	client, err := newDockerClient(dockerOpts)
+	if err != nil {
+		return nil, err
+	}
+	return release.New(ctx, release.Config{Location: location, Docker: client})
+}
+
+// completeCharts completes a chart argument: REPO/CHART references of the
+// configured repositories, or directories otherwise.
+func completeCharts(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	refs := chartReferences(toComplete)
+	if len(refs) == 0 {
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	}
+	directive := cobra.ShellCompDirectiveNoFileComp
+	for _, ref := range refs {
+		if strings.HasSuffix(ref, "/") {
+			directive |= cobra.ShellCompDirectiveNoSpace
+			break
+		}
+	}
+	return refs, directive
+}
+
+// completeChartDirs completes a local chart argument with directories.
+func completeChartDirs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return nil, cobra.ShellCompDirectiveFilterDirs
+}
+
+// chartReferences lists the charts of the repositories whose references
+// start with prefix. Before the slash, repository names are offered so the
+// shell can complete them first.
+func chartReferences(prefix string) []string {
+	manager, err := repo.NewManager()
+	if err != nil {
+		return nil
+	}
+	f, err := manager.Load()
+	if err != nil {
+		return nil
+	}
+	var out []string
+	repoName, _, hasChart := strings.Cut(prefix, "/")
+	for _, r := range f.Repositories {
+		if !hasChart {
+			if strings.HasPrefix(r.Name, prefix) {
+				out = append(out, r.Name+"/")
+			}
+			continue
+		}
+		if r.Name != repoName {
+			continue
+		}
+		idx, err := manager.Index(r.Name)
+		if err != nil {
+			continue
+		}
+		for name := range idx.Entries {
+			if ref := r.Name + "/" + name; strings.HasPrefix(ref, prefix) {
+				out = append(out, ref)
+			}
+		}
+	}
+	return out
+}
+
+// completeRepositories completes the names of configured repositories.
+func completeRepositories(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	manager, err := repo.NewManager()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	f, err := manager.Load()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	var names []string
+	for _, r := range f.Repositories {
+		names = append(names, r.Name)
+	}
+	return filterPrefix(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeContexts completes --context with the docker CLI contexts.
+func completeContexts(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	names, err := docker.ListContexts()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	return filterPrefix(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeReleaseStores completes --release-store with the store kinds.
+func completeReleaseStores(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return filterPrefix([]string{"swarm", "file", "file://", "s3://"}, toComplete), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
+
+func filterPrefix(values []string, prefix string) []string {
+	var out []string
+	for _, v := range values {
+		if strings.HasPrefix(v, prefix) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
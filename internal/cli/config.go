@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/config"
+	"github.com/acebelowzero/tmpl/internal/values"
+)
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Show and change the tmpl configuration",
+		Long: `Show and change the defaults read from the user configuration file,
+config.yaml in the tmpl config directory ($TMPL_CONFIG_DIR or
+~/.config/tmpl), and from .tmpl.yaml next to Chart.yaml in a chart.
+
+Settings apply in this order, the first that is set wins:
+
+  1. command-line flags
+  2. environment variables, e.g. TMPL_CACHE_DIR
+  3. .tmpl.yaml of the chart
+  4. the user configuration file
+  5. built-in defaults
+
+Keys: ` + strings.Join(config.Keys, ", "),
+	}
+
+	cmd.AddCommand(newConfigViewCmd())
+	cmd.AddCommand(newConfigSetCmd())
+
+	return cmd
+}
+
+func newConfigViewCmd() *cobra.Command {
+	var chartDir string
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "view",
+		Short: "Print the effective configuration",
+		Long: `Print the user configuration, merged with the .tmpl.yaml of the chart
+given with --chart.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			settings := config.FromContext(cmd.Context())
+			if chartDir != "" {
+				var err error
+				if settings, err = chartSettings(cmd, chartDir); err != nil {
+					return err
+				}
+			}
+			out := cmd.OutOrStdout()
+			switch format {
+			case "json":
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(settings)
+			case "yaml":
+				enc := yaml.NewEncoder(out)
+				enc.SetIndent(2)
+				if err := enc.Encode(settings); err != nil {
+					return err
+				}
+				return enc.Close()
+			default:
+				return withExit(ExitConfig, fmt.Errorf("unknown output format %q", format))
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&chartDir, "chart", "", "Include the .tmpl.yaml of this chart directory")
+	cmd.Flags().StringVarP(&format, "output", "o", "yaml", "Output format: yaml or json")
+	return cmd
+}
+
+func newConfigSetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set KEY VALUE",
+		Short: "Change a setting of the user configuration file",
+		Long: `Change a setting of the user configuration file. An empty VALUE removes
+the setting; env.allow takes a comma-separated list of patterns.
+
+  tmpl config set registries.acme oci://ghcr.io/acme/charts
+  tmpl config set lint.severity.latest-tag error`,
+		Args: cobra.ExactArgs(2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) > 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return filterPrefix(config.Keys, toComplete), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := config.Path()
+			if err != nil {
+				return err
+			}
+			err = config.Edit(path, func(c *config.Config) error {
+				return c.Set(args[0], args[1])
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Set %s in %s\n", args[0], path)
+			return nil
+		},
+	}
+	return cmd
+}
+
+// chartSettings returns the user configuration merged with the
+// configuration file of the chart in chartDir.
+func chartSettings(cmd *cobra.Command, chartDir string) (*config.Config, error) {
+	chartCfg, err := config.LoadChart(chartDir)
+	if err != nil {
+		return nil, withExit(ExitConfig, err)
+	}
+	return config.FromContext(cmd.Context()).Merge(chartCfg), nil
+}
+
+// loaderConfig is the values loader configuration for chartDir.
+func loaderConfig(cmd *cobra.Command, chartDir string, envFiles []string) (values.LoaderConfig, error) {
+	settings, err := chartSettings(cmd, chartDir)
+	if err != nil {
+		return values.LoaderConfig{}, err
+	}
+	return values.LoaderConfig{EnvFiles: envFiles, AllowEnv: settings.Env.Allow}, nil
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/deploy"
+)
+
+// errNotApproved is returned when the changes of a plan were declined.
+var errNotApproved = errors.New("apply cancelled")
+
+// checkDestructive refuses plans that remove services with named volumes
+// unless allowed.
+func checkDestructive(p *deploy.Plan, allowed bool) error {
+	destructive := p.Destructive()
+	if len(destructive) == 0 || allowed {
+		return nil
+	}
+	lines := make([]string, 0, len(destructive))
+	for _, c := range destructive {
+		lines = append(lines, fmt.Sprintf("%s (volumes %s)", c.Name, strings.Join(c.Volumes, ", ")))
+	}
+	return fmt.Errorf("plan removes services with volumes; pass --allow-destructive to proceed:\n  %s", strings.Join(lines, "\n  "))
+}
+
+// confirmPlan writes p and asks on the terminal whether to carry it out.
+// Plans without changes and autoApprove skip the question; when tmpl runs
+// non-interactively, autoApprove is required.
+func confirmPlan(cmd *cobra.Command, p *deploy.Plan, autoApprove bool) error {
+	if autoApprove || !p.HasChanges() {
+		return nil
+	}
+	if !interactive(cmd) {
+		return withExit(ExitConfig, errors.New("cannot ask for confirmation when running non-interactively; pass --auto-approve to apply without confirmation"))
+	}
+	w := cmd.OutOrStdout()
+	if err := writePlan(w, p, useColor(cmd, w)); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\nApply these changes to stack %s? Only 'yes' is accepted: ", p.Stack)
+	answer, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	if err != nil && answer == "" {
+		return errNotApproved
+	}
+	if strings.TrimSpace(answer) != "yes" {
+		return errNotApproved
+	}
+	return nil
+}
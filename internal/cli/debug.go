@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/render"
+)
+
+// debugHelp lists the commands of the debug prompt.
+const debugHelp = `Enter a template snippet, e.g. {{ .Values.image | toYaml }}, or a single
+pipeline without braces, e.g. .Values.image | toYaml. Named templates
+defined with {{ define }} can be used by later snippets.
+
+  :templates  list the templates and named templates
+  :help       show this help
+  :quit       leave (also Ctrl-D)
+`
+
+func newDebugCmd() *cobra.Command {
+	var valuesFiles []string
+	var envFiles []string
+	var releaseName string
+	var exprs []string
+
+	cmd := &cobra.Command{
+		Use:   "debug [CHART]",
+		Short: "Evaluate template expressions against a chart interactively",
+		Long: `Load the values of a chart and evaluate template snippets against them at
+an interactive prompt, with the helpers, functions, .Values, .Chart,
+.Release and .Files the chart's templates see.
+
+Snippets are entered one per line. A line without {{ is taken as a single
+pipeline. Errors are shown without leaving the prompt.
+
+With --eval the given snippets are evaluated in order and their output
+written to stdout, without a prompt.`,
+		Example: `  tmpl debug ./charts/web -f values-prod.yaml
+  tmpl debug -e '.Values.image | toYaml' -e '{{ include "web.labels" . }}'`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chartDir := "."
+			if len(args) == 1 {
+				chartDir = args[0]
+			}
+			session, err := newDebugSession(cmd, chartDir, releaseName, valuesFiles, envFiles)
+			if err != nil {
+				return err
+			}
+			if len(exprs) > 0 {
+				for _, expr := range exprs {
+					out, err := session.Eval(expr)
+					if err != nil {
+						return withExit(ExitRender, fmt.Errorf("%s: %w", expr, err))
+					}
+					writeDebugResult(cmd.OutOrStdout(), out)
+				}
+				return nil
+			}
+			return runDebugPrompt(cmd, session, isTerminal(cmd.InOrStdin()))
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&valuesFiles, "values", "f", nil, "Values files")
+	cmd.Flags().StringSliceVar(&envFiles, "env-file", nil, "Environment files for value expansion")
+	cmd.Flags().StringVar(&releaseName, "release", "", "Release name seen as .Release.Name (defaults to the chart name)")
+	cmd.Flags().StringArrayVarP(&exprs, "eval", "e", nil, "Evaluate a snippet and exit instead of prompting (repeatable)")
+
+	return cmd
+}
+
+// newDebugSession loads the values of the chart in chartDir and sets up a
+// session evaluating snippets against them.
+func newDebugSession(cmd *cobra.Command, chartDir, releaseName string, valuesFiles, envFiles []string) (*render.Session, error) {
+	cfg, err := loaderConfig(cmd, chartDir, envFiles)
+	if err != nil {
+		return nil, err
+	}
+	rcfg := render.Config{ChartPath: chartDir, ReleaseName: releaseName, Namespace: globalOptions(cmd.Context()).Namespace}
+	renderer, mergedValues, _, err := loadRenderer(cmd.Context(), rcfg, cfg, valuesFiles)
+	if err != nil {
+		return nil, err
+	}
+	session, err := renderer.NewSession(mergedValues)
+	if err != nil {
+		return nil, withExit(ExitRender, err)
+	}
+	return session, nil
+}
+
+// runDebugPrompt reads snippets from stdin until EOF or :quit, printing
+// the prompt only when prompt is set.
+func runDebugPrompt(cmd *cobra.Command, session *render.Session, prompt bool) error {
+	out, errOut := cmd.OutOrStdout(), cmd.ErrOrStderr()
+	if prompt {
+		fmt.Fprintln(out, "Type :help for help, :quit to leave.")
+	}
+	scanner := bufio.NewScanner(cmd.InOrStdin())
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for {
+		if prompt {
+			fmt.Fprint(out, "tmpl> ")
+		}
+		if !scanner.Scan() {
+			if prompt {
+				fmt.Fprintln(out)
+			}
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "":
+			continue
+		case ":q", ":quit", ":exit":
+			return nil
+		case ":h", ":help":
+			fmt.Fprint(out, debugHelp)
+			continue
+		case ":templates":
+			for _, name := range session.Templates() {
+				fmt.Fprintln(out, name)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			fmt.Fprintf(errOut, "error: unknown command %s, see :help\n", line)
+			continue
+		}
+		result, err := session.Eval(line)
+		if err != nil {
+			fmt.Fprintf(errOut, "error: %v\n", err)
+			continue
+		}
+		writeDebugResult(out, result)
+	}
+}
+
+// writeDebugResult prints the output of a snippet, if any, ending it with
+// a newline.
+func writeDebugResult(w io.Writer, result string) {
+	if result == "" {
+		return
+	}
+	fmt.Fprint(w, result)
+	if !strings.HasSuffix(result, "\n") {
+		fmt.Fprintln(w)
+	}
+}
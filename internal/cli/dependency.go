@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/config"
+	"github.com/acebelowzero/tmpl/internal/oci"
+	"github.com/acebelowzero/tmpl/internal/repo"
+)
+
+func newDependencyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "dependency",
+		Aliases: []string{"dep"},
+		Short:   "Manage the library charts a chart depends on",
+		Long: `Manage the library charts a chart depends on.
+
+Charts list library charts under dependencies in Chart.yaml:
+
+  dependencies:
+    - name: common
+      version: ^1.2
+      repository: https://charts.example.com
+
+Library charts have type: library. They cannot be rendered themselves,
+but the charts depending on them can use their named templates, and
+their values.yaml provides defaults below the values.yaml of the chart.
+
+The repository is the URL or name of a repository added with
+'tmpl repo add', an oci:// reference or registry name of the
+configuration, or a file:// path relative to the chart. Dependencies are
+vendored into the charts/ directory of the chart by 'tmpl dependency
+update', so renders and packages don't need the repository. Local
+dependencies are read in place until they are vendored.`,
+	}
+
+	cmd.AddCommand(newDependencyUpdateCmd())
+	cmd.AddCommand(newDependencyListCmd())
+
+	return cmd
+}
+
+func newDependencyUpdateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:               "update [CHART]",
+		Aliases:           []string{"up"},
+		Short:             "Vendor the dependencies of a chart into its charts/ directory",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) == 1 {
+				dir = args[0]
+			}
+			return runDependencyUpdate(cmd, dir)
+		},
+	}
+}
+
+func runDependencyUpdate(cmd *cobra.Command, dir string) error {
+	meta, err := chart.LoadMetadata(dir)
+	if err != nil {
+		return withExit(ExitConfig, err)
+	}
+	var manager *repo.Manager
+	for _, dep := range meta.Dependencies {
+		var vendored *chart.Metadata
+		switch {
+		case dep.IsLocal():
+			vendored, err = chart.Vendor(dir, dep, dep.LocalPath(dir))
+		case strings.HasPrefix(config.FromContext(cmd.Context()).ExpandRegistry(dep.Repository), oci.Scheme):
+			vendored, err = vendorOCI(cmd, dir, dep)
+		default:
+			if manager == nil {
+				if manager, err = repo.NewManager(); err != nil {
+					return err
+				}
+			}
+			vendored, err = vendorFromRepository(cmd, manager, dir, dep)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Saved %s %s to %s\n", vendored.Name, vendored.Version,
+			filepath.Join(dir, chart.DependenciesDir, dep.Name))
+	}
+	if len(meta.Dependencies) == 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "Chart %s has no dependencies\n", meta.Name)
+	}
+	return nil
+}
+
+// vendorFromRepository fetches dep from the configured repository its
+// repository field names, by name or URL.
+func vendorFromRepository(cmd *cobra.Command, manager *repo.Manager, dir string, dep chart.Dependency) (*chart.Metadata, error) {
+	if dep.Repository == "" {
+		return nil, fmt.Errorf("dependency %s has no repository", dep.Name)
+	}
+	f, err := manager.Load()
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimPrefix(dep.Repository, "@")
+	if _, ok := f.Get(name); !ok {
+		name = ""
+		for _, r := range f.Repositories {
+			if strings.TrimSuffix(r.URL, "/") == strings.TrimSuffix(dep.Repository, "/") {
+				name = r.Name
+				break
+			}
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("dependency %s: repository %s is not configured, add it with 'tmpl repo add'", dep.Name, dep.Repository)
+	}
+	src, _, err := manager.Fetch(cmd.Context(), name+"/"+dep.Name, dep.Version)
+	if err != nil {
+		return nil, fmt.Errorf("dependency %s: %w", dep.Name, err)
+	}
+	return chart.Vendor(dir, dep, src)
+}
+
+// vendorOCI pulls dep from an OCI registry. Registries are not searched,
+// so the version must be exact.
+func vendorOCI(cmd *cobra.Command, dir string, dep chart.Dependency) (*chart.Metadata, error) {
+	if dep.Version == "" || strings.ContainsAny(dep.Version, "<>=~^*x, |") {
+		return nil, fmt.Errorf("dependency %s: OCI dependencies need an exact version, got %q", dep.Name, dep.Version)
+	}
+	base := config.FromContext(cmd.Context()).ExpandRegistry(dep.Repository)
+	ref := strings.TrimSuffix(base, "/") + "/" + dep.Name + ":" + dep.Version
+	artifact, err := oci.Pull(cmd.Context(), ref)
+	if err != nil {
+		return nil, fmt.Errorf("dependency %s: %w", dep.Name, err)
+	}
+	if artifact.Manifest.ArtifactType != "" && artifact.Manifest.ArtifactType != oci.ArtifactTypeChart {
+		return nil, fmt.Errorf("dependency %s: %s is not a tmpl chart (artifact type %s)", dep.Name, ref, artifact.Manifest.ArtifactType)
+	}
+	return chart.VendorArchive(dir, dep, artifact.Data)
+}
+
+func newDependencyListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:               "list [CHART]",
+		Aliases:           []string{"ls"},
+		Short:             "List the dependencies of a chart and whether they are vendored",
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) == 1 {
+				dir = args[0]
+			}
+			meta, err := chart.LoadMetadata(dir)
+			if err != nil {
+				return withExit(ExitConfig, err)
+			}
+			tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tVERSION\tREPOSITORY\tSTATUS")
+			for _, dep := range meta.Dependencies {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", dep.Name, dep.Version, dep.Repository, dependencyStatus(dir, dep))
+			}
+			return tw.Flush()
+		},
+	}
+}
+
+// dependencyStatus describes whether dep of the chart in dir is usable.
+func dependencyStatus(dir string, dep chart.Dependency) string {
+	depDir, err := chart.DependencyDir(dir, dep)
+	if err != nil {
+		return "missing"
+	}
+	meta, err := chart.LoadMetadata(depDir)
+	if err != nil {
+		return "invalid"
+	}
+	if err := chart.CheckDependency(dep, meta); err != nil {
+		return "mismatch: " + strings.TrimPrefix(err.Error(), "dependency "+dep.Name+": ")
+	}
+	if _, err := os.Stat(filepath.Join(dir, chart.DependenciesDir, dep.Name)); err != nil {
+		return "local " + meta.Version
+	}
+	return "ok " + meta.Version
+}
@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/compose"
+	"github.com/acebelowzero/tmpl/internal/diff"
+	"github.com/acebelowzero/tmpl/internal/render"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// errDiffFound signals differences when --exit-code is set.
+var errDiffFound = withExit(ExitDrift, errors.New("differences found"))
+
+func newDiffCmd() *cobra.Command {
+	var valuesFiles []string
+	var envFiles []string
+	var against string
+	var stackName string
+	var exitCode bool
+	var dockerOpts dockerOptions
+
+	cmd := &cobra.Command{
+		Use:   "diff [CHART]",
+		Short: "Show differences between a rendered chart and a file or a running stack",
+		Long: `Render a chart and compare it structurally against a previously rendered
+file (--against) or against the live services of a swarm stack (--stack).`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chart := "."
+			if len(args) == 1 {
+				chart = args[0]
+			}
+			if (against == "") == (stackName == "") {
+				return withExit(ExitConfig, errors.New("exactly one of --against or --stack is required"))
+			}
+			return runDiff(cmd, chart, valuesFiles, envFiles, against, stackName, exitCode, useColor(cmd, cmd.OutOrStdout()), &dockerOpts)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&valuesFiles, "values", "f", nil, "Values files")
+	cmd.Flags().StringSliceVar(&envFiles, "env-file", nil, "Environment files for value expansion")
+	cmd.Flags().StringVar(&against, "against", "", "Previously rendered stack file to compare with")
+	cmd.Flags().StringVar(&stackName, "stack", "", "Name of the running swarm stack to compare with")
+	cmd.Flags().BoolVar(&exitCode, "exit-code", false, "Exit with a non-zero status when differences are found")
+	addDockerFlags(cmd, &dockerOpts)
+
+	return cmd
+}
+
+func runDiff(cmd *cobra.Command, chart string, valuesFiles, envFiles []string, against, stackName string, exitCode, color bool, dockerOpts *dockerOptions) error {
+	_, result, _, err := renderChartWith(cmd, render.Config{ChartPath: chart, ReleaseName: stackName}, valuesFiles, envFiles)
+	if err != nil {
+		return err
+	}
+
+	var changes []diff.Change
+	if against != "" {
+		changes, err = diffAgainstFile(result.Output, against)
+	} else {
+		changes, err = diffAgainstStack(cmd, result.Output, chart, stackName, dockerOpts)
+	}
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "No differences")
+		return nil
+	}
+	if err := diff.Write(out, changes, color); err != nil {
+		return err
+	}
+	if exitCode {
+		return errDiffFound
+	}
+	return nil
+}
+
+func diffAgainstFile(rendered []byte, path string) ([]diff.Change, error) {
+	previous, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return diffRendered(previous, rendered, path)
+}
+
+// diffRendered compares two rendered outputs structurally; name describes
+// previous in errors.
+func diffRendered(previous, rendered []byte, name string) ([]diff.Change, error) {
+	oldTree, err := renderedTree(previous)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", name, err)
+	}
+	newTree, err := renderedTree(rendered)
+	if err != nil {
+		return nil, fmt.Errorf("decode rendered output: %w", err)
+	}
+	return diff.Compare(oldTree, newTree), nil
+}
+
+// renderedTree decodes rendered output into a generic tree to compare.
+// Rendered files hold digest references instead of secret content; both
+// sides of a comparison are redacted so only changed content shows up.
+func renderedTree(data []byte) (any, error) {
+	data, err := compose.Redact(data)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := decodeDocuments(data)
+	if err != nil {
+		return nil, err
+	}
+	return diff.Normalize(documentsTree(docs))
+}
+
+func diffAgainstStack(cmd *cobra.Command, rendered []byte, chart, name string, dockerOpts *dockerOptions) ([]diff.Change, error) {
+	parsed, err := compose.Parse(rendered)
+	if err != nil {
+		return nil, err
+	}
+	desired, err := stack.Convert(parsed, stack.Options{Name: name, BaseDir: chart})
+	if err != nil {
+		return nil, convertFailure(err)
+	}
+
+	client, err := newDockerClient(dockerOpts)
+	if err != nil {
+		return nil, err
+	}
+	live, err := stack.Fetch(cmd.Context(), client, name)
+	if err != nil {
+		return nil, err
+	}
+
+	oldTree, err := diff.Normalize(live.Comparable())
+	if err != nil {
+		return nil, err
+	}
+	newTree, err := diff.Normalize(desired.Comparable())
+	if err != nil {
+		return nil, err
+	}
+	return diff.Compare(oldTree, newTree), nil
+}
+
+// decodeDocuments decodes every YAML document into generic values.
+func decodeDocuments(data []byte) ([]any, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var docs []any
+	for {
+		var doc any
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+}
+
+// documentsTree unwraps single-document streams so paths start at the
+// document root rather than at a list index.
+func documentsTree(docs []any) any {
+	if len(docs) == 1 {
+		return docs[0]
+	}
+	return docs
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+)
+
+// dockerOptions selects the engine a command talks to. Unset fields fall
+// back to DOCKER_HOST, DOCKER_CONTEXT, DOCKER_TLS_VERIFY, DOCKER_CERT_PATH
+// and the current docker context.
+type dockerOptions struct {
+	context   string
+	host      string
+	tlsVerify bool
+	caFile    string
+	certFile  string
+	keyFile   string
+}
+
+// addDockerFlags registers the engine selection flags, named as in the
+// docker CLI.
+func addDockerFlags(cmd *cobra.Command, opts *dockerOptions) {
+	cmd.Flags().StringVarP(&opts.context, "context", "c", "", "Docker context to use (overrides DOCKER_HOST and DOCKER_CONTEXT)")
+	cmd.Flags().StringVarP(&opts.host, "host", "H", "", "Docker engine to connect to, e.g. tcp://swarm:2376 (overrides --context)")
+	cmd.Flags().BoolVar(&opts.tlsVerify, "tlsverify", false, "Use TLS and verify the engine certificate")
+	cmd.Flags().StringVar(&opts.caFile, "tlscacert", "", "Trust certificates signed by this CA")
+	cmd.Flags().StringVar(&opts.certFile, "tlscert", "", "Path to the TLS client certificate")
+	cmd.Flags().StringVar(&opts.keyFile, "tlskey", "", "Path to the TLS client key")
+	_ = cmd.RegisterFlagCompletionFunc("context", completeContexts)
+}
+
+// endpoint returns the engine selected by the flags.
+func (o *dockerOptions) endpoint() docker.Endpoint {
+	return docker.Endpoint{
+		Host:      o.host,
+		Context:   o.context,
+		TLSVerify: o.tlsVerify,
+		CAFile:    o.caFile,
+		CertFile:  o.certFile,
+		KeyFile:   o.keyFile,
+	}
+}
+
+// newDockerClient connects to the engine selected by opts, the environment
+// or the current docker context.
+func newDockerClient(opts *dockerOptions) (*docker.Client, error) {
+	if opts == nil {
+		opts = &dockerOptions{}
+	}
+	cfg, err := opts.endpoint().Config()
+	if err != nil {
+		return nil, withExit(ExitConfig, fmt.Errorf("resolve docker endpoint: %w", err))
+	}
+	client, err := docker.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("setup docker client: %w", err)
+	}
+	return client, nil
+}
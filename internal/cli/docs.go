@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chartdoc"
+)
+
+func newDocsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "docs",
+		Short: "Generate the documentation of a chart",
+	}
+
+	cmd.AddCommand(newDocsGenCmd())
+
+	return cmd
+}
+
+func newDocsGenCmd() *cobra.Command {
+	var output string
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:     "gen [CHART]",
+		Aliases: []string{"generate"},
+		Short:   "Write a Markdown reference of the values and templates of a chart",
+		Long: `Write the documentation of a chart as Markdown: its metadata and
+dependencies, a reference of its values and an inventory of its templates.
+
+The values reference lists every leaf key of values.yaml and of
+values.schema.json with its type, default and description. Types and
+descriptions of the schema take precedence over those inferred from the
+defaults and the comment above a key, or at the end of its line, as with
+'tmpl schema gen'. Keys the schema requires and the values it allows are
+noted.
+
+The inventory lists the templates producing the stack, with the items of
+those generating a document per item, the hooks with their events and the
+named templates of the chart and its libraries with the file defining
+them. A {{/* comment */}} at the start of a template, or right before a
+define, becomes its description. Templates are parsed, not rendered.
+
+The document is written to the README.md of the chart unless --output is
+given; --output - prints it. A README.md written by hand is only replaced
+with --force.`,
+		Example: `  tmpl docs gen
+  tmpl docs gen ./charts/web -o docs/web.md`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chart := "."
+			if len(args) == 1 {
+				chart = args[0]
+			}
+			return runDocsGen(cmd, chart, output, force)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output file path, or - for stdout (default: the chart's README.md)")
+	cmd.Flags().BoolVar(&force, "force", false, "Replace a README.md that was not generated")
+
+	return cmd
+}
+
+func runDocsGen(cmd *cobra.Command, chart, output string, force bool) error {
+	doc, err := chartdoc.Load(chart)
+	if err != nil {
+		return withExit(ExitConfig, err)
+	}
+	var buf bytes.Buffer
+	if err := doc.WriteMarkdown(&buf); err != nil {
+		return err
+	}
+
+	if output == "-" {
+		_, err := cmd.OutOrStdout().Write(buf.Bytes())
+		return err
+	}
+	if output == "" {
+		output = filepath.Join(chart, "README.md")
+		existing, err := os.ReadFile(output)
+		if err == nil && !force && !bytes.HasPrefix(existing, []byte(chartdoc.Header)) {
+			return withExit(ExitConfig, fmt.Errorf("%s was not generated by tmpl docs gen; use --force to replace it or --output to write elsewhere", output))
+		}
+	}
+	if err := writeFile(output, defaultFileModes, buf.Bytes()); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Documentation written to %s\n", output)
+	return nil
+}
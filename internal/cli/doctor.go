@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/doctor"
+)
+
+// errDoctorFailed signals that at least one diagnostic failed.
+var errDoctorFailed = withExit(ExitValidation, errors.New("doctor found problems"))
+
+func newDoctorCmd() *cobra.Command {
+	var valuesFiles []string
+	var format string
+	var skipDocker bool
+	var timeout time.Duration
+	var dockerOpts dockerOptions
+
+	cmd := &cobra.Command{
+		Use:   "doctor [CHART]",
+		Short: "Check that the environment can render and deploy a chart",
+		Long: `Check the tools and services tmpl depends on and suggest a fix for every
+problem found:
+
+  - sops runs and age keys are usable, and one of them matches a recipient
+    of the chart's .sops.yaml
+  - the Docker API is reachable and the engine is a swarm manager
+  - every configured registry is reachable and accepts the stored credentials
+  - remote values files given with -f (git, S3, OCI or HTTP) can be fetched
+
+The chart defaults to the current directory. -o json writes the report as a
+single JSON document. The command exits with a non-zero status when a check
+fails; warnings do not fail it.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chart := "."
+			if len(args) == 1 {
+				chart = args[0]
+			}
+			if format != "text" && format != "json" {
+				return withExit(ExitConfig, fmt.Errorf("unknown output format %q", format))
+			}
+			settings, err := chartSettings(cmd, chart)
+			if err != nil {
+				return err
+			}
+			cfg := doctor.Config{
+				ChartDir:   chart,
+				Registries: settings.Registries,
+				Sources:    valuesFiles,
+				Timeout:    timeout,
+			}
+			if !skipDocker {
+				cfg.Docker = func() (*docker.Client, error) { return newDockerClient(&dockerOpts) }
+			}
+			report := doctor.Run(cmd.Context(), cfg)
+			if err := writeDoctorReport(cmd.OutOrStdout(), report, format, useColor(cmd, cmd.OutOrStdout())); err != nil {
+				return err
+			}
+			if report.Failed() {
+				return errDoctorFailed
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&valuesFiles, "values", "f", nil, "Values files whose remote sources are checked")
+	cmd.Flags().StringVarP(&format, "output", "o", "text", "Output format: text or json")
+	cmd.Flags().BoolVar(&skipDocker, "skip-docker", false, "Skip the Docker and swarm checks, e.g. on a machine that only renders")
+	cmd.Flags().DurationVar(&timeout, "timeout", doctor.DefaultTimeout, "Time allowed for each remote check")
+	addDockerFlags(cmd, &dockerOpts)
+
+	return cmd
+}
+
+func writeDoctorReport(w io.Writer, report *doctor.Report, format string, color bool) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	for _, c := range report.Checks {
+		status := fmt.Sprintf("%-4s", c.Status)
+		if color {
+			code := ansiGreen
+			switch c.Status {
+			case doctor.StatusFail:
+				code = ansiRed
+			case doctor.StatusWarn:
+				code = ansiYellow
+			}
+			status = code + status + ansiReset
+		}
+		fmt.Fprintf(w, "[%s] %s: %s\n", status, c.Name, c.Message)
+		if c.Fix != "" {
+			fmt.Fprintf(w, "       fix: %s\n", c.Fix)
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d ok, %d warning(s), %d failed\n",
+		report.Count(doctor.StatusOK), report.Count(doctor.StatusWarn), report.Count(doctor.StatusFail))
+	return err
+}
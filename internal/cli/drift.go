@@ -0,0 +1,164 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/deploy"
+	"github.com/acebelowzero/tmpl/internal/diff"
+	"github.com/acebelowzero/tmpl/internal/release"
+)
+
+// errDriftFound signals that live objects differ from the recorded release.
+var errDriftFound = withExit(ExitDrift, errors.New("drift detected"))
+
+func newDriftCmd() *cobra.Command {
+	var store string
+	var format string
+	var dockerOpts dockerOptions
+
+	cmd := &cobra.Command{
+		Use:   "drift [STACK]",
+		Short: "Report changes made to a stack outside of tmpl",
+		Long: `Compare the deployed release of a stack with the live swarm and report
+objects changed out of band, for example an image retagged or an environment
+variable edited with 'docker service update'. The stack defaults to the name
+of the chart in the current directory.
+
+The command exits with a non-zero status when drift is found.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeReleases(&store, &dockerOpts),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, err := stackArg(cmd, args)
+			if err != nil {
+				return err
+			}
+			return runDrift(cmd, name, store, format, useColor(cmd, cmd.OutOrStdout()), &dockerOpts)
+		},
+	}
+
+	addReleaseStoreFlag(cmd, &store)
+	addDockerFlags(cmd, &dockerOpts)
+	cmd.Flags().StringVarP(&format, "output", "o", "text", "Output format: text or json")
+
+	return cmd
+}
+
+// stackArg returns the stack of the release named by args, defaulting to
+// the name of the chart in the current directory, in the namespace of the
+// global options.
+func stackArg(cmd *cobra.Command, args []string) (string, error) {
+	if len(args) == 1 {
+		return namespaced(cmd, args[0]), nil
+	}
+	meta, err := chart.LoadMetadata(".")
+	if err != nil {
+		return "", fmt.Errorf("no stack given and no chart in the current directory: %w", err)
+	}
+	return namespaced(cmd, meta.Name), nil
+}
+
+func runDrift(cmd *cobra.Command, name, location, format string, color bool, dockerOpts *dockerOptions) error {
+	client, err := newDockerClient(dockerOpts)
+	if err != nil {
+		return err
+	}
+	store, err := openReleaseStore(cmd, location, client)
+	if err != nil {
+		return err
+	}
+	rel, err := deployedRelease(cmd, store, name)
+	if err != nil {
+		return err
+	}
+	if err := release.Open(cmd.Context(), rel); err != nil {
+		return withExit(ExitConfig, err)
+	}
+	if rel.Stack == nil {
+		return fmt.Errorf("revision %d of %s has no recorded stack to compare", rel.Revision, name)
+	}
+	drifts, err := deploy.New(client).Drift(cmd.Context(), rel.Stack)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	switch format {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if drifts == nil {
+			drifts = []deploy.Drift{}
+		}
+		if err := enc.Encode(drifts); err != nil {
+			return err
+		}
+	case "text":
+		if err := writeDrift(out, rel, drifts, color); err != nil {
+			return err
+		}
+	default:
+		return withExit(ExitConfig, fmt.Errorf("unknown output format %q", format))
+	}
+	if len(drifts) > 0 {
+		return errDriftFound
+	}
+	return nil
+}
+
+// deployedRelease returns the newest revision of a stack that is deployed
+// in the namespace of the global options.
+func deployedRelease(cmd *cobra.Command, store release.Store, name string) (*release.Release, error) {
+	releases, err := store.List(cmd.Context(), name)
+	if err != nil {
+		return nil, err
+	}
+	releases = inNamespace(cmd, releases)
+	for i := len(releases) - 1; i >= 0; i-- {
+		if releases[i].Status == release.StatusDeployed {
+			return releases[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no deployed revision of %s", release.ErrNotFound, name)
+}
+
+func writeDrift(w io.Writer, rel *release.Release, drifts []deploy.Drift, color bool) error {
+	fmt.Fprintf(w, "Stack %s, revision %d\n\n", rel.Name, rel.Revision)
+	if len(drifts) == 0 {
+		_, err := fmt.Fprintln(w, "No drift")
+		return err
+	}
+	for _, d := range drifts {
+		code := ansiYellow
+		switch d.State {
+		case deploy.DriftMissing:
+			code = ansiRed
+		case deploy.DriftUnmanaged:
+			code = ansiGreen
+		}
+		line := fmt.Sprintf("%s %s %s", d.State, d.Kind, d.Name)
+		if color {
+			line = code + line + ansiReset
+		}
+		fmt.Fprintln(w, line)
+
+		var buf bytes.Buffer
+		if err := diff.Write(&buf, d.Diff, color); err != nil {
+			return err
+		}
+		for _, l := range strings.SplitAfter(buf.String(), "\n") {
+			if l != "" {
+				fmt.Fprint(w, "    "+l)
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "\n%d object(s) drifted from the release\n", len(drifts))
+	return err
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/dump"
+	"github.com/acebelowzero/tmpl/internal/values"
+	"github.com/acebelowzero/tmpl/internal/version"
+)
+
+// writeDebugDumps makes cmd and its sub-commands write the artifacts of
+// their last render to dump.Dir when values failed to load or the chart
+// failed to render, or always with --debug.
+func writeDebugDumps(cmd *cobra.Command) {
+	if run := cmd.RunE; run != nil {
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			err := run(cmd, args)
+			r := dump.FromContext(cmd.Context())
+			if r.Empty() || (ExitCode(err) != ExitRender && !globalOptions(cmd.Context()).Debug) {
+				return err
+			}
+			if werr := r.Write(dump.Dir, version.Version, os.Args, err); werr != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "warning: write debug artifacts: %v\n", werr)
+			} else {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Debug artifacts written to %s\n", dump.Dir)
+			}
+			return err
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		writeDebugDumps(sub)
+	}
+}
+
+// recordValues records the values loaded for a render of chartDir for
+// debug dumps. values is nil when loading failed.
+func recordValues(ctx context.Context, chartDir string, merged map[string]any, loader *values.Loader) {
+	var sources []string
+	if merged != nil {
+		for _, l := range loader.Layers() {
+			sources = append(sources, l.Source)
+		}
+	}
+	dump.FromContext(ctx).Values(chartDir, merged, sources, loader.Env())
+}
@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/deploy"
+)
+
+// Steps reported by apply --output json.
+const (
+	stepPlan   = "plan"
+	stepHook   = "hook"
+	stepChange = "change"
+	stepRecord = "record"
+	stepWait   = "wait"
+	stepApply  = "apply"
+)
+
+// Results of a step.
+const (
+	resultOK      = "ok"
+	resultFailed  = "failed"
+	resultSkipped = "skipped"
+)
+
+// applyEvent is one line of apply --output json. Every step of an apply is
+// reported once it finished; the last event of a release has step apply.
+type applyEvent struct {
+	Time       time.Time     `json:"time"`
+	Stack      string        `json:"stack,omitempty"`
+	Step       string        `json:"step"`
+	Kind       deploy.Kind   `json:"kind,omitempty"`
+	Name       string        `json:"name,omitempty"`
+	Action     deploy.Action `json:"action,omitempty"`
+	Result     string        `json:"result"`
+	DurationMS int64         `json:"durationMs"`
+	Revision   int           `json:"revision,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	// Plan is set on the plan step.
+	Plan *deploy.Plan `json:"plan,omitempty"`
+}
+
+// eventWriter writes apply events as JSON lines. A nil eventWriter
+// discards them, so callers need not check for text output.
+type eventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// newEventWriter writes events to the standard output of cmd, then sends
+// all other output of cmd, and logs sent to --log-output stdout, to its
+// standard error, so the standard output holds nothing but events.
+func newEventWriter(cmd *cobra.Command) *eventWriter {
+	w := &eventWriter{enc: json.NewEncoder(cmd.OutOrStdout())}
+	cmd.SetOut(cmd.ErrOrStderr())
+	logsToStderr(cmd)
+	return w
+}
+
+func (w *eventWriter) emit(e applyEvent) {
+	if w == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	if e.Result == "" {
+		e.Result = resultOK
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_ = w.enc.Encode(e)
+}
+
+// finished emits step of stack with the duration since started and the
+// outcome err.
+func (w *eventWriter) finished(stack, step, name string, started time.Time, err error) {
+	e := applyEvent{Stack: stack, Step: step, Name: name, DurationMS: time.Since(started).Milliseconds()}
+	if err != nil {
+		e.Result, e.Error = resultFailed, err.Error()
+	}
+	w.emit(e)
+}
+
+// planned emits the plan step of stack.
+func (w *eventWriter) planned(stack string, p *deploy.Plan, started time.Time, err error) {
+	e := applyEvent{Stack: stack, Step: stepPlan, Plan: p, DurationMS: time.Since(started).Milliseconds()}
+	if err != nil {
+		e.Result, e.Error = resultFailed, err.Error()
+	}
+	w.emit(e)
+}
+
+// observe reports the changes deployer makes for stack.
+func (w *eventWriter) observe(deployer *deploy.Deployer, stack string) {
+	if w == nil {
+		return
+	}
+	deployer.Observe(func(s deploy.Step) {
+		if s.Running {
+			return
+		}
+		e := applyEvent{
+			Stack:      stack,
+			Step:       stepChange,
+			Kind:       s.Change.Kind,
+			Name:       s.Change.Name,
+			Action:     s.Change.Action,
+			DurationMS: s.Duration.Milliseconds(),
+		}
+		switch {
+		case s.Err != nil:
+			e.Result, e.Error = resultFailed, s.Err.Error()
+		case s.Skipped:
+			e.Result = resultSkipped
+		}
+		w.emit(e)
+	})
+}
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of tmpl, so scripts and CI can branch on the kind of failure.
+const (
+	ExitOK = 0
+	// ExitError is any failure not classified below.
+	ExitError = 1
+	// ExitConfig reports invalid flags, arguments or configuration files.
+	ExitConfig = 2
+	// ExitRender reports values that could not be loaded or templates
+	// that failed to render.
+	ExitRender = 3
+	// ExitValidation reports failed lint, schema, policy or doctor checks.
+	ExitValidation = 4
+	// ExitDrift reports drift, or differences found by diff --exit-code.
+	ExitDrift = 5
+	// ExitApply reports an apply, rollback or uninstall that failed
+	// without changing the swarm, or whose services did not converge.
+	ExitApply = 6
+	// ExitPartialApply reports an apply that failed after changing part
+	// of the swarm.
+	ExitPartialApply = 7
+)
+
+// exitError carries the exit code of a failed command.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func (e *exitError) Unwrap() error { return e.err }
+
+// withExit classifies err with code. Errors that are already classified
+// keep their code, so the most specific failure wins.
+func withExit(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	var classified *exitError
+	if errors.As(err, &classified) {
+		return err
+	}
+	return &exitError{code: code, err: err}
+}
+
+// ExitCode returns the process exit code for the error of a command.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var classified *exitError
+	if errors.As(err, &classified) {
+		return classified.code
+	}
+	return ExitError
+}
+
+// classifyArgErrors makes argument errors of cmd and its sub-commands exit
+// with ExitConfig.
+func classifyArgErrors(cmd *cobra.Command) {
+	if validate := cmd.Args; validate != nil {
+		cmd.Args = func(cmd *cobra.Command, args []string) error {
+			return withExit(ExitConfig, validate(cmd, args))
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		classifyArgErrors(sub)
+	}
+}
+
+// timeoutError is the cause of a command cancelled by --command-timeout.
+type timeoutError struct {
+	after time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("command timed out after %s", e.after)
+}
+
+// reportTimeouts makes the errors of cmd and its sub-commands name the
+// timeout when the command was cancelled by --command-timeout, rather than
+// only the operation that happened to be in flight.
+func reportTimeouts(cmd *cobra.Command) {
+	if run := cmd.RunE; run != nil {
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			err := run(cmd, args)
+			var timeout *timeoutError
+			if err == nil || !errors.As(context.Cause(cmd.Context()), &timeout) || errors.As(err, &timeout) {
+				return err
+			}
+			return fmt.Errorf("%w: %w", timeout, err)
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		reportTimeouts(sub)
+	}
+}
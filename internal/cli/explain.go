@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/schema"
+	"github.com/acebelowzero/tmpl/internal/values"
+)
+
+// explanation describes a values key for explain.
+type explanation struct {
+	Path string `json:"path"`
+	// Set reports whether the merged values hold the key.
+	Set         bool            `json:"set"`
+	Value       any             `json:"value"`
+	Type        string          `json:"type,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Sources     []explainSource `json:"sources"`
+}
+
+// explainSource is a values file that sets the explained key.
+type explainSource struct {
+	Source string `json:"source"`
+	Value  any    `json:"value"`
+}
+
+func newExplainCmd() *cobra.Command {
+	var valuesFiles []string
+	var envFiles []string
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "explain PATH [CHART]",
+		Short: "Describe a values key of a chart",
+		Long: `Describe a values key, given as in templates (.Values.ingress.hosts) or
+without the .Values prefix; list elements are selected with [N].
+
+The merged value is shown as templates would see it, together with the
+type and description the chart's values.schema.json gives the key and the
+values files that set it, in merge order. Without a schema the type is
+taken from the value.`,
+		Example: `  tmpl explain .Values.ingress.hosts
+  tmpl explain web.replicas ./charts/web -f values-prod.yaml`,
+		Args: cobra.RangeArgs(1, 2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return completeChartDirs(cmd, args[1:], toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chart := "."
+			if len(args) == 2 {
+				chart = args[1]
+			}
+			if format != "text" && format != "json" {
+				return withExit(ExitConfig, fmt.Errorf("unknown output format %q", format))
+			}
+			return runExplain(cmd, chart, args[0], valuesFiles, envFiles, format)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&valuesFiles, "values", "f", nil, "Values files")
+	cmd.Flags().StringSliceVar(&envFiles, "env-file", nil, "Environment files for value expansion")
+	cmd.Flags().StringVarP(&format, "output", "o", "text", "Output format: text or json")
+
+	return cmd
+}
+
+func runExplain(cmd *cobra.Command, chart, path string, valuesFiles, envFiles []string, format string) error {
+	segments, err := values.SplitPath(path)
+	if err != nil {
+		return withExit(ExitConfig, err)
+	}
+	s, err := schema.Load(chart)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	cfg, err := loaderConfig(cmd, chart, envFiles)
+	if err != nil {
+		return err
+	}
+	loader, err := values.NewLoader(cfg)
+	if err != nil {
+		return withExit(ExitRender, fmt.Errorf("setup values loader: %w", err))
+	}
+	merged, err := loader.Load(cmd.Context(), chart, valuesFiles...)
+	if err != nil {
+		return withExit(ExitRender, fmt.Errorf("load values: %w", err))
+	}
+
+	e := &explanation{Path: values.JoinPath(segments), Sources: []explainSource{}}
+	e.Value, e.Set = values.Lookup(merged, segments)
+	if e.Set {
+		e.Type = schema.TypeOf(e.Value)
+	}
+	if s != nil {
+		if keySchema := s.Lookup(segments); keySchema != nil {
+			if len(keySchema.Type) > 0 {
+				e.Type = strings.Join(keySchema.Type, " or ")
+			}
+			e.Description = keySchema.Description
+		} else if !e.Set {
+			s = nil
+		}
+	}
+	if !e.Set && s == nil {
+		return fmt.Errorf("values key %s is not set", path)
+	}
+	for _, layer := range loader.Layers() {
+		if v, ok := values.Lookup(layer.Values, segments); ok {
+			e.Sources = append(e.Sources, explainSource{Source: layer.Source, Value: v})
+		}
+	}
+
+	w := cmd.OutOrStdout()
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(e)
+	}
+	return writeExplanation(w, e)
+}
+
+func writeExplanation(w io.Writer, e *explanation) error {
+	name := e.Path
+	if name == "" {
+		name = ".Values"
+	}
+	fmt.Fprintln(w, name)
+	if e.Type != "" {
+		fmt.Fprintf(w, "Type:        %s\n", e.Type)
+	}
+	if e.Description != "" {
+		fmt.Fprintf(w, "Description: %s\n", e.Description)
+	}
+
+	fmt.Fprintln(w, "\nValue:")
+	if e.Set {
+		data, err := yaml.Marshal(e.Value)
+		if err != nil {
+			return fmt.Errorf("encode value: %w", err)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	} else {
+		fmt.Fprintln(w, "  (not set)")
+	}
+
+	fmt.Fprintln(w, "\nSet in:")
+	if len(e.Sources) == 0 {
+		fmt.Fprintln(w, "  no values file")
+	}
+	for _, src := range e.Sources {
+		fmt.Fprintf(w, "  %s\n", src.Source)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/release"
+)
+
+// getOptions holds the flags shared by the get subcommands.
+type getOptions struct {
+	store    string
+	revision int
+	docker   dockerOptions
+}
+
+func newGetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "get",
+		Short: "Inspect a recorded release revision",
+	}
+
+	cmd.AddCommand(newGetManifestCmd())
+	cmd.AddCommand(newGetValuesCmd())
+	cmd.AddCommand(newGetNotesCmd())
+
+	return cmd
+}
+
+func addGetFlags(cmd *cobra.Command, opts *getOptions) {
+	cmd.Flags().IntVar(&opts.revision, "revision", 0, "Revision to inspect (defaults to the latest)")
+	addReleaseStoreFlag(cmd, &opts.store)
+	addDockerFlags(cmd, &opts.docker)
+}
+
+func newGetManifestCmd() *cobra.Command {
+	opts := &getOptions{}
+
+	cmd := &cobra.Command{
+		Use:               "manifest STACK",
+		Short:             "Print the rendered compose document of a revision",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeReleases(&opts.store, &opts.docker),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rel, err := getRelease(cmd, args[0], opts)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprint(cmd.OutOrStdout(), rel.Manifest)
+			return err
+		},
+	}
+
+	addGetFlags(cmd, opts)
+	return cmd
+}
+
+func newGetValuesCmd() *cobra.Command {
+	opts := &getOptions{}
+	var all bool
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "values STACK",
+		Short: "Print the values of a revision",
+		Long: `Print the values a revision was rendered with.
+
+By default only the values supplied with -f are shown. With --all, the
+computed values including chart defaults are printed instead.
+
+Values decrypted from sops files are only stored in revisions recorded with
+releases.encrypt set, which can only be read with one of the keys they were
+encrypted for available to sops, e.g. through SOPS_AGE_KEY_FILE or cloud
+credentials. Other revisions hold references to the digests of these
+values in their place; what templates rendered from them outside secrets
+is still stored in the manifest, hooks and notes.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeReleases(&opts.store, &opts.docker),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rel, err := getRelease(cmd, args[0], opts)
+			if err != nil {
+				return err
+			}
+			vals := rel.UserValues
+			if all {
+				vals = rel.Values
+			}
+			if vals == nil {
+				vals = map[string]any{}
+			}
+			out := cmd.OutOrStdout()
+			switch format {
+			case "json":
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(vals)
+			case "yaml":
+				enc := yaml.NewEncoder(out)
+				enc.SetIndent(2)
+				if err := enc.Encode(vals); err != nil {
+					return err
+				}
+				return enc.Close()
+			default:
+				return withExit(ExitConfig, fmt.Errorf("unknown output format %q", format))
+			}
+		},
+	}
+
+	addGetFlags(cmd, opts)
+	cmd.Flags().BoolVarP(&all, "all", "a", false, "Print computed values including chart defaults")
+	cmd.Flags().StringVarP(&format, "output", "o", "yaml", "Output format: yaml or json")
+	return cmd
+}
+
+func newGetNotesCmd() *cobra.Command {
+	opts := &getOptions{}
+
+	cmd := &cobra.Command{
+		Use:               "notes STACK",
+		Short:             "Print the rendered NOTES.txt of a revision",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeReleases(&opts.store, &opts.docker),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rel, err := getRelease(cmd, args[0], opts)
+			if err != nil {
+				return err
+			}
+			if rel.Notes == "" {
+				return fmt.Errorf("revision %d of %s has no notes", rel.Revision, rel.Name)
+			}
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), strings.TrimRight(rel.Notes, "\n"))
+			return err
+		},
+	}
+
+	addGetFlags(cmd, opts)
+	return cmd
+}
+
+// getRelease loads the revision selected by opts, or the latest one.
+func getRelease(cmd *cobra.Command, name string, opts *getOptions) (*release.Release, error) {
+	client, err := newDockerClient(&opts.docker)
+	if err != nil {
+		return nil, err
+	}
+	store, err := openReleaseStore(cmd, opts.store, client)
+	if err != nil {
+		return nil, err
+	}
+	name = namespaced(cmd, name)
+	releases, err := store.List(cmd.Context(), name)
+	if err != nil {
+		return nil, err
+	}
+	return openRevision(cmd, inNamespace(cmd, releases), name, opts.revision)
+}
+
+// openRevision finds revision, or the latest one when it is zero, among
+// the releases of name and decrypts it.
+func openRevision(cmd *cobra.Command, releases []*release.Release, name string, revision int) (*release.Release, error) {
+	for i := len(releases) - 1; i >= 0; i-- {
+		if revision == 0 || releases[i].Revision == revision {
+			if err := release.Open(cmd.Context(), releases[i]); err != nil {
+				return nil, withExit(ExitConfig, err)
+			}
+			return releases[i], nil
+		}
+	}
+	if revision > 0 {
+		return nil, fmt.Errorf("%w: %s revision %d", release.ErrNotFound, name, revision)
+	}
+	return nil, fmt.Errorf("%w: %s", release.ErrNotFound, name)
+}
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/graph"
+	"github.com/acebelowzero/tmpl/internal/render"
+	"github.com/acebelowzero/tmpl/internal/tmplfile"
+)
+
+// graphOptions holds the flags of the graph command.
+type graphOptions struct {
+	valuesFiles []string
+	envFiles    []string
+	format      string
+	show        []string
+}
+
+func newGraphCmd() *cobra.Command {
+	opts := &graphOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "graph [CHART|TMPLFILE]",
+		Short: "Draw the templates and stack objects of a chart as a graph",
+		Long: `Draw a chart as a graph in Graphviz DOT or Mermaid syntax.
+
+The graph shows which templates and named templates include which others
+(--show templates) and, from the rendered stack, the networks, volumes,
+configs and secrets each service uses (--show stack). For a tmplfile it
+shows the releases and the releases they need.
+
+  tmpl graph ./charts/web | dot -Tsvg > web.svg
+  tmpl graph -o mermaid tmplfile.yaml`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			target := "."
+			if len(args) == 1 {
+				target = args[0]
+			}
+			for _, part := range opts.show {
+				if part != "templates" && part != "stack" {
+					return withExit(ExitConfig, fmt.Errorf("unknown --show value %q: expected templates or stack", part))
+				}
+			}
+			switch opts.format {
+			case "dot", "mermaid", "json":
+			default:
+				return withExit(ExitConfig, fmt.Errorf("unknown output format %q", opts.format))
+			}
+			return runGraph(cmd, target, opts)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&opts.valuesFiles, "values", "f", nil, "Values files")
+	cmd.Flags().StringSliceVar(&opts.envFiles, "env-file", nil, "Environment files for value expansion")
+	cmd.Flags().StringVarP(&opts.format, "output", "o", "dot", "Output format: dot, mermaid or json")
+	cmd.Flags().StringSliceVar(&opts.show, "show", []string{"templates", "stack"}, "Parts of a chart to draw: templates, stack")
+
+	return cmd
+}
+
+func runGraph(cmd *cobra.Command, target string, opts *graphOptions) error {
+	var g *graph.Graph
+	if tmplfile.IsFile(target) {
+		f, err := tmplfile.Load(target)
+		if err != nil {
+			return withExit(ExitConfig, err)
+		}
+		g = graph.New(filepath.Base(target))
+		g.AddReleases(f)
+	} else {
+		meta, err := chart.LoadMetadata(target)
+		if err != nil {
+			return withExit(ExitConfig, err)
+		}
+		g = graph.New(meta.Name)
+		_, result, err := renderChart(cmd, target, opts.valuesFiles, opts.envFiles)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(opts.show, "templates") {
+			if err := g.AddTemplates(result.Sources); err != nil {
+				return err
+			}
+		}
+		if slices.Contains(opts.show, "stack") {
+			parsed, err := render.ParseStack(result.Output, result.SourceMap)
+			if err != nil {
+				return withExit(ExitRender, err)
+			}
+			g.AddStack(parsed)
+		}
+	}
+
+	w := cmd.OutOrStdout()
+	switch opts.format {
+	case "mermaid":
+		return g.WriteMermaid(w)
+	case "json":
+		g.Sort()
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(g)
+	default:
+		return g.WriteDOT(w)
+	}
+}
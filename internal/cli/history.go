@@ -0,0 +1,428 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/diff"
+	"github.com/acebelowzero/tmpl/internal/release"
+)
+
+func newHistoryCmd() *cobra.Command {
+	var store string
+	var format string
+	var dockerOpts dockerOptions
+
+	cmd := &cobra.Command{
+		Use:               "history STACK",
+		Short:             "List the recorded revisions of a stack",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeReleases(&store, &dockerOpts),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runHistory(cmd, namespaced(cmd, args[0]), store, format, &dockerOpts)
+		},
+	}
+
+	addReleaseStoreFlag(cmd, &store)
+	addDockerFlags(cmd, &dockerOpts)
+	cmd.Flags().StringVarP(&format, "output", "o", "table", "Output format: table, json or yaml")
+
+	cmd.AddCommand(newHistoryGCCmd())
+	cmd.AddCommand(newHistoryDiffCmd())
+
+	return cmd
+}
+
+func newHistoryGCCmd() *cobra.Command {
+	var store string
+	var keep int
+	var dryRun bool
+	var dockerOpts dockerOptions
+
+	cmd := &cobra.Command{
+		Use:   "gc STACK",
+		Short: "Delete old revisions of a stack from the release store",
+		Long: `Delete the revisions of a stack beyond the newest --history-max ones. The
+deployed revision is always kept, however old it is. apply and rollback do
+the same after recording a revision when --history-max or TMPL_HISTORY_MAX
+is set.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeReleases(&store, &dockerOpts),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if keep < 1 {
+				return withExit(ExitConfig, fmt.Errorf("--history-max must be at least 1"))
+			}
+			return runHistoryGC(cmd, namespaced(cmd, args[0]), store, keep, dryRun, &dockerOpts)
+		},
+	}
+
+	addReleaseStoreFlag(cmd, &store)
+	addDockerFlags(cmd, &dockerOpts)
+	defaultKeep := historyMaxFromEnv()
+	if defaultKeep <= 0 {
+		defaultKeep = defaultHistoryGC
+	}
+	cmd.Flags().IntVar(&keep, "history-max", defaultKeep, "Revisions to keep")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the revisions that would be deleted")
+
+	return cmd
+}
+
+// defaultHistoryGC is the number of revisions history gc keeps when
+// neither --history-max nor TMPL_HISTORY_MAX is set.
+const defaultHistoryGC = 10
+
+func runHistoryGC(cmd *cobra.Command, name, location string, keep int, dryRun bool, dockerOpts *dockerOptions) error {
+	client, err := newDockerClient(dockerOpts)
+	if err != nil {
+		return err
+	}
+	store, err := openReleaseStore(cmd, location, client)
+	if err != nil {
+		return err
+	}
+	var deleted []*release.Release
+	if dryRun {
+		releases, err := store.List(cmd.Context(), name)
+		if err != nil {
+			return err
+		}
+		deleted = release.Expired(releases, keep)
+	} else {
+		deleted, err = release.Prune(cmd.Context(), store, name, keep)
+	}
+	verb := "Deleted"
+	if dryRun {
+		verb = "Would delete"
+	}
+	for _, r := range deleted {
+		fmt.Fprintf(cmd.OutOrStdout(), "%s revision %d (%s)\n", verb, r.Revision, r.Status)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "%s %d revisions of %s\n", verb, len(deleted), name)
+	return nil
+}
+
+func newHistoryDiffCmd() *cobra.Command {
+	var store string
+	var all bool
+	var format string
+	var exitCode bool
+	var dockerOpts dockerOptions
+
+	cmd := &cobra.Command{
+		Use:   "diff STACK FROM TO",
+		Short: "Show what changed between two revisions of a stack",
+		Long: `Compare two recorded revisions of a stack structurally, e.g.
+'tmpl history diff web 4 7': the release metadata (chart, app version,
+status, sources, description and annotations), the rendered manifest, the
+rendered hooks and the values.
+
+Manifests and hooks are compared with secret and config content redacted
+to digests, so changed content shows as a changed digest. Values are the
+user-supplied values of each revision unless --all compares the computed
+values including chart defaults. Values decrypted from sops files are
+compared as digests unless the revisions were recorded with
+releases.encrypt set, which can only be compared with one of the keys they
+were encrypted for available to sops. Manifests and hooks show what
+templates rendered from these values outside secrets as it was rendered.`,
+		Args:              cobra.ExactArgs(3),
+		ValidArgsFunction: completeReleases(&store, &dockerOpts),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var revisions [2]int
+			for i, arg := range args[1:] {
+				n, err := strconv.Atoi(arg)
+				if err != nil || n < 1 {
+					return withExit(ExitConfig, fmt.Errorf("invalid revision %q", arg))
+				}
+				revisions[i] = n
+			}
+			if format != "text" && format != "json" {
+				return withExit(ExitConfig, fmt.Errorf("unknown output format %q", format))
+			}
+			return runHistoryDiff(cmd, namespaced(cmd, args[0]), store, revisions[0], revisions[1], all, format, exitCode, &dockerOpts)
+		},
+	}
+
+	addReleaseStoreFlag(cmd, &store)
+	addDockerFlags(cmd, &dockerOpts)
+	cmd.Flags().BoolVarP(&all, "all", "a", false, "Compare computed values including chart defaults")
+	cmd.Flags().StringVarP(&format, "output", "o", "text", "Output format: text or json")
+	cmd.Flags().BoolVar(&exitCode, "exit-code", false, "Exit with a non-zero status when the revisions differ")
+
+	return cmd
+}
+
+// revisionDiff is the difference between two revisions of a stack.
+type revisionDiff struct {
+	Name     string        `json:"name"`
+	From     int           `json:"from"`
+	To       int           `json:"to"`
+	Release  []diff.Change `json:"release"`
+	Manifest []diff.Change `json:"manifest"`
+	Hooks    []diff.Change `json:"hooks"`
+	Values   []diff.Change `json:"values"`
+}
+
+func (d *revisionDiff) empty() bool {
+	return len(d.Release)+len(d.Manifest)+len(d.Hooks)+len(d.Values) == 0
+}
+
+func runHistoryDiff(cmd *cobra.Command, name, location string, from, to int, all bool, format string, exitCode bool, dockerOpts *dockerOptions) error {
+	client, err := newDockerClient(dockerOpts)
+	if err != nil {
+		return err
+	}
+	store, err := openReleaseStore(cmd, location, client)
+	if err != nil {
+		return err
+	}
+	releases, err := store.List(cmd.Context(), name)
+	if err != nil {
+		return err
+	}
+	releases = inNamespace(cmd, releases)
+	old, err := openRevision(cmd, releases, name, from)
+	if err != nil {
+		return err
+	}
+	new, err := openRevision(cmd, releases, name, to)
+	if err != nil {
+		return err
+	}
+	d, err := diffRevisions(old, new, all)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+	} else if err := writeRevisionDiff(out, d, useColor(cmd, out)); err != nil {
+		return err
+	}
+	if exitCode && !d.empty() {
+		return errDiffFound
+	}
+	return nil
+}
+
+// diffRevisions compares old and new. Changes of the revision number and
+// timestamps are left out; they always differ.
+func diffRevisions(old, new *release.Release, all bool) (*revisionDiff, error) {
+	d := &revisionDiff{Name: new.Name, From: old.Revision, To: new.Revision}
+	metadata := func(r *release.Release) (any, error) {
+		e := newHistoryEntry(r)
+		e.Revision, e.Created, e.Updated = 0, time.Time{}, time.Time{}
+		return diff.Normalize(e)
+	}
+	oldMeta, err := metadata(old)
+	if err != nil {
+		return nil, err
+	}
+	newMeta, err := metadata(new)
+	if err != nil {
+		return nil, err
+	}
+	d.Release = diff.Compare(oldMeta, newMeta)
+
+	if d.Manifest, err = diffRendered([]byte(old.Manifest), []byte(new.Manifest), fmt.Sprintf("revision %d", old.Revision)); err != nil {
+		return nil, err
+	}
+
+	oldHooks, err := hookTrees(old)
+	if err != nil {
+		return nil, err
+	}
+	newHooks, err := hookTrees(new)
+	if err != nil {
+		return nil, err
+	}
+	d.Hooks = diff.Compare(oldHooks, newHooks)
+
+	values := func(r *release.Release) (any, error) {
+		vals := r.UserValues
+		if all {
+			vals = r.Values
+		}
+		if vals == nil {
+			vals = map[string]any{}
+		}
+		return diff.Normalize(vals)
+	}
+	oldValues, err := values(old)
+	if err != nil {
+		return nil, err
+	}
+	newValues, err := values(new)
+	if err != nil {
+		return nil, err
+	}
+	d.Values = diff.Compare(oldValues, newValues)
+	return d, nil
+}
+
+// hookTrees decodes the rendered hooks of r by name.
+func hookTrees(r *release.Release) (map[string]any, error) {
+	trees := make(map[string]any, len(r.Hooks))
+	for name, rendered := range r.Hooks {
+		tree, err := renderedTree([]byte(rendered))
+		if err != nil {
+			return nil, fmt.Errorf("decode hook %s of revision %d: %w", name, r.Revision, err)
+		}
+		trees[name] = tree
+	}
+	return trees, nil
+}
+
+// writeRevisionDiff prints the changes of d by section, leaving out the
+// sections without changes.
+func writeRevisionDiff(w io.Writer, d *revisionDiff, color bool) error {
+	fmt.Fprintf(w, "Revision %d -> %d of %s\n", d.From, d.To, d.Name)
+	if d.empty() {
+		_, err := fmt.Fprintln(w, "No differences")
+		return err
+	}
+	for _, section := range []struct {
+		title   string
+		changes []diff.Change
+	}{
+		{"Release", d.Release},
+		{"Manifest", d.Manifest},
+		{"Hooks", d.Hooks},
+		{"Values", d.Values},
+	} {
+		if len(section.changes) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s:\n", section.title)
+		if err := diff.Write(w, section.changes, color); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// historyEntry is the scripting view of a revision. Manifests and stack
+// content are omitted; they can be large and hold secret material.
+type historyEntry struct {
+	Revision     int               `json:"revision" yaml:"revision"`
+	Status       release.Status    `json:"status" yaml:"status"`
+	Created      time.Time         `json:"created" yaml:"created"`
+	Updated      time.Time         `json:"updated" yaml:"updated"`
+	Chart        string            `json:"chart" yaml:"chart"`
+	ChartVersion string            `json:"chartVersion" yaml:"chartVersion"`
+	AppVersion   string            `json:"appVersion,omitempty" yaml:"appVersion,omitempty"`
+	ValuesDigest string            `json:"valuesDigest" yaml:"valuesDigest"`
+	Sources      []release.Source  `json:"sources,omitempty" yaml:"sources,omitempty"`
+	Description  string            `json:"description,omitempty" yaml:"description,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+}
+
+func newHistoryEntry(r *release.Release) historyEntry {
+	return historyEntry{
+		Revision:     r.Revision,
+		Status:       r.Status,
+		Created:      r.Created,
+		Updated:      r.Updated,
+		Chart:        r.Chart.Name,
+		ChartVersion: r.Chart.Version,
+		AppVersion:   r.Chart.AppVersion,
+		ValuesDigest: r.ValuesDigest,
+		Sources:      r.Sources,
+		Description:  r.Description,
+		Annotations:  r.Annotations,
+	}
+}
+
+func runHistory(cmd *cobra.Command, name, location, format string, dockerOpts *dockerOptions) error {
+	client, err := newDockerClient(dockerOpts)
+	if err != nil {
+		return err
+	}
+	store, err := openReleaseStore(cmd, location, client)
+	if err != nil {
+		return err
+	}
+	releases, err := store.List(cmd.Context(), name)
+	if err != nil {
+		return err
+	}
+	releases = inNamespace(cmd, releases)
+	entries := make([]historyEntry, 0, len(releases))
+	for _, r := range releases {
+		entries = append(entries, newHistoryEntry(r))
+	}
+
+	out := cmd.OutOrStdout()
+	switch format {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "yaml":
+		enc := yaml.NewEncoder(out)
+		enc.SetIndent(2)
+		if err := enc.Encode(entries); err != nil {
+			return err
+		}
+		return enc.Close()
+	case "table":
+		if len(entries) == 0 {
+			return fmt.Errorf("no history recorded for stack %s", name)
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "REVISION\tUPDATED\tSTATUS\tCHART\tAPP VERSION\tVALUES\tSOURCE\tDESCRIPTION\tANNOTATIONS")
+		for _, e := range entries {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s-%s\t%s\t%s\t%s\t%s\t%s\n", e.Revision, e.Updated.Local().Format(time.RFC3339),
+				e.Status, e.Chart, e.ChartVersion, e.AppVersion, shortDigest(e.ValuesDigest), sourceSummary(e.Sources), e.Description, annotationSummary(e.Annotations))
+		}
+		return tw.Flush()
+	default:
+		return withExit(ExitConfig, fmt.Errorf("unknown output format %q", format))
+	}
+}
+
+// shortDigest abbreviates a sha256 digest for tables.
+func shortDigest(digest string) string {
+	hex := strings.TrimPrefix(digest, "sha256:")
+	if len(hex) > 12 {
+		return hex[:12]
+	}
+	return hex
+}
+
+// annotationSummary shows annotations as KEY=VALUE pairs sorted by key.
+func annotationSummary(annotations map[string]string) string {
+	pairs := make([]string, 0, len(annotations))
+	for key, value := range annotations {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// sourceSummary shows the chart revision, or the first revision known.
+func sourceSummary(sources []release.Source) string {
+	for _, s := range sources {
+		if s.Revision != "" {
+			return s.Kind + "@" + shortDigest(s.Revision)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,222 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/logx"
+	"github.com/acebelowzero/tmpl/internal/oci"
+	"github.com/acebelowzero/tmpl/internal/render"
+)
+
+// renderFlags are the flags selecting what a rendered stack holds, shared
+// by the commands rendering one.
+type renderFlags struct {
+	profiles   []string
+	pinDigests bool
+	sandbox    bool
+	// strict fails renders of deprecated charts, which otherwise warn.
+	strict bool
+	// overlay is a directory whose templates shadow those of the chart.
+	overlay string
+	// patches are files of patches applied to the rendered stack.
+	patches []string
+	// cache keeps parsed templates across the renders of watch and serve;
+	// it is not a flag.
+	cache *render.Cache
+	// capabilities describe the swarm of plan and apply to templates; they
+	// are not a flag.
+	capabilities *render.Capabilities
+}
+
+func addRenderFlags(cmd *cobra.Command, f *renderFlags) {
+	cmd.Flags().StringSliceVar(&f.profiles, "profile", nil, "Activate compose profiles, enabling the services that have them ('*' for all)")
+	cmd.Flags().BoolVar(&f.pinDigests, "pin-digests", false, "Resolve image tags to digests in their registries and pin services to them")
+	cmd.Flags().BoolVar(&f.sandbox, "sandbox", false, "Render an untrusted chart confined to its directory, without remote fetches and with time and output limits")
+	cmd.Flags().BoolVar(&f.strict, "strict", false, "Fail instead of warning when the chart or a library chart it depends on is deprecated")
+	cmd.Flags().StringVar(&f.overlay, "overlay", "", "Directory of templates and helpers shadowing the chart files of the same path")
+	cmd.Flags().StringArrayVar(&f.patches, "patch", nil, "Apply a strategic merge or JSON patch file to the rendered stack (repeatable)")
+	cmd.MarkFlagsMutuallyExclusive("pin-digests", "sandbox")
+}
+
+// config returns rcfg with the flags applied.
+func (f renderFlags) config(ctx context.Context, rcfg render.Config) render.Config {
+	rcfg.Profiles = f.profiles
+	rcfg.Overlay = f.overlay
+	rcfg.Patches = f.patches
+	rcfg.Cache = f.cache
+	rcfg.Capabilities = f.capabilities
+	if f.pinDigests {
+		rcfg.ResolveImage = imageResolver(ctx)
+	}
+	if f.sandbox {
+		rcfg.Sandbox = &render.Sandbox{}
+	}
+	return rcfg
+}
+
+// swarmCapabilities queries the swarm of client for the capabilities
+// templates read as .Capabilities.
+func swarmCapabilities(ctx context.Context, client *docker.Client) (*render.Capabilities, error) {
+	nodes, err := client.ListNodes(ctx, docker.Filters{})
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	return render.SwarmCapabilities(nodes), nil
+}
+
+// checkDeprecated warns when the chart in chartDir or a library chart it
+// depends on is deprecated, naming its successor, and fails with --strict.
+func (f renderFlags) checkDeprecated(cmd *cobra.Command, chartDir string) error {
+	meta, err := chart.LoadMetadata(chartDir)
+	if err != nil {
+		return withExit(ExitConfig, err)
+	}
+	libs, err := chart.Libraries(chartDir)
+	if err != nil {
+		return withExit(ExitConfig, err)
+	}
+	charts := []*chart.Metadata{meta}
+	for _, lib := range libs {
+		charts = append(charts, lib.Metadata)
+	}
+	for _, m := range charts {
+		err := m.CheckDeprecated()
+		if err == nil {
+			continue
+		}
+		if f.strict {
+			return withExit(ExitValidation, err)
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "warning: %s\n", err)
+	}
+	return nil
+}
+
+// imageResolver returns a function pinning images to the digest of their
+// tag, asking the registry once per image.
+func imageResolver(ctx context.Context) func(string) (string, error) {
+	var mu sync.Mutex
+	pinned := map[string]string{}
+	return func(image string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ref, ok := pinned[image]; ok {
+			return ref, nil
+		}
+		ref, err := oci.ResolveImage(ctx, image)
+		if err != nil {
+			return "", err
+		}
+		if ref != image {
+			logx.FromContext(ctx).Debug("pinned image", "image", image, "ref", ref)
+		}
+		pinned[image] = ref
+		return ref, nil
+	}
+}
+
+// serviceImage is an entry of tmpl images.
+type serviceImage struct {
+	Service string `json:"service" yaml:"service"`
+	Image   string `json:"image" yaml:"image"`
+}
+
+func newImagesCmd() *cobra.Command {
+	var valuesFiles []string
+	var envFiles []string
+	var stackName string
+	var flags renderFlags
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "images [CHART]",
+		Short: "List the images of the services of a rendered chart",
+		Long: `Render a chart and list the image of every service of the stack.
+
+With --pin-digests the tag of every image is resolved to the digest it
+points to in its registry, with the credentials of the docker config, and
+images are listed as NAME:TAG@DIGEST. template, plan and apply take the same
+flag to pin the images of the rendered stack, so a saved plan deploys the
+images that were planned even when their tags move before it is applied.`,
+		Example: `  tmpl images ./charts/web -f values-prod.yaml
+  tmpl images --pin-digests -o json`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chartDir := "."
+			if len(args) == 1 {
+				chartDir = args[0]
+			}
+			if err := flags.checkDeprecated(cmd, chartDir); err != nil {
+				return err
+			}
+			rcfg := flags.config(cmd.Context(), render.Config{ChartPath: chartDir, ReleaseName: stackName, Namespace: globalOptions(cmd.Context()).Namespace})
+			images, err := renderImages(cmd, rcfg, valuesFiles, envFiles)
+			if err != nil {
+				return err
+			}
+			return writeImages(cmd, images, format)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&valuesFiles, "values", "f", nil, "Values files")
+	cmd.Flags().StringSliceVar(&envFiles, "env-file", nil, "Environment files for value expansion")
+	cmd.Flags().StringVar(&stackName, "stack", "", "Release name seen as .Release.Name (defaults to the chart name)")
+	cmd.Flags().StringVarP(&format, "output", "o", "table", "Output format: table, json or yaml")
+	addRenderFlags(cmd, &flags)
+
+	return cmd
+}
+
+// renderImages renders a chart and returns the image of every service,
+// sorted by service.
+func renderImages(cmd *cobra.Command, rcfg render.Config, valuesFiles, envFiles []string) ([]serviceImage, error) {
+	_, rendered, _, err := renderChartWith(cmd, rcfg, valuesFiles, envFiles)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := render.ParseStack(rendered.Output, rendered.SourceMap)
+	if err != nil {
+		return nil, withExit(ExitRender, err)
+	}
+	images := make([]serviceImage, 0, len(parsed.Services))
+	for _, name := range parsed.ServiceNames() {
+		images = append(images, serviceImage{Service: name, Image: parsed.Services[name].Image})
+	}
+	return images, nil
+}
+
+func writeImages(cmd *cobra.Command, images []serviceImage, format string) error {
+	out := cmd.OutOrStdout()
+	switch format {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(images)
+	case "yaml":
+		enc := yaml.NewEncoder(out)
+		enc.SetIndent(2)
+		if err := enc.Encode(images); err != nil {
+			return err
+		}
+		return enc.Close()
+	case "table":
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "SERVICE\tIMAGE")
+		for _, i := range images {
+			fmt.Fprintf(tw, "%s\t%s\n", i.Service, i.Image)
+		}
+		return tw.Flush()
+	default:
+		return withExit(ExitConfig, fmt.Errorf("unknown output format %q", format))
+	}
+}
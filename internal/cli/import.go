@@ -0,0 +1,251 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/compose"
+	"github.com/acebelowzero/tmpl/internal/schema"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+func newImportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Create a chart from an existing deployment",
+	}
+
+	cmd.AddCommand(newImportSwarmCmd())
+
+	return cmd
+}
+
+func newImportSwarmCmd() *cobra.Command {
+	var dir string
+	var chartName string
+	var dockerOpts dockerOptions
+
+	cmd := &cobra.Command{
+		Use:   "swarm STACK",
+		Short: "Create a chart skeleton from a running swarm stack",
+		Long: `Read the services, networks, volumes, configs and secrets of a running
+swarm stack and write a chart deploying the same stack, as a starting
+point for moving an existing deployment to tmpl.
+
+The image, replicas and environment of every service become values, keyed
+by service name, and the rest of the stack is written as is to
+templates/stack.yaml.tmpl. The content of configs is written to files/.
+The engine never returns the content of secrets, so they are declared
+external, referring to the live secrets by name; replace them with files
+or encrypted values before deploying the chart elsewhere. values.schema.json
+is inferred from the values as 'tmpl schema gen' would.
+
+The chart is written to the directory named after the stack unless --dir
+is given, which must not hold a chart yet. To take over the running stack
+with the chart, see 'tmpl adopt'.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := namespaced(cmd, args[0])
+			if chartName == "" {
+				chartName = args[0]
+			}
+			if dir == "" {
+				dir = args[0]
+			}
+			return runImportSwarm(cmd, name, chartName, dir, &dockerOpts)
+		},
+	}
+
+	cmd.Flags().StringVar(&dir, "dir", "", "Directory to write the chart to (default: the stack name)")
+	cmd.Flags().StringVar(&chartName, "name", "", "Chart name (default: the stack name)")
+	addDockerFlags(cmd, &dockerOpts)
+
+	return cmd
+}
+
+func runImportSwarm(cmd *cobra.Command, name, chartName, dir string, dockerOpts *dockerOptions) error {
+	if _, err := os.Stat(filepath.Join(dir, "Chart.yaml")); err == nil {
+		return withExit(ExitConfig, fmt.Errorf("%s already holds a chart", dir))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("check chart existence: %w", err)
+	}
+	client, err := newDockerClient(dockerOpts)
+	if err != nil {
+		return err
+	}
+	live, err := stack.Fetch(cmd.Context(), client, name)
+	if err != nil {
+		return err
+	}
+	if len(live.Services) == 0 {
+		return fmt.Errorf("stack %s has no services", name)
+	}
+	composed, warnings := live.Compose()
+	files, err := importedChart(chartName, name, composed)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := writeFile(filepath.Join(dir, path), defaultFileModes, files[path]); err != nil {
+			return err
+		}
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(cmd.ErrOrStderr(), "warning: %s\n", w)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Created chart %s in %s from stack %s (%d services)\n", chartName, dir, name, len(composed.Services))
+	return nil
+}
+
+// importPlaceholder marks the values the template of an imported chart
+// reads, until the encoded stack has them replaced by template actions.
+const importPlaceholder = "tmpl-import-value-"
+
+// identifier matches keys templates can read as fields.
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// importedChart returns the files of chart name deploying s, imported from
+// stackName, by their path: the image, replicas and environment of its
+// services are values, config content moves to files.
+func importedChart(name, stackName string, s *compose.Stack) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for key, obj := range s.Configs {
+		if obj.External {
+			continue
+		}
+		path := filepath.ToSlash(filepath.Join("files", key))
+		files[path] = []byte(obj.Content)
+		obj.Content, obj.File = "", path
+		s.Configs[key] = obj
+	}
+
+	var root yaml.Node
+	if err := root.Encode(s); err != nil {
+		return nil, fmt.Errorf("encode stack: %w", err)
+	}
+	values := &yaml.Node{Kind: yaml.MappingNode}
+	var actions []string
+	placeholder := func(node *yaml.Node, action string) {
+		node.Kind, node.Tag, node.Style = yaml.ScalarNode, "!!str", 0
+		node.Value = fmt.Sprintf("%s%d", importPlaceholder, len(actions))
+		node.Content = nil
+		actions = append(actions, action)
+	}
+	services := mappingValue(&root, "services")
+	for _, svcName := range s.ServiceNames() {
+		svc := s.Services[svcName]
+		node := mappingValue(services, svcName)
+		ref := ".Values." + svcName
+		if !identifier.MatchString(svcName) {
+			ref = fmt.Sprintf("(index .Values %q)", svcName)
+		}
+		svcValues := &yaml.Node{Kind: yaml.MappingNode}
+		addValue := func(key, comment string, value *yaml.Node) {
+			k := &yaml.Node{Kind: yaml.ScalarNode, Value: key, HeadComment: comment}
+			svcValues.Content = append(svcValues.Content, k, value)
+		}
+		if image := mappingValue(node, "image"); image != nil {
+			addValue("image", fmt.Sprintf("Image of the %s service.", svcName), &yaml.Node{Kind: yaml.ScalarNode, Value: svc.Image})
+			placeholder(image, fmt.Sprintf("{{ %s.image | quote }}", ref))
+		}
+		if replicas := mappingValue(mappingValue(node, "deploy"), "replicas"); replicas != nil {
+			addValue("replicas", fmt.Sprintf("Number of tasks of the %s service.", svcName), &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: replicas.Value})
+			placeholder(replicas, fmt.Sprintf("{{ %s.replicas }}", ref))
+		}
+		if env := mappingValue(node, "environment"); env != nil {
+			envValues := &yaml.Node{Kind: yaml.MappingNode}
+			for i := 0; i+1 < len(env.Content); i += 2 {
+				key := env.Content[i].Value
+				envValues.Content = append(envValues.Content,
+					&yaml.Node{Kind: yaml.ScalarNode, Value: key},
+					&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: svc.Environment[key]})
+				placeholder(env.Content[i+1], fmt.Sprintf("{{ index %s.environment %q | quote }}", ref, key))
+			}
+			addValue("environment", fmt.Sprintf("Environment of the %s service.", svcName), envValues)
+		}
+		if len(svcValues.Content) > 0 {
+			values.Content = append(values.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: svcName}, svcValues)
+		}
+	}
+
+	var stackYAML bytes.Buffer
+	enc := yaml.NewEncoder(&stackYAML)
+	enc.SetIndent(2)
+	if err := enc.Encode(&root); err != nil {
+		return nil, fmt.Errorf("encode stack: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("encode stack: %w", err)
+	}
+	template := stackYAML.String()
+	// Later placeholders first, so tmpl-import-value-1 does not replace
+	// the start of tmpl-import-value-10.
+	for i := len(actions) - 1; i >= 0; i-- {
+		template = strings.ReplaceAll(template, fmt.Sprintf("%s%d", importPlaceholder, i), actions[i])
+	}
+	files[filepath.ToSlash(filepath.Join("templates", "stack.yaml.tmpl"))] = []byte(template)
+
+	var valuesYAML bytes.Buffer
+	fmt.Fprintf(&valuesYAML, "# Default values for %s, as deployed when it was imported.\n", name)
+	if len(values.Content) > 0 {
+		enc = yaml.NewEncoder(&valuesYAML)
+		enc.SetIndent(2)
+		if err := enc.Encode(values); err != nil {
+			return nil, fmt.Errorf("encode values: %w", err)
+		}
+		if err := enc.Close(); err != nil {
+			return nil, fmt.Errorf("encode values: %w", err)
+		}
+	}
+	files["values.yaml"] = valuesYAML.Bytes()
+
+	generated, err := schema.Generate(valuesYAML.Bytes(), schema.GenerateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.MarshalIndent(generated, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encode schema: %w", err)
+	}
+	files[schema.FileName] = append(encoded, '\n')
+
+	files["Chart.yaml"] = []byte(`apiVersion: v1
+name: ` + name + `
+description: Imported from the swarm stack ` + stackName + `
+version: 0.1.0
+`)
+	return files, nil
+}
+
+// mappingValue returns the value of key in the mapping node, nil when the
+// node is not a mapping or lacks the key.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node != nil && node.Kind == yaml.DocumentNode && len(node.Content) == 1 {
+		node = node.Content[0]
+	}
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -7,30 +7,47 @@ import (
 	"path/filepath"
 
 	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/config"
+	"github.com/acebelowzero/tmpl/internal/source"
 )
 
 func newInitCmd() *cobra.Command {
 	var chartName string
+	var starter string
 
 	cmd := &cobra.Command{
 		Use:   "init [DIRECTORY]",
 		Short: "Create a new tmpl chart skeleton",
-		Args:  cobra.MaximumNArgs(1),
+		Long: `Create a new tmpl chart skeleton.
+
+With --starter the skeleton is a packaged chart fetched from an OCI
+registry, git, S3 or HTTP, so an organization can distribute its own chart
+layout:
+
+  tmpl init --starter oci://registry/starters/webapp:1.0 --name api api
+
+"` + chart.StarterPlaceholder + `" is replaced by the chart name in every file of the
+starter. NAME/REPOSITORY:VERSION stands for the reference of registry NAME
+of the configuration; see 'tmpl config'.`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			target := "."
 			if len(args) == 1 {
 				target = args[0]
 			}
-			return runInit(cmd, target, chartName)
+			return runInit(cmd, target, chartName, starter)
 		},
 	}
 
 	cmd.Flags().StringVar(&chartName, "name", "example", "Chart name")
+	cmd.Flags().StringVar(&starter, "starter", "", "Packaged chart to use as skeleton (oci://, git+, s3:// or http(s):// URI)")
 
 	return cmd
 }
 
-func runInit(cmd *cobra.Command, dir, chartName string) error {
+func runInit(cmd *cobra.Command, dir, chartName, starter string) error {
 	dir = filepath.Clean(dir)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return fmt.Errorf("create chart directory: %w", err)
@@ -43,6 +60,10 @@ func runInit(cmd *cobra.Command, dir, chartName string) error {
 		return fmt.Errorf("check chart existence: %w", err)
 	}
 
+	if starter != "" {
+		return runInitStarter(cmd, dir, chartName, starter)
+	}
+
 	files := map[string]string{
 		"Chart.yaml": `apiVersion: v1
 name: ` + chartName + `
@@ -84,3 +105,23 @@ services:
 	fmt.Fprintf(cmd.OutOrStdout(), "Created chart skeleton in %s\n", dir)
 	return nil
 }
+
+// runInitStarter creates the chart in dir from a starter fetched through the
+// source factory.
+func runInitStarter(cmd *cobra.Command, dir, chartName, starter string) error {
+	ref := config.FromContext(cmd.Context()).ExpandRegistry(starter)
+	src, err := source.NewFactory().New(ref)
+	if err != nil {
+		return fmt.Errorf("starter: %w", err)
+	}
+	data, err := src.Fetch(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("fetch starter %s: %w", ref, err)
+	}
+	if err := chart.CreateFromStarter(data, dir, chartName); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Created chart %s in %s from starter %s\n", chartName, dir, ref)
+	return nil
+}
@@ -0,0 +1,201 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/lint"
+	"github.com/acebelowzero/tmpl/internal/policy"
+	"github.com/acebelowzero/tmpl/internal/timing"
+)
+
+func newLintCmd() *cobra.Command {
+	var valuesFiles []string
+	var envFiles []string
+	var failOn string
+	var severities map[string]string
+	var format string
+	var policies []string
+	var policyNamespace string
+	var fix bool
+
+	cmd := &cobra.Command{
+		Use:   "lint [CHART]",
+		Short: "Render a chart and check it against lint rules",
+		Long: `Render a chart and check templates and the rendered stack against lint rules.
+
+Findings can be silenced inline with "# tmpl-lint:disable [rule,...]" on the
+offending line or the line above it, or "# tmpl-lint:disable-file [rule,...]"
+anywhere in a template.
+
+With --fix, findings of the trailing-whitespace, yaml-tabs,
+document-separator and values-key-order rules are corrected in the chart
+files before the chart is checked; the rewritten files are reported.
+Suppressed findings are left as they are.
+
+Rule severities and --fail-on default to the lint settings of the chart's
+.tmpl.yaml and of the user configuration; see 'tmpl config'.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chart := "."
+			if len(args) == 1 {
+				chart = args[0]
+			}
+			if !cmd.Flags().Changed("fail-on") {
+				failOn = ""
+			}
+			cfg, threshold, err := lintSettings(cmd, chart, failOn, severities)
+			if err != nil {
+				return err
+			}
+			policyCfg := policy.Config{Paths: policies, Namespace: policyNamespace}
+			return runLint(cmd, chart, valuesFiles, envFiles, cfg, policyCfg, threshold, format, fix)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&valuesFiles, "values", "f", nil, "Values files")
+	cmd.Flags().StringSliceVar(&envFiles, "env-file", nil, "Environment files for value expansion")
+	cmd.Flags().StringVar(&failOn, "fail-on", "error", "Lowest severity that fails the command: info, warn or error")
+	cmd.Flags().StringToStringVar(&severities, "severity", nil, "Override rule severities, e.g. latest-tag=error,resource-limits=off")
+	cmd.Flags().StringVarP(&format, "output", "o", "text", "Output format: text or json")
+	cmd.Flags().StringSliceVar(&policies, "policy", nil, "Rego policy files or bundle directories evaluated with opa")
+	cmd.Flags().StringVar(&policyNamespace, "policy-namespace", policy.DefaultNamespace, "Rego package whose deny and warn rules are evaluated")
+	cmd.Flags().BoolVar(&fix, "fix", false, "Rewrite chart files to correct fixable findings")
+
+	return cmd
+}
+
+// lintSettings returns the lint configuration and failure threshold of the
+// chart: its settings and those of the user configuration, overridden by
+// failOn, unless empty, and severities.
+func lintSettings(cmd *cobra.Command, chart, failOn string, severities map[string]string) (lint.Config, lint.Severity, error) {
+	settings, err := chartSettings(cmd, chart)
+	if err != nil {
+		return lint.Config{}, lint.SeverityOff, err
+	}
+	if failOn == "" {
+		failOn = settings.Lint.FailOn
+	}
+	if failOn == "" {
+		failOn = "error"
+	}
+	threshold, err := lint.ParseSeverity(failOn)
+	if err != nil {
+		return lint.Config{}, lint.SeverityOff, fmt.Errorf("invalid --fail-on: %w", err)
+	}
+	levels := maps.Clone(settings.Lint.Severity)
+	if levels == nil {
+		levels = map[string]string{}
+	}
+	maps.Copy(levels, severities)
+	cfg := lint.Config{Severities: make(map[string]lint.Severity, len(levels))}
+	for rule, level := range levels {
+		sev, err := lint.ParseSeverity(level)
+		if err != nil {
+			return lint.Config{}, lint.SeverityOff, fmt.Errorf("invalid severity for rule %s: %w", rule, err)
+		}
+		cfg.Severities[rule] = sev
+	}
+	return cfg, threshold, nil
+}
+
+func runLint(cmd *cobra.Command, chart string, valuesFiles, envFiles []string, cfg lint.Config, policyCfg policy.Config, threshold lint.Severity, format string, fix bool) error {
+	linter, err := lint.New(cfg)
+	if err != nil {
+		return err
+	}
+	files, err := lint.ReadChartFiles(chart)
+	if err != nil {
+		return fmt.Errorf("read chart files: %w", err)
+	}
+	var fixed []lint.Fixed
+	if fix {
+		if fixed, err = linter.Fix(files); err != nil {
+			return err
+		}
+		for _, f := range fixed {
+			if err := os.WriteFile(filepath.Join(chart, filepath.FromSlash(f.File)), f.Data, 0o644); err != nil {
+				return fmt.Errorf("write %s: %w", f.File, err)
+			}
+			files[f.File] = f.Data
+		}
+	}
+
+	mergedValues, result, err := renderChart(cmd, chart, valuesFiles, envFiles)
+	if err != nil {
+		return err
+	}
+	input, err := lint.NewInput(mergedValues, result)
+	if err != nil {
+		return err
+	}
+	input.Files, input.Dir = files, chart
+	policyFindings, err := evaluatePolicies(cmd, policyCfg, input, chart, nil)
+	if err != nil {
+		return err
+	}
+	stop := timing.Track(cmd.Context(), timing.Validate)
+	report := linter.Run(input, policyFindings...)
+	stop()
+	report.Fixed = fixed
+
+	out := cmd.OutOrStdout()
+	switch format {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	default:
+		for _, f := range report.Fixed {
+			fmt.Fprintf(out, "Fixed %s: %s\n", f.File, strings.Join(f.Rules, ", "))
+		}
+		for _, f := range report.Findings {
+			fmt.Fprintln(out, f)
+		}
+		fmt.Fprintf(out, "%d error(s), %d warning(s), %d info, %d suppressed\n",
+			report.Count(lint.SeverityError), report.Count(lint.SeverityWarn), report.Count(lint.SeverityInfo), report.Suppressed)
+	}
+
+	if threshold != lint.SeverityOff && report.Max() >= threshold {
+		return withExit(ExitValidation, fmt.Errorf("lint failed: findings at or above %s", threshold))
+	}
+	return nil
+}
+
+// evaluatePolicies runs the configured policy bundles against the rendered
+// stack and merged values, and extra as input.extra. It is a no-op when no
+// policies are configured.
+func evaluatePolicies(cmd *cobra.Command, cfg policy.Config, input *lint.Input, chartPath string, extra map[string]any) ([]lint.Finding, error) {
+	if len(cfg.Paths) == 0 {
+		return nil, nil
+	}
+	defer timing.Track(cmd.Context(), timing.Validate)()
+	evaluator, err := policy.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("setup policy evaluator: %w", err)
+	}
+	meta, err := chart.LoadMetadata(chartPath)
+	if err != nil {
+		return nil, err
+	}
+	policyInput, err := lint.PolicyInput(input, meta)
+	if err != nil {
+		return nil, err
+	}
+	policyInput.Extra = extra
+	violations, err := evaluator.Evaluate(cmd.Context(), policyInput)
+	if err != nil {
+		return nil, fmt.Errorf("evaluate policies: %w", err)
+	}
+	return lint.PolicyFindings(input, violations), nil
+}
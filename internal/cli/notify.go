@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/audit"
+	"github.com/acebelowzero/tmpl/internal/config"
+	"github.com/acebelowzero/tmpl/internal/deploy"
+	"github.com/acebelowzero/tmpl/internal/logx"
+	"github.com/acebelowzero/tmpl/internal/notify"
+	"github.com/acebelowzero/tmpl/internal/release"
+)
+
+// notification posts the start and outcome of a deployment to the
+// configured webhooks. A nil notification posts nothing, for dry runs and
+// when no webhook is configured.
+type notification struct {
+	cmd      *cobra.Command
+	notifier *notify.Notifier
+	event    notify.Event
+	started  time.Time
+}
+
+// startNotification prepares the webhooks for operation, so a
+// misconfigured webhook fails the command before the swarm is changed.
+func startNotification(cmd *cobra.Command, operation string) (*notification, error) {
+	var hooks []notify.Webhook
+	for _, w := range config.FromContext(cmd.Context()).Notify.Webhooks {
+		hooks = append(hooks, notify.Webhook{URL: w.URL, Format: w.Format, Events: w.Events})
+	}
+	notifier, err := notify.New(hooks)
+	if err != nil {
+		return nil, withExit(ExitConfig, fmt.Errorf("notify.webhooks: %w", err))
+	}
+	if notifier == nil {
+		return nil, nil
+	}
+	return &notification{
+		cmd:      cmd,
+		notifier: notifier,
+		event: notify.Event{
+			Operation: operation,
+			Namespace: globalOptions(cmd.Context()).Namespace,
+			User:      audit.CurrentUser(),
+		},
+		started: time.Now(),
+	}, nil
+}
+
+// start posts that the changes of p are about to be made to rel.
+func (n *notification) start(rel *release.Release, p *deploy.Plan) {
+	if n == nil {
+		return
+	}
+	n.describe(rel)
+	n.event.Description = rel.Description
+	n.event.Plan = &notify.Plan{
+		Create:    p.Count(deploy.ActionCreate),
+		Update:    p.Count(deploy.ActionUpdate),
+		Delete:    p.Count(deploy.ActionDelete),
+		Unchanged: p.Count(deploy.ActionUnchanged),
+	}
+	for _, c := range p.Changes {
+		if c.Action != deploy.ActionUnchanged {
+			n.event.Plan.Changes = append(n.event.Plan.Changes, notify.Change{Action: string(c.Action), Kind: string(c.Kind), Name: c.Name})
+		}
+	}
+	n.send(notify.EventStart)
+}
+
+// finish posts the outcome err of the operation on the release name. rel
+// describes the revision it recorded and may be nil. Declined operations
+// are not posted, and failing to post is logged, not returned.
+func (n *notification) finish(name string, rel *release.Release, err error) {
+	if n == nil || errors.Is(err, errNotApproved) {
+		return
+	}
+	n.event.Release = name
+	if rel != nil {
+		n.describe(rel)
+		n.event.Revision = rel.Revision
+		// A failure is recorded in the description of the revision, and
+		// posted as the error.
+		if n.event.Description == "" && err == nil {
+			n.event.Description = rel.Description
+		}
+	}
+	n.event.DurationMS = time.Since(n.started).Milliseconds()
+	if err != nil {
+		n.event.Error = err.Error()
+		n.send(notify.EventFailure)
+		return
+	}
+	n.send(notify.EventSuccess)
+}
+
+func (n *notification) describe(rel *release.Release) {
+	n.event.Release = rel.Name
+	n.event.Chart, n.event.ChartVersion, n.event.AppVersion = rel.Chart.Name, rel.Chart.Version, rel.Chart.AppVersion
+	n.event.Annotations = rel.Annotations
+}
+
+func (n *notification) send(event string) {
+	e := n.event
+	e.Type, e.Time = event, time.Now().UTC()
+	ctx := context.WithoutCancel(n.cmd.Context())
+	if err := n.notifier.Send(ctx, &e); err != nil {
+		logx.FromContext(ctx).Warn("could not send notification", "event", event, "stack", e.Release, "error", err)
+	}
+}
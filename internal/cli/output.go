@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/logx"
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+)
+
+// useColor reports whether ANSI colors should be written to w by cmd:
+// never with --no-color, $NO_COLOR or TERM=dumb, otherwise only to a
+// terminal.
+func useColor(cmd *cobra.Command, w io.Writer) bool {
+	if globalOptions(cmd.Context()).NoColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(w)
+}
+
+// interactive reports whether cmd may prompt the user: not with
+// --non-interactive or in CI ($CI set), and only when stdin is a terminal.
+func interactive(cmd *cobra.Command) bool {
+	if globalOptions(cmd.Context()).NonInteractive || os.Getenv("CI") != "" {
+		return false
+	}
+	return isTerminal(cmd.InOrStdin())
+}
+
+// isTerminal reports whether v, a reader or writer, is a terminal.
+func isTerminal(v any) bool {
+	f, ok := v.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// logOutput returns the writer of --log-output: the standard error or
+// output of cmd, or the named file opened for appending. The file stays
+// open until tmpl exits.
+func logOutput(cmd *cobra.Command, output string) (io.Writer, error) {
+	switch output {
+	case "", "stderr":
+		return cmd.ErrOrStderr(), nil
+	case "stdout":
+		return cmd.OutOrStdout(), nil
+	}
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open log output: %w", err)
+	}
+	return f, nil
+}
+
+// logsToStderr sends logs written to --log-output stdout to the standard
+// error of cmd instead, for commands whose standard output is a document
+// or a stream of events.
+func logsToStderr(cmd *cobra.Command) {
+	if globalOptions(cmd.Context()).LogOutput == "stdout" {
+		cmd.SetContext(logx.WithWriter(cmd.Context(), cmd.ErrOrStderr()))
+	}
+}
+
+// envOr returns the value of the environment variable name, or def when
+// it is unset or empty.
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// withOptions attaches the global flags to ctx.
+func withOptions(ctx context.Context, opts *Options) context.Context {
+	return context.WithValue(ctx, optionsKey{}, opts)
+}
+
+// globalOptions returns the global flags attached to ctx, or the defaults.
+func globalOptions(ctx context.Context) *Options {
+	if ctx != nil {
+		if opts, ok := ctx.Value(optionsKey{}).(*Options); ok && opts != nil {
+			return opts
+		}
+	}
+	return &Options{}
+}
+
+type optionsKey struct{}
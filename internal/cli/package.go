@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/provenance"
+)
+
+func newPackageCmd() *cobra.Command {
+	var destination string
+	var sign string
+
+	cmd := &cobra.Command{
+		Use:   "package [CHART]",
+		Short: "Package a chart directory into a versioned archive",
+		Long: `Package a chart directory into a versioned archive, <name>-<version>.tgz.
+
+A provenance file, <archive>.prov, is written next to the archive. It records
+the digest of the archive, the git commit the chart was packaged from and
+what packaged it. --sign signs it into <archive>.prov.sig with cosign or gpg:
+
+  tmpl package --sign cosign:cosign.key
+  tmpl package --sign pgp:charts@acme.com
+
+push uploads the provenance with the archive, and pull and template check it
+with --verify.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) == 1 {
+				dir = args[0]
+			}
+			var key *provenance.Key
+			if sign != "" {
+				k, err := provenance.ParseKey(sign)
+				if err != nil {
+					return withExit(ExitConfig, fmt.Errorf("--sign: %w", err))
+				}
+				key = &k
+			}
+			if !cmd.Flags().Changed("destination") {
+				settings, err := chartSettings(cmd, dir)
+				if err != nil {
+					return err
+				}
+				if settings.Output.Dir != "" {
+					destination = settings.Output.Dir
+				}
+			}
+			archive, err := chart.Package(dir, destination)
+			if err != nil {
+				return fmt.Errorf("package chart: %w", err)
+			}
+			files, err := writeProvenance(cmd, dir, archive, key)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Packaged %s %s to %s\nDigest: %s\n",
+				archive.Metadata.Name, archive.Metadata.Version, archive.Path, archive.Digest)
+			if len(files.Signature) > 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "Signed provenance %s\n", provenance.SignaturePath(archive.Path))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&destination, "destination", "d", ".", "Directory to write the archive to (defaults to output.dir of the configuration, or .)")
+	cmd.Flags().StringVar(&sign, "sign", "", "Sign the provenance file with a key: cosign:KEY or pgp[:ID]")
+
+	return cmd
+}
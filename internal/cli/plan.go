@@ -0,0 +1,357 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/deploy"
+	"github.com/acebelowzero/tmpl/internal/diff"
+	"github.com/acebelowzero/tmpl/internal/lint"
+	"github.com/acebelowzero/tmpl/internal/policy"
+	"github.com/acebelowzero/tmpl/internal/release"
+	"github.com/acebelowzero/tmpl/internal/render"
+	"github.com/acebelowzero/tmpl/internal/stack"
+	"github.com/acebelowzero/tmpl/internal/timing"
+	"github.com/acebelowzero/tmpl/internal/values"
+)
+
+// planOptions holds the flags of the plan command.
+type planOptions struct {
+	valuesFiles     []string
+	envFiles        []string
+	stackName       string
+	render          renderFlags
+	out             string
+	format          string
+	policies        []string
+	policyNamespace string
+	prune           bool
+	store           string
+	docker          dockerOptions
+	update          updateOptions
+	services        deploy.Selector
+	skipImageCheck  bool
+	scan            scanOptions
+}
+
+func newPlanCmd() *cobra.Command {
+	opts := &planOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "plan [[RELEASE] CHART]",
+		Short: "Show the swarm changes apply would make",
+		Long: `Render a chart, query the live swarm and list the networks, configs,
+secrets and services that apply would create, update or delete.
+
+With --prune, objects removed from the chart are planned for removal when
+tmpl created them; apply of the saved plan then removes them.
+
+--only and --exclude plan changes to the selected services alone and keep
+the others at their live spec, as 'tmpl apply' does with the same flags.
+
+The release, which names the stack and its record in the release store,
+defaults to the chart name; 'tmpl plan RELEASE CHART' or --stack plans the
+chart as another release.
+
+Templates read the nodes, node labels, engine versions and resources of
+the swarm as .Capabilities; see docs/charts.md.
+
+The images of created and updated services must exist in their registries
+and be available for the platforms of the nodes their placement constraints
+allow, or the plan fails listing the missing images and node platforms.
+--skip-image-check skips this check, e.g. for images only present on the
+nodes.
+
+--pin-digests resolves image tags to the digests they point to in their
+registries and plans the services pinned to them, so applying a plan saved
+with --out deploys the images that were planned even when tags move.
+
+Configs and secrets swarm would reject, over 500 KiB or with invalid
+names, fail the plan listing them all.
+
+The plan can be saved with --out for review. When --policy is given the
+rendered stack and the plan, as input.extra.plan, are checked and deny
+rules fail the plan before it is saved.
+
+With --scan, or when scan.scanner is set in the user or chart
+configuration, the images of the stack are scanned for vulnerabilities
+with trivy or grype, or a trivy server set as scan.server. Findings at or
+above scan.warnOn (high) are printed as warnings and those at or above
+scan.failOn (critical), or --scan-fail-on, fail the plan. Vulnerability IDs
+listed in scan.ignore are accepted.`,
+		Args:              cobra.MaximumNArgs(2),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chartDir, err := releaseArgs(args, &opts.stackName)
+			if err != nil {
+				return err
+			}
+			if err := checkSelector(opts.services, opts.prune); err != nil {
+				return err
+			}
+			return runPlan(cmd, chartDir, opts)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&opts.valuesFiles, "values", "f", nil, "Values files")
+	cmd.Flags().StringSliceVar(&opts.envFiles, "env-file", nil, "Environment files for value expansion")
+	cmd.Flags().StringVar(&opts.stackName, "stack", "", "Release and stack name (defaults to the chart name)")
+	addRenderFlags(cmd, &opts.render)
+	cmd.Flags().BoolVar(&opts.prune, "prune", false, "Remove tmpl-owned objects that are no longer in the chart")
+	cmd.Flags().BoolVar(&opts.skipImageCheck, "skip-image-check", false, "Do not check that service images exist for the platforms of the swarm nodes")
+	cmd.Flags().StringVar(&opts.out, "out", "", "Write the plan to this file as JSON")
+	cmd.Flags().StringVarP(&opts.format, "output", "o", "text", "Output format: text or json")
+	cmd.Flags().StringSliceVar(&opts.policies, "policy", nil, "Rego policy files or bundle directories evaluated with opa")
+	cmd.Flags().StringVar(&opts.policyNamespace, "policy-namespace", policy.DefaultNamespace, "Rego package whose deny and warn rules are evaluated")
+	addScanFlags(cmd, &opts.scan)
+	addReleaseStoreFlag(cmd, &opts.store)
+	addUpdateFlags(cmd, &opts.update)
+	addSelectorFlags(cmd, &opts.services)
+	addDockerFlags(cmd, &opts.docker)
+
+	return cmd
+}
+
+func runPlan(cmd *cobra.Command, chartDir string, opts *planOptions) error {
+	client, err := newDockerClient(&opts.docker)
+	if err != nil {
+		return err
+	}
+	if opts.render.capabilities, err = swarmCapabilities(cmd.Context(), client); err != nil {
+		return err
+	}
+	built, err := buildStack(cmd, chartDir, opts.valuesFiles, opts.envFiles, opts.stackName, opts.render)
+	if err != nil {
+		return err
+	}
+	updates, err := opts.update.policy(cmd)
+	if err != nil {
+		return err
+	}
+	built.stack.SetUpdatePolicy(updates)
+
+	store, err := openReleaseStore(cmd, opts.store, client)
+	if err != nil {
+		return err
+	}
+	rel, err := newRelease(built)
+	if err != nil {
+		return err
+	}
+	if err := release.LabelOwner(cmd.Context(), store, rel); err != nil {
+		return err
+	}
+	p, err := deploy.New(client).Plan(cmd.Context(), built.stack, deploy.Options{Prune: opts.prune, Services: opts.services, CheckImages: !opts.skipImageCheck})
+	if err != nil {
+		return err
+	}
+	// The plan keeps the values of the revision apply records, without the
+	// content decrypted from sops files, and leaves out the stack, which
+	// it describes itself.
+	p.Release = rel
+	p.Release.Stack = nil
+	p.Release.Values = values.Redact(rel.Values, rel.Encrypted)
+	p.Release.UserValues = values.Redact(rel.UserValues, rel.Encrypted)
+	policyCfg := policy.Config{Paths: opts.policies, Namespace: opts.policyNamespace}
+	if err := checkPolicies(cmd, policyCfg, built.values, built.result, chartDir, p); err != nil {
+		return err
+	}
+	if err := scanImages(cmd, chartDir, built.stack, opts.scan); err != nil {
+		return err
+	}
+	if opts.out != "" {
+		if err := p.Write(opts.out); err != nil {
+			return err
+		}
+	}
+
+	w := cmd.OutOrStdout()
+	switch opts.format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p)
+	case "text":
+		if err := writePlan(w, p, useColor(cmd, w)); err != nil {
+			return err
+		}
+		if opts.out != "" {
+			fmt.Fprintf(w, "Plan saved to %s\n", opts.out)
+		}
+		return nil
+	default:
+		return withExit(ExitConfig, fmt.Errorf("unknown output format %q", opts.format))
+	}
+}
+
+// builtStack is a rendered chart converted into swarm objects.
+type builtStack struct {
+	chartDir  string
+	namespace string
+	values    map[string]any
+	// userValues are the values from -f files, without chart defaults.
+	userValues map[string]any
+	result     *render.Result
+	sources    []values.Fetched
+	// encrypted maps the values paths decrypted from sops files to them.
+	encrypted map[string]string
+	stack     *stack.Stack
+}
+
+// buildStack renders chartDir and converts the output into the swarm
+// objects of the stack of the named release, defaulting the name to the
+// chart name, with the given render flags. The stack is in the
+// namespace of the global options.
+func buildStack(cmd *cobra.Command, chartDir string, valuesFiles, envFiles []string, stackName string, flags renderFlags) (*builtStack, error) {
+	if err := flags.checkDeprecated(cmd, chartDir); err != nil {
+		return nil, err
+	}
+	cfg, err := loaderConfig(cmd, chartDir, envFiles)
+	if err != nil {
+		return nil, err
+	}
+	return buildStackWith(cmd.Context(), chartDir, cfg, valuesFiles, stackName, flags)
+}
+
+// buildStackWith is buildStack with a custom values loader configuration.
+func buildStackWith(ctx context.Context, chartDir string, cfg values.LoaderConfig, valuesFiles []string, stackName string, flags renderFlags) (*builtStack, error) {
+	if stackName == "" {
+		meta, err := chart.LoadMetadata(chartDir)
+		if err != nil {
+			return nil, err
+		}
+		stackName = meta.Name
+	}
+
+	namespace := globalOptions(ctx).Namespace
+	rcfg := flags.config(ctx, render.Config{ChartPath: chartDir, ReleaseName: stackName, Namespace: namespace})
+	mergedValues, result, loader, err := renderSourcesWith(ctx, rcfg, cfg, valuesFiles)
+	if err != nil {
+		return nil, err
+	}
+	stop := timing.Track(ctx, timing.Validate)
+	parsed, err := render.ParseStack(result.Output, result.SourceMap)
+	if err != nil {
+		stop()
+		return nil, withExit(ExitRender, err)
+	}
+	desired, err := stack.Convert(parsed, stack.Options{Name: stack.Namespaced(namespace, stackName), BaseDir: chartDir, Namespace: namespace})
+	stop()
+	if err != nil {
+		return nil, convertFailure(err)
+	}
+	return &builtStack{
+		chartDir:   chartDir,
+		namespace:  namespace,
+		values:     mergedValues,
+		userValues: loader.UserValues(),
+		result:     result,
+		sources:    loader.Fetched(),
+		encrypted:  loader.Encrypted(),
+		stack:      desired,
+	}, nil
+}
+
+// convertFailure classifies the error of converting a rendered stack:
+// configs and secrets swarm would reject are a validation failure,
+// anything else a render failure.
+func convertFailure(err error) error {
+	err = fmt.Errorf("convert stack: %w", err)
+	var invalid *stack.ObjectError
+	if errors.As(err, &invalid) {
+		return withExit(ExitValidation, err)
+	}
+	return withExit(ExitRender, err)
+}
+
+// checkPolicies evaluates policies against the rendered stack and the plan
+// computed for it, printing findings to stderr and failing on deny rules.
+func checkPolicies(cmd *cobra.Command, cfg policy.Config, mergedValues map[string]any, result *render.Result, chartDir string, p *deploy.Plan) error {
+	if len(cfg.Paths) == 0 {
+		return nil
+	}
+	input, err := lint.NewInput(mergedValues, result)
+	if err != nil {
+		return err
+	}
+	findings, err := evaluatePolicies(cmd, cfg, input, chartDir, map[string]any{"plan": p})
+	if err != nil {
+		return err
+	}
+	denied := 0
+	for _, f := range findings {
+		fmt.Fprintln(cmd.ErrOrStderr(), f)
+		if f.Severity >= lint.SeverityError {
+			denied++
+		}
+	}
+	if denied > 0 {
+		return withExit(ExitValidation, fmt.Errorf("%d policy violation(s)", denied))
+	}
+	return nil
+}
+
+func writePlan(w io.Writer, p *deploy.Plan, color bool) error {
+	fmt.Fprintf(w, "Stack %s on %s\n\n", p.Stack, p.Engine)
+	for _, c := range p.Changes {
+		var symbol, code, note string
+		switch c.Action {
+		case deploy.ActionCreate:
+			symbol, code = "+", ansiGreen
+		case deploy.ActionUpdate:
+			symbol, code = "~", ansiYellow
+		case deploy.ActionDelete:
+			symbol, code = "-", ansiRed
+			switch {
+			case c.ReplacedBy != "":
+				note = fmt.Sprintf(" (replaced by %s, removed after services are updated)", c.ReplacedBy)
+			case c.Prune:
+				note = " (no longer in the chart, pruned)"
+			case p.Prune:
+				note = " (no longer in the chart, not owned by the release and left in place)"
+			default:
+				note = " (no longer in the chart, left in place by apply without --prune)"
+			}
+		default:
+			if len(c.Warnings) == 0 {
+				continue
+			}
+			symbol = " "
+		}
+		line := fmt.Sprintf("%s %s %s %s%s", symbol, c.Action, c.Kind, c.Name, note)
+		if color && code != "" {
+			line = code + line + ansiReset
+		}
+		fmt.Fprintln(w, line)
+
+		var buf bytes.Buffer
+		if err := diff.Write(&buf, c.Diff, color); err != nil {
+			return err
+		}
+		for _, l := range strings.SplitAfter(buf.String(), "\n") {
+			if l != "" {
+				fmt.Fprint(w, "    "+l)
+			}
+		}
+		for _, warning := range c.Warnings {
+			fmt.Fprintf(w, "    warning: %s\n", warning)
+		}
+	}
+	if !p.HasChanges() {
+		fmt.Fprintln(w, "No changes")
+	}
+	if len(p.Skipped) > 0 {
+		fmt.Fprintf(w, "\nNot selected, left as they are: %s\n", strings.Join(p.Skipped, ", "))
+	}
+	_, err := fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to delete, %d unchanged\n",
+		p.Count(deploy.ActionCreate), p.Count(deploy.ActionUpdate), p.Count(deploy.ActionDelete), p.Count(deploy.ActionUnchanged))
+	return err
+}
@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/acebelowzero/tmpl/internal/config"
+	"github.com/acebelowzero/tmpl/internal/paths"
+	"github.com/acebelowzero/tmpl/internal/plugin"
+	"github.com/acebelowzero/tmpl/internal/version"
+)
+
+// addPluginCmds registers a sub-command for every discovered plugin whose
+// name is not taken by a built-in command. Discovery problems are reported
+// on stderr without failing the built-in commands.
+func addPluginCmds(root *cobra.Command, opts *Options) {
+	plugins, err := plugin.Discover()
+	if err != nil {
+		fmt.Fprintf(root.ErrOrStderr(), "warning: %v\n", err)
+	}
+	taken := map[string]bool{"help": true, "completion": true}
+	for _, sub := range root.Commands() {
+		taken[sub.Name()] = true
+		for _, alias := range sub.Aliases {
+			taken[alias] = true
+		}
+	}
+	for _, p := range plugins {
+		if !taken[p.Name] {
+			root.AddCommand(newPluginCmd(p, opts))
+		}
+	}
+}
+
+func newPluginCmd(p plugin.Plugin, opts *Options) *cobra.Command {
+	short := p.Usage + " (plugin)"
+	if p.Usage == "" {
+		short = "Run the plugin " + p.Command
+	}
+	// Flag parsing is left to the plugin, so tmpl only takes the global
+	// flags that come before the plugin's own arguments.
+	var pluginArgs []string
+	return &cobra.Command{
+		Use:                p.Name + " [ARGS...]",
+		Short:              short,
+		DisableFlagParsing: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			rest, err := parseGlobalFlags(cmd.Root().PersistentFlags(), args)
+			if err != nil {
+				return withExit(ExitConfig, err)
+			}
+			pluginArgs = rest
+			return cmd.Root().PersistentPreRunE(cmd, rest)
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+			configPath, err := config.Path()
+			if err != nil {
+				return err
+			}
+			cacheDir, err := paths.CacheDir()
+			if err != nil {
+				return err
+			}
+			binary, _ := os.Executable()
+			input := &plugin.Context{
+				APIVersion: plugin.APIVersion,
+				Name:       p.Name,
+				Args:       pluginArgs,
+				Binary:     binary,
+				Version:    version.Version,
+				Options: map[string]any{
+					"logLevel":       opts.LogLevel,
+					"logFormat":      opts.LogFormat,
+					"logOutput":      opts.LogOutput,
+					"quiet":          opts.Quiet,
+					"noColor":        opts.NoColor,
+					"nonInteractive": opts.NonInteractive,
+					"commandTimeout": opts.Timeout.String(),
+				},
+				Config:     config.FromContext(ctx),
+				ConfigPath: configPath,
+				CacheDir:   cacheDir,
+			}
+			env := []string{
+				"TMPL_PLUGIN_NAME=" + p.Name,
+				"TMPL_BIN=" + binary,
+				"TMPL_LOG_LEVEL=" + opts.LogLevel,
+				"TMPL_LOG_FORMAT=" + opts.LogFormat,
+				"TMPL_LOG_OUTPUT=" + opts.LogOutput,
+				"TMPL_QUIET=" + strconv.FormatBool(opts.Quiet),
+				"TMPL_NO_COLOR=" + strconv.FormatBool(opts.NoColor),
+				"TMPL_NON_INTERACTIVE=" + strconv.FormatBool(opts.NonInteractive),
+			}
+			if opts.NoColor {
+				env = append(env, "NO_COLOR=1")
+			}
+			if opts.Timeout > 0 {
+				env = append(env, "TMPL_COMMAND_TIMEOUT="+opts.Timeout.String())
+			}
+
+			err = plugin.Run(ctx, p, input, env, cmd.OutOrStdout(), cmd.ErrOrStderr())
+			var exit *plugin.ExitError
+			if errors.As(err, &exit) {
+				return withExit(exit.Code, err)
+			}
+			return err
+		},
+	}
+}
+
+// parseGlobalFlags sets the leading flags of args that belong to flags and
+// returns the remaining arguments, which start at the first argument that
+// is not a global flag. A "--" ends the global flags and is dropped.
+func parseGlobalFlags(flags *pflag.FlagSet, args []string) ([]string, error) {
+	for len(args) > 0 {
+		arg := args[0]
+		if arg == "--" {
+			return args[1:], nil
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			return args, nil
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		var flag *pflag.Flag
+		if strings.HasPrefix(arg, "--") {
+			flag = flags.Lookup(name)
+		} else if len(name) == 1 {
+			flag = flags.ShorthandLookup(name)
+		}
+		if flag == nil {
+			return args, nil
+		}
+		args = args[1:]
+		if !hasValue {
+			if flag.NoOptDefVal != "" {
+				value = flag.NoOptDefVal
+			} else if len(args) > 0 {
+				value, args = args[0], args[1:]
+			} else {
+				return nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+		}
+		if err := flags.Set(flag.Name, value); err != nil {
+			return nil, fmt.Errorf("invalid argument %q for %s: %w", value, arg, err)
+		}
+	}
+	return args, nil
+}
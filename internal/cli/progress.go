@@ -0,0 +1,252 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/deploy"
+	"github.com/acebelowzero/tmpl/internal/logx"
+)
+
+// progressKinds orders the groups of the progress tree like apply orders
+// its changes.
+var progressKinds = []deploy.Kind{deploy.KindNetwork, deploy.KindConfig, deploy.KindSecret, deploy.KindService}
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// progressState is the state of one change of the progress tree.
+type progressState int
+
+const (
+	progressPending progressState = iota
+	progressRunning
+	progressDone
+	progressFailed
+	progressSkipped
+)
+
+type progressItem struct {
+	kind     deploy.Kind
+	name     string
+	action   deploy.Action
+	state    progressState
+	started  time.Time
+	duration time.Duration
+}
+
+// progress shows the changes of an apply as they are made. On a terminal
+// it redraws a tree of the changes grouped by kind, with a spinner on the
+// running ones, and erases it when the apply ends so that the result is
+// printed as without it. Elsewhere every change made is logged; skipped and
+// failed changes are logged by the deployer.
+type progress struct {
+	w     io.Writer
+	color bool
+	stack string
+
+	mu    sync.Mutex
+	items []*progressItem
+	frame int
+	lines int
+	// ended is set by stop; later steps are ignored.
+	ended bool
+	// logs holds an incomplete log line written while the tree is shown.
+	logs bytes.Buffer
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// startProgress reports the changes deployer makes for p and returns the
+// context to apply with and the func that ends the report. Nothing is
+// shown for plans without changes.
+func startProgress(cmd *cobra.Command, deployer *deploy.Deployer, p *deploy.Plan) (context.Context, func()) {
+	ctx := cmd.Context()
+	if !p.HasChanges() {
+		return ctx, func() {}
+	}
+	w := cmd.OutOrStdout()
+	if !isTerminal(w) {
+		log := logx.FromContext(ctx)
+		deployer.Observe(func(s deploy.Step) {
+			if !s.Running && !s.Skipped && s.Err == nil {
+				log.Info("change applied", "stack", p.Stack, "action", s.Change.Action, "kind", s.Change.Kind, "name", s.Change.Name, "duration", s.Duration.Round(time.Millisecond).String())
+			}
+		})
+		return ctx, func() {}
+	}
+
+	v := &progress{w: w, color: useColor(cmd, w), stack: p.Stack, done: make(chan struct{}), stopped: make(chan struct{})}
+	for _, c := range p.Changes {
+		if c.Action != deploy.ActionUnchanged {
+			v.items = append(v.items, &progressItem{kind: c.Kind, name: c.Name, action: c.Action})
+		}
+	}
+	deployer.Observe(v.observe)
+	// Log lines to the same terminal would scroll the tree; they are
+	// printed above it instead.
+	if output := globalOptions(ctx).LogOutput; output == "stdout" || (output == "stderr" && isTerminal(cmd.ErrOrStderr())) {
+		ctx = logx.WithWriter(ctx, v)
+	}
+	v.mu.Lock()
+	v.draw()
+	v.mu.Unlock()
+	go v.spin()
+	return ctx, v.stop
+}
+
+func (v *progress) observe(s deploy.Step) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.ended {
+		return
+	}
+	item := v.item(s.Change)
+	switch {
+	case s.Running:
+		item.state, item.started = progressRunning, time.Now()
+	case s.Err != nil:
+		item.state, item.duration = progressFailed, s.Duration
+	case s.Skipped:
+		item.state = progressSkipped
+	default:
+		item.state, item.duration = progressDone, s.Duration
+	}
+	v.draw()
+}
+
+// item returns the item of c, adding it when the apply makes a change the
+// plan did not show.
+func (v *progress) item(c deploy.Change) *progressItem {
+	for _, item := range v.items {
+		if item.kind == c.Kind && item.name == c.Name && item.action == c.Action {
+			return item
+		}
+	}
+	item := &progressItem{kind: c.Kind, name: c.Name, action: c.Action}
+	v.items = append(v.items, item)
+	return item
+}
+
+func (v *progress) spin() {
+	defer close(v.stopped)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-v.done:
+			return
+		case <-ticker.C:
+			v.mu.Lock()
+			v.frame++
+			v.draw()
+			v.mu.Unlock()
+		}
+	}
+}
+
+// stop erases the tree and prints log lines still buffered.
+func (v *progress) stop() {
+	close(v.done)
+	<-v.stopped
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.ended = true
+	v.erase()
+	if v.logs.Len() > 0 {
+		v.logs.WriteByte('\n')
+		_, _ = v.w.Write(v.logs.Bytes())
+		v.logs.Reset()
+	}
+}
+
+// Write prints complete log lines above the tree.
+func (v *progress) Write(data []byte) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.ended {
+		return v.w.Write(data)
+	}
+	v.logs.Write(data)
+	i := bytes.LastIndexByte(v.logs.Bytes(), '\n')
+	if i < 0 {
+		return len(data), nil
+	}
+	v.erase()
+	_, _ = v.w.Write(v.logs.Next(i + 1))
+	v.draw()
+	return len(data), nil
+}
+
+// erase moves the cursor to the first line of the tree and clears it
+// and the lines below.
+func (v *progress) erase() {
+	if v.lines > 0 {
+		fmt.Fprintf(v.w, "\x1b[%dF\x1b[J", v.lines)
+		v.lines = 0
+	}
+}
+
+func (v *progress) draw() {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Applying stack %s\n", v.stack)
+	lines := 1
+	for _, kind := range progressKinds {
+		var group []*progressItem
+		for _, item := range v.items {
+			if item.kind == kind {
+				group = append(group, item)
+			}
+		}
+		if len(group) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "  %ss\n", kind)
+		lines++
+		for _, item := range group {
+			b.WriteString("    ")
+			b.WriteString(v.symbol(item))
+			fmt.Fprintf(&b, " %s %s", item.action, item.name)
+			switch item.state {
+			case progressRunning:
+				fmt.Fprintf(&b, " (%s)", time.Since(item.started).Round(time.Second))
+			case progressDone, progressFailed:
+				fmt.Fprintf(&b, " (%s)", item.duration.Round(10*time.Millisecond))
+			case progressSkipped:
+				b.WriteString(" (left in place)")
+			}
+			b.WriteByte('\n')
+			lines++
+		}
+	}
+	v.erase()
+	_, _ = io.WriteString(v.w, b.String())
+	v.lines = lines
+}
+
+func (v *progress) symbol(item *progressItem) string {
+	var symbol, code string
+	switch item.state {
+	case progressPending:
+		symbol = "·"
+	case progressRunning:
+		symbol = spinnerFrames[v.frame%len(spinnerFrames)]
+	case progressDone:
+		symbol, code = "✓", ansiGreen
+	case progressFailed:
+		symbol, code = "✗", ansiRed
+	case progressSkipped:
+		symbol, code = "-", ansiYellow
+	}
+	if v.color && code != "" {
+		return code + symbol + ansiReset
+	}
+	return symbol
+}
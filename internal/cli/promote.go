@@ -0,0 +1,211 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/deploy"
+	"github.com/acebelowzero/tmpl/internal/hook"
+	"github.com/acebelowzero/tmpl/internal/release"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// promoteOptions holds the flags of the promote command.
+type promoteOptions struct {
+	from             string
+	to               string
+	fromContext      string
+	store            string
+	dryRun           bool
+	wait             bool
+	timeout          time.Duration
+	noHooks          bool
+	prune            bool
+	autoApprove      bool
+	allowDestructive bool
+	lockTimeout      time.Duration
+	historyMax       int
+	docker           dockerOptions
+}
+
+func newPromoteCmd() *cobra.Command {
+	opts := &promoteOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "promote --from RELEASE --to RELEASE",
+		Short: "Deploy the deployed revision of one release as another release",
+		Long: `Deploy exactly what a release runs as another release, for promoting a
+tested staging deployment to production:
+
+  tmpl promote --from staging --to prod
+
+Nothing is rendered again. The stored stack of the deployed revision of
+--from is applied under the name of --to, so images, configs, secrets and
+service specs are identical; only the names of stack objects change. The
+new revision of --to records the same manifest, values and pinned chart
+and values source revisions as the promoted one.
+
+With --from-context the release is read from another docker context, such
+as a staging swarm, and deployed to the swarm of --context or --host. The
+release store is selected with --release-store for both.
+
+The changes are shown and promote asks for confirmation like apply;
+--dry-run stops after showing them. The pre-apply and post-apply hooks of
+the promoted revision run unless --no-hooks is set.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.from == "" || opts.to == "" {
+				return withExit(ExitConfig, errors.New("--from and --to are required"))
+			}
+			for _, name := range []string{opts.from, opts.to} {
+				if !releaseName.MatchString(name) {
+					return withExit(ExitConfig, fmt.Errorf("invalid release name %q: use letters, digits, '_', '.' and '-'", name))
+				}
+			}
+			if opts.from == opts.to && opts.fromContext == "" {
+				return withExit(ExitConfig, errors.New("--from and --to name the same release; use --from-context to promote between swarms"))
+			}
+			return withExit(ExitApply, runPromote(cmd, opts))
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.from, "from", "", "Release whose deployed revision is promoted")
+	cmd.Flags().StringVar(&opts.to, "to", "", "Release to deploy it as")
+	cmd.Flags().StringVar(&opts.fromContext, "from-context", "", "Docker context of the swarm running --from (defaults to the target swarm)")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "Show the changes without applying them")
+	cmd.Flags().BoolVar(&opts.wait, "wait", false, "Wait until all services have converged")
+	cmd.Flags().DurationVar(&opts.timeout, "timeout", 5*time.Minute, "How long --wait waits for convergence")
+	cmd.Flags().BoolVar(&opts.noHooks, "no-hooks", false, "Do not run chart hooks")
+	cmd.Flags().BoolVar(&opts.prune, "prune", false, "Remove tmpl-owned objects of --to that are not in the promoted revision")
+	cmd.Flags().BoolVar(&opts.autoApprove, "auto-approve", false, "Apply without asking for confirmation")
+	cmd.Flags().BoolVar(&opts.allowDestructive, "allow-destructive", false, "Allow removing services that mount named volumes")
+	addReleaseStoreFlag(cmd, &opts.store)
+	addLockFlag(cmd, &opts.lockTimeout)
+	addHistoryMaxFlag(cmd, &opts.historyMax)
+	addDockerFlags(cmd, &opts.docker)
+	_ = cmd.RegisterFlagCompletionFunc("from", completeReleases(&opts.store, &opts.docker))
+	_ = cmd.RegisterFlagCompletionFunc("to", completeReleases(&opts.store, &opts.docker))
+	_ = cmd.RegisterFlagCompletionFunc("from-context", completeContexts)
+
+	return cmd
+}
+
+func runPromote(cmd *cobra.Command, opts *promoteOptions) (err error) {
+	client, err := newDockerClient(&opts.docker)
+	if err != nil {
+		return err
+	}
+	store, err := openReleaseStore(cmd, opts.store, client)
+	if err != nil {
+		return err
+	}
+	sourceStore := store
+	if opts.fromContext != "" {
+		sourceClient, err := newDockerClient(&dockerOptions{context: opts.fromContext})
+		if err != nil {
+			return err
+		}
+		if sourceStore, err = openReleaseStore(cmd, opts.store, sourceClient); err != nil {
+			return err
+		}
+	}
+
+	from, to := namespaced(cmd, opts.from), namespaced(cmd, opts.to)
+	source, err := deployedRelease(cmd, sourceStore, from)
+	if err != nil {
+		return err
+	}
+	if err := release.Open(cmd.Context(), source); err != nil {
+		return withExit(ExitConfig, err)
+	}
+	if source.Stack == nil {
+		return fmt.Errorf("revision %d of %s has no stored stack to promote", source.Revision, from)
+	}
+	var rel *release.Release
+	if !opts.dryRun {
+		audited, aerr := startAudit(cmd, "promote")
+		if aerr != nil {
+			return aerr
+		}
+		defer func() { audited.finish(to, rel, err) }()
+		unlock, err := lockRelease(cmd, store, to, "promote", opts.lockTimeout)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	namespace := globalOptions(cmd.Context()).Namespace
+	rel = &release.Release{
+		Name:         to,
+		Namespace:    namespace,
+		Chart:        source.Chart,
+		ChartDigest:  source.ChartDigest,
+		ValuesDigest: source.ValuesDigest,
+		Sources:      source.Sources,
+		Manifest:     source.Manifest,
+		Notes:        source.Notes,
+		Values:       source.Values,
+		UserValues:   source.UserValues,
+		Encrypted:    source.Encrypted,
+		Hooks:        source.Hooks,
+		Stack:        source.Stack.Rename(to, source.Namespace, namespace),
+		Description:  fmt.Sprintf("Promoted from %s revision %d", from, source.Revision),
+	}
+	if err := release.LabelOwner(cmd.Context(), store, rel); err != nil {
+		return err
+	}
+
+	deployer := deploy.New(client)
+	p, err := deployer.Plan(cmd.Context(), rel.Stack, deploy.Options{Prune: opts.prune})
+	if err != nil {
+		return err
+	}
+	w := cmd.OutOrStdout()
+	fmt.Fprintf(w, "Promoting %s revision %d (%s-%s) to %s\n", from, source.Revision, source.Chart.Name, source.Chart.Version, to)
+	if opts.dryRun {
+		return writePlan(w, p, useColor(cmd, w))
+	}
+	if err := checkDestructive(p, opts.allowDestructive); err != nil {
+		return err
+	}
+	if err := confirmPlan(cmd, p, opts.autoApprove); err != nil {
+		return err
+	}
+	if !opts.noHooks {
+		if err := runHooks(cmd, deployer, rel, hook.PreApply); err != nil {
+			return err
+		}
+	}
+
+	secrets, err := secretPayloads(p, rel.Stack, func() (*stack.Stack, error) {
+		rendered, err := renderRevision(cmd.Context(), source)
+		if err != nil {
+			return nil, err
+		}
+		return rendered.Rename(to, source.Namespace, namespace), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	started := time.Now()
+	ctx, stop := startProgress(cmd, deployer, p)
+	applied, err := deployer.ApplyPlan(ctx, p, secrets)
+	stop()
+	if err := recordRelease(cmd, store, rel, applied, err, opts.historyMax); err != nil {
+		return err
+	}
+	if opts.wait {
+		if err := waitForRelease(cmd, deployer, store, rel, started, opts.timeout); err != nil {
+			return err
+		}
+	}
+	if opts.noHooks {
+		return nil
+	}
+	return runPostHooks(cmd, deployer, store, rel, hook.PostApply)
+}
@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/provenance"
+	"github.com/acebelowzero/tmpl/internal/version"
+)
+
+// verifyOptions holds the flags verifying the provenance of a chart.
+type verifyOptions struct {
+	enabled bool
+	key     string
+}
+
+func addVerifyFlags(cmd *cobra.Command, opts *verifyOptions) {
+	cmd.Flags().BoolVar(&opts.enabled, "verify", false, "Verify the signed provenance of the chart before using it")
+	cmd.Flags().StringVar(&opts.key, "verify-key", "", "Key the provenance must be signed with: cosign:PUBLIC_KEY or pgp:ID (default: any key of the gpg keyring)")
+}
+
+// verify checks the provenance files of the chart archive with digest,
+// named what in messages, when --verify is set.
+func (o verifyOptions) verify(cmd *cobra.Command, what string, files provenance.Files, digest string) error {
+	var key *provenance.Key
+	if o.key != "" {
+		k, err := provenance.ParseKey(o.key)
+		if err != nil {
+			return withExit(ExitConfig, fmt.Errorf("--verify-key: %w", err))
+		}
+		key = &k
+	}
+	p, err := files.Verify(cmd.Context(), digest, key)
+	if err != nil {
+		return withExit(ExitValidation, fmt.Errorf("verify %s: %w", what, err))
+	}
+	msg := fmt.Sprintf("Verified %s %s", p.Chart.Name, p.Chart.Version)
+	if p.Source != nil {
+		msg += " built from " + p.Source.Commit
+		if p.Source.Dirty {
+			msg += " with uncommitted changes"
+		}
+	}
+	fmt.Fprintln(cmd.ErrOrStderr(), msg)
+	return nil
+}
+
+// writeProvenance writes the provenance file of a packaged chart from
+// dir next to it, signed with key unless it is nil.
+func writeProvenance(cmd *cobra.Command, dir string, archive *chart.Archive, key *provenance.Key) (provenance.Files, error) {
+	p := &provenance.Provenance{
+		Chart:   provenance.Chart{Name: archive.Metadata.Name, Version: archive.Metadata.Version, Digest: archive.Digest},
+		Source:  gitSource(cmd, dir),
+		Builder: provenance.Builder{Name: "tmpl", Version: version.Version, Platform: runtime.GOOS + "/" + runtime.GOARCH},
+		Created: time.Now().UTC().Truncate(time.Second),
+	}
+	p.Builder.Host, _ = os.Hostname()
+	data, err := p.Marshal()
+	if err != nil {
+		return provenance.Files{}, err
+	}
+	files := provenance.Files{Provenance: data}
+	if key != nil {
+		if files.Signature, err = provenance.Sign(cmd.Context(), *key, data); err != nil {
+			return provenance.Files{}, err
+		}
+	}
+	if err := files.Write(archive.Path); err != nil {
+		return provenance.Files{}, err
+	}
+	return files, nil
+}
+
+// gitSource describes the git checkout holding dir, or returns nil when
+// there is none.
+func gitSource(cmd *cobra.Command, dir string) *provenance.Source {
+	commit, err := git(cmd, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil
+	}
+	source := &provenance.Source{Commit: commit}
+	if remote, err := git(cmd, dir, "config", "--get", "remote.origin.url"); err == nil {
+		// Credentials in the remote URL must not be published.
+		if u, err := url.Parse(remote); err == nil && u.User != nil {
+			u.User = nil
+			remote = u.String()
+		}
+		source.Repository = remote
+	}
+	if status, err := git(cmd, dir, "status", "--porcelain", "--", "."); err == nil && status != "" {
+		source.Dirty = true
+	}
+	return source
+}
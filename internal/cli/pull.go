@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/config"
+	"github.com/acebelowzero/tmpl/internal/oci"
+	"github.com/acebelowzero/tmpl/internal/provenance"
+)
+
+func newPullCmd() *cobra.Command {
+	var destination string
+	var untar bool
+	var verify verifyOptions
+
+	cmd := &cobra.Command{
+		Use:   "pull oci://REGISTRY/REPOSITORY:VERSION",
+		Short: "Download a packaged chart from an OCI registry",
+		Long: `Download a packaged chart from an OCI registry. NAME/REPOSITORY:VERSION
+stands for the reference of registry NAME of the configuration; see
+'tmpl config'.
+
+The provenance of the chart, when it was pushed with one, is written next to
+the archive. --verify fails unless the provenance describes the archive and
+is signed, by the key of --verify-key when one is given.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPull(cmd, args[0], destination, untar, verify)
+		},
+	}
+
+	cmd.Flags().StringVarP(&destination, "destination", "d", ".", "Directory to write the chart to")
+	cmd.Flags().BoolVar(&untar, "untar", false, "Extract the chart instead of writing the archive")
+	addVerifyFlags(cmd, &verify)
+
+	return cmd
+}
+
+func runPull(cmd *cobra.Command, ref, destination string, untar bool, verify verifyOptions) error {
+	ref = config.FromContext(cmd.Context()).ExpandRegistry(ref)
+	if !strings.HasPrefix(ref, oci.Scheme) {
+		return fmt.Errorf("reference %s must start with %s", ref, oci.Scheme)
+	}
+	artifact, err := oci.Pull(cmd.Context(), ref)
+	if err != nil {
+		return err
+	}
+	if artifact.Manifest.ArtifactType != "" && artifact.Manifest.ArtifactType != oci.ArtifactTypeChart {
+		return fmt.Errorf("%s is not a tmpl chart (artifact type %s)", ref, artifact.Manifest.ArtifactType)
+	}
+	var files provenance.Files
+	if files.Provenance, err = oci.PullLayer(cmd.Context(), artifact, oci.MediaTypeProvenance); err != nil {
+		return err
+	}
+	if files.Signature, err = oci.PullLayer(cmd.Context(), artifact, oci.MediaTypeProvenanceSignature); err != nil {
+		return err
+	}
+	if verify.enabled {
+		if files.Provenance == nil {
+			return withExit(ExitValidation, fmt.Errorf("verify %s: chart has no provenance", ref))
+		}
+		if err := verify.verify(cmd, ref, files, chart.Digest(artifact.Data)); err != nil {
+			return err
+		}
+	}
+
+	// Extract next to the destination when untarring so the final rename
+	// stays on one filesystem.
+	tmpRoot := ""
+	if untar {
+		if err := ensureDir(destination, defaultFileModes.dir); err != nil {
+			return err
+		}
+		tmpRoot = destination
+	}
+	dir, err := os.MkdirTemp(tmpRoot, ".tmpl-pull-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if _, err := chart.Extract(artifact.Data, dir); err != nil {
+		return err
+	}
+	meta, err := chart.LoadMetadata(dir)
+	if err != nil {
+		return err
+	}
+
+	var target string
+	if untar {
+		target = filepath.Join(destination, meta.Name)
+		if _, err := os.Stat(target); err == nil {
+			return fmt.Errorf("%s already exists", target)
+		}
+		if err := os.Rename(dir, target); err != nil {
+			return fmt.Errorf("move chart into place: %w", err)
+		}
+	} else {
+		target = filepath.Join(destination, chart.ArchiveName(meta))
+		if err := writeFile(target, defaultFileModes, artifact.Data); err != nil {
+			return err
+		}
+		if files.Provenance != nil {
+			if err := files.Write(target); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Pulled %s to %s\nDigest: %s\n", ref, target, artifact.Digest)
+	return nil
+}
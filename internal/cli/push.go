@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/config"
+	"github.com/acebelowzero/tmpl/internal/oci"
+	"github.com/acebelowzero/tmpl/internal/provenance"
+)
+
+func newPushCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "push ARCHIVE oci://REGISTRY/REPOSITORY",
+		Short: "Push a packaged chart to an OCI registry",
+		Long: `Push a packaged chart to an OCI registry. The chart version is used as the
+tag unless the reference already carries one. Credentials are read from the
+docker configuration. The provenance file package wrote next to the archive,
+and its signature, are pushed with it.
+
+A registry named in the configuration can stand in for its reference, so
+with registries.acme set to oci://ghcr.io/acme/charts, "acme/web" pushes to
+oci://ghcr.io/acme/charts/web.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPush(cmd, args[0], args[1])
+		},
+	}
+	return cmd
+}
+
+func runPush(cmd *cobra.Command, archivePath, ref string) error {
+	ref = config.FromContext(cmd.Context()).ExpandRegistry(ref)
+	if !strings.HasPrefix(ref, oci.Scheme) {
+		return fmt.Errorf("reference %s must start with %s", ref, oci.Scheme)
+	}
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		return fmt.Errorf("read archive: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "tmpl-push-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	chartDir, err := chart.Extract(data, dir)
+	if err != nil {
+		return err
+	}
+	meta, err := chart.LoadMetadata(chartDir)
+	if err != nil {
+		return err
+	}
+
+	target := strings.TrimSuffix(ref, "/")
+	if !hasTag(target) {
+		target += ":" + meta.Version
+	}
+	var layers []oci.Layer
+	files, err := provenance.ReadFiles(archivePath)
+	switch {
+	case err == nil:
+		layers = append(layers, oci.Layer{MediaType: oci.MediaTypeProvenance, Data: files.Provenance})
+		if len(files.Signature) > 0 {
+			layers = append(layers, oci.Layer{MediaType: oci.MediaTypeProvenanceSignature, Data: files.Signature})
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	desc, err := oci.PushChart(cmd.Context(), target, data, meta, chartAnnotations(meta), layers...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Pushed %s\nDigest: %s\n", target, desc.Digest)
+	return nil
+}
+
+// chartAnnotations describes a chart with the predefined OCI manifest
+// annotations.
+func chartAnnotations(meta *chart.Metadata) map[string]string {
+	annotations := map[string]string{
+		"org.opencontainers.image.title":   meta.Name,
+		"org.opencontainers.image.version": meta.Version,
+	}
+	if meta.Description != "" {
+		annotations["org.opencontainers.image.description"] = meta.Description
+	}
+	if meta.Home != "" {
+		annotations["org.opencontainers.image.url"] = meta.Home
+	}
+	if len(meta.Sources) > 0 {
+		annotations["org.opencontainers.image.source"] = meta.Sources[0]
+	}
+	if len(meta.Maintainers) > 0 {
+		authors := make([]string, len(meta.Maintainers))
+		for i, m := range meta.Maintainers {
+			authors[i] = m.String()
+		}
+		annotations["org.opencontainers.image.authors"] = strings.Join(authors, ", ")
+	}
+	return annotations
+}
+
+// hasTag reports whether an OCI reference ends in a tag or digest.
+func hasTag(ref string) bool {
+	ref = strings.TrimPrefix(ref, oci.Scheme)
+	if strings.Contains(ref, "@") {
+		return true
+	}
+	last := ref[strings.LastIndex(ref, "/")+1:]
+	return strings.Contains(last, ":")
+}
@@ -0,0 +1,273 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/config"
+	"github.com/acebelowzero/tmpl/internal/deploy"
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/hook"
+	"github.com/acebelowzero/tmpl/internal/logx"
+	"github.com/acebelowzero/tmpl/internal/release"
+	"github.com/acebelowzero/tmpl/internal/render"
+	"github.com/acebelowzero/tmpl/internal/revision"
+	"github.com/acebelowzero/tmpl/internal/sops"
+	"github.com/acebelowzero/tmpl/internal/stack"
+	"github.com/acebelowzero/tmpl/internal/values"
+)
+
+// releaseName is what a release name may contain: it prefixes the names
+// of the swarm objects of its stack.
+var releaseName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// releaseArgs splits the [[RELEASE] CHART] arguments of apply and plan and
+// returns the chart. A single argument is the chart; a release name given
+// as argument is stored in stackName, the value of --stack, which it may
+// not be combined with.
+func releaseArgs(args []string, stackName *string) (string, error) {
+	chartDir := "."
+	switch len(args) {
+	case 1:
+		chartDir = args[0]
+	case 2:
+		if *stackName != "" {
+			return "", withExit(ExitConfig, errors.New("a release name argument cannot be combined with --stack"))
+		}
+		*stackName, chartDir = args[0], args[1]
+	}
+	if *stackName != "" && !releaseName.MatchString(*stackName) {
+		return "", withExit(ExitConfig, fmt.Errorf("invalid release name %q: use letters, digits, '_', '.' and '-'", *stackName))
+	}
+	return chartDir, nil
+}
+
+// namespaced returns the stack of the release name in the namespace of the
+// global options.
+func namespaced(cmd *cobra.Command, name string) string {
+	return stack.Namespaced(globalOptions(cmd.Context()).Namespace, name)
+}
+
+// inNamespace returns the revisions of releases deployed in the namespace
+// of the global options, or all of them without a namespace.
+func inNamespace(cmd *cobra.Command, releases []*release.Release) []*release.Release {
+	namespace := globalOptions(cmd.Context()).Namespace
+	if namespace == "" {
+		return releases
+	}
+	var out []*release.Release
+	for _, r := range releases {
+		if r.Namespace == namespace {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// addReleaseStoreFlag registers --release-store, defaulting to
+// $TMPL_RELEASE_STORE.
+func addReleaseStoreFlag(cmd *cobra.Command, location *string) {
+	cmd.Flags().StringVar(location, "release-store", os.Getenv("TMPL_RELEASE_STORE"),
+		"Where release history is kept: swarm (default), file, file://DIR or s3://BUCKET/PREFIX")
+	_ = cmd.RegisterFlagCompletionFunc("release-store", completeReleaseStores)
+}
+
+func openReleaseStore(cmd *cobra.Command, location string, client *docker.Client) (release.Store, error) {
+	keys := release.EncryptKeys(config.FromContext(cmd.Context()).Releases.Encrypt)
+	if err := sops.ValidateKeys(keys); err != nil {
+		return nil, withExit(ExitConfig, fmt.Errorf("release encryption: %w", err))
+	}
+	return release.New(cmd.Context(), release.Config{Location: location, Docker: client, Encrypt: keys})
+}
+
+// addHistoryMaxFlag registers --history-max, defaulting to
+// $TMPL_HISTORY_MAX.
+func addHistoryMaxFlag(cmd *cobra.Command, historyMax *int) {
+	cmd.Flags().IntVar(historyMax, "history-max", historyMaxFromEnv(), "Revisions kept per release, older ones are deleted (0 keeps all)")
+}
+
+func historyMaxFromEnv() int {
+	n, _ := strconv.Atoi(os.Getenv("TMPL_HISTORY_MAX"))
+	return n
+}
+
+// newRelease describes a built stack as a release revision to record.
+func newRelease(built *builtStack) (*release.Release, error) {
+	return revision.New(revision.Rendered{
+		ChartDir:   built.chartDir,
+		Namespace:  built.namespace,
+		Result:     built.result,
+		Values:     built.values,
+		UserValues: built.userValues,
+		Encrypted:  built.encrypted,
+		Fetched:    built.sources,
+		Stack:      built.stack,
+	})
+}
+
+// secretPayloads returns the payloads of the secrets p creates for the
+// desired stack of a recorded revision. Revisions do not store them:
+// unless desired still holds them, as revisions recorded before payloads
+// were left out do, they are rendered again with rendered and must match
+// the digests recorded in desired.
+func secretPayloads(p *deploy.Plan, desired *stack.Stack, rendered func() (*stack.Stack, error)) (map[string]docker.ObjectSpec, error) {
+	var missing []string
+	for _, c := range p.Changes {
+		if c.Kind == deploy.KindSecret && c.Action == deploy.ActionCreate && desired.Secrets[c.Name].Data == nil {
+			missing = append(missing, c.Name)
+		}
+	}
+	if len(missing) == 0 {
+		return desired.Secrets, nil
+	}
+	restored, err := rendered()
+	if err != nil {
+		return nil, fmt.Errorf("restore secrets %s: %w", strings.Join(missing, ", "), err)
+	}
+	secrets := maps.Clone(desired.Secrets)
+	for _, name := range missing {
+		spec, ok := restored.Secrets[name]
+		if !ok {
+			return nil, fmt.Errorf("restore secret %s: the chart no longer renders it", name)
+		}
+		if want := desired.SecretDigest(name); want != "" && stack.Digest(spec.Data) != want {
+			return nil, fmt.Errorf("restore secret %s: the chart renders other content than was recorded", name)
+		}
+		secrets[name] = spec
+	}
+	return secrets, nil
+}
+
+// renderRevision renders the chart of rel again from the directory it was
+// applied from, with the values of rel, those decrypted from sops files
+// read again, and converts it into the stack of rel.
+func renderRevision(ctx context.Context, rel *release.Release) (*stack.Stack, error) {
+	var dir string
+	for _, s := range rel.Sources {
+		if s.Kind == "chart" {
+			dir = s.URL
+		}
+	}
+	if info, err := os.Stat(dir); dir == "" || err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("the chart of revision %d is not available at %q", rel.Revision, dir)
+	}
+	vals, err := values.Decrypt(ctx, rel.Values, rel.Encrypted)
+	if err != nil {
+		return nil, err
+	}
+	renderer, err := render.New(render.Config{
+		ChartPath:   dir,
+		ReleaseName: strings.TrimPrefix(rel.Name, stack.Namespaced(rel.Namespace, "")),
+		Namespace:   rel.Namespace,
+	})
+	if err != nil {
+		return nil, err
+	}
+	result, err := renderer.Render(ctx, vals)
+	if err != nil {
+		return nil, fmt.Errorf("render templates: %w", err)
+	}
+	parsed, err := render.ParseStack(result.Output, result.SourceMap)
+	if err != nil {
+		return nil, err
+	}
+	return stack.Convert(parsed, stack.Options{Name: rel.Name, BaseDir: dir, Namespace: rel.Namespace})
+}
+
+// unencryptedWarning prints the warning of warnUnencrypted once.
+var unencryptedWarning sync.Once
+
+// warnUnencrypted warns, once, when rel holds values decrypted from sops
+// files and store does not encrypt revisions: the values are left out, but
+// what templates render from them outside secrets is stored as rendered.
+func warnUnencrypted(cmd *cobra.Command, store release.Store, rel *release.Release) {
+	if len(rel.Encrypted) == 0 || release.Encrypting(store) {
+		return
+	}
+	unencryptedWarning.Do(func() {
+		fmt.Fprintf(cmd.ErrOrStderr(), "warning: releases are stored unencrypted: values decrypted from sops files are left out of revisions, "+
+			"but what templates render from them into service environments, configs, hooks and notes is stored as rendered; "+
+			"set releases.encrypt or $%s to store revisions encrypted\n", release.EnvEncrypt)
+	})
+}
+
+// runHooks runs the hooks of rel registered for event. Command hooks run
+// from the chart directory the release was rendered from.
+func runHooks(cmd *cobra.Command, deployer *deploy.Deployer, rel *release.Release, event hook.Event) error {
+	hooks, err := hook.ParseAll(rel.Hooks)
+	if err != nil {
+		return err
+	}
+	dir := "."
+	for _, s := range rel.Sources {
+		if s.Kind != "chart" {
+			continue
+		}
+		if info, err := os.Stat(s.URL); err == nil && info.IsDir() {
+			dir = s.URL
+		}
+	}
+	runner := hook.New(hook.Config{
+		Deployer: deployer,
+		Stack:    rel.Name,
+		Dir:      dir,
+		Stdout:   cmd.OutOrStdout(),
+		Stderr:   cmd.ErrOrStderr(),
+	})
+	return runner.Run(cmd.Context(), event, hooks)
+}
+
+// runPostHooks runs the post hooks of a recorded release and marks the
+// revision failed when one of them aborts.
+func runPostHooks(cmd *cobra.Command, deployer *deploy.Deployer, store release.Store, rel *release.Release, event hook.Event) error {
+	if err := runHooks(cmd, deployer, rel, event); err != nil {
+		if serr := store.SetStatus(cmd.Context(), rel.Name, rel.Revision, release.StatusFailed); serr != nil {
+			logx.FromContext(cmd.Context()).Warn("could not mark release failed", "stack", rel.Name, "error", serr)
+		}
+		return fmt.Errorf("stack %s: %w", rel.Name, err)
+	}
+	return nil
+}
+
+// addLockFlag registers --lock-timeout.
+func addLockFlag(cmd *cobra.Command, timeout *time.Duration) {
+	cmd.Flags().DurationVar(timeout, "lock-timeout", 5*time.Minute, "How long to wait for another operation on the release to finish")
+}
+
+// lockRelease takes the lock of a release for operation, waiting up to
+// timeout. The returned function releases it and logs failures to do so.
+func lockRelease(cmd *cobra.Command, store release.Store, name, operation string, timeout time.Duration) (func(), error) {
+	info := release.NewLockInfo(operation)
+	err := store.Lock(cmd.Context(), name, info)
+	if err == nil {
+		return logUnlock(cmd, name, func() error { return store.Unlock(context.WithoutCancel(cmd.Context()), name) }), nil
+	}
+	var locked *release.LockedError
+	if !errors.As(err, &locked) || timeout <= 0 {
+		return nil, err
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Waiting up to %s for the lock of release %s held by %s\n", timeout, name, locked.Info.Holder)
+	unlock, err := release.Acquire(cmd.Context(), store, name, info, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return logUnlock(cmd, name, unlock), nil
+}
+
+func logUnlock(cmd *cobra.Command, name string, unlock func() error) func() {
+	return func() {
+		if err := unlock(); err != nil {
+			logx.FromContext(cmd.Context()).Warn("could not release lock", "stack", name, "error", err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/repo"
+)
+
+func newRepoCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repo",
+		Short: "Manage chart repositories",
+	}
+
+	cmd.AddCommand(newRepoAddCmd())
+	cmd.AddCommand(newRepoUpdateCmd())
+	cmd.AddCommand(newRepoListCmd())
+	cmd.AddCommand(newRepoRemoveCmd())
+	cmd.AddCommand(newRepoIndexCmd())
+
+	return cmd
+}
+
+func newRepoAddCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "add NAME URL",
+		Short: "Add a chart repository",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manager, err := repo.NewManager()
+			if err != nil {
+				return err
+			}
+			if err := manager.Add(cmd.Context(), args[0], args[1]); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Repository %s added\n", args[0])
+			return nil
+		},
+	}
+}
+
+func newRepoUpdateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:               "update [NAME...]",
+		ValidArgsFunction: completeRepositories,
+		Short:             "Refresh cached repository indexes",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manager, err := repo.NewManager()
+			if err != nil {
+				return err
+			}
+			if err := manager.Update(cmd.Context(), args...); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Repository indexes updated")
+			return nil
+		},
+	}
+}
+
+func newRepoListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List configured chart repositories",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manager, err := repo.NewManager()
+			if err != nil {
+				return err
+			}
+			f, err := manager.Load()
+			if err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tURL")
+			for _, r := range f.Repositories {
+				fmt.Fprintf(tw, "%s\t%s\n", r.Name, r.URL)
+			}
+			return tw.Flush()
+		},
+	}
+}
+
+func newRepoRemoveCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:               "remove NAME",
+		Aliases:           []string{"rm"},
+		Short:             "Remove a chart repository",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRepositories,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manager, err := repo.NewManager()
+			if err != nil {
+				return err
+			}
+			if err := manager.Remove(args[0]); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Repository %s removed\n", args[0])
+			return nil
+		},
+	}
+}
+
+func newRepoIndexCmd() *cobra.Command {
+	var url string
+
+	cmd := &cobra.Command{
+		Use:   "index DIR",
+		Short: "Generate an index.yaml for the packaged charts in a directory",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			idx, err := repo.IndexDir(args[0], url)
+			if err != nil {
+				return err
+			}
+			data, err := idx.Marshal()
+			if err != nil {
+				return err
+			}
+			path := filepath.Join(args[0], repo.IndexFile)
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				return fmt.Errorf("write %s: %w", path, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Index written to %s\n", path)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&url, "url", "", "Base URL prefixed to chart archive names")
+
+	return cmd
+}
@@ -0,0 +1,235 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/compose"
+	"github.com/acebelowzero/tmpl/internal/deploy"
+	"github.com/acebelowzero/tmpl/internal/hook"
+	"github.com/acebelowzero/tmpl/internal/release"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// rollbackOptions holds the flags of the rollback command.
+type rollbackOptions struct {
+	store            string
+	dryRun           bool
+	wait             bool
+	timeout          time.Duration
+	noHooks          bool
+	prune            bool
+	allowDestructive bool
+	lockTimeout      time.Duration
+	historyMax       int
+	docker           dockerOptions
+}
+
+func newRollbackCmd() *cobra.Command {
+	opts := &rollbackOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "rollback STACK [REVISION]",
+		Short: "Restore a stack to a previously recorded revision",
+		Long: `Restore a stack to a recorded revision, the previous successful one by
+default. The changes against the live swarm are shown before the stored
+stack is applied; --dry-run stops after the preview. Configs of the
+revision that no longer exist are recreated from the stored content.
+Secret payloads are not stored: secrets that no longer exist are rendered
+again from the chart directory the revision was applied from, with its
+values and the sops files its encrypted values were read from, and must
+match the digests recorded for them.
+
+The pre-rollback and post-rollback hooks of the target revision run around
+the rollback unless --no-hooks is set.
+
+The webhooks of notify.webhooks in the user configuration are notified when
+the rollback starts and when it succeeded or failed.`,
+		Args:              cobra.RangeArgs(1, 2),
+		ValidArgsFunction: completeRevisions(&opts.store, &opts.docker),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			revision := 0
+			if len(args) == 2 {
+				rev, err := strconv.Atoi(args[1])
+				if err != nil || rev < 1 {
+					return withExit(ExitConfig, fmt.Errorf("invalid revision %q", args[1]))
+				}
+				revision = rev
+			}
+			return withExit(ExitApply, runRollback(cmd, namespaced(cmd, args[0]), revision, opts))
+		},
+	}
+
+	addReleaseStoreFlag(cmd, &opts.store)
+	addLockFlag(cmd, &opts.lockTimeout)
+	addHistoryMaxFlag(cmd, &opts.historyMax)
+	addDockerFlags(cmd, &opts.docker)
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "Show the changes without applying them")
+	cmd.Flags().BoolVar(&opts.wait, "wait", false, "Wait until all services have converged")
+	cmd.Flags().DurationVar(&opts.timeout, "timeout", 5*time.Minute, "How long --wait waits for convergence")
+	cmd.Flags().BoolVar(&opts.noHooks, "no-hooks", false, "Do not run chart hooks")
+	cmd.Flags().BoolVar(&opts.prune, "prune", false, "Remove tmpl-owned objects that are not in the revision")
+	cmd.Flags().BoolVar(&opts.allowDestructive, "allow-destructive", false, "Allow removing services that mount named volumes")
+
+	return cmd
+}
+
+func runRollback(cmd *cobra.Command, name string, revision int, opts *rollbackOptions) (err error) {
+	client, err := newDockerClient(&opts.docker)
+	if err != nil {
+		return err
+	}
+	store, err := openReleaseStore(cmd, opts.store, client)
+	if err != nil {
+		return err
+	}
+	var rel *release.Release
+	var notified *notification
+	if !opts.dryRun {
+		audited, aerr := startAudit(cmd, "rollback")
+		if aerr != nil {
+			return aerr
+		}
+		defer func() { audited.finish(name, rel, err) }()
+		if notified, err = startNotification(cmd, "rollback"); err != nil {
+			return err
+		}
+		defer func() { notified.finish(name, rel, err) }()
+		unlock, err := lockRelease(cmd, store, name, "rollback", opts.lockTimeout)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	target, err := rollbackTarget(cmd, store, name, revision)
+	if err != nil {
+		return err
+	}
+	if err := release.Open(cmd.Context(), target); err != nil {
+		return withExit(ExitConfig, err)
+	}
+	if target.Stack == nil {
+		return fmt.Errorf("revision %d of %s has no stored stack", target.Revision, name)
+	}
+
+	// Revisions recorded before manifests were redacted may still carry
+	// secret content.
+	manifest, err := compose.Redact([]byte(target.Manifest))
+	if err != nil {
+		return fmt.Errorf("redact secrets: %w", err)
+	}
+	rel = &release.Release{
+		Name:         name,
+		Chart:        target.Chart,
+		ChartDigest:  target.ChartDigest,
+		ValuesDigest: target.ValuesDigest,
+		Sources:      target.Sources,
+		Manifest:     string(manifest),
+		Notes:        target.Notes,
+		Values:       target.Values,
+		UserValues:   target.UserValues,
+		Encrypted:    target.Encrypted,
+		Hooks:        target.Hooks,
+		Stack:        target.Stack,
+		Description:  fmt.Sprintf("Rollback to %d", target.Revision),
+	}
+	if err := release.LabelOwner(cmd.Context(), store, rel); err != nil {
+		return err
+	}
+
+	deployer := deploy.New(client)
+	p, err := deployer.Plan(cmd.Context(), rel.Stack, deploy.Options{Prune: opts.prune})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Rolling back %s to revision %d (%s-%s)\n", name, target.Revision, target.Chart.Name, target.Chart.Version)
+	if err := writePlan(cmd.OutOrStdout(), p, useColor(cmd, cmd.OutOrStdout())); err != nil {
+		return err
+	}
+	if opts.dryRun {
+		return nil
+	}
+	if err := checkDestructive(p, opts.allowDestructive); err != nil {
+		return err
+	}
+	notified.start(rel, p)
+	if !opts.noHooks {
+		if err := runHooks(cmd, deployer, rel, hook.PreRollback); err != nil {
+			return err
+		}
+	}
+
+	secrets, err := secretPayloads(p, rel.Stack, func() (*stack.Stack, error) {
+		return renderRevision(cmd.Context(), target)
+	})
+	if err != nil {
+		return err
+	}
+
+	started := time.Now()
+	ctx, stop := startProgress(cmd, deployer, p)
+	applied, err := deployer.ApplyPlan(ctx, p, secrets)
+	stop()
+	if err == nil {
+		if err := markRolledBack(cmd, store, name); err != nil {
+			return err
+		}
+	}
+	if err := recordRelease(cmd, store, rel, applied, err, opts.historyMax); err != nil {
+		return err
+	}
+	if opts.wait {
+		if err := waitForRelease(cmd, deployer, store, rel, started, opts.timeout); err != nil {
+			return err
+		}
+	}
+	if opts.noHooks {
+		return nil
+	}
+	return runPostHooks(cmd, deployer, store, rel, hook.PostRollback)
+}
+
+// rollbackTarget returns the requested revision, or the newest successful
+// revision before the current one when revision is zero. A release
+// uninstalled with its history kept is restored to its last revision.
+func rollbackTarget(cmd *cobra.Command, store release.Store, name string, revision int) (*release.Release, error) {
+	if revision > 0 {
+		return store.Get(cmd.Context(), name, revision)
+	}
+	releases, err := store.List(cmd.Context(), name)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(releases); n > 0 && releases[n-1].Status == release.StatusUninstalled {
+		return releases[n-1], nil
+	}
+	if len(releases) < 2 {
+		return nil, fmt.Errorf("stack %s has no previous revision to roll back to", name)
+	}
+	for i := len(releases) - 2; i >= 0; i-- {
+		if releases[i].Status != release.StatusFailed {
+			return releases[i], nil
+		}
+	}
+	return nil, fmt.Errorf("stack %s has no previous successful revision", name)
+}
+
+// markRolledBack flags the currently deployed revisions of a stack as
+// rolled back, so they are not reported as merely superseded.
+func markRolledBack(cmd *cobra.Command, store release.Store, name string) error {
+	releases, err := store.List(cmd.Context(), name)
+	if err != nil {
+		return err
+	}
+	for _, r := range releases {
+		if r.Status == release.StatusDeployed {
+			if err := store.SetStatus(cmd.Context(), name, r.Revision, release.StatusRolledBack); err != nil {
+				return fmt.Errorf("mark revision %d of %s rolled back: %w", r.Revision, name, err)
+			}
+		}
+	}
+	return nil
+}
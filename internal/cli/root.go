@@ -3,17 +3,45 @@ package cli
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/acebelowzero/tmpl/internal/config"
+	"github.com/acebelowzero/tmpl/internal/dump"
 	"github.com/acebelowzero/tmpl/internal/logx"
+	"github.com/acebelowzero/tmpl/internal/paths"
+	"github.com/acebelowzero/tmpl/internal/timing"
 )
 
 // Options hold global CLI flags propagated to sub-commands.
 type Options struct {
 	LogLevel string
+	// LogFormat is json or text, and LogOutput stderr, stdout or the
+	// path of a file logs are appended to.
+	LogFormat string
+	LogOutput string
+	// Quiet discards the standard output of commands and logs below
+	// the error level, leaving only errors.
+	Quiet bool
+	// NoColor disables ANSI colors even on a terminal.
+	NoColor bool
+	// NonInteractive fails instead of prompting, as when stdin is not a
+	// terminal.
+	NonInteractive bool
+	// Timeout bounds the whole command; zero means no limit.
+	Timeout time.Duration
+	// Timings prints the time spent in each phase when a command ends.
+	Timings bool
+	// Namespace prefixes the stacks of releases and labels their objects,
+	// so that several teams can share a swarm.
+	Namespace string
+	// Debug writes the artifacts of renders to .tmpl-debug/ even when
+	// the command succeeds.
+	Debug bool
 }
 
 // NewRootCmd constructs the root command, wiring in all sub-commands.
@@ -22,9 +50,17 @@ func NewRootCmd(ctx context.Context, opts *Options) *cobra.Command {
 		opts = &Options{}
 	}
 
+	var cancel context.CancelFunc
 	cmd := &cobra.Command{
-		Use:          "tmpl",
-		Short:        "Render and manage Docker Swarm stacks from Helm-like charts",
+		Use:   "tmpl",
+		Short: "Render and manage Docker Swarm stacks from Helm-like charts",
+		Long: `Render and manage Docker Swarm stacks from Helm-like charts.
+
+Exit codes are 0 on success, 2 for invalid flags or configuration, 3 when
+a chart fails to render, 4 for failed checks, 5 for drift and 6 or 7 when a
+deploy fails. docs/operations.md lists them and describes logs, audit
+sinks, debug dumps, telemetry and plugins; docs/releases.md covers
+namespaces and the encryption of releases at rest.`,
 		SilenceUsage: true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			level := strings.TrimSpace(opts.LogLevel)
@@ -34,28 +70,113 @@ func NewRootCmd(ctx context.Context, opts *Options) *cobra.Command {
 			if level == "" {
 				level = "info"
 			}
-			logger := logx.New(level)
-			cmd.SetContext(logx.WithContext(cmd.Context(), logger))
+			format, err := logx.ParseFormat(opts.LogFormat)
+			if err != nil {
+				return withExit(ExitConfig, err)
+			}
+			logs, err := logOutput(cmd, opts.LogOutput)
+			if err != nil {
+				return withExit(ExitConfig, err)
+			}
+			if opts.Quiet {
+				level = "error"
+				cmd.SetOut(io.Discard)
+			}
+			logx.SensitiveEnv()
+			logger := logx.New(level, format, logs)
+			ctx := withOptions(logx.WithContext(cmd.Context(), logger), opts)
+			if opts.Timings {
+				ctx = timing.WithContext(ctx, timing.New())
+			}
+			ctx = dump.WithContext(ctx, dump.New())
+			if opts.Timeout > 0 {
+				ctx, cancel = context.WithTimeoutCause(ctx, opts.Timeout, &timeoutError{after: opts.Timeout})
+			}
+
+			if opts.Namespace != "" && !releaseName.MatchString(opts.Namespace) {
+				return withExit(ExitConfig, fmt.Errorf("invalid namespace %q: use letters, digits, '_', '.' and '-'", opts.Namespace))
+			}
+
+			path, err := config.Path()
+			if err != nil {
+				return err
+			}
+			settings, err := config.Load(path)
+			if err != nil {
+				return withExit(ExitConfig, err)
+			}
+			paths.SetCacheDir(settings.Cache.Dir)
+			cmd.SetContext(config.WithContext(ctx, settings))
 			return nil
 		},
+		PersistentPostRun: func(cmd *cobra.Command, args []string) {
+			if cancel != nil {
+				cancel()
+			}
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return fmt.Errorf("no command specified; run 'tmpl --help' for usage")
+			return withExit(ExitConfig, fmt.Errorf("no command specified; run 'tmpl --help' for usage"))
 		},
 	}
 
 	cmd.PersistentFlags().StringVar(&opts.LogLevel, "log-level", "info", "Log level (debug, info, warn, error)")
+	cmd.PersistentFlags().StringVar(&opts.LogFormat, "log-format", envOr("TMPL_LOG_FORMAT", logx.FormatJSON), "Log format: json or text (also $TMPL_LOG_FORMAT)")
+	cmd.PersistentFlags().StringVar(&opts.LogOutput, "log-output", envOr("TMPL_LOG_OUTPUT", "stderr"), "Where logs go: stderr, stdout or a file to append to (also $TMPL_LOG_OUTPUT)")
+	cmd.PersistentFlags().BoolVarP(&opts.Quiet, "quiet", "q", false, "Print only errors")
+	cmd.PersistentFlags().BoolVar(&opts.NoColor, "no-color", false, "Disable colored output (also $NO_COLOR)")
+	cmd.PersistentFlags().BoolVar(&opts.NonInteractive, "non-interactive", false, "Never prompt; fail where input would be needed (also $CI)")
+	cmd.PersistentFlags().DurationVar(&opts.Timeout, "command-timeout", 0, "Cancel the command after this long, e.g. 10m (default: no limit)")
+	cmd.PersistentFlags().BoolVar(&opts.Timings, "timings", false, "Print the time spent in each phase to stderr when the command ends")
+	cmd.PersistentFlags().BoolVar(&opts.Debug, "debug", false, "Write the merged values, expanded environment and template outputs of renders to "+dump.Dir+"/, not only on render failures; they may hold secrets")
+	cmd.PersistentFlags().StringVarP(&opts.Namespace, "namespace", "n", os.Getenv("TMPL_NAMESPACE"), "Namespace of the releases to act on, prefixing their stacks (also $TMPL_NAMESPACE)")
 
 	// Register sub-commands
 	cmd.AddCommand(newInitCmd())
 	cmd.AddCommand(newTemplateCmd())
 	cmd.AddCommand(newLintCmd())
+	cmd.AddCommand(newVerifyCmd())
+	cmd.AddCommand(newSchemaCmd())
+	cmd.AddCommand(newDocsCmd())
+	cmd.AddCommand(newExplainCmd())
+	cmd.AddCommand(newValuesCmd())
+	cmd.AddCommand(newDebugCmd())
+	cmd.AddCommand(newImagesCmd())
+	cmd.AddCommand(newSBOMCmd())
+	cmd.AddCommand(newGraphCmd())
+	cmd.AddCommand(newDiffCmd())
+	cmd.AddCommand(newBenchCmd())
+	cmd.AddCommand(newPackageCmd())
+	cmd.AddCommand(newPushCmd())
+	cmd.AddCommand(newPullCmd())
+	cmd.AddCommand(newDependencyCmd())
+	cmd.AddCommand(newRepoCmd())
+	cmd.AddCommand(newSearchCmd())
 	cmd.AddCommand(newPlanCmd())
 	cmd.AddCommand(newApplyCmd())
 	cmd.AddCommand(newRollbackCmd())
+	cmd.AddCommand(newPromoteCmd())
+	cmd.AddCommand(newAdoptCmd())
+	cmd.AddCommand(newImportCmd())
+	cmd.AddCommand(newUnlockCmd())
+	cmd.AddCommand(newUninstallCmd())
 	cmd.AddCommand(newHistoryCmd())
+	cmd.AddCommand(newDriftCmd())
+	cmd.AddCommand(newStatusCmd())
 	cmd.AddCommand(newGetCmd())
+	cmd.AddCommand(newServeCmd())
+	cmd.AddCommand(newConfigCmd())
 	cmd.AddCommand(newVersionCmd())
 	cmd.AddCommand(newDoctorCmd())
+	addPluginCmds(cmd, opts)
+
+	cmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return withExit(ExitConfig, err)
+	})
+	classifyArgErrors(cmd)
+	reportTimeouts(cmd)
+	traceCommands(cmd)
+	reportTimings(cmd)
+	writeDebugDumps(cmd)
 
 	return cmd
 }
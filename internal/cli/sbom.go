@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/render"
+	"github.com/acebelowzero/tmpl/internal/sbom"
+	"github.com/acebelowzero/tmpl/internal/version"
+)
+
+func newSBOMCmd() *cobra.Command {
+	var valuesFiles []string
+	var envFiles []string
+	var stackName string
+	var flags renderFlags
+	var format string
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "sbom [CHART]",
+		Short: "Write a software bill of materials of a rendered chart",
+		Long: `Render a chart and describe the stack as a software bill of materials for
+compliance pipelines: the chart with its version and digest, the library
+charts it depends on, and the image of every service.
+
+--format selects CycloneDX (1.5) or SPDX (2.3) JSON. Images are identified
+by package URLs, pkg:oci for images pinned to a digest and pkg:docker
+otherwise; with --pin-digests every image is resolved to its digest first.`,
+		Example: `  tmpl sbom ./charts/web -f values-prod.yaml -o web.cdx.json
+  tmpl sbom --format spdx --pin-digests`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chartDir := "."
+			if len(args) == 1 {
+				chartDir = args[0]
+			}
+			if format != sbom.FormatCycloneDX && format != sbom.FormatSPDX {
+				return withExit(ExitConfig, fmt.Errorf("unknown --format %q, must be %s or %s", format, sbom.FormatCycloneDX, sbom.FormatSPDX))
+			}
+			if err := flags.checkDeprecated(cmd, chartDir); err != nil {
+				return err
+			}
+			meta, err := chart.LoadMetadata(chartDir)
+			if err != nil {
+				return withExit(ExitConfig, err)
+			}
+			digest, err := chart.DirDigest(chartDir)
+			if err != nil {
+				return err
+			}
+			libs, err := chart.Libraries(chartDir)
+			if err != nil {
+				return withExit(ExitConfig, err)
+			}
+			rcfg := flags.config(cmd.Context(), render.Config{ChartPath: chartDir, ReleaseName: stackName, Namespace: globalOptions(cmd.Context()).Namespace})
+			images, err := renderImages(cmd, rcfg, valuesFiles, envFiles)
+			if err != nil {
+				return err
+			}
+
+			s := sbom.Stack{
+				Name:  stackName,
+				Chart: sbom.Chart{Name: meta.Name, Version: meta.Version, AppVersion: meta.AppVersion, Digest: digest},
+			}
+			if s.Name == "" {
+				s.Name = meta.Name
+			}
+			for _, lib := range libs {
+				s.Dependencies = append(s.Dependencies, sbom.Chart{Name: lib.Metadata.Name, Version: lib.Metadata.Version, AppVersion: lib.Metadata.AppVersion})
+			}
+			index := map[string]int{}
+			for _, i := range images {
+				if i.Image == "" {
+					continue
+				}
+				n, ok := index[i.Image]
+				if !ok {
+					n = len(s.Images)
+					index[i.Image] = n
+					s.Images = append(s.Images, sbom.Image{Ref: i.Image})
+				}
+				s.Images[n].Services = append(s.Images[n].Services, i.Service)
+			}
+
+			write := func(w io.Writer) error {
+				return sbom.Write(w, format, s, sbom.Tool{Name: "tmpl", Version: version.Version}, time.Now())
+			}
+			if output == "-" {
+				logsToStderr(cmd)
+				return write(cmd.OutOrStdout())
+			}
+			if err := writeFileWith(output, defaultFileModes, write); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "SBOM written to %s\n", output)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&valuesFiles, "values", "f", nil, "Values files")
+	cmd.Flags().StringSliceVar(&envFiles, "env-file", nil, "Environment files for value expansion")
+	cmd.Flags().StringVar(&stackName, "stack", "", "Release name seen as .Release.Name (defaults to the chart name)")
+	cmd.Flags().StringVar(&format, "format", sbom.FormatCycloneDX, "Document format: cyclonedx or spdx")
+	cmd.Flags().StringVarP(&output, "output", "o", "-", "File to write the document to, or - for stdout")
+	addRenderFlags(cmd, &flags)
+
+	return cmd
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/scan"
+	"github.com/acebelowzero/tmpl/internal/stack"
+	"github.com/acebelowzero/tmpl/internal/timing"
+)
+
+// Severities of the vulnerability scan when none are configured.
+const (
+	defaultScanFailOn = "critical"
+	defaultScanWarnOn = "high"
+)
+
+// scanOptions holds the flags of the vulnerability scan of plan.
+type scanOptions struct {
+	enabled bool
+	failOn  string
+}
+
+func addScanFlags(cmd *cobra.Command, opts *scanOptions) {
+	cmd.Flags().BoolVar(&opts.enabled, "scan", false, "Scan the images of the stack for vulnerabilities, with trivy unless scan.scanner is configured")
+	cmd.Flags().StringVar(&opts.failOn, "scan-fail-on", "", "Lowest vulnerability severity that fails the plan: low, medium, high or critical (overrides scan.failOn)")
+}
+
+// scanImages runs the vulnerability scanner over the images of desired
+// when --scan is set or the configuration of chartDir names a scanner.
+// Vulnerabilities at or above scan.warnOn are printed as warnings, and
+// those at or above scan.failOn fail the plan, unless they are ignored.
+func scanImages(cmd *cobra.Command, chartDir string, desired *stack.Stack, opts scanOptions) error {
+	settings, err := chartSettings(cmd, chartDir)
+	if err != nil {
+		return err
+	}
+	cfg := settings.Scan
+	if !opts.enabled && cfg.Scanner == "" {
+		return nil
+	}
+	if cfg.Scanner == "" {
+		cfg.Scanner = scan.ScannerTrivy
+	}
+	failOn, err := scanSeverity(opts.failOn, cfg.FailOn, defaultScanFailOn)
+	if err != nil {
+		return withExit(ExitConfig, fmt.Errorf("scan fail-on: %w", err))
+	}
+	warnOn, err := scanSeverity("", cfg.WarnOn, defaultScanWarnOn)
+	if err != nil {
+		return withExit(ExitConfig, fmt.Errorf("scan.warnOn: %w", err))
+	}
+	scanner, err := scan.New(scan.Config{Scanner: cfg.Scanner, Server: cfg.Server})
+	if err != nil {
+		return withExit(ExitConfig, err)
+	}
+
+	seen := map[string]bool{}
+	var images []string
+	for _, spec := range desired.Services {
+		if cs := spec.TaskTemplate.ContainerSpec; cs != nil && cs.Image != "" && !seen[cs.Image] {
+			seen[cs.Image] = true
+			images = append(images, cs.Image)
+		}
+	}
+	sort.Strings(images)
+
+	defer timing.Track(cmd.Context(), timing.Validate)()
+	var failed []string
+	for _, image := range images {
+		vulns, err := scanner.Scan(cmd.Context(), image)
+		if err != nil {
+			return err
+		}
+		for _, v := range vulns {
+			switch {
+			case slices.Contains(cfg.Ignore, v.ID):
+			case v.Severity >= failOn:
+				failed = append(failed, image+": "+v.String())
+			case v.Severity >= warnOn:
+				fmt.Fprintf(cmd.ErrOrStderr(), "warning: %s: %s\n", image, v)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return withExit(ExitValidation, fmt.Errorf("%d vulnerabilities at or above %s:\n  %s", len(failed), failOn, strings.Join(failed, "\n  ")))
+	}
+	return nil
+}
+
+// scanSeverity parses the first severity set of flag, configured and
+// fallback.
+func scanSeverity(flag, configured, fallback string) (scan.Severity, error) {
+	for _, s := range []string{flag, configured} {
+		if s != "" {
+			return scan.ParseSeverity(s)
+		}
+	}
+	return scan.ParseSeverity(fallback)
+}
@@ -0,0 +1,232 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/schema"
+	"github.com/acebelowzero/tmpl/internal/timing"
+	"github.com/acebelowzero/tmpl/internal/values"
+)
+
+// errSchemaInvalid signals that values do not satisfy the chart schema.
+var errSchemaInvalid = withExit(ExitValidation, errors.New("values do not match the schema"))
+
+func newSchemaCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Generate and check the values schema of a chart",
+	}
+
+	cmd.AddCommand(newSchemaGenCmd())
+	cmd.AddCommand(newSchemaCheckCmd())
+
+	return cmd
+}
+
+func newSchemaGenCmd() *cobra.Command {
+	var output string
+	var strict bool
+
+	cmd := &cobra.Command{
+		Use:     "gen [CHART]",
+		Aliases: []string{"generate"},
+		Short:   "Infer values.schema.json from values.yaml",
+		Long: `Infer values.schema.json from the values.yaml of a chart.
+
+Every key becomes a property typed after its default value and, unless the
+default is null, a required key. The comment above a key, or at the end of
+its line, becomes its description. With --strict objects reject keys that
+values.yaml does not define.
+
+The schema is written to the chart, replacing any existing one, unless
+--output is given; --output - prints it.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chart := "."
+			if len(args) == 1 {
+				chart = args[0]
+			}
+			return runSchemaGen(cmd, chart, output, strict)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output file path, or - for stdout (default: the chart's values.schema.json)")
+	cmd.Flags().BoolVar(&strict, "strict", false, "Reject keys not defined in values.yaml")
+
+	return cmd
+}
+
+func runSchemaGen(cmd *cobra.Command, chart, output string, strict bool) error {
+	data, err := os.ReadFile(filepath.Join(chart, "values.yaml"))
+	if err != nil {
+		return fmt.Errorf("read values: %w", err)
+	}
+	s, err := schema.Generate(data, schema.GenerateOptions{Strict: strict})
+	if err != nil {
+		return err
+	}
+	encoded, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode schema: %w", err)
+	}
+	encoded = append(encoded, '\n')
+
+	if output == "-" {
+		_, err := cmd.OutOrStdout().Write(encoded)
+		return err
+	}
+	if output == "" {
+		output = filepath.Join(chart, schema.FileName)
+	}
+	if err := writeFile(output, defaultFileModes, encoded); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Schema written to %s\n", output)
+	return nil
+}
+
+func newSchemaCheckCmd() *cobra.Command {
+	var valuesFiles []string
+	var envFiles []string
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "check [CHART]",
+		Short: "Validate values files against the chart's values.schema.json",
+		Long: `Validate values against the chart's values.schema.json without rendering
+the chart. The values are those templates would see: the chart defaults
+merged with the values files in order, with environment expanded and
+encrypted references decrypted.
+
+The library charts the chart depends on read the same values, and those
+with a values.schema.json validate them too. Their errors are reported
+after those of the chart, prefixed with the library name, so one run shows
+every violation. Library schemas describe the keys of their library and
+must allow the others, which JSON schemas do unless additionalProperties
+is false.
+
+The command exits with a non-zero status when the values do not match.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chart := "."
+			if len(args) == 1 {
+				chart = args[0]
+			}
+			if format != "text" && format != "json" {
+				return withExit(ExitConfig, fmt.Errorf("unknown output format %q", format))
+			}
+			return runSchemaCheck(cmd, chart, valuesFiles, envFiles, format)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&valuesFiles, "values", "f", nil, "Values files")
+	cmd.Flags().StringSliceVar(&envFiles, "env-file", nil, "Environment files for value expansion")
+	cmd.Flags().StringVarP(&format, "output", "o", "text", "Output format: text or json")
+
+	return cmd
+}
+
+func runSchemaCheck(cmd *cobra.Command, chart string, valuesFiles, envFiles []string, format string) error {
+	schemas, err := chartSchemas(chart)
+	if err != nil {
+		return err
+	}
+	cfg, err := loaderConfig(cmd, chart, envFiles)
+	if err != nil {
+		return err
+	}
+	loader, err := values.NewLoader(cfg)
+	if err != nil {
+		return withExit(ExitRender, fmt.Errorf("setup values loader: %w", err))
+	}
+	merged, err := loader.Load(cmd.Context(), chart, valuesFiles...)
+	if err != nil {
+		return withExit(ExitRender, fmt.Errorf("load values: %w", err))
+	}
+
+	stop := timing.Track(cmd.Context(), timing.Validate)
+	var errs []schema.Error
+	for _, s := range schemas {
+		for _, e := range s.schema.Validate(merged) {
+			e.Chart = s.library
+			errs = append(errs, e)
+		}
+	}
+	stop()
+	if err := writeSchemaErrors(cmd.OutOrStdout(), errs, format); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errSchemaInvalid
+	}
+	return nil
+}
+
+// chartSchema is the values schema of a chart, or of the library named
+// library.
+type chartSchema struct {
+	library string
+	schema  *schema.Schema
+}
+
+// chartSchemas loads the values schemas of the chart in dir and of the
+// library charts it depends on that have one. The chart needs a schema
+// unless one of its libraries has.
+func chartSchemas(dir string) ([]chartSchema, error) {
+	var schemas []chartSchema
+	s, chartErr := schema.Load(dir)
+	if chartErr == nil {
+		schemas = append(schemas, chartSchema{schema: s})
+	} else if !errors.Is(chartErr, fs.ErrNotExist) {
+		return nil, chartErr
+	}
+	libs, err := chart.Libraries(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, withExit(ExitConfig, err)
+	}
+	for _, lib := range libs {
+		s, err := schema.Load(lib.Dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("library %s: %w", lib.Metadata.Name, err)
+		}
+		schemas = append(schemas, chartSchema{library: lib.Metadata.Name, schema: s})
+	}
+	if len(schemas) == 0 {
+		return nil, chartErr
+	}
+	return schemas, nil
+}
+
+func writeSchemaErrors(w io.Writer, errs []schema.Error, format string) error {
+	if format == "json" {
+		if errs == nil {
+			errs = []schema.Error{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(errs)
+	}
+	for _, e := range errs {
+		fmt.Fprintln(w, e.Error())
+	}
+	if len(errs) == 0 {
+		_, err := fmt.Fprintln(w, "Values match the schema")
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d error(s)\n", len(errs))
+	return err
+}
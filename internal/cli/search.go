@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/repo"
+)
+
+func newSearchCmd() *cobra.Command {
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "search [TERM]",
+		Short: "Search configured repositories for charts",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			term := ""
+			if len(args) == 1 {
+				term = args[0]
+			}
+			manager, err := repo.NewManager()
+			if err != nil {
+				return err
+			}
+			results, err := manager.Search(term)
+			if err != nil {
+				return err
+			}
+			switch format {
+			case "json":
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(results)
+			case "text":
+				tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "NAME\tVERSION\tAPP VERSION\tDESCRIPTION")
+				for _, r := range results {
+					description := r.Description
+					switch {
+					case r.Deprecated && r.ReplacedBy != "":
+						description = fmt.Sprintf("[deprecated, use %s] %s", r.ReplacedBy, description)
+					case r.Deprecated:
+						description = "[deprecated] " + description
+					}
+					fmt.Fprintf(tw, "%s/%s\t%s\t%s\t%s\n", r.Repository, r.Name, r.Version, r.AppVersion, description)
+				}
+				return tw.Flush()
+			default:
+				return withExit(ExitConfig, fmt.Errorf("unknown output format %q", format))
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&format, "output", "o", "text", "Output format: text or json")
+
+	return cmd
+}
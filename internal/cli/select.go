@@ -0,0 +1,27 @@
+package cli
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/deploy"
+)
+
+func addSelectorFlags(cmd *cobra.Command, sel *deploy.Selector) {
+	cmd.Flags().StringSliceVar(&sel.Only, "only", nil, "Deploy only these services, by name or glob pattern (e.g. web,worker)")
+	cmd.Flags().StringSliceVar(&sel.Exclude, "exclude", nil, "Leave these services as they are, by name or glob pattern (e.g. cron-*)")
+}
+
+// checkSelector validates the service selector of a command. Pruning is
+// refused alongside it, as objects of the services that are not selected
+// would look unused.
+func checkSelector(sel deploy.Selector, prune bool) error {
+	if sel.IsZero() {
+		return nil
+	}
+	if prune {
+		return withExit(ExitConfig, errors.New("--prune cannot be combined with --only or --exclude"))
+	}
+	return sel.Validate()
+}
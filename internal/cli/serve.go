@@ -0,0 +1,414 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/compose"
+	"github.com/acebelowzero/tmpl/internal/deploy"
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/logx"
+	"github.com/acebelowzero/tmpl/internal/release"
+	"github.com/acebelowzero/tmpl/internal/render"
+	"github.com/acebelowzero/tmpl/internal/repo"
+	"github.com/acebelowzero/tmpl/internal/source"
+	"github.com/acebelowzero/tmpl/internal/stack"
+	"github.com/acebelowzero/tmpl/internal/telemetry"
+	"github.com/acebelowzero/tmpl/internal/values"
+)
+
+// maxServeRequest bounds the body of an API request.
+const maxServeRequest = 4 << 20
+
+// serveOptions holds the flags of the serve command.
+type serveOptions struct {
+	listen         string
+	tokenFile      string
+	requestTimeout time.Duration
+	store          string
+	docker         dockerOptions
+	metrics        http.Handler
+}
+
+func newServeCmd() *cobra.Command {
+	opts := &serveOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve an HTTP API that renders and plans repository charts",
+		Long: `Run an HTTP server that renders charts and plans their deployment for
+other tools, without shelling out to tmpl.
+
+Every request but GET /healthz and GET /metrics needs an
+"Authorization: Bearer TOKEN" header. Tokens are read from --token-file, one per line, and from
+$TMPL_SERVE_TOKEN; the server does not start without one.
+
+  POST /v1/render  render a chart, returning the stack with redacted secrets
+                   and without its notes, which may quote them
+  POST /v1/plan    plan the rendered stack against the swarm of the server,
+                   likewise without the notes
+  GET  /metrics    Prometheus metrics: renders, source fetches, chart cache
+                   lookups and Docker API calls by result, and durations
+
+Both take a JSON body:
+
+  {
+    "chart": "REPO/CHART",
+    "version": "^1.2",
+    "values": {"replicas": 3},
+    "valuesFiles": ["git+https://example.com/env.git//prod.yaml"],
+    "env": {"TAG": "1.4.0"},
+    "stack": "web",
+    "profiles": ["debug"],
+    "pinDigests": false,
+    "prune": false,
+    "only": ["api"],
+    "exclude": []
+  }
+
+Charts come from the repositories configured with 'tmpl repo add' and are
+kept in the chart cache shared by all requests, with their parsed
+templates. Values files must be
+remote sources. Local paths, the environment of the server and encrypted
+references outside the chart are not available to requests; the
+variables of "env" are expanded instead.`,
+		Args: cobra.NoArgs,
+		// The metrics must be enabled before the telemetry of the
+		// command is set up.
+		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			opts.metrics, err = telemetry.MetricsHandler()
+			return err
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runServe(cmd, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.listen, "listen", "127.0.0.1:8080", "Address to listen on")
+	cmd.Flags().StringVar(&opts.tokenFile, "token-file", "", "File of accepted API tokens, one per line")
+	cmd.Flags().DurationVar(&opts.requestTimeout, "request-timeout", 2*time.Minute, "Maximum time to render or plan a request")
+	addReleaseStoreFlag(cmd, &opts.store)
+	addDockerFlags(cmd, &opts.docker)
+
+	return cmd
+}
+
+func runServe(cmd *cobra.Command, opts *serveOptions) error {
+	ctx := cmd.Context()
+	tokens, err := loadServeTokens(opts.tokenFile)
+	if err != nil {
+		return err
+	}
+	manager, err := repo.NewManager()
+	if err != nil {
+		return err
+	}
+	client, err := newDockerClient(&opts.docker)
+	if err != nil {
+		return err
+	}
+	store, err := openReleaseStore(cmd, opts.store, client)
+	if err != nil {
+		return err
+	}
+
+	s := &server{
+		tokens:    tokens,
+		metrics:   opts.metrics,
+		timeout:   opts.requestTimeout,
+		repos:     manager,
+		client:    client,
+		store:     store,
+		templates: render.NewCache(),
+	}
+	listener, err := net.Listen("tcp", opts.listen)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", opts.listen, err)
+	}
+	srv := &http.Server{
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	log := logx.FromContext(ctx)
+	log.Info("serving", "address", listener.Addr().String())
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(listener)
+	}()
+	select {
+	case err := <-done:
+		return fmt.Errorf("serve: %w", err)
+	case <-ctx.Done():
+	}
+	log.Info("shutting down, waiting for requests in progress")
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), opts.requestTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	return nil
+}
+
+// loadServeTokens reads the accepted tokens from path, skipping blank
+// lines and # comments, and from $TMPL_SERVE_TOKEN.
+func loadServeTokens(path string) ([]string, error) {
+	var tokens []string
+	if t := strings.TrimSpace(os.Getenv("TMPL_SERVE_TOKEN")); t != "" {
+		tokens = append(tokens, t)
+	}
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("read token file: %w", err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			tokens = append(tokens, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("read token file: %w", err)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("no API token configured; use --token-file or $TMPL_SERVE_TOKEN")
+	}
+	return tokens, nil
+}
+
+// server answers the API requests of tmpl serve.
+type server struct {
+	tokens  []string
+	metrics http.Handler
+	timeout time.Duration
+	repos   *repo.Manager
+	client  *docker.Client
+	store   release.Store
+	// templates keeps the parsed templates of the charts rendered.
+	templates *render.Cache
+}
+
+// serveRequest is the body of the render and plan endpoints.
+type serveRequest struct {
+	Chart       string            `json:"chart"`
+	Version     string            `json:"version,omitempty"`
+	Values      map[string]any    `json:"values,omitempty"`
+	ValuesFiles []string          `json:"valuesFiles,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	Stack       string            `json:"stack,omitempty"`
+	Profiles    []string          `json:"profiles,omitempty"`
+	PinDigests  bool              `json:"pinDigests,omitempty"`
+	Prune       bool              `json:"prune,omitempty"`
+	Only        []string          `json:"only,omitempty"`
+	Exclude     []string          `json:"exclude,omitempty"`
+}
+
+// renderFlags returns the render settings of the request, parsing
+// templates through cache.
+func (r *serveRequest) renderFlags(cache *render.Cache) renderFlags {
+	return renderFlags{profiles: r.Profiles, pinDigests: r.PinDigests, cache: cache}
+}
+
+// renderResponse is the answer of /v1/render. It leaves out the notes of
+// the chart: they are free text that can quote the secrets redacted from
+// the manifest.
+type renderResponse struct {
+	Chart    chart.Metadata `json:"chart"`
+	Stack    string         `json:"stack"`
+	Manifest string         `json:"manifest"`
+}
+
+// apiError is the body of failed requests.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// statusError carries the HTTP status a failed request is answered with.
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+
+func (e *statusError) Unwrap() error { return e.err }
+
+func withStatus(status int, err error) error {
+	return &statusError{status: status, err: err}
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	if s.metrics != nil {
+		mux.Handle("GET /metrics", s.metrics)
+	}
+	mux.Handle("POST /v1/render", s.handle(s.render))
+	mux.Handle("POST /v1/plan", s.handle(s.plan))
+	return mux
+}
+
+// handle authenticates a request, decodes its body, runs fn within the
+// request timeout and writes its result or error as JSON.
+func (s *server) handle(fn func(context.Context, *serveRequest) (any, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		status, err := s.serve(w, r, fn)
+		log := logx.FromContext(r.Context())
+		if err != nil {
+			writeJSON(w, status, apiError{Error: err.Error()})
+			log.Warn("request failed", "path", r.URL.Path, "status", status, "duration", time.Since(started), "error", err)
+			return
+		}
+		log.Info("request served", "path", r.URL.Path, "status", status, "duration", time.Since(started))
+	})
+}
+
+func (s *server) serve(w http.ResponseWriter, r *http.Request, fn func(context.Context, *serveRequest) (any, error)) (int, error) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="tmpl"`)
+		return http.StatusUnauthorized, errors.New("missing or invalid API token")
+	}
+	var req serveRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxServeRequest))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return http.StatusBadRequest, fmt.Errorf("decode request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	result, err := fn(ctx, &req)
+	if err != nil {
+		status := http.StatusUnprocessableEntity
+		var se *statusError
+		if errors.As(err, &se) {
+			status = se.status
+		}
+		return status, err
+	}
+	writeJSON(w, http.StatusOK, result)
+	return http.StatusOK, nil
+}
+
+func (s *server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) render(ctx context.Context, req *serveRequest) (any, error) {
+	chartDir, cfg, err := s.resolve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := chart.LoadMetadata(chartDir)
+	if err != nil {
+		return nil, err
+	}
+	stackName := req.Stack
+	if stackName == "" {
+		stackName = meta.Name
+	}
+	namespace := globalOptions(ctx).Namespace
+	rcfg := req.renderFlags(s.templates).config(ctx, render.Config{ChartPath: chartDir, ReleaseName: stackName, Namespace: namespace})
+	_, result, _, err := renderSourcesWith(ctx, rcfg, cfg, req.ValuesFiles)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := compose.Redact(result.Output)
+	if err != nil {
+		return nil, fmt.Errorf("redact secrets: %w", err)
+	}
+	return renderResponse{Chart: *meta, Stack: stack.Namespaced(namespace, stackName), Manifest: string(manifest)}, nil
+}
+
+func (s *server) plan(ctx context.Context, req *serveRequest) (any, error) {
+	sel := deploy.Selector{Only: req.Only, Exclude: req.Exclude}
+	if err := checkSelector(sel, req.Prune); err != nil {
+		return nil, withStatus(http.StatusBadRequest, err)
+	}
+	chartDir, cfg, err := s.resolve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	flags := req.renderFlags(s.templates)
+	if flags.capabilities, err = swarmCapabilities(ctx, s.client); err != nil {
+		return nil, err
+	}
+	built, err := buildStackWith(ctx, chartDir, cfg, req.ValuesFiles, req.Stack, flags)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := newRelease(built)
+	if err != nil {
+		return nil, err
+	}
+	if err := release.LabelOwner(ctx, s.store, rel); err != nil {
+		return nil, withStatus(http.StatusBadGateway, err)
+	}
+	p, err := deploy.New(s.client).Plan(ctx, built.stack, deploy.Options{Prune: req.Prune, Services: sel, CheckImages: true})
+	if err != nil {
+		return nil, withStatus(http.StatusBadGateway, err)
+	}
+	// As with renders, the notes are left out with the values.
+	p.Release = rel
+	p.Release.Stack, p.Release.Values, p.Release.UserValues = nil, nil, nil
+	p.Release.Notes = ""
+	return p, nil
+}
+
+// resolve checks the sources of req, fetches its chart into the cache and
+// returns the isolated values loader configuration for it.
+func (s *server) resolve(ctx context.Context, req *serveRequest) (string, values.LoaderConfig, error) {
+	if req.Chart == "" {
+		return "", values.LoaderConfig{}, withStatus(http.StatusBadRequest, errors.New("chart is required"))
+	}
+	if !s.repos.IsReference(req.Chart) {
+		return "", values.LoaderConfig{}, withStatus(http.StatusBadRequest, fmt.Errorf("chart %q is not a chart of a configured repository (REPO/CHART)", req.Chart))
+	}
+	for _, file := range req.ValuesFiles {
+		if source.ParseScheme(file) == source.SchemeLocal {
+			return "", values.LoaderConfig{}, withStatus(http.StatusBadRequest, fmt.Errorf("values file %q: only remote sources are accepted", file))
+		}
+	}
+
+	dir, _, err := s.repos.Fetch(ctx, req.Chart, req.Version)
+	if err != nil {
+		return "", values.LoaderConfig{}, err
+	}
+	return dir, values.LoaderConfig{Env: req.Env, Overrides: req.Values, Isolated: true}, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,245 @@
+package cli
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/compose"
+)
+
+// outputSplit shards a rendered stack across numbered files. A part is
+// closed once it holds documents documents or, before it would grow past
+// bytes, once it holds any; a single larger document is a part of its own.
+type outputSplit struct {
+	documents int
+	bytes     int64
+}
+
+// sizeUnits are the suffixes of --split-output sizes.
+var sizeUnits = []struct {
+	suffix string
+	n      int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"B", 1},
+}
+
+// parseOutputSplit parses --split-output: a number of documents per file,
+// or a size per file such as 900KB or 5MiB. It returns nil for "".
+func parseOutputSplit(s string) (*outputSplit, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 1 {
+			return nil, fmt.Errorf("invalid --split-output %q, must be at least 1", s)
+		}
+		return &outputSplit{documents: n}, nil
+	}
+	for _, u := range sizeUnits {
+		number, ok := strings.CutSuffix(s, u.suffix)
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+		if err != nil || n < 1 {
+			break
+		}
+		return &outputSplit{bytes: n * u.n}, nil
+	}
+	return nil, fmt.Errorf("invalid --split-output %q, expected a number of documents or a size such as 5MiB", s)
+}
+
+// splitIndex is the manifest written to the output path of a split stack,
+// listing its parts in order.
+type splitIndex struct {
+	Documents int         `yaml:"documents"`
+	Parts     []splitPart `yaml:"parts"`
+}
+
+// splitPart is a file of a split stack, relative to the index.
+type splitPart struct {
+	File      string `yaml:"file"`
+	Documents int    `yaml:"documents"`
+	Size      int64  `yaml:"size"`
+	Digest    string `yaml:"digest"`
+}
+
+// splitIndexHeader starts index files, which are not stacks themselves.
+const splitIndexHeader = "# Index of a stack split by tmpl template --split-output; deploy the parts in order.\n"
+
+// pendingPart is a part being written to a temporary file.
+type pendingPart struct {
+	splitPart
+	file *os.File
+	w    *bufio.Writer
+	sum  hash.Hash
+}
+
+// writeSplit writes the stack written by write to numbered files next to
+// output, NAME-001.yaml and on, and the index of the parts to output. The
+// parts go to temporary files that are only renamed into place once the
+// whole stack was written, and parts of an earlier split that are not
+// part of this one are removed.
+func writeSplit(output string, modes fileModes, split *outputSplit, write func(io.Writer) error) (_ *splitIndex, err error) {
+	if output == "" {
+		return nil, errors.New("output path is empty")
+	}
+	dir := filepath.Dir(output)
+	if err := ensureDir(dir, modes.dir); err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(output)
+	base := strings.TrimSuffix(filepath.Base(output), ext)
+	if ext == "" {
+		ext = ".yaml"
+	}
+
+	var parts []*pendingPart
+	defer func() {
+		if err != nil {
+			for _, p := range parts {
+				p.file.Close()
+				os.Remove(p.file.Name())
+			}
+		}
+	}()
+	next := func() error {
+		name := fmt.Sprintf("%s-%03d%s", base, len(parts)+1, ext)
+		f, err := os.CreateTemp(dir, "."+name+".*")
+		if err != nil {
+			return fmt.Errorf("write output: %w", err)
+		}
+		p := &pendingPart{splitPart: splitPart{File: name}, file: f, sum: sha256.New()}
+		p.w = bufio.NewWriter(io.MultiWriter(f, p.sum))
+		parts = append(parts, p)
+		return nil
+	}
+
+	pr, pw := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		err := write(pw)
+		pw.CloseWithError(err)
+		written <- err
+	}()
+	serr := compose.EachDocument(pr, func(data []byte, _ int) error {
+		var cur *pendingPart
+		if len(parts) > 0 {
+			cur = parts[len(parts)-1]
+		}
+		size := int64(len(data))
+		if cur == nil ||
+			split.documents > 0 && cur.Documents >= split.documents ||
+			split.bytes > 0 && cur.Documents > 0 && cur.Size+size > split.bytes {
+			if err := next(); err != nil {
+				return err
+			}
+			cur = parts[len(parts)-1]
+		}
+		if _, err := cur.w.Write(data); err != nil {
+			return fmt.Errorf("write output: %w", err)
+		}
+		cur.Documents++
+		cur.Size += size
+		return nil
+	})
+	// Stops the render when splitting failed first.
+	pr.Close()
+	if err := <-written; err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, serr
+	}
+
+	index := &splitIndex{Parts: []splitPart{}}
+	for _, p := range parts {
+		if err := p.w.Flush(); err != nil {
+			return nil, fmt.Errorf("write output: %w", err)
+		}
+		if err := p.file.Chmod(modes.file); err != nil {
+			return nil, fmt.Errorf("write output: %w", err)
+		}
+		if err := p.file.Sync(); err != nil {
+			return nil, fmt.Errorf("write output: %w", err)
+		}
+		p.Digest = "sha256:" + hex.EncodeToString(p.sum.Sum(nil))
+		index.Documents += p.Documents
+		index.Parts = append(index.Parts, p.splitPart)
+	}
+	stale := staleParts(output, index)
+	for _, p := range parts {
+		if err := p.file.Close(); err != nil {
+			return nil, fmt.Errorf("write output: %w", err)
+		}
+		target := filepath.Join(dir, p.File)
+		if modes.backup {
+			if err := backupFile(target); err != nil {
+				return nil, err
+			}
+		}
+		if err := os.Rename(p.file.Name(), target); err != nil {
+			return nil, fmt.Errorf("write output: %w", err)
+		}
+	}
+	// The parts are in place; from here on, failing leaves no temporary
+	// files behind.
+	parts = nil
+
+	err = writeFileWith(output, modes, func(w io.Writer) error {
+		io.WriteString(w, splitIndexHeader)
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(index); err != nil {
+			return fmt.Errorf("encode split index: %w", err)
+		}
+		return enc.Close()
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range stale {
+		if err := os.Remove(filepath.Join(dir, file)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("remove stale part: %w", err)
+		}
+	}
+	return index, nil
+}
+
+// staleParts lists the parts of the split index at output, if there is
+// one, that index does not replace.
+func staleParts(output string, index *splitIndex) []string {
+	data, err := os.ReadFile(output)
+	if err != nil || !strings.HasPrefix(string(data), splitIndexHeader) {
+		return nil
+	}
+	var previous splitIndex
+	if err := yaml.Unmarshal(data, &previous); err != nil {
+		return nil
+	}
+	current := map[string]bool{}
+	for _, p := range index.Parts {
+		current[p.File] = true
+	}
+	var stale []string
+	for _, p := range previous.Parts {
+		// Only files next to the index are ever removed.
+		if !current[p.File] && p.File == filepath.Base(p.File) {
+			stale = append(stale, p.File)
+		}
+	}
+	return stale
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/deploy"
+)
+
+func newStatusCmd() *cobra.Command {
+	var format string
+	var watch bool
+	var interval time.Duration
+	var dockerOpts dockerOptions
+
+	cmd := &cobra.Command{
+		Use:   "status [STACK]",
+		Short: "Show the rollout state of a stack's services",
+		Long: `Show the running and desired replicas, rolling update state and recent task
+errors of every service of a stack. The stack defaults to the name of the
+chart in the current directory.
+
+With --watch, task state transitions are streamed as they happen until
+interrupted. -o json writes one JSON object per service, or per task event
+when watching.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, err := stackArg(cmd, args)
+			if err != nil {
+				return err
+			}
+			if format != "table" && format != "json" {
+				return withExit(ExitConfig, fmt.Errorf("unknown output format %q", format))
+			}
+			client, err := newDockerClient(&dockerOpts)
+			if err != nil {
+				return err
+			}
+			deployer := deploy.New(client)
+			if watch {
+				return watchStatus(cmd, deployer, name, format, interval)
+			}
+			statuses, err := deployer.Status(cmd.Context(), name)
+			if err != nil {
+				return err
+			}
+			return writeStatus(cmd.OutOrStdout(), statuses, format)
+		},
+	}
+
+	cmd.Flags().StringVarP(&format, "output", "o", "table", "Output format: table or json")
+	cmd.Flags().BoolVarP(&watch, "watch", "w", false, "Stream task state transitions")
+	cmd.Flags().DurationVar(&interval, "interval", deploy.DefaultWaitInterval, "How often --watch polls the swarm")
+	addDockerFlags(cmd, &dockerOpts)
+
+	return cmd
+}
+
+func writeStatus(w io.Writer, statuses []deploy.ServiceStatus, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		for _, s := range statuses {
+			if err := enc.Encode(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tREPLICAS\tUPDATE\tLAST ERROR")
+	for _, s := range statuses {
+		lastErr := ""
+		if len(s.Errors) > 0 {
+			lastErr = s.Errors[0]
+		}
+		fmt.Fprintf(tw, "%s\t%d/%d\t%s\t%s\n", s.Name, s.Running, s.Desired, s.Update, lastErr)
+	}
+	return tw.Flush()
+}
+
+// watchStatus prints the current service state and then streams task
+// events until the command is interrupted.
+func watchStatus(cmd *cobra.Command, deployer *deploy.Deployer, name, format string, interval time.Duration) error {
+	w := cmd.OutOrStdout()
+	if format == "table" {
+		statuses, err := deployer.Status(cmd.Context(), name)
+		if err != nil {
+			return err
+		}
+		if err := writeStatus(w, statuses, format); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	enc := json.NewEncoder(w)
+	err := deployer.Watch(cmd.Context(), name, interval, func(e deploy.TaskEvent) error {
+		if format == "json" {
+			return enc.Encode(e)
+		}
+		detail := e.Message
+		if e.Error != "" {
+			detail = e.Error
+		}
+		task := e.Service
+		if e.Slot > 0 {
+			task = fmt.Sprintf("%s.%d", e.Service, e.Slot)
+		}
+		_, err := fmt.Fprintf(w, "%s  %-30s %-12s %-9s %s\n", e.Time.Local().Format(time.TimeOnly), task, e.Task,
+			e.State, detail)
+		return err
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/acebelowzero/tmpl/internal/logx"
+	"github.com/acebelowzero/tmpl/internal/telemetry"
+)
+
+// traceCommands makes cmd and its sub-commands export a span for the
+// command, with the spans of its fetches, decryption, renders and engine
+// calls as children, when an OTLP endpoint is configured. Telemetry that
+// cannot be set up or delivered is logged and never fails the command.
+func traceCommands(cmd *cobra.Command) {
+	if run := cmd.RunE; run != nil {
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			log := logx.FromContext(cmd.Context())
+			ctx, shutdown, err := telemetry.Setup(cmd.Context())
+			if err != nil {
+				log.Warn("could not set up telemetry", "error", err)
+			}
+			ctx, span := telemetry.StartNamed(ctx, telemetry.Command, cmd.CommandPath(), attribute.String("command", cmd.CommandPath()))
+			cmd.SetContext(ctx)
+			err = run(cmd, args)
+			span.End(err)
+
+			flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+			defer cancel()
+			if serr := shutdown(flushCtx); serr != nil {
+				log.Warn("could not export telemetry", "error", serr)
+			}
+			return err
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		traceCommands(sub)
+	}
+}
+
+// serveMetrics serves handler as /metrics on address until ctx is done.
+// Only listening fails; errors of the server are logged.
+func serveMetrics(ctx context.Context, address string, handler http.Handler) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", address, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", handler)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	log := logx.FromContext(ctx)
+	log.Info("serving metrics", "address", listener.Addr().String())
+	go func() {
+		if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Warn("metrics server stopped", "error", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	return nil
+}
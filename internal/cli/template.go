@@ -1,14 +1,26 @@
 package cli
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 
 	"github.com/spf13/cobra"
 
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/compose"
+	"github.com/acebelowzero/tmpl/internal/config"
+	"github.com/acebelowzero/tmpl/internal/deploy"
+	"github.com/acebelowzero/tmpl/internal/kube"
+	"github.com/acebelowzero/tmpl/internal/nomad"
 	"github.com/acebelowzero/tmpl/internal/render"
+	"github.com/acebelowzero/tmpl/internal/repo"
+	"github.com/acebelowzero/tmpl/internal/stack"
 	"github.com/acebelowzero/tmpl/internal/values"
 )
 
@@ -16,86 +28,627 @@ func newTemplateCmd() *cobra.Command {
 	var valuesFiles []string
 	var envFiles []string
 	var output string
+	var version string
+	var verify verifyOptions
+	var showSecrets bool
+	var skipEmpty bool
+	var lineEndings string
+	var target string
+	watch := &watchOptions{}
+	var validate bool
+	var outputMode, outputDirMode string
+	var backup bool
+	var splitOutput string
 
 	cmd := &cobra.Command{
 		Use:     "template [CHART]",
 		Aliases: []string{"render"},
 		Short:   "Render a stack from a tmpl chart",
-		Args:    cobra.MaximumNArgs(1),
+		Long: `Render a stack from a tmpl chart, one YAML document per template, and
+write it to --output or stdout. The inline content of secrets is replaced
+by a reference to its digest unless --show-secrets prints it to stdout.
+
+.Release.Name is the chart name, or the stack name given with --stack.
+docs/charts.md describes what charts can use and docs/rendering.md the
+output files, --watch and the other targets of this command.`,
+		Example: `  tmpl template -f values-prod.yaml -o stacks/web.yaml
+  tmpl template --watch --apply -f values-dev.yaml
+  tmpl template --profile debug --profile tools
+  tmpl template -o out/stack.yaml --split-output 50`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeCharts,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return watch.enableMetrics()
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			chart := "."
 			if len(args) == 1 {
 				chart = args[0]
 			}
+			resolved, fromRepo, err := resolveChart(cmd, chart, version, verify)
+			if err != nil {
+				return err
+			}
+			chart = resolved
+			if err := watch.render.checkDeprecated(cmd, chart); err != nil {
+				return err
+			}
 			if output == "" {
-				output = filepath.Join(chart, "rendered-stack.yaml")
+				if output, err = defaultOutput(cmd, chart, fromRepo); err != nil {
+					return err
+				}
+			}
+			le, err := render.ParseLineEndings(lineEndings)
+			if err != nil {
+				return withExit(ExitConfig, fmt.Errorf("invalid --line-endings: %w", err))
+			}
+			if target != targetSwarm && target != targetKubernetes && target != targetNomad {
+				return withExit(ExitConfig, fmt.Errorf("unknown --target %q, must be swarm, kubernetes or nomad", target))
+			}
+			if target != targetSwarm && (validate || watch.enabled) {
+				return withExit(ExitConfig, fmt.Errorf("--target %s cannot be combined with --validate or --watch", target))
+			}
+			if showSecrets && output != "-" {
+				return withExit(ExitConfig, fmt.Errorf("--show-secrets is only supported with --output -"))
+			}
+			modes, err := outputModes(cmd, chart, outputMode, outputDirMode, backup)
+			if err != nil {
+				return err
+			}
+			split, err := parseOutputSplit(splitOutput)
+			if err != nil {
+				return withExit(ExitConfig, err)
+			}
+			if split != nil && (output == "-" || watch.enabled || target == targetNomad) {
+				return withExit(ExitConfig, errors.New("--split-output needs an output file and cannot be combined with --watch or --target nomad"))
 			}
-			return runTemplate(cmd, chart, valuesFiles, envFiles, output)
+			if watch.enabled {
+				if validate {
+					return withExit(ExitConfig, errors.New("--validate cannot be combined with --watch"))
+				}
+				if fromRepo || output == "-" {
+					return withExit(ExitConfig, errors.New("--watch needs a local chart and an output file"))
+				}
+				return watchTemplate(cmd, chart, valuesFiles, envFiles, output, modes, skipEmpty, le, watch)
+			}
+			if watch.apply {
+				return withExit(ExitConfig, errors.New("--apply requires --watch; use 'tmpl apply' otherwise"))
+			}
+			var engine *dockerOptions
+			if validate {
+				engine = &watch.docker
+			}
+			rcfg := watch.render.config(cmd.Context(), render.Config{ChartPath: chart, SkipEmpty: skipEmpty, ReleaseName: watch.stackName, Namespace: globalOptions(cmd.Context()).Namespace})
+			if target != targetSwarm {
+				return runConvertedTemplate(cmd, rcfg, valuesFiles, envFiles, output, modes, split, showSecrets, le, target)
+			}
+			return runTemplate(cmd, rcfg, valuesFiles, envFiles, output, modes, split, showSecrets, le, engine)
 		},
 	}
 
 	cmd.Flags().StringSliceVarP(&valuesFiles, "values", "f", nil, "Values files")
 	cmd.Flags().StringSliceVar(&envFiles, "env-file", nil, "Environment files for value expansion")
 	cmd.Flags().StringVarP(&output, "output", "o", "", "Output file path")
+	cmd.Flags().StringVar(&outputMode, "output-mode", "", "Permissions of the output file, e.g. 0600 (default 0644 or output.fileMode)")
+	cmd.Flags().BoolVar(&backup, "backup", false, "Keep the output file replaced as FILE.bak (default output.backup)")
+	cmd.Flags().StringVar(&splitOutput, "split-output", "", "Split the stack across numbered files of N documents or a size such as 5MiB, indexed in the output file")
+	cmd.Flags().StringVar(&outputDirMode, "output-dir-mode", "", "Permissions of directories created for the output file, e.g. 0700 (default 0755 or output.dirMode)")
+	cmd.Flags().StringVar(&version, "version", "", "Version constraint for repository charts (e.g. 1.2.x, ^1.2)")
+	addVerifyFlags(cmd, &verify)
+	cmd.Flags().BoolVar(&showSecrets, "show-secrets", false, "Print inline secret content instead of digest references (stdout only)")
+	cmd.Flags().BoolVar(&skipEmpty, "skip-empty", false, "Omit templates that render to whitespace only")
+	addRenderFlags(cmd, &watch.render)
+	cmd.Flags().StringVar(&lineEndings, "line-endings", string(render.LineEndingsPreserve), "Line endings of the rendered stack: lf, crlf or preserve (as in the templates)")
+	cmd.Flags().StringVar(&target, "target", targetSwarm, "Platform to render for: swarm, kubernetes or nomad (experimental)")
+	cmd.Flags().BoolVar(&validate, "validate", false, "Validate the rendered stack against the swarm without changing it")
+	addWatchFlags(cmd, watch)
 
 	return cmd
 }
 
-func runTemplate(cmd *cobra.Command, chart string, valuesFiles, envFiles []string, output string) error {
-	ctx := cmd.Context()
-	loader, err := values.NewLoader(values.LoaderConfig{
-		EnvFiles: envFiles,
-	})
+// resolveChart maps a REPO/CHART reference to a cached chart directory,
+// returning local paths unchanged. The provenance of repository charts is
+// verified when verify is enabled.
+func resolveChart(cmd *cobra.Command, ref, version string, verify verifyOptions) (string, bool, error) {
+	manager, err := repo.NewManager()
 	if err != nil {
-		return fmt.Errorf("setup values loader: %w", err)
+		return "", false, err
 	}
-	mergedValues, err := loader.Load(ctx, chart, valuesFiles...)
+	if !manager.IsReference(ref) {
+		if version != "" {
+			return "", false, withExit(ExitConfig, fmt.Errorf("--version requires a repository chart reference"))
+		}
+		if verify.enabled {
+			return "", false, withExit(ExitConfig, fmt.Errorf("--verify requires a repository chart reference"))
+		}
+		return ref, false, nil
+	}
+	dir, entry, err := manager.Fetch(cmd.Context(), ref, version)
 	if err != nil {
-		return fmt.Errorf("load values: %w", err)
+		return "", false, err
 	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Using %s %s\n", ref, entry.Version)
+	if verify.enabled {
+		if entry.Digest == "" {
+			return "", false, withExit(ExitValidation, fmt.Errorf("verify %s: the repository index has no digest for %s", ref, entry.Version))
+		}
+		files, err := manager.FetchProvenance(cmd.Context(), ref, entry)
+		if err != nil {
+			return "", false, withExit(ExitValidation, fmt.Errorf("verify %s: %w", ref, err))
+		}
+		if err := verify.verify(cmd, ref, files, entry.Digest); err != nil {
+			return "", false, err
+		}
+	}
+	return dir, true, nil
+}
 
-	renderer, err := render.New(render.Config{ChartPath: chart})
+// defaultOutput is where template writes when no --output is given:
+// <output.dir>/<chart>.yaml when an output directory is configured, else
+// rendered-stack.yaml in the chart, or in the working directory for
+// repository charts.
+func defaultOutput(cmd *cobra.Command, chartDir string, fromRepo bool) (string, error) {
+	settings, err := chartSettings(cmd, chartDir)
 	if err != nil {
-		return fmt.Errorf("setup renderer: %w", err)
+		return "", err
+	}
+	if settings.Output.Dir != "" {
+		meta, err := chart.LoadMetadata(chartDir)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(settings.Output.Dir, meta.Name+".yaml"), nil
+	}
+	if fromRepo {
+		return "rendered-stack.yaml", nil
 	}
+	return filepath.Join(chartDir, "rendered-stack.yaml"), nil
+}
 
-	result, err := renderer.Execute(ctx, mergedValues)
+// runTemplate renders a chart into output. With engine set, the rendered
+// stack is first validated against the swarm of those docker flags;
+// otherwise it is written as it is rendered.
+func runTemplate(cmd *cobra.Command, rcfg render.Config, valuesFiles, envFiles []string, output string, modes fileModes, split *outputSplit, showSecrets bool, le render.LineEndings, engine *dockerOptions) error {
+	if output == "-" {
+		logsToStderr(cmd)
+	}
+	renderTo := func(w io.Writer) error {
+		return renderChartTo(cmd, rcfg, valuesFiles, envFiles, w)
+	}
+	if engine != nil {
+		// Validation needs the whole stack, before anything is written.
+		_, rendered, _, err := renderChartWith(cmd, rcfg, valuesFiles, envFiles)
+		if err != nil {
+			return err
+		}
+		if err := validateRendered(cmd, rcfg, rendered, engine); err != nil {
+			return err
+		}
+		renderTo = func(w io.Writer) error {
+			_, err := w.Write(rendered.Output)
+			return err
+		}
+	}
+	return writeOutput(cmd, output, modes, split, func(w io.Writer) error {
+		return writeRendered(w, renderTo, showSecrets, le)
+	})
+}
+
+// Platforms of --target.
+const (
+	targetSwarm      = "swarm"
+	targetKubernetes = "kubernetes"
+	targetNomad      = "nomad"
+)
+
+// runConvertedTemplate renders a chart and writes it to output converted
+// for target, Kubernetes manifests or Nomad jobs. Secret content is
+// redacted by the conversion unless showSecrets is set.
+func runConvertedTemplate(cmd *cobra.Command, rcfg render.Config, valuesFiles, envFiles []string, output string, modes fileModes, split *outputSplit, showSecrets bool, le render.LineEndings, target string) error {
+	if output == "-" {
+		logsToStderr(cmd)
+	}
+	_, rendered, _, err := renderChartWith(cmd, rcfg, valuesFiles, envFiles)
 	if err != nil {
-		return fmt.Errorf("render templates: %w", err)
+		return err
+	}
+	parsed, err := render.ParseStack(rendered.Output, rendered.SourceMap)
+	if err != nil {
+		return withExit(ExitValidation, err)
+	}
+	release := rcfg.ReleaseName
+	if release == "" {
+		meta, err := chart.LoadMetadata(rcfg.ChartPath)
+		if err != nil {
+			return err
+		}
+		release = meta.Name
+	}
+	var encode func(io.Writer) error
+	var warnings []string
+	switch target {
+	case targetKubernetes:
+		var manifests []kube.Manifest
+		manifests, warnings, err = kube.Convert(parsed, kube.Options{
+			Release:   release,
+			Namespace: rcfg.Namespace,
+			BaseDir:   rcfg.ChartPath,
+			Redact:    !showSecrets,
+		})
+		encode = func(w io.Writer) error { return kube.Encode(w, manifests) }
+	case targetNomad:
+		var jobs []*nomad.Job
+		jobs, warnings, err = nomad.Convert(parsed, nomad.Options{
+			Name:      release,
+			Namespace: rcfg.Namespace,
+			BaseDir:   rcfg.ChartPath,
+			Redact:    !showSecrets,
+		})
+		encode = func(w io.Writer) error { return nomad.Encode(w, jobs) }
 	}
+	if err != nil {
+		return withExit(ExitValidation, fmt.Errorf("convert to %s: %w", target, err))
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(cmd.ErrOrStderr(), "warning: %s\n", w)
+	}
+	return writeOutput(cmd, output, modes, split, func(w io.Writer) error {
+		if le == render.LineEndingsPreserve {
+			return encode(w)
+		}
+		lw := render.NewLineWriter(w, le)
+		if err := encode(lw); err != nil {
+			return err
+		}
+		return lw.Flush()
+	})
+}
 
+// writeOutput writes the stack written by write to output, a file with
+// modes, split across files when split is set, or - for stdout.
+func writeOutput(cmd *cobra.Command, output string, modes fileModes, split *outputSplit, write func(io.Writer) error) error {
 	if output == "-" {
-		if _, err := cmd.OutOrStdout().Write(result); err != nil {
+		w := bufio.NewWriter(cmd.OutOrStdout())
+		if err := write(w); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
 			return fmt.Errorf("write stdout: %w", err)
 		}
 		return nil
 	}
 
-	if err := writeFile(output, result); err != nil {
+	if split != nil {
+		index, err := writeSplit(output, modes, split, write)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Rendered stack written to %d files, indexed in %s\n", len(index.Parts), output)
+		return nil
+	}
+	if err := writeFileWith(output, modes, write); err != nil {
 		return err
 	}
 	fmt.Fprintf(cmd.OutOrStdout(), "Rendered stack written to %s\n", output)
 	return nil
 }
 
-func writeFile(path string, data []byte) error {
+// writeRendered writes the stack rendered by renderTo to w, redacting the
+// secrets document by document unless showSecrets is set, with the line
+// endings le.
+func writeRendered(w io.Writer, renderTo func(io.Writer) error, showSecrets bool, le render.LineEndings) error {
+	if le != render.LineEndingsPreserve {
+		lw := render.NewLineWriter(w, le)
+		if err := writeRendered(lw, renderTo, showSecrets, render.LineEndingsPreserve); err != nil {
+			return err
+		}
+		return lw.Flush()
+	}
+	if showSecrets {
+		return renderTo(w)
+	}
+	pr, pw := io.Pipe()
+	rendered := make(chan error, 1)
+	go func() {
+		err := renderTo(pw)
+		pw.CloseWithError(err)
+		rendered <- err
+	}()
+	rerr := compose.RedactStream(w, pr)
+	// Stops the render when redaction failed first.
+	pr.Close()
+	if err := <-rendered; err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		return err
+	}
+	if rerr != nil {
+		return fmt.Errorf("redact secrets: %w", rerr)
+	}
+	return nil
+}
+
+// validateRendered converts the rendered stack and has the engine check
+// it; see deploy.Deployer.Validate.
+func validateRendered(cmd *cobra.Command, rcfg render.Config, rendered *render.Result, engine *dockerOptions) error {
+	chartDir, name := rcfg.ChartPath, rcfg.ReleaseName
+	if name == "" {
+		meta, err := chart.LoadMetadata(chartDir)
+		if err != nil {
+			return withExit(ExitConfig, err)
+		}
+		name = meta.Name
+	}
+	parsed, err := render.ParseStack(rendered.Output, rendered.SourceMap)
+	if err != nil {
+		return withExit(ExitRender, err)
+	}
+	name = stack.Namespaced(rcfg.Namespace, name)
+	desired, err := stack.Convert(parsed, stack.Options{Name: name, BaseDir: chartDir, Namespace: rcfg.Namespace})
+	if err != nil {
+		return convertFailure(err)
+	}
+	client, err := newDockerClient(engine)
+	if err != nil {
+		return err
+	}
+	var invalid *deploy.ValidationError
+	if err := deploy.New(client).Validate(cmd.Context(), desired); errors.As(err, &invalid) {
+		return withExit(ExitValidation, err)
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Stack %s is valid for %s\n", name, client.Host())
+	return nil
+}
+
+// renderChart loads the merged values for chart and renders its templates.
+func renderChart(cmd *cobra.Command, chart string, valuesFiles, envFiles []string) (map[string]any, *render.Result, error) {
+	mergedValues, result, _, err := renderChartSources(cmd, chart, valuesFiles, envFiles)
+	return mergedValues, result, err
+}
+
+// renderChartSources is renderChart that also returns the values loader,
+// which reports the fetched remote sources and the user-supplied values.
+func renderChartSources(cmd *cobra.Command, chart string, valuesFiles, envFiles []string) (map[string]any, *render.Result, *values.Loader, error) {
+	return renderChartWith(cmd, render.Config{ChartPath: chart}, valuesFiles, envFiles)
+}
+
+// renderChartWith is renderChartSources with a custom renderer
+// configuration.
+func renderChartWith(cmd *cobra.Command, rcfg render.Config, valuesFiles, envFiles []string) (map[string]any, *render.Result, *values.Loader, error) {
+	cfg, err := loaderConfig(cmd, rcfg.ChartPath, envFiles)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	mergedValues, result, loader, err := renderSourcesWith(cmd.Context(), rcfg, cfg, valuesFiles)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	printRenderWarnings(cmd, result)
+	return mergedValues, result, loader, nil
+}
+
+// renderSourcesWith is renderChartWith with a custom values loader
+// configuration.
+func renderSourcesWith(ctx context.Context, rcfg render.Config, cfg values.LoaderConfig, valuesFiles []string) (map[string]any, *render.Result, *values.Loader, error) {
+	renderer, mergedValues, loader, err := loadRenderer(ctx, rcfg, cfg, valuesFiles)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	result, err := renderer.Render(ctx, mergedValues)
+	if err != nil {
+		return nil, nil, nil, renderFailure(err)
+	}
+	return mergedValues, result, loader, nil
+}
+
+// renderChartTo is renderChartWith that streams the rendered stack to w
+// one template at a time; see render.Renderer.RenderTo.
+func renderChartTo(cmd *cobra.Command, rcfg render.Config, valuesFiles, envFiles []string, w io.Writer) error {
+	cfg, err := loaderConfig(cmd, rcfg.ChartPath, envFiles)
+	if err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+	renderer, mergedValues, _, err := loadRenderer(ctx, rcfg, cfg, valuesFiles)
+	if err != nil {
+		return err
+	}
+	result, err := renderer.RenderTo(ctx, mergedValues, w)
+	if err != nil {
+		return renderFailure(err)
+	}
+	printRenderWarnings(cmd, result)
+	return nil
+}
+
+// printRenderWarnings prints the warnings of a render to stderr.
+func printRenderWarnings(cmd *cobra.Command, result *render.Result) {
+	for _, w := range result.Warnings {
+		fmt.Fprintf(cmd.ErrOrStderr(), "warning: %s\n", w)
+	}
+}
+
+// renderFailure classifies the error of a render: values failing the
+// validations of the chart are a validation failure, anything else a
+// render failure.
+func renderFailure(err error) error {
+	err = fmt.Errorf("render templates: %w", err)
+	var invalid *render.ValidationError
+	if errors.As(err, &invalid) {
+		return withExit(ExitValidation, err)
+	}
+	return withExit(ExitRender, err)
+}
+
+// loadRenderer loads the merged values of a render and sets up its
+// renderer.
+func loadRenderer(ctx context.Context, rcfg render.Config, cfg values.LoaderConfig, valuesFiles []string) (*render.Renderer, map[string]any, *values.Loader, error) {
+	if rcfg.Sandbox != nil {
+		// Values of an untrusted chart must not reach the environment or
+		// files of the host, nor fetch anything.
+		cfg.Isolated, cfg.Sandbox = true, true
+	}
+	loader, err := values.NewLoader(cfg)
+	if err != nil {
+		return nil, nil, nil, withExit(ExitRender, fmt.Errorf("setup values loader: %w", err))
+	}
+	mergedValues, err := loader.Load(ctx, rcfg.ChartPath, valuesFiles...)
+	recordValues(ctx, rcfg.ChartPath, mergedValues, loader)
+	if err != nil {
+		return nil, nil, nil, withExit(ExitRender, fmt.Errorf("load values: %w", err))
+	}
+	rcfg.Functions = functionPolicy(ctx)
+	renderer, err := render.New(rcfg)
+	if err != nil {
+		return nil, nil, nil, withExit(ExitRender, fmt.Errorf("setup renderer: %w", err))
+	}
+	return renderer, mergedValues, loader, nil
+}
+
+// functionPolicy returns the template functions the user configuration
+// allows charts to call.
+func functionPolicy(ctx context.Context) render.FuncPolicy {
+	fns := config.FromContext(ctx).Functions
+	return render.FuncPolicy{Allow: fns.Allow, Deny: fns.Deny}
+}
+
+// fileModes are the permissions of a written file and of the directories
+// created for it, and whether the file it replaces is kept as a backup.
+type fileModes struct {
+	file   fs.FileMode
+	dir    fs.FileMode
+	backup bool
+}
+
+// defaultFileModes are the permissions of the files tmpl writes unless
+// configured otherwise.
+var defaultFileModes = fileModes{file: 0o644, dir: 0o755}
+
+// outputModes returns the permissions of the rendered stacks of the chart
+// in chartDir: --output-mode, --output-dir-mode and --backup when given,
+// else those of the output settings.
+func outputModes(cmd *cobra.Command, chartDir, fileMode, dirMode string, backup bool) (fileModes, error) {
+	settings, err := chartSettings(cmd, chartDir)
+	if err != nil {
+		return fileModes{}, err
+	}
+	modes := defaultFileModes
+	modes.backup = settings.Output.Backup
+	if cmd.Flags().Changed("backup") {
+		modes.backup = backup
+	}
+	for _, m := range []struct {
+		name, value string
+		mode        *fs.FileMode
+	}{
+		{"output.fileMode", settings.Output.FileMode, &modes.file},
+		{"output.dirMode", settings.Output.DirMode, &modes.dir},
+		{"--output-mode", fileMode, &modes.file},
+		{"--output-dir-mode", dirMode, &modes.dir},
+	} {
+		if m.value == "" {
+			continue
+		}
+		mode, err := config.ParseMode(m.value)
+		if err != nil {
+			return fileModes{}, withExit(ExitConfig, fmt.Errorf("%s: %w", m.name, err))
+		}
+		*m.mode = mode
+	}
+	return modes, nil
+}
+
+// writeFile replaces the file at path with data; see writeFileWith.
+func writeFile(path string, modes fileModes, data []byte) error {
+	return writeFileWith(path, modes, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeFileWith replaces the file at path with the output of write. The
+// output goes to a temporary file next to it that is renamed into place
+// once write succeeded, so a failed or interrupted write leaves the file as
+// it was. With modes.backup the file replaced is kept as path.bak.
+func writeFileWith(path string, modes fileModes, write func(io.Writer) error) (err error) {
 	if path == "" {
 		return errors.New("output path is empty")
 	}
-	if err := ensureDir(filepath.Dir(path)); err != nil {
+	dir := filepath.Dir(path)
+	if err := ensureDir(dir, modes.dir); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("write output: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	w := bufio.NewWriter(f)
+	if err := write(w); err != nil {
 		return err
 	}
-	if err := os.WriteFile(path, data, 0o644); err != nil {
+	if err := w.Flush(); err != nil {
 		return fmt.Errorf("write output: %w", err)
 	}
+	if err := f.Chmod(modes.file); err != nil {
+		return fmt.Errorf("write output: %w", err)
+	}
+	// Without syncing, a crash after the rename may leave an empty file.
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("write output: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write output: %w", err)
+	}
+	if modes.backup {
+		if err := backupFile(path); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("write output: %w", err)
+	}
+	return nil
+}
+
+// backupFile keeps the file at path as path.bak, replacing an earlier
+// backup. The file stays in place, so that it is never missing while it is
+// being replaced.
+func backupFile(path string) error {
+	bak := path + ".bak"
+	if err := os.Remove(bak); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("back up %s: %w", path, err)
+	}
+	err := os.Link(path, bak)
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	// Some file systems have no hard links.
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("back up %s: %w", path, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("back up %s: %w", path, err)
+	}
+	if err := os.WriteFile(bak, data, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("back up %s: %w", path, err)
+	}
 	return nil
 }
 
-func ensureDir(dir string) error {
+// ensureDir creates dir and its missing parents with mode, less the umask.
+func ensureDir(dir string, mode fs.FileMode) error {
 	if dir == "" || dir == "." {
 		return nil
 	}
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+	if err := os.MkdirAll(dir, mode); err != nil {
 		return fmt.Errorf("ensure directory %s: %w", dir, err)
 	}
 	return nil
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/timing"
+)
+
+// reportTimings makes cmd and its sub-commands print the phases recorded
+// for --timings when they end, whether or not they failed.
+func reportTimings(cmd *cobra.Command) {
+	if run := cmd.RunE; run != nil {
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			err := run(cmd, args)
+			if r := timing.FromContext(cmd.Context()); r != nil {
+				writeTimings(cmd.ErrOrStderr(), r)
+			}
+			return err
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		reportTimings(sub)
+	}
+}
+
+func writeTimings(w io.Writer, r *timing.Recorder) {
+	fmt.Fprintln(w, "\nTimings:")
+	for _, t := range r.Totals() {
+		name := t.Phase
+		indent := "  "
+		if name == timing.Fetch || name == timing.Decrypt {
+			indent = "    "
+		}
+		line := fmt.Sprintf("%s%-*s %10s", indent, 16-len(indent)+2, name, roundDuration(t.Duration))
+		if t.Count > 1 {
+			line += fmt.Sprintf("  (%d)", t.Count)
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintf(w, "  %-16s %10s\n", "total", roundDuration(r.Elapsed()))
+}
+
+// roundDuration keeps durations readable: milliseconds, or microseconds
+// below one millisecond.
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(time.Millisecond)
+}
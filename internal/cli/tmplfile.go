@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/tmplfile"
+)
+
+// tmplfiles returns the values files that are tmplfiles.
+func tmplfiles(valuesFiles []string) []string {
+	var files []string
+	for _, f := range valuesFiles {
+		if tmplfile.IsFile(f) {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// runApplyFiles deploys the releases of tmplfiles in dependency order with
+// the remaining apply flags, stopping at the first failed release.
+func runApplyFiles(cmd *cobra.Command, files []string, opts *applyOptions) error {
+	var releases []tmplfile.Release
+	var sharedEnv [][]string
+	for _, path := range files {
+		f, err := tmplfile.Load(path)
+		if err != nil {
+			return err
+		}
+		ordered, err := f.Ordered()
+		if err != nil {
+			return err
+		}
+		for _, r := range ordered {
+			releases = append(releases, r)
+			sharedEnv = append(sharedEnv, f.EnvFiles)
+		}
+	}
+
+	w := cmd.OutOrStdout()
+	for i, r := range releases {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		target := r.Context
+		if target == "" {
+			target = "current context"
+		}
+		fmt.Fprintf(w, "==> %s (%s on %s)\n", r.Name, r.Chart, target)
+
+		chartDir, _, err := resolveChart(cmd, r.Chart, r.Version, verifyOptions{})
+		if err != nil {
+			return fmt.Errorf("release %s: %w", r.Name, err)
+		}
+		dockerOpts := opts.docker
+		if r.Context != "" {
+			dockerOpts.context, dockerOpts.host = r.Context, ""
+		}
+		client, err := newDockerClient(&dockerOpts)
+		if err != nil {
+			return fmt.Errorf("release %s: %w", r.Name, err)
+		}
+		relOpts := *opts
+		relOpts.valuesFiles = r.Values
+		relOpts.envFiles = append(append(append([]string{}, opts.envFiles...), sharedEnv[i]...), r.EnvFiles...)
+		relOpts.stackName = r.Name
+		if err := applyRelease(cmd, client, chartDir, &relOpts); err != nil {
+			err = fmt.Errorf("release %s: %w", r.Name, err)
+			if i > 0 {
+				// Earlier releases of the run stay deployed.
+				return &exitError{code: ExitPartialApply, err: err}
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/deploy"
+	"github.com/acebelowzero/tmpl/internal/release"
+)
+
+type uninstallOptions struct {
+	store       string
+	keepHistory bool
+	dryRun      bool
+	timeout     time.Duration
+	lockTimeout time.Duration
+	docker      dockerOptions
+}
+
+func newUninstallCmd() *cobra.Command {
+	opts := &uninstallOptions{}
+
+	cmd := &cobra.Command{
+		Use:     "uninstall STACK",
+		Aliases: []string{"destroy"},
+		Short:   "Remove a release and the objects it owns",
+		Long: `Remove the services, networks, configs and secrets owned by the release of
+a stack, then delete its recorded history. Objects of the stack owned by
+another release or deployed without tmpl are left in place. Named volumes
+are never removed.
+
+With --keep-history the revisions are kept and the deployed one is marked
+uninstalled, so the stack can later be restored with 'tmpl rollback'.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeReleases(&opts.store, &opts.docker),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withExit(ExitApply, runUninstall(cmd, namespaced(cmd, args[0]), opts))
+		},
+	}
+
+	addReleaseStoreFlag(cmd, &opts.store)
+	addLockFlag(cmd, &opts.lockTimeout)
+	addDockerFlags(cmd, &opts.docker)
+	cmd.Flags().BoolVar(&opts.keepHistory, "keep-history", false, "Keep the recorded revisions of the release")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "Show the objects that would be removed without removing them")
+	cmd.Flags().DurationVar(&opts.timeout, "timeout", 2*time.Minute, "How long to wait for service tasks to shut down")
+
+	return cmd
+}
+
+func runUninstall(cmd *cobra.Command, name string, opts *uninstallOptions) (err error) {
+	client, err := newDockerClient(&opts.docker)
+	if err != nil {
+		return err
+	}
+	store, err := openReleaseStore(cmd, opts.store, client)
+	if err != nil {
+		return err
+	}
+	releases, err := store.List(cmd.Context(), name)
+	if err != nil {
+		return err
+	}
+	if !opts.dryRun {
+		audited, aerr := startAudit(cmd, "uninstall")
+		if aerr != nil {
+			return aerr
+		}
+		// The record describes the last revision, the one removed.
+		var last *release.Release
+		if n := len(releases); n > 0 {
+			last = releases[n-1]
+		}
+		defer func() { audited.finish(name, last, err) }()
+		unlock, err := lockRelease(cmd, store, name, "uninstall", opts.lockTimeout)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), opts.timeout)
+	defer cancel()
+	changes, err := deploy.New(client).Uninstall(ctx, name, opts.dryRun)
+	w := cmd.OutOrStdout()
+	removed, kept := 0, 0
+	for _, c := range changes {
+		if c.Action != deploy.ActionDelete {
+			if len(c.Warnings) > 0 {
+				kept++
+				fmt.Fprintf(w, "  keep %s %s (%s)\n", c.Kind, c.Name, c.Warnings[0])
+			}
+			continue
+		}
+		removed++
+		fmt.Fprintf(w, "- remove %s %s\n", c.Kind, c.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 && len(releases) == 0 {
+		return fmt.Errorf("%w: %s", release.ErrNotFound, name)
+	}
+	if opts.dryRun {
+		fmt.Fprintf(w, "Stack %s: %d objects would be removed, %d kept", name, removed, kept)
+		if !opts.keepHistory {
+			fmt.Fprintf(w, ", %d revisions deleted", len(releases))
+		}
+		fmt.Fprintln(w)
+		return nil
+	}
+
+	if err := uninstallHistory(cmd, store, releases, opts.keepHistory); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Stack %s uninstalled: %d objects removed", name, removed)
+	if kept > 0 {
+		fmt.Fprintf(w, ", %d not owned by the release kept", kept)
+	}
+	fmt.Fprintln(w)
+	return nil
+}
+
+// uninstallHistory deletes the revisions of an uninstalled release, or
+// marks its deployed revisions uninstalled when keep is set.
+func uninstallHistory(cmd *cobra.Command, store release.Store, releases []*release.Release, keep bool) error {
+	var errs []error
+	for _, r := range releases {
+		switch {
+		case keep && r.Status == release.StatusDeployed:
+			if err := store.SetStatus(cmd.Context(), r.Name, r.Revision, release.StatusUninstalled); err != nil {
+				errs = append(errs, fmt.Errorf("mark revision %d of %s uninstalled: %w", r.Revision, r.Name, err))
+			}
+		case !keep:
+			if err := store.Delete(cmd.Context(), r.Name, r.Revision); err != nil {
+				errs = append(errs, fmt.Errorf("delete revision %d of %s: %w", r.Revision, r.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newUnlockCmd() *cobra.Command {
+	var store string
+	var dockerOpts dockerOptions
+
+	cmd := &cobra.Command{
+		Use:   "unlock STACK",
+		Short: "Remove the lock of a release left behind by an interrupted run",
+		Long: `Remove the lock an apply, rollback or adopt holds on a release while it
+runs. Only use it when the holder is known to have stopped, for example a
+cancelled CI job; unlocking a running operation lets another run overlap it.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeReleases(&store, &dockerOpts),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := newDockerClient(&dockerOpts)
+			if err != nil {
+				return err
+			}
+			s, err := openReleaseStore(cmd, store, client)
+			if err != nil {
+				return err
+			}
+			name := namespaced(cmd, args[0])
+			if err := s.Unlock(cmd.Context(), name); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Release %s unlocked\n", name)
+			return nil
+		},
+	}
+
+	addReleaseStoreFlag(cmd, &store)
+	addDockerFlags(cmd, &dockerOpts)
+
+	return cmd
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// updateOptions holds the flags overriding the rolling update settings of
+// a chart.
+type updateOptions struct {
+	parallelism       uint64
+	delay             time.Duration
+	order             string
+	rollbackOnFailure bool
+}
+
+func addUpdateFlags(cmd *cobra.Command, opts *updateOptions) {
+	cmd.Flags().Uint64Var(&opts.parallelism, "update-parallelism", 1, "Tasks updated at once for every service, 0 for all (overrides the chart)")
+	cmd.Flags().DurationVar(&opts.delay, "update-delay", 0, "Delay between updating task batches (overrides the chart)")
+	cmd.Flags().StringVar(&opts.order, "update-order", "", "Update order: start-first or stop-first (overrides the chart)")
+	cmd.Flags().BoolVar(&opts.rollbackOnFailure, "rollback-on-failure", false, "Roll services back when their update fails")
+}
+
+// updateFlagsChanged reports whether any update policy flag was set.
+func updateFlagsChanged(cmd *cobra.Command) bool {
+	for _, name := range []string{"update-parallelism", "update-delay", "update-order", "rollback-on-failure"} {
+		if cmd.Flags().Changed(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// policy returns the overrides set on the command line.
+func (o *updateOptions) policy(cmd *cobra.Command) (stack.UpdatePolicy, error) {
+	var p stack.UpdatePolicy
+	if cmd.Flags().Changed("update-parallelism") {
+		p.Parallelism = &o.parallelism
+	}
+	if cmd.Flags().Changed("update-delay") {
+		if o.delay < 0 {
+			return p, withExit(ExitConfig, errors.New("--update-delay must not be negative"))
+		}
+		p.Delay = &o.delay
+	}
+	switch o.order {
+	case "", stack.UpdateStartFirst, stack.UpdateStopFirst:
+		p.Order = o.order
+	default:
+		return p, fmt.Errorf("unknown --update-order %q, expected %s or %s", o.order, stack.UpdateStartFirst, stack.UpdateStopFirst)
+	}
+	p.RollbackOnFailure = o.rollbackOnFailure
+	return p, nil
+}
@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/values"
+)
+
+func newValuesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "values",
+		Short: "Inspect how the values of a chart are composed",
+	}
+
+	cmd.AddCommand(newValuesPrecedenceCmd())
+
+	return cmd
+}
+
+// precedence describes the values layers of a chart for
+// values explain-precedence.
+type precedence struct {
+	Chart  string            `json:"chart"`
+	Layers []precedenceLayer `json:"layers"`
+	// Keys counts the leaf keys of the merged values.
+	Keys int           `json:"keys"`
+	Env  precedenceEnv `json:"env"`
+}
+
+// precedenceLayer is a values layer with the number of leaf keys it sets,
+// of which Overrides were set by an earlier layer.
+type precedenceLayer struct {
+	Kind      string `json:"kind"`
+	Source    string `json:"source"`
+	Keys      int    `json:"keys"`
+	Overrides int    `json:"overrides"`
+}
+
+// precedenceEnv describes where ${VAR} references are expanded from, in
+// increasing precedence: the process environment, then env files.
+type precedenceEnv struct {
+	// Allow restricts the process environment when set.
+	Allow []string `json:"allow,omitempty"`
+	Files []string `json:"files,omitempty"`
+	// Expanded counts the variables values files referred to.
+	Expanded int `json:"expanded"`
+}
+
+// layerKinds names the kinds of values layers in tables.
+var layerKinds = map[string]string{
+	values.LayerLibrary:   "library default",
+	values.LayerChart:     "chart default",
+	values.LayerFile:      "values file",
+	values.LayerOverrides: "overrides",
+}
+
+func newValuesPrecedenceCmd() *cobra.Command {
+	var valuesFiles []string
+	var envFiles []string
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "explain-precedence [CHART]",
+		Short: "Show the values layers of a chart in merge order",
+		Long: `Show the layers the values of a chart are merged from, lowest precedence
+first, with the number of keys each sets and how many of those override an
+earlier layer:
+
+  1. the values.yaml of the library charts it depends on, dependencies
+     before the libraries needing them
+  2. the values.yaml of the chart
+  3. the -f values files, in the order given
+
+Maps are merged key by key; lists and other values of a later layer replace
+the earlier value as a whole. Before merging, ${VAR} references in every
+values file are expanded from the process environment, restricted by
+env.allow in the configuration, and from the --env-file files, which take
+precedence over it and over the files before them. Compose profiles select
+services, not values, and are not a layer.
+
+Use 'tmpl explain KEY' to see which layers set a given key.`,
+		Example: `  tmpl values explain-precedence
+  tmpl values explain-precedence ./charts/web -f values-prod.yaml --env-file prod.env`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chart := "."
+			if len(args) == 1 {
+				chart = args[0]
+			}
+			if format != "text" && format != "json" {
+				return withExit(ExitConfig, fmt.Errorf("unknown output format %q", format))
+			}
+			return runValuesPrecedence(cmd, chart, valuesFiles, envFiles, format)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&valuesFiles, "values", "f", nil, "Values files")
+	cmd.Flags().StringSliceVar(&envFiles, "env-file", nil, "Environment files for value expansion")
+	cmd.Flags().StringVarP(&format, "output", "o", "text", "Output format: text or json")
+
+	return cmd
+}
+
+func runValuesPrecedence(cmd *cobra.Command, chart string, valuesFiles, envFiles []string, format string) error {
+	cfg, err := loaderConfig(cmd, chart, envFiles)
+	if err != nil {
+		return err
+	}
+	loader, err := values.NewLoader(cfg)
+	if err != nil {
+		return withExit(ExitRender, fmt.Errorf("setup values loader: %w", err))
+	}
+	merged, err := loader.Load(cmd.Context(), chart, valuesFiles...)
+	if err != nil {
+		return withExit(ExitRender, fmt.Errorf("load values: %w", err))
+	}
+
+	p := &precedence{
+		Chart:  chart,
+		Layers: []precedenceLayer{},
+		Keys:   len(values.Keys(merged)),
+		Env:    precedenceEnv{Allow: cfg.AllowEnv, Files: envFiles, Expanded: len(loader.Env())},
+	}
+	set := map[string]bool{}
+	for _, layer := range loader.Layers() {
+		l := precedenceLayer{Kind: layer.Kind, Source: layer.Source}
+		for _, key := range values.Keys(layer.Values) {
+			l.Keys++
+			if set[key] {
+				l.Overrides++
+			}
+			set[key] = true
+		}
+		p.Layers = append(p.Layers, l)
+	}
+
+	w := cmd.OutOrStdout()
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p)
+	}
+	return writePrecedence(w, p)
+}
+
+func writePrecedence(w io.Writer, p *precedence) error {
+	fmt.Fprintf(w, "Values of %s, lowest precedence first:\n\n", p.Chart)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "  #\tLAYER\tSOURCE\tKEYS\tOVERRIDES")
+	for i, l := range p.Layers {
+		fmt.Fprintf(tw, "  %d\t%s\t%s\t%d\t%d\n", i+1, layerKinds[l.Kind], l.Source, l.Keys, l.Overrides)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(p.Layers) == 0 {
+		fmt.Fprintln(w, "  no values files")
+	}
+	fmt.Fprintf(w, "\nKeys after merging: %d. Maps are merged key by key; lists and other\nvalues replace the earlier value as a whole.\n", p.Keys)
+
+	fmt.Fprintln(w, "\n${VAR} references in values files are expanded before merging from:")
+	process := "the process environment"
+	if len(p.Env.Allow) > 0 {
+		process += ", only " + strings.Join(p.Env.Allow, ", ") + " (env.allow)"
+	}
+	fmt.Fprintf(w, "  1. %s\n", process)
+	if len(p.Env.Files) > 0 {
+		fmt.Fprintf(w, "  2. env files, later ones overriding: %s\n", strings.Join(p.Env.Files, ", "))
+	}
+	_, err := fmt.Fprintf(w, "Variables expanded: %d\n", p.Env.Expanded)
+	return err
+}
@@ -0,0 +1,393 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/compose"
+	"github.com/acebelowzero/tmpl/internal/lint"
+	"github.com/acebelowzero/tmpl/internal/render"
+	"github.com/acebelowzero/tmpl/internal/stack"
+	"github.com/acebelowzero/tmpl/internal/values"
+	"github.com/acebelowzero/tmpl/internal/version"
+)
+
+// Statuses of verify checks.
+const (
+	verifyPass = "pass"
+	verifyWarn = "warn"
+	verifyFail = "fail"
+	verifySkip = "skip"
+)
+
+// defaultFixture names the run of the checks on the chart defaults alone.
+const defaultFixture = "defaults"
+
+// verifyCheck is the outcome of a check of tmpl verify.
+type verifyCheck struct {
+	Name string `json:"name"`
+	// Fixture names the values file the check ran with, empty for checks
+	// of the chart itself.
+	Fixture    string   `json:"fixture,omitempty"`
+	Status     string   `json:"status"`
+	DurationMS int64    `json:"durationMs"`
+	Messages   []string `json:"messages,omitempty"`
+}
+
+// verifyReport is the summary of tmpl verify.
+type verifyReport struct {
+	Chart  string         `json:"chart"`
+	Passed bool           `json:"passed"`
+	Counts map[string]int `json:"counts"`
+	Checks []verifyCheck  `json:"checks"`
+}
+
+func newVerifyCmd() *cobra.Command {
+	var fixtures string
+	var envFiles []string
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "verify [CHART]",
+		Short: "Check a chart end to end: schema, lint, strict render and stack",
+		Long: `Check a chart end to end, as a single entry point for CI.
+
+The checks are:
+
+  chart       Chart.yaml is valid, the chart supports this version of tmpl;
+              a deprecated chart or library is a warning
+  lint        the lint rules, with the lint settings of the chart and of
+              the user configuration, on the chart defaults
+  schema      the values match the values schemas of the chart and its
+              libraries, skipped when there are none
+  render      the chart renders strictly: reading a values key that is not
+              set fails the render instead of rendering an empty value
+  compose     the rendered stack is a valid compose file that converts to
+              swarm services
+  references  the networks, named volumes, configs and secrets services use
+              are defined by the stack
+
+The schema, render, compose and references checks run once on the chart
+defaults and once per test fixture: every .yaml or .yml values file in the
+ci directory of the chart, or the directory given with --fixtures, in name
+order. Library charts are not rendered; only their chart and schema checks
+run.
+
+Every check runs even when an earlier one failed, except the checks of a
+fixture whose values cannot be loaded or whose stack does not render. With
+-o json the summary is written as JSON. The command exits with a non-zero
+status when a check failed; warnings do not fail it.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeChartDirs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chartDir := "."
+			if len(args) == 1 {
+				chartDir = args[0]
+			}
+			if format != "text" && format != "json" {
+				return withExit(ExitConfig, fmt.Errorf("unknown output format %q", format))
+			}
+			report, err := runVerify(cmd, chartDir, fixtures, envFiles)
+			if err != nil {
+				return err
+			}
+			if err := writeVerifyReport(cmd.OutOrStdout(), report, format); err != nil {
+				return err
+			}
+			if !report.Passed {
+				return withExit(ExitValidation, fmt.Errorf("verify failed: %d check(s) failed", report.Counts[verifyFail]))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&fixtures, "fixtures", "ci", "Directory of test values files, relative to the chart")
+	cmd.Flags().StringSliceVar(&envFiles, "env-file", nil, "Environment files for value expansion")
+	cmd.Flags().StringVarP(&format, "output", "o", "text", "Output format: text or json")
+
+	return cmd
+}
+
+// verifyRun records the checks of a verify run.
+type verifyRun struct {
+	report *verifyReport
+}
+
+// check runs fn as the check name of fixture and records its outcome: fn
+// returns the status and the messages explaining it.
+func (v *verifyRun) check(name, fixture string, fn func() (string, []string)) string {
+	start := time.Now()
+	status, messages := fn()
+	v.report.Checks = append(v.report.Checks, verifyCheck{
+		Name:       name,
+		Fixture:    fixture,
+		Status:     status,
+		DurationMS: time.Since(start).Milliseconds(),
+		Messages:   messages,
+	})
+	v.report.Counts[status]++
+	if status == verifyFail {
+		v.report.Passed = false
+	}
+	return status
+}
+
+// skip records the check name of fixture as skipped for reason.
+func (v *verifyRun) skip(name, fixture, reason string) {
+	v.check(name, fixture, func() (string, []string) { return verifySkip, []string{reason} })
+}
+
+func runVerify(cmd *cobra.Command, chartDir, fixturesDir string, envFiles []string) (*verifyReport, error) {
+	v := &verifyRun{report: &verifyReport{
+		Chart:  chartDir,
+		Passed: true,
+		Counts: map[string]int{verifyPass: 0, verifyWarn: 0, verifyFail: 0, verifySkip: 0},
+	}}
+	ctx := cmd.Context()
+
+	var meta *chart.Metadata
+	status := v.check("chart", "", func() (string, []string) {
+		var err error
+		if meta, err = chart.LoadMetadata(chartDir); err != nil {
+			return verifyFail, []string{err.Error()}
+		}
+		if err := meta.CheckTmplVersion(version.Version); err != nil {
+			return verifyFail, []string{err.Error()}
+		}
+		libs, err := chart.Libraries(chartDir)
+		if err != nil {
+			return verifyFail, []string{err.Error()}
+		}
+		var warnings []string
+		for _, m := range append([]*chart.Metadata{meta}, libraryMetadata(libs)...) {
+			if err := m.CheckDeprecated(); err != nil {
+				warnings = append(warnings, err.Error())
+			}
+		}
+		if len(warnings) > 0 {
+			return verifyWarn, warnings
+		}
+		return verifyPass, nil
+	})
+	if status == verifyFail {
+		// Nothing else can run without a chart.
+		return v.report, nil
+	}
+
+	// Invalid settings or fixtures are errors of the invocation, not
+	// failed checks.
+	lintCfg, threshold, err := lintSettings(cmd, chartDir, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	loaderCfg, err := loaderConfig(cmd, chartDir, envFiles)
+	if err != nil {
+		return nil, err
+	}
+	fixtures, err := verifyFixtures(chartDir, fixturesDir)
+	if err != nil {
+		return nil, withExit(ExitConfig, err)
+	}
+	schemas, err := chartSchemas(chartDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	library := meta.IsLibrary()
+	if library {
+		v.skip("lint", "", "library charts are not rendered")
+	} else {
+		v.check("lint", "", func() (string, []string) {
+			return verifyLint(cmd, chartDir, envFiles, lintCfg, threshold)
+		})
+	}
+
+	for _, fixture := range append([]string{""}, fixtures...) {
+		name := defaultFixture
+		var valuesFiles []string
+		if fixture != "" {
+			name = filepath.ToSlash(filepath.Join(fixturesDir, filepath.Base(fixture)))
+			valuesFiles = []string{fixture}
+		}
+
+		loader, err := values.NewLoader(loaderCfg)
+		if err != nil {
+			return nil, withExit(ExitRender, fmt.Errorf("setup values loader: %w", err))
+		}
+		merged, loadErr := loader.Load(ctx, chartDir, valuesFiles...)
+		if loadErr != nil {
+			v.check("schema", name, func() (string, []string) {
+				return verifyFail, []string{"load values: " + loadErr.Error()}
+			})
+		} else if len(schemas) == 0 {
+			v.skip("schema", name, "the chart has no values schema")
+		} else {
+			v.check("schema", name, func() (string, []string) {
+				var messages []string
+				for _, s := range schemas {
+					for _, e := range s.schema.Validate(merged) {
+						e.Chart = s.library
+						messages = append(messages, e.Error())
+					}
+				}
+				if len(messages) > 0 {
+					return verifyFail, messages
+				}
+				return verifyPass, nil
+			})
+		}
+
+		if library || loadErr != nil {
+			reason := "library charts are not rendered"
+			if loadErr != nil {
+				reason = "the values did not load"
+			}
+			for _, check := range []string{"render", "compose", "references"} {
+				v.skip(check, name, reason)
+			}
+			continue
+		}
+
+		var result *render.Result
+		status := v.check("render", name, func() (string, []string) {
+			renderer, err := render.New(render.Config{ChartPath: chartDir, Strict: true, Functions: functionPolicy(ctx)})
+			if err != nil {
+				return verifyFail, []string{err.Error()}
+			}
+			if result, err = renderer.Render(ctx, merged); err != nil {
+				return verifyFail, []string{err.Error()}
+			}
+			return verifyPass, nil
+		})
+		if status == verifyFail {
+			v.skip("compose", name, "the stack did not render")
+			v.skip("references", name, "the stack did not render")
+			continue
+		}
+
+		var parsed *compose.Stack
+		v.check("compose", name, func() (string, []string) {
+			var err error
+			if parsed, err = render.ParseStack(result.Output, result.SourceMap); err != nil {
+				return verifyFail, []string{err.Error()}
+			}
+			if _, err := stack.Convert(parsed, stack.Options{Name: meta.Name, BaseDir: chartDir}); err != nil {
+				return verifyFail, []string{err.Error()}
+			}
+			return verifyPass, nil
+		})
+		if parsed == nil {
+			v.skip("references", name, "the stack is not a compose file")
+			continue
+		}
+		v.check("references", name, func() (string, []string) {
+			if problems := parsed.UndefinedReferences(); len(problems) > 0 {
+				return verifyFail, problems
+			}
+			return verifyPass, nil
+		})
+	}
+	return v.report, nil
+}
+
+// verifyLint runs the lint rules on the chart defaults.
+func verifyLint(cmd *cobra.Command, chartDir string, envFiles []string, cfg lint.Config, threshold lint.Severity) (string, []string) {
+	linter, err := lint.New(cfg)
+	if err != nil {
+		return verifyFail, []string{err.Error()}
+	}
+	files, err := lint.ReadChartFiles(chartDir)
+	if err != nil {
+		return verifyFail, []string{fmt.Sprintf("read chart files: %s", err)}
+	}
+	merged, result, err := renderChart(cmd, chartDir, nil, envFiles)
+	if err != nil {
+		return verifyFail, []string{err.Error()}
+	}
+	input, err := lint.NewInput(merged, result)
+	if err != nil {
+		return verifyFail, []string{err.Error()}
+	}
+	input.Files, input.Dir = files, chartDir
+	report := linter.Run(input)
+	var messages []string
+	for _, f := range report.Findings {
+		if f.Severity >= lint.SeverityWarn {
+			messages = append(messages, f.String())
+		}
+	}
+	switch {
+	case threshold != lint.SeverityOff && report.Max() >= threshold:
+		return verifyFail, messages
+	case len(messages) > 0:
+		return verifyWarn, messages
+	}
+	return verifyPass, nil
+}
+
+// libraryMetadata returns the metadata of libs.
+func libraryMetadata(libs []chart.Library) []*chart.Metadata {
+	metas := make([]*chart.Metadata, 0, len(libs))
+	for _, lib := range libs {
+		metas = append(metas, lib.Metadata)
+	}
+	return metas
+}
+
+// verifyFixtures lists the values files in dir, relative to the chart, in
+// name order. A missing directory has none.
+func verifyFixtures(chartDir, dir string) ([]string, error) {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(chartDir, dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read fixtures: %w", err)
+	}
+	var fixtures []string
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.Type().IsRegular() && (ext == ".yaml" || ext == ".yml") {
+			fixtures = append(fixtures, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(fixtures)
+	return fixtures, nil
+}
+
+// writeVerifyReport writes report as a table of checks with the messages
+// of those that did not pass, or as JSON.
+func writeVerifyReport(w io.Writer, report *verifyReport, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	for _, c := range report.Checks {
+		line := fmt.Sprintf("%-4s  %-10s", strings.ToUpper(c.Status), c.Name)
+		if c.Fixture != "" {
+			line += "  " + c.Fixture
+		}
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+		for _, m := range c.Messages {
+			fmt.Fprintf(w, "      %s\n", m)
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d passed, %d warning(s), %d failed, %d skipped\n",
+		report.Counts[verifyPass], report.Counts[verifyWarn], report.Counts[verifyFail], report.Counts[verifySkip])
+	return err
+}
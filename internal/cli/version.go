@@ -1,11 +1,17 @@
 package cli
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/semver"
 	"github.com/acebelowzero/tmpl/internal/version"
 )
 
@@ -36,6 +42,8 @@ func newVersionCmd() *cobra.Command {
 
 	cmd.Flags().StringVarP(&format, "output", "o", "text", "Output format: text or json")
 
+	cmd.AddCommand(newVersionBumpCmd())
+
 	return cmd
 }
 
@@ -54,3 +62,125 @@ func runVersionJSON(cmd *cobra.Command) error {
 	enc.SetIndent("", "  ")
 	return enc.Encode(payload)
 }
+
+// bumpOptions holds the flags of the version bump command.
+type bumpOptions struct {
+	appVersion string
+	tag        bool
+	tagPrefix  string
+}
+
+func newVersionBumpCmd() *cobra.Command {
+	opts := &bumpOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "bump patch|minor|major|VERSION [CHART]",
+		Short: "Raise the version of a chart",
+		Long: `Raise the version of Chart.yaml to the next patch, minor or major release,
+or to an explicit VERSION. The current and the new version must be valid
+semantic versions and the new one must be greater. --app-version sets the
+appVersion along with it.
+
+With --tag, Chart.yaml is committed to git and the commit is tagged with
+the new version, prefixed by --tag-prefix. Other changes of the work tree
+are not part of the commit.`,
+		Args: cobra.RangeArgs(1, 2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 0 {
+				return filterPrefix([]string{"patch", "minor", "major"}, toComplete), cobra.ShellCompDirectiveNoFileComp
+			}
+			return completeChartDirs(cmd, args[1:], toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) == 2 {
+				dir = args[1]
+			}
+			return runVersionBump(cmd, dir, args[0], opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.appVersion, "app-version", "", "Also set appVersion to this value")
+	cmd.Flags().BoolVar(&opts.tag, "tag", false, "Commit Chart.yaml and tag the commit with the new version")
+	cmd.Flags().StringVar(&opts.tagPrefix, "tag-prefix", "v", "Prefix of the git tag")
+
+	return cmd
+}
+
+func runVersionBump(cmd *cobra.Command, dir, part string, opts *bumpOptions) error {
+	meta, err := chart.LoadMetadata(dir)
+	if err != nil {
+		return withExit(ExitConfig, err)
+	}
+	current, err := semver.Parse(meta.Version)
+	if err != nil {
+		return withExit(ExitConfig, fmt.Errorf("chart %s: %w", meta.Name, err))
+	}
+	next, err := nextVersion(current, part)
+	if err != nil {
+		return withExit(ExitConfig, err)
+	}
+
+	tag := opts.tagPrefix + next.String()
+	if opts.tag {
+		// Refuse before Chart.yaml is touched rather than leave a bumped
+		// but untagged chart behind.
+		if _, err := git(cmd, dir, "rev-parse", "--quiet", "--verify", "refs/tags/"+tag); err == nil {
+			return withExit(ExitConfig, fmt.Errorf("git tag %s already exists", tag))
+		}
+	}
+	if err := chart.SetVersion(dir, next.String(), opts.appVersion); err != nil {
+		return err
+	}
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Bumped %s from %s to %s\n", meta.Name, current, next)
+	if !opts.tag {
+		return nil
+	}
+
+	message := fmt.Sprintf("Release %s %s", meta.Name, next)
+	if _, err := git(cmd, dir, "commit", "--message", message, "--", chart.MetadataFile); err != nil {
+		return err
+	}
+	if _, err := git(cmd, dir, "tag", "--annotate", "--message", message, tag); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "Tagged %s\n", tag)
+	return err
+}
+
+// nextVersion returns the version part names relative to current, or part
+// itself when it is a version.
+func nextVersion(current semver.Version, part string) (semver.Version, error) {
+	switch part {
+	case "patch":
+		return current.IncPatch(), nil
+	case "minor":
+		return current.IncMinor(), nil
+	case "major":
+		return current.IncMajor(), nil
+	}
+	next, err := semver.Parse(part)
+	if err != nil {
+		return semver.Version{}, fmt.Errorf("expected patch, minor, major or a version: %w", err)
+	}
+	if !current.LessThan(next) {
+		return semver.Version{}, fmt.Errorf("version %s is not greater than the current %s", next, current)
+	}
+	return next, nil
+}
+
+// git runs a git command in dir and returns its output.
+func git(cmd *cobra.Command, dir string, args ...string) (string, error) {
+	c := exec.CommandContext(cmd.Context(), "git", append([]string{"-C", filepath.Clean(dir)}, args...)...)
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
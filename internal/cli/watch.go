@@ -0,0 +1,277 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/compose"
+	"github.com/acebelowzero/tmpl/internal/diff"
+	"github.com/acebelowzero/tmpl/internal/render"
+	"github.com/acebelowzero/tmpl/internal/telemetry"
+)
+
+// watchOptions holds the flags of template --watch. Its stack name and
+// docker flags also select the swarm of template --validate.
+type watchOptions struct {
+	enabled   bool
+	interval  time.Duration
+	apply     bool
+	stackName string
+	// render holds --profile and --pin-digests of template.
+	render renderFlags
+	docker dockerOptions
+	// metricsListen is the address of --metrics-listen, and metrics the
+	// handler serving them once enabled.
+	metricsListen string
+	metrics       http.Handler
+}
+
+func addWatchFlags(cmd *cobra.Command, opts *watchOptions) {
+	cmd.Flags().BoolVarP(&opts.enabled, "watch", "w", false, "Render again whenever the chart, its libraries, values, env or encrypted files change")
+	cmd.Flags().DurationVar(&opts.interval, "interval", 500*time.Millisecond, "How often --watch checks for changes")
+	cmd.Flags().BoolVar(&opts.apply, "apply", false, "With --watch, apply every render to the swarm without confirmation")
+	cmd.Flags().StringVar(&opts.stackName, "stack", "", "Release and stack name for .Release.Name, --apply and --validate (defaults to the chart name)")
+	cmd.Flags().StringVar(&opts.metricsListen, "metrics-listen", "", "With --watch, serve Prometheus metrics at /metrics on this address")
+	addDockerFlags(cmd, &opts.docker)
+}
+
+// enableMetrics prepares the metrics of --metrics-listen. It runs before
+// the command, so that its telemetry is collected for them.
+func (o *watchOptions) enableMetrics() error {
+	if o.metricsListen == "" {
+		return nil
+	}
+	if !o.enabled {
+		return withExit(ExitConfig, errors.New("--metrics-listen requires --watch"))
+	}
+	handler, err := telemetry.MetricsHandler()
+	if err != nil {
+		return err
+	}
+	o.metrics = handler
+	return nil
+}
+
+// fileState is what a watch compares to detect a changed file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchTemplate renders chartDir into output, written with modes, then
+// again whenever a file of the chart or its libraries, a local values file,
+// an env file or an encrypted file the values refer to changes, printing
+// what changed in the rendered stack. Render and apply failures are reported
+// and the watch goes on until the command is interrupted.
+func watchTemplate(cmd *cobra.Command, chartDir string, valuesFiles, envFiles []string, output string, modes fileModes, skipEmpty bool, le render.LineEndings, opts *watchOptions) error {
+	ctx := cmd.Context()
+	w := cmd.OutOrStdout()
+	color := useColor(cmd, w)
+	skip, err := filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+	// The files read by the last successful render are watched beside
+	// the chart, its libraries and the files and patches given, so that
+	// editing an encrypted file or a library renders again.
+	extra := append(append(append([]string{}, valuesFiles...), envFiles...), opts.render.patches...)
+	roots, watched := watchRoots(chartDir, opts.render.overlay), extra
+	// Renders only parse the templates again when one of them changed.
+	opts.render.cache = render.NewCache()
+	if opts.metrics != nil {
+		if err := serveMetrics(ctx, opts.metricsListen, opts.metrics); err != nil {
+			return err
+		}
+	}
+
+	var previous []byte
+	iteration := 0
+	run := func(changed []string) {
+		iteration++
+		if len(changed) > 0 {
+			fmt.Fprintf(w, "\n[%d] %s changed\n", iteration, strings.Join(changed, ", "))
+		}
+		_, rendered, loader, err := renderChartWith(cmd, opts.render.config(ctx, render.Config{ChartPath: chartDir, SkipEmpty: skipEmpty, ReleaseName: opts.stackName, Namespace: globalOptions(ctx).Namespace}), valuesFiles, envFiles)
+		if err != nil {
+			fmt.Fprintf(w, "[%d] render failed: %v\n", iteration, err)
+			return
+		}
+		roots, watched = watchRoots(chartDir, opts.render.overlay), append(slices.Clip(extra), loader.Files()...)
+		result, err := compose.Redact(rendered.Output)
+		if err != nil {
+			fmt.Fprintf(w, "[%d] render failed: redact secrets: %v\n", iteration, err)
+			return
+		}
+		if err := writeFile(output, modes, render.ConvertLineEndings(result, le)); err != nil {
+			fmt.Fprintf(w, "[%d] %v\n", iteration, err)
+			return
+		}
+		if previous == nil {
+			fmt.Fprintf(w, "[%d] rendered stack written to %s\n", iteration, output)
+		} else {
+			changes, err := diffRendered(previous, result, "previous render")
+			switch {
+			case err != nil:
+				fmt.Fprintf(w, "[%d] %v\n", iteration, err)
+			case len(changes) == 0:
+				fmt.Fprintf(w, "[%d] rendered stack unchanged\n", iteration)
+				return
+			default:
+				fmt.Fprintf(w, "[%d] rendered stack written to %s:\n", iteration, output)
+				if err := diff.Write(w, changes, color); err != nil {
+					fmt.Fprintf(w, "[%d] %v\n", iteration, err)
+				}
+			}
+		}
+		previous = result
+		if opts.apply {
+			if err := runApply(cmd, chartDir, watchApplyOptions(valuesFiles, envFiles, opts)); err != nil {
+				fmt.Fprintf(w, "[%d] apply failed: %v\n", iteration, err)
+			}
+		}
+	}
+
+	last := snapshotFiles(roots, watched, skip)
+	run(nil)
+	last = carryOver(last, snapshotFiles(roots, watched, skip))
+	fmt.Fprintf(w, "Watching %s for changes, press Ctrl-C to stop\n", chartDir)
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		current := snapshotFiles(roots, watched, skip)
+		if len(changedFiles(last, current)) == 0 {
+			continue
+		}
+		// Editors and generators often write several files in a row;
+		// render once they have been quiet for an interval.
+		for settled := false; !settled; {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+			next := snapshotFiles(roots, watched, skip)
+			settled = len(changedFiles(current, next)) == 0
+			current = next
+		}
+		changed := changedFiles(last, current)
+		last = current
+		run(changed)
+		last = carryOver(last, snapshotFiles(roots, watched, skip))
+	}
+}
+
+// watchApplyOptions are the apply settings of template --watch --apply: a
+// development loop that applies without asking.
+func watchApplyOptions(valuesFiles, envFiles []string, opts *watchOptions) *applyOptions {
+	return &applyOptions{
+		valuesFiles: valuesFiles,
+		envFiles:    envFiles,
+		stackName:   opts.stackName,
+		render:      opts.render,
+		docker:      opts.docker,
+		autoApprove: true,
+		format:      "text",
+		historyMax:  historyMaxFromEnv(),
+		// Development loops often deploy images built on the node.
+		skipImageCheck: true,
+	}
+}
+
+// watchRoots returns the directories a watch of chartDir walks: the chart,
+// the library charts it depends on and the overlay, if any.
+func watchRoots(chartDir, overlay string) []string {
+	roots := []string{chartDir}
+	if overlay != "" {
+		roots = append(roots, overlay)
+	}
+	libs, _ := chart.Libraries(chartDir)
+	for _, lib := range libs {
+		roots = append(roots, lib.Dir)
+	}
+	return roots
+}
+
+// carryOver returns the files of fresh with their state in last when it
+// has one. Files that started or stopped being watched with a render then
+// do not count as changed, while edits made during the render still do.
+func carryOver(last, fresh map[string]fileState) map[string]fileState {
+	for path := range fresh {
+		if state, ok := last[path]; ok {
+			fresh[path] = state
+		}
+	}
+	return fresh
+}
+
+// snapshotFiles records the state of every file below the roots, except
+// skip and hidden directories, and of the given extra files. Missing extra
+// files, such as remote values, are left out.
+func snapshotFiles(roots, extra []string, skip string) map[string]fileState {
+	out := map[string]fileState{}
+	for _, root := range roots {
+		walkWatched(root, skip, out)
+	}
+	for _, path := range extra {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			out[path] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return out
+}
+
+// walkWatched records the state of the files below root in out.
+func walkWatched(root, skip string, out map[string]fileState) {
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if abs, err := filepath.Abs(path); err == nil && abs == skip {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			out[path] = fileState{modTime: info.ModTime(), size: info.Size()}
+		}
+		return nil
+	})
+}
+
+// changedFiles lists the files added, removed or modified between two
+// snapshots.
+func changedFiles(old, new map[string]fileState) []string {
+	var changed []string
+	for path, state := range new {
+		if prev, ok := old[path]; !ok || prev.size != state.size || !prev.modTime.Equal(state.modTime) {
+			changed = append(changed, path)
+		}
+	}
+	for path := range old {
+		if _, ok := new[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
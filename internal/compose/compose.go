@@ -0,0 +1,258 @@
+package compose
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Stack is the subset of the compose v3 file format understood by tmpl.
+// Multiple rendered documents are merged into a single Stack.
+type Stack struct {
+	Version  string             `yaml:"version,omitempty" json:"version,omitempty"`
+	Services map[string]Service `yaml:"services,omitempty" json:"services,omitempty"`
+	Networks map[string]Network `yaml:"networks,omitempty" json:"networks,omitempty"`
+	Volumes  map[string]Volume  `yaml:"volumes,omitempty" json:"volumes,omitempty"`
+	Configs  map[string]Object  `yaml:"configs,omitempty" json:"configs,omitempty"`
+	Secrets  map[string]Object  `yaml:"secrets,omitempty" json:"secrets,omitempty"`
+}
+
+// Service describes a single compose service.
+type Service struct {
+	Image       string            `yaml:"image,omitempty" json:"image,omitempty"`
+	Command     StringList        `yaml:"command,omitempty" json:"command,omitempty"`
+	Entrypoint  StringList        `yaml:"entrypoint,omitempty" json:"entrypoint,omitempty"`
+	Environment Mapping           `yaml:"environment,omitempty" json:"environment,omitempty"`
+	Labels      Mapping           `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Hostname    string            `yaml:"hostname,omitempty" json:"hostname,omitempty"`
+	User        string            `yaml:"user,omitempty" json:"user,omitempty"`
+	WorkingDir  string            `yaml:"working_dir,omitempty" json:"working_dir,omitempty"`
+	StopSignal  string            `yaml:"stop_signal,omitempty" json:"stop_signal,omitempty"`
+	Ports       []Port            `yaml:"ports,omitempty" json:"ports,omitempty"`
+	Networks    ServiceNetworks   `yaml:"networks,omitempty" json:"networks,omitempty"`
+	Volumes     []VolumeMount     `yaml:"volumes,omitempty" json:"volumes,omitempty"`
+	Configs     []FileReference   `yaml:"configs,omitempty" json:"configs,omitempty"`
+	Secrets     []FileReference   `yaml:"secrets,omitempty" json:"secrets,omitempty"`
+	Healthcheck *Healthcheck      `yaml:"healthcheck,omitempty" json:"healthcheck,omitempty"`
+	Deploy      Deploy            `yaml:"deploy,omitempty" json:"deploy,omitempty"`
+	Profiles    []string          `yaml:"profiles,omitempty" json:"profiles,omitempty"`
+	Extensions  map[string]any    `yaml:",inline" json:"-"`
+	Sysctls     map[string]string `yaml:"sysctls,omitempty" json:"sysctls,omitempty"`
+}
+
+// Deploy holds swarm specific service settings.
+type Deploy struct {
+	Mode           string         `yaml:"mode,omitempty" json:"mode,omitempty"`
+	Replicas       *uint64        `yaml:"replicas,omitempty" json:"replicas,omitempty"`
+	Labels         Mapping        `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Placement      Placement      `yaml:"placement,omitempty" json:"placement,omitempty"`
+	Resources      Resources      `yaml:"resources,omitempty" json:"resources,omitempty"`
+	UpdateConfig   *UpdateConfig  `yaml:"update_config,omitempty" json:"update_config,omitempty"`
+	RollbackConfig *UpdateConfig  `yaml:"rollback_config,omitempty" json:"rollback_config,omitempty"`
+	RestartPolicy  *RestartPolicy `yaml:"restart_policy,omitempty" json:"restart_policy,omitempty"`
+	EndpointMode   string         `yaml:"endpoint_mode,omitempty" json:"endpoint_mode,omitempty"`
+}
+
+// Placement constrains where tasks are scheduled.
+type Placement struct {
+	Constraints []string              `yaml:"constraints,omitempty" json:"constraints,omitempty"`
+	Preferences []PlacementPreference `yaml:"preferences,omitempty" json:"preferences,omitempty"`
+	MaxReplicas uint64                `yaml:"max_replicas_per_node,omitempty" json:"max_replicas_per_node,omitempty"`
+}
+
+// PlacementPreference spreads tasks over a node label.
+type PlacementPreference struct {
+	Spread string `yaml:"spread" json:"spread"`
+}
+
+// Resources holds limits and reservations.
+type Resources struct {
+	Limits       *Resource `yaml:"limits,omitempty" json:"limits,omitempty"`
+	Reservations *Resource `yaml:"reservations,omitempty" json:"reservations,omitempty"`
+}
+
+// Resource is a CPU and memory amount, e.g. cpus: "0.5", memory: 256M.
+type Resource struct {
+	CPUs   string `yaml:"cpus,omitempty" json:"cpus,omitempty"`
+	Memory string `yaml:"memory,omitempty" json:"memory,omitempty"`
+}
+
+// UpdateConfig controls rolling updates and rollbacks.
+type UpdateConfig struct {
+	Parallelism     *uint64 `yaml:"parallelism,omitempty" json:"parallelism,omitempty"`
+	Delay           string  `yaml:"delay,omitempty" json:"delay,omitempty"`
+	FailureAction   string  `yaml:"failure_action,omitempty" json:"failure_action,omitempty"`
+	Monitor         string  `yaml:"monitor,omitempty" json:"monitor,omitempty"`
+	MaxFailureRatio float64 `yaml:"max_failure_ratio,omitempty" json:"max_failure_ratio,omitempty"`
+	Order           string  `yaml:"order,omitempty" json:"order,omitempty"`
+}
+
+// RestartPolicy controls task restarts.
+type RestartPolicy struct {
+	Condition   string  `yaml:"condition,omitempty" json:"condition,omitempty"`
+	Delay       string  `yaml:"delay,omitempty" json:"delay,omitempty"`
+	MaxAttempts *uint64 `yaml:"max_attempts,omitempty" json:"max_attempts,omitempty"`
+	Window      string  `yaml:"window,omitempty" json:"window,omitempty"`
+}
+
+// Healthcheck configures the container healthcheck.
+type Healthcheck struct {
+	Test        StringList `yaml:"test,omitempty" json:"test,omitempty"`
+	Interval    string     `yaml:"interval,omitempty" json:"interval,omitempty"`
+	Timeout     string     `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Retries     *uint64    `yaml:"retries,omitempty" json:"retries,omitempty"`
+	StartPeriod string     `yaml:"start_period,omitempty" json:"start_period,omitempty"`
+	Disable     bool       `yaml:"disable,omitempty" json:"disable,omitempty"`
+}
+
+// Network is a top-level network definition.
+type Network struct {
+	Driver     string            `yaml:"driver,omitempty" json:"driver,omitempty"`
+	DriverOpts map[string]string `yaml:"driver_opts,omitempty" json:"driver_opts,omitempty"`
+	Attachable bool              `yaml:"attachable,omitempty" json:"attachable,omitempty"`
+	Internal   bool              `yaml:"internal,omitempty" json:"internal,omitempty"`
+	External   bool              `yaml:"external,omitempty" json:"external,omitempty"`
+	Name       string            `yaml:"name,omitempty" json:"name,omitempty"`
+	Labels     Mapping           `yaml:"labels,omitempty" json:"labels,omitempty"`
+}
+
+// Volume is a top-level volume definition.
+type Volume struct {
+	Driver     string            `yaml:"driver,omitempty" json:"driver,omitempty"`
+	DriverOpts map[string]string `yaml:"driver_opts,omitempty" json:"driver_opts,omitempty"`
+	External   bool              `yaml:"external,omitempty" json:"external,omitempty"`
+	Name       string            `yaml:"name,omitempty" json:"name,omitempty"`
+	Labels     Mapping           `yaml:"labels,omitempty" json:"labels,omitempty"`
+}
+
+// Object is a top-level config or secret definition.
+type Object struct {
+	File     string  `yaml:"file,omitempty" json:"file,omitempty"`
+	Content  string  `yaml:"content,omitempty" json:"content,omitempty"`
+	External bool    `yaml:"external,omitempty" json:"external,omitempty"`
+	Name     string  `yaml:"name,omitempty" json:"name,omitempty"`
+	Labels   Mapping `yaml:"labels,omitempty" json:"labels,omitempty"`
+}
+
+// FileReference attaches a config or secret to a service.
+type FileReference struct {
+	Source string  `yaml:"source" json:"source"`
+	Target string  `yaml:"target,omitempty" json:"target,omitempty"`
+	UID    string  `yaml:"uid,omitempty" json:"uid,omitempty"`
+	GID    string  `yaml:"gid,omitempty" json:"gid,omitempty"`
+	Mode   *uint32 `yaml:"mode,omitempty" json:"mode,omitempty"`
+}
+
+// Parse decodes rendered output into a Stack. Later documents override
+// definitions of the same name from earlier documents.
+func Parse(data []byte) (*Stack, error) {
+	stack := &Stack{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for i := 0; ; i++ {
+		var doc Stack
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, decodeErrors(i+1, err)
+		}
+		stack.merge(&doc)
+	}
+	return stack, nil
+}
+
+// DecodeError reports a rendered document that is not a valid compose
+// document, such as one with a value of the wrong type.
+type DecodeError struct {
+	// Document is the 1-based index of the document in the output.
+	Document int
+	// Line is the line of the output the error is at, counted across
+	// documents from 1, or 0 when it is not known.
+	Line int
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("decode compose document %d: line %d: %v", e.Document, e.Line, e.Err)
+	}
+	return fmt.Sprintf("decode compose document %d: %v", e.Document, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decodeLinePattern matches the errors of the decoder and of the
+// unmarshalers of this package that tell the line they are at.
+var decodeLinePattern = regexp.MustCompile(`(?s)^(?:yaml: )?line (\d+): (.*)$`)
+
+// decodeErrors turns the error decoding document doc into a DecodeError,
+// or several joined for the type errors the decoder collects.
+func decodeErrors(doc int, err error) error {
+	messages := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+	errs := make([]error, 0, len(messages))
+	for _, msg := range messages {
+		e := &DecodeError{Document: doc, Err: err}
+		if match := decodeLinePattern.FindStringSubmatch(msg); match != nil {
+			e.Line, _ = strconv.Atoi(match[1])
+			e.Err = errors.New(match[2])
+		} else if typeErr != nil {
+			e.Err = errors.New(msg)
+		}
+		errs = append(errs, e)
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Stack) merge(doc *Stack) {
+	if doc.Version != "" {
+		s.Version = doc.Version
+	}
+	s.Services = mergeMap(s.Services, doc.Services)
+	s.Networks = mergeMap(s.Networks, doc.Networks)
+	s.Volumes = mergeMap(s.Volumes, doc.Volumes)
+	s.Configs = mergeMap(s.Configs, doc.Configs)
+	s.Secrets = mergeMap(s.Secrets, doc.Secrets)
+}
+
+func mergeMap[T any](dst, src map[string]T) map[string]T {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]T, len(src))
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+// ServiceNames returns the sorted service names.
+func (s *Stack) ServiceNames() []string {
+	return sortedKeys(s.Services)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
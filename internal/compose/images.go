@@ -0,0 +1,83 @@
+package compose
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ReplaceImages replaces the image of every service in rendered documents
+// with what replace returns for it, e.g. the image pinned to a digest.
+// Documents whose images are all kept are returned byte for byte and the
+// others are encoded again. It reports whether any image was replaced.
+func ReplaceImages(data []byte, replace func(image string) (string, error)) ([]byte, bool, error) {
+	if !bytes.Contains(data, []byte("image:")) {
+		return data, false, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	var replaced bool
+	err := EachDocument(bytes.NewReader(data), func(doc []byte, index int) error {
+		changed, err := imageDocument(&buf, doc, index, replace)
+		replaced = replaced || changed
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), replaced, nil
+}
+
+// imageDocument writes data, the text of the index'th document, to w with
+// the images of its services replaced and reports whether any was.
+func imageDocument(w io.Writer, data []byte, index int, replace func(string) (string, error)) (bool, error) {
+	docs, err := decodeDocument(data, index)
+	if err != nil {
+		return false, err
+	}
+	var replaced bool
+	for _, doc := range docs {
+		changed, err := replaceServiceImages(doc, replace)
+		if err != nil {
+			return false, err
+		}
+		replaced = replaced || changed
+	}
+	if !replaced {
+		if _, err := w.Write(data); err != nil {
+			return false, fmt.Errorf("write compose document %d: %w", index+1, err)
+		}
+		return false, nil
+	}
+	return true, encodeDocument(w, data, docs, index)
+}
+
+// replaceServiceImages replaces the images of the services of a document
+// node and reports whether any changed.
+func replaceServiceImages(doc *yaml.Node, replace func(string) (string, error)) (bool, error) {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return false, nil
+	}
+	services := mappingValue(doc.Content[0], "services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return false, nil
+	}
+	var replaced bool
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		image := mappingValue(services.Content[i+1], "image")
+		if image == nil || image.Kind != yaml.ScalarNode || image.Value == "" {
+			continue
+		}
+		ref, err := replace(image.Value)
+		if err != nil {
+			return false, fmt.Errorf("service %s: %w", services.Content[i].Value, err)
+		}
+		if ref != image.Value {
+			image.Value, image.Style = ref, 0
+			replaced = true
+		}
+	}
+	return replaced, nil
+}
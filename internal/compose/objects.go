@@ -0,0 +1,103 @@
+package compose
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// MaxObjectSize is the largest content swarm accepts for a config or a
+// secret.
+const MaxObjectSize = 500 * 1024
+
+// MaxObjectNameLength is the longest name swarm accepts for a config or a
+// secret.
+const MaxObjectNameLength = 64
+
+var (
+	// objectNamePattern matches the names swarm accepts for configs and
+	// secrets.
+	objectNamePattern = regexp.MustCompile(`^[a-zA-Z0-9]+(?:[a-zA-Z0-9-_.]*[a-zA-Z0-9])?$`)
+	// objectKeyPattern matches the keys of configs and secrets that make
+	// valid names once prefixed with the stack name and suffixed with their
+	// content digest.
+	objectKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9-_.]+$`)
+)
+
+// CheckObjectName returns an error when swarm rejects name as the name of
+// a config or secret.
+func CheckObjectName(name string) error {
+	if len(name) > MaxObjectNameLength {
+		return fmt.Errorf("name %s is %d characters, over the %d swarm allows for configs and secrets", name, len(name), MaxObjectNameLength)
+	}
+	if !objectNamePattern.MatchString(name) {
+		return fmt.Errorf("name %q must only hold letters, digits, '-', '_' and '.' and start and end with a letter or digit", name)
+	}
+	return nil
+}
+
+// CheckName returns an error when the config or secret key has a name
+// swarm rejects, as far as can be told without the stack name: explicit
+// names are checked in full, keys for the characters they may hold.
+// External objects are not created and have no constraints.
+func (o Object) CheckName(key string) error {
+	switch {
+	case o.External:
+		return nil
+	case o.Name != "":
+		return CheckObjectName(o.Name)
+	case !objectKeyPattern.MatchString(key):
+		return fmt.Errorf("key %q must only hold letters, digits, '-', '_' and '.' to name a swarm object", key)
+	}
+	return nil
+}
+
+// Size returns the size of the content of a config or secret: its inline
+// content or the file it reads, relative to baseDir. External objects
+// have none.
+func (o Object) Size(baseDir string) (int64, error) {
+	if o.External || o.File == "" {
+		return int64(len(o.Content)), nil
+	}
+	path := o.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// ObjectProblems describes the configs and secrets of the stack swarm
+// rejects, configs first and by name: those whose content exceeds
+// MaxObjectSize and those whose name is invalid as far as CheckName can
+// tell. Files are read relative to baseDir; those that cannot be read are
+// left to the deploy to report.
+func (s *Stack) ObjectProblems(baseDir string) []string {
+	var problems []string
+	for _, kind := range []struct {
+		name    string
+		objects map[string]Object
+	}{{"config", s.Configs}, {"secret", s.Secrets}} {
+		for _, name := range sortedKeys(kind.objects) {
+			obj := kind.objects[name]
+			if err := obj.CheckName(name); err != nil {
+				problems = append(problems, fmt.Sprintf("%s %s: %v", kind.name, name, err))
+			}
+			size, err := obj.Size(baseDir)
+			if err == nil && size > MaxObjectSize {
+				problems = append(problems, OversizedMessage(kind.name, name, size))
+			}
+		}
+	}
+	return problems
+}
+
+// OversizedMessage describes a config or secret of size bytes over
+// MaxObjectSize.
+func OversizedMessage(kind, name string, size int64) string {
+	return fmt.Sprintf("%s %s is %d KiB, over the %d KiB swarm limit for configs and secrets", kind, name, (size+1023)/1024, MaxObjectSize/1024)
+}
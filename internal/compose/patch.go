@@ -0,0 +1,656 @@
+package compose
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// patchDirective is the key of strategic merge directives: "$patch:
+// delete" removes the mapping or list item holding it, "$patch: replace"
+// replaces the mapping holding it instead of merging into it.
+const patchDirective = "$patch"
+
+// listMergeKeys are the service lists strategic merge patches merge item
+// by item, matching items by the given field, or by value for the short
+// syntax. Other lists are replaced.
+var listMergeKeys = map[string]string{
+	"volumes": "target",
+	"ports":   "target",
+	"configs": "source",
+	"secrets": "source",
+}
+
+// Patch is a change to rendered stacks, read from a YAML or JSON file. Each
+// document of the file is either a strategic merge patch, a partial
+// compose file merged into the stack, or a JSON patch (RFC 6902), a list of
+// operations on the stack.
+//
+// Every service, network, volume, config and secret of a merge patch is
+// merged into the last document defining it, which is the definition the
+// stack keeps, or added to the last document when none does. Mappings are
+// merged key by key, null values and "$patch: delete" remove what they
+// replace and "$patch: replace" replaces a mapping as a whole. The volumes,
+// ports, configs and secrets of services are merged item by item, other
+// lists are replaced.
+//
+// The operations of a JSON patch apply to the last document holding their
+// path, or the parent of their path for add operations.
+type Patch struct {
+	// Source names the patch in errors, usually its file.
+	Source string
+	steps  []patchStep
+}
+
+// patchStep is a document of a patch: either a merge patch or operations.
+type patchStep struct {
+	merge *yaml.Node
+	ops   []patchOp
+}
+
+// patchOp is a JSON patch operation.
+type patchOp struct {
+	op    string
+	path  []string
+	from  []string
+	value *yaml.Node
+	// desc names the operation in errors.
+	desc string
+}
+
+// LoadPatch reads the patch in the file at path.
+func LoadPatch(path string) (*Patch, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read patch: %w", err)
+	}
+	return ParsePatch(path, data)
+}
+
+// ParsePatch parses the patch in data, naming it source in errors.
+func ParsePatch(source string, data []byte) (*Patch, error) {
+	p := &Patch{Source: source}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for i := 0; ; i++ {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("patch %s: decode document %d: %w", source, i+1, err)
+		}
+		if len(doc.Content) == 0 || isNull(doc.Content[0]) {
+			continue
+		}
+		root := doc.Content[0]
+		switch root.Kind {
+		case yaml.MappingNode:
+			if err := checkDirectives(root); err != nil {
+				return nil, fmt.Errorf("patch %s: document %d: %w", source, i+1, err)
+			}
+			p.steps = append(p.steps, patchStep{merge: root})
+		case yaml.SequenceNode:
+			ops, err := parseOps(root)
+			if err != nil {
+				return nil, fmt.Errorf("patch %s: document %d: %w", source, i+1, err)
+			}
+			p.steps = append(p.steps, patchStep{ops: ops})
+		default:
+			return nil, fmt.Errorf("patch %s: document %d is neither a mapping to merge nor a list of operations", source, i+1)
+		}
+	}
+	return p, nil
+}
+
+// checkDirectives fails for "$patch" values other than delete, replace
+// and merge below node.
+func checkDirectives(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key, value := node.Content[i], node.Content[i+1]; key.Value == patchDirective {
+				switch value.Value {
+				case "delete", "replace", "merge":
+				default:
+					return fmt.Errorf("line %d: unknown %s directive %q, want delete, replace or merge", value.Line, patchDirective, value.Value)
+				}
+			}
+		}
+	}
+	for _, child := range node.Content {
+		if err := checkDirectives(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseOps parses the operations of a JSON patch.
+func parseOps(list *yaml.Node) ([]patchOp, error) {
+	ops := make([]patchOp, 0, len(list.Content))
+	for i, item := range list.Content {
+		if item.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("operation %d is not a mapping", i+1)
+		}
+		op := patchOp{}
+		var path, from string
+		var hasPath, hasFrom bool
+		for j := 0; j+1 < len(item.Content); j += 2 {
+			switch value := item.Content[j+1]; item.Content[j].Value {
+			case "op":
+				op.op = value.Value
+			case "path":
+				path, hasPath = value.Value, true
+			case "from":
+				from, hasFrom = value.Value, true
+			case "value":
+				op.value = value
+			}
+		}
+		op.desc = fmt.Sprintf("operation %d (%s %s)", i+1, op.op, path)
+		switch op.op {
+		case "add", "replace", "test":
+			if op.value == nil {
+				return nil, fmt.Errorf("%s: missing value", op.desc)
+			}
+		case "move", "copy":
+			if !hasFrom {
+				return nil, fmt.Errorf("%s: missing from", op.desc)
+			}
+		case "remove":
+		case "":
+			return nil, fmt.Errorf("operation %d: missing op", i+1)
+		default:
+			return nil, fmt.Errorf("%s: unknown op, want add, remove, replace, move, copy or test", op.desc)
+		}
+		if !hasPath {
+			return nil, fmt.Errorf("%s: missing path", op.desc)
+		}
+		var err error
+		if op.path, err = parsePointer(path); err != nil {
+			return nil, fmt.Errorf("%s: %w", op.desc, err)
+		}
+		if hasFrom {
+			if op.from, err = parsePointer(from); err != nil {
+				return nil, fmt.Errorf("%s: %w", op.desc, err)
+			}
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// parsePointer splits a JSON pointer (RFC 6901) into its unescaped
+// tokens. Pointers to the whole document are rejected, documents are
+// never replaced.
+func parsePointer(pointer string) ([]string, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q does not start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// ApplyPatches applies patches to rendered documents in order. Documents
+// the patches leave alone are returned byte for byte and the others are
+// encoded again; documents a merge patch adds definitions to when the
+// output has none are appended. It reports whether any document changed.
+func ApplyPatches(data []byte, patches []*Patch) ([]byte, bool, error) {
+	if len(patches) == 0 {
+		return data, false, nil
+	}
+	t := &patchTarget{}
+	err := EachDocument(bytes.NewReader(data), func(doc []byte, index int) error {
+		docs, err := decodeDocument(doc, index)
+		if err != nil {
+			return err
+		}
+		t.segments = append(t.segments, slices.Clone(doc))
+		t.docs = append(t.docs, docs)
+		t.changed = append(t.changed, false)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	for _, p := range patches {
+		for _, step := range p.steps {
+			if step.merge != nil {
+				t.merge(step.merge)
+				continue
+			}
+			for _, op := range step.ops {
+				if err := t.apply(op); err != nil {
+					return nil, false, fmt.Errorf("patch %s: %w", p.Source, err)
+				}
+			}
+		}
+	}
+	if !slices.Contains(t.changed, true) {
+		return data, false, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	for i, segment := range t.segments {
+		if !t.changed[i] {
+			buf.Write(segment)
+			continue
+		}
+		if err := encodeDocument(&buf, segment, t.docs[i], i); err != nil {
+			return nil, false, err
+		}
+	}
+	return buf.Bytes(), true, nil
+}
+
+// patchTarget is a rendered stack being patched: the text of its
+// documents, their nodes and whether they changed.
+type patchTarget struct {
+	segments [][]byte
+	docs     [][]*yaml.Node
+	changed  []bool
+}
+
+// last returns the root mapping of the last document for which match
+// returns true and the index of its segment, nil when there is none.
+func (t *patchTarget) last(match func(root *yaml.Node) bool) (*yaml.Node, int) {
+	for i := len(t.docs) - 1; i >= 0; i-- {
+		for j := len(t.docs[i]) - 1; j >= 0; j-- {
+			if root := documentRoot(t.docs[i][j]); root != nil && match(root) {
+				return root, i
+			}
+		}
+	}
+	return nil, -1
+}
+
+// lastOrNew is last, falling back to the last document that is a mapping
+// and then to a document appended to the output.
+func (t *patchTarget) lastOrNew(match func(root *yaml.Node) bool) (*yaml.Node, int) {
+	if root, i := t.last(match); root != nil {
+		return root, i
+	}
+	if root, i := t.last(func(*yaml.Node) bool { return true }); root != nil {
+		return root, i
+	}
+	var separator []byte
+	if len(t.segments) > 0 {
+		separator = []byte("---\n")
+	}
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	t.segments = append(t.segments, separator)
+	t.docs = append(t.docs, []*yaml.Node{{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}})
+	t.changed = append(t.changed, true)
+	return root, len(t.segments) - 1
+}
+
+// documentRoot returns the mapping at the root of doc, making empty
+// documents one, or nil when doc holds something else.
+func documentRoot(doc *yaml.Node) *yaml.Node {
+	if doc.Kind != yaml.DocumentNode {
+		return nil
+	}
+	if len(doc.Content) == 0 || isNull(doc.Content[0]) {
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	return doc.Content[0]
+}
+
+// merge applies a merge patch. The entries of its sections are merged into
+// the last document defining them, other keys into the last document
+// holding them.
+func (t *patchTarget) merge(patch *yaml.Node) {
+	for i := 0; i+1 < len(patch.Content); i += 2 {
+		key, value := patch.Content[i].Value, patch.Content[i+1]
+		if value.Kind != yaml.MappingNode || directive(value) != "" {
+			if deletes(value) {
+				t.removeAll(key)
+				continue
+			}
+			root, seg := t.lastOrNew(func(root *yaml.Node) bool { return mappingValue(root, key) != nil })
+			setValue(root, patch.Content[i], mergeNode(mappingValue(root, key), value, []string{key}))
+			t.changed[seg] = true
+			continue
+		}
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			entry, change := value.Content[j].Value, value.Content[j+1]
+			if deletes(change) {
+				t.removeAll(key, entry)
+				continue
+			}
+			root, seg := t.lastOrNew(func(root *yaml.Node) bool {
+				section := mappingValue(root, key)
+				return section != nil && mappingValue(section, entry) != nil
+			})
+			section := mappingValue(root, key)
+			if section == nil || section.Kind != yaml.MappingNode {
+				section = &yaml.Node{Kind: yaml.MappingNode}
+				setValue(root, patch.Content[i], section)
+			}
+			setValue(section, value.Content[j], mergeNode(mappingValue(section, entry), change, []string{key, entry}))
+			t.changed[seg] = true
+		}
+	}
+}
+
+// removeAll removes the value at path from every document holding it.
+func (t *patchTarget) removeAll(path ...string) {
+	for i, docs := range t.docs {
+		for _, doc := range docs {
+			parent := documentRoot(doc)
+			for _, key := range path[:len(path)-1] {
+				if parent == nil {
+					break
+				}
+				parent = mappingValue(parent, key)
+			}
+			if parent != nil && removeKey(parent, path[len(path)-1]) {
+				t.changed[i] = true
+			}
+		}
+	}
+}
+
+// mergeNode merges patch into dst, the value at path, and returns the
+// result. dst may be nil when there is none; patch is left unchanged.
+func mergeNode(dst, patch *yaml.Node, path []string) *yaml.Node {
+	switch {
+	case patch.Kind == yaml.MappingNode:
+		if dst == nil || dst.Kind != yaml.MappingNode || directive(patch) == "replace" {
+			return cleanCopy(patch)
+		}
+		for i := 0; i+1 < len(patch.Content); i += 2 {
+			key, value := patch.Content[i], patch.Content[i+1]
+			switch {
+			case key.Value == patchDirective:
+			case deletes(value):
+				removeKey(dst, key.Value)
+			default:
+				setValue(dst, key, mergeNode(mappingValue(dst, key.Value), value, append(slices.Clip(path), key.Value)))
+			}
+		}
+		return dst
+	case patch.Kind == yaml.SequenceNode && dst != nil && dst.Kind == yaml.SequenceNode:
+		if len(path) == 3 && path[0] == "services" {
+			if field, ok := listMergeKeys[path[2]]; ok {
+				return mergeList(dst, patch, field, path)
+			}
+		}
+	}
+	return cleanCopy(patch)
+}
+
+// mergeList merges the items of patch into those of dst matching them by
+// the field, or by value for scalar items.
+func mergeList(dst, patch *yaml.Node, field string, path []string) *yaml.Node {
+	for _, item := range patch.Content {
+		id, ok := listItemID(item, field)
+		index := -1
+		if ok {
+			index = slices.IndexFunc(dst.Content, func(existing *yaml.Node) bool {
+				existingID, ok := listItemID(existing, field)
+				return ok && existingID == id
+			})
+		}
+		switch {
+		case directive(item) == "delete":
+			if index >= 0 {
+				dst.Content = slices.Delete(dst.Content, index, index+1)
+			}
+		case index >= 0:
+			dst.Content[index] = mergeNode(dst.Content[index], item, path)
+		default:
+			dst.Content = append(dst.Content, cleanCopy(item))
+		}
+	}
+	return dst
+}
+
+// listItemID returns what identifies a list item: the field of mappings or
+// the value of scalars.
+func listItemID(item *yaml.Node, field string) (string, bool) {
+	switch item.Kind {
+	case yaml.ScalarNode:
+		return item.Value, true
+	case yaml.MappingNode:
+		if value := mappingValue(item, field); value != nil && value.Kind == yaml.ScalarNode {
+			return value.Value, true
+		}
+	}
+	return "", false
+}
+
+// directive returns the "$patch" value of a mapping node, if any.
+func directive(node *yaml.Node) string {
+	if value := mappingValue(node, patchDirective); value != nil {
+		return value.Value
+	}
+	return ""
+}
+
+// deletes reports whether a patch value removes what it replaces.
+func deletes(node *yaml.Node) bool {
+	return isNull(node) || directive(node) == "delete"
+}
+
+// isNull reports whether node is a null scalar.
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
+
+// cleanCopy returns a deep copy of a patch node without its directives and
+// without the list items and mapping values it deletes, so that patched
+// documents never share nodes with the patch.
+func cleanCopy(node *yaml.Node) *yaml.Node {
+	c := *node
+	c.Content = nil
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == patchDirective || deletes(node.Content[i+1]) {
+				continue
+			}
+			c.Content = append(c.Content, cleanCopy(node.Content[i]), cleanCopy(node.Content[i+1]))
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if directive(item) != "delete" {
+				c.Content = append(c.Content, cleanCopy(item))
+			}
+		}
+	default:
+		for _, child := range node.Content {
+			c.Content = append(c.Content, cleanCopy(child))
+		}
+	}
+	return &c
+}
+
+// copyNode returns a deep copy of node.
+func copyNode(node *yaml.Node) *yaml.Node {
+	c := *node
+	c.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		c.Content[i] = copyNode(child)
+	}
+	return &c
+}
+
+// setValue sets the value of key in a mapping node, appending the key when
+// the mapping lacks it.
+func setValue(mapping, key, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key.Value {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key.Value, Style: key.Style}, value)
+}
+
+// removeKey removes key from a mapping node and reports whether it had it.
+func removeKey(mapping *yaml.Node, key string) bool {
+	if mapping.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = slices.Delete(mapping.Content, i, i+2)
+			return true
+		}
+	}
+	return false
+}
+
+// apply applies a JSON patch operation to the last document holding its
+// path, or the parent of its path for add, move and copy.
+func (t *patchTarget) apply(op patchOp) error {
+	holds := func(path []string) func(*yaml.Node) bool {
+		return func(root *yaml.Node) bool { return lookup(root, path) != nil }
+	}
+	hasParent := func(root *yaml.Node) bool {
+		parent := lookup(root, op.path[:len(op.path)-1])
+		return parent != nil && (parent.Kind == yaml.MappingNode || parent.Kind == yaml.SequenceNode)
+	}
+
+	value := op.value
+	switch op.op {
+	case "test":
+		root, _ := t.last(holds(op.path))
+		if root == nil {
+			return fmt.Errorf("%s: path not found", op.desc)
+		}
+		var got, want any
+		if err := lookup(root, op.path).Decode(&got); err != nil {
+			return fmt.Errorf("%s: %w", op.desc, err)
+		}
+		if err := op.value.Decode(&want); err != nil {
+			return fmt.Errorf("%s: %w", op.desc, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("%s: test failed", op.desc)
+		}
+		return nil
+	case "remove", "replace":
+		root, seg := t.last(holds(op.path))
+		if root == nil {
+			return fmt.Errorf("%s: path not found", op.desc)
+		}
+		if op.op == "remove" {
+			removePath(root, op.path)
+		} else {
+			setPath(root, op.path, copyNode(op.value), true)
+		}
+		t.changed[seg] = true
+		return nil
+	case "move", "copy":
+		root, seg := t.last(holds(op.from))
+		if root == nil {
+			return fmt.Errorf("%s: from %s not found", op.desc, "/"+strings.Join(op.from, "/"))
+		}
+		value = lookup(root, op.from)
+		if op.op == "move" {
+			removePath(root, op.from)
+			t.changed[seg] = true
+		} else {
+			value = copyNode(value)
+		}
+	}
+	root, seg := t.last(hasParent)
+	if root == nil {
+		return fmt.Errorf("%s: parent of path not found", op.desc)
+	}
+	if value == op.value {
+		value = copyNode(value)
+	}
+	if !setPath(root, op.path, value, false) {
+		return fmt.Errorf("%s: index out of range", op.desc)
+	}
+	t.changed[seg] = true
+	return nil
+}
+
+// lookup returns the node at the tokens of a JSON pointer below root, nil
+// when there is none.
+func lookup(root *yaml.Node, tokens []string) *yaml.Node {
+	node := root
+	for _, token := range tokens {
+		switch node.Kind {
+		case yaml.MappingNode:
+			node = mappingValue(node, token)
+		case yaml.SequenceNode:
+			i, ok := listIndex(token, len(node.Content))
+			if !ok || i == len(node.Content) {
+				return nil
+			}
+			node = node.Content[i]
+		default:
+			return nil
+		}
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// setPath sets the node at the tokens of a JSON pointer, whose parent
+// exists, to value: an existing mapping key or list item is replaced, or,
+// unless replace is set, a key is added or an item inserted. It reports
+// whether a list index was in range.
+func setPath(root *yaml.Node, tokens []string, value *yaml.Node, replace bool) bool {
+	parent, last := lookup(root, tokens[:len(tokens)-1]), tokens[len(tokens)-1]
+	if parent.Kind == yaml.MappingNode {
+		setValue(parent, &yaml.Node{Kind: yaml.ScalarNode, Value: last}, value)
+		return true
+	}
+	i, ok := listIndex(last, len(parent.Content))
+	if !ok {
+		return false
+	}
+	if replace {
+		parent.Content[i] = value
+	} else {
+		parent.Content = slices.Insert(parent.Content, i, value)
+	}
+	return true
+}
+
+// removePath removes the node at the tokens of a JSON pointer, which
+// exists.
+func removePath(root *yaml.Node, tokens []string) {
+	parent, last := lookup(root, tokens[:len(tokens)-1]), tokens[len(tokens)-1]
+	if parent.Kind == yaml.MappingNode {
+		removeKey(parent, last)
+		return
+	}
+	i, _ := listIndex(last, len(parent.Content))
+	parent.Content = slices.Delete(parent.Content, i, i+1)
+}
+
+// listIndex parses a JSON pointer token indexing a list of n items, where
+// "-" is the index past the last item.
+func listIndex(token string, n int) (int, bool) {
+	if token == "-" {
+		return n, true
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > n || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, false
+	}
+	return i, true
+}
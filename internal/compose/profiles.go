@@ -0,0 +1,100 @@
+package compose
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AllProfiles activates every profile, as with docker compose --profile '*'.
+const AllProfiles = "*"
+
+// ProfileEnabled reports whether a service with profiles runs when the
+// active profiles are: services without profiles always run, the others
+// when one of their profiles is active.
+func ProfileEnabled(profiles, active []string) bool {
+	if len(profiles) == 0 || slices.Contains(active, AllProfiles) {
+		return true
+	}
+	for _, p := range profiles {
+		if slices.Contains(active, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectProfiles removes the services that are not enabled with the active
+// profiles from rendered documents, following docker compose. Documents
+// that lose no service are returned byte for byte and the others are
+// encoded again. It reports whether any service was removed.
+func SelectProfiles(data []byte, active []string) ([]byte, bool, error) {
+	if !bytes.Contains(data, []byte("profiles:")) {
+		return data, false, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	var removed bool
+	err := EachDocument(bytes.NewReader(data), func(doc []byte, index int) error {
+		changed, err := profileDocument(&buf, doc, index, active)
+		removed = removed || changed
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), removed, nil
+}
+
+// profileDocument writes data, the text of the index'th document, to w
+// without the services that are not enabled and reports whether there
+// were any.
+func profileDocument(w io.Writer, data []byte, index int, active []string) (bool, error) {
+	docs, err := decodeDocument(data, index)
+	if err != nil {
+		return false, err
+	}
+	var removed bool
+	for _, doc := range docs {
+		if removeDisabled(doc, active) {
+			removed = true
+		}
+	}
+	if !removed {
+		if _, err := w.Write(data); err != nil {
+			return false, fmt.Errorf("write compose document %d: %w", index+1, err)
+		}
+		return false, nil
+	}
+	return true, encodeDocument(w, data, docs, index)
+}
+
+// removeDisabled removes the services of a document node that are not
+// enabled and reports whether any was.
+func removeDisabled(doc *yaml.Node, active []string) bool {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return false
+	}
+	services := mappingValue(doc.Content[0], "services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return false
+	}
+	kept := services.Content[:0]
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		var profiles []string
+		if node := mappingValue(services.Content[i+1], "profiles"); node != nil && node.Kind == yaml.SequenceNode {
+			for _, p := range node.Content {
+				profiles = append(profiles, p.Value)
+			}
+		}
+		if ProfileEnabled(profiles, active) {
+			kept = append(kept, services.Content[i], services.Content[i+1])
+		}
+	}
+	removed := len(kept) < len(services.Content)
+	services.Content = kept
+	return removed
+}
@@ -0,0 +1,182 @@
+package compose
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RedactedPrefix starts the reference that replaces inline secret content
+// in redacted documents, followed by the digest of the content.
+const RedactedPrefix = "redacted:"
+
+// Redact returns rendered documents with the inline content of top-level
+// secrets replaced by a reference to its digest, so they can be written to
+// disk or stored with a release. The reference still changes with the
+// content, which keeps diffs of redacted documents meaningful. Documents
+// without inline secret content are returned unchanged.
+func Redact(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	if err := RedactStream(&buf, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RedactStream is Redact that copies the documents read from r to w one at
+// a time, so that only a single document is held in memory. Documents are
+// split at the --- lines that start them; documents without inline secret
+// content are copied byte for byte and the others are encoded again.
+func RedactStream(w io.Writer, r io.Reader) error {
+	return EachDocument(r, func(data []byte, index int) error {
+		return redactDocument(w, data, index)
+	})
+}
+
+// EachDocument calls fn with the text of every document read from r, split
+// at the --- lines that start them, one document at a time.
+func EachDocument(r io.Reader, fn func(data []byte, index int) error) error {
+	br := bufio.NewReader(r)
+	var doc bytes.Buffer
+	for i := 0; ; {
+		line, err := br.ReadBytes('\n')
+		eof := errors.Is(err, io.EOF)
+		if err != nil && !eof {
+			return fmt.Errorf("read compose document %d: %w", i+1, err)
+		}
+		if doc.Len() > 0 && startsDocument(line) {
+			if err := fn(doc.Bytes(), i); err != nil {
+				return err
+			}
+			doc.Reset()
+			i++
+		}
+		doc.Write(line)
+		if eof {
+			if doc.Len() == 0 {
+				return nil
+			}
+			return fn(doc.Bytes(), i)
+		}
+	}
+}
+
+// startsDocument reports whether the first line of data is a --- marker
+// that starts a YAML document.
+func startsDocument(data []byte) bool {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return bytes.Equal(line, []byte("---")) || bytes.HasPrefix(line, []byte("--- ")) || bytes.HasPrefix(line, []byte("---\t"))
+}
+
+// redactDocument writes data, the text of the index'th document, to w,
+// encoded again when it has inline secret content.
+func redactDocument(w io.Writer, data []byte, index int) error {
+	docs, err := decodeDocument(data, index)
+	if err != nil {
+		return err
+	}
+	var redacted bool
+	for _, doc := range docs {
+		if redactSecrets(doc) {
+			redacted = true
+		}
+	}
+	if !redacted {
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("write compose document %d: %w", index+1, err)
+		}
+		return nil
+	}
+	return encodeDocument(w, data, docs, index)
+}
+
+// decodeDocument decodes data, the text of the index'th document.
+func decodeDocument(data []byte, index int) ([]*yaml.Node, error) {
+	var docs []*yaml.Node
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decode compose document %d: %w", index+1, err)
+		}
+		docs = append(docs, &doc)
+	}
+}
+
+// encodeDocument writes docs, decoded from data, the text of the index'th
+// document, to w, keeping the --- that started it.
+func encodeDocument(w io.Writer, data []byte, docs []*yaml.Node, index int) error {
+	var buf bytes.Buffer
+	if startsDocument(data) {
+		buf.WriteString("---\n")
+	}
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	for _, doc := range docs {
+		if err := enc.Encode(doc); err != nil {
+			return fmt.Errorf("encode compose document %d: %w", index+1, err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("encode compose document %d: %w", index+1, err)
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("write compose document %d: %w", index+1, err)
+	}
+	return nil
+}
+
+// RedactedReference returns the reference that replaces secret content.
+func RedactedReference(content []byte) string {
+	sum := sha256.Sum256(content)
+	return RedactedPrefix + "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// redactSecrets replaces the content of the secrets of a document node and
+// reports whether any was found.
+func redactSecrets(doc *yaml.Node) bool {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return false
+	}
+	secrets := mappingValue(doc.Content[0], "secrets")
+	if secrets == nil || secrets.Kind != yaml.MappingNode {
+		return false
+	}
+	var redacted bool
+	for i := 1; i < len(secrets.Content); i += 2 {
+		content := mappingValue(secrets.Content[i], "content")
+		if content == nil || content.Kind != yaml.ScalarNode || strings.HasPrefix(content.Value, RedactedPrefix) {
+			continue
+		}
+		content.Value = RedactedReference([]byte(content.Value))
+		content.Tag = "!!str"
+		content.Style = 0
+		redacted = true
+	}
+	return redacted
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package compose
+
+import "fmt"
+
+// defaultNetwork is the network services without networks are attached
+// to, which stacks need not define.
+const defaultNetwork = "default"
+
+// UndefinedReferences describes the networks, named volumes, configs and
+// secrets services use without the stack defining them at the top level,
+// which docker stack deploy rejects, one message per reference in the
+// order of the service names.
+func (s *Stack) UndefinedReferences() []string {
+	var problems []string
+	for _, name := range s.ServiceNames() {
+		svc := s.Services[name]
+		for _, net := range svc.Networks.Names() {
+			if _, ok := s.Networks[net]; !ok && net != defaultNetwork {
+				problems = append(problems, fmt.Sprintf("service %s: network %s is not defined", name, net))
+			}
+		}
+		for _, v := range svc.Volumes {
+			if v.Type != "volume" || v.Source == "" {
+				continue
+			}
+			if _, ok := s.Volumes[v.Source]; !ok {
+				problems = append(problems, fmt.Sprintf("service %s: volume %s is not defined", name, v.Source))
+			}
+		}
+		for _, ref := range svc.Configs {
+			if _, ok := s.Configs[ref.Source]; !ok {
+				problems = append(problems, fmt.Sprintf("service %s: config %s is not defined", name, ref.Source))
+			}
+		}
+		for _, ref := range svc.Secrets {
+			if _, ok := s.Secrets[ref.Source]; !ok {
+				problems = append(problems, fmt.Sprintf("service %s: secret %s is not defined", name, ref.Source))
+			}
+		}
+	}
+	return problems
+}
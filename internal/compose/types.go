@@ -0,0 +1,203 @@
+package compose
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StringList accepts either a string or a list of strings. A plain string
+// is kept as a single element; shell splitting is left to the consumer.
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*l = StringList{node.Value}
+		return nil
+	case yaml.SequenceNode:
+		var items []string
+		if err := node.Decode(&items); err != nil {
+			return err
+		}
+		*l = items
+		return nil
+	default:
+		return fmt.Errorf("line %d: expected string or list", node.Line)
+	}
+}
+
+// Mapping accepts either a map or a list of KEY=VALUE entries.
+type Mapping map[string]string
+
+func (m *Mapping) UnmarshalYAML(node *yaml.Node) error {
+	out := Mapping{}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			out[node.Content[i].Value] = node.Content[i+1].Value
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			key, val, _ := strings.Cut(item.Value, "=")
+			out[key] = val
+		}
+	default:
+		return fmt.Errorf("line %d: expected map or list of KEY=VALUE", node.Line)
+	}
+	*m = out
+	return nil
+}
+
+// Keys returns the sorted keys of the mapping.
+func (m Mapping) Keys() []string {
+	return sortedKeys(m)
+}
+
+// Pairs returns KEY=VALUE entries sorted by key.
+func (m Mapping) Pairs() []string {
+	pairs := make([]string, 0, len(m))
+	for _, k := range m.Keys() {
+		pairs = append(pairs, k+"="+m[k])
+	}
+	return pairs
+}
+
+// Port is a published port in long syntax; short syntax is converted.
+type Port struct {
+	Target    uint32 `yaml:"target" json:"target"`
+	Published uint32 `yaml:"published,omitempty" json:"published,omitempty"`
+	Protocol  string `yaml:"protocol,omitempty" json:"protocol,omitempty"`
+	Mode      string `yaml:"mode,omitempty" json:"mode,omitempty"`
+}
+
+func (p *Port) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		type plain Port
+		return node.Decode((*plain)(p))
+	}
+	parsed, err := parseShortPort(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*p = parsed
+	return nil
+}
+
+// parseShortPort parses "[HOST:]CONTAINER[/PROTOCOL]". Host IPs and port
+// ranges are not supported by swarm's ingress and are rejected.
+func parseShortPort(raw string) (Port, error) {
+	spec, proto, _ := strings.Cut(raw, "/")
+	parts := strings.Split(spec, ":")
+	port := Port{Protocol: proto}
+	var err error
+	switch len(parts) {
+	case 1:
+		port.Target, err = parsePortNumber(parts[0])
+	case 2:
+		if port.Published, err = parsePortNumber(parts[0]); err == nil {
+			port.Target, err = parsePortNumber(parts[1])
+		}
+	default:
+		return Port{}, fmt.Errorf("unsupported port %q", raw)
+	}
+	if err != nil {
+		return Port{}, fmt.Errorf("invalid port %q: %w", raw, err)
+	}
+	return port, nil
+}
+
+func parsePortNumber(s string) (uint32, error) {
+	n, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(n), nil
+}
+
+// ServiceNetworks accepts a list of names or a map of name to attachment
+// options, keeping only aliases.
+type ServiceNetworks map[string]*NetworkAttachment
+
+// NetworkAttachment holds per-network service options.
+type NetworkAttachment struct {
+	Aliases []string `yaml:"aliases,omitempty" json:"aliases,omitempty"`
+}
+
+func (n *ServiceNetworks) UnmarshalYAML(node *yaml.Node) error {
+	out := ServiceNetworks{}
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			out[item.Value] = nil
+		}
+	case yaml.MappingNode:
+		var m map[string]*NetworkAttachment
+		if err := node.Decode(&m); err != nil {
+			return err
+		}
+		for k, v := range m {
+			out[k] = v
+		}
+	default:
+		return fmt.Errorf("line %d: expected list or map of networks", node.Line)
+	}
+	*n = out
+	return nil
+}
+
+// Names returns the sorted network names.
+func (n ServiceNetworks) Names() []string {
+	names := make([]string, 0, len(n))
+	for k := range n {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// VolumeMount is a service volume in long syntax; short syntax is converted.
+type VolumeMount struct {
+	Type     string `yaml:"type,omitempty" json:"type,omitempty"`
+	Source   string `yaml:"source,omitempty" json:"source,omitempty"`
+	Target   string `yaml:"target" json:"target"`
+	ReadOnly bool   `yaml:"read_only,omitempty" json:"read_only,omitempty"`
+}
+
+func (v *VolumeMount) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		type plain VolumeMount
+		return node.Decode((*plain)(v))
+	}
+	parts := strings.Split(node.Value, ":")
+	mount := VolumeMount{Type: "volume"}
+	switch len(parts) {
+	case 1:
+		mount.Target = parts[0]
+	case 2, 3:
+		mount.Source, mount.Target = parts[0], parts[1]
+		if len(parts) == 3 {
+			mount.ReadOnly = strings.Contains(parts[2], "ro")
+		}
+	default:
+		return fmt.Errorf("line %d: invalid volume %q", node.Line, node.Value)
+	}
+	if strings.HasPrefix(mount.Source, "/") || strings.HasPrefix(mount.Source, ".") {
+		mount.Type = "bind"
+	}
+	*v = mount
+	return nil
+}
+
+// FileReference accepts the short syntax of a bare config or secret name.
+func (f *FileReference) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*f = FileReference{Source: node.Value}
+		return nil
+	}
+	type plain FileReference
+	return node.Decode((*plain)(f))
+}
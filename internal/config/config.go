@@ -0,0 +1,567 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/audit"
+	"github.com/acebelowzero/tmpl/internal/lint"
+	"github.com/acebelowzero/tmpl/internal/notify"
+	"github.com/acebelowzero/tmpl/internal/oci"
+	"github.com/acebelowzero/tmpl/internal/paths"
+	"github.com/acebelowzero/tmpl/internal/scan"
+	"github.com/acebelowzero/tmpl/internal/sops"
+)
+
+const (
+	// FileName is the user configuration file in the tmpl config dir.
+	FileName = "config.yaml"
+	// ChartFileName is the configuration file of a chart, next to
+	// Chart.yaml.
+	ChartFileName = ".tmpl.yaml"
+)
+
+// Config holds defaults for commands, read from the user configuration
+// file and from the configuration file of a chart:
+//
+//	registries:
+//	  acme: oci://ghcr.io/acme/charts
+//	env:
+//	  allow: [APP_*, IMAGE_TAG]
+//	cache:
+//	  dir: /var/cache/tmpl
+//	output:
+//	  dir: build
+//	  fileMode: "0600"
+//	lint:
+//	  failOn: warn
+//	  severity:
+//	    latest-tag: error
+//	audit:
+//	  sink: s3://acme-audit/tmpl
+//	releases:
+//	  encrypt: [age:age1...]
+//	scan:
+//	  scanner: trivy
+//	  failOn: high
+//	  ignore: [CVE-2023-12345]
+//	functions:
+//	  deny: [tpl, Files]
+//	notify:
+//	  webhooks:
+//	    - url: ${SLACK_WEBHOOK_URL}
+//	      format: slack
+//	      events: [success, failure]
+//
+// Flags and environment variables take precedence over both files, and
+// the chart file over the user file. The audit sink, release keys,
+// template functions and webhooks are only read from the user file, so a
+// chart cannot redirect the audit log, release state or notifications, or
+// lift the restrictions it is rendered with.
+type Config struct {
+	// Registries maps names to OCI repository prefixes, so NAME/CHART
+	// can stand for the full reference in push and pull.
+	Registries map[string]string `yaml:"registries,omitempty" json:"registries,omitempty"`
+	Env        Env               `yaml:"env,omitempty" json:"env,omitempty"`
+	Cache      Cache             `yaml:"cache,omitempty" json:"cache,omitempty"`
+	Output     Output            `yaml:"output,omitempty" json:"output,omitempty"`
+	Lint       Lint              `yaml:"lint,omitempty" json:"lint,omitempty"`
+	Audit      Audit             `yaml:"audit,omitempty" json:"audit,omitempty"`
+	Releases   Releases          `yaml:"releases,omitempty" json:"releases,omitempty"`
+	Scan       Scan              `yaml:"scan,omitempty" json:"scan,omitempty"`
+	Functions  Functions         `yaml:"functions,omitempty" json:"functions,omitempty"`
+	Notify     Notify            `yaml:"notify,omitempty" json:"notify,omitempty"`
+}
+
+// Env restricts the expansion of ${VAR} in values files.
+type Env struct {
+	// Allow lists the process environment variables, by name or glob
+	// pattern, that values may expand. Empty allows all of them.
+	// Variables of env files are always expanded.
+	Allow []string `yaml:"allow,omitempty" json:"allow,omitempty"`
+}
+
+// Cache configures where downloaded charts and indexes are kept.
+type Cache struct {
+	// Dir replaces the default cache directory; $TMPL_CACHE_DIR takes
+	// precedence.
+	Dir string `yaml:"dir,omitempty" json:"dir,omitempty"`
+}
+
+// Output configures where commands write files by default.
+type Output struct {
+	// Dir receives rendered stacks and chart archives when no output
+	// path is given.
+	Dir string `yaml:"dir,omitempty" json:"dir,omitempty"`
+	// FileMode and DirMode are the octal permissions of rendered stacks
+	// and of the directories created for them, 0644 and 0755 by default.
+	FileMode string `yaml:"fileMode,omitempty" json:"fileMode,omitempty"`
+	DirMode  string `yaml:"dirMode,omitempty" json:"dirMode,omitempty"`
+	// Backup keeps the rendered stack a render replaces as FILE.bak.
+	Backup bool `yaml:"backup,omitempty" json:"backup,omitempty"`
+}
+
+// Lint holds defaults of tmpl lint.
+type Lint struct {
+	// FailOn is the lowest severity that fails the command.
+	FailOn string `yaml:"failOn,omitempty" json:"failOn,omitempty"`
+	// Severity overrides the severity of rules by name.
+	Severity map[string]string `yaml:"severity,omitempty" json:"severity,omitempty"`
+}
+
+// Audit configures where mutating operations are recorded.
+type Audit struct {
+	// Sink is a file path, an s3://BUCKET/PREFIX location or a webhook
+	// URL; $TMPL_AUDIT_SINK takes precedence.
+	Sink string `yaml:"sink,omitempty" json:"sink,omitempty"`
+}
+
+// Releases configures how release revisions are stored.
+type Releases struct {
+	// Encrypt lists the sops keys release content is encrypted for, as
+	// TYPE:KEY with TYPE age, pgp, kms, gcp-kms, azure-kv or
+	// hc-vault-transit; $TMPL_RELEASE_ENCRYPT takes precedence.
+	Encrypt []string `yaml:"encrypt,omitempty" json:"encrypt,omitempty"`
+}
+
+// Scan configures the vulnerability scan of the images of a plan.
+type Scan struct {
+	// Scanner is trivy or grype; setting it enables the scan.
+	Scanner string `yaml:"scanner,omitempty" json:"scanner,omitempty"`
+	// Server is the URL of a trivy server scanning instead of the local
+	// vulnerability database.
+	Server string `yaml:"server,omitempty" json:"server,omitempty"`
+	// FailOn is the lowest severity that fails the plan, critical by
+	// default, and WarnOn the lowest reported, high by default.
+	FailOn string `yaml:"failOn,omitempty" json:"failOn,omitempty"`
+	WarnOn string `yaml:"warnOn,omitempty" json:"warnOn,omitempty"`
+	// Ignore lists vulnerability IDs that are accepted.
+	Ignore []string `yaml:"ignore,omitempty" json:"ignore,omitempty"`
+}
+
+// Functions restricts the functions chart templates can call, by name or
+// glob pattern; Files stands for .Files.
+type Functions struct {
+	// Allow, when set, lists the only tmpl functions available. The
+	// functions of text/template are only restricted by Deny.
+	Allow []string `yaml:"allow,omitempty" json:"allow,omitempty"`
+	// Deny lists functions that are not available.
+	Deny []string `yaml:"deny,omitempty" json:"deny,omitempty"`
+}
+
+// Notify configures the webhooks notified of applies and rollbacks.
+type Notify struct {
+	Webhooks []Webhook `yaml:"webhooks,omitempty" json:"webhooks,omitempty"`
+}
+
+// Webhook is an endpoint receiving deployment events.
+type Webhook struct {
+	// URL may refer to environment variables as ${VAR}.
+	URL string `yaml:"url" json:"url"`
+	// Format is slack or json, the default.
+	Format string `yaml:"format,omitempty" json:"format,omitempty"`
+	// Events lists the events posted among start, success and failure;
+	// empty posts all of them.
+	Events []string `yaml:"events,omitempty" json:"events,omitempty"`
+}
+
+// Path returns the location of the user configuration file.
+func Path() (string, error) {
+	dir, err := paths.ConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("resolve config dir: %w", err)
+	}
+	return filepath.Join(dir, FileName), nil
+}
+
+// Load reads the configuration file at path, resolving its directories
+// against the directory of the file. A missing file yields an empty
+// configuration.
+func Load(path string) (*Config, error) {
+	c, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	c.resolvePaths(filepath.Dir(path))
+	return c, nil
+}
+
+// Edit applies fn to the configuration file at path and writes it back,
+// creating it when missing.
+func Edit(path string, fn func(*Config) error) error {
+	c, err := read(path)
+	if err != nil {
+		return err
+	}
+	if err := fn(c); err != nil {
+		return err
+	}
+	return c.Write(path)
+}
+
+func read(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// LoadChart reads the configuration file of the chart in dir.
+func LoadChart(dir string) (*Config, error) {
+	return Load(filepath.Join(dir, ChartFileName))
+}
+
+// Validate checks registry references, env patterns, output modes, lint
+// severities, the audit sink, release keys, scan settings and webhooks.
+func (c *Config) Validate() error {
+	for name, ref := range c.Registries {
+		if name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("registries: invalid name %q", name)
+		}
+		if !strings.HasPrefix(ref, oci.Scheme) {
+			return fmt.Errorf("registries.%s: %s must start with %s", name, ref, oci.Scheme)
+		}
+	}
+	for _, pattern := range c.Env.Allow {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("env.allow: invalid pattern %q: %w", pattern, err)
+		}
+	}
+	for key, mode := range map[string]string{"output.fileMode": c.Output.FileMode, "output.dirMode": c.Output.DirMode} {
+		if mode == "" {
+			continue
+		}
+		if _, err := ParseMode(mode); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	if c.Lint.FailOn != "" {
+		if _, err := lint.ParseSeverity(c.Lint.FailOn); err != nil {
+			return fmt.Errorf("lint.failOn: %w", err)
+		}
+	}
+	for rule, level := range c.Lint.Severity {
+		if _, err := lint.ParseSeverity(level); err != nil {
+			return fmt.Errorf("lint.severity.%s: %w", rule, err)
+		}
+	}
+	if err := audit.Validate(c.Audit.Sink); err != nil {
+		return fmt.Errorf("audit.sink: %w", err)
+	}
+	if err := sops.ValidateKeys(c.Releases.Encrypt); err != nil {
+		return fmt.Errorf("releases.encrypt: %w", err)
+	}
+	if s := c.Scan.Scanner; s != "" && s != scan.ScannerTrivy && s != scan.ScannerGrype {
+		return fmt.Errorf("scan.scanner: unknown scanner %q, expected %s or %s", s, scan.ScannerTrivy, scan.ScannerGrype)
+	}
+	for key, level := range map[string]string{"scan.failOn": c.Scan.FailOn, "scan.warnOn": c.Scan.WarnOn} {
+		if level == "" {
+			continue
+		}
+		if _, err := scan.ParseSeverity(level); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	for key, patterns := range map[string][]string{"functions.allow": c.Functions.Allow, "functions.deny": c.Functions.Deny} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("%s: invalid pattern %q: %w", key, pattern, err)
+			}
+		}
+	}
+	for i, w := range c.Notify.Webhooks {
+		if err := (notify.Webhook{URL: w.URL, Format: w.Format, Events: w.Events}).Validate(); err != nil {
+			return fmt.Errorf("notify.webhooks[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// resolvePaths makes relative directories relative to dir, the directory
+// of the file they were read from, and expands a leading ~.
+func (c *Config) resolvePaths(dir string) {
+	for _, p := range []*string{&c.Cache.Dir, &c.Output.Dir} {
+		*p = resolvePath(dir, *p)
+	}
+	if !strings.Contains(c.Audit.Sink, "://") {
+		c.Audit.Sink = resolvePath(dir, c.Audit.Sink)
+	}
+}
+
+func resolvePath(dir, p string) string {
+	if p == "" {
+		return ""
+	}
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+// Merge returns c with the settings of over applied on top. Maps are
+// merged by key; other settings of over replace those of c when set.
+// The audit sink, release keys, template functions and webhooks of over
+// are ignored.
+func (c *Config) Merge(over *Config) *Config {
+	out := c.clone()
+	if over == nil {
+		return out
+	}
+	for name, ref := range over.Registries {
+		if out.Registries == nil {
+			out.Registries = map[string]string{}
+		}
+		out.Registries[name] = ref
+	}
+	if len(over.Env.Allow) > 0 {
+		out.Env.Allow = slices.Clone(over.Env.Allow)
+	}
+	if over.Cache.Dir != "" {
+		out.Cache.Dir = over.Cache.Dir
+	}
+	if over.Output.Dir != "" {
+		out.Output.Dir = over.Output.Dir
+	}
+	if over.Output.FileMode != "" {
+		out.Output.FileMode = over.Output.FileMode
+	}
+	if over.Output.DirMode != "" {
+		out.Output.DirMode = over.Output.DirMode
+	}
+	if over.Output.Backup {
+		out.Output.Backup = true
+	}
+	if over.Lint.FailOn != "" {
+		out.Lint.FailOn = over.Lint.FailOn
+	}
+	for rule, level := range over.Lint.Severity {
+		if out.Lint.Severity == nil {
+			out.Lint.Severity = map[string]string{}
+		}
+		out.Lint.Severity[rule] = level
+	}
+	if over.Scan.Scanner != "" {
+		out.Scan.Scanner = over.Scan.Scanner
+	}
+	if over.Scan.Server != "" {
+		out.Scan.Server = over.Scan.Server
+	}
+	if over.Scan.FailOn != "" {
+		out.Scan.FailOn = over.Scan.FailOn
+	}
+	if over.Scan.WarnOn != "" {
+		out.Scan.WarnOn = over.Scan.WarnOn
+	}
+	if len(over.Scan.Ignore) > 0 {
+		out.Scan.Ignore = slices.Clone(over.Scan.Ignore)
+	}
+	return out
+}
+
+func (c *Config) clone() *Config {
+	out := *c
+	out.Registries = maps.Clone(c.Registries)
+	out.Env.Allow = slices.Clone(c.Env.Allow)
+	out.Lint.Severity = maps.Clone(c.Lint.Severity)
+	out.Releases.Encrypt = slices.Clone(c.Releases.Encrypt)
+	out.Scan.Ignore = slices.Clone(c.Scan.Ignore)
+	out.Functions.Allow = slices.Clone(c.Functions.Allow)
+	out.Functions.Deny = slices.Clone(c.Functions.Deny)
+	out.Notify.Webhooks = slices.Clone(c.Notify.Webhooks)
+	for i, w := range out.Notify.Webhooks {
+		out.Notify.Webhooks[i].Events = slices.Clone(w.Events)
+	}
+	return &out
+}
+
+// ExpandRegistry turns NAME/REPOSITORY[:TAG] into a full OCI reference
+// when NAME is a configured registry. Other references are returned as
+// they are.
+func (c *Config) ExpandRegistry(ref string) string {
+	if strings.HasPrefix(ref, oci.Scheme) {
+		return ref
+	}
+	name, rest, _ := strings.Cut(ref, "/")
+	prefix, ok := c.Registries[name]
+	if !ok {
+		return ref
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	if rest == "" {
+		return prefix
+	}
+	return prefix + "/" + rest
+}
+
+// Keys lists the keys accepted by Set; NAME and RULE stand for any
+// registry name and lint rule.
+var Keys = []string{
+	"registries.NAME",
+	"env.allow",
+	"cache.dir",
+	"output.dir",
+	"output.fileMode",
+	"output.dirMode",
+	"output.backup",
+	"lint.failOn",
+	"lint.severity.RULE",
+	"audit.sink",
+	"releases.encrypt",
+	"scan.scanner",
+	"scan.server",
+	"scan.failOn",
+	"scan.warnOn",
+	"scan.ignore",
+	"functions.allow",
+	"functions.deny",
+}
+
+// Set changes the setting named by a dotted key, e.g. "cache.dir" or
+// "registries.acme". An empty value removes the setting. env.allow,
+// releases.encrypt, scan.ignore and functions.allow and .deny take a
+// comma-separated list.
+func (c *Config) Set(key, value string) error {
+	switch {
+	case strings.HasPrefix(key, "registries."):
+		name := strings.TrimPrefix(key, "registries.")
+		if value == "" {
+			delete(c.Registries, name)
+			break
+		}
+		if c.Registries == nil {
+			c.Registries = map[string]string{}
+		}
+		c.Registries[name] = value
+	case key == "env.allow":
+		c.Env.Allow = splitList(value)
+	case key == "cache.dir":
+		c.Cache.Dir = value
+	case key == "output.dir":
+		c.Output.Dir = value
+	case key == "output.fileMode":
+		c.Output.FileMode = value
+	case key == "output.dirMode":
+		c.Output.DirMode = value
+	case key == "output.backup":
+		backup := false
+		if value != "" {
+			var err error
+			if backup, err = strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("output.backup: invalid value %q, expected true or false", value)
+			}
+		}
+		c.Output.Backup = backup
+	case key == "lint.failOn":
+		c.Lint.FailOn = value
+	case strings.HasPrefix(key, "lint.severity."):
+		rule := strings.TrimPrefix(key, "lint.severity.")
+		if value == "" {
+			delete(c.Lint.Severity, rule)
+			break
+		}
+		if c.Lint.Severity == nil {
+			c.Lint.Severity = map[string]string{}
+		}
+		c.Lint.Severity[rule] = value
+	case key == "audit.sink":
+		c.Audit.Sink = value
+	case key == "releases.encrypt":
+		c.Releases.Encrypt = splitList(value)
+	case key == "scan.scanner":
+		c.Scan.Scanner = value
+	case key == "scan.server":
+		c.Scan.Server = value
+	case key == "scan.failOn":
+		c.Scan.FailOn = value
+	case key == "scan.warnOn":
+		c.Scan.WarnOn = value
+	case key == "scan.ignore":
+		c.Scan.Ignore = splitList(value)
+	case key == "functions.allow":
+		c.Functions.Allow = splitList(value)
+	case key == "functions.deny":
+		c.Functions.Deny = splitList(value)
+	default:
+		return fmt.Errorf("unknown config key %q, expected one of %s", key, strings.Join(Keys, ", "))
+	}
+	return c.Validate()
+}
+
+// ParseMode parses octal file permissions such as 0600 or 750.
+func ParseMode(mode string) (fs.FileMode, error) {
+	n, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || n > 0o777 {
+		return 0, fmt.Errorf("invalid mode %q, expected octal permissions such as 0600", mode)
+	}
+	return fs.FileMode(n), nil
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Write stores the configuration at path.
+func (c *Config) Write(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("ensure config dir: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	return nil
+}
+
+// WithContext attaches the user configuration to ctx.
+func WithContext(ctx context.Context, c *Config) context.Context {
+	return context.WithValue(ctx, ctxKey{}, c)
+}
+
+// FromContext returns the configuration attached to ctx, or an empty one.
+func FromContext(ctx context.Context) *Config {
+	if ctx != nil {
+		if c, ok := ctx.Value(ctxKey{}).(*Config); ok && c != nil {
+			return c
+		}
+	}
+	return &Config{}
+}
+
+type ctxKey struct{}
@@ -0,0 +1,331 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/acebelowzero/tmpl/internal/diff"
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/logx"
+	"github.com/acebelowzero/tmpl/internal/stack"
+	"github.com/acebelowzero/tmpl/internal/timing"
+)
+
+// Kind identifies the type of a swarm object.
+type Kind string
+
+const (
+	KindNetwork Kind = "network"
+	KindConfig  Kind = "config"
+	KindSecret  Kind = "secret"
+	KindService Kind = "service"
+)
+
+// Action is the operation planned or performed on an object.
+type Action string
+
+const (
+	ActionCreate    Action = "create"
+	ActionUpdate    Action = "update"
+	ActionDelete    Action = "delete"
+	ActionUnchanged Action = "unchanged"
+)
+
+// Change is a planned or performed operation on a single object.
+type Change struct {
+	Kind   Kind   `json:"kind"`
+	Name   string `json:"name"`
+	Action Action `json:"action"`
+	// ID and ObjectVersion identify the live object an update or delete
+	// acts on, as observed when planning.
+	ID            string `json:"id,omitempty"`
+	ObjectVersion uint64 `json:"objectVersion,omitempty"`
+	// Digest is the content digest of a desired config or secret.
+	Digest string `json:"digest,omitempty"`
+	// ReplacedBy names the new version of a rotated config or secret on
+	// the delete of an old one. Apply removes replaced objects once the
+	// services referring to them have been updated.
+	ReplacedBy string `json:"replacedBy,omitempty"`
+	// Prune is set on deletes of objects removed from the chart that apply
+	// removes because pruning was requested and tmpl created them.
+	Prune bool `json:"prune,omitempty"`
+	// Volumes lists the named volumes mounted by a deleted service.
+	Volumes  []string      `json:"volumes,omitempty"`
+	Diff     []diff.Change `json:"diff,omitempty"`
+	Warnings []string      `json:"warnings,omitempty"`
+}
+
+// Result lists the changes made by Apply in the order they were made.
+type Result struct {
+	Changes []Change `json:"changes"`
+}
+
+// Count returns the number of changes with the given action.
+func (r *Result) Count(action Action) int {
+	return count(r.Changes, action)
+}
+
+func count(changes []Change, action Action) int {
+	n := 0
+	for _, c := range changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Deployer converges a swarm onto a desired stack through the Engine API.
+type Deployer struct {
+	client    *docker.Client
+	observers []func(Step)
+}
+
+// Options tunes Plan and Apply.
+type Options struct {
+	// Prune removes live objects that are no longer in the desired stack,
+	// provided they are owned by the release.
+	Prune bool
+	// Services restricts the plan to the selected services; the others
+	// keep their live spec. The zero value selects every service.
+	Services Selector
+	// CheckImages has Plan verify that the images of the services it
+	// creates or updates exist in their registries and are available for
+	// the platforms of the nodes that may run them.
+	CheckImages bool
+}
+
+// New constructs a Deployer using client.
+func New(client *docker.Client) *Deployer {
+	return &Deployer{client: client}
+}
+
+// Apply plans and executes the changes that converge the swarm onto
+// desired. Objects present in the swarm but absent from desired are left
+// untouched unless opts.Prune is set. On error the result holds the
+// changes made so far.
+func (d *Deployer) Apply(ctx context.Context, desired *stack.Stack, opts Options) (*Result, error) {
+	p, err := d.Plan(ctx, desired, opts)
+	if err != nil {
+		return &Result{}, err
+	}
+	return d.execute(ctx, p, desired)
+}
+
+// execute performs the changes of p using the specs of desired, which must
+// include secret payloads.
+func (d *Deployer) execute(ctx context.Context, p *Plan, desired *stack.Stack) (*Result, error) {
+	defer timing.Track(ctx, timing.Apply)()
+	log := logx.FromContext(ctx)
+	result := &Result{}
+
+	configIDs, err := d.configIDs(ctx)
+	if err != nil {
+		return result, err
+	}
+	secretIDs, err := d.secretIDs(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	var removals []Change
+	for _, c := range p.Changes {
+		switch c.Action {
+		case ActionUnchanged:
+			result.Changes = append(result.Changes, c)
+			continue
+		case ActionDelete:
+			if c.ReplacedBy != "" || c.Prune {
+				removals = append(removals, c)
+				continue
+			}
+			log.Warn("object is no longer in the chart and is left in place", "kind", c.Kind, "name", c.Name)
+			d.step(Step{Change: c, Skipped: true})
+			continue
+		}
+
+		done := Change{Kind: c.Kind, Name: c.Name, Action: c.Action, ID: c.ID, Digest: c.Digest, Diff: c.Diff}
+		d.step(Step{Change: c, Running: true})
+		began := time.Now()
+		var err error
+		switch c.Kind {
+		case KindNetwork:
+			done.ID, err = d.createNetwork(ctx, desired.Networks[c.Name])
+		case KindConfig:
+			done.ID, err = d.applyConfig(ctx, c, desired.Configs[c.Name])
+			configIDs[c.Name] = done.ID
+		case KindSecret:
+			done.ID, err = d.applySecret(ctx, c, desired.Secrets[c.Name])
+			secretIDs[c.Name] = done.ID
+		case KindService:
+			done.ID, done.Warnings, err = d.applyService(ctx, c, desired.Services[c.Name], configIDs, secretIDs)
+		default:
+			err = fmt.Errorf("unknown object kind %q", c.Kind)
+		}
+		d.step(Step{Change: done, Duration: time.Since(began), Err: err})
+		if err != nil {
+			return result, fmt.Errorf("%s %s %s: %w", c.Action, c.Kind, c.Name, err)
+		}
+		log.Debug("applied change", "kind", c.Kind, "name", c.Name, "action", c.Action, "id", done.ID)
+		result.Changes = append(result.Changes, done)
+	}
+
+	// Objects are removed last, after services were repointed, and
+	// services before the configs, secrets and networks they use. The
+	// engine refuses to remove objects still in use, e.g. by services
+	// outside the stack, and those are left for a later apply.
+	sort.SliceStable(removals, func(i, j int) bool {
+		return removalOrder[removals[i].Kind] < removalOrder[removals[j].Kind]
+	})
+	for _, c := range removals {
+		d.step(Step{Change: c, Running: true})
+		began := time.Now()
+		err := d.remove(ctx, c)
+		d.step(Step{Change: c, Duration: time.Since(began), Err: err})
+		if err != nil {
+			log.Warn("could not remove object", "kind", c.Kind, "name", c.Name, "error", err)
+			continue
+		}
+		log.Debug("removed object", "kind", c.Kind, "name", c.Name, "replacement", c.ReplacedBy)
+		result.Changes = append(result.Changes, Change{Kind: c.Kind, Name: c.Name, Action: ActionDelete, ID: c.ID, ReplacedBy: c.ReplacedBy, Prune: c.Prune})
+	}
+	return result, nil
+}
+
+// removalOrder sorts removals so that objects go before what they use.
+var removalOrder = map[Kind]int{KindService: 0, KindConfig: 1, KindSecret: 1, KindNetwork: 2}
+
+func (d *Deployer) remove(ctx context.Context, c Change) error {
+	switch c.Kind {
+	case KindService:
+		return d.client.RemoveService(ctx, c.ID)
+	case KindNetwork:
+		return d.client.RemoveNetwork(ctx, c.ID)
+	case KindConfig:
+		return d.client.RemoveConfig(ctx, c.ID)
+	case KindSecret:
+		return d.client.RemoveSecret(ctx, c.ID)
+	}
+	return fmt.Errorf("unknown object kind %q", c.Kind)
+}
+
+func (d *Deployer) createNetwork(ctx context.Context, spec stack.NetworkSpec) (string, error) {
+	return d.client.CreateNetwork(ctx, docker.NetworkCreate{
+		Name:           spec.Name,
+		CheckDuplicate: true,
+		Driver:         spec.Driver,
+		Scope:          "swarm",
+		Attachable:     spec.Attachable,
+		Internal:       spec.Internal,
+		Labels:         spec.Labels,
+		Options:        spec.Options,
+	})
+}
+
+func (d *Deployer) applyConfig(ctx context.Context, c Change, spec docker.ObjectSpec) (string, error) {
+	if c.Action == ActionCreate {
+		return d.client.CreateConfig(ctx, spec)
+	}
+	for _, change := range c.Diff {
+		if change.Path == "Data" {
+			return "", fmt.Errorf("content changed but swarm configs are immutable; drop the explicit name to let tmpl rotate it")
+		}
+	}
+	err := d.client.UpdateConfig(ctx, c.ID, docker.Version{Index: c.ObjectVersion}, spec)
+	return c.ID, err
+}
+
+func (d *Deployer) applySecret(ctx context.Context, c Change, spec docker.ObjectSpec) (string, error) {
+	if c.Action == ActionCreate {
+		return d.client.CreateSecret(ctx, spec)
+	}
+	// Secret data cannot be changed; updates only carry labels.
+	err := d.client.UpdateSecret(ctx, c.ID, docker.Version{Index: c.ObjectVersion}, docker.ObjectSpec{Name: spec.Name, Labels: spec.Labels})
+	return c.ID, err
+}
+
+func (d *Deployer) applyService(ctx context.Context, c Change, spec docker.ServiceSpec, configIDs, secretIDs map[string]string) (string, []string, error) {
+	spec, err := resolveReferences(spec, configIDs, secretIDs)
+	if err != nil {
+		return "", nil, err
+	}
+	if c.Action == ActionCreate {
+		resp, err := d.client.CreateService(ctx, spec)
+		if err != nil {
+			return "", nil, err
+		}
+		return resp.ID, resp.Warnings, nil
+	}
+	warnings, err := d.client.UpdateService(ctx, c.ID, docker.Version{Index: c.ObjectVersion}, spec)
+	return c.ID, warnings, err
+}
+
+// configIDs returns the IDs of every config in the swarm by name, so that
+// external configs resolve as well.
+func (d *Deployer) configIDs(ctx context.Context) (map[string]string, error) {
+	all, err := d.client.ListConfigs(ctx, docker.Filters{})
+	if err != nil {
+		return nil, fmt.Errorf("list configs: %w", err)
+	}
+	ids := make(map[string]string, len(all))
+	for _, cfg := range all {
+		ids[cfg.Spec.Name] = cfg.ID
+	}
+	return ids, nil
+}
+
+// secretIDs returns the IDs of every secret in the swarm by name.
+func (d *Deployer) secretIDs(ctx context.Context) (map[string]string, error) {
+	all, err := d.client.ListSecrets(ctx, docker.Filters{})
+	if err != nil {
+		return nil, fmt.Errorf("list secrets: %w", err)
+	}
+	ids := make(map[string]string, len(all))
+	for _, sec := range all {
+		ids[sec.Spec.Name] = sec.ID
+	}
+	return ids, nil
+}
+
+// resolveReferences returns a copy of spec with config and secret IDs
+// filled in, as required by the Engine API.
+func resolveReferences(spec docker.ServiceSpec, configIDs, secretIDs map[string]string) (docker.ServiceSpec, error) {
+	cs := spec.TaskTemplate.ContainerSpec
+	if cs == nil {
+		return spec, nil
+	}
+	copied := *cs
+	copied.Configs = make([]*docker.ConfigReference, len(cs.Configs))
+	for i, ref := range cs.Configs {
+		r := *ref
+		id, ok := configIDs[r.ConfigName]
+		if !ok {
+			return spec, fmt.Errorf("config %s not found", r.ConfigName)
+		}
+		r.ConfigID = id
+		copied.Configs[i] = &r
+	}
+	copied.Secrets = make([]*docker.SecretReference, len(cs.Secrets))
+	for i, ref := range cs.Secrets {
+		r := *ref
+		id, ok := secretIDs[r.SecretName]
+		if !ok {
+			return spec, fmt.Errorf("secret %s not found", r.SecretName)
+		}
+		r.SecretID = id
+		copied.Secrets[i] = &r
+	}
+	spec.TaskTemplate.ContainerSpec = &copied
+	return spec, nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,112 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/acebelowzero/tmpl/internal/diff"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// DriftState describes how a live object departs from the recorded stack.
+type DriftState string
+
+const (
+	// DriftModified objects exist but their spec was changed out of band.
+	DriftModified DriftState = "modified"
+	// DriftMissing objects were recorded but no longer exist.
+	DriftMissing DriftState = "missing"
+	// DriftUnmanaged objects carry the stack label but were never recorded.
+	DriftUnmanaged DriftState = "unmanaged"
+)
+
+// Drift is an out-of-band change to a single object. Diff reads from the
+// recorded spec to the live one.
+type Drift struct {
+	Kind  Kind          `json:"kind"`
+	Name  string        `json:"name"`
+	State DriftState    `json:"state"`
+	ID    string        `json:"id,omitempty"`
+	Diff  []diff.Change `json:"diff,omitempty"`
+}
+
+// Drift compares a recorded stack with the live swarm and returns every
+// object that was changed, removed or added since it was applied.
+func (d *Deployer) Drift(ctx context.Context, recorded *stack.Stack) ([]Drift, error) {
+	if err := d.checkManager(ctx); err != nil {
+		return nil, err
+	}
+	live, err := stack.Fetch(ctx, d.client, recorded.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []Drift
+	for _, name := range sortedKeys(recorded.Networks) {
+		existing, ok := live.NetworkObjects[name]
+		if !ok {
+			drifts = append(drifts, Drift{Kind: KindNetwork, Name: name, State: DriftMissing})
+			continue
+		}
+		if changes := compareTrees(networkShape(recorded.Networks[name]), networkShape(live.Networks[name])); len(changes) > 0 {
+			drifts = append(drifts, Drift{Kind: KindNetwork, Name: name, State: DriftModified, ID: existing.ID, Diff: changes})
+		}
+	}
+	for _, name := range sortedKeys(live.NetworkObjects) {
+		if _, ok := recorded.Networks[name]; !ok {
+			drifts = append(drifts, Drift{Kind: KindNetwork, Name: name, State: DriftUnmanaged, ID: live.NetworkObjects[name].ID})
+		}
+	}
+
+	for _, name := range sortedKeys(recorded.Configs) {
+		existing, ok := live.ConfigObjects[name]
+		if !ok {
+			drifts = append(drifts, Drift{Kind: KindConfig, Name: name, State: DriftMissing})
+			continue
+		}
+		if want := recorded.Configs[name].Data; !bytes.Equal(existing.Spec.Data, want) {
+			drifts = append(drifts, Drift{Kind: KindConfig, Name: name, State: DriftModified, ID: existing.ID,
+				Diff: []diff.Change{{Path: "Data", Kind: diff.Changed, Old: digest(want), New: digest(existing.Spec.Data)}}})
+		}
+	}
+	for _, name := range sortedKeys(live.ConfigObjects) {
+		if _, ok := recorded.Configs[name]; !ok {
+			drifts = append(drifts, Drift{Kind: KindConfig, Name: name, State: DriftUnmanaged, ID: live.ConfigObjects[name].ID})
+		}
+	}
+
+	// Secret content is never returned by the engine, so secrets can only
+	// drift by disappearing or appearing.
+	for _, name := range sortedKeys(recorded.Secrets) {
+		if _, ok := live.SecretObjects[name]; !ok {
+			drifts = append(drifts, Drift{Kind: KindSecret, Name: name, State: DriftMissing})
+		}
+	}
+	for _, name := range sortedKeys(live.SecretObjects) {
+		if _, ok := recorded.Secrets[name]; !ok {
+			drifts = append(drifts, Drift{Kind: KindSecret, Name: name, State: DriftUnmanaged, ID: live.SecretObjects[name].ID})
+		}
+	}
+
+	for _, name := range recorded.ServiceNames() {
+		existing, ok := live.ServiceObjects[name]
+		if !ok {
+			drifts = append(drifts, Drift{Kind: KindService, Name: name, State: DriftMissing})
+			continue
+		}
+		changes, err := compareServices(recorded.Services[name], existing.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		if len(changes) > 0 {
+			drifts = append(drifts, Drift{Kind: KindService, Name: name, State: DriftModified, ID: existing.ID, Diff: changes})
+		}
+	}
+	for _, name := range sortedKeys(live.ServiceObjects) {
+		if _, ok := recorded.Services[name]; !ok {
+			drifts = append(drifts, Drift{Kind: KindService, Name: name, State: DriftUnmanaged, ID: live.ServiceObjects[name].ID})
+		}
+	}
+	return drifts, nil
+}
@@ -0,0 +1,182 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/oci"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// ImageError reports services whose image does not exist or is not
+// available for the platforms of the nodes that can run them.
+type ImageError struct {
+	Services []string
+}
+
+func (e *ImageError) Error() string {
+	return "images unavailable:\n  " + strings.Join(e.Services, "\n  ")
+}
+
+// nodeArchitectures maps the machine names engines report for nodes to
+// OCI architectures and variants.
+var nodeArchitectures = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"armv7l":  "arm/v7",
+	"armv6l":  "arm/v6",
+	"i386":    "386",
+	"i686":    "386",
+}
+
+// nodePlatform returns the platform of a node as OS/ARCH[/VARIANT].
+func nodePlatform(n docker.Node) string {
+	arch := n.Description.Platform.Architecture
+	if a, ok := nodeArchitectures[arch]; ok {
+		arch = a
+	}
+	return strings.ToLower(n.Description.Platform.OS) + "/" + arch
+}
+
+// platformMatches reports whether an image built for image runs on a node
+// of platform node. A variant is only compared when both have one, and
+// arm64 images are v8 whether or not they say so.
+func platformMatches(image, node string) bool {
+	ios, iarch, ivariant := splitPlatform(image)
+	nos, narch, nvariant := splitPlatform(node)
+	if ios != nos || iarch != narch {
+		return false
+	}
+	return ivariant == "" || nvariant == "" || ivariant == nvariant
+}
+
+func splitPlatform(p string) (os, arch, variant string) {
+	parts := strings.SplitN(p, "/", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	if parts[1] == "arm64" && parts[2] == "v8" {
+		parts[2] = ""
+	}
+	return parts[0], parts[1], parts[2]
+}
+
+// checkImages verifies that the image of every service the plan creates
+// or updates exists in its registry and is available for the platform of
+// each node its placement constraints allow. Images that cannot be
+// inspected, for instance without registry credentials, only add a
+// warning to the change of their service.
+func (d *Deployer) checkImages(ctx context.Context, p *Plan, desired *stack.Stack) error {
+	var changed []int
+	for i, c := range p.Changes {
+		if c.Kind == KindService && (c.Action == ActionCreate || c.Action == ActionUpdate) {
+			changed = append(changed, i)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	all, err := d.client.ListNodes(ctx, docker.Filters{})
+	if err != nil {
+		return fmt.Errorf("list nodes: %w", err)
+	}
+	var nodes []docker.Node
+	for _, n := range all {
+		if n.Spec.Availability == "active" && n.Status.State == "ready" {
+			nodes = append(nodes, n)
+		}
+	}
+
+	// Services often share images, so every image is inspected once.
+	type inspected struct {
+		platforms []string
+		err       error
+	}
+	images := map[string]inspected{}
+	var failed []string
+	for _, i := range changed {
+		name := p.Changes[i].Name
+		spec, ok := desired.Services[name]
+		if !ok || spec.TaskTemplate.ContainerSpec == nil || spec.TaskTemplate.ContainerSpec.Image == "" {
+			continue
+		}
+		image := spec.TaskTemplate.ContainerSpec.Image
+		result, ok := images[image]
+		if !ok {
+			result.platforms, result.err = oci.ImagePlatforms(ctx, image)
+			images[image] = result
+		}
+		switch {
+		case oci.IsNotFound(result.err):
+			failed = append(failed, fmt.Sprintf("%s: image %s not found", name, image))
+			continue
+		case result.err != nil:
+			p.Changes[i].Warnings = append(p.Changes[i].Warnings, fmt.Sprintf("image not checked: %v", result.err))
+			continue
+		case len(result.platforms) == 0:
+			continue
+		}
+
+		eligible, err := eligibleNodes(nodes, spec)
+		if err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+		unsupported := map[string][]string{}
+		for _, n := range eligible {
+			platform := nodePlatform(n)
+			if !supports(result.platforms, platform) {
+				unsupported[platform] = append(unsupported[platform], n.Description.Hostname)
+			}
+		}
+		if len(unsupported) == 0 {
+			continue
+		}
+		var missing []string
+		for platform, hosts := range unsupported {
+			missing = append(missing, fmt.Sprintf("%s (%s)", platform, strings.Join(hosts, ", ")))
+		}
+		sort.Strings(missing)
+		failed = append(failed, fmt.Sprintf("%s: image %s is available for %s, not for node platform %s",
+			name, image, strings.Join(result.platforms, ", "), strings.Join(missing, ", ")))
+	}
+	if len(failed) > 0 {
+		return &ImageError{Services: failed}
+	}
+	return nil
+}
+
+// eligibleNodes returns the nodes satisfying the placement constraints of
+// a service.
+func eligibleNodes(nodes []docker.Node, spec docker.ServiceSpec) ([]docker.Node, error) {
+	var constraints []stack.Constraint
+	if p := spec.TaskTemplate.Placement; p != nil {
+		for _, raw := range p.Constraints {
+			c, err := stack.ParseConstraint(raw)
+			if err != nil {
+				return nil, err
+			}
+			constraints = append(constraints, c)
+		}
+	}
+	var eligible []docker.Node
+	for _, n := range nodes {
+		if matchAny([]docker.Node{n}, constraints...) {
+			eligible = append(eligible, n)
+		}
+	}
+	return eligible, nil
+}
+
+func supports(platforms []string, node string) bool {
+	for _, p := range platforms {
+		if platformMatches(p, node) {
+			return true
+		}
+	}
+	return false
+}
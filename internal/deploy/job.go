@@ -0,0 +1,132 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/logx"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// Terminal task states of a one-shot service.
+const (
+	taskComplete = "complete"
+	taskFailed   = "failed"
+	taskRejected = "rejected"
+	taskShutdown = "shutdown"
+)
+
+// JobError reports a one-shot service whose task did not complete.
+type JobError struct {
+	Service string
+	State   string
+	Message string
+}
+
+func (e *JobError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("job %s ended in state %s", e.Service, e.State)
+	}
+	return fmt.Sprintf("job %s ended in state %s: %s", e.Service, e.State, e.Message)
+}
+
+// RunJob runs spec as a one-shot service with a single task that is never
+// restarted, waits for the task to finish and removes the service. Config
+// and secret references may use the unversioned name of a rotated object,
+// which resolves to its newest version.
+func (d *Deployer) RunJob(ctx context.Context, spec docker.ServiceSpec) error {
+	log := logx.FromContext(ctx)
+	configIDs, err := d.configIDs(ctx)
+	if err != nil {
+		return err
+	}
+	secretIDs, err := d.secretIDs(ctx)
+	if err != nil {
+		return err
+	}
+	if err := d.addLatestVersions(ctx, configIDs, secretIDs); err != nil {
+		return err
+	}
+
+	one := uint64(1)
+	spec.Mode = docker.ServiceMode{Replicated: &docker.ReplicatedService{Replicas: &one}}
+	spec.TaskTemplate.RestartPolicy = &docker.RestartPolicy{Condition: "none"}
+	spec.UpdateConfig, spec.RollbackConfig = nil, nil
+	if spec, err = resolveReferences(spec, configIDs, secretIDs); err != nil {
+		return err
+	}
+
+	resp, err := d.client.CreateService(ctx, spec)
+	if err != nil {
+		return fmt.Errorf("create job %s: %w", spec.Name, err)
+	}
+	defer func() {
+		// Remove the service even when ctx has ended.
+		if err := d.client.RemoveService(context.WithoutCancel(ctx), resp.ID); err != nil {
+			log.Warn("could not remove job service", "service", spec.Name, "error", err)
+		}
+	}()
+
+	ticker := time.NewTicker(DefaultWaitInterval)
+	defer ticker.Stop()
+	for {
+		tasks, err := d.client.ListTasks(ctx, docker.Filters{}.Service(resp.ID))
+		if err != nil && ctx.Err() == nil {
+			return fmt.Errorf("list tasks of %s: %w", spec.Name, err)
+		}
+		for _, t := range tasks {
+			switch t.Status.State {
+			case taskComplete:
+				return nil
+			case taskFailed, taskRejected, taskShutdown:
+				msg := t.Status.Err
+				if msg == "" {
+					msg = t.Status.Message
+				}
+				return &JobError{Service: spec.Name, State: t.Status.State, Message: msg}
+			}
+		}
+		log.Debug("waiting for job to finish", "service", spec.Name)
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("job %s: timed out", spec.Name)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// addLatestVersions maps the unversioned names of rotated configs and
+// secrets to the ID of their newest version.
+func (d *Deployer) addLatestVersions(ctx context.Context, configIDs, secretIDs map[string]string) error {
+	configs, err := d.client.ListConfigs(ctx, docker.Filters{}.Label(stack.LabelObject))
+	if err != nil {
+		return fmt.Errorf("list configs: %w", err)
+	}
+	newest := map[string]time.Time{}
+	for _, cfg := range configs {
+		base := cfg.Spec.Labels[stack.LabelObject]
+		if cfg.CreatedAt.After(newest[base]) {
+			configIDs[base], newest[base] = cfg.ID, cfg.CreatedAt
+		}
+	}
+
+	secrets, err := d.client.ListSecrets(ctx, docker.Filters{}.Label(stack.LabelObject))
+	if err != nil {
+		return fmt.Errorf("list secrets: %w", err)
+	}
+	newest = map[string]time.Time{}
+	for _, sec := range secrets {
+		base := sec.Spec.Labels[stack.LabelObject]
+		if sec.CreatedAt.After(newest[base]) {
+			secretIDs[base], newest[base] = sec.ID, sec.CreatedAt
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// OwnershipError reports objects a release may not modify.
+type OwnershipError struct {
+	Release   string
+	Conflicts []string
+}
+
+func (e *OwnershipError) Error() string {
+	return fmt.Sprintf("release %s does not own:\n  %s", e.Release, strings.Join(e.Conflicts, "\n  "))
+}
+
+// checkOwnership refuses plans that would modify objects owned by another
+// release, or stack objects deployed without tmpl that were not adopted.
+// Networks are never modified in place, so only their owner is checked.
+func (d *Deployer) checkOwnership(ctx context.Context, p *Plan, live *stack.Live) error {
+	var conflicts []string
+	var creates bool
+	for _, c := range p.Changes {
+		var labels map[string]string
+		switch c.Action {
+		case ActionCreate:
+			creates = true
+			continue
+		case ActionUpdate, ActionUnchanged:
+		default:
+			continue
+		}
+		switch c.Kind {
+		case KindService:
+			labels = live.Services[c.Name].Labels
+		case KindNetwork:
+			labels = live.Networks[c.Name].Labels
+		case KindConfig:
+			labels = live.Configs[c.Name].Labels
+		case KindSecret:
+			labels = live.Secrets[c.Name].Labels
+		}
+		switch owner := stack.Owner(labels); {
+		case owner == p.Stack:
+		case owner != "":
+			conflicts = append(conflicts, fmt.Sprintf("%s %s is owned by release %s", c.Kind, c.Name, owner))
+		case c.Kind != KindNetwork && c.Action == ActionUpdate:
+			conflicts = append(conflicts, fmt.Sprintf("%s %s was not deployed by tmpl; run 'tmpl adopt %s' to take ownership", c.Kind, c.Name, p.Stack))
+		}
+	}
+
+	// Objects with explicit names may exist outside the stack namespace.
+	if creates {
+		existing, err := d.ownersByName(ctx)
+		if err != nil {
+			return err
+		}
+		for _, c := range p.Changes {
+			if c.Action != ActionCreate {
+				continue
+			}
+			owner, ok := existing[c.Kind][c.Name]
+			if ok && owner != "" && owner != p.Stack {
+				conflicts = append(conflicts, fmt.Sprintf("%s %s is owned by release %s", c.Kind, c.Name, owner))
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		return &OwnershipError{Release: p.Stack, Conflicts: conflicts}
+	}
+	return nil
+}
+
+// ownersByName returns the owning release of every object in the swarm by
+// kind and name, empty for objects without one.
+func (d *Deployer) ownersByName(ctx context.Context) (map[Kind]map[string]string, error) {
+	out := map[Kind]map[string]string{
+		KindService: {}, KindNetwork: {}, KindConfig: {}, KindSecret: {},
+	}
+	services, err := d.client.ListServices(ctx, docker.Filters{})
+	if err != nil {
+		return nil, fmt.Errorf("list services: %w", err)
+	}
+	for _, svc := range services {
+		out[KindService][svc.Spec.Name] = stack.Owner(svc.Spec.Labels)
+	}
+	networks, err := d.client.ListNetworks(ctx, docker.Filters{})
+	if err != nil {
+		return nil, fmt.Errorf("list networks: %w", err)
+	}
+	for _, net := range networks {
+		out[KindNetwork][net.Name] = stack.Owner(net.Labels)
+	}
+	configs, err := d.client.ListConfigs(ctx, docker.Filters{})
+	if err != nil {
+		return nil, fmt.Errorf("list configs: %w", err)
+	}
+	for _, cfg := range configs {
+		out[KindConfig][cfg.Spec.Name] = stack.Owner(cfg.Spec.Labels)
+	}
+	secrets, err := d.client.ListSecrets(ctx, docker.Filters{})
+	if err != nil {
+		return nil, fmt.Errorf("list secrets: %w", err)
+	}
+	for _, sec := range secrets {
+		out[KindSecret][sec.Spec.Name] = stack.Owner(sec.Spec.Labels)
+	}
+	return out, nil
+}
+
+// Adopt labels the services, configs and secrets of a stack deployed
+// without tmpl as owned by the release named after the stack. Networks
+// cannot be relabelled and are adopted implicitly. Objects owned by
+// another release are refused; with dryRun nothing is changed.
+func (d *Deployer) Adopt(ctx context.Context, name string, dryRun bool) ([]Change, error) {
+	if err := d.checkManager(ctx); err != nil {
+		return nil, err
+	}
+	live, err := stack.Fetch(ctx, d.client, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []string
+	var changes []Change
+	consider := func(kind Kind, objName, id string, version uint64, labels map[string]string) {
+		switch owner := labels[stack.LabelOwner]; {
+		case owner == name:
+			changes = append(changes, Change{Kind: kind, Name: objName, Action: ActionUnchanged, ID: id})
+		case owner != "":
+			conflicts = append(conflicts, fmt.Sprintf("%s %s is owned by release %s", kind, objName, owner))
+		default:
+			changes = append(changes, Change{Kind: kind, Name: objName, Action: ActionUpdate, ID: id, ObjectVersion: version})
+		}
+	}
+	for _, n := range sortedKeys(live.ConfigObjects) {
+		obj := live.ConfigObjects[n]
+		consider(KindConfig, n, obj.ID, obj.Version.Index, obj.Spec.Labels)
+	}
+	for _, n := range sortedKeys(live.SecretObjects) {
+		obj := live.SecretObjects[n]
+		consider(KindSecret, n, obj.ID, obj.Version.Index, obj.Spec.Labels)
+	}
+	for _, n := range sortedKeys(live.ServiceObjects) {
+		obj := live.ServiceObjects[n]
+		consider(KindService, n, obj.ID, obj.Version.Index, obj.Spec.Labels)
+	}
+	if len(conflicts) > 0 {
+		return nil, &OwnershipError{Release: name, Conflicts: conflicts}
+	}
+	if dryRun {
+		return changes, nil
+	}
+
+	for _, c := range changes {
+		if c.Action != ActionUpdate {
+			continue
+		}
+		version := docker.Version{Index: c.ObjectVersion}
+		switch c.Kind {
+		case KindConfig:
+			spec := live.ConfigObjects[c.Name].Spec
+			spec.Labels = stack.Adopt(spec.Labels, name)
+			err = d.client.UpdateConfig(ctx, c.ID, version, spec)
+		case KindSecret:
+			spec := live.SecretObjects[c.Name].Spec
+			err = d.client.UpdateSecret(ctx, c.ID, version, docker.ObjectSpec{Name: spec.Name, Labels: stack.Adopt(spec.Labels, name)})
+		case KindService:
+			spec := live.ServiceObjects[c.Name].Spec
+			spec.Labels = stack.Adopt(spec.Labels, name)
+			_, err = d.client.UpdateService(ctx, c.ID, version, spec)
+		}
+		if err != nil {
+			return changes, fmt.Errorf("adopt %s %s: %w", c.Kind, c.Name, err)
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,93 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// PlacementError reports services whose placement constraints no
+// schedulable node of the swarm satisfies.
+type PlacementError struct {
+	Services []string
+}
+
+func (e *PlacementError) Error() string {
+	return "no node can run:\n  " + strings.Join(e.Services, "\n  ")
+}
+
+// checkPlacement verifies that every service with placement constraints
+// has at least one active, ready node satisfying all of them, so that a
+// missing node label fails the plan instead of leaving tasks pending.
+func (d *Deployer) checkPlacement(ctx context.Context, desired *stack.Stack) error {
+	var constrained []string
+	for _, name := range sortedKeys(desired.Services) {
+		if p := desired.Services[name].TaskTemplate.Placement; p != nil && len(p.Constraints) > 0 {
+			constrained = append(constrained, name)
+		}
+	}
+	if len(constrained) == 0 {
+		return nil
+	}
+	all, err := d.client.ListNodes(ctx, docker.Filters{})
+	if err != nil {
+		return fmt.Errorf("list nodes: %w", err)
+	}
+	var nodes []docker.Node
+	for _, n := range all {
+		if n.Spec.Availability == "active" && n.Status.State == "ready" {
+			nodes = append(nodes, n)
+		}
+	}
+
+	var failed []string
+	for _, name := range constrained {
+		var constraints []stack.Constraint
+		for _, raw := range desired.Services[name].TaskTemplate.Placement.Constraints {
+			c, err := stack.ParseConstraint(raw)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", name, err)
+			}
+			constraints = append(constraints, c)
+		}
+		if matchAny(nodes, constraints...) {
+			continue
+		}
+		// Name the constraints no node satisfies on its own, typically a
+		// node label that was never set.
+		var unmet []string
+		for _, c := range constraints {
+			if !matchAny(nodes, c) {
+				unmet = append(unmet, c.String())
+			}
+		}
+		msg := fmt.Sprintf("%s: no node satisfies all of %s", name, strings.Join(desired.Services[name].TaskTemplate.Placement.Constraints, ", "))
+		if len(unmet) > 0 {
+			msg = fmt.Sprintf("%s: no node satisfies %s", name, strings.Join(unmet, ", "))
+		}
+		failed = append(failed, msg)
+	}
+	if len(failed) > 0 {
+		return &PlacementError{Services: failed}
+	}
+	return nil
+}
+
+func matchAny(nodes []docker.Node, constraints ...stack.Constraint) bool {
+	for _, n := range nodes {
+		ok := true
+		for _, c := range constraints {
+			if !c.Match(n) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
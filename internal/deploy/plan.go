@@ -0,0 +1,441 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/acebelowzero/tmpl/internal/diff"
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/release"
+	"github.com/acebelowzero/tmpl/internal/stack"
+	"github.com/acebelowzero/tmpl/internal/timing"
+)
+
+// PlanVersion is the format version of saved plan files.
+const PlanVersion = 1
+
+// Plan is the set of changes that converge a live stack onto a desired one.
+type Plan struct {
+	Version int       `json:"version"`
+	Stack   string    `json:"stack"`
+	Created time.Time `json:"created"`
+	// Engine is the docker endpoint the plan was computed against.
+	Engine string `json:"engine,omitempty"`
+	// Prune records that deletes of tmpl-owned objects are carried out.
+	Prune   bool     `json:"prune,omitempty"`
+	Changes []Change `json:"changes"`
+	// Desired holds the specs to apply. Secret payloads are never
+	// included; see Digest on secret changes.
+	Desired *stack.Stack `json:"desired"`
+	// Skipped lists the services a selective plan leaves at their live
+	// spec, or does not deploy.
+	Skipped []string `json:"skipped,omitempty"`
+
+	// Release describes the rendered chart and is recorded in the release
+	// history when the plan is applied. Its Stack and values are always
+	// nil, as they may hold secret content.
+	Release *release.Release `json:"release,omitempty"`
+}
+
+// Count returns the number of planned changes with the given action.
+func (p *Plan) Count(action Action) int {
+	return count(p.Changes, action)
+}
+
+// HasChanges reports whether applying the plan would modify the swarm.
+func (p *Plan) HasChanges() bool {
+	return p.Count(ActionCreate)+p.Count(ActionUpdate)+p.Count(ActionDelete) > 0
+}
+
+// Write saves the plan as indented JSON.
+func (p *Plan) Write(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode plan: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("write plan %s: %w", path, err)
+	}
+	return nil
+}
+
+// ReadPlan loads a plan saved with Write.
+func ReadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read plan %s: %w", path, err)
+	}
+	var p Plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("decode plan %s: %w", path, err)
+	}
+	if p.Version != PlanVersion {
+		return nil, fmt.Errorf("plan %s has unsupported version %d", path, p.Version)
+	}
+	if p.Desired == nil {
+		return nil, fmt.Errorf("plan %s has no desired state", path)
+	}
+	return &p, nil
+}
+
+// Plan queries the swarm and computes the object-level changes needed to
+// converge it onto desired, without modifying anything. With a service
+// selector in opts, the desired state of the plan differs from desired;
+// see Selector.
+func (d *Deployer) Plan(ctx context.Context, desired *stack.Stack, opts Options) (*Plan, error) {
+	defer timing.Track(ctx, timing.Plan)()
+	if err := d.checkManager(ctx); err != nil {
+		return nil, err
+	}
+	live, err := stack.Fetch(ctx, d.client, desired.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var skipped []string
+	if !opts.Services.IsZero() {
+		if desired, skipped, err = selectServices(desired, live, opts.Services); err != nil {
+			return nil, err
+		}
+	}
+
+	p := &Plan{
+		Version: PlanVersion,
+		Stack:   desired.Name,
+		Created: time.Now().UTC(),
+		Engine:  d.client.Host(),
+		Prune:   opts.Prune,
+		Desired: desired.WithoutSecretData(),
+		Skipped: skipped,
+	}
+	planNetworks(p, desired, live)
+	planObjects(p, KindConfig, desired.Configs, nil, configObjects(live))
+	planObjects(p, KindSecret, desired.Secrets, desired.SecretDigests, secretObjects(live))
+	if err := planServices(p, desired, live); err != nil {
+		return nil, err
+	}
+	if err := d.checkOwnership(ctx, p, live); err != nil {
+		return nil, err
+	}
+	if err := d.checkPlacement(ctx, desired); err != nil {
+		return nil, err
+	}
+	if opts.CheckImages {
+		if err := d.checkImages(ctx, p, desired); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (d *Deployer) checkManager(ctx context.Context) error {
+	info, err := d.client.Info(ctx)
+	if err != nil {
+		return fmt.Errorf("query docker engine: %w", err)
+	}
+	if !info.Swarm.Active() || !info.Swarm.ControlAvailable {
+		return fmt.Errorf("docker engine at %s is not a swarm manager", d.client.Host())
+	}
+	return nil
+}
+
+func planNetworks(p *Plan, desired *stack.Stack, live *stack.Live) {
+	for _, name := range sortedKeys(desired.Networks) {
+		spec := desired.Networks[name]
+		existing, ok := live.NetworkObjects[name]
+		if !ok {
+			p.Changes = append(p.Changes, Change{Kind: KindNetwork, Name: name, Action: ActionCreate})
+			continue
+		}
+		c := Change{Kind: KindNetwork, Name: name, Action: ActionUnchanged, ID: existing.ID}
+		// Networks cannot be updated in place and recreating one would
+		// detach running tasks, so differences are only reported.
+		if changes := compareTrees(networkShape(live.Networks[name]), networkShape(spec)); len(changes) > 0 {
+			c.Diff = changes
+			c.Warnings = []string{"network differs from the chart but networks are not updated in place"}
+		}
+		p.Changes = append(p.Changes, c)
+	}
+	for _, name := range sortedKeys(live.NetworkObjects) {
+		if _, ok := desired.Networks[name]; !ok {
+			p.Changes = append(p.Changes, Change{Kind: KindNetwork, Name: name, Action: ActionDelete, ID: live.NetworkObjects[name].ID,
+				Prune: p.Prune && stack.Owner(live.Networks[name].Labels) == p.Stack})
+		}
+	}
+}
+
+// networkShape keeps the network settings that matter for comparison; the
+// engine adds driver options of its own to overlay networks.
+func networkShape(spec stack.NetworkSpec) any {
+	return struct {
+		Driver     string
+		Attachable bool
+		Internal   bool
+	}{spec.Driver, spec.Attachable, spec.Internal}
+}
+
+// liveObject is the part of a live config or secret a plan depends on.
+type liveObject struct {
+	id      string
+	version docker.Version
+	spec    docker.ObjectSpec
+}
+
+func configObjects(live *stack.Live) map[string]liveObject {
+	out := make(map[string]liveObject, len(live.ConfigObjects))
+	for name, obj := range live.ConfigObjects {
+		out[name] = liveObject{id: obj.ID, version: obj.Version, spec: obj.Spec}
+	}
+	return out
+}
+
+func secretObjects(live *stack.Live) map[string]liveObject {
+	out := make(map[string]liveObject, len(live.SecretObjects))
+	for name, obj := range live.SecretObjects {
+		out[name] = liveObject{id: obj.ID, version: obj.Version, spec: obj.Spec}
+	}
+	return out
+}
+
+// planObjects plans the configs or secrets desired. digests holds the
+// digests of those whose payloads were left out.
+func planObjects(p *Plan, kind Kind, desired map[string]docker.ObjectSpec, digests map[string]string, live map[string]liveObject) {
+	for _, name := range sortedKeys(desired) {
+		spec := desired[name]
+		c := Change{Kind: kind, Name: name, Action: ActionCreate, Digest: digest(spec.Data)}
+		if d, ok := digests[name]; ok && spec.Data == nil {
+			c.Digest = d
+		}
+		existing, ok := live[name]
+		if ok {
+			c.ID, c.ObjectVersion = existing.id, existing.version.Index
+			c.Action = ActionUnchanged
+			if !maps.Equal(stack.ComparableLabels(existing.spec.Labels), stack.ComparableLabels(spec.Labels)) {
+				c.Action = ActionUpdate
+				c.Diff = compareTrees(labelsOf(existing.spec), labelsOf(spec))
+			}
+			// The engine returns config data but never secret data, so
+			// only config content changes can be detected.
+			if kind == KindConfig && !bytes.Equal(existing.spec.Data, spec.Data) {
+				c.Action = ActionUpdate
+				c.Diff = append(c.Diff, diff.Change{Path: "Data", Kind: diff.Changed, Old: digest(existing.spec.Data), New: c.Digest})
+				c.Warnings = append(c.Warnings, "content changed but swarm configs are immutable; apply will fail unless the config is renamed")
+			}
+		}
+		p.Changes = append(p.Changes, c)
+	}
+	versions := make(map[string]string, len(desired))
+	for name, spec := range desired {
+		if base := spec.Labels[stack.LabelObject]; base != "" {
+			versions[base] = name
+		}
+	}
+	for _, name := range sortedKeys(live) {
+		if _, ok := desired[name]; !ok {
+			obj := live[name]
+			c := Change{Kind: kind, Name: name, Action: ActionDelete, ID: obj.id, ObjectVersion: obj.version.Index}
+			// Older versions of a rotated object are labelled with its
+			// base name; objects from before rotation carry it as name.
+			base := obj.spec.Labels[stack.LabelObject]
+			if base == "" {
+				base = name
+			}
+			c.ReplacedBy = versions[base]
+			c.Prune = p.Prune && c.ReplacedBy == "" && stack.Owner(obj.spec.Labels) == p.Stack
+			p.Changes = append(p.Changes, c)
+		}
+	}
+}
+
+func planServices(p *Plan, desired *stack.Stack, live *stack.Live) error {
+	for _, name := range desired.ServiceNames() {
+		existing, ok := live.ServiceObjects[name]
+		if !ok {
+			p.Changes = append(p.Changes, Change{Kind: KindService, Name: name, Action: ActionCreate})
+			continue
+		}
+		changes, err := compareServices(existing.Spec, desired.Services[name])
+		if err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+		c := Change{Kind: KindService, Name: name, Action: ActionUnchanged, ID: existing.ID, ObjectVersion: existing.Version.Index}
+		if len(changes) > 0 {
+			c.Action, c.Diff = ActionUpdate, changes
+		}
+		p.Changes = append(p.Changes, c)
+	}
+	for _, name := range sortedKeys(live.ServiceObjects) {
+		if _, ok := desired.Services[name]; !ok {
+			obj := live.ServiceObjects[name]
+			p.Changes = append(p.Changes, Change{Kind: KindService, Name: name, Action: ActionDelete, ID: obj.ID, ObjectVersion: obj.Version.Index,
+				Prune: p.Prune && stack.Owner(obj.Spec.Labels) == p.Stack, Volumes: namedVolumes(obj.Spec)})
+		}
+	}
+	return nil
+}
+
+// namedVolumes returns the named volumes a service mounts.
+func namedVolumes(spec docker.ServiceSpec) []string {
+	if spec.TaskTemplate.ContainerSpec == nil {
+		return nil
+	}
+	var volumes []string
+	for _, m := range spec.TaskTemplate.ContainerSpec.Mounts {
+		if m.Type == "volume" && m.Source != "" {
+			volumes = append(volumes, m.Source)
+		}
+	}
+	return volumes
+}
+
+// compareServices diffs service specs the same way tmpl diff does,
+// ignoring fields assigned by the engine.
+func compareServices(old, new docker.ServiceSpec) ([]diff.Change, error) {
+	a := (&stack.Stack{Services: map[string]docker.ServiceSpec{"": old}}).Comparable()
+	b := (&stack.Stack{Services: map[string]docker.ServiceSpec{"": new}}).Comparable()
+	oldTree, err := diff.Normalize(a.Services[""])
+	if err != nil {
+		return nil, err
+	}
+	newTree, err := diff.Normalize(b.Services[""])
+	if err != nil {
+		return nil, err
+	}
+	return diff.Compare(oldTree, newTree), nil
+}
+
+func compareTrees(old, new any) []diff.Change {
+	oldTree, err := diff.Normalize(old)
+	if err != nil {
+		return nil
+	}
+	newTree, err := diff.Normalize(new)
+	if err != nil {
+		return nil
+	}
+	return diff.Compare(oldTree, newTree)
+}
+
+func labelsOf(spec docker.ObjectSpec) any {
+	return struct{ Labels map[string]string }{stack.ComparableLabels(spec.Labels)}
+}
+
+// digest identifies config and secret content without revealing it.
+func digest(data []byte) string {
+	return stack.Digest(data)
+}
+
+// Destructive returns the deletes the plan carries out that remove
+// services with named volumes, whose data is no longer used by the stack.
+func (p *Plan) Destructive() []Change {
+	var out []Change
+	for _, c := range p.Changes {
+		if c.Action == ActionDelete && c.Prune && len(c.Volumes) > 0 {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// NeedsSecrets reports whether executing the plan creates secrets, whose
+// payloads are not stored in plan files and must be supplied again.
+func (p *Plan) NeedsSecrets() bool {
+	for _, c := range p.Changes {
+		if c.Kind == KindSecret && c.Action == ActionCreate {
+			return true
+		}
+	}
+	return false
+}
+
+// DriftError reports that the swarm changed after a plan was computed.
+type DriftError struct {
+	Drifted []string
+}
+
+func (e *DriftError) Error() string {
+	return "live state drifted since the plan was made:\n  " + strings.Join(e.Drifted, "\n  ")
+}
+
+// ApplyPlan executes a saved plan. It recomputes the plan against the
+// live swarm and refuses to run when any object differs from what was
+// observed at planning time. secrets supplies the payloads of secrets the
+// plan creates; each must match the digest recorded in the plan.
+func (d *Deployer) ApplyPlan(ctx context.Context, p *Plan, secrets map[string]docker.ObjectSpec) (*Result, error) {
+	if p.Engine != "" && p.Engine != d.client.Host() {
+		return &Result{}, fmt.Errorf("plan was made against %s, not %s", p.Engine, d.client.Host())
+	}
+	current, err := d.Plan(ctx, p.Desired, Options{Prune: p.Prune})
+	if err != nil {
+		return &Result{}, err
+	}
+	if drifted := driftBetween(p.Changes, current.Changes); len(drifted) > 0 {
+		return &Result{}, &DriftError{Drifted: drifted}
+	}
+
+	desired := *p.Desired
+	desired.Secrets = make(map[string]docker.ObjectSpec, len(p.Desired.Secrets))
+	for name, spec := range p.Desired.Secrets {
+		desired.Secrets[name] = spec
+	}
+	for _, c := range p.Changes {
+		if c.Kind != KindSecret || c.Action != ActionCreate {
+			continue
+		}
+		spec, ok := secrets[c.Name]
+		if !ok {
+			return &Result{}, fmt.Errorf("plan creates secret %s but its content was not supplied", c.Name)
+		}
+		if digest(spec.Data) != c.Digest {
+			return &Result{}, fmt.Errorf("content of secret %s differs from the plan", c.Name)
+		}
+		// The planned labels carry the owner of the planned revision.
+		spec.Labels = desired.Secrets[c.Name].Labels
+		desired.Secrets[c.Name] = spec
+	}
+	return d.execute(ctx, p, &desired)
+}
+
+// driftBetween lists the objects whose planned action or observed identity
+// differs between two plans of the same desired state.
+func driftBetween(planned, current []Change) []string {
+	type key struct {
+		kind Kind
+		name string
+	}
+	describe := func(c Change) string {
+		if c.ID == "" {
+			return string(c.Action)
+		}
+		return fmt.Sprintf("%s of %s@%d", c.Action, shortID(c.ID), c.ObjectVersion)
+	}
+	seen := make(map[key]Change, len(planned))
+	for _, c := range planned {
+		seen[key{c.Kind, c.Name}] = c
+	}
+
+	var drifted []string
+	for _, c := range current {
+		k := key{c.Kind, c.Name}
+		old, ok := seen[k]
+		delete(seen, k)
+		switch {
+		case !ok:
+			drifted = append(drifted, fmt.Sprintf("%s %s: not in plan, now %s", c.Kind, c.Name, describe(c)))
+		case old.Action != c.Action || old.ID != c.ID || old.ObjectVersion != c.ObjectVersion:
+			drifted = append(drifted, fmt.Sprintf("%s %s: planned %s, now %s", c.Kind, c.Name, describe(old), describe(c)))
+		}
+	}
+	for _, c := range planned {
+		if _, ok := seen[key{c.Kind, c.Name}]; ok {
+			drifted = append(drifted, fmt.Sprintf("%s %s: planned %s, object is gone", c.Kind, c.Name, describe(c)))
+		}
+	}
+	return drifted
+}
@@ -0,0 +1,126 @@
+package deploy
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"strings"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// Selector restricts a plan to some services of the desired stack. Only
+// and Exclude hold patterns in path.Match syntax, e.g. "cron-*", matched
+// against compose service names without the stack prefix.
+type Selector struct {
+	Only    []string
+	Exclude []string
+}
+
+// IsZero reports whether the selector selects every service.
+func (s Selector) IsZero() bool {
+	return len(s.Only) == 0 && len(s.Exclude) == 0
+}
+
+// Validate checks the syntax of the patterns.
+func (s Selector) Validate() error {
+	for _, pattern := range append(append([]string{}, s.Only...), s.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid service pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Match reports whether the compose service name is selected.
+func (s Selector) Match(service string) bool {
+	if len(s.Only) > 0 && !matchPattern(s.Only, service) {
+		return false
+	}
+	return !matchPattern(s.Exclude, service)
+}
+
+func matchPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// selectServices returns desired with the services sel does not select
+// replaced by their live spec, or dropped when they are not deployed, so
+// that the plan leaves them untouched. Live configs and secrets used by
+// the kept services are added unless desired holds them. The names of the
+// services that were not selected are returned as well.
+func selectServices(desired *stack.Stack, live *stack.Live, sel Selector) (*stack.Stack, []string, error) {
+	if err := sel.Validate(); err != nil {
+		return nil, nil, err
+	}
+	prefix := desired.Name + "_"
+	for _, pattern := range sel.Only {
+		var matched bool
+		for name := range desired.Services {
+			if ok, _ := path.Match(pattern, strings.TrimPrefix(name, prefix)); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, nil, fmt.Errorf("service pattern %q matches no service of stack %s", pattern, desired.Name)
+		}
+	}
+
+	out := *desired
+	out.Services = make(map[string]docker.ServiceSpec, len(desired.Services))
+	out.Configs = maps.Clone(desired.Configs)
+	out.Secrets = maps.Clone(desired.Secrets)
+	var skipped []string
+	selected := 0
+	keepLive := func(name string) {
+		spec, ok := live.Services[name]
+		if !ok {
+			return
+		}
+		out.Services[name] = spec
+		cs := spec.TaskTemplate.ContainerSpec
+		if cs == nil {
+			return
+		}
+		for _, ref := range cs.Configs {
+			if _, ok := out.Configs[ref.ConfigName]; !ok {
+				if cfg, ok := live.Configs[ref.ConfigName]; ok {
+					out.Configs[ref.ConfigName] = cfg
+				}
+			}
+		}
+		for _, ref := range cs.Secrets {
+			if _, ok := out.Secrets[ref.SecretName]; !ok {
+				if sec, ok := live.Secrets[ref.SecretName]; ok {
+					out.Secrets[ref.SecretName] = sec
+				}
+			}
+		}
+	}
+	for _, name := range desired.ServiceNames() {
+		if sel.Match(strings.TrimPrefix(name, prefix)) {
+			out.Services[name] = desired.Services[name]
+			selected++
+			continue
+		}
+		skipped = append(skipped, name)
+		keepLive(name)
+	}
+	for _, name := range sortedKeys(live.Services) {
+		if _, ok := desired.Services[name]; !ok && !sel.Match(strings.TrimPrefix(name, prefix)) {
+			skipped = append(skipped, name)
+			keepLive(name)
+		}
+	}
+	if selected == 0 && len(desired.Services) > 0 {
+		return nil, nil, fmt.Errorf("no service of stack %s is selected", desired.Name)
+	}
+	return &out, skipped, nil
+}
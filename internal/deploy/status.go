@@ -0,0 +1,129 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// TaskEvent is an observed state of a task of a stack service.
+type TaskEvent struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	Task    string    `json:"task"`
+	Slot    int       `json:"slot,omitempty"`
+	Node    string    `json:"node,omitempty"`
+	State   string    `json:"state"`
+	Desired string    `json:"desiredState"`
+	Message string    `json:"message,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Status reports the convergence of every service of the named stack,
+// ordered by service name.
+func (d *Deployer) Status(ctx context.Context, name string) ([]ServiceStatus, error) {
+	services, err := d.stackServices(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ServiceStatus, 0, len(services))
+	for i := range services {
+		tasks, err := d.client.ListTasks(ctx, docker.Filters{}.Service(services[i].ID))
+		if err != nil {
+			return nil, fmt.Errorf("list tasks of %s: %w", services[i].Spec.Name, err)
+		}
+		out = append(out, serviceStatus(&services[i], tasks, time.Time{}))
+	}
+	return out, nil
+}
+
+// Watch polls the tasks of the named stack and calls fn with every task
+// state not reported before, oldest first, until ctx ends or fn fails. The
+// first poll reports the current state of the tasks meant to be running.
+func (d *Deployer) Watch(ctx context.Context, name string, interval time.Duration, fn func(TaskEvent) error) error {
+	if interval <= 0 {
+		interval = DefaultWaitInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	type observed struct{ state, desired, err string }
+	seen := map[string]observed{}
+	first := true
+	for {
+		events, err := d.taskEvents(ctx, name)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		for _, e := range events {
+			o := observed{e.State, e.Desired, e.Error}
+			prev, ok := seen[e.Task]
+			seen[e.Task] = o
+			if (ok && prev == o) || (!ok && first && e.Desired != "running") {
+				continue
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		first = false
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// taskEvents returns the current state of every task of a stack, oldest
+// first.
+func (d *Deployer) taskEvents(ctx context.Context, name string) ([]TaskEvent, error) {
+	services, err := d.stackServices(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(services))
+	filters := docker.Filters{}
+	for _, svc := range services {
+		names[svc.ID] = svc.Spec.Name
+		filters.Service(svc.ID)
+	}
+	tasks, err := d.client.ListTasks(ctx, filters)
+	if err != nil {
+		return nil, fmt.Errorf("list tasks of %s: %w", name, err)
+	}
+	events := make([]TaskEvent, 0, len(tasks))
+	for _, t := range tasks {
+		events = append(events, TaskEvent{
+			Time:    t.Status.Timestamp,
+			Service: names[t.ServiceID],
+			Task:    shortID(t.ID),
+			Slot:    t.Slot,
+			Node:    shortID(t.NodeID),
+			State:   t.Status.State,
+			Desired: t.DesiredState,
+			Message: t.Status.Message,
+			Error:   t.Status.Err,
+		})
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events, nil
+}
+
+// stackServices lists the services of a stack by name.
+func (d *Deployer) stackServices(ctx context.Context, name string) ([]docker.Service, error) {
+	services, err := d.client.ListServices(ctx, docker.Filters{}.Label(stack.LabelNamespace+"="+name))
+	if err != nil {
+		return nil, fmt.Errorf("list services of %s: %w", name, err)
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("stack %s has no services", name)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Spec.Name < services[j].Spec.Name })
+	return services, nil
+}
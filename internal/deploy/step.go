@@ -0,0 +1,30 @@
+package deploy
+
+import "time"
+
+// Step reports a single change made or skipped while executing a plan.
+type Step struct {
+	Change   Change
+	Duration time.Duration
+	// Err is set when the change failed. Failed removals do not stop the
+	// apply; see Apply.
+	Err error
+	// Skipped is set on deletes that are left in place.
+	Skipped bool
+	// Running is set on the report made when a change begins; the change
+	// is reported again when it finished.
+	Running bool
+}
+
+// Observe registers fn to be called with every step of later applies,
+// in the order they are made, after the observers registered before.
+// Unchanged objects are not reported.
+func (d *Deployer) Observe(fn func(Step)) {
+	d.observers = append(d.observers, fn)
+}
+
+func (d *Deployer) step(s Step) {
+	for _, fn := range d.observers {
+		fn(s)
+	}
+}
@@ -0,0 +1,136 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/logx"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// UninstallError reports objects the engine refused to remove.
+type UninstallError struct {
+	Stack  string
+	Failed []string
+}
+
+func (e *UninstallError) Error() string {
+	return fmt.Sprintf("stack %s was not fully removed:\n  %s", e.Stack, strings.Join(e.Failed, "\n  "))
+}
+
+// Uninstall removes the services, networks, configs and secrets of a stack
+// owned by the release named after it. Objects of the stack owned by
+// another release or deployed without tmpl are left in place and returned
+// as unchanged; named volumes are never removed. With dryRun nothing is
+// changed. Removals the engine refuses are reported as an UninstallError
+// once every other object was attempted.
+func (d *Deployer) Uninstall(ctx context.Context, name string, dryRun bool) ([]Change, error) {
+	if err := d.checkManager(ctx); err != nil {
+		return nil, err
+	}
+	live, err := stack.Fetch(ctx, d.client, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	consider := func(kind Kind, objName, id string, labels map[string]string, volumes []string) {
+		c := Change{Kind: kind, Name: objName, Action: ActionUnchanged, ID: id}
+		if stack.Owner(labels) == name {
+			c.Action, c.Volumes = ActionDelete, volumes
+		} else {
+			c.Warnings = []string{"not owned by release " + name}
+		}
+		changes = append(changes, c)
+	}
+	for _, n := range sortedKeys(live.ServiceObjects) {
+		obj := live.ServiceObjects[n]
+		consider(KindService, n, obj.ID, obj.Spec.Labels, namedVolumes(obj.Spec))
+	}
+	for _, n := range sortedKeys(live.ConfigObjects) {
+		obj := live.ConfigObjects[n]
+		consider(KindConfig, n, obj.ID, obj.Spec.Labels, nil)
+	}
+	for _, n := range sortedKeys(live.SecretObjects) {
+		obj := live.SecretObjects[n]
+		consider(KindSecret, n, obj.ID, obj.Spec.Labels, nil)
+	}
+	for _, n := range sortedKeys(live.NetworkObjects) {
+		obj := live.NetworkObjects[n]
+		consider(KindNetwork, n, obj.ID, obj.Labels, nil)
+	}
+	if dryRun {
+		return changes, nil
+	}
+
+	log := logx.FromContext(ctx)
+	var failed []string
+	var removed []string
+	// Changes are ordered services first, as removalOrder requires.
+	for i, c := range changes {
+		if c.Action != ActionDelete {
+			continue
+		}
+		if c.Kind != KindService && len(removed) > 0 {
+			// Networks, configs and secrets stay in use until the tasks of
+			// the removed services have shut down.
+			if err := d.waitTasksGone(ctx, removed); err != nil {
+				return changes, err
+			}
+			removed = nil
+		}
+		if err := d.remove(ctx, c); err != nil {
+			failed = append(failed, fmt.Sprintf("%s %s: %v", c.Kind, c.Name, err))
+			changes[i].Action = ActionUnchanged
+			continue
+		}
+		log.Debug("removed object", "kind", c.Kind, "name", c.Name)
+		if c.Kind == KindService {
+			removed = append(removed, c.ID)
+		}
+	}
+	if len(failed) > 0 {
+		return changes, &UninstallError{Stack: name, Failed: failed}
+	}
+	return changes, nil
+}
+
+// waitTasksGone polls until no task of the given services is left. The
+// engine rejects task filters naming removed services, so all tasks are
+// listed and matched by service ID.
+func (d *Deployer) waitTasksGone(ctx context.Context, services []string) error {
+	ids := make(map[string]bool, len(services))
+	for _, id := range services {
+		ids[id] = true
+	}
+	ticker := time.NewTicker(DefaultWaitInterval)
+	defer ticker.Stop()
+	for {
+		tasks, err := d.client.ListTasks(ctx, docker.Filters{})
+		if err != nil && ctx.Err() == nil {
+			return fmt.Errorf("list tasks: %w", err)
+		}
+		left := 0
+		for _, t := range tasks {
+			if ids[t.ServiceID] {
+				left++
+			}
+		}
+		if err == nil && left == 0 {
+			return nil
+		}
+		logx.FromContext(ctx).Debug("waiting for tasks to shut down", "tasks", left)
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("timed out waiting for %d tasks to shut down", left)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/stack"
+	"github.com/acebelowzero/tmpl/internal/timing"
+)
+
+// ValidationError reports the problems the engine found with a stack.
+type ValidationError struct {
+	Stack    string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("stack %s failed engine validation:\n  %s", e.Stack, strings.Join(e.Problems, "\n  "))
+}
+
+// outOfSequence is the error of the engine for an update with a stale
+// object version, which it checks after validating the spec.
+const outOfSequence = "update out of sequence"
+
+// Validate checks desired against the swarm without modifying it. The
+// Engine API has no dry run, so the specs of services that already exist
+// are sent as an update with version 0: the engine validates the spec and
+// then rejects the stale version, so nothing is persisted. For all
+// services, referenced networks, configs and secrets must exist or be part
+// of the stack, and placement constraints must match a node.
+func (d *Deployer) Validate(ctx context.Context, desired *stack.Stack) error {
+	defer timing.Track(ctx, timing.Validate)()
+	if err := d.checkManager(ctx); err != nil {
+		return err
+	}
+	live, err := stack.Fetch(ctx, d.client, desired.Name)
+	if err != nil {
+		return err
+	}
+	networks, err := d.client.ListNetworks(ctx, docker.Filters{})
+	if err != nil {
+		return fmt.Errorf("list networks: %w", err)
+	}
+	existing := map[string]bool{}
+	for _, n := range networks {
+		existing[n.Name] = true
+	}
+	configIDs, err := d.configIDs(ctx)
+	if err != nil {
+		return err
+	}
+	secretIDs, err := d.secretIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, name := range sortedKeys(desired.Services) {
+		spec := desired.Services[name]
+		var missing []string
+		for _, n := range spec.TaskTemplate.Networks {
+			if _, ok := desired.Networks[n.Target]; !ok && !existing[n.Target] {
+				missing = append(missing, "network "+n.Target)
+			}
+		}
+		// References to objects the stack creates cannot be resolved
+		// before the apply, so such services skip the engine check.
+		resolvable := true
+		if cs := spec.TaskTemplate.ContainerSpec; cs != nil {
+			for _, ref := range cs.Configs {
+				if _, ok := configIDs[ref.ConfigName]; ok {
+					continue
+				}
+				resolvable = false
+				if _, ok := desired.Configs[ref.ConfigName]; !ok {
+					missing = append(missing, "config "+ref.ConfigName)
+				}
+			}
+			for _, ref := range cs.Secrets {
+				if _, ok := secretIDs[ref.SecretName]; ok {
+					continue
+				}
+				resolvable = false
+				if _, ok := desired.Secrets[ref.SecretName]; !ok {
+					missing = append(missing, "secret "+ref.SecretName)
+				}
+			}
+		}
+		if len(missing) > 0 {
+			problems = append(problems, fmt.Sprintf("%s: %s not found", name, strings.Join(missing, ", ")))
+			continue
+		}
+		svc, ok := live.ServiceObjects[name]
+		if !ok || !resolvable {
+			continue
+		}
+		if err := d.validateService(ctx, svc.ID, spec, configIDs, secretIDs); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	var placement *PlacementError
+	if err := d.checkPlacement(ctx, desired); errors.As(err, &placement) {
+		problems = append(problems, placement.Services...)
+	} else if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Stack: desired.Name, Problems: problems}
+	}
+	return nil
+}
+
+// validateService has the engine validate spec as an update of the
+// service id with version 0, which it always refuses.
+func (d *Deployer) validateService(ctx context.Context, id string, spec docker.ServiceSpec, configIDs, secretIDs map[string]string) error {
+	spec, err := resolveReferences(spec, configIDs, secretIDs)
+	if err != nil {
+		return err
+	}
+	_, err = d.client.UpdateService(ctx, id, docker.Version{}, spec)
+	var apiErr *docker.APIError
+	switch {
+	case err == nil:
+		// Object versions start at 1, so no engine accepts version 0.
+		return fmt.Errorf("engine accepted an update with version 0")
+	case errors.As(err, &apiErr) && strings.Contains(apiErr.Message, outOfSequence):
+		return nil
+	case errors.As(err, &apiErr):
+		return errors.New(apiErr.Message)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,214 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/logx"
+	"github.com/acebelowzero/tmpl/internal/stack"
+	"github.com/acebelowzero/tmpl/internal/timing"
+)
+
+// DefaultWaitInterval is how often Wait polls service and task state.
+const DefaultWaitInterval = 2 * time.Second
+
+// Update states reported by the engine for rolling updates.
+const (
+	updateUpdating         = "updating"
+	updatePaused           = "paused"
+	updateRollbackStarted  = "rollback_started"
+	updateRollbackPaused   = "rollback_paused"
+	updateRollbackComplete = "rollback_completed"
+)
+
+// ServiceStatus summarises the convergence of one service.
+type ServiceStatus struct {
+	Name    string `json:"name"`
+	Desired int    `json:"desired"`
+	Running int    `json:"running"`
+	// Healthy counts the running tasks of a health-gated service that
+	// have run for its health gate; see stack.HealthGate.
+	Healthy int `json:"healthy,omitempty"`
+	// Update is the rolling update state, empty when no update ran.
+	Update string `json:"update,omitempty"`
+	// Errors holds the most recent task failures, newest first.
+	Errors []string `json:"errors,omitempty"`
+
+	healthGate time.Duration
+}
+
+func (s ServiceStatus) converged() bool {
+	if s.Update == updateUpdating {
+		return false
+	}
+	if s.healthGate > 0 {
+		return s.Healthy >= s.Desired
+	}
+	return s.Running >= s.Desired
+}
+
+func (s ServiceStatus) rolledBack() bool {
+	switch s.Update {
+	case updatePaused, updateRollbackStarted, updateRollbackPaused, updateRollbackComplete:
+		return true
+	}
+	return false
+}
+
+// WaitError reports services that did not converge.
+type WaitError struct {
+	Reason   string
+	Services []ServiceStatus
+}
+
+func (e *WaitError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Reason)
+	for _, s := range e.Services {
+		fmt.Fprintf(&b, "\n  %s: %d/%d running", s.Name, s.Running, s.Desired)
+		if s.healthGate > 0 {
+			fmt.Fprintf(&b, ", %d healthy for %s", s.Healthy, s.healthGate)
+		}
+		if s.Update != "" {
+			fmt.Fprintf(&b, ", update %s", s.Update)
+		}
+		for _, msg := range s.Errors {
+			fmt.Fprintf(&b, "\n    %s", msg)
+		}
+	}
+	return b.String()
+}
+
+// WaitOptions tunes Wait.
+type WaitOptions struct {
+	// Interval between polls; DefaultWaitInterval when zero.
+	Interval time.Duration
+	// Since ignores rolling update states from updates started earlier,
+	// such as a rollback left over from a previous deploy.
+	Since time.Time
+}
+
+// Wait polls the given services until every one has all desired replicas
+// running their current spec, and healthy for services gated with
+// stack.LabelWait, and no rolling update in progress. It fails early when
+// an update is paused or rolled back, and with the last observed state when
+// ctx ends.
+func (d *Deployer) Wait(ctx context.Context, services []string, opts WaitOptions) error {
+	defer timing.Track(ctx, timing.Wait)()
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DefaultWaitInterval
+	}
+	log := logx.FromContext(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var statuses []ServiceStatus
+	for {
+		var err error
+		statuses, err = d.status(ctx, services, opts.Since)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		if err == nil {
+			var pending, failed []ServiceStatus
+			for _, s := range statuses {
+				switch {
+				case s.rolledBack():
+					failed = append(failed, s)
+				case !s.converged():
+					pending = append(pending, s)
+				}
+			}
+			if len(failed) > 0 {
+				return &WaitError{Reason: "update failed", Services: failed}
+			}
+			if len(pending) == 0 {
+				return nil
+			}
+			statuses = pending
+			log.Debug("waiting for services to converge", "pending", len(pending))
+		}
+
+		select {
+		case <-ctx.Done():
+			reason := "wait cancelled"
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				reason = "timed out waiting for services to converge"
+			}
+			return &WaitError{Reason: reason, Services: statuses}
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Deployer) status(ctx context.Context, services []string, since time.Time) ([]ServiceStatus, error) {
+	out := make([]ServiceStatus, 0, len(services))
+	for _, name := range services {
+		svc, err := d.client.InspectService(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("inspect service %s: %w", name, err)
+		}
+		tasks, err := d.client.ListTasks(ctx, docker.Filters{}.Service(svc.ID))
+		if err != nil {
+			return nil, fmt.Errorf("list tasks of %s: %w", name, err)
+		}
+		out = append(out, serviceStatus(svc, tasks, since))
+	}
+	return out, nil
+}
+
+const maxTaskErrors = 3
+
+func serviceStatus(svc *docker.Service, tasks []docker.Task, since time.Time) ServiceStatus {
+	s := ServiceStatus{Name: svc.Spec.Name}
+	// Invalid gates were refused when the stack was converted.
+	s.healthGate, _ = stack.HealthGate(svc.Spec)
+	if us := svc.UpdateStatus; us != nil && (us.StartedAt == nil || !us.StartedAt.Before(since)) {
+		s.Update = us.State
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Status.Timestamp.After(tasks[j].Status.Timestamp)
+	})
+	nodes := map[string]bool{}
+	for _, t := range tasks {
+		if t.DesiredState == "running" {
+			nodes[t.NodeID] = true
+			// Tasks of the previous spec keep running until an update
+			// replaces them, whether or not the engine reports the update.
+			current := len(compareTrees(t.Spec, svc.Spec.TaskTemplate)) == 0
+			if current && t.Status.State == "running" {
+				s.Running++
+				if s.healthGate > 0 && time.Since(t.Status.Timestamp) >= s.healthGate {
+					s.Healthy++
+				}
+			}
+		}
+		if t.Status.Err != "" && len(s.Errors) < maxTaskErrors {
+			s.Errors = append(s.Errors, fmt.Sprintf("task %s: %s: %s", shortID(t.ID), t.Status.State, t.Status.Err))
+		}
+	}
+
+	switch {
+	case svc.Spec.Mode.Replicated != nil && svc.Spec.Mode.Replicated.Replicas != nil:
+		s.Desired = int(*svc.Spec.Mode.Replicated.Replicas)
+	case svc.Spec.Mode.Global != nil:
+		// Global services run one task per eligible node; the scheduler
+		// tells us which nodes those are through the desired tasks.
+		s.Desired = len(nodes)
+	}
+	return s
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
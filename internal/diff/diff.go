@@ -0,0 +1,160 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kind classifies a change between two documents.
+type Kind string
+
+const (
+	Added   Kind = "added"
+	Removed Kind = "removed"
+	Changed Kind = "changed"
+)
+
+// Change is a single difference at a path such as services.web.image.
+type Change struct {
+	Path string `json:"path"`
+	Kind Kind   `json:"kind"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+// Normalize converts v into generic JSON values (maps, slices, scalars) so
+// typed documents and decoded YAML can be compared uniformly.
+func Normalize(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Compare returns the changes needed to turn old into new, ordered by path.
+// Both values should be generic trees as produced by Normalize.
+func Compare(old, new any) []Change {
+	var changes []Change
+	compare("", old, new, &changes)
+	return changes
+}
+
+func compare(path string, old, new any, out *[]Change) {
+	switch o := old.(type) {
+	case map[string]any:
+		n, ok := new.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool, len(o)+len(n))
+		for k := range o {
+			keys[k] = true
+		}
+		for k := range n {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			ov, ook := o[k]
+			nv, nok := n[k]
+			child := join(path, k)
+			switch {
+			case !ook:
+				*out = append(*out, Change{Path: child, Kind: Added, New: nv})
+			case !nok:
+				*out = append(*out, Change{Path: child, Kind: Removed, Old: ov})
+			default:
+				compare(child, ov, nv, out)
+			}
+		}
+		return
+	case []any:
+		n, ok := new.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < len(o) || i < len(n); i++ {
+			child := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(o):
+				*out = append(*out, Change{Path: child, Kind: Added, New: n[i]})
+			case i >= len(n):
+				*out = append(*out, Change{Path: child, Kind: Removed, Old: o[i]})
+			default:
+				compare(child, o[i], n[i], out)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(old, new) {
+		*out = append(*out, Change{Path: path, Kind: Changed, Old: old, New: new})
+	}
+}
+
+func join(path, key string) string {
+	if strings.ContainsAny(key, ".[]") {
+		key = strconv.Quote(key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+)
+
+// Write prints changes one per line, optionally with ANSI colors.
+func Write(w io.Writer, changes []Change, color bool) error {
+	for _, c := range changes {
+		var line, code string
+		switch c.Kind {
+		case Added:
+			line, code = fmt.Sprintf("+ %s: %s", c.Path, format(c.New)), colorGreen
+		case Removed:
+			line, code = fmt.Sprintf("- %s: %s", c.Path, format(c.Old)), colorRed
+		default:
+			line, code = fmt.Sprintf("~ %s: %s -> %s", c.Path, format(c.Old), format(c.New)), colorYellow
+		}
+		if color {
+			line = code + line + colorReset
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func format(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(t)
+	default:
+		data, err := json.Marshal(t)
+		if err != nil {
+			return fmt.Sprint(t)
+		}
+		return string(data)
+	}
+}
@@ -0,0 +1,519 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/acebelowzero/tmpl/internal/telemetry"
+)
+
+const (
+	// DefaultHost is used when DOCKER_HOST is not set.
+	DefaultHost = "unix:///var/run/docker.sock"
+	// DefaultAPIVersion is the Engine API version requested by tmpl.
+	DefaultAPIVersion = "1.41"
+)
+
+// Config selects and authenticates the Docker Engine endpoint.
+type Config struct {
+	Host       string
+	APIVersion string
+	TLSVerify  bool
+	// CertPath is the directory holding ca.pem, cert.pem and key.pem.
+	CertPath string
+	// CAFile, CertFile and KeyFile override the files of CertPath.
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// ConfigFromEnv builds a Config from DOCKER_HOST, DOCKER_API_VERSION,
+// DOCKER_TLS_VERIFY and DOCKER_CERT_PATH. When DOCKER_HOST is unset the
+// endpoint of the current docker CLI context is used, as selected by
+// DOCKER_CONTEXT or the currentContext of the CLI config file.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Host:       os.Getenv("DOCKER_HOST"),
+		APIVersion: os.Getenv("DOCKER_API_VERSION"),
+		TLSVerify:  os.Getenv("DOCKER_TLS_VERIFY") != "",
+		CertPath:   os.Getenv("DOCKER_CERT_PATH"),
+	}
+	if cfg.Host != "" {
+		return cfg, nil
+	}
+	name, err := CurrentContext()
+	if err != nil {
+		return Config{}, err
+	}
+	if name == "" || name == DefaultContext {
+		return cfg, nil
+	}
+	ctxCfg, err := LoadContext(name)
+	if err != nil {
+		return Config{}, err
+	}
+	ctxCfg.APIVersion = cfg.APIVersion
+	return ctxCfg, nil
+}
+
+// Endpoint selects an engine as the docker CLI flags do. Unset fields fall
+// back to DOCKER_HOST, DOCKER_CONTEXT, DOCKER_TLS_VERIFY, DOCKER_CERT_PATH
+// and the current docker context.
+type Endpoint struct {
+	// Host is the engine to connect to, e.g. tcp://swarm:2376. It
+	// overrides Context.
+	Host string
+	// Context names a docker CLI context.
+	Context   string
+	TLSVerify bool
+	// CAFile, CertFile and KeyFile are the TLS material of the engine.
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// Config resolves the endpoint: Host, then Context, then the environment.
+// The TLS fields apply on top of the resolved endpoint.
+func (e Endpoint) Config() (Config, error) {
+	var cfg Config
+	switch {
+	case e.Host != "":
+		cfg = Config{
+			Host:      e.Host,
+			TLSVerify: os.Getenv("DOCKER_TLS_VERIFY") != "",
+			CertPath:  os.Getenv("DOCKER_CERT_PATH"),
+		}
+	case e.Context == DefaultContext:
+	case e.Context != "":
+		var err error
+		if cfg, err = LoadContext(e.Context); err != nil {
+			return Config{}, err
+		}
+	default:
+		var err error
+		if cfg, err = ConfigFromEnv(); err != nil {
+			return Config{}, err
+		}
+	}
+	if cfg.APIVersion == "" {
+		cfg.APIVersion = os.Getenv("DOCKER_API_VERSION")
+	}
+	cfg.TLSVerify = cfg.TLSVerify || e.TLSVerify
+	if e.CAFile != "" {
+		cfg.CAFile = e.CAFile
+	}
+	if e.CertFile != "" {
+		cfg.CertFile = e.CertFile
+	}
+	if e.KeyFile != "" {
+		cfg.KeyFile = e.KeyFile
+	}
+	return cfg, nil
+}
+
+// Client is a minimal Docker Engine API client for swarm resources.
+type Client struct {
+	cfg    Config
+	http   *http.Client
+	scheme string
+	host   string
+}
+
+// New constructs a Client for the configured endpoint.
+func New(cfg Config) (*Client, error) {
+	if cfg.Host == "" {
+		cfg.Host = DefaultHost
+	}
+	if cfg.APIVersion == "" {
+		cfg.APIVersion = DefaultAPIVersion
+	}
+	cfg.APIVersion = strings.TrimPrefix(cfg.APIVersion, "v")
+
+	u, err := url.Parse(cfg.Host)
+	if err != nil {
+		return nil, fmt.Errorf("parse docker host %s: %w", cfg.Host, err)
+	}
+
+	transport := &http.Transport{}
+	c := &Client{cfg: cfg, http: &http.Client{Transport: transport}}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		c.scheme, c.host = "http", "docker"
+	case "tcp", "http", "https":
+		c.scheme, c.host = "http", u.Host
+		if cfg.TLSVerify || cfg.CertPath != "" || cfg.CAFile != "" || cfg.CertFile != "" || u.Scheme == "https" {
+			tlsCfg, err := tlsConfig(cfg)
+			if err != nil {
+				return nil, err
+			}
+			transport.TLSClientConfig = tlsCfg
+			c.scheme = "https"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported docker host scheme %q", u.Scheme)
+	}
+	return c, nil
+}
+
+func tlsConfig(cfg Config) (*tls.Config, error) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: !cfg.TLSVerify}
+	dir := cfg.CertPath
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+
+	file := func(override, name string) string {
+		if override != "" {
+			return override
+		}
+		return filepath.Join(dir, name)
+	}
+
+	caFile := file(cfg.CAFile, "ca.pem")
+	if ca, err := os.ReadFile(caFile); err == nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid CA certificate in %s", caFile)
+		}
+		tlsCfg.RootCAs = pool
+	} else if cfg.TLSVerify || cfg.CAFile != "" {
+		return nil, fmt.Errorf("read CA certificate: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(file(cfg.CertFile, "cert.pem"), file(cfg.KeyFile, "key.pem"))
+	if err == nil {
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	} else if !errors.Is(err, os.ErrNotExist) || cfg.CertFile != "" {
+		return nil, fmt.Errorf("load client certificate: %w", err)
+	}
+	return tlsCfg, nil
+}
+
+// Host returns the endpoint the client talks to.
+func (c *Client) Host() string {
+	return c.cfg.Host
+}
+
+// APIError is a non-2xx response from the Engine API.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("docker api: %s (status %d)", e.Message, e.StatusCode)
+}
+
+// IsNotFound reports whether err is an Engine API 404.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsConflict reports whether err is an Engine API 409, returned when an
+// object with the same name exists.
+func IsConflict(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out any) (err error) {
+	ctx, span := telemetry.Start(ctx, telemetry.Docker, attribute.String("http.request.method", method))
+	defer func() { span.End(err) }()
+	span.Annotate(attribute.String("url.path", path), attribute.String("docker.host", c.Host()))
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reader = b
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encode request %s %s: %w", method, path, err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	u := url.URL{
+		Scheme:   c.scheme,
+		Host:     c.host,
+		Path:     "/v" + c.cfg.APIVersion + path,
+		RawQuery: query.Encode(),
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("docker %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	span.Annotate(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		var payload struct {
+			Message string `json:"message"`
+		}
+		msg := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &payload) == nil && payload.Message != "" {
+			msg = payload.Message
+		}
+		return &APIError{StatusCode: resp.StatusCode, Message: msg}
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// Filters builds the JSON encoded filters query parameter.
+type Filters map[string][]string
+
+// Label adds a label filter, "key" or "key=value".
+func (f Filters) Label(label string) Filters {
+	f["label"] = append(f["label"], label)
+	return f
+}
+
+// Service adds a service filter by ID or name.
+func (f Filters) Service(service string) Filters {
+	f["service"] = append(f["service"], service)
+	return f
+}
+
+func (f Filters) query() url.Values {
+	q := url.Values{}
+	if len(f) == 0 {
+		return q
+	}
+	data, _ := json.Marshal(map[string][]string(f))
+	q.Set("filters", string(data))
+	return q
+}
+
+// Ping checks the engine is reachable.
+func (c *Client) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return c.do(ctx, http.MethodGet, "/_ping", nil, nil, nil)
+}
+
+// Info returns engine and swarm information.
+func (c *Client) Info(ctx context.Context) (*Info, error) {
+	var info Info
+	if err := c.do(ctx, http.MethodGet, "/info", nil, nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// ListServices lists swarm services matching filters.
+func (c *Client) ListServices(ctx context.Context, filters Filters) ([]Service, error) {
+	var services []Service
+	if err := c.do(ctx, http.MethodGet, "/services", filters.query(), nil, &services); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+// InspectService returns a single service by ID or name.
+func (c *Client) InspectService(ctx context.Context, id string) (*Service, error) {
+	var svc Service
+	if err := c.do(ctx, http.MethodGet, "/services/"+url.PathEscape(id), nil, nil, &svc); err != nil {
+		return nil, err
+	}
+	return &svc, nil
+}
+
+// ListNodes lists the nodes of the swarm.
+func (c *Client) ListNodes(ctx context.Context, filters Filters) ([]Node, error) {
+	var nodes []Node
+	if err := c.do(ctx, http.MethodGet, "/nodes", filters.query(), nil, &nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// ListNetworks lists networks matching filters.
+func (c *Client) ListNetworks(ctx context.Context, filters Filters) ([]Network, error) {
+	var networks []Network
+	if err := c.do(ctx, http.MethodGet, "/networks", filters.query(), nil, &networks); err != nil {
+		return nil, err
+	}
+	return networks, nil
+}
+
+// ListConfigs lists swarm configs matching filters.
+func (c *Client) ListConfigs(ctx context.Context, filters Filters) ([]SwarmConfig, error) {
+	var configs []SwarmConfig
+	if err := c.do(ctx, http.MethodGet, "/configs", filters.query(), nil, &configs); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// ListSecrets lists swarm secrets matching filters.
+func (c *Client) ListSecrets(ctx context.Context, filters Filters) ([]SwarmSecret, error) {
+	var secrets []SwarmSecret
+	if err := c.do(ctx, http.MethodGet, "/secrets", filters.query(), nil, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// CreateResponse is returned by object create endpoints.
+type CreateResponse struct {
+	ID       string   `json:"ID"`
+	Warnings []string `json:",omitempty"`
+}
+
+// CreateService creates a swarm service and returns its ID.
+func (c *Client) CreateService(ctx context.Context, spec ServiceSpec) (*CreateResponse, error) {
+	var resp CreateResponse
+	if err := c.do(ctx, http.MethodPost, "/services/create", nil, spec, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateService replaces the spec of a service. version must be the
+// current object version, as returned by InspectService.
+func (c *Client) UpdateService(ctx context.Context, id string, version Version, spec ServiceSpec) ([]string, error) {
+	q := url.Values{}
+	q.Set("version", strconv.FormatUint(version.Index, 10))
+	var resp struct {
+		Warnings []string
+	}
+	if err := c.do(ctx, http.MethodPost, "/services/"+url.PathEscape(id)+"/update", q, spec, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Warnings, nil
+}
+
+// RemoveService deletes a service.
+func (c *Client) RemoveService(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/services/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// NetworkCreate is the request body of POST /networks/create.
+type NetworkCreate struct {
+	Name           string
+	CheckDuplicate bool
+	Driver         string            `json:",omitempty"`
+	Scope          string            `json:",omitempty"`
+	Attachable     bool              `json:",omitempty"`
+	Internal       bool              `json:",omitempty"`
+	Labels         map[string]string `json:",omitempty"`
+	Options        map[string]string `json:",omitempty"`
+}
+
+// CreateNetwork creates a network and returns its ID.
+func (c *Client) CreateNetwork(ctx context.Context, req NetworkCreate) (string, error) {
+	var resp struct {
+		ID string `json:"Id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/networks/create", nil, req, &resp); err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// RemoveNetwork deletes a network.
+func (c *Client) RemoveNetwork(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/networks/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// CreateConfig creates a swarm config and returns its ID.
+func (c *Client) CreateConfig(ctx context.Context, spec ObjectSpec) (string, error) {
+	var resp CreateResponse
+	if err := c.do(ctx, http.MethodPost, "/configs/create", nil, spec, &resp); err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// UpdateConfig updates a config. The engine only accepts label changes.
+func (c *Client) UpdateConfig(ctx context.Context, id string, version Version, spec ObjectSpec) error {
+	q := url.Values{}
+	q.Set("version", strconv.FormatUint(version.Index, 10))
+	return c.do(ctx, http.MethodPost, "/configs/"+url.PathEscape(id)+"/update", q, spec, nil)
+}
+
+// RemoveConfig deletes a config.
+func (c *Client) RemoveConfig(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/configs/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// CreateSecret creates a swarm secret and returns its ID.
+// The spec is encoded straight into the request body rather than
+// marshalled into an intermediate buffer.
+func (c *Client) CreateSecret(ctx context.Context, spec ObjectSpec) (string, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(json.NewEncoder(pw).Encode(spec))
+	}()
+	defer pr.Close()
+
+	var resp CreateResponse
+	if err := c.do(ctx, http.MethodPost, "/secrets/create", nil, pr, &resp); err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// UpdateSecret updates a secret. The engine only accepts label changes.
+func (c *Client) UpdateSecret(ctx context.Context, id string, version Version, spec ObjectSpec) error {
+	q := url.Values{}
+	q.Set("version", strconv.FormatUint(version.Index, 10))
+	return c.do(ctx, http.MethodPost, "/secrets/"+url.PathEscape(id)+"/update", q, spec, nil)
+}
+
+// RemoveSecret deletes a secret.
+func (c *Client) RemoveSecret(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/secrets/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// ListTasks lists swarm tasks matching filters.
+func (c *Client) ListTasks(ctx context.Context, filters Filters) ([]Task, error) {
+	var tasks []Task
+	if err := c.do(ctx, http.MethodGet, "/tasks", filters.query(), nil, &tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
@@ -0,0 +1,123 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DefaultContext is the implicit docker CLI context backed by DOCKER_HOST
+// or the local socket.
+const DefaultContext = "default"
+
+// configDir returns the docker CLI config directory.
+func configDir() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker"), nil
+}
+
+// CurrentContext returns the context selected by DOCKER_CONTEXT or by the
+// currentContext field of the docker CLI config file.
+func CurrentContext() (string, error) {
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name, nil
+	}
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read docker config: %w", err)
+	}
+	var cliCfg struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if err := json.Unmarshal(data, &cliCfg); err != nil {
+		return "", fmt.Errorf("decode docker config: %w", err)
+	}
+	return cliCfg.CurrentContext, nil
+}
+
+// LoadContext resolves the docker endpoint of a named CLI context from the
+// context store, including its TLS material.
+func LoadContext(name string) (Config, error) {
+	dir, err := configDir()
+	if err != nil {
+		return Config{}, err
+	}
+	sum := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(sum[:])
+
+	data, err := os.ReadFile(filepath.Join(dir, "contexts", "meta", id, "meta.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Config{}, fmt.Errorf("docker context %q not found", name)
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("read docker context %s: %w", name, err)
+	}
+	var meta struct {
+		Endpoints map[string]struct {
+			Host          string
+			SkipTLSVerify bool
+		}
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return Config{}, fmt.Errorf("decode docker context %s: %w", name, err)
+	}
+	endpoint, ok := meta.Endpoints["docker"]
+	if !ok || endpoint.Host == "" {
+		return Config{}, fmt.Errorf("docker context %s has no docker endpoint", name)
+	}
+
+	cfg := Config{Host: endpoint.Host}
+	tlsDir := filepath.Join(dir, "contexts", "tls", id, "docker")
+	if _, err := os.Stat(tlsDir); err == nil {
+		cfg.CertPath = tlsDir
+		cfg.TLSVerify = !endpoint.SkipTLSVerify
+	}
+	return cfg, nil
+}
+
+// ListContexts returns the names of the docker CLI contexts in the context
+// store, with DefaultContext first.
+func ListContexts() ([]string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "contexts", "meta"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read docker contexts: %w", err)
+	}
+	var names []string
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, "contexts", "meta", e.Name(), "meta.json"))
+		if err != nil {
+			continue
+		}
+		var meta struct {
+			Name string
+		}
+		if json.Unmarshal(data, &meta) == nil && meta.Name != "" {
+			names = append(names, meta.Name)
+		}
+	}
+	sort.Strings(names)
+	return append([]string{DefaultContext}, names...), nil
+}
@@ -0,0 +1,311 @@
+package docker
+
+import (
+	"os"
+	"time"
+)
+
+// The types below mirror the Engine API JSON documents used by tmpl. Field
+// names match the API so values round-trip without translation.
+
+// Version is the object version used for optimistic concurrency on updates.
+type Version struct {
+	Index uint64 `json:",omitempty"`
+}
+
+// Info is the subset of GET /info used by tmpl.
+type Info struct {
+	ServerVersion string
+	Swarm         SwarmInfo
+}
+
+// SwarmInfo describes the local node's swarm membership.
+type SwarmInfo struct {
+	NodeID           string
+	LocalNodeState   string
+	ControlAvailable bool
+}
+
+// Active reports whether the engine is part of a swarm.
+func (s SwarmInfo) Active() bool {
+	return s.LocalNodeState == "active"
+}
+
+// Service is a swarm service.
+type Service struct {
+	ID           string
+	Version      Version
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Spec         ServiceSpec
+	PreviousSpec *ServiceSpec  `json:",omitempty"`
+	UpdateStatus *UpdateStatus `json:",omitempty"`
+}
+
+// UpdateStatus reports the progress of a rolling update.
+type UpdateStatus struct {
+	State       string     `json:",omitempty"`
+	StartedAt   *time.Time `json:",omitempty"`
+	CompletedAt *time.Time `json:",omitempty"`
+	Message     string     `json:",omitempty"`
+}
+
+// ServiceSpec is the desired state of a service.
+type ServiceSpec struct {
+	Name           string            `json:",omitempty"`
+	Labels         map[string]string `json:",omitempty"`
+	TaskTemplate   TaskSpec          `json:",omitempty"`
+	Mode           ServiceMode       `json:",omitempty"`
+	UpdateConfig   *UpdateConfig     `json:",omitempty"`
+	RollbackConfig *UpdateConfig     `json:",omitempty"`
+	EndpointSpec   *EndpointSpec     `json:",omitempty"`
+}
+
+// TaskSpec is the template for service tasks.
+type TaskSpec struct {
+	ContainerSpec *ContainerSpec            `json:",omitempty"`
+	Resources     *ResourceRequirements     `json:",omitempty"`
+	RestartPolicy *RestartPolicy            `json:",omitempty"`
+	Placement     *Placement                `json:",omitempty"`
+	Networks      []NetworkAttachmentConfig `json:",omitempty"`
+	ForceUpdate   uint64                    `json:",omitempty"`
+}
+
+// ContainerSpec describes the container run by each task.
+type ContainerSpec struct {
+	Image       string             `json:",omitempty"`
+	Labels      map[string]string  `json:",omitempty"`
+	Command     []string           `json:",omitempty"`
+	Args        []string           `json:",omitempty"`
+	Hostname    string             `json:",omitempty"`
+	Env         []string           `json:",omitempty"`
+	Dir         string             `json:",omitempty"`
+	User        string             `json:",omitempty"`
+	Mounts      []Mount            `json:",omitempty"`
+	StopSignal  string             `json:",omitempty"`
+	Healthcheck *HealthConfig      `json:",omitempty"`
+	Configs     []*ConfigReference `json:",omitempty"`
+	Secrets     []*SecretReference `json:",omitempty"`
+	Sysctls     map[string]string  `json:",omitempty"`
+}
+
+// Mount is a volume or bind mount.
+type Mount struct {
+	Type     string `json:",omitempty"`
+	Source   string `json:",omitempty"`
+	Target   string `json:",omitempty"`
+	ReadOnly bool   `json:",omitempty"`
+}
+
+// HealthConfig configures the container healthcheck. Durations are in
+// nanoseconds as in the Engine API.
+type HealthConfig struct {
+	Test        []string      `json:",omitempty"`
+	Interval    time.Duration `json:",omitempty"`
+	Timeout     time.Duration `json:",omitempty"`
+	StartPeriod time.Duration `json:",omitempty"`
+	Retries     int           `json:",omitempty"`
+}
+
+// FileTarget is where a config or secret is mounted in the container.
+type FileTarget struct {
+	Name string
+	UID  string
+	GID  string
+	Mode os.FileMode
+}
+
+// ConfigReference attaches a swarm config to a container.
+type ConfigReference struct {
+	File       *FileTarget `json:",omitempty"`
+	ConfigID   string
+	ConfigName string
+}
+
+// SecretReference attaches a swarm secret to a container.
+type SecretReference struct {
+	File       *FileTarget `json:",omitempty"`
+	SecretID   string
+	SecretName string
+}
+
+// ResourceRequirements holds limits and reservations.
+type ResourceRequirements struct {
+	Limits       *Resources `json:",omitempty"`
+	Reservations *Resources `json:",omitempty"`
+}
+
+// Resources is an amount of CPU (in 1e-9 CPUs) and memory (in bytes).
+type Resources struct {
+	NanoCPUs    int64 `json:",omitempty"`
+	MemoryBytes int64 `json:",omitempty"`
+}
+
+// RestartPolicy controls task restarts.
+type RestartPolicy struct {
+	Condition   string         `json:",omitempty"`
+	Delay       *time.Duration `json:",omitempty"`
+	MaxAttempts *uint64        `json:",omitempty"`
+	Window      *time.Duration `json:",omitempty"`
+}
+
+// Placement constrains task scheduling.
+type Placement struct {
+	Constraints []string              `json:",omitempty"`
+	Preferences []PlacementPreference `json:",omitempty"`
+	MaxReplicas uint64                `json:",omitempty"`
+}
+
+// PlacementPreference spreads tasks over a node attribute.
+type PlacementPreference struct {
+	Spread *SpreadOver `json:",omitempty"`
+}
+
+// SpreadOver names the attribute to spread over, e.g. node.labels.zone.
+type SpreadOver struct {
+	SpreadDescriptor string
+}
+
+// NetworkAttachmentConfig attaches a service to a network.
+type NetworkAttachmentConfig struct {
+	Target  string
+	Aliases []string `json:",omitempty"`
+}
+
+// ServiceMode is either replicated or global.
+type ServiceMode struct {
+	Replicated *ReplicatedService `json:",omitempty"`
+	Global     *GlobalService     `json:",omitempty"`
+}
+
+// ReplicatedService runs a fixed number of tasks.
+type ReplicatedService struct {
+	Replicas *uint64 `json:",omitempty"`
+}
+
+// GlobalService runs one task per node.
+type GlobalService struct{}
+
+// UpdateConfig controls rolling updates and rollbacks.
+type UpdateConfig struct {
+	Parallelism     uint64
+	Delay           time.Duration `json:",omitempty"`
+	FailureAction   string        `json:",omitempty"`
+	Monitor         time.Duration `json:",omitempty"`
+	MaxFailureRatio float32       `json:",omitempty"`
+	Order           string        `json:",omitempty"`
+}
+
+// EndpointSpec configures service discovery and published ports.
+type EndpointSpec struct {
+	Mode  string       `json:",omitempty"`
+	Ports []PortConfig `json:",omitempty"`
+}
+
+// PortConfig is a published port.
+type PortConfig struct {
+	Name          string `json:",omitempty"`
+	Protocol      string `json:",omitempty"`
+	TargetPort    uint32 `json:",omitempty"`
+	PublishedPort uint32 `json:",omitempty"`
+	PublishMode   string `json:",omitempty"`
+}
+
+// Network is a docker network.
+type Network struct {
+	ID         string `json:"Id"`
+	Name       string
+	Driver     string
+	Scope      string
+	Attachable bool
+	Internal   bool
+	Labels     map[string]string
+	Options    map[string]string
+}
+
+// SwarmConfig is a swarm config object.
+type SwarmConfig struct {
+	ID        string
+	Version   Version
+	CreatedAt time.Time
+	Spec      ObjectSpec
+}
+
+// SwarmSecret is a swarm secret object. The API never returns secret data.
+type SwarmSecret struct {
+	ID        string
+	Version   Version
+	CreatedAt time.Time
+	Spec      ObjectSpec
+}
+
+// ObjectSpec is the spec shared by configs and secrets. Data is base64
+// encoded on the wire, which encoding/json does for []byte.
+type ObjectSpec struct {
+	Name   string
+	Labels map[string]string `json:",omitempty"`
+	Data   []byte            `json:",omitempty"`
+}
+
+// Task is a single scheduled instance of a service.
+type Task struct {
+	ID           string
+	ServiceID    string
+	NodeID       string `json:",omitempty"`
+	Slot         int    `json:",omitempty"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Status       TaskStatus
+	DesiredState string
+	// Spec is the task template of the service spec the task was created
+	// from.
+	Spec TaskSpec
+}
+
+// TaskStatus is the observed state of a task.
+type TaskStatus struct {
+	Timestamp time.Time
+	State     string
+	Message   string `json:",omitempty"`
+	Err       string `json:",omitempty"`
+}
+
+// Node is a swarm node.
+type Node struct {
+	ID          string
+	Spec        NodeSpec
+	Description NodeDescription
+	Status      NodeStatus
+}
+
+// NodeSpec holds the user-assigned attributes of a node.
+type NodeSpec struct {
+	Labels       map[string]string `json:",omitempty"`
+	Role         string
+	Availability string
+}
+
+// NodeDescription holds the attributes a node reports about itself.
+type NodeDescription struct {
+	Hostname string
+	// Platform is the OS and architecture of the node as the engine
+	// reports them, e.g. linux and x86_64.
+	Platform struct {
+		Architecture string
+		OS           string
+	}
+	// Resources are the CPUs, in billionths, and the memory of the node.
+	Resources struct {
+		NanoCPUs    int64
+		MemoryBytes int64
+	}
+	Engine struct {
+		EngineVersion string
+		Labels        map[string]string `json:",omitempty"`
+	}
+}
+
+// NodeStatus is the reachability of a node.
+type NodeStatus struct {
+	State string
+}
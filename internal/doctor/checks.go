@@ -0,0 +1,264 @@
+package doctor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/oci"
+	"github.com/acebelowzero/tmpl/internal/source"
+)
+
+const agePrivateKeyPrefix = "AGE-SECRET-KEY-"
+
+// checkSops verifies the sops binary runs and that age keys are available
+// and, when the chart has a .sops.yaml, match one of its recipients.
+func checkSops(ctx context.Context, r *Report, chartDir string) {
+	if _, err := exec.LookPath("sops"); err != nil {
+		r.add(Check{Name: "sops", Status: StatusFail, Message: "sops binary not found in PATH",
+			Fix: "Install sops from https://github.com/getsops/sops/releases; values referencing .enc files cannot be decrypted without it"})
+		return
+	}
+	out, err := exec.CommandContext(ctx, "sops", "--version").Output()
+	if err != nil {
+		r.add(Check{Name: "sops", Status: StatusFail, Message: fmt.Sprintf("sops --version: %v", err),
+			Fix: "Reinstall sops; the binary in PATH does not run"})
+		return
+	}
+	version, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	r.add(Check{Name: "sops", Status: StatusOK, Message: version})
+
+	keys, origin, err := ageKeys()
+	if err != nil {
+		r.add(Check{Name: "age keys", Status: StatusFail, Message: err.Error(),
+			Fix: "Make the key file readable or point SOPS_AGE_KEY_FILE at a valid age key file"})
+		return
+	}
+	if len(keys) == 0 {
+		r.add(Check{Name: "age keys", Status: StatusWarn, Message: "no age keys found in " + origin,
+			Fix: "Generate one with 'age-keygen -o " + origin + "' or set SOPS_AGE_KEY_FILE; ignore this if secrets are encrypted with PGP or a cloud KMS"})
+		return
+	}
+	if _, err := exec.LookPath("age-keygen"); err != nil {
+		r.add(Check{Name: "age keys", Status: StatusOK, Message: fmt.Sprintf("%d key(s) in %s, not verified: age-keygen not found", len(keys), origin)})
+		return
+	}
+	recipients := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		cmd := exec.CommandContext(ctx, "age-keygen", "-y")
+		cmd.Stdin = strings.NewReader(key + "\n")
+		out, err := cmd.Output()
+		if err != nil {
+			r.add(Check{Name: "age keys", Status: StatusFail, Message: fmt.Sprintf("key %d in %s is invalid: %v", i+1, origin, err),
+				Fix: "Replace the damaged key with the original from your secret store"})
+			return
+		}
+		recipients[strings.TrimSpace(string(out))] = true
+	}
+	r.add(Check{Name: "age keys", Status: StatusOK, Message: fmt.Sprintf("%d usable key(s) in %s", len(keys), origin)})
+
+	if chartDir == "" {
+		return
+	}
+	wanted, err := sopsRecipients(filepath.Join(chartDir, ".sops.yaml"))
+	switch {
+	case errors.Is(err, fs.ErrNotExist) || (err == nil && len(wanted) == 0):
+	case err != nil:
+		r.add(Check{Name: "sops recipients", Status: StatusFail, Message: err.Error(),
+			Fix: "Fix the syntax of the chart's .sops.yaml"})
+	default:
+		for _, w := range wanted {
+			if recipients[w] {
+				r.add(Check{Name: "sops recipients", Status: StatusOK, Message: "an age key matches a recipient of .sops.yaml"})
+				return
+			}
+		}
+		r.add(Check{Name: "sops recipients", Status: StatusFail,
+			Message: fmt.Sprintf("none of the age keys matches the %d recipient(s) of .sops.yaml", len(wanted)),
+			Fix:     "Ask a maintainer to add your public key to .sops.yaml and run 'sops updatekeys' on the encrypted files"})
+	}
+}
+
+// ageKeys returns the age secret keys sops would use: $SOPS_AGE_KEY, then
+// $SOPS_AGE_KEY_FILE, then sops/age/keys.txt in the user config dir, and a
+// description of where they were read from.
+func ageKeys() ([]string, string, error) {
+	if v := os.Getenv("SOPS_AGE_KEY"); v != "" {
+		return parseAgeKeys([]byte(v)), "$SOPS_AGE_KEY", nil
+	}
+	path := os.Getenv("SOPS_AGE_KEY_FILE")
+	if path == "" {
+		dir := os.Getenv("XDG_CONFIG_HOME")
+		if dir == "" {
+			var err error
+			if dir, err = os.UserConfigDir(); err != nil {
+				return nil, "", fmt.Errorf("resolve user config dir: %w", err)
+			}
+		}
+		path = filepath.Join(dir, "sops", "age", "keys.txt")
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, path, nil
+	}
+	if err != nil {
+		return nil, path, fmt.Errorf("read age keys: %w", err)
+	}
+	return parseAgeKeys(data), path, nil
+}
+
+func parseAgeKeys(data []byte) []string {
+	var keys []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, agePrivateKeyPrefix) {
+			keys = append(keys, line)
+		}
+	}
+	return keys
+}
+
+// sopsRecipients returns the age recipients of all creation rules of a
+// .sops.yaml file. Rules list them as a comma-separated string or a list.
+func sopsRecipients(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		CreationRules []struct {
+			Age any `yaml:"age"`
+		} `yaml:"creation_rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	var out []string
+	for _, rule := range file.CreationRules {
+		var items []string
+		switch v := rule.Age.(type) {
+		case string:
+			items = strings.Split(v, ",")
+		case []any:
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+		}
+		for _, item := range items {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+	}
+	return out, nil
+}
+
+// checkDocker verifies the engine answers and is a swarm manager.
+func checkDocker(ctx context.Context, r *Report, connect func() (*docker.Client, error)) {
+	client, err := connect()
+	if err != nil {
+		r.add(Check{Name: "docker", Status: StatusFail, Message: err.Error(),
+			Fix: "Set DOCKER_HOST, or select an existing context with --context or 'docker context use'"})
+		return
+	}
+	if err := client.Ping(ctx); err != nil {
+		r.add(Check{Name: "docker", Status: StatusFail, Message: fmt.Sprintf("engine at %s is unreachable: %v", client.Host(), err),
+			Fix: "Start the Docker daemon, or check --host, DOCKER_HOST and the TLS settings"})
+		return
+	}
+	info, err := client.Info(ctx)
+	if err != nil {
+		r.add(Check{Name: "docker", Status: StatusFail, Message: fmt.Sprintf("engine info: %v", err),
+			Fix: "Check that the user may access the Docker API, e.g. membership of the docker group"})
+		return
+	}
+	r.add(Check{Name: "docker", Status: StatusOK, Message: fmt.Sprintf("Docker %s at %s", info.ServerVersion, client.Host())})
+
+	switch {
+	case !info.Swarm.Active():
+		state := info.Swarm.LocalNodeState
+		if state == "" {
+			state = "inactive"
+		}
+		r.add(Check{Name: "swarm", Status: StatusFail, Message: "swarm mode is not active (state: " + state + ")",
+			Fix: "Run 'docker swarm init', or join this engine to a swarm with 'docker swarm join'"})
+	case !info.Swarm.ControlAvailable:
+		r.add(Check{Name: "swarm", Status: StatusFail, Message: "engine is a swarm worker; stacks are deployed through managers",
+			Fix: "Point --context or --host at a manager node"})
+	default:
+		r.add(Check{Name: "swarm", Status: StatusOK, Message: "manager node " + info.Swarm.NodeID})
+	}
+}
+
+// checkRegistry verifies the registry of ref is reachable and accepts the
+// stored credentials.
+func checkRegistry(ctx context.Context, r *Report, name, ref string) {
+	check := Check{Name: "registry " + name}
+	host, _, _ := strings.Cut(strings.TrimPrefix(ref, oci.Scheme), "/")
+	authenticated, err := oci.Ping(ctx, ref)
+	switch {
+	case err != nil && oci.IsUnauthorized(err):
+		check.Status = StatusFail
+		check.Message = err.Error()
+		check.Fix = fmt.Sprintf("Run 'docker login %s' to store valid credentials", host)
+	case err != nil:
+		check.Status = StatusFail
+		check.Message = err.Error()
+		check.Fix = fmt.Sprintf("Check network access to %s and the registries.%s setting", host, name)
+	case !authenticated:
+		check.Status = StatusWarn
+		check.Message = fmt.Sprintf("%s is reachable but no credentials are stored; only public charts can be pulled", host)
+		check.Fix = fmt.Sprintf("Run 'docker login %s' to push or pull private charts", host)
+	default:
+		check.Status = StatusOK
+		check.Message = fmt.Sprintf("credentials for %s accepted", host)
+	}
+	r.add(check)
+}
+
+// checkSource fetches a remote values file.
+func checkSource(ctx context.Context, r *Report, raw string) {
+	check := Check{Name: "source " + raw}
+	scheme := source.ParseScheme(raw)
+	if scheme == source.SchemeLocal {
+		return
+	}
+	src, err := source.NewFactory().New(raw)
+	if err == nil {
+		var data []byte
+		if data, err = src.Fetch(ctx); err == nil {
+			check.Status = StatusOK
+			check.Message = fmt.Sprintf("fetched %d bytes", len(data))
+			if rev, ok := src.(source.Revisioner); ok && rev.Revision() != "" {
+				check.Message += " at " + rev.Revision()
+			}
+			r.add(check)
+			return
+		}
+	}
+	check.Status = StatusFail
+	check.Message = err.Error()
+	switch scheme {
+	case source.SchemeGit:
+		check.Fix = "Check the repository URL and ref; set TMPL_GIT_USERNAME and TMPL_GIT_PASSWORD for private repositories"
+	case source.SchemeS3:
+		check.Fix = "Check the AWS credentials (AWS_PROFILE or AWS_ACCESS_KEY_ID), AWS_REGION and the bucket policy"
+	case source.SchemeOCI:
+		host, _, _ := strings.Cut(strings.TrimPrefix(raw, oci.Scheme), "/")
+		check.Fix = fmt.Sprintf("Check the reference exists and run 'docker login %s' for private repositories", host)
+	case source.SchemeHTTP:
+		check.Fix = "Check the URL; set TMPL_HTTP_TOKEN when the server requires a bearer token"
+	}
+	r.add(check)
+}
@@ -0,0 +1,104 @@
+package doctor
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+const (
+	StatusOK   Status = "ok"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+)
+
+// DefaultTimeout bounds each check that talks to a remote service.
+const DefaultTimeout = 30 * time.Second
+
+// Check is the result of a single diagnostic.
+type Check struct {
+	// Name groups checks by area, e.g. "docker" or "registry acme".
+	Name    string `json:"name"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	// Fix suggests how to resolve a check that did not pass.
+	Fix string `json:"fix,omitempty"`
+}
+
+// Report lists the checks in the order they ran.
+type Report struct {
+	Checks []Check `json:"checks"`
+}
+
+// Failed reports whether any check failed.
+func (r *Report) Failed() bool {
+	return slices.ContainsFunc(r.Checks, func(c Check) bool { return c.Status == StatusFail })
+}
+
+// Count returns the number of checks with status s.
+func (r *Report) Count(s Status) int {
+	n := 0
+	for _, c := range r.Checks {
+		if c.Status == s {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Report) add(c Check) {
+	r.Checks = append(r.Checks, c)
+}
+
+// Config selects what Run verifies.
+type Config struct {
+	// ChartDir is the chart whose .sops.yaml recipients are matched
+	// against the available age keys. Empty skips the match.
+	ChartDir string
+	// Docker connects to the engine stacks are deployed to. Nil skips
+	// the docker checks.
+	Docker func() (*docker.Client, error)
+	// Registries maps configured registry names to OCI references.
+	Registries map[string]string
+	// Sources are remote values files the chart is rendered with.
+	Sources []string
+	// Timeout bounds each remote check; zero uses DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Run performs every diagnostic and returns the report. Failures of the
+// environment are recorded as checks rather than returned as errors.
+func Run(ctx context.Context, cfg Config) *Report {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	r := &Report{}
+	checkSops(ctx, r, cfg.ChartDir)
+	if cfg.Docker != nil {
+		withTimeout(ctx, cfg.Timeout, func(ctx context.Context) { checkDocker(ctx, r, cfg.Docker) })
+	}
+	names := make([]string, 0, len(cfg.Registries))
+	for name := range cfg.Registries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		withTimeout(ctx, cfg.Timeout, func(ctx context.Context) { checkRegistry(ctx, r, name, cfg.Registries[name]) })
+	}
+	for _, src := range cfg.Sources {
+		withTimeout(ctx, cfg.Timeout, func(ctx context.Context) { checkSource(ctx, r, src) })
+	}
+	return r
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration, fn func(context.Context)) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	fn(ctx)
+}
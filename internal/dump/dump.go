@@ -0,0 +1,215 @@
+// Package dump keeps the intermediate artifacts of a render, the merged
+// values, the expanded environment and the output of every template, and
+// writes them to a directory users can attach to bug reports.
+package dump
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Dir is the directory artifacts are written to, relative to the working
+// directory.
+const Dir = ".tmpl-debug"
+
+// ManifestFile names the manifest in Dir.
+const ManifestFile = "manifest.json"
+
+// Kinds of artifact files.
+const (
+	KindValues   = "values"
+	KindEnv      = "env"
+	KindTemplate = "template"
+)
+
+// Manifest describes a written dump.
+type Manifest struct {
+	TmplVersion string    `json:"tmplVersion"`
+	Command     []string  `json:"command"`
+	Created     time.Time `json:"created"`
+	Chart       string    `json:"chart,omitempty"`
+	// ValuesSources lists the values files merged, in merge order.
+	ValuesSources []string `json:"valuesSources,omitempty"`
+	// Error is the error the command failed with.
+	Error string `json:"error,omitempty"`
+	Files []File `json:"files"`
+}
+
+// File is an artifact of a dump.
+type File struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+	// Template names the template a KindTemplate file is the output of.
+	Template string `json:"template,omitempty"`
+	// Failed marks the output of a template that failed to execute or
+	// rendered invalid YAML.
+	Failed bool `json:"failed,omitempty"`
+}
+
+// output is the recorded output of a template.
+type output struct {
+	name   string
+	data   []byte
+	failed bool
+}
+
+// Recorder collects the artifacts of the last render of a command. It is
+// safe for concurrent use; a nil Recorder records nothing.
+type Recorder struct {
+	mu        sync.Mutex
+	chart     string
+	values    map[string]any
+	sources   []string
+	env       map[string]string
+	templates []output
+}
+
+// New returns an empty Recorder.
+func New() *Recorder {
+	return &Recorder{}
+}
+
+// Values records the values of a render of chart, merged from sources,
+// and the environment variables expanded in them. It starts a new render,
+// dropping the templates recorded for an earlier one.
+func (r *Recorder) Values(chart string, values map[string]any, sources []string, env map[string]string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.chart, r.values, r.sources, r.env = chart, values, sources, env
+	r.templates = nil
+}
+
+// Template records the output of the named template, which failed when
+// failed is set. Templates generated from front matter are recorded once
+// per item, under a name that includes the item key.
+func (r *Recorder) Template(name string, data []byte, failed bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.templates = append(r.templates, output{name: name, data: append([]byte(nil), data...), failed: failed})
+}
+
+// Empty reports whether nothing was recorded.
+func (r *Recorder) Empty() bool {
+	if r == nil {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.chart == "" && len(r.templates) == 0
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._/-]+`)
+
+// Write replaces the contents of dir with the recorded artifacts and a
+// manifest of them, describing the command line, tmpl version and the
+// error the command failed with, if any.
+func (r *Recorder) Write(dir, version string, command []string, cmdErr error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Only remove what looks like an earlier dump.
+	if _, err := os.Stat(filepath.Join(dir, ManifestFile)); err == nil {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	// Values and the environment may hold secrets.
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	m := Manifest{
+		TmplVersion:   version,
+		Command:       command,
+		Created:       time.Now().UTC(),
+		Chart:         r.chart,
+		ValuesSources: r.sources,
+	}
+	if cmdErr != nil {
+		m.Error = cmdErr.Error()
+	}
+	write := func(f File, data []byte) error {
+		target := filepath.Join(dir, filepath.FromSlash(f.Path))
+		if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, data, 0o600); err != nil {
+			return err
+		}
+		m.Files = append(m.Files, f)
+		return nil
+	}
+
+	if r.values != nil {
+		data, err := yaml.Marshal(r.values)
+		if err != nil {
+			return fmt.Errorf("encode values: %w", err)
+		}
+		if err := write(File{Path: "values.yaml", Kind: KindValues}, data); err != nil {
+			return err
+		}
+	}
+	if r.env != nil {
+		names := make([]string, 0, len(r.env))
+		for name := range r.env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var b strings.Builder
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s=%s\n", name, r.env[name])
+		}
+		if err := write(File{Path: "env", Kind: KindEnv}, []byte(b.String())); err != nil {
+			return err
+		}
+	}
+	seen := map[string]int{}
+	for _, t := range r.templates {
+		p := "rendered" + path.Clean("/"+unsafeChars.ReplaceAllString(t.name, "_"))
+		if n := seen[p]; n > 0 {
+			seen[p]++
+			p = fmt.Sprintf("%s.%d", p, n)
+		} else {
+			seen[p] = 1
+		}
+		if err := write(File{Path: p, Kind: KindTemplate, Template: t.name, Failed: t.failed}, t.data); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ManifestFile), append(data, '\n'), 0o600)
+}
+
+type ctxKey struct{}
+
+// WithContext attaches the recorder to the context for downstream retrieval.
+func WithContext(ctx context.Context, r *Recorder) context.Context {
+	return context.WithValue(ctx, ctxKey{}, r)
+}
+
+// FromContext returns the recorder of the context, or nil.
+func FromContext(ctx context.Context) *Recorder {
+	r, _ := ctx.Value(ctxKey{}).(*Recorder)
+	return r
+}
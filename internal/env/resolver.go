@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"path"
 	"regexp"
 	"strings"
 )
@@ -13,23 +14,35 @@ var envPattern = regexp.MustCompile(`\$\{([A-Z0-9_]+)\}`)
 // Config controls environment variable expansion behaviour.
 type Config struct {
 	Files []string
+	// Vars are further variables, taking precedence over the files.
+	Vars map[string]string
+	// Isolated leaves the process environment out, so only Files and
+	// Vars are expanded.
+	Isolated bool
+	// Allow restricts the process environment to the variables matching
+	// one of these path.Match patterns. Empty allows all variables.
+	Allow []string
 }
 
 // Resolver expands ${VAR} references using process env or provided env files.
 type Resolver struct {
 	cfg Config
 	env map[string]string
+	// expanded records the variables Expand substituted.
+	expanded map[string]string
 }
 
 // NewResolver builds a Resolver and eagerly loads .env style files.
 func NewResolver(cfg Config) (*Resolver, error) {
 	envMap := make(map[string]string)
-	for _, kv := range os.Environ() {
-		parts := strings.SplitN(kv, "=", 2)
-		if len(parts) != 2 {
-			continue
+	if !cfg.Isolated {
+		for _, kv := range os.Environ() {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 || !allowed(cfg.Allow, parts[0]) {
+				continue
+			}
+			envMap[parts[0]] = parts[1]
 		}
-		envMap[parts[0]] = parts[1]
 	}
 
 	for _, file := range cfg.Files {
@@ -37,8 +50,23 @@ func NewResolver(cfg Config) (*Resolver, error) {
 			return nil, fmt.Errorf("load env file %s: %w", file, err)
 		}
 	}
+	for k, v := range cfg.Vars {
+		envMap[k] = v
+	}
+
+	return &Resolver{cfg: cfg, env: envMap, expanded: map[string]string{}}, nil
+}
 
-	return &Resolver{cfg: cfg, env: envMap}, nil
+func allowed(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
 }
 
 // Expand replaces ${VAR} occurrences with corresponding values.
@@ -50,12 +78,18 @@ func (r *Resolver) Expand(data []byte) ([]byte, error) {
 		}
 		key := string(groups[1])
 		if val, ok := r.env[key]; ok {
+			r.expanded[key] = val
 			return []byte(val)
 		}
 		return match
 	}), nil
 }
 
+// Expanded returns the variables substituted by Expand so far, by name.
+func (r *Resolver) Expanded() map[string]string {
+	return r.expanded
+}
+
 func loadEnvFile(target map[string]string, path string) error {
 	f, err := os.Open(path)
 	if err != nil {
@@ -67,7 +101,12 @@ func loadEnvFile(target map[string]string, path string) error {
 	lineNo := 0
 	for scanner.Scan() {
 		lineNo++
-		line := scanner.Text()
+		// Files saved on Windows may start with a byte order mark and
+		// end their lines with CRLF.
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if lineNo == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
 		if idx := strings.Index(line, "#"); idx >= 0 {
 			line = line[:idx]
 		}
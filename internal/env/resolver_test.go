@@ -0,0 +1,28 @@
+package env
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadEnvFileCRLF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prod.env")
+	content := "\ufeffHOST=db.internal\r\n# comment\r\n\r\nPORT = 5432 # trailing\r\nEMPTY=\r\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	if err := loadEnvFile(got, path); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"HOST": "db.internal", "PORT": "5432", "EMPTY": ""}
+	if len(got) != len(want) {
+		t.Fatalf("loaded %q, want %q", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package graph
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Kind is the kind of part of a chart or stack a node stands for.
+type Kind string
+
+const (
+	KindRelease  Kind = "release"
+	KindTemplate Kind = "template"
+	KindDefine   Kind = "define"
+	KindService  Kind = "service"
+	KindNetwork  Kind = "network"
+	KindVolume   Kind = "volume"
+	KindConfig   Kind = "config"
+	KindSecret   Kind = "secret"
+)
+
+// Node is a vertex of the graph, identified by its kind and name.
+type Node struct {
+	Kind  Kind   `json:"kind"`
+	Name  string `json:"name"`
+	Label string `json:"label,omitempty"`
+}
+
+// ID returns the identifier of the node within its graph.
+func (n Node) ID() string {
+	return string(n.Kind) + "/" + n.Name
+}
+
+// Edge is a directed edge between the nodes with the IDs From and To.
+type Edge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Label string `json:"label,omitempty"`
+}
+
+// Graph is a directed graph of the parts of a chart and its stack.
+type Graph struct {
+	Name  string `json:"name"`
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+
+	ids   map[string]bool
+	edges map[Edge]bool
+}
+
+// New returns an empty graph called name.
+func New(name string) *Graph {
+	return &Graph{Name: name, Nodes: []Node{}, Edges: []Edge{}, ids: map[string]bool{}, edges: map[Edge]bool{}}
+}
+
+// AddNode adds n unless a node with its ID exists, and returns the ID.
+func (g *Graph) AddNode(n Node) string {
+	id := n.ID()
+	if !g.ids[id] {
+		g.ids[id] = true
+		g.Nodes = append(g.Nodes, n)
+	}
+	return id
+}
+
+// AddEdge adds an edge between two nodes, once.
+func (g *Graph) AddEdge(from, to, label string) {
+	e := Edge{From: from, To: to, Label: label}
+	if !g.edges[e] {
+		g.edges[e] = true
+		g.Edges = append(g.Edges, e)
+	}
+}
+
+// Sort orders nodes by kind and name and edges by their ends, so output
+// is stable. The Write methods sort the graph themselves.
+func (g *Graph) Sort() {
+	sort.SliceStable(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID() < g.Nodes[j].ID() })
+	sort.SliceStable(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+}
+
+func (n Node) label() string {
+	if n.Label != "" {
+		return n.Label
+	}
+	return n.Name
+}
+
+// dotShapes draws each kind of node differently.
+var dotShapes = map[Kind]string{
+	KindRelease:  "box3d",
+	KindTemplate: "note",
+	KindDefine:   "component",
+	KindService:  "box",
+	KindNetwork:  "ellipse",
+	KindVolume:   "cylinder",
+	KindConfig:   "folder",
+	KindSecret:   "octagon",
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	g.Sort()
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(g.Name))
+	b.WriteString("  rankdir=LR;\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "  %s [label=%s, shape=%s];\n", dotQuote(n.ID()), dotQuote(n.label()), dotShapes[n.Kind])
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "  %s -> %s", dotQuote(e.From), dotQuote(e.To))
+		if e.Label != "" {
+			fmt.Fprintf(&b, " [label=%s]", dotQuote(e.Label))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// mermaidShapes wraps node labels in the bracket pair of their shape.
+var mermaidShapes = map[Kind][2]string{
+	KindRelease:  {"[[", "]]"},
+	KindTemplate: {"[/", "/]"},
+	KindDefine:   {"[\\", "\\]"},
+	KindService:  {"[", "]"},
+	KindNetwork:  {"((", "))"},
+	KindVolume:   {"[(", ")]"},
+	KindConfig:   {">", "]"},
+	KindSecret:   {"{{", "}}"},
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart. Nodes get
+// positional IDs, as Mermaid IDs cannot hold every character of a name.
+func (g *Graph) WriteMermaid(w io.Writer) error {
+	g.Sort()
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	ids := make(map[string]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		id := fmt.Sprintf("n%d", i)
+		ids[n.ID()] = id
+		shape := mermaidShapes[n.Kind]
+		fmt.Fprintf(&b, "  %s%s\"%s: %s\"%s\n", id, shape[0], n.Kind, mermaidEscape(n.label()), shape[1])
+	}
+	for _, e := range g.Edges {
+		if e.Label != "" {
+			fmt.Fprintf(&b, "  %s -->|%s| %s\n", ids[e.From], mermaidEscape(e.Label), ids[e.To])
+			continue
+		}
+		fmt.Fprintf(&b, "  %s --> %s\n", ids[e.From], ids[e.To])
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func mermaidEscape(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "|", "#124;").Replace(s)
+}
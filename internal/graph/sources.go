@@ -0,0 +1,175 @@
+package graph
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template/parse"
+
+	"github.com/acebelowzero/tmpl/internal/compose"
+	"github.com/acebelowzero/tmpl/internal/tmplfile"
+)
+
+// AddTemplates adds the chart files of sources, keyed by chart-relative
+// name, and the named templates they define, with an edge for every
+// include or template call.
+func (g *Graph) AddTemplates(sources map[string]string) error {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type parsedFile struct {
+		name  string
+		trees map[string]*parse.Tree
+	}
+	files := make([]parsedFile, 0, len(names))
+	definedIn := map[string]string{}
+	for _, name := range names {
+		trees := map[string]*parse.Tree{}
+		tree := parse.New(name)
+		tree.Mode = parse.SkipFuncCheck
+		if _, err := tree.Parse(sources[name], "{{", "}}", trees); err != nil {
+			return fmt.Errorf("parse template %s: %w", name, err)
+		}
+		for define := range trees {
+			if define != name {
+				definedIn[define] = name
+			}
+		}
+		files = append(files, parsedFile{name: name, trees: trees})
+	}
+
+	defineNode := func(define string) string {
+		label := define
+		if file, ok := definedIn[define]; ok {
+			label = fmt.Sprintf("%s (%s)", define, path.Base(file))
+		}
+		return g.AddNode(Node{Kind: KindDefine, Name: define, Label: label})
+	}
+	for _, f := range files {
+		for tree, t := range f.trees {
+			node := func() string { return defineNode(tree) }
+			if tree == f.name {
+				node = func() string { return g.AddNode(Node{Kind: KindTemplate, Name: f.name}) }
+				// Helper files are drawn through the templates they
+				// define, unless their top level calls others.
+				if !strings.HasSuffix(f.name, ".tpl") {
+					node()
+				}
+			}
+			walkCalls(t.Root, func(call, target string) {
+				g.AddEdge(node(), defineNode(target), call)
+			})
+		}
+	}
+	return nil
+}
+
+// walkCalls calls fn for every include or template call below node whose
+// target is a string constant.
+func walkCalls(node parse.Node, fn func(call, target string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			walkCalls(c, fn)
+		}
+	case *parse.TemplateNode:
+		fn("template", n.Name)
+		if n.Pipe != nil {
+			walkCalls(n.Pipe, fn)
+		}
+	case *parse.ActionNode:
+		walkCalls(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkCalls(cmd, fn)
+		}
+	case *parse.CommandNode:
+		if len(n.Args) >= 2 {
+			if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && ident.Ident == "include" {
+				if s, ok := n.Args[1].(*parse.StringNode); ok {
+					fn("include", s.Text)
+				}
+			}
+		}
+		for _, arg := range n.Args {
+			walkCalls(arg, fn)
+		}
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, fn)
+	}
+}
+
+func walkBranch(n *parse.BranchNode, fn func(call, target string)) {
+	walkCalls(n.Pipe, fn)
+	walkCalls(n.List, fn)
+	if n.ElseList != nil {
+		walkCalls(n.ElseList, fn)
+	}
+}
+
+// AddStack adds the services of a rendered stack and the networks,
+// volumes, configs and secrets they use or the stack defines.
+func (g *Graph) AddStack(s *compose.Stack) {
+	for name := range s.Networks {
+		g.AddNode(Node{Kind: KindNetwork, Name: name})
+	}
+	for name := range s.Volumes {
+		g.AddNode(Node{Kind: KindVolume, Name: name})
+	}
+	for name := range s.Configs {
+		g.AddNode(Node{Kind: KindConfig, Name: name})
+	}
+	for name := range s.Secrets {
+		g.AddNode(Node{Kind: KindSecret, Name: name})
+	}
+	for name, svc := range s.Services {
+		label := name
+		if svc.Image != "" {
+			label = fmt.Sprintf("%s (%s)", name, svc.Image)
+		}
+		from := g.AddNode(Node{Kind: KindService, Name: name, Label: label})
+		for network := range svc.Networks {
+			g.AddEdge(from, g.AddNode(Node{Kind: KindNetwork, Name: network}), "")
+		}
+		for _, v := range svc.Volumes {
+			// Bind mounts and tmpfs are not stack objects.
+			if v.Type == "volume" && v.Source != "" {
+				g.AddEdge(from, g.AddNode(Node{Kind: KindVolume, Name: v.Source}), v.Target)
+			}
+		}
+		for _, c := range svc.Configs {
+			g.AddEdge(from, g.AddNode(Node{Kind: KindConfig, Name: c.Source}), "")
+		}
+		for _, sec := range svc.Secrets {
+			g.AddEdge(from, g.AddNode(Node{Kind: KindSecret, Name: sec.Source}), "")
+		}
+	}
+}
+
+// AddReleases adds the releases of a tmplfile, labelled with their chart,
+// with an edge from every release to those it needs.
+func (g *Graph) AddReleases(f *tmplfile.File) {
+	for _, r := range f.Releases {
+		g.AddNode(Node{Kind: KindRelease, Name: r.Name, Label: fmt.Sprintf("%s (%s)", r.Name, r.Chart)})
+	}
+	for _, r := range f.Releases {
+		from := Node{Kind: KindRelease, Name: r.Name}.ID()
+		for _, need := range r.Needs {
+			g.AddEdge(from, g.AddNode(Node{Kind: KindRelease, Name: need}), "needs")
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package hook
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/compose"
+)
+
+// Event is a point in a deploy at which hooks run.
+type Event string
+
+const (
+	PreApply     Event = "pre-apply"
+	PostApply    Event = "post-apply"
+	PreRollback  Event = "pre-rollback"
+	PostRollback Event = "post-rollback"
+)
+
+// FailurePolicy decides what a failed hook does to the deploy.
+type FailurePolicy string
+
+const (
+	// Abort stops the deploy. It is the default.
+	Abort FailurePolicy = "abort"
+	// Continue logs the failure and carries on.
+	Continue FailurePolicy = "continue"
+)
+
+// DefaultTimeout bounds hooks that do not set tmpl.hook-timeout.
+const DefaultTimeout = 5 * time.Minute
+
+const (
+	annotationEvents  = "tmpl.hook"
+	annotationTimeout = "tmpl.hook-timeout"
+	annotationFailure = "tmpl.hook-failure"
+)
+
+// Hook is a parsed hook template. Hooks are templates whose leading
+// comment block carries annotations:
+//
+//	# tmpl.hook: pre-apply, pre-rollback
+//	# tmpl.hook-timeout: 10m
+//	# tmpl.hook-failure: abort
+//	service:
+//	  image: example/app:{{ .Chart.AppVersion }}
+//	  command: ["./migrate", "up"]
+//
+// The body declares either a one-shot swarm service, in compose service
+// syntax, or a local command run from the chart directory:
+//
+//	# tmpl.hook: post-apply
+//	command: ["./scripts/warm-cache.sh"]
+//	env:
+//	  URL: https://example.com
+type Hook struct {
+	// Name is the template name, which also orders hooks of an event.
+	Name    string
+	Events  []Event
+	Timeout time.Duration
+	Failure FailurePolicy
+	// Service is the one-shot service to run, nil for local commands.
+	Service *compose.Service
+	// Command and Env describe a local command.
+	Command []string
+	Env     map[string]string
+}
+
+// body is the YAML document of a hook.
+type body struct {
+	Service *compose.Service   `yaml:"service"`
+	Command compose.StringList `yaml:"command"`
+	Env     map[string]string  `yaml:"env"`
+}
+
+// Parse reads the annotations and body of a rendered hook template.
+func Parse(name, src string) (*Hook, error) {
+	h := &Hook{Name: name, Timeout: DefaultTimeout, Failure: Abort}
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		key, value, ok := strings.Cut(strings.TrimSpace(strings.TrimPrefix(line, "#")), ":")
+		if !ok || !strings.HasPrefix(key, annotationEvents) {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case annotationEvents:
+			for _, e := range strings.Split(value, ",") {
+				event := Event(strings.TrimSpace(e))
+				switch event {
+				case PreApply, PostApply, PreRollback, PostRollback:
+					h.Events = append(h.Events, event)
+				default:
+					return nil, fmt.Errorf("hook %s: unknown event %q", name, event)
+				}
+			}
+		case annotationTimeout:
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("hook %s: invalid timeout: %w", name, err)
+			}
+			h.Timeout = d
+		case annotationFailure:
+			switch policy := FailurePolicy(value); policy {
+			case Abort, Continue:
+				h.Failure = policy
+			default:
+				return nil, fmt.Errorf("hook %s: unknown failure policy %q", name, value)
+			}
+		default:
+			return nil, fmt.Errorf("hook %s: unknown annotation %s", name, key)
+		}
+	}
+	if len(h.Events) == 0 {
+		return nil, fmt.Errorf("hook %s: no events declared", name)
+	}
+
+	var b body
+	if err := yaml.Unmarshal([]byte(src), &b); err != nil {
+		return nil, fmt.Errorf("hook %s: %w", name, err)
+	}
+	switch {
+	case b.Service != nil && len(b.Command) > 0:
+		return nil, fmt.Errorf("hook %s: service and command are mutually exclusive", name)
+	case b.Service != nil:
+		if b.Service.Image == "" {
+			return nil, fmt.Errorf("hook %s: service image is required", name)
+		}
+		h.Service = b.Service
+	case len(b.Command) > 0:
+		h.Command, h.Env = b.Command, b.Env
+	default:
+		return nil, fmt.Errorf("hook %s: either service or command is required", name)
+	}
+	return h, nil
+}
+
+// ParseAll parses rendered hook templates keyed by name and returns them
+// ordered by name.
+func ParseAll(sources map[string]string) ([]*Hook, error) {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hooks := make([]*Hook, 0, len(names))
+	for _, name := range names {
+		h, err := Parse(name, sources[name])
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// Has reports whether the hook runs on event.
+func (h *Hook) Has(event Event) bool {
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
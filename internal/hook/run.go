@@ -0,0 +1,143 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/acebelowzero/tmpl/internal/compose"
+	"github.com/acebelowzero/tmpl/internal/deploy"
+	"github.com/acebelowzero/tmpl/internal/logx"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// LabelHook marks the one-shot services started for hooks with their event.
+const LabelHook = "tmpl.hook"
+
+// Config controls how hooks are run.
+type Config struct {
+	// Deployer runs service hooks.
+	Deployer *deploy.Deployer
+	// Stack is the stack the hooks belong to.
+	Stack string
+	// Dir is the working directory of command hooks, usually the chart.
+	Dir string
+	// Stdout and Stderr receive the output of command hooks.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Runner executes the hooks of a release.
+type Runner struct {
+	cfg Config
+}
+
+// New constructs a Runner.
+func New(cfg Config) *Runner {
+	if cfg.Stdout == nil {
+		cfg.Stdout = io.Discard
+	}
+	if cfg.Stderr == nil {
+		cfg.Stderr = io.Discard
+	}
+	return &Runner{cfg: cfg}
+}
+
+// Run executes the hooks registered for event in order. A failing hook
+// with the abort policy stops the run and its error is returned; failures
+// of other hooks are logged.
+func (r *Runner) Run(ctx context.Context, event Event, hooks []*Hook) error {
+	log := logx.FromContext(ctx)
+	for _, h := range hooks {
+		if !h.Has(event) {
+			continue
+		}
+		log.Info("running hook", "hook", h.Name, "event", event)
+		if err := r.run(ctx, event, h); err != nil {
+			if h.Failure == Continue {
+				log.Warn("hook failed", "hook", h.Name, "event", event, "error", err)
+				continue
+			}
+			return fmt.Errorf("%s hook %s: %w", event, h.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) run(ctx context.Context, event Event, h *Hook) error {
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+
+	env := map[string]string{
+		"TMPL_STACK":      r.cfg.Stack,
+		"TMPL_HOOK_EVENT": string(event),
+	}
+	if h.Service != nil {
+		return r.runService(ctx, event, h, env)
+	}
+	return r.runCommand(ctx, h, env)
+}
+
+func (r *Runner) runService(ctx context.Context, event Event, h *Hook, env map[string]string) error {
+	if r.cfg.Deployer == nil {
+		return errors.New("service hooks need a docker engine")
+	}
+	svc := *h.Service
+	svc.Environment = compose.Mapping{}
+	for k, v := range h.Service.Environment {
+		svc.Environment[k] = v
+	}
+	for k, v := range env {
+		svc.Environment[k] = v
+	}
+	key := "hook-" + jobName(h.Name)
+	converted, err := stack.Convert(&compose.Stack{Services: map[string]compose.Service{key: svc}},
+		stack.Options{Name: r.cfg.Stack, BaseDir: r.cfg.Dir})
+	if err != nil {
+		return fmt.Errorf("convert service: %w", err)
+	}
+	spec := converted.Services[r.cfg.Stack+"_"+key]
+	// Without the namespace label a job left behind by a failed removal is
+	// not mistaken for a stack service.
+	delete(spec.Labels, stack.LabelNamespace)
+	spec.Labels[LabelHook] = string(event)
+	return r.cfg.Deployer.RunJob(ctx, spec)
+}
+
+func (r *Runner) runCommand(ctx context.Context, h *Hook, env map[string]string) error {
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Dir = r.cfg.Dir
+	cmd.Stdout, cmd.Stderr = r.cfg.Stdout, r.cfg.Stderr
+	cmd.Env = os.Environ()
+	for k, v := range h.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s", h.Timeout)
+		}
+		return err
+	}
+	return nil
+}
+
+// jobName derives a service name from a hook template name, e.g.
+// templates/db-migrate.tmpl becomes db-migrate.
+func jobName(template string) string {
+	base := strings.TrimSuffix(path.Base(template), path.Ext(template))
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-':
+			return r
+		}
+		return '-'
+	}, base)
+}
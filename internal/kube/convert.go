@@ -0,0 +1,600 @@
+package kube
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/acebelowzero/tmpl/internal/compose"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// Recommended labels set on every object; LabelName and LabelInstance
+// also select the pods of a service.
+const (
+	LabelName      = "app.kubernetes.io/name"
+	LabelInstance  = "app.kubernetes.io/instance"
+	LabelManagedBy = "app.kubernetes.io/managed-by"
+
+	// defaultVolumeSize is the storage requested for named volumes, which
+	// have no size in compose.
+	defaultVolumeSize = "1Gi"
+	// secretsDir is where swarm mounts secrets with a relative target.
+	secretsDir = "/run/secrets"
+)
+
+// Options controls the conversion of a compose stack.
+type Options struct {
+	// Release labels every object with LabelInstance.
+	Release string
+	// Namespace is the namespace of every object; empty leaves it to the
+	// kubectl context.
+	Namespace string
+	// BaseDir resolves relative config and secret file paths.
+	BaseDir string
+	// Redact replaces the content of secrets with a reference to its
+	// digest, as in redacted compose documents.
+	Redact bool
+}
+
+// Convert translates a compose stack into Kubernetes manifests, in the
+// manner of kompose: services become Deployments, or DaemonSets in global
+// mode, with a Service for their ports; configs and secrets become
+// ConfigMaps and Secrets mounted as files; named volumes become
+// PersistentVolumeClaims. What has no Kubernetes equivalent is reported
+// in the returned warnings instead.
+func Convert(src *compose.Stack, opts Options) ([]Manifest, []string, error) {
+	c := &converter{src: src, opts: opts}
+	var out []Manifest
+	for _, name := range sortedKeys(src.Configs) {
+		obj := src.Configs[name]
+		if obj.External {
+			continue
+		}
+		m, err := c.configMap(name, obj)
+		if err != nil {
+			return nil, nil, fmt.Errorf("config %s: %w", name, err)
+		}
+		out = append(out, m)
+	}
+	for _, name := range sortedKeys(src.Secrets) {
+		obj := src.Secrets[name]
+		if obj.External {
+			continue
+		}
+		m, err := c.secret(name, obj)
+		if err != nil {
+			return nil, nil, fmt.Errorf("secret %s: %w", name, err)
+		}
+		out = append(out, m)
+	}
+	for _, name := range sortedKeys(src.Volumes) {
+		if vol := src.Volumes[name]; !vol.External {
+			out = append(out, c.claim(name, vol))
+		}
+	}
+	for _, name := range src.ServiceNames() {
+		manifests, err := c.service(name, src.Services[name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		out = append(out, manifests...)
+	}
+	for _, name := range sortedKeys(src.Networks) {
+		if name != "default" {
+			c.warnf("network %s is not converted, pods of a namespace all reach each other", name)
+		}
+	}
+	return out, c.warnings, nil
+}
+
+type converter struct {
+	src      *compose.Stack
+	opts     Options
+	warnings []string
+}
+
+func (c *converter) warnf(format string, args ...any) {
+	c.warnings = append(c.warnings, fmt.Sprintf(format, args...))
+}
+
+func (c *converter) metadata(name string, labels compose.Mapping) Metadata {
+	meta := Metadata{
+		Name:      name,
+		Namespace: c.opts.Namespace,
+		Labels:    map[string]string{LabelManagedBy: "tmpl"},
+	}
+	if c.opts.Release != "" {
+		meta.Labels[LabelInstance] = c.opts.Release
+	}
+	// Compose labels rarely make valid label values, annotations take any.
+	if len(labels) > 0 {
+		meta.Annotations = map[string]string(labels)
+	}
+	return meta
+}
+
+func (c *converter) selector(service string) map[string]string {
+	labels := map[string]string{LabelName: service}
+	if c.opts.Release != "" {
+		labels[LabelInstance] = c.opts.Release
+	}
+	return labels
+}
+
+// objectName is the name of the ConfigMap, Secret or claim of a compose
+// object, which for external objects must exist already.
+func objectName(name, explicit string) string {
+	if explicit != "" {
+		return dnsLabel(explicit)
+	}
+	return dnsLabel(name)
+}
+
+func (c *converter) configMap(name string, obj compose.Object) (Manifest, error) {
+	data, err := c.content(obj)
+	if err != nil {
+		return Manifest{}, err
+	}
+	m := Manifest{APIVersion: "v1", Kind: "ConfigMap", Metadata: c.metadata(objectName(name, obj.Name), obj.Labels)}
+	key := dataKey(name)
+	if utf8.Valid(data) {
+		m.Data = map[string]string{key: string(data)}
+	} else {
+		m.BinaryData = map[string]string{key: base64.StdEncoding.EncodeToString(data)}
+	}
+	return m, nil
+}
+
+func (c *converter) secret(name string, obj compose.Object) (Manifest, error) {
+	data, err := c.content(obj)
+	if err != nil {
+		return Manifest{}, err
+	}
+	m := Manifest{APIVersion: "v1", Kind: "Secret", Type: "Opaque", Metadata: c.metadata(objectName(name, obj.Name), obj.Labels)}
+	key := dataKey(name)
+	if c.opts.Redact {
+		m.StringData = map[string]string{key: compose.RedactedReference(data)}
+	} else {
+		m.Data = map[string]string{key: base64.StdEncoding.EncodeToString(data)}
+	}
+	return m, nil
+}
+
+func (c *converter) content(obj compose.Object) ([]byte, error) {
+	if obj.File == "" {
+		return []byte(obj.Content), nil
+	}
+	path := obj.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.opts.BaseDir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return data, nil
+}
+
+func (c *converter) claim(name string, vol compose.Volume) Manifest {
+	if vol.Driver != "" && vol.Driver != "local" {
+		c.warnf("volume %s: driver %s is not converted, the claim uses the default storage class", name, vol.Driver)
+	}
+	return Manifest{
+		APIVersion: "v1",
+		Kind:       "PersistentVolumeClaim",
+		Metadata:   c.metadata(objectName(name, vol.Name), vol.Labels),
+		Spec: claimSpec{
+			AccessModes: []string{"ReadWriteOnce"},
+			Resources:   resourceSpec{Requests: map[string]string{"storage": defaultVolumeSize}},
+		},
+	}
+}
+
+func (c *converter) service(name string, svc compose.Service) ([]Manifest, error) {
+	kname := dnsLabel(name)
+	if kname != name {
+		c.warnf("service %s is named %s, other services must use that name to reach it", name, kname)
+	}
+	ctr := container{
+		Name:       kname,
+		Image:      svc.Image,
+		Command:    []string(svc.Entrypoint),
+		Args:       []string(svc.Command),
+		WorkingDir: svc.WorkingDir,
+	}
+	if len(svc.Entrypoint) == 1 {
+		ctr.Command = strings.Fields(svc.Entrypoint[0])
+	}
+	if len(svc.Command) == 1 {
+		ctr.Args = strings.Fields(svc.Command[0])
+	}
+	for _, k := range svc.Environment.Keys() {
+		ctr.Env = append(ctr.Env, envVar{Name: k, Value: svc.Environment[k]})
+	}
+	for _, p := range svc.Ports {
+		ctr.Ports = append(ctr.Ports, portSpec{ContainerPort: p.Target, Protocol: protocol(p.Protocol)})
+	}
+
+	pod := podSpec{Hostname: svc.Hostname}
+	var err error
+	if ctr.Resources, err = c.resources(svc.Deploy.Resources); err != nil {
+		return nil, err
+	}
+	if hc := svc.Healthcheck; hc != nil && !hc.Disable {
+		if ctr.LivenessProbe, err = livenessProbe(hc); err != nil {
+			return nil, err
+		}
+	}
+	if pod.SecurityContext, err = c.security(name, svc); err != nil {
+		return nil, err
+	}
+	pod.NodeSelector = c.nodeSelector(name, svc.Deploy.Placement)
+	if svc.StopSignal != "" {
+		c.warnf("service %s: stop_signal is not converted, containers are stopped with SIGTERM", name)
+	}
+	if rp := svc.Deploy.RestartPolicy; rp != nil && rp.Condition != "" && rp.Condition != "any" {
+		c.warnf("service %s: restart condition %s is not converted, pods of a workload are always restarted", name, rp.Condition)
+	}
+
+	for i, v := range svc.Volumes {
+		vol, mount := c.volume(name, i, v)
+		pod.addVolume(vol)
+		ctr.VolumeMounts = append(ctr.VolumeMounts, mount)
+	}
+	for _, ref := range svc.Configs {
+		target := ref.Target
+		if target == "" {
+			target = "/" + ref.Source
+		}
+		vol := volume{Name: "config-" + dnsLabel(ref.Source), ConfigMap: &objectVolume{Name: objectName(ref.Source, c.src.Configs[ref.Source].Name), DefaultMode: ref.Mode}}
+		pod.addVolume(vol)
+		ctr.VolumeMounts = append(ctr.VolumeMounts, volumeMount{Name: vol.Name, MountPath: target, SubPath: dataKey(ref.Source), ReadOnly: true})
+	}
+	for _, ref := range svc.Secrets {
+		target := ref.Target
+		if target == "" {
+			target = ref.Source
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(secretsDir, target)
+		}
+		vol := volume{Name: "secret-" + dnsLabel(ref.Source), Secret: &objectVolume{SecretName: objectName(ref.Source, c.src.Secrets[ref.Source].Name), DefaultMode: ref.Mode}}
+		pod.addVolume(vol)
+		ctr.VolumeMounts = append(ctr.VolumeMounts, volumeMount{Name: vol.Name, MountPath: target, SubPath: dataKey(ref.Source), ReadOnly: true})
+	}
+	pod.Containers = []container{ctr}
+
+	selector := c.selector(kname)
+	podMeta := Metadata{Labels: selector}
+	if len(svc.Labels) > 0 {
+		podMeta.Annotations = map[string]string(svc.Labels)
+	}
+	spec := workloadSpec{
+		Selector: labelSelector{MatchLabels: selector},
+		Template: podTemplate{Metadata: podMeta, Spec: pod},
+	}
+	workload := Manifest{APIVersion: "apps/v1", Metadata: c.metadata(kname, svc.Deploy.Labels), Spec: &spec}
+	for k, v := range selector {
+		workload.Metadata.Labels[k] = v
+	}
+	switch svc.Deploy.Mode {
+	case "global":
+		workload.Kind = "DaemonSet"
+	case "", "replicated":
+		workload.Kind = "Deployment"
+		replicas := uint64(1)
+		if svc.Deploy.Replicas != nil {
+			replicas = *svc.Deploy.Replicas
+		}
+		spec.Replicas = &replicas
+		spec.Strategy = rollingStrategy(svc.Deploy.UpdateConfig)
+	default:
+		return nil, fmt.Errorf("unsupported deploy mode %q", svc.Deploy.Mode)
+	}
+
+	out := []Manifest{workload}
+	if len(svc.Ports) == 0 {
+		return out, nil
+	}
+	kservice := serviceSpec{Selector: selector}
+	if svc.Deploy.EndpointMode == "dnsrr" {
+		kservice.ClusterIP = "None"
+	}
+	for _, p := range svc.Ports {
+		port := p.Published
+		if port == 0 {
+			port = p.Target
+		} else {
+			c.warnf("service %s: published port %d is only reachable inside the cluster, expose it with an Ingress or a LoadBalancer Service", name, p.Published)
+		}
+		proto := protocol(p.Protocol)
+		kservice.Ports = append(kservice.Ports, portSpec{
+			Name:       strings.ToLower(proto) + "-" + strconv.FormatUint(uint64(port), 10),
+			Port:       port,
+			TargetPort: p.Target,
+			Protocol:   proto,
+		})
+	}
+	out = append(out, Manifest{APIVersion: "v1", Kind: "Service", Metadata: c.metadata(kname, nil), Spec: kservice})
+	return out, nil
+}
+
+// addVolume adds vol unless a volume of the same name, mounted more than
+// once, was added already.
+func (p *podSpec) addVolume(vol volume) {
+	for _, v := range p.Volumes {
+		if v.Name == vol.Name {
+			return
+		}
+	}
+	p.Volumes = append(p.Volumes, vol)
+}
+
+// volume converts the i'th volume of a service: named volumes mount their
+// claim, anonymous volumes and tmpfs an emptyDir and bind mounts of
+// absolute paths a hostPath.
+func (c *converter) volume(service string, i int, v compose.VolumeMount) (volume, volumeMount) {
+	mount := volumeMount{MountPath: v.Target, ReadOnly: v.ReadOnly}
+	vol := volume{}
+	switch {
+	case v.Type == "bind" && path.IsAbs(v.Source):
+		vol.Name = "bind-" + strconv.Itoa(i)
+		vol.HostPath = &hostPath{Path: v.Source}
+		c.warnf("service %s: bind mount of %s becomes a hostPath volume of the node the pod runs on", service, v.Source)
+	case v.Type == "bind":
+		vol.Name = "bind-" + strconv.Itoa(i)
+		vol.EmptyDir = &emptyDir{}
+		c.warnf("service %s: bind mount of relative path %s becomes an empty volume", service, v.Source)
+	case v.Type == "tmpfs":
+		vol.Name = "tmpfs-" + strconv.Itoa(i)
+		vol.EmptyDir = &emptyDir{Medium: "Memory"}
+	case v.Source == "":
+		vol.Name = "volume-" + strconv.Itoa(i)
+		vol.EmptyDir = &emptyDir{}
+	default:
+		vol.Name = dnsLabel(v.Source)
+		vol.PersistentVolumeClaim = &claimVolume{ClaimName: objectName(v.Source, c.src.Volumes[v.Source].Name)}
+	}
+	mount.Name = vol.Name
+	return vol, mount
+}
+
+func (c *converter) resources(r compose.Resources) (*resourceSpec, error) {
+	if r.Limits == nil && r.Reservations == nil {
+		return nil, nil
+	}
+	out := &resourceSpec{}
+	var err error
+	if out.Limits, err = quantities(r.Limits); err != nil {
+		return nil, fmt.Errorf("resource limits: %w", err)
+	}
+	if out.Requests, err = quantities(r.Reservations); err != nil {
+		return nil, fmt.Errorf("resource reservations: %w", err)
+	}
+	return out, nil
+}
+
+func quantities(r *compose.Resource) (map[string]string, error) {
+	if r == nil {
+		return nil, nil
+	}
+	out := map[string]string{}
+	if r.CPUs != "" {
+		if _, err := strconv.ParseFloat(r.CPUs, 64); err != nil {
+			return nil, fmt.Errorf("invalid cpus %q: %w", r.CPUs, err)
+		}
+		out["cpu"] = r.CPUs
+	}
+	if r.Memory != "" {
+		mem, err := stack.ParseBytes(r.Memory)
+		if err != nil {
+			return nil, err
+		}
+		out["memory"] = memoryQuantity(mem)
+	}
+	return out, nil
+}
+
+// memoryQuantity formats bytes with the largest binary suffix that
+// divides them.
+func memoryQuantity(n int64) string {
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"Ti", 1 << 40}, {"Gi", 1 << 30}, {"Mi", 1 << 20}, {"Ki", 1 << 10}} {
+		if n >= unit.size && n%unit.size == 0 {
+			return strconv.FormatInt(n/unit.size, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func livenessProbe(hc *compose.Healthcheck) (*probe, error) {
+	var cmd []string
+	switch {
+	case len(hc.Test) == 1:
+		cmd = []string{"sh", "-c", hc.Test[0]}
+	case len(hc.Test) > 1 && hc.Test[0] == "CMD-SHELL":
+		cmd = []string{"sh", "-c", strings.Join(hc.Test[1:], " ")}
+	case len(hc.Test) > 1 && hc.Test[0] == "CMD":
+		cmd = hc.Test[1:]
+	default:
+		// NONE or no test disables the healthcheck of the image.
+		return nil, nil
+	}
+	p := &probe{Exec: execAction{Command: cmd}}
+	var err error
+	if p.PeriodSeconds, err = seconds(hc.Interval); err != nil {
+		return nil, fmt.Errorf("healthcheck interval: %w", err)
+	}
+	if p.TimeoutSeconds, err = seconds(hc.Timeout); err != nil {
+		return nil, fmt.Errorf("healthcheck timeout: %w", err)
+	}
+	if p.InitialDelaySeconds, err = seconds(hc.StartPeriod); err != nil {
+		return nil, fmt.Errorf("healthcheck start_period: %w", err)
+	}
+	if hc.Retries != nil {
+		p.FailureThreshold = int(*hc.Retries)
+	}
+	return p, nil
+}
+
+// seconds converts a compose duration to whole seconds, rounded up.
+func seconds(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	return int64(math.Ceil(d.Seconds())), nil
+}
+
+// security runs the pod as a numeric user; user names cannot be resolved
+// outside of the image.
+func (c *converter) security(name string, svc compose.Service) (*podSecurity, error) {
+	sec := &podSecurity{}
+	if svc.User != "" {
+		user, group, _ := strings.Cut(svc.User, ":")
+		uid, uerr := strconv.ParseInt(user, 10, 64)
+		if uerr != nil {
+			c.warnf("service %s: user %s is not numeric and is not converted", name, svc.User)
+		} else {
+			sec.RunAsUser = &uid
+			if group != "" {
+				gid, err := strconv.ParseInt(group, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid group of user %q", svc.User)
+				}
+				sec.RunAsGroup = &gid
+			}
+		}
+	}
+	for _, k := range sortedKeys(svc.Sysctls) {
+		sec.Sysctls = append(sec.Sysctls, envVar{Name: k, Value: svc.Sysctls[k]})
+	}
+	if sec.RunAsUser == nil && len(sec.Sysctls) == 0 {
+		return nil, nil
+	}
+	return sec, nil
+}
+
+// nodeSelector converts the equality placement constraints the well-known
+// node labels express; others are reported.
+func (c *converter) nodeSelector(name string, placement compose.Placement) map[string]string {
+	out := map[string]string{}
+	for _, raw := range placement.Constraints {
+		con, err := stack.ParseConstraint(raw)
+		if err != nil || !con.Equal {
+			c.warnf("service %s: placement constraint %s is not converted", name, raw)
+			continue
+		}
+		switch {
+		case strings.HasPrefix(con.Field, "node.labels."):
+			out[strings.TrimPrefix(con.Field, "node.labels.")] = con.Value
+		case con.Field == "node.hostname":
+			out["kubernetes.io/hostname"] = con.Value
+		case con.Field == "node.platform.os":
+			out["kubernetes.io/os"] = con.Value
+		case con.Field == "node.platform.arch":
+			out["kubernetes.io/arch"] = arch(con.Value)
+		default:
+			c.warnf("service %s: placement constraint %s is not converted", name, raw)
+		}
+	}
+	if len(placement.Preferences) > 0 || placement.MaxReplicas > 0 {
+		c.warnf("service %s: placement preferences and max_replicas_per_node are not converted, use topology spread constraints", name)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// arch maps the architectures docker reports to those of Go, which
+// Kubernetes uses.
+func arch(s string) string {
+	switch s {
+	case "x86_64":
+		return "amd64"
+	case "aarch64":
+		return "arm64"
+	}
+	return s
+}
+
+// rollingStrategy converts update_config: stop-first, the swarm default,
+// replaces parallelism pods at a time without surge, start-first surges
+// by parallelism instead. A parallelism of 0 updates all pods at once.
+func rollingStrategy(uc *compose.UpdateConfig) *strategy {
+	if uc == nil {
+		return nil
+	}
+	step := "1"
+	if uc.Parallelism != nil {
+		step = strconv.FormatUint(*uc.Parallelism, 10)
+		if *uc.Parallelism == 0 {
+			step = "100%"
+		}
+	}
+	ru := &rollingUpdate{MaxSurge: "0", MaxUnavailable: step}
+	if uc.Order == "start-first" {
+		ru.MaxSurge, ru.MaxUnavailable = step, "0"
+	}
+	return &strategy{Type: "RollingUpdate", RollingUpdate: ru}
+}
+
+func protocol(p string) string {
+	if p == "" {
+		return "TCP"
+	}
+	return strings.ToUpper(p)
+}
+
+// dnsLabel turns s into a valid object name: lower case alphanumerics and
+// dashes, at most 63 characters.
+func dnsLabel(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	name := b.String()
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.Trim(name, "-")
+}
+
+// dataKey is the key of the content of a config or secret in its
+// ConfigMap or Secret.
+func dataKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' || r == '_' {
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,174 @@
+// Package kube converts compose stacks into Kubernetes manifests, for
+// teams moving charts off swarm. Only the part of the Kubernetes API the
+// conversion produces is modelled.
+package kube
+
+import (
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest is a Kubernetes object.
+type Manifest struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   Metadata          `yaml:"metadata"`
+	Type       string            `yaml:"type,omitempty"`
+	Spec       any               `yaml:"spec,omitempty"`
+	Data       map[string]string `yaml:"data,omitempty"`
+	BinaryData map[string]string `yaml:"binaryData,omitempty"`
+	StringData map[string]string `yaml:"stringData,omitempty"`
+}
+
+// Metadata is the object metadata of a manifest.
+type Metadata struct {
+	Name        string            `yaml:"name,omitempty"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type workloadSpec struct {
+	Replicas *uint64       `yaml:"replicas,omitempty"`
+	Selector labelSelector `yaml:"selector"`
+	Strategy *strategy     `yaml:"strategy,omitempty"`
+	Template podTemplate   `yaml:"template"`
+}
+
+type labelSelector struct {
+	MatchLabels map[string]string `yaml:"matchLabels"`
+}
+
+type strategy struct {
+	Type          string         `yaml:"type"`
+	RollingUpdate *rollingUpdate `yaml:"rollingUpdate,omitempty"`
+}
+
+type rollingUpdate struct {
+	MaxSurge       string `yaml:"maxSurge"`
+	MaxUnavailable string `yaml:"maxUnavailable"`
+}
+
+type podTemplate struct {
+	Metadata Metadata `yaml:"metadata"`
+	Spec     podSpec  `yaml:"spec"`
+}
+
+type podSpec struct {
+	Hostname        string            `yaml:"hostname,omitempty"`
+	NodeSelector    map[string]string `yaml:"nodeSelector,omitempty"`
+	SecurityContext *podSecurity      `yaml:"securityContext,omitempty"`
+	Containers      []container       `yaml:"containers"`
+	Volumes         []volume          `yaml:"volumes,omitempty"`
+}
+
+type podSecurity struct {
+	RunAsUser  *int64   `yaml:"runAsUser,omitempty"`
+	RunAsGroup *int64   `yaml:"runAsGroup,omitempty"`
+	Sysctls    []envVar `yaml:"sysctls,omitempty"`
+}
+
+type container struct {
+	Name          string        `yaml:"name"`
+	Image         string        `yaml:"image"`
+	Command       []string      `yaml:"command,omitempty"`
+	Args          []string      `yaml:"args,omitempty"`
+	WorkingDir    string        `yaml:"workingDir,omitempty"`
+	Env           []envVar      `yaml:"env,omitempty"`
+	Ports         []portSpec    `yaml:"ports,omitempty"`
+	Resources     *resourceSpec `yaml:"resources,omitempty"`
+	VolumeMounts  []volumeMount `yaml:"volumeMounts,omitempty"`
+	LivenessProbe *probe        `yaml:"livenessProbe,omitempty"`
+}
+
+type envVar struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+type portSpec struct {
+	Name          string `yaml:"name,omitempty"`
+	ContainerPort uint32 `yaml:"containerPort,omitempty"`
+	Port          uint32 `yaml:"port,omitempty"`
+	TargetPort    uint32 `yaml:"targetPort,omitempty"`
+	Protocol      string `yaml:"protocol"`
+}
+
+type resourceSpec struct {
+	Limits   map[string]string `yaml:"limits,omitempty"`
+	Requests map[string]string `yaml:"requests,omitempty"`
+}
+
+type volumeMount struct {
+	Name      string `yaml:"name"`
+	MountPath string `yaml:"mountPath"`
+	SubPath   string `yaml:"subPath,omitempty"`
+	ReadOnly  bool   `yaml:"readOnly,omitempty"`
+}
+
+type volume struct {
+	Name                  string        `yaml:"name"`
+	ConfigMap             *objectVolume `yaml:"configMap,omitempty"`
+	Secret                *objectVolume `yaml:"secret,omitempty"`
+	PersistentVolumeClaim *claimVolume  `yaml:"persistentVolumeClaim,omitempty"`
+	HostPath              *hostPath     `yaml:"hostPath,omitempty"`
+	EmptyDir              *emptyDir     `yaml:"emptyDir,omitempty"`
+}
+
+// objectVolume mounts a ConfigMap, named by Name, or a Secret, named by
+// SecretName.
+type objectVolume struct {
+	Name        string  `yaml:"name,omitempty"`
+	SecretName  string  `yaml:"secretName,omitempty"`
+	DefaultMode *uint32 `yaml:"defaultMode,omitempty"`
+}
+
+type claimVolume struct {
+	ClaimName string `yaml:"claimName"`
+}
+
+type hostPath struct {
+	Path string `yaml:"path"`
+}
+
+type emptyDir struct {
+	Medium string `yaml:"medium,omitempty"`
+}
+
+type probe struct {
+	Exec                execAction `yaml:"exec"`
+	InitialDelaySeconds int64      `yaml:"initialDelaySeconds,omitempty"`
+	PeriodSeconds       int64      `yaml:"periodSeconds,omitempty"`
+	TimeoutSeconds      int64      `yaml:"timeoutSeconds,omitempty"`
+	FailureThreshold    int        `yaml:"failureThreshold,omitempty"`
+}
+
+type execAction struct {
+	Command []string `yaml:"command"`
+}
+
+type serviceSpec struct {
+	Type      string            `yaml:"type,omitempty"`
+	ClusterIP string            `yaml:"clusterIP,omitempty"`
+	Selector  map[string]string `yaml:"selector"`
+	Ports     []portSpec        `yaml:"ports"`
+}
+
+type claimSpec struct {
+	AccessModes []string     `yaml:"accessModes"`
+	Resources   resourceSpec `yaml:"resources"`
+}
+
+// Encode writes manifests to w as YAML documents separated by ---.
+func Encode(w io.Writer, manifests []Manifest) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	for _, m := range manifests {
+		if err := enc.Encode(m); err != nil {
+			return fmt.Errorf("encode %s %s: %w", m.Kind, m.Metadata.Name, err)
+		}
+	}
+	return enc.Close()
+}
@@ -0,0 +1,118 @@
+package lint
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/acebelowzero/tmpl/internal/render"
+)
+
+// ValuesFile is the chart's default values file, checked by file rules.
+const ValuesFile = "values.yaml"
+
+// Fixer is implemented by rules whose findings can be corrected by
+// rewriting the file they were found in. Fix returns the corrected content
+// of the chart file name, or data itself when nothing needs fixing. Lines
+// for which skip reports true, because a finding there is suppressed,
+// must be left as they are.
+type Fixer interface {
+	Fix(name string, data []byte, skip func(line int) bool) ([]byte, error)
+}
+
+// Fixed is a chart file rewritten by Fix.
+type Fixed struct {
+	File string `json:"file"`
+	// Rules lists the rules whose findings were corrected.
+	Rules []string `json:"rules"`
+	Data  []byte   `json:"-"`
+}
+
+// ReadChartFiles reads the chart files checked by file rules: values.yaml,
+// the helpers at the chart root and everything under templates/. Keys are
+// chart-relative, slash-separated paths.
+func ReadChartFiles(dir string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	data, err := os.ReadFile(filepath.Join(dir, ValuesFile))
+	switch {
+	case err == nil:
+		files[ValuesFile] = data
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+	helpers, err := filepath.Glob(filepath.Join(dir, "*.tpl"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range helpers {
+		if files[filepath.Base(path)], err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	err = filepath.WalkDir(filepath.Join(dir, "templates"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)], err = os.ReadFile(path)
+		return err
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Fix applies the enabled rules that implement Fixer to files and returns
+// the files that changed, sorted by name. Suppressed findings are not
+// fixed.
+func (l *Linter) Fix(files map[string][]byte) ([]Fixed, error) {
+	suppressions := parseSuppressions(fileLines(files))
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fixed []Fixed
+	for _, name := range names {
+		data := files[name]
+		var rules []string
+		for _, rule := range l.rules {
+			fixer, ok := rule.(Fixer)
+			if !ok || l.severity(rule) == SeverityOff {
+				continue
+			}
+			skip := func(line int) bool {
+				return suppressions.matches(Finding{Rule: rule.Name(), Location: render.Location{File: name, Line: line}})
+			}
+			out, err := fixer.Fix(name, data, skip)
+			if err != nil {
+				return nil, err
+			}
+			if string(out) != string(data) {
+				rules = append(rules, rule.Name())
+				data = out
+			}
+		}
+		if len(rules) > 0 {
+			fixed = append(fixed, Fixed{File: name, Rules: rules, Data: data})
+		}
+	}
+	return fixed, nil
+}
+
+// fileLines splits file contents into lines.
+func fileLines(files map[string][]byte) map[string][]string {
+	out := make(map[string][]string, len(files))
+	for name, data := range files {
+		out[name] = strings.Split(string(data), "\n")
+	}
+	return out
+}
@@ -0,0 +1,296 @@
+package lint
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/render"
+)
+
+// The rules below check the formatting of chart files rather than the
+// rendered stack. They all implement Fixer, so 'tmpl lint --fix' can
+// correct their findings.
+
+// sortedFileNames returns the names of files accepted by keep, sorted.
+func sortedFileNames(files map[string][]byte, keep func(name string) bool) []string {
+	var names []string
+	for name := range files {
+		if keep(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isYAMLSource reports whether name holds YAML, or templates of it.
+func isYAMLSource(name string) bool {
+	for _, ext := range []string{".yaml", ".yml", ".tmpl", ".tpl"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func anyFile(string) bool { return true }
+
+type trailingWhitespaceRule struct{}
+
+func (trailingWhitespaceRule) Name() string { return "trailing-whitespace" }
+
+func (trailingWhitespaceRule) Description() string {
+	return "lines of chart files end with spaces or tabs"
+}
+
+func (trailingWhitespaceRule) DefaultSeverity() Severity { return SeverityInfo }
+
+func (trailingWhitespaceRule) Check(in *Input) []Finding {
+	var findings []Finding
+	for _, name := range sortedFileNames(in.Files, anyFile) {
+		for i, line := range strings.Split(string(in.Files[name]), "\n") {
+			if strings.TrimRight(line, " \t") != line {
+				findings = append(findings, Finding{Message: "trailing whitespace", Location: locationAt(name, i+1)})
+			}
+		}
+	}
+	return findings
+}
+
+func (trailingWhitespaceRule) Fix(name string, data []byte, skip func(int) bool) ([]byte, error) {
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if !skip(i + 1) {
+			lines[i] = strings.TrimRight(line, " \t")
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+type yamlTabsRule struct{}
+
+func (yamlTabsRule) Name() string { return "yaml-tabs" }
+
+func (yamlTabsRule) Description() string {
+	return "YAML files and templates indent lines with tabs, which YAML forbids"
+}
+
+func (yamlTabsRule) DefaultSeverity() Severity { return SeverityWarn }
+
+func (yamlTabsRule) Check(in *Input) []Finding {
+	var findings []Finding
+	for _, name := range sortedFileNames(in.Files, isYAMLSource) {
+		for i, line := range strings.Split(string(in.Files[name]), "\n") {
+			if strings.Contains(indentOf(line), "\t") {
+				findings = append(findings, Finding{Message: "tab in indentation", Location: locationAt(name, i+1)})
+			}
+		}
+	}
+	return findings
+}
+
+// Fix replaces every tab of the indentation with two spaces.
+func (yamlTabsRule) Fix(name string, data []byte, skip func(int) bool) ([]byte, error) {
+	if !isYAMLSource(name) {
+		return data, nil
+	}
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		indent := indentOf(line)
+		if skip(i+1) || !strings.Contains(indent, "\t") {
+			continue
+		}
+		lines[i] = strings.ReplaceAll(indent, "\t", "  ") + line[len(indent):]
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+func indentOf(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+type documentSeparatorRule struct{}
+
+func (documentSeparatorRule) Name() string { return "document-separator" }
+
+func (documentSeparatorRule) Description() string {
+	return "templates rendered into one stack do not start their document with ---, leaving it to the renderer"
+}
+
+func (documentSeparatorRule) DefaultSeverity() Severity { return SeverityInfo }
+
+func (documentSeparatorRule) Check(in *Input) []Finding {
+	names := stackTemplates(in.Files)
+	if len(names) < 2 {
+		return nil
+	}
+	var findings []Finding
+	for _, name := range names {
+		if line := missingSeparator(string(in.Files[name])); line > 0 {
+			findings = append(findings, Finding{
+				Message:  "document does not start with ---",
+				Location: locationAt(name, line),
+			})
+		}
+	}
+	return findings
+}
+
+// Fix inserts --- before the first line of YAML content, so the separator
+// is only rendered along with the content.
+func (documentSeparatorRule) Fix(name string, data []byte, skip func(int) bool) ([]byte, error) {
+	src := string(data)
+	if !render.IsStackTemplate(name, src) {
+		return data, nil
+	}
+	line := missingSeparator(src)
+	if line == 0 || skip(line) {
+		return data, nil
+	}
+	lines := strings.Split(src, "\n")
+	lines = append(lines[:line-1], append([]string{"---"}, lines[line-1:]...)...)
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// stackTemplates returns the templates whose output is joined into the
+// stack.
+func stackTemplates(files map[string][]byte) []string {
+	return sortedFileNames(files, func(name string) bool {
+		return render.IsStackTemplate(name, string(files[name]))
+	})
+}
+
+// missingSeparator returns the line of the first YAML content of src when
+// no --- precedes it, or 0. Blank lines, comments and lines holding only
+// template actions are not content.
+func missingSeparator(src string) int {
+	for i, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "---" || strings.HasPrefix(trimmed, "--- "):
+			return 0
+		case trimmed == "", strings.HasPrefix(trimmed, "#"),
+			strings.HasPrefix(trimmed, "{{") && strings.HasSuffix(trimmed, "}}"):
+			continue
+		default:
+			return i + 1
+		}
+	}
+	return 0
+}
+
+type valuesKeyOrderRule struct{}
+
+func (valuesKeyOrderRule) Name() string { return "values-key-order" }
+
+func (valuesKeyOrderRule) Description() string {
+	return "keys of values.yaml are not sorted alphabetically"
+}
+
+func (valuesKeyOrderRule) DefaultSeverity() Severity { return SeverityInfo }
+
+func (valuesKeyOrderRule) Check(in *Input) []Finding {
+	data, ok := in.Files[ValuesFile]
+	if !ok {
+		return nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	var findings []Finding
+	walkMappings(&doc, "", func(path string, n *yaml.Node) {
+		if key := unsortedKey(n); key != nil {
+			findings = append(findings, Finding{
+				Message:  fmt.Sprintf("key %s%s is out of alphabetical order", path, key.Value),
+				Location: locationAt(ValuesFile, key.Line),
+			})
+		}
+	})
+	return findings
+}
+
+// Fix sorts the keys of every mapping of values.yaml, keeping comments
+// with their keys. The file is re-indented with two spaces. Files whose
+// values would change, e.g. because an alias would precede its anchor, are
+// left as they are.
+func (valuesKeyOrderRule) Fix(name string, data []byte, skip func(int) bool) ([]byte, error) {
+	if name != ValuesFile {
+		return data, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return data, nil
+	}
+	changed := false
+	walkMappings(&doc, "", func(_ string, n *yaml.Node) {
+		key := unsortedKey(n)
+		if key == nil || skip(key.Line) {
+			return
+		}
+		sortMapping(n)
+		changed = true
+	})
+	if !changed {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("encode %s: %w", name, err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	var before, after any
+	if yaml.Unmarshal(data, &before) != nil || yaml.Unmarshal(buf.Bytes(), &after) != nil || !reflect.DeepEqual(before, after) {
+		return data, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// walkMappings calls fn for every mapping node below n with the dotted
+// path of its keys.
+func walkMappings(n *yaml.Node, path string, fn func(path string, n *yaml.Node)) {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, c := range n.Content {
+			walkMappings(c, path, fn)
+		}
+	case yaml.MappingNode:
+		fn(path, n)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			walkMappings(n.Content[i+1], path+n.Content[i].Value+".", fn)
+		}
+	}
+}
+
+// unsortedKey returns the first key of a mapping that sorts before the key
+// preceding it, or nil.
+func unsortedKey(n *yaml.Node) *yaml.Node {
+	for i := 2; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value < n.Content[i-2].Value {
+			return n.Content[i]
+		}
+	}
+	return nil
+}
+
+func sortMapping(n *yaml.Node) {
+	type pair struct{ key, value *yaml.Node }
+	pairs := make([]pair, 0, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		pairs = append(pairs, pair{n.Content[i], n.Content[i+1]})
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].key.Value < pairs[j].key.Value })
+	n.Content = n.Content[:0]
+	for _, p := range pairs {
+		n.Content = append(n.Content, p.key, p.value)
+	}
+}
@@ -0,0 +1,254 @@
+package lint
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/render"
+)
+
+// Severity ranks findings; higher values are more severe.
+type Severity int
+
+const (
+	SeverityOff Severity = iota
+	SeverityInfo
+	SeverityWarn
+	SeverityError
+)
+
+// ParseSeverity converts a textual severity (off, info, warn, error).
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "off", "none":
+		return SeverityOff, nil
+	case "info":
+		return SeverityInfo, nil
+	case "warn", "warning":
+		return SeverityWarn, nil
+	case "error":
+		return SeverityError, nil
+	default:
+		return SeverityOff, fmt.Errorf("unknown severity %q", s)
+	}
+}
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarn:
+		return "warn"
+	case SeverityError:
+		return "error"
+	default:
+		return "off"
+	}
+}
+
+// MarshalText renders the severity by name in JSON and YAML output.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Finding is a single rule violation.
+type Finding struct {
+	Rule     string          `json:"rule"`
+	Severity Severity        `json:"severity"`
+	Message  string          `json:"message"`
+	Location render.Location `json:"location"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s [%s] %s", f.Location, f.Severity, f.Rule, f.Message)
+}
+
+// Input is everything a rule may inspect.
+type Input struct {
+	Values    map[string]any
+	Result    *render.Result
+	Documents []*yaml.Node
+	// Sources maps template names to their source lines.
+	Sources map[string][]string
+	// Files holds the chart files checked by file rules, such as
+	// trailing-whitespace, by chart-relative path; see ReadChartFiles.
+	Files map[string][]byte
+	// Dir is the chart directory, which the files of configs and secrets
+	// are relative to.
+	Dir string
+}
+
+// Locate maps a rendered output line to a template location when possible.
+func (in *Input) Locate(line int) render.Location {
+	if in.Result != nil {
+		if loc, ok := in.Result.SourceMap.Lookup(line); ok {
+			return loc
+		}
+	}
+	return render.Location{File: "<rendered>", Line: line}
+}
+
+// Rule checks one aspect of a chart.
+type Rule interface {
+	Name() string
+	Description() string
+	DefaultSeverity() Severity
+	Check(in *Input) []Finding
+}
+
+// Config controls which rules run and at which severity.
+type Config struct {
+	// Severities overrides the default severity per rule name.
+	Severities map[string]Severity
+}
+
+// Linter runs a set of rules and applies severities and suppressions.
+type Linter struct {
+	cfg   Config
+	rules []Rule
+}
+
+// New constructs a Linter with the built-in rules.
+func New(cfg Config) (*Linter, error) {
+	rules := DefaultRules()
+	known := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		known[r.Name()] = true
+	}
+	for name := range cfg.Severities {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown lint rule %q", name)
+		}
+	}
+	return &Linter{cfg: cfg, rules: rules}, nil
+}
+
+// Rules returns the rules evaluated by the linter.
+func (l *Linter) Rules() []Rule {
+	return l.rules
+}
+
+// Report is the outcome of a lint run.
+type Report struct {
+	// Fixed lists the files rewritten by Fix before the run.
+	Fixed    []Fixed   `json:"fixed,omitempty"`
+	Findings []Finding `json:"findings"`
+	// Suppressed counts findings silenced by inline directives.
+	Suppressed int `json:"suppressed"`
+}
+
+// Max returns the highest severity among the findings.
+func (r *Report) Max() Severity {
+	max := SeverityOff
+	for _, f := range r.Findings {
+		if f.Severity > max {
+			max = f.Severity
+		}
+	}
+	return max
+}
+
+// Count returns the number of findings with the given severity.
+func (r *Report) Count(s Severity) int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Severity == s {
+			n++
+		}
+	}
+	return n
+}
+
+// Run evaluates all enabled rules against the input. Extra findings, such
+// as policy violations, keep their severity but honour suppressions.
+func (l *Linter) Run(in *Input, extra ...Finding) *Report {
+	sources := fileLines(in.Files)
+	for name, lines := range in.Sources {
+		sources[name] = lines
+	}
+	suppressions := parseSuppressions(sources)
+	report := &Report{}
+	for _, f := range extra {
+		if suppressions.matches(f) {
+			report.Suppressed++
+			continue
+		}
+		report.Findings = append(report.Findings, f)
+	}
+	for _, rule := range l.rules {
+		severity := l.severity(rule)
+		if severity == SeverityOff {
+			continue
+		}
+		for _, f := range rule.Check(in) {
+			f.Rule = rule.Name()
+			f.Severity = severity
+			if suppressions.matches(f) {
+				report.Suppressed++
+				continue
+			}
+			report.Findings = append(report.Findings, f)
+		}
+	}
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		a, b := report.Findings[i].Location, report.Findings[j].Location
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return report
+}
+
+// severity returns the configured severity of rule.
+func (l *Linter) severity(rule Rule) Severity {
+	if override, ok := l.cfg.Severities[rule.Name()]; ok {
+		return override
+	}
+	return rule.DefaultSeverity()
+}
+
+func locationAt(file string, line int) render.Location {
+	return render.Location{File: file, Line: line}
+}
+
+// NewInput prepares rule input from merged values and a render result.
+func NewInput(values map[string]any, result *render.Result) (*Input, error) {
+	docs, err := ParseDocuments(result.Output)
+	if err != nil {
+		return nil, err
+	}
+	sources := make(map[string][]string, len(result.Sources))
+	for name, src := range result.Sources {
+		sources[name] = strings.Split(src, "\n")
+	}
+	return &Input{
+		Values:    values,
+		Result:    result,
+		Documents: docs,
+		Sources:   sources,
+	}, nil
+}
+
+// ParseDocuments decodes every YAML document of rendered output.
+func ParseDocuments(data []byte) ([]*yaml.Node, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var docs []*yaml.Node
+	for {
+		doc := &yaml.Node{}
+		err := dec.Decode(doc)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decode rendered output: %w", err)
+		}
+		docs = append(docs, doc)
+	}
+}
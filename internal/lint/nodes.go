@@ -0,0 +1,56 @@
+package lint
+
+import "gopkg.in/yaml.v3"
+
+// service is a compose service located in a rendered document.
+type service struct {
+	name string
+	key  *yaml.Node
+	body *yaml.Node
+}
+
+// services returns the services declared across all rendered documents.
+func services(docs []*yaml.Node) []service {
+	var out []service
+	for _, doc := range docs {
+		svcs := lookup(doc, "services")
+		if svcs == nil || svcs.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 0; i+1 < len(svcs.Content); i += 2 {
+			out = append(out, service{
+				name: svcs.Content[i].Value,
+				key:  svcs.Content[i],
+				body: svcs.Content[i+1],
+			})
+		}
+	}
+	return out
+}
+
+// lookup follows mapping keys from node and returns the value node, or nil.
+func lookup(node *yaml.Node, keys ...string) *yaml.Node {
+	if node != nil && node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	for _, key := range keys {
+		_, node = lookupKey(node, key)
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// lookupKey returns the key and value nodes for key in a mapping node.
+func lookupKey(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,55 @@
+package lint
+
+import (
+	"fmt"
+
+	"github.com/acebelowzero/tmpl/internal/policy"
+	"github.com/acebelowzero/tmpl/internal/render"
+)
+
+// PolicyRuleName is the rule name reported for policy violations.
+const PolicyRuleName = "policy"
+
+// PolicyInput converts lint input into the document evaluated by policies.
+func PolicyInput(in *Input, chart any) (policy.Input, error) {
+	stack := make([]any, 0, len(in.Documents))
+	for _, doc := range in.Documents {
+		var decoded any
+		if err := doc.Decode(&decoded); err != nil {
+			return policy.Input{}, fmt.Errorf("decode rendered document: %w", err)
+		}
+		stack = append(stack, decoded)
+	}
+	return policy.Input{Values: in.Values, Stack: stack, Chart: chart}, nil
+}
+
+// PolicyFindings converts policy violations into findings. Deny rules report
+// errors and warn rules warnings; violations naming a service are located at
+// that service's definition.
+func PolicyFindings(in *Input, violations []policy.Violation) []Finding {
+	findings := make([]Finding, 0, len(violations))
+	for _, v := range violations {
+		severity := SeverityError
+		if v.Level == policy.LevelWarn {
+			severity = SeverityWarn
+		}
+		findings = append(findings, Finding{
+			Rule:     PolicyRuleName,
+			Severity: severity,
+			Message:  v.Message,
+			Location: in.serviceLocation(v.Service),
+		})
+	}
+	return findings
+}
+
+func (in *Input) serviceLocation(name string) render.Location {
+	if name != "" {
+		for _, svc := range services(in.Documents) {
+			if svc.name == name {
+				return in.Locate(svc.key.Line)
+			}
+		}
+	}
+	return render.Location{File: "<policy>"}
+}
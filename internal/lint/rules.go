@@ -0,0 +1,310 @@
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/compose"
+)
+
+// DefaultRules returns the built-in rule set.
+func DefaultRules() []Rule {
+	return []Rule{
+		undefinedValuesRule{},
+		deprecatedKeysRule{},
+		resourceLimitsRule{},
+		latestTagRule{},
+		privilegedRule{},
+		objectSizeRule{},
+		objectNameRule{},
+		trailingWhitespaceRule{},
+		yamlTabsRule{},
+		documentSeparatorRule{},
+		valuesKeyOrderRule{},
+	}
+}
+
+var valuesRefPattern = regexp.MustCompile(`\.Values((?:\.[A-Za-z_][A-Za-z0-9_]*)+)`)
+
+// guardPattern matches constructs that make a values reference optional.
+var guardPattern = regexp.MustCompile(`\b(if|with|default|hasKey|empty|coalesce)\b`)
+
+type undefinedValuesRule struct{}
+
+func (undefinedValuesRule) Name() string { return "undefined-values" }
+
+func (undefinedValuesRule) Description() string {
+	return "templates reference .Values keys that are not defined in the merged values"
+}
+
+func (undefinedValuesRule) DefaultSeverity() Severity { return SeverityWarn }
+
+func (undefinedValuesRule) Check(in *Input) []Finding {
+	var findings []Finding
+	files := make([]string, 0, len(in.Sources))
+	for name := range in.Sources {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		for i, line := range in.Sources[file] {
+			if guardPattern.MatchString(line) {
+				continue
+			}
+			seen := map[string]bool{}
+			for _, match := range valuesRefPattern.FindAllStringSubmatch(line, -1) {
+				path := strings.TrimPrefix(match[1], ".")
+				if seen[path] || valuesHasPath(in.Values, strings.Split(path, ".")) {
+					continue
+				}
+				seen[path] = true
+				findings = append(findings, Finding{
+					Message:  fmt.Sprintf(".Values.%s is not defined", path),
+					Location: locationAt(file, i+1),
+				})
+			}
+		}
+	}
+	return findings
+}
+
+func valuesHasPath(values map[string]any, path []string) bool {
+	var node any = values
+	for _, key := range path {
+		m, ok := node.(map[string]any)
+		if !ok {
+			// Paths into non-map values cannot be checked statically.
+			return true
+		}
+		next, ok := m[key]
+		if !ok {
+			return false
+		}
+		node = next
+	}
+	return true
+}
+
+// deprecatedKeys lists service keys that are ignored or deprecated for
+// swarm deployments, with the suggested replacement.
+var deprecatedKeys = map[string]string{
+	"links":          "use networks for service discovery",
+	"external_links": "use external networks",
+	"container_name": "swarm assigns task names; remove it",
+	"restart":        "use deploy.restart_policy",
+	"cpu_shares":     "use deploy.resources",
+	"cpuset":         "use deploy.resources",
+	"mem_limit":      "use deploy.resources.limits.memory",
+	"memswap_limit":  "use deploy.resources",
+	"volumes_from":   "use named volumes",
+	"volume_driver":  "declare the driver on the top-level volume",
+}
+
+type deprecatedKeysRule struct{}
+
+func (deprecatedKeysRule) Name() string { return "deprecated-keys" }
+
+func (deprecatedKeysRule) Description() string {
+	return "services use compose keys that are deprecated or ignored by swarm"
+}
+
+func (deprecatedKeysRule) DefaultSeverity() Severity { return SeverityWarn }
+
+func (deprecatedKeysRule) Check(in *Input) []Finding {
+	var findings []Finding
+	for _, svc := range services(in.Documents) {
+		if svc.body == nil {
+			continue
+		}
+		for i := 0; i+1 < len(svc.body.Content); i += 2 {
+			key := svc.body.Content[i]
+			hint, ok := deprecatedKeys[key.Value]
+			if !ok {
+				continue
+			}
+			findings = append(findings, Finding{
+				Message:  fmt.Sprintf("service %s uses deprecated key %s: %s", svc.name, key.Value, hint),
+				Location: in.Locate(key.Line),
+			})
+		}
+	}
+	return findings
+}
+
+type resourceLimitsRule struct{}
+
+func (resourceLimitsRule) Name() string { return "resource-limits" }
+
+func (resourceLimitsRule) Description() string {
+	return "services do not set deploy.resources.limits"
+}
+
+func (resourceLimitsRule) DefaultSeverity() Severity { return SeverityWarn }
+
+func (resourceLimitsRule) Check(in *Input) []Finding {
+	var findings []Finding
+	for _, svc := range services(in.Documents) {
+		if lookup(svc.body, "deploy", "resources", "limits") != nil {
+			continue
+		}
+		findings = append(findings, Finding{
+			Message:  fmt.Sprintf("service %s has no deploy.resources.limits", svc.name),
+			Location: in.Locate(svc.key.Line),
+		})
+	}
+	return findings
+}
+
+type latestTagRule struct{}
+
+func (latestTagRule) Name() string { return "latest-tag" }
+
+func (latestTagRule) Description() string {
+	return "service images use the latest tag or no tag"
+}
+
+func (latestTagRule) DefaultSeverity() Severity { return SeverityWarn }
+
+func (latestTagRule) Check(in *Input) []Finding {
+	var findings []Finding
+	for _, svc := range services(in.Documents) {
+		image := lookup(svc.body, "image")
+		if image == nil || image.Value == "" || !isMutableTag(image.Value) {
+			continue
+		}
+		findings = append(findings, Finding{
+			Message:  fmt.Sprintf("service %s image %s is not pinned to a version", svc.name, image.Value),
+			Location: in.Locate(image.Line),
+		})
+	}
+	return findings
+}
+
+// isMutableTag reports whether an image reference resolves to latest.
+func isMutableTag(ref string) bool {
+	if strings.Contains(ref, "@") {
+		return false
+	}
+	name := ref
+	if idx := strings.LastIndex(ref, "/"); idx >= 0 {
+		name = ref[idx+1:]
+	}
+	idx := strings.LastIndex(name, ":")
+	return idx < 0 || name[idx+1:] == "latest"
+}
+
+// dangerousCapabilities are capabilities that amount to privileged access.
+var dangerousCapabilities = map[string]bool{
+	"ALL":       true,
+	"SYS_ADMIN": true,
+	"NET_ADMIN": true,
+}
+
+type privilegedRule struct{}
+
+func (privilegedRule) Name() string { return "privileged" }
+
+func (privilegedRule) Description() string {
+	return "services run privileged or add dangerous capabilities"
+}
+
+func (privilegedRule) DefaultSeverity() Severity { return SeverityError }
+
+func (privilegedRule) Check(in *Input) []Finding {
+	var findings []Finding
+	for _, svc := range services(in.Documents) {
+		if node := lookup(svc.body, "privileged"); node != nil && node.Value == "true" {
+			findings = append(findings, Finding{
+				Message:  fmt.Sprintf("service %s runs privileged", svc.name),
+				Location: in.Locate(node.Line),
+			})
+		}
+		caps := lookup(svc.body, "cap_add")
+		if caps == nil {
+			continue
+		}
+		for _, c := range caps.Content {
+			name := strings.TrimPrefix(strings.ToUpper(c.Value), "CAP_")
+			if dangerousCapabilities[name] {
+				findings = append(findings, Finding{
+					Message:  fmt.Sprintf("service %s adds capability %s", svc.name, c.Value),
+					Location: in.Locate(c.Line),
+				})
+			}
+		}
+	}
+	return findings
+}
+
+type objectSizeRule struct{}
+
+func (objectSizeRule) Name() string { return "object-size" }
+
+func (objectSizeRule) Description() string {
+	return "configs and secrets exceed the 500 KiB swarm limit"
+}
+
+func (objectSizeRule) DefaultSeverity() Severity { return SeverityError }
+
+func (objectSizeRule) Check(in *Input) []Finding {
+	var findings []Finding
+	eachObject(in, func(kind string, key *yaml.Node, obj compose.Object) {
+		size, err := obj.Size(in.Dir)
+		if err != nil || size <= compose.MaxObjectSize {
+			return
+		}
+		findings = append(findings, Finding{
+			Message:  compose.OversizedMessage(kind, key.Value, size),
+			Location: in.Locate(key.Line),
+		})
+	})
+	return findings
+}
+
+type objectNameRule struct{}
+
+func (objectNameRule) Name() string { return "object-name" }
+
+func (objectNameRule) Description() string {
+	return "configs and secrets have names swarm rejects"
+}
+
+func (objectNameRule) DefaultSeverity() Severity { return SeverityError }
+
+func (objectNameRule) Check(in *Input) []Finding {
+	var findings []Finding
+	eachObject(in, func(kind string, key *yaml.Node, obj compose.Object) {
+		if err := obj.CheckName(key.Value); err != nil {
+			findings = append(findings, Finding{
+				Message:  fmt.Sprintf("%s %s: %v", kind, key.Value, err),
+				Location: in.Locate(key.Line),
+			})
+		}
+	})
+	return findings
+}
+
+// eachObject calls fn with the key and definition of every config and
+// secret of the rendered documents.
+func eachObject(in *Input, fn func(kind string, key *yaml.Node, obj compose.Object)) {
+	for _, doc := range in.Documents {
+		for _, kind := range []string{"config", "secret"} {
+			objects := lookup(doc, kind+"s")
+			if objects == nil || objects.Kind != yaml.MappingNode {
+				continue
+			}
+			for i := 0; i+1 < len(objects.Content); i += 2 {
+				var obj compose.Object
+				if err := objects.Content[i+1].Decode(&obj); err != nil {
+					continue
+				}
+				fn(kind, objects.Content[i], obj)
+			}
+		}
+	}
+}
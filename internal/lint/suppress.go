@@ -0,0 +1,93 @@
+package lint
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Inline directives silence findings from template sources:
+//
+//	# tmpl-lint:disable latest-tag        (own line: applies to the next line)
+//	image: nginx # tmpl-lint:disable      (trailing: applies to this line)
+//	# tmpl-lint:disable-file privileged   (applies to the whole file)
+//
+// Omitting rule names disables every rule. Directives can also be written
+// as template comments, {{/* tmpl-lint:disable latest-tag */}}.
+var directivePattern = regexp.MustCompile(`tmpl-lint:(disable-file|disable)\b([\w\s,-]*)`)
+
+type suppressionKey struct {
+	file string
+	line int
+}
+
+type suppressions struct {
+	lines map[suppressionKey][]string
+	files map[string][]string
+}
+
+func parseSuppressions(sources map[string][]string) *suppressions {
+	s := &suppressions{
+		lines: make(map[suppressionKey][]string),
+		files: make(map[string][]string),
+	}
+	for file, lines := range sources {
+		for i, line := range lines {
+			match := directivePattern.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			rules := parseRuleList(match[2])
+			if match[1] == "disable-file" {
+				s.files[file] = append(s.files[file], rules...)
+				continue
+			}
+			target := i + 1
+			if isCommentOnly(line) {
+				target = i + 2
+			}
+			key := suppressionKey{file: file, line: target}
+			s.lines[key] = append(s.lines[key], rules...)
+		}
+	}
+	return s
+}
+
+func (s *suppressions) matches(f Finding) bool {
+	if covers(s.files[f.Location.File], f.Rule) {
+		return true
+	}
+	return covers(s.lines[suppressionKey{file: f.Location.File, line: f.Location.Line}], f.Rule)
+}
+
+// covers reports whether a directive's rule list applies to rule. A
+// directive without names is stored as a single "*" entry.
+func covers(rules []string, rule string) bool {
+	for _, r := range rules {
+		if r == "*" || r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRuleList(raw string) []string {
+	raw = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(raw), "*/}}"))
+	if raw == "" {
+		return []string{"*"}
+	}
+	var rules []string
+	for _, part := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		if part = strings.TrimSpace(part); part != "" {
+			rules = append(rules, part)
+		}
+	}
+	if len(rules) == 0 {
+		return []string{"*"}
+	}
+	return rules
+}
+
+func isCommentOnly(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "{{/*") || strings.HasPrefix(trimmed, "{{- /*")
+}
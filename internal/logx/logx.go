@@ -2,6 +2,8 @@ package logx
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"strings"
@@ -13,21 +15,59 @@ var (
 	defaultLogger *slog.Logger
 )
 
-// New returns a JSON slog.Logger configured with the requested level.
-// The logger writes to stdout to simplify integration with container runtimes.
-func New(level string) *slog.Logger {
-	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: parseLevel(level)})
-	return slog.New(handler)
+// Formats of log lines.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// New returns a slog.Logger writing lines of format, json or text, at the
+// requested level to w. Values registered with Sensitive are masked.
+func New(level, format string, w io.Writer) *slog.Logger {
+	return slog.New(newHandler(format, w, parseLevel(level)))
+}
+
+// ParseFormat checks a log format name and returns it in canonical form.
+func ParseFormat(format string) (string, error) {
+	switch f := strings.ToLower(strings.TrimSpace(format)); f {
+	case FormatJSON, FormatText:
+		return f, nil
+	case "":
+		return FormatJSON, nil
+	default:
+		return "", fmt.Errorf("unknown log format %q, expected json or text", format)
+	}
 }
 
-// Default returns a process-wide shared logger initialised lazily at info level.
+// Default returns a process-wide shared logger initialised lazily at info
+// level. It writes JSON to stderr, keeping stdout for command output.
 func Default() *slog.Logger {
 	defaultOnce.Do(func() {
-		defaultLogger = New("info")
+		defaultLogger = New("info", FormatJSON, os.Stderr)
 	})
 	return defaultLogger
 }
 
+func newHandler(format string, w io.Writer, level slog.Level) slog.Handler {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == FormatText {
+		return &redactingHandler{next: slog.NewTextHandler(w, opts)}
+	}
+	return &redactingHandler{next: slog.NewJSONHandler(w, opts)}
+}
+
+// format returns the format of the lines logger writes.
+func format(logger *slog.Logger) string {
+	h := logger.Handler()
+	if r, ok := h.(*redactingHandler); ok {
+		h = r.next
+	}
+	if _, ok := h.(*slog.TextHandler); ok {
+		return FormatText
+	}
+	return FormatJSON
+}
+
 // WithContext attaches the provided logger to the context for downstream retrieval.
 func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
 	return context.WithValue(ctx, ctxKey{}, logger)
@@ -44,6 +84,20 @@ func FromContext(ctx context.Context) *slog.Logger {
 	return Default()
 }
 
+// WithWriter returns a context whose logger writes to w at the level and
+// in the format of the logger in ctx.
+func WithWriter(ctx context.Context, w io.Writer) context.Context {
+	logger := FromContext(ctx)
+	level := slog.LevelError
+	for _, l := range []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn} {
+		if logger.Enabled(ctx, l) {
+			level = l
+			break
+		}
+	}
+	return WithContext(ctx, slog.New(newHandler(format(logger), w, level)))
+}
+
 type ctxKey struct{}
 
 func parseLevel(level string) slog.Level {
@@ -0,0 +1,149 @@
+package logx
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Mask replaces sensitive values in log lines.
+const Mask = "[REDACTED]"
+
+// minSensitive is the length below which values are not masked, so that
+// short values such as "1" or "on" do not mangle every line.
+const minSensitive = 4
+
+// sensitiveEnv are substrings of the names of environment variables whose
+// values are masked.
+var sensitiveEnv = []string{"TOKEN", "SECRET", "PASSWORD", "PASSWD", "CREDENTIAL", "API_KEY", "ACCESS_KEY", "PRIVATE_KEY"}
+
+// sensitive holds the values masked by every logger of the process.
+var sensitive struct {
+	mu       sync.RWMutex
+	values   []string
+	replacer *strings.Replacer
+}
+
+// Sensitive registers values, such as decrypted secrets, to be masked in
+// all log lines from now on, whatever their level.
+func Sensitive(values ...string) {
+	sensitive.mu.Lock()
+	defer sensitive.mu.Unlock()
+	changed := false
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if len(v) < minSensitive || slices.Contains(sensitive.values, v) {
+			continue
+		}
+		sensitive.values = append(sensitive.values, v)
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	// Longer values first, so a secret containing another is masked
+	// whole.
+	slices.SortFunc(sensitive.values, func(a, b string) int { return len(b) - len(a) })
+	pairs := make([]string, 0, 2*len(sensitive.values))
+	for _, v := range sensitive.values {
+		pairs = append(pairs, v, Mask)
+	}
+	sensitive.replacer = strings.NewReplacer(pairs...)
+}
+
+// SensitiveEnv registers the values of environment variables whose names
+// suggest credentials, such as GITHUB_TOKEN or AWS_SECRET_ACCESS_KEY.
+func SensitiveEnv() {
+	var values []string
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		upper := strings.ToUpper(name)
+		for _, s := range sensitiveEnv {
+			if strings.Contains(upper, s) {
+				values = append(values, value)
+				break
+			}
+		}
+	}
+	Sensitive(values...)
+}
+
+// hasSensitive reports whether any value is registered.
+func hasSensitive() bool {
+	sensitive.mu.RLock()
+	defer sensitive.mu.RUnlock()
+	return sensitive.replacer != nil
+}
+
+// redact masks the registered values in s.
+func redact(s string) string {
+	sensitive.mu.RLock()
+	r := sensitive.replacer
+	sensitive.mu.RUnlock()
+	if r == nil {
+		return s
+	}
+	return r.Replace(s)
+}
+
+// redactingHandler masks registered values in the message and attributes
+// of records before passing them on.
+type redactingHandler struct {
+	next slog.Handler
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if !hasSensitive() {
+		return h.next.Handle(ctx, r)
+	}
+	out := slog.NewRecord(r.Time, r.Level, redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttr(a)
+	}
+	return &redactingHandler{next: h.next.WithAttrs(redacted)}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name)}
+}
+
+// redactAttr masks the value of a. Values other than strings and groups
+// are masked as a whole, turning them into strings, when their text
+// contains a registered value.
+func redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redact(v.String()))
+	case slog.KindGroup:
+		attrs := v.Group()
+		redacted := make([]slog.Attr, len(attrs))
+		for i, ga := range attrs {
+			redacted[i] = redactAttr(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redacted...)}
+	case slog.KindAny:
+		text := fmt.Sprint(v.Any())
+		if masked := redact(text); masked != text {
+			return slog.String(a.Key, masked)
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
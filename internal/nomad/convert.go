@@ -0,0 +1,453 @@
+package nomad
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acebelowzero/tmpl/internal/compose"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+const (
+	// mhzPerCPU converts compose cpus into the MHz Nomad reserves.
+	mhzPerCPU = 1000
+	// secretsDir is where swarm mounts secrets with a relative target.
+	secretsDir = "/run/secrets"
+)
+
+// Options controls the conversion of a compose stack.
+type Options struct {
+	// Name is the ID of the job; services in global mode go to a second
+	// system job with a -system suffix.
+	Name string
+	// Namespace is the Nomad namespace of the jobs.
+	Namespace string
+	// BaseDir resolves relative config and secret file paths.
+	BaseDir string
+	// Redact replaces the content of secrets with a reference to its
+	// digest, as in redacted compose documents.
+	Redact bool
+}
+
+// Convert translates a compose stack into Nomad jobs: every service
+// becomes a task group running a docker task, with its configs and secrets
+// written by templates and bind mounted at their targets. Replicated
+// services make up a service job and global services a system job. What
+// has no Nomad equivalent is reported in the returned warnings instead.
+func Convert(src *compose.Stack, opts Options) ([]*Job, []string, error) {
+	if opts.Name == "" {
+		return nil, nil, fmt.Errorf("job name is required")
+	}
+	c := &converter{src: src, opts: opts}
+	service := &Job{ID: opts.Name, Name: opts.Name, Type: "service", Namespace: opts.Namespace}
+	system := &Job{ID: opts.Name + "-system", Name: opts.Name + "-system", Type: "system", Namespace: opts.Namespace}
+	for _, name := range src.ServiceNames() {
+		svc := src.Services[name]
+		group, err := c.group(name, svc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		switch svc.Deploy.Mode {
+		case "global":
+			system.TaskGroups = append(system.TaskGroups, group)
+		case "", "replicated":
+			service.TaskGroups = append(service.TaskGroups, group)
+		default:
+			return nil, nil, fmt.Errorf("service %s: unsupported deploy mode %q", name, svc.Deploy.Mode)
+		}
+	}
+	for _, name := range sortedKeys(src.Networks) {
+		if name != "default" {
+			c.warnf("network %s is not converted, services find each other through Nomad service discovery", name)
+		}
+	}
+
+	var jobs []*Job
+	for _, job := range []*Job{service, system} {
+		if len(job.TaskGroups) > 0 {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, c.warnings, nil
+}
+
+type converter struct {
+	src      *compose.Stack
+	opts     Options
+	warnings []string
+}
+
+func (c *converter) warnf(format string, args ...any) {
+	c.warnings = append(c.warnings, fmt.Sprintf(format, args...))
+}
+
+func (c *converter) group(name string, svc compose.Service) (*TaskGroup, error) {
+	config := map[string]any{"image": svc.Image}
+	if len(svc.Entrypoint) > 0 {
+		entrypoint := []string(svc.Entrypoint)
+		if len(entrypoint) == 1 {
+			entrypoint = strings.Fields(entrypoint[0])
+		}
+		config["entrypoint"] = entrypoint
+	}
+	if len(svc.Command) > 0 {
+		command := []string(svc.Command)
+		if len(command) == 1 {
+			command = strings.Fields(command[0])
+		}
+		config["command"] = command[0]
+		if len(command) > 1 {
+			config["args"] = command[1:]
+		}
+	}
+	if svc.Hostname != "" {
+		config["hostname"] = svc.Hostname
+	}
+	if svc.WorkingDir != "" {
+		config["work_dir"] = svc.WorkingDir
+	}
+	if len(svc.Labels) > 0 {
+		config["labels"] = map[string]string(svc.Labels)
+	}
+	if len(svc.Sysctls) > 0 {
+		config["sysctl"] = svc.Sysctls
+	}
+
+	task := &Task{Name: name, Driver: "docker", User: svc.User, Config: config, KillSignal: svc.StopSignal}
+	if len(svc.Environment) > 0 {
+		task.Env = map[string]string(svc.Environment)
+	}
+	group := &TaskGroup{Name: name, Tasks: []*Task{task}}
+	if len(svc.Deploy.Labels) > 0 {
+		group.Meta = map[string]string(svc.Deploy.Labels)
+	}
+	if svc.Deploy.Mode != "global" {
+		replicas := uint64(1)
+		if svc.Deploy.Replicas != nil {
+			replicas = *svc.Deploy.Replicas
+		}
+		group.Count = &replicas
+	}
+
+	var err error
+	if task.Resources, err = resources(svc.Deploy.Resources); err != nil {
+		return nil, err
+	}
+	if group.RestartPolicy, err = restartPolicy(svc.Deploy.RestartPolicy); err != nil {
+		return nil, err
+	}
+	if uc := svc.Deploy.UpdateConfig; uc != nil {
+		if group.Update, err = update(uc); err != nil {
+			return nil, fmt.Errorf("update_config: %w", err)
+		}
+	}
+	if hc := svc.Healthcheck; hc != nil && !hc.Disable {
+		c.warnf("service %s: healthcheck is not converted, add a Nomad service check", name)
+	}
+	c.placement(name, svc.Deploy.Placement, group)
+	c.ports(name, svc.Ports, group, task)
+	if err := c.mounts(name, svc, group, task); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// ports reserves published ports on the client and maps the others to
+// dynamic ports, and registers the first port as the service.
+func (c *converter) ports(name string, ports []compose.Port, group *TaskGroup, task *Task) {
+	if len(ports) == 0 {
+		return
+	}
+	network := &Network{}
+	var labels []string
+	for _, p := range ports {
+		label := "port_" + strconv.FormatUint(uint64(p.Target), 10)
+		if p.Protocol == "udp" {
+			label += "_udp"
+		}
+		port := Port{Label: label, To: p.Target}
+		if p.Published != 0 {
+			port.Value = p.Published
+			network.ReservedPorts = append(network.ReservedPorts, port)
+		} else {
+			network.DynamicPorts = append(network.DynamicPorts, port)
+		}
+		labels = append(labels, label)
+	}
+	group.Networks = []*Network{network}
+	// Service names must be valid DNS labels, unlike service names of
+	// compose.
+	serviceName := strings.Trim(strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(name)), "-")
+	if serviceName != name {
+		c.warnf("service %s is registered as %s in service discovery", name, serviceName)
+	}
+	group.Services = []*Service{{Name: serviceName, PortLabel: labels[0], Provider: "nomad"}}
+	task.Config["ports"] = labels
+}
+
+// mounts converts volumes, configs and secrets. Named volumes mount host
+// volumes of the same name, which the clients must provide.
+func (c *converter) mounts(name string, svc compose.Service, group *TaskGroup, task *Task) error {
+	var dockerMounts []map[string]any
+	for _, v := range svc.Volumes {
+		switch {
+		case v.Type == "bind":
+			dockerMounts = append(dockerMounts, map[string]any{"type": "bind", "source": v.Source, "target": v.Target, "readonly": v.ReadOnly})
+			c.warnf("service %s: bind mount of %s needs volumes enabled in the docker driver of the clients", name, v.Source)
+		case v.Type == "tmpfs":
+			dockerMounts = append(dockerMounts, map[string]any{"type": "tmpfs", "target": v.Target, "readonly": v.ReadOnly})
+		case v.Source == "":
+			dockerMounts = append(dockerMounts, map[string]any{"type": "volume", "target": v.Target, "readonly": v.ReadOnly})
+		default:
+			source := v.Source
+			if vol := c.src.Volumes[v.Source]; vol.Name != "" {
+				source = vol.Name
+			}
+			if group.Volumes == nil {
+				group.Volumes = map[string]Volume{}
+			}
+			group.Volumes[v.Source] = Volume{Name: v.Source, Type: "host", Source: source}
+			task.VolumeMounts = append(task.VolumeMounts, VolumeMount{Volume: v.Source, Destination: v.Target, ReadOnly: v.ReadOnly})
+		}
+	}
+
+	for _, ref := range svc.Configs {
+		target := ref.Target
+		if target == "" {
+			target = "/" + ref.Source
+		}
+		obj := c.src.Configs[ref.Source]
+		if obj.External {
+			c.warnf("service %s: external config %s is not converted", name, ref.Source)
+			continue
+		}
+		data, err := c.content(obj)
+		if err != nil {
+			return fmt.Errorf("config %s: %w", ref.Source, err)
+		}
+		dest := "local/configs/" + ref.Source
+		task.Templates = append(task.Templates, template(string(data), dest, ref.Mode))
+		dockerMounts = append(dockerMounts, map[string]any{"type": "bind", "source": dest, "target": target, "readonly": true})
+	}
+	for _, ref := range svc.Secrets {
+		target := ref.Target
+		if target == "" {
+			target = ref.Source
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(secretsDir, target)
+		}
+		obj := c.src.Secrets[ref.Source]
+		if obj.External {
+			c.warnf("service %s: external secret %s is not converted, read it from Vault or Nomad variables", name, ref.Source)
+			continue
+		}
+		data, err := c.content(obj)
+		if err != nil {
+			return fmt.Errorf("secret %s: %w", ref.Source, err)
+		}
+		content := string(data)
+		if c.opts.Redact {
+			content = compose.RedactedReference(data)
+		}
+		dest := "secrets/" + ref.Source
+		task.Templates = append(task.Templates, template(content, dest, ref.Mode))
+		dockerMounts = append(dockerMounts, map[string]any{"type": "bind", "source": dest, "target": target, "readonly": true})
+	}
+	if len(dockerMounts) > 0 {
+		task.Config["mount"] = dockerMounts
+	}
+	return nil
+}
+
+// template writes content as is: delimiters that do not occur in it keep
+// Nomad from evaluating it as a template.
+func template(content, dest string, mode *uint32) *Template {
+	t := &Template{EmbeddedTmpl: content, DestPath: dest}
+	if strings.Contains(content, "{{") {
+		t.LeftDelim, t.RightDelim = "[[tmpl-literal", "tmpl-literal]]"
+	}
+	if mode != nil {
+		t.Perms = strconv.FormatUint(uint64(*mode), 8)
+	}
+	return t
+}
+
+func (c *converter) content(obj compose.Object) ([]byte, error) {
+	if obj.File == "" {
+		return []byte(obj.Content), nil
+	}
+	path := obj.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.opts.BaseDir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// placement converts constraints on node attributes into group
+// constraints and spread preferences on node labels into spreads.
+func (c *converter) placement(name string, placement compose.Placement, group *TaskGroup) {
+	for _, raw := range placement.Constraints {
+		con, err := stack.ParseConstraint(raw)
+		if err != nil {
+			c.warnf("service %s: placement constraint %s is not converted", name, raw)
+			continue
+		}
+		operand := "!="
+		if con.Equal {
+			operand = "="
+		}
+		value := con.Value
+		var attr string
+		switch {
+		case strings.HasPrefix(con.Field, "node.labels."):
+			attr = "${meta." + strings.TrimPrefix(con.Field, "node.labels.") + "}"
+		case con.Field == "node.id":
+			attr = "${node.unique.id}"
+		case con.Field == "node.hostname":
+			attr = "${attr.unique.hostname}"
+		case con.Field == "node.platform.os":
+			attr = "${attr.kernel.name}"
+		case con.Field == "node.platform.arch":
+			attr, value = "${attr.cpu.arch}", arch(value)
+		default:
+			c.warnf("service %s: placement constraint %s is not converted", name, raw)
+			continue
+		}
+		group.Constraints = append(group.Constraints, Constraint{LTarget: attr, RTarget: value, Operand: operand})
+	}
+	for _, pref := range placement.Preferences {
+		label, ok := strings.CutPrefix(pref.Spread, "node.labels.")
+		if !ok {
+			c.warnf("service %s: placement preference %s is not converted", name, pref.Spread)
+			continue
+		}
+		group.Spreads = append(group.Spreads, Spread{Attribute: "${meta." + label + "}", Weight: 100})
+	}
+	if placement.MaxReplicas > 0 {
+		c.warnf("service %s: max_replicas_per_node is not converted", name)
+	}
+}
+
+// arch maps the architectures docker reports to those of Go, which Nomad
+// uses.
+func arch(s string) string {
+	switch s {
+	case "x86_64":
+		return "amd64"
+	case "aarch64":
+		return "arm64"
+	}
+	return s
+}
+
+// resources reserves what compose reserves, or else limits, as Nomad
+// schedules by reservation. A memory limit above the reservation becomes
+// the memory oversubscription limit.
+func resources(r compose.Resources) (*Resources, error) {
+	reserve := r.Reservations
+	if reserve == nil {
+		reserve = r.Limits
+	}
+	if reserve == nil {
+		return nil, nil
+	}
+	out := &Resources{}
+	if reserve.CPUs != "" {
+		cpus, err := strconv.ParseFloat(reserve.CPUs, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpus %q: %w", reserve.CPUs, err)
+		}
+		out.CPU = int64(cpus * mhzPerCPU)
+	}
+	if reserve.Memory != "" {
+		mem, err := stack.ParseBytes(reserve.Memory)
+		if err != nil {
+			return nil, err
+		}
+		out.MemoryMB = mebibytes(mem)
+	}
+	if r.Reservations != nil && r.Limits != nil && r.Limits.Memory != "" {
+		mem, err := stack.ParseBytes(r.Limits.Memory)
+		if err != nil {
+			return nil, err
+		}
+		if max := mebibytes(mem); max > out.MemoryMB {
+			out.MemoryMaxMB = max
+		}
+	}
+	return out, nil
+}
+
+// mebibytes rounds bytes up to whole MiB.
+func mebibytes(n int64) int64 {
+	return (n + 1<<20 - 1) >> 20
+}
+
+func restartPolicy(rp *compose.RestartPolicy) (*RestartPolicy, error) {
+	if rp == nil {
+		return nil, nil
+	}
+	out := &RestartPolicy{Attempts: 2, Mode: "delay"}
+	if rp.Condition == "none" {
+		return &RestartPolicy{Attempts: 0, Mode: "fail"}, nil
+	}
+	if rp.MaxAttempts != nil {
+		out.Attempts, out.Mode = int(*rp.MaxAttempts), "fail"
+	}
+	var err error
+	if out.Delay, err = nanoseconds(rp.Delay); err != nil {
+		return nil, fmt.Errorf("restart_policy delay: %w", err)
+	}
+	if out.Interval, err = nanoseconds(rp.Window); err != nil {
+		return nil, fmt.Errorf("restart_policy window: %w", err)
+	}
+	return out, nil
+}
+
+func update(uc *compose.UpdateConfig) (*Update, error) {
+	out := &Update{MaxParallel: 1}
+	if uc.Parallelism != nil {
+		out.MaxParallel = *uc.Parallelism
+	}
+	var err error
+	if out.Stagger, err = nanoseconds(uc.Delay); err != nil {
+		return nil, fmt.Errorf("delay: %w", err)
+	}
+	return out, nil
+}
+
+func nanoseconds(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	return int64(d), nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
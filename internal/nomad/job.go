@@ -0,0 +1,142 @@
+// Package nomad converts compose stacks into Nomad jobs, so the same
+// charts can drive Nomad clusters. Jobs are modelled after the JSON job
+// specification of the Nomad API, as accepted by nomad job run -json.
+package nomad
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Job is a Nomad job.
+type Job struct {
+	ID          string
+	Name        string
+	Type        string
+	Namespace   string            `json:",omitempty"`
+	Datacenters []string          `json:",omitempty"`
+	Meta        map[string]string `json:",omitempty"`
+	TaskGroups  []*TaskGroup
+}
+
+// TaskGroup runs Count instances of its tasks together on a client.
+type TaskGroup struct {
+	Name          string
+	Count         *uint64           `json:",omitempty"`
+	Meta          map[string]string `json:",omitempty"`
+	Constraints   []Constraint      `json:",omitempty"`
+	Spreads       []Spread          `json:",omitempty"`
+	Update        *Update           `json:",omitempty"`
+	RestartPolicy *RestartPolicy    `json:",omitempty"`
+	Networks      []*Network        `json:",omitempty"`
+	Services      []*Service        `json:",omitempty"`
+	Volumes       map[string]Volume `json:",omitempty"`
+	Tasks         []*Task
+}
+
+// Constraint restricts the clients a group is placed on.
+type Constraint struct {
+	LTarget string
+	RTarget string
+	Operand string
+}
+
+// Spread spreads the allocations of a group over the values of an
+// attribute.
+type Spread struct {
+	Attribute string
+	Weight    int
+}
+
+// Update controls rolling updates of a group.
+type Update struct {
+	MaxParallel uint64
+	Stagger     int64 `json:",omitempty"`
+}
+
+// RestartPolicy controls restarts of failed tasks on the same client.
+type RestartPolicy struct {
+	Attempts int
+	Interval int64 `json:",omitempty"`
+	Delay    int64 `json:",omitempty"`
+	Mode     string
+}
+
+// Network holds the ports of a group.
+type Network struct {
+	ReservedPorts []Port `json:",omitempty"`
+	DynamicPorts  []Port `json:",omitempty"`
+}
+
+// Port is a reserved port with a Value or a dynamic port, mapped to To in
+// the container.
+type Port struct {
+	Label string
+	Value uint32 `json:",omitempty"`
+	To    uint32 `json:",omitempty"`
+}
+
+// Service registers a group port in Nomad service discovery.
+type Service struct {
+	Name      string
+	PortLabel string
+	Provider  string
+}
+
+// Volume is a host volume of a group.
+type Volume struct {
+	Name     string
+	Type     string
+	Source   string
+	ReadOnly bool `json:",omitempty"`
+}
+
+// Task runs a container with the docker driver.
+type Task struct {
+	Name         string
+	Driver       string
+	User         string `json:",omitempty"`
+	Config       map[string]any
+	Env          map[string]string `json:",omitempty"`
+	Resources    *Resources        `json:",omitempty"`
+	Templates    []*Template       `json:",omitempty"`
+	VolumeMounts []VolumeMount     `json:",omitempty"`
+	KillSignal   string            `json:",omitempty"`
+}
+
+// Resources reserves CPU in MHz and memory in MiB for a task.
+type Resources struct {
+	CPU         int64 `json:",omitempty"`
+	MemoryMB    int64 `json:",omitempty"`
+	MemoryMaxMB int64 `json:",omitempty"`
+}
+
+// Template writes a file into the task directory.
+type Template struct {
+	EmbeddedTmpl string
+	DestPath     string
+	Perms        string `json:",omitempty"`
+	LeftDelim    string `json:",omitempty"`
+	RightDelim   string `json:",omitempty"`
+}
+
+// VolumeMount mounts a group volume into a task.
+type VolumeMount struct {
+	Volume      string
+	Destination string
+	ReadOnly    bool `json:",omitempty"`
+}
+
+// Encode writes jobs to w as JSON job specifications, one after another.
+func Encode(w io.Writer, jobs []*Job) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	for _, job := range jobs {
+		if err := enc.Encode(struct{ Job *Job }{job}); err != nil {
+			return fmt.Errorf("encode job %s: %w", job.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+// Package notify posts deployment notifications, such as the start and
+// outcome of an apply or rollback, to webhooks in the Slack format or as
+// generic JSON.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Webhook formats.
+const (
+	// FormatSlack posts a message with a text field, understood by Slack
+	// incoming webhooks and compatible chat servers.
+	FormatSlack = "slack"
+	// FormatJSON posts the event itself.
+	FormatJSON = "json"
+)
+
+// Event types.
+const (
+	EventStart   = "start"
+	EventSuccess = "success"
+	EventFailure = "failure"
+)
+
+// Events lists the event types in the order they occur.
+var Events = []string{EventStart, EventSuccess, EventFailure}
+
+// Event describes a step of a deployment.
+type Event struct {
+	Type string    `json:"event"`
+	Time time.Time `json:"time"`
+	// Operation is the command, such as apply or rollback.
+	Operation    string            `json:"operation"`
+	Release      string            `json:"release"`
+	Namespace    string            `json:"namespace,omitempty"`
+	Revision     int               `json:"revision,omitempty"`
+	Chart        string            `json:"chart,omitempty"`
+	ChartVersion string            `json:"chartVersion,omitempty"`
+	AppVersion   string            `json:"appVersion,omitempty"`
+	User         string            `json:"user,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Plan         *Plan             `json:"plan,omitempty"`
+	Error        string            `json:"error,omitempty"`
+	// DurationMS is the time the operation took, on success and failure.
+	DurationMS int64 `json:"durationMs,omitempty"`
+}
+
+// Plan summarizes the changes of a deployment.
+type Plan struct {
+	Create    int      `json:"create"`
+	Update    int      `json:"update"`
+	Delete    int      `json:"delete"`
+	Unchanged int      `json:"unchanged"`
+	Changes   []Change `json:"changes,omitempty"`
+}
+
+// Change is a planned change of an object.
+type Change struct {
+	Action string `json:"action"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+}
+
+// Webhook is an endpoint receiving events.
+type Webhook struct {
+	// URL may refer to environment variables as ${VAR}, so secret webhook
+	// URLs need not be written to configuration files.
+	URL string
+	// Format is FormatSlack or FormatJSON, the default.
+	Format string
+	// Events lists the event types posted; empty posts all of them.
+	Events []string
+}
+
+// Validate checks the format and event types of w.
+func (w Webhook) Validate() error {
+	if w.URL == "" {
+		return errors.New("url is required")
+	}
+	if w.Format != "" && w.Format != FormatSlack && w.Format != FormatJSON {
+		return fmt.Errorf("unknown format %q, expected %s or %s", w.Format, FormatSlack, FormatJSON)
+	}
+	for _, e := range w.Events {
+		if !slices.Contains(Events, e) {
+			return fmt.Errorf("unknown event %q, expected one of %s", e, strings.Join(Events, ", "))
+		}
+	}
+	return nil
+}
+
+// Notifier posts events to webhooks.
+type Notifier struct {
+	hooks  []Webhook
+	client *http.Client
+}
+
+// New returns a notifier posting to hooks, expanding environment variables
+// in their URLs. It returns nil when there are no hooks.
+func New(hooks []Webhook) (*Notifier, error) {
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+	n := &Notifier{client: &http.Client{Timeout: 10 * time.Second}}
+	for _, h := range hooks {
+		if err := h.Validate(); err != nil {
+			return nil, err
+		}
+		h.URL = os.ExpandEnv(h.URL)
+		u, err := url.Parse(h.URL)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			// The URL is not shown, as it usually holds a secret.
+			return nil, errors.New("webhook url must be an http(s) URL")
+		}
+		n.hooks = append(n.hooks, h)
+	}
+	return n, nil
+}
+
+// Send posts e to every webhook subscribed to its type. Webhooks failing
+// do not keep e from the others; their errors are joined.
+func (n *Notifier) Send(ctx context.Context, e *Event) error {
+	if n == nil {
+		return nil
+	}
+	var errs []error
+	for _, h := range n.hooks {
+		if len(h.Events) > 0 && !slices.Contains(h.Events, e.Type) {
+			continue
+		}
+		if err := n.post(ctx, h, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (n *Notifier) post(ctx context.Context, h Webhook, e *Event) error {
+	var body any = e
+	if h.Format == FormatSlack {
+		body = map[string]string{"text": Text(e)}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("encode notification: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The error of the client holds the URL.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("post notification to %s: %w", redact(h.URL), err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("post notification to %s: %s", redact(h.URL), resp.Status)
+	}
+	return nil
+}
+
+// redact returns the host of a webhook URL, as its path and query usually
+// hold a token.
+func redact(webhook string) string {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return "webhook"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// verbs words events by operation: the start, the success and the object
+// of a failure.
+var verbs = map[string][3]string{
+	"apply":    {"Deploying", "Deployed", "deploy"},
+	"rollback": {"Rolling back", "Rolled back", "roll back"},
+}
+
+// Text describes e as a chat message in Slack markup.
+func Text(e *Event) string {
+	v, ok := verbs[e.Operation]
+	if !ok {
+		v = [3]string{"Running " + e.Operation + " of", "Finished " + e.Operation + " of", e.Operation}
+	}
+	var b strings.Builder
+	switch e.Type {
+	case EventStart:
+		fmt.Fprintf(&b, "%s *%s*", v[0], e.Release)
+	case EventSuccess:
+		fmt.Fprintf(&b, "%s *%s*", v[1], e.Release)
+		if e.Revision > 0 {
+			fmt.Fprintf(&b, " revision %d", e.Revision)
+		}
+	default:
+		fmt.Fprintf(&b, "Failed to %s *%s*", v[2], e.Release)
+	}
+	if e.Chart != "" {
+		fmt.Fprintf(&b, " (%s-%s)", e.Chart, e.ChartVersion)
+	}
+	if e.Namespace != "" {
+		fmt.Fprintf(&b, " in %s", e.Namespace)
+	}
+	if e.User != "" {
+		fmt.Fprintf(&b, " by %s", e.User)
+	}
+	if e.Type != EventStart && e.DurationMS > 0 {
+		fmt.Fprintf(&b, " after %s", (time.Duration(e.DurationMS) * time.Millisecond).Round(time.Second))
+	}
+	if p := e.Plan; p != nil && e.Type == EventStart {
+		fmt.Fprintf(&b, ": %d to create, %d to update, %d to remove, %d unchanged", p.Create, p.Update, p.Delete, p.Unchanged)
+	}
+	if e.Error != "" {
+		fmt.Fprintf(&b, ": %s", e.Error)
+	}
+	if e.Description != "" {
+		fmt.Fprintf(&b, "\n> %s", e.Description)
+	}
+	if len(e.Annotations) > 0 {
+		pairs := make([]string, 0, len(e.Annotations))
+		for key, value := range e.Annotations {
+			pairs = append(pairs, key+"="+value)
+		}
+		sort.Strings(pairs)
+		fmt.Fprintf(&b, "\n%s", strings.Join(pairs, ", "))
+	}
+	return b.String()
+}
@@ -0,0 +1,139 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// Docker Hub is named docker.io in image references, but serves the
+// registry API from another host.
+const (
+	dockerHub         = "docker.io"
+	dockerHubRegistry = "registry-1.docker.io"
+)
+
+// mediaTypeDockerManifestList is the docker equivalent of an OCI index.
+const mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+// ResolveImage pins a container image reference, such as nginx:1.25 or
+// ghcr.io/acme/web, to the digest its tag currently points to, returning
+// e.g. nginx:1.25@sha256:.... References with a digest are returned as
+// they are. Credentials are read from the docker config.
+func ResolveImage(ctx context.Context, image string) (string, error) {
+	if strings.Contains(image, "@") {
+		return image, nil
+	}
+	repo, target, err := imageRepository(image)
+	if err != nil {
+		return "", err
+	}
+	desc, err := repo.Resolve(ctx, target)
+	if err != nil {
+		return "", fmt.Errorf("resolve image %s: %w", image, err)
+	}
+	return image + "@" + desc.Digest.String(), nil
+}
+
+// ImagePlatforms returns the platforms an image is available for, as
+// OS/ARCH or OS/ARCH/VARIANT, e.g. linux/arm/v7. It fails with an error
+// IsNotFound recognizes when the image does not exist.
+func ImagePlatforms(ctx context.Context, image string) ([]string, error) {
+	repo, target, err := imageRepository(image)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := repo.Resolve(ctx, target)
+	if err != nil {
+		return nil, fmt.Errorf("resolve image %s: %w", image, err)
+	}
+	raw, err := content.FetchAll(ctx, repo, desc)
+	if err != nil {
+		return nil, fmt.Errorf("fetch manifest of %s: %w", image, err)
+	}
+	if desc.MediaType == ocispec.MediaTypeImageIndex || desc.MediaType == mediaTypeDockerManifestList {
+		var index ocispec.Index
+		if err := json.Unmarshal(raw, &index); err != nil {
+			return nil, fmt.Errorf("decode index of %s: %w", image, err)
+		}
+		var platforms []string
+		for _, m := range index.Manifests {
+			// Attestations are listed with an unknown platform.
+			if m.Platform != nil && m.Platform.OS != "unknown" {
+				platforms = append(platforms, platformString(*m.Platform))
+			}
+		}
+		return platforms, nil
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return nil, fmt.Errorf("decode manifest of %s: %w", image, err)
+	}
+	rawConfig, err := content.FetchAll(ctx, repo.Blobs(), manifest.Config)
+	if err != nil {
+		return nil, fmt.Errorf("fetch config of %s: %w", image, err)
+	}
+	var config ocispec.Platform
+	if err := json.Unmarshal(rawConfig, &config); err != nil || config.OS == "" {
+		// Not an image config, so the platform is unknown.
+		return nil, nil
+	}
+	return []string{platformString(config)}, nil
+}
+
+// IsNotFound reports whether err is a registry response saying an image
+// or tag does not exist.
+func IsNotFound(err error) bool {
+	return errors.Is(err, errdef.ErrNotFound)
+}
+
+func platformString(p ocispec.Platform) string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// imageRepository returns the repository of an image reference and the
+// tag or digest it names, following the docker conventions: references
+// without a registry are on Docker Hub, in library/ when they have no
+// namespace, and the tag defaults to latest.
+func imageRepository(image string) (*remote.Repository, string, error) {
+	name, target := image, "latest"
+	if n, digest, ok := strings.Cut(image, "@"); ok {
+		name, target = n, digest
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		if !strings.Contains(image, "@") {
+			target = name[i+1:]
+		}
+		name = name[:i]
+	}
+	host, path, ok := strings.Cut(name, "/")
+	if !ok || !strings.ContainsAny(host, ".:") && host != "localhost" {
+		host, path = dockerHub, name
+	}
+	if host == dockerHub {
+		if !strings.Contains(path, "/") {
+			path = "library/" + path
+		}
+		host = dockerHubRegistry
+	}
+	sep := ":"
+	if strings.Contains(target, ":") {
+		sep = "@"
+	}
+	repo, err := Repository(host + "/" + path + sep + target)
+	if err != nil {
+		return nil, "", fmt.Errorf("image %s: %w", image, err)
+	}
+	return repo, target, nil
+}
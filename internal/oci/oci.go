@@ -0,0 +1,208 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+// Media types of packaged charts stored in OCI registries.
+const (
+	ArtifactTypeChart  = "application/vnd.tmpl.chart.v1"
+	MediaTypeConfig    = "application/vnd.tmpl.chart.config.v1+json"
+	MediaTypeChartData = "application/vnd.tmpl.chart.content.v1.tar+gzip"
+	// The provenance file of a chart and its signature are pushed as
+	// further layers.
+	MediaTypeProvenance          = "application/vnd.tmpl.chart.provenance.v1+yaml"
+	MediaTypeProvenanceSignature = "application/vnd.tmpl.chart.provenance.signature.v1"
+)
+
+// Scheme prefixes OCI references on the command line.
+const Scheme = "oci://"
+
+// Artifact is content pulled from a registry.
+type Artifact struct {
+	Ref      string
+	Digest   string
+	Data     []byte
+	Manifest ocispec.Manifest
+}
+
+// Layer is content pushed with a chart besides its archive.
+type Layer struct {
+	MediaType string
+	Data      []byte
+}
+
+// Repository returns a remote repository for ref ("registry/repo[:tag]")
+// authenticated with credentials from the docker config.
+func Repository(ref string) (*remote.Repository, error) {
+	repo, err := remote.NewRepository(strings.TrimPrefix(ref, Scheme))
+	if err != nil {
+		return nil, fmt.Errorf("parse reference %s: %w", ref, err)
+	}
+	store, err := credentials.NewStoreFromDocker(credentials.StoreOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("load registry credentials: %w", err)
+	}
+	repo.Client = authClient(store)
+	return repo, nil
+}
+
+func authClient(store credentials.Store) *auth.Client {
+	return &auth.Client{
+		Client:     retry.DefaultClient,
+		Cache:      auth.NewCache(),
+		Credential: credentials.Credential(store),
+	}
+}
+
+// Ping checks that the registry of ref ("registry[/repo]") is reachable
+// and accepts the credentials stored for it in the docker config. It
+// reports whether any credentials were stored.
+func Ping(ctx context.Context, ref string) (bool, error) {
+	host, _, _ := strings.Cut(strings.TrimPrefix(ref, Scheme), "/")
+	reg, err := remote.NewRegistry(host)
+	if err != nil {
+		return false, fmt.Errorf("parse registry %s: %w", host, err)
+	}
+	store, err := credentials.NewStoreFromDocker(credentials.StoreOptions{})
+	if err != nil {
+		return false, fmt.Errorf("load registry credentials: %w", err)
+	}
+	cred, err := store.Get(ctx, credentials.ServerAddressFromRegistry(host))
+	if err != nil {
+		return false, fmt.Errorf("read credentials of %s: %w", host, err)
+	}
+	reg.Client = authClient(store)
+	if err := reg.Ping(ctx); err != nil {
+		return cred != auth.EmptyCredential, fmt.Errorf("ping %s: %w", host, err)
+	}
+	return cred != auth.EmptyCredential, nil
+}
+
+// IsUnauthorized reports whether err is a registry response rejecting the
+// credentials used.
+func IsUnauthorized(err error) bool {
+	var resp *errcode.ErrorResponse
+	if !errors.As(err, &resp) {
+		return false
+	}
+	return resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden
+}
+
+// PushChart uploads a packaged chart with its metadata as config, and any
+// extra layers after the archive, and tags the manifest. The tag defaults
+// to the reference's tag.
+func PushChart(ctx context.Context, ref string, archive []byte, metadata any, annotations map[string]string, extra ...Layer) (ocispec.Descriptor, error) {
+	repo, err := Repository(ref)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	tag := repo.Reference.Reference
+	if tag == "" {
+		return ocispec.Descriptor{}, fmt.Errorf("reference %s has no tag", ref)
+	}
+
+	config, err := json.Marshal(metadata)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("encode chart config: %w", err)
+	}
+	configDesc := content.NewDescriptorFromBytes(MediaTypeConfig, config)
+	if err := repo.Push(ctx, configDesc, bytes.NewReader(config)); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("push chart config: %w", err)
+	}
+
+	layer := content.NewDescriptorFromBytes(MediaTypeChartData, archive)
+	if err := repo.Push(ctx, layer, bytes.NewReader(archive)); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("push chart content: %w", err)
+	}
+	layers := []ocispec.Descriptor{layer}
+	for _, l := range extra {
+		desc := content.NewDescriptorFromBytes(l.MediaType, l.Data)
+		if err := repo.Push(ctx, desc, bytes.NewReader(l.Data)); err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("push %s: %w", l.MediaType, err)
+		}
+		layers = append(layers, desc)
+	}
+
+	manifest, err := oras.PackManifest(ctx, repo, oras.PackManifestVersion1_1, ArtifactTypeChart, oras.PackManifestOptions{
+		Layers:              layers,
+		ConfigDescriptor:    &configDesc,
+		ManifestAnnotations: annotations,
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("push manifest: %w", err)
+	}
+	if err := repo.Tag(ctx, manifest, tag); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("tag %s: %w", tag, err)
+	}
+	return manifest, nil
+}
+
+// Pull resolves ref and returns the content of its first layer. It is used
+// both for charts and for plain files pushed with oras.
+func Pull(ctx context.Context, ref string) (*Artifact, error) {
+	repo, err := Repository(ref)
+	if err != nil {
+		return nil, err
+	}
+	target := repo.Reference.Reference
+	if target == "" {
+		return nil, fmt.Errorf("reference %s has no tag or digest", ref)
+	}
+
+	desc, err := repo.Resolve(ctx, target)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", ref, err)
+	}
+	raw, err := content.FetchAll(ctx, repo, desc)
+	if err != nil {
+		return nil, fmt.Errorf("fetch manifest %s: %w", ref, err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return nil, fmt.Errorf("decode manifest %s: %w", ref, err)
+	}
+	if len(manifest.Layers) == 0 {
+		return nil, errors.New("manifest has no layers")
+	}
+	data, err := content.FetchAll(ctx, repo.Blobs(), manifest.Layers[0])
+	if err != nil {
+		return nil, fmt.Errorf("fetch layer of %s: %w", ref, err)
+	}
+	return &Artifact{Ref: ref, Digest: desc.Digest.String(), Data: data, Manifest: manifest}, nil
+}
+
+// PullLayer fetches the first layer of the pulled artifact a with the
+// media type, returning nil when it has none.
+func PullLayer(ctx context.Context, a *Artifact, mediaType string) ([]byte, error) {
+	for _, l := range a.Manifest.Layers {
+		if l.MediaType != mediaType {
+			continue
+		}
+		repo, err := Repository(a.Ref)
+		if err != nil {
+			return nil, err
+		}
+		data, err := content.FetchAll(ctx, repo.Blobs(), l)
+		if err != nil {
+			return nil, fmt.Errorf("fetch %s of %s: %w", mediaType, a.Ref, err)
+		}
+		return data, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,60 @@
+package paths
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// ConfigDir returns the tmpl configuration directory, $TMPL_CONFIG_DIR or
+// <user config dir>/tmpl.
+func ConfigDir() (string, error) {
+	if dir := os.Getenv("TMPL_CONFIG_DIR"); dir != "" {
+		return dir, nil
+	}
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "tmpl"), nil
+}
+
+// cacheDir is the configured cache directory, see SetCacheDir.
+var cacheDir string
+
+// SetCacheDir sets the directory CacheDir returns when $TMPL_CACHE_DIR is
+// unset, e.g. from the configuration file.
+func SetCacheDir(dir string) {
+	cacheDir = dir
+}
+
+// CacheDir returns the tmpl cache directory, $TMPL_CACHE_DIR, the
+// directory set with SetCacheDir or <user cache dir>/tmpl.
+func CacheDir() (string, error) {
+	if dir := os.Getenv("TMPL_CACHE_DIR"); dir != "" {
+		return dir, nil
+	}
+	if cacheDir != "" {
+		return cacheDir, nil
+	}
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "tmpl"), nil
+}
+
+// StateDir returns the tmpl state directory, $TMPL_STATE_DIR,
+// $XDG_STATE_HOME/tmpl or ~/.local/state/tmpl.
+func StateDir() (string, error) {
+	if dir := os.Getenv("TMPL_STATE_DIR"); dir != "" {
+		return dir, nil
+	}
+	if base := os.Getenv("XDG_STATE_HOME"); base != "" {
+		return filepath.Join(base, "tmpl"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "tmpl"), nil
+}
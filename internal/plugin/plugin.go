@@ -0,0 +1,189 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/paths"
+)
+
+// Prefix starts the name of plugin executables: tmpl-NAME is run for
+// 'tmpl NAME'.
+const Prefix = "tmpl-"
+
+// ManifestFile describes a plugin installed in a directory of Dir.
+const ManifestFile = "plugin.yaml"
+
+// APIVersion identifies the format of the Context a plugin receives.
+const APIVersion = "tmpl.plugin/v1"
+
+// Plugin is an executable run as a tmpl subcommand.
+type Plugin struct {
+	Name string `yaml:"name" json:"name"`
+	// Usage is the one-line help of the subcommand.
+	Usage string `yaml:"usage" json:"usage,omitempty"`
+	// Command is the executable, relative to the manifest directory for
+	// plugins installed with a manifest.
+	Command string `yaml:"command" json:"command"`
+}
+
+// Dir returns the plugin directory, $TMPL_PLUGINS_DIR or the plugins
+// directory of the configuration directory.
+func Dir() (string, error) {
+	if dir := os.Getenv("TMPL_PLUGINS_DIR"); dir != "" {
+		return dir, nil
+	}
+	dir, err := paths.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "plugins"), nil
+}
+
+// Discover finds the plugins of the plugin directory and of the
+// directories of $PATH. In the plugin directory a plugin is a tmpl-NAME
+// executable or a directory with a plugin.yaml manifest. The first plugin
+// found for a name wins, so the plugin directory shadows $PATH. Plugins
+// are returned sorted by name.
+func Discover() ([]Plugin, error) {
+	found := map[string]Plugin{}
+	add := func(p Plugin) {
+		if _, ok := found[p.Name]; !ok && p.Name != "" {
+			found[p.Name] = p
+		}
+	}
+
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read plugin directory: %w", err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		p, err := loadManifest(filepath.Join(dir, e.Name()))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		add(p)
+	}
+
+	dirs := append([]string{dir}, filepath.SplitList(os.Getenv("PATH"))...)
+	for _, d := range dirs {
+		entries, err := os.ReadDir(d)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name, ok := strings.CutPrefix(e.Name(), Prefix)
+			if !ok || e.IsDir() || !isExecutable(filepath.Join(d, e.Name())) {
+				continue
+			}
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+			add(Plugin{Name: name, Command: filepath.Join(d, e.Name())})
+		}
+	}
+
+	plugins := make([]Plugin, 0, len(found))
+	for _, p := range found {
+		plugins = append(plugins, p)
+	}
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Name < plugins[j].Name })
+	return plugins, nil
+}
+
+// loadManifest reads the plugin.yaml of a plugin directory.
+func loadManifest(dir string) (Plugin, error) {
+	path := filepath.Join(dir, ManifestFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Plugin{}, err
+	}
+	var p Plugin
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return Plugin{}, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if p.Name == "" {
+		p.Name = filepath.Base(dir)
+	}
+	if p.Command == "" {
+		return Plugin{}, fmt.Errorf("%s: command is required", path)
+	}
+	if !filepath.IsAbs(p.Command) {
+		p.Command = filepath.Join(dir, p.Command)
+	}
+	return p, nil
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0o111 != 0
+}
+
+// Context is the JSON document a plugin reads from its standard input.
+type Context struct {
+	APIVersion string `json:"apiVersion"`
+	// Name is the plugin name, as invoked.
+	Name string   `json:"name"`
+	Args []string `json:"args"`
+	// Binary is the tmpl executable, for plugins that call back into it.
+	Binary  string `json:"binary,omitempty"`
+	Version string `json:"version"`
+	// Options holds the global flags tmpl was run with.
+	Options map[string]any `json:"options"`
+	// Config is the user configuration.
+	Config     any    `json:"config"`
+	ConfigPath string `json:"configPath,omitempty"`
+	CacheDir   string `json:"cacheDir,omitempty"`
+}
+
+// ExitError is a plugin that exited with a non-zero status.
+type ExitError struct {
+	Name string
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("plugin %s exited with status %d", e.Name, e.Code)
+}
+
+// Run executes p with args, writing c as JSON to its standard input. env
+// is added to the environment of tmpl.
+func Run(ctx context.Context, p Plugin, c *Context, env []string, stdout, stderr io.Writer) error {
+	input, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("encode plugin context: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, p.Command, c.Args...)
+	cmd.Stdin = strings.NewReader(string(input) + "\n")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = append(os.Environ(), env...)
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return &ExitError{Name: p.Name, Code: exitErr.ExitCode()}
+	}
+	if err != nil {
+		return fmt.Errorf("run plugin %s: %w", p.Name, err)
+	}
+	return nil
+}
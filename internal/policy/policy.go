@@ -0,0 +1,158 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+)
+
+// DefaultNamespace is the Rego package evaluated when none is configured.
+const DefaultNamespace = "tmpl"
+
+// Level classifies a policy violation.
+type Level string
+
+const (
+	LevelDeny Level = "deny"
+	LevelWarn Level = "warn"
+)
+
+// Input is the document policies are evaluated against. Rego rules see it
+// as input.values, input.stack and input.chart.
+type Input struct {
+	Values map[string]any `json:"values"`
+	Stack  []any          `json:"stack"`
+	Chart  any            `json:"chart,omitempty"`
+	// Extra carries command specific data, such as the plan tmpl plan
+	// computed as input.extra.plan.
+	Extra map[string]any `json:"extra,omitempty"`
+}
+
+// Violation is a single message produced by a deny or warn rule.
+type Violation struct {
+	Level   Level  `json:"level"`
+	Message string `json:"message"`
+	// Service optionally names the service the violation refers to.
+	Service string `json:"service,omitempty"`
+}
+
+// Config selects policy bundles and the package to query.
+type Config struct {
+	// Paths are .rego files or bundle directories passed to opa as data.
+	Paths     []string
+	Namespace string
+}
+
+// Evaluator abstracts policy evaluation to facilitate testing.
+type Evaluator interface {
+	Evaluate(ctx context.Context, input Input) ([]Violation, error)
+}
+
+type execEvaluator struct {
+	cfg Config
+}
+
+// New constructs an Evaluator backed by the opa CLI.
+func New(cfg Config) (Evaluator, error) {
+	if len(cfg.Paths) == 0 {
+		return nil, errors.New("no policy paths configured")
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = DefaultNamespace
+	}
+	if _, err := exec.LookPath("opa"); err != nil {
+		return nil, fmt.Errorf("opa binary not found: %w", err)
+	}
+	return &execEvaluator{cfg: cfg}, nil
+}
+
+// Evaluate queries data.<namespace> and collects its deny and warn rules.
+// Rule elements may be strings or objects with msg and optional service.
+func (e *execEvaluator) Evaluate(ctx context.Context, input Input) ([]Violation, error) {
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("encode policy input: %w", err)
+	}
+
+	args := []string{"eval", "--format", "json", "--stdin-input"}
+	for _, path := range e.cfg.Paths {
+		args = append(args, "--data", path)
+	}
+	args = append(args, "data."+e.cfg.Namespace)
+
+	cmd := exec.CommandContext(ctx, "opa", args...)
+	cmd.Stdin = bytes.NewReader(payload)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("opa eval: %w: %s", err, stderr.String())
+	}
+	return parseResult(out)
+}
+
+type evalResult struct {
+	Result []struct {
+		Expressions []struct {
+			Value map[string]json.RawMessage `json:"value"`
+		} `json:"expressions"`
+	} `json:"result"`
+}
+
+type violationObject struct {
+	Msg     string `json:"msg"`
+	Service string `json:"service"`
+}
+
+func parseResult(data []byte) ([]Violation, error) {
+	var res evalResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("decode opa result: %w", err)
+	}
+
+	var violations []Violation
+	for _, r := range res.Result {
+		for _, expr := range r.Expressions {
+			for _, level := range []Level{LevelDeny, LevelWarn} {
+				raw, ok := expr.Value[string(level)]
+				if !ok {
+					continue
+				}
+				var items []json.RawMessage
+				if err := json.Unmarshal(raw, &items); err != nil {
+					return nil, fmt.Errorf("policy rule %s must be a set or array: %w", level, err)
+				}
+				for _, item := range items {
+					v, err := parseViolation(level, item)
+					if err != nil {
+						return nil, err
+					}
+					violations = append(violations, v)
+				}
+			}
+		}
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Message < violations[j].Message
+	})
+	return violations, nil
+}
+
+func parseViolation(level Level, raw json.RawMessage) (Violation, error) {
+	var msg string
+	if err := json.Unmarshal(raw, &msg); err == nil {
+		return Violation{Level: level, Message: msg}, nil
+	}
+	var obj violationObject
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return Violation{}, fmt.Errorf("decode policy %s message: %w", level, err)
+	}
+	if obj.Msg == "" {
+		return Violation{}, fmt.Errorf("policy %s message is missing msg: %s", level, string(raw))
+	}
+	return Violation{Level: level, Message: obj.Msg, Service: obj.Service}, nil
+}
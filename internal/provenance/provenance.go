@@ -0,0 +1,165 @@
+// Package provenance describes where a packaged chart comes from, and signs
+// and verifies that description with cosign or PGP.
+package provenance
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Suffixes of the provenance file of an archive and of its signature.
+const (
+	FileSuffix      = ".prov"
+	SignatureSuffix = ".sig"
+)
+
+// Provenance is the content of the provenance file written next to a
+// packaged chart.
+type Provenance struct {
+	Chart   Chart     `yaml:"chart" json:"chart"`
+	Source  *Source   `yaml:"source,omitempty" json:"source,omitempty"`
+	Builder Builder   `yaml:"builder" json:"builder"`
+	Created time.Time `yaml:"created" json:"created"`
+}
+
+// Chart identifies the packaged chart.
+type Chart struct {
+	Name    string `yaml:"name" json:"name"`
+	Version string `yaml:"version" json:"version"`
+	// Digest is the sha256 digest of the archive.
+	Digest string `yaml:"digest" json:"digest"`
+}
+
+// Source is the git checkout a chart was packaged from.
+type Source struct {
+	Repository string `yaml:"repository,omitempty" json:"repository,omitempty"`
+	Commit     string `yaml:"commit" json:"commit"`
+	// Dirty is set when the checkout had uncommitted changes.
+	Dirty bool `yaml:"dirty,omitempty" json:"dirty,omitempty"`
+}
+
+// Builder describes what packaged the chart.
+type Builder struct {
+	Name     string `yaml:"name" json:"name"`
+	Version  string `yaml:"version" json:"version"`
+	Platform string `yaml:"platform" json:"platform"`
+	Host     string `yaml:"host,omitempty" json:"host,omitempty"`
+}
+
+// Path returns the path of the provenance file of the archive at path.
+func Path(archive string) string {
+	return archive + FileSuffix
+}
+
+// SignaturePath returns the path of the signature of the provenance file
+// of the archive at path.
+func SignaturePath(archive string) string {
+	return Path(archive) + SignatureSuffix
+}
+
+// Marshal encodes p as YAML, the format of provenance files.
+func (p *Provenance) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(p); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Parse decodes a provenance file.
+func Parse(data []byte) (*Provenance, error) {
+	var p Provenance
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("decode provenance: %w", err)
+	}
+	if p.Chart.Digest == "" {
+		return nil, errors.New("provenance names no chart digest")
+	}
+	return &p, nil
+}
+
+// Check verifies that p describes the archive with digest.
+func (p *Provenance) Check(digest string) error {
+	if p.Chart.Digest != digest {
+		return fmt.Errorf("provenance is for digest %s, archive is %s", p.Chart.Digest, digest)
+	}
+	return nil
+}
+
+// Files are the provenance file of an archive and its signature, as read
+// next to it or fetched with it.
+type Files struct {
+	Provenance []byte
+	// Signature is empty for unsigned provenance.
+	Signature []byte
+}
+
+// ReadFiles reads the provenance files next to the archive at path. A
+// missing signature leaves Files.Signature empty.
+func ReadFiles(archive string) (Files, error) {
+	var files Files
+	var err error
+	if files.Provenance, err = os.ReadFile(Path(archive)); err != nil {
+		return Files{}, fmt.Errorf("read provenance: %w", err)
+	}
+	files.Signature, err = os.ReadFile(SignaturePath(archive))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Files{}, fmt.Errorf("read provenance signature: %w", err)
+	}
+	return files, nil
+}
+
+// Write writes the files next to the archive at path, removing the
+// signature of an earlier package when they are unsigned.
+func (f Files) Write(archive string) error {
+	if err := os.WriteFile(Path(archive), f.Provenance, 0o644); err != nil {
+		return fmt.Errorf("write provenance: %w", err)
+	}
+	if len(f.Signature) == 0 {
+		if err := os.Remove(SignaturePath(archive)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove provenance signature: %w", err)
+		}
+		return nil
+	}
+	if err := os.WriteFile(SignaturePath(archive), f.Signature, 0o644); err != nil {
+		return fmt.Errorf("write provenance signature: %w", err)
+	}
+	return nil
+}
+
+// pgpArmor starts armored PGP signatures.
+const pgpArmor = "-----BEGIN PGP SIGNATURE-----"
+
+// Verify parses the provenance and checks that it describes the archive
+// with digest and that its signature was made with key. Without a key,
+// PGP signatures are accepted from any key the gpg keyring trusts.
+func (f Files) Verify(ctx context.Context, digest string, key *Key) (*Provenance, error) {
+	p, err := Parse(f.Provenance)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Check(digest); err != nil {
+		return nil, err
+	}
+	if key == nil {
+		if len(f.Signature) > 0 && !bytes.HasPrefix(bytes.TrimSpace(f.Signature), []byte(pgpArmor)) {
+			return nil, fmt.Errorf("provenance has a cosign signature, verifying it needs its public key as %s:KEY", KeyCosign)
+		}
+		key = &Key{Type: KeyPGP}
+	}
+	if err := Verify(ctx, *key, f.Provenance, f.Signature); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,183 @@
+package provenance
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Types of signing keys.
+const (
+	KeyCosign = "cosign"
+	KeyPGP    = "pgp"
+)
+
+// Key selects how provenance is signed or verified, as TYPE:KEY:
+//
+//   - cosign:KEY names a cosign key: a key file, or a KMS URI such as
+//     awskms:///alias/charts. Signing takes the private key, verifying
+//     the public one.
+//   - pgp:ID names a key of the gpg keyring by ID, fingerprint or email.
+//     Signing uses it instead of the default key; verifying requires the
+//     signature to be made by it. A bare "pgp" accepts any key the
+//     keyring trusts.
+type Key struct {
+	Type string
+	Ref  string
+}
+
+// ParseKey parses a key specification.
+func ParseKey(spec string) (Key, error) {
+	typ, ref, _ := strings.Cut(spec, ":")
+	switch typ {
+	case KeyCosign:
+		if ref == "" {
+			return Key{}, fmt.Errorf("key %q names no cosign key", spec)
+		}
+	case KeyPGP:
+	default:
+		return Key{}, fmt.Errorf("unknown key type in %q, expected %s:KEY or %s[:ID]", spec, KeyCosign, KeyPGP)
+	}
+	return Key{Type: typ, Ref: ref}, nil
+}
+
+func (k Key) String() string {
+	if k.Ref == "" {
+		return k.Type
+	}
+	return k.Type + ":" + k.Ref
+}
+
+// binary returns the program signing and verifying with k.
+func (k Key) binary() string {
+	if k.Type == KeyCosign {
+		return "cosign"
+	}
+	return "gpg"
+}
+
+// Sign signs data, the content of a provenance file, and returns the
+// detached signature: a base64 cosign signature or an armored PGP one.
+func Sign(ctx context.Context, key Key, data []byte) ([]byte, error) {
+	dir, file, err := stage(data)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	sig := filepath.Join(dir, "signature")
+
+	var args []string
+	switch key.Type {
+	case KeyCosign:
+		args = []string{"sign-blob", "--yes", "--key", key.Ref, "--output-signature", sig, file}
+	case KeyPGP:
+		args = []string{"--batch", "--yes", "--armor", "--detach-sign", "--output", sig}
+		if key.Ref != "" {
+			args = append(args, "--local-user", key.Ref)
+		}
+		args = append(args, file)
+	}
+	if _, err := run(ctx, key.binary(), args...); err != nil {
+		return nil, fmt.Errorf("sign provenance: %w", err)
+	}
+	return os.ReadFile(sig)
+}
+
+// Verify checks that signature is a valid signature of data made with
+// key.
+func Verify(ctx context.Context, key Key, data, signature []byte) error {
+	if len(signature) == 0 {
+		return errors.New("provenance is not signed")
+	}
+	dir, file, err := stage(data)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	sig := filepath.Join(dir, "signature")
+	if err := os.WriteFile(sig, signature, 0o600); err != nil {
+		return err
+	}
+
+	switch key.Type {
+	case KeyCosign:
+		if _, err := run(ctx, "cosign", "verify-blob", "--key", key.Ref, "--signature", sig, file); err != nil {
+			return fmt.Errorf("verify provenance signature: %w", err)
+		}
+		return nil
+	case KeyPGP:
+		status, err := run(ctx, "gpg", "--batch", "--status-fd", "1", "--verify", sig, file)
+		if err != nil {
+			return fmt.Errorf("verify provenance signature: %w", err)
+		}
+		return checkSigner(status, key.Ref)
+	default:
+		return fmt.Errorf("unknown key type %q", key.Type)
+	}
+}
+
+// checkSigner checks that the gpg status output of a good signature names
+// id as the fingerprint, key ID or user ID of the signing key. An empty id
+// accepts any signer.
+func checkSigner(status []byte, id string) error {
+	if id == "" {
+		return nil
+	}
+	want := strings.ToUpper(strings.TrimPrefix(id, "0x"))
+	var signer string
+	sc := bufio.NewScanner(bytes.NewReader(status))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || fields[0] != "[GNUPG:]" {
+			continue
+		}
+		switch fields[1] {
+		case "GOODSIG":
+			signer = strings.Join(fields[3:], " ")
+			if strings.HasSuffix(want, strings.ToUpper(fields[2])) || strings.Contains(strings.ToUpper(signer), want) {
+				return nil
+			}
+		case "VALIDSIG":
+			if strings.HasSuffix(strings.ToUpper(fields[2]), want) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("provenance is signed by %q, not by %s", signer, id)
+}
+
+// stage writes data to a temporary directory for the signing tools, which
+// take files.
+func stage(data []byte) (dir, file string, err error) {
+	dir, err = os.MkdirTemp("", "tmpl-provenance-")
+	if err != nil {
+		return "", "", err
+	}
+	file = filepath.Join(dir, "provenance")
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	return dir, file, nil
+}
+
+// run runs a signing tool and returns its standard output.
+func run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return nil, fmt.Errorf("%s binary not found: %w", name, err)
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,183 @@
+package release
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileStore keeps each revision as a JSON file under DIR/<name>/.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store rooted at dir.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+func (s *FileStore) path(name string, revision int) string {
+	return filepath.Join(s.dir, name, fmt.Sprintf("%08d.json", revision))
+}
+
+// Create implements Store.
+func (s *FileStore) Create(_ context.Context, r *Release) error {
+	data, err := encode(r)
+	if err != nil {
+		return err
+	}
+	path := s.path(r.Name, r.Revision)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Get implements Store.
+func (s *FileStore) Get(_ context.Context, name string, revision int) (*Release, error) {
+	data, err := os.ReadFile(s.path(name, revision))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s revision %d", ErrNotFound, name, revision)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decode(data)
+}
+
+// List implements Store.
+func (s *FileStore) List(ctx context.Context, name string) ([]*Release, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var revisions []int
+	for _, e := range entries {
+		rev, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil || e.IsDir() {
+			continue
+		}
+		revisions = append(revisions, rev)
+	}
+	sort.Ints(revisions)
+
+	releases := make([]*Release, 0, len(revisions))
+	for _, rev := range revisions {
+		r, err := s.Get(ctx, name, rev)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+	return releases, nil
+}
+
+// Names implements Store.
+func (s *FileStore) Names(_ context.Context) ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		revisions, err := filepath.Glob(filepath.Join(s.dir, e.Name(), "[0-9]*.json"))
+		if err == nil && len(revisions) > 0 {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// SetStatus implements Store.
+func (s *FileStore) SetStatus(ctx context.Context, name string, revision int, status Status) error {
+	r, err := s.Get(ctx, name, revision)
+	if err != nil {
+		return err
+	}
+	r.Status = status
+	r.Updated = time.Now().UTC()
+	data, err := encode(r)
+	if err != nil {
+		return err
+	}
+	path := s.path(name, revision)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Delete implements Store.
+func (s *FileStore) Delete(_ context.Context, name string, revision int) error {
+	err := os.Remove(s.path(name, revision))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s revision %d", ErrNotFound, name, revision)
+	}
+	return err
+}
+
+func (s *FileStore) lockPath(name string) string {
+	return filepath.Join(s.dir, name, "lock.json")
+}
+
+// Lock implements Store.
+func (s *FileStore) Lock(_ context.Context, name string, info LockInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	path := s.lockPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, fs.ErrExist) {
+		var holder LockInfo
+		if data, rerr := os.ReadFile(path); rerr == nil {
+			_ = json.Unmarshal(data, &holder)
+		}
+		return &LockedError{Release: name, Info: holder}
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Unlock implements Store.
+func (s *FileStore) Unlock(_ context.Context, name string) error {
+	if err := os.Remove(s.lockPath(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package release
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+)
+
+// LockInfo describes the holder of a release lock.
+type LockInfo struct {
+	// Holder identifies who took the lock, as user@host.
+	Holder    string    `json:"holder"`
+	PID       int       `json:"pid"`
+	Operation string    `json:"operation,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// NewLockInfo describes the current process running operation.
+func NewLockInfo(operation string) LockInfo {
+	holder := "unknown"
+	if u, err := user.Current(); err == nil {
+		holder = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		holder += "@" + host
+	}
+	return LockInfo{Holder: holder, PID: os.Getpid(), Operation: operation, Created: time.Now().UTC()}
+}
+
+// LockedError is returned when a release is locked by another holder.
+type LockedError struct {
+	Release string
+	Info    LockInfo
+}
+
+func (e *LockedError) Error() string {
+	msg := fmt.Sprintf("release %s is locked by %s (pid %d) since %s", e.Release, e.Info.Holder, e.Info.PID,
+		e.Info.Created.Local().Format(time.RFC3339))
+	if e.Info.Operation != "" {
+		msg += " for " + e.Info.Operation
+	}
+	return msg + "; run 'tmpl unlock " + e.Release + "' if it is stale"
+}
+
+// DefaultLockInterval is how often Acquire retries a held lock.
+const DefaultLockInterval = 2 * time.Second
+
+// Acquire takes the lock of a release, waiting up to timeout while another
+// holder has it. The returned function releases the lock.
+func Acquire(ctx context.Context, store Store, name string, info LockInfo, timeout time.Duration) (func() error, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := store.Lock(ctx, name, info)
+		if err == nil {
+			return func() error {
+				// Release the lock even when ctx has ended.
+				return store.Unlock(context.WithoutCancel(ctx), name)
+			}, nil
+		}
+		var locked *LockedError
+		if !errors.As(err, &locked) || !time.Now().Before(deadline) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(DefaultLockInterval):
+		}
+	}
+}
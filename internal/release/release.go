@@ -0,0 +1,308 @@
+package release
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/logx"
+	"github.com/acebelowzero/tmpl/internal/paths"
+	"github.com/acebelowzero/tmpl/internal/sops"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// Status is the lifecycle state of a release revision.
+type Status string
+
+const (
+	StatusDeployed   Status = "deployed"
+	StatusSuperseded Status = "superseded"
+	StatusFailed     Status = "failed"
+	StatusRolledBack Status = "rolled-back"
+	// StatusUninstalled marks the last deployed revision of a release
+	// uninstalled with its history kept.
+	StatusUninstalled Status = "uninstalled"
+)
+
+// ErrNotFound is returned when a release or revision does not exist.
+var ErrNotFound = errors.New("release not found")
+
+// Release is one revision of a deployed stack.
+type Release struct {
+	Name string `json:"name"`
+	// Namespace is the --namespace the release was deployed in; Name
+	// includes it as a prefix.
+	Namespace string    `json:"namespace,omitempty"`
+	Revision  int       `json:"revision"`
+	Status    Status    `json:"status"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+	// Description is a free-form note, such as the reason for a deploy or
+	// for its failure.
+	Description string `json:"description,omitempty"`
+	// Annotations are key-value pairs given on apply, e.g. the ticket a
+	// revision was deployed for.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Chart       chart.Metadata    `json:"chart"`
+	// ChartDigest is the digest of the chart as 'tmpl package' would
+	// archive it.
+	ChartDigest  string `json:"chartDigest,omitempty"`
+	ValuesDigest string `json:"valuesDigest"`
+	// Sources lists where the chart and remote values came from.
+	Sources []Source `json:"sources,omitempty"`
+	// Manifest is the rendered compose document.
+	Manifest string `json:"manifest"`
+	// Notes is the rendered NOTES.txt of the chart.
+	Notes string `json:"notes,omitempty"`
+	// Values are the computed values the chart was rendered with and
+	// UserValues the subset supplied with -f, without chart defaults.
+	Values     map[string]any `json:"values,omitempty"`
+	UserValues map[string]any `json:"userValues,omitempty"`
+	// Hooks holds the rendered hook templates by name.
+	Hooks map[string]string `json:"hooks,omitempty"`
+	// Encrypted maps the values paths whose content was decrypted from
+	// sops files to those files. Unless the revision is encrypted, Values
+	// and UserValues hold references to the digests of this content in its
+	// place; see Redact.
+	Encrypted map[string]string `json:"encrypted,omitempty"`
+	// Stack is the converted stack that was applied, including config
+	// content so the revision can be restored. Secret payloads are left
+	// out; only their digests are stored.
+	Stack *stack.Stack `json:"stack,omitempty"`
+	// Sealed holds the manifest, notes, values, hooks and stack when the
+	// store encrypts them; see Open.
+	Sealed *Sealed `json:"sealed,omitempty"`
+}
+
+// Source is an input of a release and the revision that was used.
+type Source struct {
+	// Kind is "chart" or "values".
+	Kind     string `json:"kind" yaml:"kind"`
+	URL      string `json:"url" yaml:"url"`
+	Revision string `json:"revision,omitempty" yaml:"revision,omitempty"`
+}
+
+// Store persists release revisions.
+type Store interface {
+	// Create writes a new revision. It fails if the revision exists.
+	Create(ctx context.Context, r *Release) error
+	// Get returns a single revision.
+	Get(ctx context.Context, name string, revision int) (*Release, error)
+	// List returns every revision of a release ordered by revision.
+	List(ctx context.Context, name string) ([]*Release, error)
+	// Names returns the sorted names of the releases with revisions.
+	Names(ctx context.Context) ([]string, error)
+	// SetStatus changes the status of a revision.
+	SetStatus(ctx context.Context, name string, revision int, status Status) error
+	// Delete removes a revision.
+	Delete(ctx context.Context, name string, revision int) error
+	// Lock takes the exclusive lock of a release, failing with a
+	// *LockedError while another holder has it.
+	Lock(ctx context.Context, name string, info LockInfo) error
+	// Unlock releases the lock of a release whoever holds it. Unlocking a
+	// release that is not locked is not an error.
+	Unlock(ctx context.Context, name string) error
+}
+
+// Config selects a Store implementation.
+type Config struct {
+	// Location is "swarm" (the default), "file" for the local state
+	// directory, "file://DIR" or a path, or "s3://BUCKET/PREFIX".
+	Location string
+	// Docker is the engine used by the swarm store.
+	Docker *docker.Client
+	// Encrypt lists the sops keys, such as age:age1... or kms:ARN, new
+	// revisions are encrypted for. Empty stores them in plain.
+	Encrypt []string
+}
+
+// New opens the store described by cfg.
+func New(ctx context.Context, cfg Config) (Store, error) {
+	store, err := open(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Encrypt) == 0 {
+		return &plainStore{Store: store}, nil
+	}
+	if err := sops.ValidateKeys(cfg.Encrypt); err != nil {
+		return nil, err
+	}
+	return &sealingStore{Store: store, keys: cfg.Encrypt}, nil
+}
+
+func open(ctx context.Context, cfg Config) (Store, error) {
+	loc := cfg.Location
+	switch {
+	case loc == "" || loc == "swarm":
+		if cfg.Docker == nil {
+			return nil, fmt.Errorf("swarm release store requires a docker client")
+		}
+		return NewSwarmStore(cfg.Docker), nil
+	case strings.HasPrefix(loc, "s3://"):
+		return NewS3Store(ctx, loc)
+	case loc == "file":
+		dir, err := paths.StateDir()
+		if err != nil {
+			return nil, fmt.Errorf("resolve state dir: %w", err)
+		}
+		return NewFileStore(filepath.Join(dir, "releases")), nil
+	default:
+		return NewFileStore(strings.TrimPrefix(loc, "file://")), nil
+	}
+}
+
+// Append stores r as the next revision of its release. When r is deployed,
+// previously deployed revisions are marked superseded.
+func Append(ctx context.Context, store Store, r *Release) error {
+	existing, err := store.List(ctx, r.Name)
+	if err != nil {
+		return err
+	}
+	r.Revision = nextRevision(existing)
+	now := time.Now().UTC()
+	if r.Created.IsZero() {
+		r.Created = now
+	}
+	r.Updated = now
+	if err := store.Create(ctx, r); err != nil {
+		return fmt.Errorf("store revision %d of %s: %w", r.Revision, r.Name, err)
+	}
+	if r.Status != StatusDeployed {
+		return nil
+	}
+	for _, prev := range existing {
+		if prev.Status == StatusDeployed {
+			if err := store.SetStatus(ctx, r.Name, prev.Revision, StatusSuperseded); err != nil {
+				return fmt.Errorf("supersede revision %d of %s: %w", prev.Revision, r.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// NextRevision returns the revision Append assigns to the next release
+// named name.
+func NextRevision(ctx context.Context, store Store, name string) (int, error) {
+	existing, err := store.List(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	return nextRevision(existing), nil
+}
+
+func nextRevision(existing []*Release) int {
+	if n := len(existing); n > 0 {
+		return existing[n-1].Revision + 1
+	}
+	return 1
+}
+
+// Expired returns the revisions beyond the newest keep, oldest first.
+// Deployed revisions are never expired. A keep of zero keeps everything.
+func Expired(releases []*Release, keep int) []*Release {
+	if keep <= 0 || len(releases) <= keep {
+		return nil
+	}
+	var expired []*Release
+	for _, r := range releases[:len(releases)-keep] {
+		if r.Status != StatusDeployed {
+			expired = append(expired, r)
+		}
+	}
+	return expired
+}
+
+// Prune deletes the revisions of a release that Expired reports for keep
+// and returns them.
+func Prune(ctx context.Context, store Store, name string, keep int) ([]*Release, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+	releases, err := store.List(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	expired := Expired(releases, keep)
+	for i, r := range expired {
+		if err := store.Delete(ctx, name, r.Revision); err != nil {
+			return expired[:i], fmt.Errorf("delete revision %d of %s: %w", r.Revision, name, err)
+		}
+	}
+	return expired, nil
+}
+
+// PruneHistory prunes the revisions of a release beyond historyMax after
+// one was recorded. A failure is only logged, the release itself was
+// recorded.
+func PruneHistory(ctx context.Context, store Store, name string, historyMax int) {
+	pruned, err := Prune(ctx, store, name, historyMax)
+	log := logx.FromContext(ctx)
+	if err != nil {
+		log.Warn("could not prune release history", "stack", name, "error", err)
+	}
+	if len(pruned) > 0 {
+		log.Debug("pruned release history", "stack", name, "revisions", len(pruned))
+	}
+}
+
+// LabelOwner marks the objects of the stack of r as owned by its release
+// at the revision it will be recorded as.
+func LabelOwner(ctx context.Context, store Store, r *Release) error {
+	revision, err := NextRevision(ctx, store, r.Name)
+	if err != nil {
+		return err
+	}
+	chartRef := r.Chart.Name
+	if r.Chart.Version != "" {
+		chartRef += "-" + r.Chart.Version
+	}
+	r.Stack.SetOwner(revision, chartRef)
+	return nil
+}
+
+// Latest returns the newest revision of a release.
+func Latest(ctx context.Context, store Store, name string) (*Release, error) {
+	releases, err := store.List(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(releases) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return releases[len(releases)-1], nil
+}
+
+// ValuesDigest identifies a set of merged values.
+func ValuesDigest(values map[string]any) (string, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("encode values: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+func encode(r *Release) ([]byte, error) {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encode release %s: %w", r.Name, err)
+	}
+	return data, nil
+}
+
+func decode(data []byte) (*Release, error) {
+	var r Release
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("decode release: %w", err)
+	}
+	return &r, nil
+}
@@ -0,0 +1,221 @@
+package release
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3Store keeps each revision as a JSON object under
+// s3://BUCKET/PREFIX/<name>/.
+type S3Store struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Store returns a store for an s3://BUCKET/PREFIX location using the
+// default AWS credential chain.
+func NewS3Store(ctx context.Context, location string) (*S3Store, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("parse release store %s: %w", location, err)
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load aws config: %w", err)
+	}
+	return &S3Store{
+		client: s3.NewFromConfig(cfg),
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+	}, nil
+}
+
+func (s *S3Store) key(name string, revision int) string {
+	return path.Join(s.prefix, name, fmt.Sprintf("%08d.json", revision))
+}
+
+// Create implements Store.
+func (s *S3Store) Create(ctx context.Context, r *Release) error {
+	if _, err := s.Get(ctx, r.Name, r.Revision); err == nil {
+		return fmt.Errorf("revision %d of %s already exists", r.Revision, r.Name)
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return s.put(ctx, r)
+}
+
+func (s *S3Store) put(ctx context.Context, r *Release) error {
+	data, err := encode(r)
+	if err != nil {
+		return err
+	}
+	key := s.key(r.Name, r.Revision)
+	contentType := "application/json"
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &s.bucket,
+		Key:         &key,
+		Body:        bytes.NewReader(data),
+		ContentType: &contentType,
+	})
+	if err != nil {
+		return fmt.Errorf("put s3://%s/%s: %w", s.bucket, key, err)
+	}
+	return nil
+}
+
+// Get implements Store.
+func (s *S3Store) Get(ctx context.Context, name string, revision int) (*Release, error) {
+	key := s.key(name, revision)
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &s.bucket, Key: &key})
+	if err != nil {
+		var noKey *types.NoSuchKey
+		if errors.As(err, &noKey) {
+			return nil, fmt.Errorf("%w: %s revision %d", ErrNotFound, name, revision)
+		}
+		return nil, fmt.Errorf("get s3://%s/%s: %w", s.bucket, key, err)
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decode(data)
+}
+
+// List implements Store.
+func (s *S3Store) List(ctx context.Context, name string) ([]*Release, error) {
+	prefix := path.Join(s.prefix, name) + "/"
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{Bucket: &s.bucket, Prefix: &prefix})
+	var revisions []int
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list s3://%s/%s: %w", s.bucket, prefix, err)
+		}
+		for _, obj := range page.Contents {
+			if obj.Key == nil {
+				continue
+			}
+			rev, err := strconv.Atoi(strings.TrimSuffix(path.Base(*obj.Key), ".json"))
+			if err == nil {
+				revisions = append(revisions, rev)
+			}
+		}
+	}
+	sort.Ints(revisions)
+
+	releases := make([]*Release, 0, len(revisions))
+	for _, rev := range revisions {
+		r, err := s.Get(ctx, name, rev)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+	return releases, nil
+}
+
+// Names implements Store. Every prefix below the store counts as a
+// release.
+func (s *S3Store) Names(ctx context.Context) ([]string, error) {
+	prefix := s.prefix + "/"
+	if s.prefix == "" {
+		prefix = ""
+	}
+	delimiter := "/"
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{Bucket: &s.bucket, Prefix: &prefix, Delimiter: &delimiter})
+	var names []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list s3://%s/%s: %w", s.bucket, prefix, err)
+		}
+		for _, p := range page.CommonPrefixes {
+			if p.Prefix != nil {
+				names = append(names, strings.TrimSuffix(strings.TrimPrefix(*p.Prefix, prefix), "/"))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// SetStatus implements Store.
+func (s *S3Store) SetStatus(ctx context.Context, name string, revision int, status Status) error {
+	r, err := s.Get(ctx, name, revision)
+	if err != nil {
+		return err
+	}
+	r.Status = status
+	r.Updated = time.Now().UTC()
+	return s.put(ctx, r)
+}
+
+// Delete implements Store.
+func (s *S3Store) Delete(ctx context.Context, name string, revision int) error {
+	key := s.key(name, revision)
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &s.bucket, Key: &key}); err != nil {
+		return fmt.Errorf("delete s3://%s/%s: %w", s.bucket, key, err)
+	}
+	return nil
+}
+
+func (s *S3Store) lockKey(name string) string {
+	return path.Join(s.prefix, name, "lock.json")
+}
+
+// Lock implements Store. The lock object is written with If-None-Match so
+// that only one writer can create it.
+func (s *S3Store) Lock(ctx context.Context, name string, info LockInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	key := s.lockKey(name)
+	contentType := "application/json"
+	ifNoneMatch := "*"
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &s.bucket,
+		Key:         &key,
+		Body:        bytes.NewReader(data),
+		ContentType: &contentType,
+		IfNoneMatch: &ifNoneMatch,
+	})
+	if err == nil {
+		return nil
+	}
+	out, gerr := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &s.bucket, Key: &key})
+	if gerr != nil {
+		return fmt.Errorf("lock s3://%s/%s: %w", s.bucket, key, err)
+	}
+	defer out.Body.Close()
+	var holder LockInfo
+	if current, rerr := io.ReadAll(out.Body); rerr == nil {
+		_ = json.Unmarshal(current, &holder)
+	}
+	return &LockedError{Release: name, Info: holder}
+}
+
+// Unlock implements Store.
+func (s *S3Store) Unlock(ctx context.Context, name string) error {
+	key := s.lockKey(name)
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &s.bucket, Key: &key}); err != nil {
+		return fmt.Errorf("delete s3://%s/%s: %w", s.bucket, key, err)
+	}
+	return nil
+}
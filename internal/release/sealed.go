@@ -0,0 +1,190 @@
+package release
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/acebelowzero/tmpl/internal/sops"
+	"github.com/acebelowzero/tmpl/internal/stack"
+	"github.com/acebelowzero/tmpl/internal/values"
+)
+
+// EnvEncrypt holds comma-separated keys release content is encrypted
+// for, taking precedence over the releases.encrypt setting.
+const EnvEncrypt = "TMPL_RELEASE_ENCRYPT"
+
+// Sealed is the encrypted content of a release revision: its manifest,
+// notes, values, hooks and stack. The content is encrypted with a random
+// AES-256-GCM data key, and the data key with sops for the configured age,
+// PGP or KMS keys, so any of them can open it.
+type Sealed struct {
+	// Key is the sops document holding the data key.
+	Key   string `json:"key"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// content holds the fields of a release that may contain secrets.
+type content struct {
+	Manifest   string            `json:"manifest"`
+	Notes      string            `json:"notes,omitempty"`
+	Values     map[string]any    `json:"values,omitempty"`
+	UserValues map[string]any    `json:"userValues,omitempty"`
+	Hooks      map[string]string `json:"hooks,omitempty"`
+	Stack      *stack.Stack      `json:"stack,omitempty"`
+}
+
+// EncryptKeys returns the keys of $TMPL_RELEASE_ENCRYPT, or configured
+// when it is unset.
+func EncryptKeys(configured []string) []string {
+	env := os.Getenv(EnvEncrypt)
+	if env == "" {
+		return configured
+	}
+	var keys []string
+	for _, key := range strings.Split(env, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Seal encrypts the content of r for keys, clearing the plain fields.
+// Metadata such as the name, status, chart and digests stays readable.
+func Seal(ctx context.Context, r *Release, keys []string) error {
+	if r.Sealed != nil {
+		return nil
+	}
+	plain, err := json.Marshal(content{
+		Manifest:   r.Manifest,
+		Notes:      r.Notes,
+		Values:     r.Values,
+		UserValues: r.UserValues,
+		Hooks:      r.Hooks,
+		Stack:      r.Stack,
+	})
+	if err != nil {
+		return fmt.Errorf("encode release %s: %w", r.Name, err)
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	wrapped, err := sops.Encrypt(ctx, dataKey, keys)
+	if err != nil {
+		return fmt.Errorf("encrypt release %s: %w", r.Name, err)
+	}
+	r.Sealed = &Sealed{
+		Key:   string(wrapped),
+		Nonce: nonce,
+		Data:  aead.Seal(nil, nonce, plain, []byte(r.Name)),
+	}
+	r.Manifest, r.Notes, r.Hooks, r.Stack = "", "", nil, nil
+	r.Values, r.UserValues = nil, nil
+	return nil
+}
+
+// Open decrypts the content of a sealed release in place. Releases that
+// are not sealed are left as they are.
+func Open(ctx context.Context, r *Release) error {
+	if r.Sealed == nil {
+		return nil
+	}
+	dataKey, err := sops.DecryptDocument(ctx, []byte(r.Sealed.Key))
+	if err != nil {
+		return fmt.Errorf("revision %d of %s is encrypted and none of its keys is available, "+
+			"e.g. set SOPS_AGE_KEY_FILE or cloud credentials: %w", r.Revision, r.Name, err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	plain, err := aead.Open(nil, r.Sealed.Nonce, r.Sealed.Data, []byte(r.Name))
+	if err != nil {
+		return fmt.Errorf("decrypt revision %d of %s: %w", r.Revision, r.Name, err)
+	}
+	var c content
+	if err := json.Unmarshal(plain, &c); err != nil {
+		return fmt.Errorf("decode revision %d of %s: %w", r.Revision, r.Name, err)
+	}
+	r.Manifest, r.Notes, r.Hooks, r.Stack = c.Manifest, c.Notes, c.Hooks, c.Stack
+	r.Values, r.UserValues = c.Values, c.UserValues
+	r.Sealed = nil
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("data key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealingStore seals the revisions it creates. Revisions are returned
+// sealed, to be opened with Open by the commands that need their content.
+type sealingStore struct {
+	Store
+	keys []string
+}
+
+func (s *sealingStore) Create(ctx context.Context, r *Release) error {
+	// Seal a copy, callers keep using the content of r.
+	c := *r
+	Redact(&c, true)
+	if err := Seal(ctx, &c, s.keys); err != nil {
+		return err
+	}
+	return s.Store.Create(ctx, &c)
+}
+
+// plainStore stores revisions unencrypted, without the secrets they hold;
+// see Redact.
+type plainStore struct {
+	Store
+}
+
+func (s *plainStore) Create(ctx context.Context, r *Release) error {
+	c := *r
+	Redact(&c, false)
+	return s.Store.Create(ctx, &c)
+}
+
+// Encrypting reports whether store encrypts the revisions it creates.
+func Encrypting(store Store) bool {
+	_, ok := store.(*sealingStore)
+	return ok
+}
+
+// Redact leaves the secrets of r out of it before it is stored: the
+// payloads of the secrets of its stack, of which the digests are kept, and,
+// unless keepValues is set because the revision is encrypted, the values
+// decrypted from the files of Encrypted, which are replaced by references
+// to their digests. Restoring the revision reads them again from the chart
+// and the encrypted files. What templates rendered from decrypted values
+// outside secrets, into the manifest, stack, hooks and notes, is kept: the
+// revision is restored from it.
+func Redact(r *Release, keepValues bool) {
+	if r.Stack != nil {
+		r.Stack = r.Stack.WithoutSecretData()
+	}
+	if !keepValues {
+		r.Values = values.Redact(r.Values, r.Encrypted)
+		r.UserValues = values.Redact(r.UserValues, r.Encrypted)
+	}
+}
@@ -0,0 +1,213 @@
+package release
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+)
+
+// Labels set on the swarm configs that hold release revisions.
+const (
+	LabelRelease  = "tmpl.release"
+	LabelRevision = "tmpl.revision"
+	LabelStatus   = "tmpl.status"
+	// LabelLock marks the config holding the lock of a release.
+	LabelLock = "tmpl.lock"
+)
+
+// SwarmStore keeps each revision as a gzipped swarm config, so release
+// state lives with the cluster. Configs are immutable, so the status is
+// tracked in a label that can be updated in place.
+type SwarmStore struct {
+	client *docker.Client
+}
+
+// NewSwarmStore returns a store backed by swarm configs.
+func NewSwarmStore(client *docker.Client) *SwarmStore {
+	return &SwarmStore{client: client}
+}
+
+func configName(name string, revision int) string {
+	return fmt.Sprintf("tmpl-release.%s.v%d", name, revision)
+}
+
+// Create implements Store.
+func (s *SwarmStore) Create(ctx context.Context, r *Release) error {
+	data, err := encode(r)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	_, err = s.client.CreateConfig(ctx, docker.ObjectSpec{
+		Name: configName(r.Name, r.Revision),
+		Labels: map[string]string{
+			LabelRelease:  r.Name,
+			LabelRevision: strconv.Itoa(r.Revision),
+			LabelStatus:   string(r.Status),
+		},
+		Data: buf.Bytes(),
+	})
+	return err
+}
+
+// Get implements Store.
+func (s *SwarmStore) Get(ctx context.Context, name string, revision int) (*Release, error) {
+	cfg, err := s.find(ctx, name, revision)
+	if err != nil {
+		return nil, err
+	}
+	return fromConfig(cfg)
+}
+
+// List implements Store.
+func (s *SwarmStore) List(ctx context.Context, name string) ([]*Release, error) {
+	configs, err := s.client.ListConfigs(ctx, docker.Filters{}.Label(LabelRelease+"="+name))
+	if err != nil {
+		return nil, fmt.Errorf("list releases of %s: %w", name, err)
+	}
+	releases := make([]*Release, 0, len(configs))
+	for _, cfg := range configs {
+		r, err := fromConfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("config %s: %w", cfg.Spec.Name, err)
+		}
+		releases = append(releases, r)
+	}
+	sort.Slice(releases, func(i, j int) bool { return releases[i].Revision < releases[j].Revision })
+	return releases, nil
+}
+
+// Names implements Store.
+func (s *SwarmStore) Names(ctx context.Context) ([]string, error) {
+	configs, err := s.client.ListConfigs(ctx, docker.Filters{}.Label(LabelRelease))
+	if err != nil {
+		return nil, fmt.Errorf("list releases: %w", err)
+	}
+	seen := map[string]bool{}
+	var names []string
+	for _, cfg := range configs {
+		if name := cfg.Spec.Labels[LabelRelease]; name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// SetStatus implements Store.
+func (s *SwarmStore) SetStatus(ctx context.Context, name string, revision int, status Status) error {
+	cfg, err := s.find(ctx, name, revision)
+	if err != nil {
+		return err
+	}
+	spec := cfg.Spec
+	labels := make(map[string]string, len(spec.Labels))
+	for k, v := range spec.Labels {
+		labels[k] = v
+	}
+	labels[LabelStatus] = string(status)
+	spec.Labels = labels
+	return s.client.UpdateConfig(ctx, cfg.ID, cfg.Version, spec)
+}
+
+func (s *SwarmStore) find(ctx context.Context, name string, revision int) (docker.SwarmConfig, error) {
+	filters := docker.Filters{}.
+		Label(LabelRelease + "=" + name).
+		Label(LabelRevision + "=" + strconv.Itoa(revision))
+	configs, err := s.client.ListConfigs(ctx, filters)
+	if err != nil {
+		return docker.SwarmConfig{}, fmt.Errorf("find release %s revision %d: %w", name, revision, err)
+	}
+	if len(configs) == 0 {
+		return docker.SwarmConfig{}, fmt.Errorf("%w: %s revision %d", ErrNotFound, name, revision)
+	}
+	return configs[0], nil
+}
+
+// Delete implements Store.
+func (s *SwarmStore) Delete(ctx context.Context, name string, revision int) error {
+	cfg, err := s.find(ctx, name, revision)
+	if err != nil {
+		return err
+	}
+	return s.client.RemoveConfig(ctx, cfg.ID)
+}
+
+func lockName(name string) string {
+	return "tmpl-lock." + name
+}
+
+// Lock implements Store. The engine refuses duplicate config names, which
+// makes creating the lock config atomic.
+func (s *SwarmStore) Lock(ctx context.Context, name string, info LockInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.CreateConfig(ctx, docker.ObjectSpec{
+		Name:   lockName(name),
+		Labels: map[string]string{LabelLock: name},
+		Data:   data,
+	})
+	if err == nil {
+		return nil
+	}
+	if !docker.IsConflict(err) {
+		return fmt.Errorf("lock release %s: %w", name, err)
+	}
+	configs, lerr := s.client.ListConfigs(ctx, docker.Filters{}.Label(LabelLock+"="+name))
+	if lerr != nil || len(configs) == 0 {
+		return fmt.Errorf("lock release %s: %w", name, err)
+	}
+	var holder LockInfo
+	_ = json.Unmarshal(configs[0].Spec.Data, &holder)
+	return &LockedError{Release: name, Info: holder}
+}
+
+// Unlock implements Store.
+func (s *SwarmStore) Unlock(ctx context.Context, name string) error {
+	configs, err := s.client.ListConfigs(ctx, docker.Filters{}.Label(LabelLock+"="+name))
+	if err != nil {
+		return fmt.Errorf("unlock release %s: %w", name, err)
+	}
+	for _, cfg := range configs {
+		if err := s.client.RemoveConfig(ctx, cfg.ID); err != nil && !docker.IsNotFound(err) {
+			return fmt.Errorf("unlock release %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func fromConfig(cfg docker.SwarmConfig) (*Release, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(cfg.Spec.Data))
+	if err != nil {
+		return nil, fmt.Errorf("open release data: %w", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("read release data: %w", err)
+	}
+	r, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if status := cfg.Spec.Labels[LabelStatus]; status != "" {
+		r.Status = Status(status)
+	}
+	return r, nil
+}
@@ -0,0 +1,112 @@
+package render
+
+import (
+	"maps"
+	"os"
+	"slices"
+	"sync"
+)
+
+// Cache keeps the parsed templates of charts across renders, so that
+// renders repeated with new values, as in tmpl template --watch and tmpl
+// serve, only read and parse the templates again when a helper, template
+// or notes file was added, removed or changed. A Cache is safe for
+// concurrent use.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[cacheKey]*cacheEntry
+}
+
+// NewCache returns an empty Cache.
+func NewCache() *Cache {
+	return &Cache{entries: map[cacheKey]*cacheEntry{}}
+}
+
+// cacheKey is what parsing depends on besides the template files.
+type cacheKey struct {
+	chartPath string
+	overlay   string
+	strict    bool
+	sandbox   bool
+}
+
+// cacheEntry is a parsed chart and the state of the files it was parsed
+// from.
+type cacheEntry struct {
+	files  []templateFile
+	stamps []fileStamp
+	parsed *parsedChart
+}
+
+// fileStamp is the state of a file when it was read.
+type fileStamp struct {
+	modTime int64
+	size    int64
+}
+
+// cacheKey returns the key the templates of the renderer are cached under.
+func (r *Renderer) cacheKey() cacheKey {
+	return cacheKey{chartPath: r.cfg.ChartPath, overlay: r.cfg.Overlay, strict: r.cfg.Strict, sandbox: r.cfg.Sandbox != nil}
+}
+
+// stampFiles returns the state of the template files, reading overlay
+// files in place of those they shadow, or nil when one cannot be read.
+func (r *Renderer) stampFiles(files []templateFile) []fileStamp {
+	stamps := make([]fileStamp, len(files))
+	for i, f := range files {
+		path := f.path
+		if overlay, ok := r.overlaid[path]; ok {
+			path = overlay
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil
+		}
+		stamps[i] = fileStamp{modTime: info.ModTime().UnixNano(), size: info.Size()}
+	}
+	return stamps
+}
+
+// lookup returns the chart parsed for key from the same files in the same
+// state, or nil.
+func (c *Cache) lookup(key cacheKey, files []templateFile, stamps []fileStamp) *parsedChart {
+	if stamps == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !slices.Equal(entry.files, files) || !slices.Equal(entry.stamps, stamps) {
+		return nil
+	}
+	return entry.parsed
+}
+
+// store records parsed for key. The cache keeps a copy of its templates,
+// so that the render that parsed them can go on using them.
+func (c *Cache) store(key cacheKey, files []templateFile, stamps []fileStamp, parsed *parsedChart) error {
+	tmpl, err := parsed.tmpl.Clone()
+	if err != nil {
+		return err
+	}
+	kept := *parsed
+	kept.tmpl, kept.sources = tmpl, maps.Clone(parsed.sources)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = &cacheEntry{files: files, stamps: stamps, parsed: &kept}
+	return nil
+}
+
+// bind returns a copy of a cached chart whose templates are executed by
+// exec.
+func (r *Renderer) bind(cached *parsedChart, exec *executor) (*parsedChart, error) {
+	tmpl, err := cached.tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	funcs := funcMap(tmpl, exec)
+	tmpl.Funcs(funcs).Funcs(r.cfg.Functions.restrict(funcs))
+	parsed := *cached
+	parsed.tmpl, parsed.sources = tmpl, maps.Clone(cached.sources)
+	return &parsed, nil
+}
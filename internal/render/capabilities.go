@@ -0,0 +1,63 @@
+package render
+
+import (
+	"slices"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+)
+
+// Capabilities describes the swarm a chart is rendered for, as
+// .Capabilities, so that charts can size and place services for it:
+//
+//	replicas: {{ if ge .Capabilities.Nodes 3 }}3{{ else }}1{{ end }}
+//
+// Renders that do not query a swarm, such as tmpl template, have the zero
+// value.
+type Capabilities struct {
+	// Swarm reports whether the other fields describe a swarm.
+	Swarm bool
+	// Nodes counts the nodes tasks can be scheduled on, those active and
+	// ready, and Managers the managers among them. The other fields
+	// describe these nodes alone.
+	Nodes    int
+	Managers int
+	// NodeLabels maps the labels set on nodes to their distinct values,
+	// sorted.
+	NodeLabels map[string][]string
+	// EngineVersions are the distinct engine versions of the nodes, sorted.
+	EngineVersions []string
+	// CPUs and MemoryBytes are the total CPUs and memory of the nodes.
+	CPUs        float64
+	MemoryBytes int64
+}
+
+// SwarmCapabilities returns the capabilities of the swarm of nodes.
+func SwarmCapabilities(nodes []docker.Node) *Capabilities {
+	caps := &Capabilities{Swarm: true, NodeLabels: map[string][]string{}}
+	var nanoCPUs int64
+	for _, n := range nodes {
+		if n.Spec.Availability != "active" || n.Status.State != "ready" {
+			continue
+		}
+		caps.Nodes++
+		if n.Spec.Role == "manager" {
+			caps.Managers++
+		}
+		for k, v := range n.Spec.Labels {
+			if !slices.Contains(caps.NodeLabels[k], v) {
+				caps.NodeLabels[k] = append(caps.NodeLabels[k], v)
+			}
+		}
+		if v := n.Description.Engine.EngineVersion; v != "" && !slices.Contains(caps.EngineVersions, v) {
+			caps.EngineVersions = append(caps.EngineVersions, v)
+		}
+		nanoCPUs += n.Description.Resources.NanoCPUs
+		caps.MemoryBytes += n.Description.Resources.MemoryBytes
+	}
+	for _, values := range caps.NodeLabels {
+		slices.Sort(values)
+	}
+	slices.Sort(caps.EngineVersions)
+	caps.CPUs = float64(nanoCPUs) / 1e9
+	return caps
+}
@@ -0,0 +1,94 @@
+package render
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+)
+
+// Files exposes non-template chart files to templates via .Files.
+type Files map[string][]byte
+
+// Get returns the contents of the named file, or an empty string.
+func (f Files) Get(name string) string {
+	return string(f[name])
+}
+
+// GetBytes returns the contents of the named file as bytes, for binary
+// files such as certificates in DER form, or nil.
+func (f Files) GetBytes(name string) []byte {
+	return f[name]
+}
+
+// Glob returns the subset of files whose chart-relative path matches pattern.
+func (f Files) Glob(pattern string) Files {
+	matched := Files{}
+	for name, data := range f {
+		if ok, _ := path.Match(pattern, name); ok {
+			matched[name] = data
+		}
+	}
+	return matched
+}
+
+// Names returns the sorted chart-relative paths of all files.
+func (f Files) Names() []string {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadFiles reads every chart file that is not chart metadata, values, a
+// helper, a template or a vendored dependency. confine vets the path of
+// every file before it is read.
+func loadFiles(chartPath string, confine func(path string) error) (Files, error) {
+	files := Files{}
+	err := filepath.WalkDir(chartPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(chartPath, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel == templatesDir || rel == chart.DependenciesDir || (rel != "." && strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isReservedFile(rel) {
+			return nil
+		}
+		if err := confine(p); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[rel] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func isReservedFile(rel string) bool {
+	switch rel {
+	case "Chart.yaml", "values.yaml", "values.schema.json":
+		return true
+	}
+	return strings.HasSuffix(rel, helperSuffix)
+}
@@ -0,0 +1,199 @@
+package render
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// funcMap returns the helper functions available to chart templates.
+// include and tpl are bound to the template set being executed, and the
+// output they capture counts against the limits of e.
+func funcMap(t *template.Template, e *executor) template.FuncMap {
+	return template.FuncMap{
+		"include": func(name string, data any) (string, error) {
+			var buf bytes.Buffer
+			if err := t.ExecuteTemplate(e.writer(&buf), name, data); err != nil {
+				return "", err
+			}
+			return buf.String(), nil
+		},
+		"tpl": func(text string, data any) (string, error) {
+			clone, err := t.Clone()
+			if err != nil {
+				return "", err
+			}
+			parsed, err := clone.New("tpl").Parse(text)
+			if err != nil {
+				return "", fmt.Errorf("parse tpl: %w", err)
+			}
+			var buf bytes.Buffer
+			if err := parsed.Execute(e.writer(&buf), data); err != nil {
+				return "", err
+			}
+			return buf.String(), nil
+		},
+		"required": func(msg string, val any) (any, error) {
+			if isEmpty(val) {
+				return nil, errors.New(msg)
+			}
+			return val, nil
+		},
+		"default": func(def any, val ...any) any {
+			if len(val) == 0 || isEmpty(val[0]) {
+				return def
+			}
+			return val[0]
+		},
+		"toYaml": func(v any) (string, error) {
+			data, err := yaml.Marshal(v)
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimSuffix(string(data), "\n"), nil
+		},
+		"fromYaml": func(s string) (map[string]any, error) {
+			out := map[string]any{}
+			if err := yaml.Unmarshal([]byte(s), &out); err != nil {
+				return nil, err
+			}
+			return out, nil
+		},
+		"toJson": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return "", err
+			}
+			return string(data), nil
+		},
+		"indent": func(spaces int, s string) string {
+			pad := strings.Repeat(" ", spaces)
+			return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+		},
+		"nindent": func(spaces int, s string) string {
+			pad := strings.Repeat(" ", spaces)
+			return "\n" + pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+		},
+		"quote": func(v any) string {
+			return fmt.Sprintf("%q", fmt.Sprint(v))
+		},
+		"squote": func(v any) string {
+			return "'" + fmt.Sprint(v) + "'"
+		},
+		"trim":    strings.TrimSpace,
+		"upper":   strings.ToUpper,
+		"lower":   strings.ToLower,
+		"replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"b64enc":  b64enc,
+		"b64dec":  b64dec,
+
+		"constraint": constraint,
+		"nodeLabels": nodeLabels,
+		"cpus":       cpus,
+		"memory":     memory,
+	}
+}
+
+// b64enc encodes a string, or the bytes of .Files.GetBytes, as standard
+// base64, e.g. to embed a binary file in a config.
+func b64enc(v any) (string, error) {
+	switch v := v.(type) {
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), nil
+	case string:
+		return base64.StdEncoding.EncodeToString([]byte(v)), nil
+	default:
+		return "", fmt.Errorf("b64enc: cannot encode %T, want a string or bytes", v)
+	}
+}
+
+// b64dec decodes standard base64.
+func b64dec(s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("b64dec: %w", err)
+	}
+	return string(data), nil
+}
+
+func isEmpty(val any) bool {
+	if val == nil {
+		return true
+	}
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.String, reflect.Map, reflect.Slice, reflect.Array:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	default:
+		return v.IsZero()
+	}
+}
+
+// FilesObject names .Files, the non-template files of the chart, in a
+// FuncPolicy.
+const FilesObject = "Files"
+
+// builtins are the functions text/template predefines.
+var builtins = []string{
+	"and", "call", "html", "index", "js", "len", "not", "or", "print", "printf",
+	"println", "slice", "urlquery", "eq", "ge", "gt", "le", "lt", "ne",
+}
+
+// FuncPolicy restricts the functions chart templates can call, so charts
+// from untrusted sources can be rendered with less risk. Entries are
+// function names or path.Match patterns; FilesObject stands for .Files.
+type FuncPolicy struct {
+	// Allow, when set, lists the only functions of tmpl available. The
+	// functions text/template predefines are only restricted by Deny.
+	Allow []string
+	// Deny lists functions that are not available.
+	Deny []string
+}
+
+// Allows reports whether templates may use the function name.
+func (p FuncPolicy) Allows(name string) bool {
+	if matchesAny(p.Deny, name) {
+		return false
+	}
+	return len(p.Allow) == 0 || slices.Contains(builtins, name) || matchesAny(p.Allow, name)
+}
+
+// restrict returns replacements for the functions of funcs and the
+// predefined ones the policy does not allow. They fail when called, so
+// only templates actually using them fail to render.
+func (p FuncPolicy) restrict(funcs template.FuncMap) template.FuncMap {
+	disabled := template.FuncMap{}
+	names := slices.Concat(slices.Collect(maps.Keys(funcs)), builtins)
+	for _, name := range names {
+		if p.Allows(name) {
+			continue
+		}
+		disabled[name] = func(...any) (any, error) {
+			return nil, fmt.Errorf("function %s is disabled by the template function policy", name)
+		}
+	}
+	return disabled
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+const frontMatterDelim = "---"
+
+// frontMatter holds per-template options declared in a leading YAML block:
+//
+//	---
+//	generate:
+//	  items: .Values.services
+//	---
+//
+// With generate set the template body renders once per item into its own
+// document, exposing the element as .Item and its map key or index as .Key.
+type frontMatter struct {
+	Generate *generateSpec `yaml:"generate"`
+}
+
+type generateSpec struct {
+	Items string `yaml:"items"`
+}
+
+// item is a single element produced by a generate expression.
+type item struct {
+	Key   any
+	Value any
+}
+
+// splitFrontMatter separates a leading front matter block from the template
+// body. It returns the number of source lines consumed so template line
+// numbers can be reported against the original file. Files whose leading
+// block does not declare generate are returned untouched.
+func splitFrontMatter(src string) (*frontMatter, string, int, error) {
+	eol := "\n"
+	if strings.HasPrefix(src, frontMatterDelim+"\r\n") {
+		eol = "\r\n"
+	}
+	if !strings.HasPrefix(src, frontMatterDelim+eol) {
+		return nil, src, 0, nil
+	}
+	rest := src[len(frontMatterDelim)+len(eol):]
+	end := strings.Index(rest, eol+frontMatterDelim+eol)
+	if end < 0 {
+		return nil, src, 0, nil
+	}
+	header := rest[:end]
+
+	var probe map[string]any
+	if err := yaml.Unmarshal([]byte(header), &probe); err != nil {
+		return nil, src, 0, nil
+	}
+	if _, ok := probe["generate"]; !ok {
+		return nil, src, 0, nil
+	}
+
+	fm := &frontMatter{}
+	if err := yaml.Unmarshal([]byte(header), fm); err != nil {
+		return nil, "", 0, fmt.Errorf("decode front matter: %w", err)
+	}
+	if fm.Generate == nil || strings.TrimSpace(fm.Generate.Items) == "" {
+		return nil, "", 0, fmt.Errorf("front matter generate.items is required")
+	}
+
+	body := rest[end+len(eol)+len(frontMatterDelim)+len(eol):]
+	consumed := strings.Count(src[:len(src)-len(body)], "\n")
+	return fm, body, consumed, nil
+}
+
+// evalItems evaluates the generate expression against data and flattens the
+// result into an ordered list. Maps are ordered by key.
+func evalItems(exec *executor, tmpl *template.Template, expr string, data map[string]any) ([]item, error) {
+	captured, err := evalPipeline(exec, tmpl, "generate items", expr, data)
+	if err != nil {
+		return nil, err
+	}
+	if captured == nil {
+		return nil, nil
+	}
+
+	v := reflect.ValueOf(captured)
+	switch v.Kind() {
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		items := make([]item, 0, len(keys))
+		for _, k := range keys {
+			items = append(items, item{Key: k.Interface(), Value: itemValue(v.MapIndex(k))})
+		}
+		return items, nil
+	case reflect.Slice, reflect.Array:
+		items := make([]item, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, item{Key: i, Value: itemValue(v.Index(i))})
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("generate items %q must evaluate to a map or list, got %T", expr, captured)
+	}
+}
+
+// itemValue unwraps an element, exposing file contents as strings.
+func itemValue(v reflect.Value) any {
+	val := v.Interface()
+	if b, ok := val.([]byte); ok {
+		return string(b)
+	}
+	return val
+}
+
+// evalPipeline evaluates the template pipeline expr against data and
+// returns its value. what names the pipeline in errors.
+func evalPipeline(exec *executor, tmpl *template.Template, what, expr string, data map[string]any) (any, error) {
+	var captured any
+	clone, err := tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	clone.Funcs(template.FuncMap{
+		"__capture": func(v any) string {
+			captured = v
+			return ""
+		},
+	})
+	t, err := clone.New(what).Parse("{{ __capture (" + expr + ") }}")
+	if err != nil {
+		return nil, fmt.Errorf("parse %s %q: %w", what, expr, err)
+	}
+	if err := exec.execute(&bytes.Buffer{}, t, t.Name(), data); err != nil {
+		return nil, fmt.Errorf("evaluate %s %q: %w", what, expr, err)
+	}
+	return captured, nil
+}
+
+// withItem returns a copy of data extended with the generate item.
+func withItem(data map[string]any, it item) map[string]any {
+	scoped := make(map[string]any, len(data)+2)
+	for k, v := range data {
+		scoped[k] = v
+	}
+	scoped["Item"] = it.Value
+	scoped["Key"] = it.Key
+	return scoped
+}
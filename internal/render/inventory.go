@@ -0,0 +1,126 @@
+package render
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+)
+
+// Inventory describes the templates of a chart as they are parsed for
+// rendering, without executing them.
+type Inventory struct {
+	// Templates lists the templates producing stack output, sorted by
+	// name.
+	Templates []TemplateInfo
+	// Hooks lists the hook templates, sorted by name.
+	Hooks []TemplateInfo
+	// Notes names the notes template, empty when the chart has none.
+	Notes string
+	// Defines lists the named templates of the chart and of the library
+	// charts it depends on, sorted by name.
+	Defines []Define
+}
+
+// TemplateInfo describes a template file.
+type TemplateInfo struct {
+	Name string
+	// Generate is the items expression of a template rendering one
+	// document per item, empty for others.
+	Generate string
+	// Events lists the events of a hook as annotated.
+	Events string
+	// Description is the template comment the file starts with.
+	Description string
+}
+
+// Define is a named template.
+type Define struct {
+	Name string
+	// File names the chart file defining it; a chart redefining a
+	// template of a library is the one that counts.
+	File string
+	// Description is the template comment right before the define.
+	Description string
+}
+
+var (
+	defineAction   = regexp.MustCompile(`\{\{-?\s*define\s+"([^"]+)"`)
+	commentDefine  = regexp.MustCompile(`(?s)\{\{-?\s*/\*(.*?)\*/\s*-?\}\}\s*\{\{-?\s*define\s+"([^"]+)"`)
+	leadingComment = regexp.MustCompile(`(?s)^\s*\{\{-?\s*/\*(.*?)\*/\s*-?\}\}`)
+)
+
+// Inventory parses the chart and describes its templates.
+func (r *Renderer) Inventory() (*Inventory, error) {
+	parsed, err := r.parse(nil)
+	if err != nil {
+		return nil, err
+	}
+	inv := &Inventory{Notes: parsed.notes}
+	for _, t := range parsed.templates {
+		info := TemplateInfo{Name: t.name, Description: templateComment(parsed.sources[t.name])}
+		if t.frontMatter != nil && t.frontMatter.Generate != nil {
+			info.Generate = strings.TrimSpace(t.frontMatter.Generate.Items)
+			// The comment follows the front matter.
+			_, body, _, _ := splitFrontMatter(parsed.sources[t.name])
+			info.Description = templateComment(body)
+		}
+		inv.Templates = append(inv.Templates, info)
+	}
+	for _, name := range parsed.hooks {
+		inv.Hooks = append(inv.Hooks, TemplateInfo{Name: name, Events: hookEvents(parsed.sources[name])})
+	}
+
+	files := make([]string, 0, len(parsed.sources))
+	for name := range parsed.sources {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	defines := map[string]*Define{}
+	for _, file := range files {
+		src := parsed.sources[file]
+		comments := map[string]string{}
+		for _, m := range commentDefine.FindAllStringSubmatch(src, -1) {
+			comments[m[2]] = cleanComment(m[1])
+		}
+		library := strings.HasPrefix(file, chart.DependenciesDir+"/")
+		for _, m := range defineAction.FindAllStringSubmatch(src, -1) {
+			if d, ok := defines[m[1]]; ok && library && !strings.HasPrefix(d.File, chart.DependenciesDir+"/") {
+				continue
+			}
+			defines[m[1]] = &Define{Name: m[1], File: file, Description: comments[m[1]]}
+		}
+	}
+	for _, t := range parsed.tmpl.Templates() {
+		if d, ok := defines[t.Name()]; ok {
+			inv.Defines = append(inv.Defines, *d)
+		}
+	}
+	sort.Slice(inv.Defines, func(i, j int) bool { return inv.Defines[i].Name < inv.Defines[j].Name })
+	return inv, nil
+}
+
+// templateComment returns the template comment src starts with.
+func templateComment(src string) string {
+	if m := leadingComment.FindStringSubmatch(src); m != nil {
+		return cleanComment(m[1])
+	}
+	return ""
+}
+
+// cleanComment joins the lines of a template comment.
+func cleanComment(comment string) string {
+	return strings.Join(strings.Fields(comment), " ")
+}
+
+// hookEvents returns the value of the hook annotation of src.
+func hookEvents(src string) string {
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "#"))
+		if value, ok := strings.CutPrefix(line, hookAnnotation); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,99 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// LineEndings selects the line endings of rendered output.
+type LineEndings string
+
+const (
+	// LineEndingsPreserve keeps the line endings of the templates, which
+	// may be CRLF for charts checked out on Windows.
+	LineEndingsPreserve LineEndings = "preserve"
+	LineEndingsLF       LineEndings = "lf"
+	LineEndingsCRLF     LineEndings = "crlf"
+)
+
+// ParseLineEndings validates a --line-endings value.
+func ParseLineEndings(s string) (LineEndings, error) {
+	switch le := LineEndings(s); le {
+	case LineEndingsPreserve, LineEndingsLF, LineEndingsCRLF:
+		return le, nil
+	}
+	return "", fmt.Errorf("unknown line endings %q, must be lf, crlf or preserve", s)
+}
+
+// LineWriter converts the line endings of what is written through it,
+// LF and CRLF alike, to LF or CRLF. Flush must be called after the last
+// write.
+type LineWriter struct {
+	w   io.Writer
+	eol []byte
+	// cr is set when the last byte written was a CR, which is held back
+	// until it is known whether it ends a line.
+	cr  bool
+	buf []byte
+}
+
+// NewLineWriter returns a LineWriter converting line endings to le, which
+// must not be LineEndingsPreserve.
+func NewLineWriter(w io.Writer, le LineEndings) *LineWriter {
+	eol := []byte("\n")
+	if le == LineEndingsCRLF {
+		eol = []byte("\r\n")
+	}
+	return &LineWriter{w: w, eol: eol}
+}
+
+func (lw *LineWriter) Write(p []byte) (int, error) {
+	out := lw.buf[:0]
+	for _, c := range p {
+		if lw.cr {
+			lw.cr = false
+			if c == '\n' {
+				out = append(out, lw.eol...)
+				continue
+			}
+			out = append(out, '\r')
+		}
+		switch c {
+		case '\r':
+			lw.cr = true
+		case '\n':
+			out = append(out, lw.eol...)
+		default:
+			out = append(out, c)
+		}
+	}
+	lw.buf = out
+	if _, err := lw.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes a trailing CR held back by the last write.
+func (lw *LineWriter) Flush() error {
+	if !lw.cr {
+		return nil
+	}
+	lw.cr = false
+	_, err := lw.w.Write([]byte{'\r'})
+	return err
+}
+
+// ConvertLineEndings returns data with its line endings converted to le.
+func ConvertLineEndings(data []byte, le LineEndings) []byte {
+	if le == LineEndingsPreserve || le == "" {
+		return data
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	lw := NewLineWriter(&buf, le)
+	_, _ = lw.Write(data)
+	_ = lw.Flush()
+	return buf.Bytes()
+}
@@ -0,0 +1,64 @@
+package render
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseLineEndings(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    LineEndings
+		wantErr bool
+	}{
+		{"lf", LineEndingsLF, false},
+		{"crlf", LineEndingsCRLF, false},
+		{"preserve", LineEndingsPreserve, false},
+		{"", "", true},
+		{"CRLF", "", true},
+		{"cr", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLineEndings(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseLineEndings(%q) = %q, %v, want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestConvertLineEndings(t *testing.T) {
+	tests := []struct {
+		in   string
+		le   LineEndings
+		want string
+	}{
+		{"a\nb\r\nc", LineEndingsPreserve, "a\nb\r\nc"},
+		{"a\nb\r\nc", "", "a\nb\r\nc"},
+		{"a\nb\r\nc\n", LineEndingsLF, "a\nb\nc\n"},
+		{"a\nb\r\nc\n", LineEndingsCRLF, "a\r\nb\r\nc\r\n"},
+		{"a\rb\r", LineEndingsLF, "a\rb\r"},
+		{"a\r\r\n", LineEndingsLF, "a\r\n"},
+		{"", LineEndingsCRLF, ""},
+	}
+	for _, tt := range tests {
+		if got := string(ConvertLineEndings([]byte(tt.in), tt.le)); got != tt.want {
+			t.Errorf("ConvertLineEndings(%q, %s) = %q, want %q", tt.in, tt.le, got, tt.want)
+		}
+	}
+}
+
+func TestLineWriterCRLFAcrossWrites(t *testing.T) {
+	var buf bytes.Buffer
+	lw := NewLineWriter(&buf, LineEndingsLF)
+	for _, chunk := range []string{"a\r", "\nb\r", "c\r"} {
+		if _, err := lw.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "a\nb\rc\r"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,67 @@
+package render
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// constraint formats a placement constraint from values, e.g.
+// {{ constraint "node.labels.zone" "==" .Values.zone }}, and validates it.
+func constraint(field, op string, value any) (string, error) {
+	if op != "==" && op != "!=" {
+		return "", fmt.Errorf("constraint %s: operator must be == or !=, got %q", field, op)
+	}
+	c, err := stack.ParseConstraint(field + op + fmt.Sprint(value))
+	if err != nil {
+		return "", err
+	}
+	return c.String(), nil
+}
+
+// nodeLabels turns a map of required node labels into equality
+// constraints ordered by label:
+//
+//	constraints: {{ nodeLabels .Values.nodeSelector | toJson }}
+func nodeLabels(labels map[string]any) ([]string, error) {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		c, err := constraint("node.labels."+k, "==", labels[k])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// cpus validates a CPU amount such as 0.5 and formats it for compose.
+func cpus(v any) (string, error) {
+	s := fmt.Sprint(v)
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return "", fmt.Errorf("invalid cpus %q: expected a positive number", s)
+	}
+	return strconv.FormatFloat(n, 'f', -1, 64), nil
+}
+
+// memory validates a byte amount such as 512M or 1g and returns it
+// unchanged.
+func memory(v any) (string, error) {
+	s := fmt.Sprint(v)
+	n, err := stack.ParseBytes(s)
+	if err != nil {
+		return "", err
+	}
+	if n <= 0 {
+		return "", fmt.Errorf("invalid memory %q: expected a positive amount", s)
+	}
+	return s, nil
+}
@@ -0,0 +1,784 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/compose"
+	"github.com/acebelowzero/tmpl/internal/dump"
+	"github.com/acebelowzero/tmpl/internal/telemetry"
+	"github.com/acebelowzero/tmpl/internal/timing"
+	"github.com/acebelowzero/tmpl/internal/version"
+)
+
+const (
+	templatesDir   = "templates"
+	templateSuffix = ".tmpl"
+	helperSuffix   = ".tpl"
+	// notesFile is rendered separately from the stack and shown to users
+	// after a deploy.
+	notesFile = "NOTES.txt"
+	// hookAnnotation marks a template as a deploy hook when it appears in
+	// the leading comment block; see package hook.
+	hookAnnotation = "tmpl.hook:"
+)
+
+var yamlLinePattern = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// Config controls how a chart is rendered.
+type Config struct {
+	ChartPath string
+	// SkipEmpty leaves templates that render to whitespace only out of the
+	// output, instead of emitting empty documents for them.
+	SkipEmpty bool
+	// ReleaseName is .Release.Name, the release the chart is rendered
+	// for. It defaults to the chart name.
+	ReleaseName string
+	// Namespace is .Release.Namespace, the namespace of the release.
+	Namespace string
+	// Profiles are the active compose profiles. Services with profiles
+	// are left out of the output unless one of them is active, as with
+	// docker compose --profile.
+	Profiles []string
+	// ResolveImage, when set, replaces the image of every rendered service
+	// with what it returns, e.g. the image pinned to a digest.
+	ResolveImage func(image string) (string, error)
+	// Functions restricts the functions templates can call.
+	Functions FuncPolicy
+	// Sandbox, when set, confines rendering for untrusted charts.
+	Sandbox *Sandbox
+	// Strict fails renders reading values keys that are not set, which
+	// otherwise render as zero values.
+	Strict bool
+	// Overlay is a directory laid out as a chart whose helpers, templates
+	// and NOTES.txt shadow the chart files of the same path, or are added
+	// to the chart when it has none.
+	Overlay string
+	// Cache, when set, keeps the parsed templates of the chart for later
+	// renders with the same cache, such as those of a watch.
+	Cache *Cache
+	// Patches are files of patches applied to the rendered stack in order,
+	// strategic merge or JSON patches as described by compose.Patch.
+	Patches []string
+	// Capabilities is .Capabilities, the swarm the chart is rendered for,
+	// or the zero Capabilities when nil.
+	Capabilities *Capabilities
+}
+
+// Release describes the release a chart is rendered for, as .Release.
+type Release struct {
+	Name      string
+	Namespace string
+}
+
+// Renderer executes chart templates against merged values.
+type Renderer struct {
+	cfg   Config
+	chart *chart.Metadata
+	files Files
+	// overlaid maps the paths of chart files shadowed by the overlay to
+	// the overlay files.
+	overlaid map[string]string
+	patches  []*compose.Patch
+}
+
+// Result holds rendered output together with its source map.
+type Result struct {
+	Output    []byte
+	SourceMap *SourceMap
+	// Sources holds the raw source of every template and helper by name.
+	Sources map[string]string
+	// Notes is the rendered templates/NOTES.txt, if the chart has one.
+	Notes string
+	// Hooks holds the rendered hook templates by name. Hooks are not part
+	// of Output.
+	Hooks map[string]string
+	// Warnings describe problems of the output that do not fail the
+	// render, such as configs too large for swarm.
+	Warnings []string
+}
+
+// New constructs a Renderer for the chart at cfg.ChartPath. It fails when
+// the tmplVersion constraint of the chart excludes this version of tmpl.
+func New(cfg Config) (*Renderer, error) {
+	if cfg.ChartPath == "" {
+		cfg.ChartPath = "."
+	}
+	meta, err := chart.LoadMetadata(cfg.ChartPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := meta.CheckTmplVersion(version.Version); err != nil {
+		return nil, err
+	}
+	r := &Renderer{cfg: cfg, chart: meta}
+	if r.files, err = loadFiles(cfg.ChartPath, r.confine); err != nil {
+		return nil, fmt.Errorf("load chart files: %w", err)
+	}
+	if cfg.Overlay != "" {
+		if r.overlaid, err = overlayFiles(meta, cfg.ChartPath, cfg.Overlay); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range cfg.Patches {
+		patch, err := compose.LoadPatch(path)
+		if err != nil {
+			return nil, err
+		}
+		r.patches = append(r.patches, patch)
+	}
+	return r, nil
+}
+
+// overlayFiles maps the chart paths of the helpers, templates, raw copied
+// files and notes of the overlay directory to their overlay paths, telling
+// templates apart as meta, the metadata of the chart, does.
+func overlayFiles(meta *chart.Metadata, chartPath, overlay string) (map[string]string, error) {
+	info, err := os.Stat(overlay)
+	if err != nil {
+		return nil, fmt.Errorf("overlay: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("overlay %s is not a directory", overlay)
+	}
+	overlaid := map[string]string{}
+	err = filepath.WalkDir(overlay, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(overlay, path)
+		if err != nil {
+			return err
+		}
+		dir := filepath.Dir(rel)
+		inTemplates, _ := strings.CutPrefix(filepath.ToSlash(rel), templatesDir+"/")
+		switch {
+		case dir == "." && strings.HasSuffix(rel, helperSuffix),
+			dir == templatesDir && d.Name() == notesFile,
+			strings.HasPrefix(rel, templatesDir+string(filepath.Separator)) &&
+				(strings.HasSuffix(rel, helperSuffix) || meta.IsTemplate(rel) || meta.IsRawCopy(inTemplates)):
+			overlaid[filepath.Join(chartPath, rel)] = path
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read overlay: %w", err)
+	}
+	return overlaid, nil
+}
+
+// Execute renders all chart templates and returns the concatenated output.
+func (r *Renderer) Execute(ctx context.Context, values map[string]any) ([]byte, error) {
+	result, err := r.Render(ctx, values)
+	if err != nil {
+		return nil, err
+	}
+	return result.Output, nil
+}
+
+// Render renders all chart templates, validates that the output is well
+// formed YAML and reports failures against template source lines.
+//
+// Templates are rendered in the order of their names, each into its own
+// YAML document: a --- separator is written before every template output
+// but the first, unless the output starts with one itself.
+func (r *Renderer) Render(ctx context.Context, values map[string]any) (*Result, error) {
+	var out bytes.Buffer
+	result, err := r.RenderTo(ctx, values, &out)
+	if err != nil {
+		return nil, err
+	}
+	result.Output = out.Bytes()
+	return result, nil
+}
+
+// RenderTo is Render that writes the output to w instead of returning it in
+// Result.Output. Every template is rendered, validated and written before
+// the next one is executed, so memory use grows with the largest template
+// output rather than with the whole stack. Output already written stays
+// written when a later template fails. With patches, which may change any
+// document, the output is held back until the whole stack is rendered.
+func (r *Renderer) RenderTo(ctx context.Context, values map[string]any, w io.Writer) (_ *Result, err error) {
+	defer timing.Track(ctx, timing.Render)()
+	ctx, span := telemetry.Start(ctx, telemetry.Render, attribute.String("chart", r.chart.Name))
+	defer func() { span.End(err) }()
+	if r.chart.IsLibrary() {
+		return nil, fmt.Errorf("chart %s is a library chart and cannot be rendered, add it to the dependencies of an application chart", r.chart.Name)
+	}
+	exec, release := r.newExecutor(ctx)
+	defer release()
+	parsed, err := r.parse(exec)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := parsed.tmpl
+	data := r.data(values)
+	if err := r.checkValidations(exec, tmpl, data); err != nil {
+		return nil, err
+	}
+
+	out := &stream{w: w, dump: dump.FromContext(ctx)}
+	var unpatched bytes.Buffer
+	if len(r.patches) > 0 {
+		out.w = &unpatched
+	}
+	for _, t := range parsed.templates {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if t.frontMatter == nil || t.frontMatter.Generate == nil {
+			if err := r.executeInto(exec, out, tmpl, t.name, t.name, data); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		items, err := evalItems(exec, tmpl, t.frontMatter.Generate.Items, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.name, err)
+		}
+		for _, it := range items {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if err := r.executeInto(exec, out, tmpl, t.name, fmt.Sprintf("%s [%v]", t.name, it.Key), withItem(data, it)); err != nil {
+				return nil, fmt.Errorf("%s [%v]: %w", t.name, it.Key, err)
+			}
+		}
+	}
+
+	if len(r.patches) > 0 {
+		if err := r.writePatched(out, unpatched.Bytes(), w); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &Result{SourceMap: out.builder.build(), Sources: parsed.sources, Warnings: out.warnings}
+	if parsed.notes != "" {
+		var notes bytes.Buffer
+		if err := exec.execute(&notes, tmpl, parsed.notes, data); err != nil {
+			return nil, fmt.Errorf("execute %s: %w", parsed.notes, err)
+		}
+		result.Notes = string(bytes.ReplaceAll(notes.Bytes(), []byte("<no value>"), nil))
+	}
+	for _, name := range parsed.hooks {
+		var hook bytes.Buffer
+		if err := exec.execute(&hook, tmpl, name, data); err != nil {
+			return nil, fmt.Errorf("execute %s: %w", name, err)
+		}
+		if result.Hooks == nil {
+			result.Hooks = map[string]string{}
+		}
+		result.Hooks[name] = string(bytes.ReplaceAll(hook.Bytes(), []byte("<no value>"), nil))
+	}
+	return result, nil
+}
+
+// data is the dot templates are executed with.
+func (r *Renderer) data(values map[string]any) map[string]any {
+	release := Release{Name: r.cfg.ReleaseName, Namespace: r.cfg.Namespace}
+	if release.Name == "" {
+		release.Name = r.chart.Name
+	}
+	files := r.files
+	if !r.cfg.Functions.Allows(FilesObject) {
+		files = Files{}
+	}
+	capabilities := r.cfg.Capabilities
+	if capabilities == nil {
+		capabilities = &Capabilities{}
+	}
+	return map[string]any{
+		"Values":       values,
+		"Chart":        r.chart,
+		"Release":      release,
+		"Files":        files,
+		"Capabilities": capabilities,
+	}
+}
+
+// stream is the output of a render: template outputs are assembled into
+// chunk one at a time, validated and passed on to w.
+type stream struct {
+	w       io.Writer
+	builder sourceMapBuilder
+	chunk   bytes.Buffer
+	written int64
+	// dump records the output of every template for debugging.
+	dump *dump.Recorder
+	// warnings collects Result.Warnings.
+	warnings []string
+}
+
+// executeInto executes the template name and passes its output on to out.
+// label names the execution in debug dumps.
+func (r *Renderer) executeInto(exec *executor, out *stream, tmpl *template.Template, name, label string, data map[string]any) (err error) {
+	var buf bytes.Buffer
+	if err := exec.execute(&buf, tmpl, name, data); err != nil {
+		out.dump.Template(label, stripMarkers(buf.Bytes()), true)
+		return fmt.Errorf("execute %s: %w", name, err)
+	}
+	rendered := bytes.ReplaceAll(buf.Bytes(), []byte("<no value>"), nil)
+	text := stripMarkers(rendered)
+	defer func() { out.dump.Template(label, text, err != nil) }()
+	if r.cfg.SkipEmpty && len(bytes.TrimSpace(text)) == 0 {
+		return nil
+	}
+
+	// Separators end like the lines of the template, which are CRLF
+	// for charts checked out on Windows.
+	eol := "\n"
+	if bytes.Contains(text, []byte("\r\n")) {
+		eol = "\r\n"
+	}
+	chunk := &out.chunk
+	chunk.Reset()
+	firstLine := len(out.builder.lines)
+	if out.written > 0 && !startsDocument(text) {
+		out.builder.appendPlain(chunk, frontMatterDelim+eol)
+	}
+	out.builder.append(chunk, rendered, name)
+	if chunk.Len() > 0 && chunk.Bytes()[chunk.Len()-1] != '\n' {
+		// append recorded the open last line already.
+		chunk.WriteString(eol)
+	}
+	// Every chunk starts a document, so it can be validated on its own.
+	if err := validateYAML(chunk.Bytes(), out.builder.build(), firstLine); err != nil {
+		return err
+	}
+	selected, rewritten, err := compose.SelectProfiles(chunk.Bytes(), r.cfg.Profiles)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if r.cfg.ResolveImage != nil {
+		var pinned bool
+		if selected, pinned, err = compose.ReplaceImages(selected, r.cfg.ResolveImage); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		rewritten = rewritten || pinned
+	}
+	if rewritten {
+		// The documents were encoded again, their lines no longer map to
+		// the template.
+		out.builder.truncate(firstLine)
+		out.builder.unmapped(bytes.Count(selected, []byte{'\n'}))
+	}
+	if bytes.Contains(selected, []byte("configs:")) || bytes.Contains(selected, []byte("secrets:")) {
+		// Documents the stack cannot be read from fail later, with
+		// better errors.
+		if s, err := compose.Parse(selected); err == nil {
+			for _, problem := range s.ObjectProblems(r.cfg.ChartPath) {
+				out.warnings = append(out.warnings, name+": "+problem)
+			}
+		}
+	}
+	n, err := out.w.Write(selected)
+	out.written += int64(n)
+	if err != nil {
+		return fmt.Errorf("write output: %w", err)
+	}
+	return nil
+}
+
+// writePatched applies the patches of the renderer to the rendered stack
+// and writes the result to w. Documents the patches change no longer map
+// to the templates.
+func (r *Renderer) writePatched(out *stream, rendered []byte, w io.Writer) error {
+	patched, changed, err := compose.ApplyPatches(rendered, r.patches)
+	if err != nil {
+		return err
+	}
+	if changed {
+		var before [][]byte
+		if err := compose.EachDocument(bytes.NewReader(rendered), func(doc []byte, _ int) error {
+			before = append(before, slices.Clone(doc))
+			return nil
+		}); err != nil {
+			return err
+		}
+		var lines []Location
+		offset := 0
+		err := compose.EachDocument(bytes.NewReader(patched), func(doc []byte, index int) error {
+			n := bytes.Count(doc, []byte{'\n'})
+			if index < len(before) && bytes.Equal(doc, before[index]) && offset+n <= len(out.builder.lines) {
+				lines = append(lines, out.builder.lines[offset:offset+n]...)
+			} else {
+				lines = append(lines, make([]Location, n)...)
+			}
+			if index < len(before) {
+				offset += bytes.Count(before[index], []byte{'\n'})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		out.builder.lines = lines
+	}
+	if _, err := w.Write(patched); err != nil {
+		return fmt.Errorf("write output: %w", err)
+	}
+	return nil
+}
+
+// startsDocument reports whether rendered text opens its YAML document
+// with a --- separator, ignoring leading blank and comment lines.
+func startsDocument(text []byte) bool {
+	for _, line := range strings.Split(string(text), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case line == frontMatterDelim:
+			return true
+		default:
+			return strings.HasPrefix(line, frontMatterDelim+" ")
+		}
+	}
+	return false
+}
+
+// chartTemplate is a parsed template file that produces output.
+type chartTemplate struct {
+	name        string
+	frontMatter *frontMatter
+	hook        bool
+}
+
+// parsedChart is the template set of a chart together with its sources.
+type parsedChart struct {
+	tmpl *template.Template
+	// templates lists the templates that produce output, sorted by name.
+	templates []chartTemplate
+	sources   map[string]string
+	// notes names the notes template, empty when the chart has none.
+	notes string
+	// hooks lists the hook templates, sorted by name.
+	hooks []string
+}
+
+// templateFile is a chart file parse reads: a helper, the notes or a
+// template that produces output, which raw files do without being
+// executed.
+type templateFile struct {
+	name   string
+	path   string
+	output bool
+	notes  bool
+	raw    bool
+}
+
+// parse loads helpers and templates from the chart. Helpers of library
+// charts the chart depends on are parsed first, so the chart can redefine
+// their named templates. Templates are executed by exec. With a cache, the
+// templates of an earlier render are reused when none of the files changed.
+func (r *Renderer) parse(exec *executor) (*parsedChart, error) {
+	files, err := r.discover()
+	if err != nil {
+		return nil, err
+	}
+	cache, key := r.cfg.Cache, r.cacheKey()
+	var stamps []fileStamp
+	if cache != nil {
+		// Files are stamped before they are read, so that changes made
+		// while parsing show in the next lookup.
+		stamps = r.stampFiles(files)
+		if cached := cache.lookup(key, files, stamps); cached != nil {
+			return r.bind(cached, exec)
+		}
+	}
+
+	missingKey := "missingkey=zero"
+	if r.cfg.Strict {
+		missingKey = "missingkey=error"
+	}
+	tmpl := template.New(r.chart.Name).Option(missingKey)
+	funcs := funcMap(tmpl, exec)
+	tmpl.Funcs(funcs).Funcs(r.cfg.Functions.restrict(funcs))
+	parsed := &parsedChart{tmpl: tmpl, sources: map[string]string{}}
+	for _, f := range files {
+		t, err := r.parseFile(parsed, f)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case f.notes:
+			parsed.notes = t.name
+		case !f.output:
+		case t.hook:
+			parsed.hooks = append(parsed.hooks, t.name)
+		default:
+			parsed.templates = append(parsed.templates, t)
+		}
+	}
+	if cache != nil && stamps != nil {
+		if err := cache.store(key, files, stamps, parsed); err != nil {
+			return nil, err
+		}
+	}
+	return parsed, nil
+}
+
+// discover lists the files parse reads in the order it parses them: the
+// helpers and templates of library charts, the helpers of the chart, its
+// notes and its templates, sorted by path within each group.
+func (r *Renderer) discover() ([]templateFile, error) {
+	var files []templateFile
+	libs, err := chart.Libraries(r.cfg.ChartPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, lib := range libs {
+		if err := r.confine(lib.Dir); err != nil {
+			return nil, fmt.Errorf("library %s: %w", lib.Metadata.Name, err)
+		}
+		libFiles, err := libraryFiles(lib)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, libFiles...)
+	}
+
+	helpers, err := filepath.Glob(filepath.Join(r.cfg.ChartPath, "*"+helperSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []string
+	var notes string
+	root := filepath.Join(r.cfg.ChartPath, templatesDir)
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		switch {
+		case filepath.Dir(path) == root && d.Name() == notesFile:
+			notes = path
+		case r.isRawCopy(path):
+			templates = append(templates, path)
+		case strings.HasSuffix(path, helperSuffix):
+			helpers = append(helpers, path)
+		case r.chart.IsTemplate(path):
+			templates = append(templates, path)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("discover templates: %w", err)
+	}
+	// Overlay files the chart does not have are added to it.
+	for path := range r.overlaid {
+		if slices.Contains(helpers, path) || slices.Contains(templates, path) || path == notes {
+			continue
+		}
+		switch {
+		case filepath.Dir(path) == root && filepath.Base(path) == notesFile:
+			notes = path
+		case strings.HasSuffix(path, helperSuffix):
+			helpers = append(helpers, path)
+		default:
+			templates = append(templates, path)
+		}
+	}
+	sort.Strings(helpers)
+	sort.Strings(templates)
+
+	for _, path := range helpers {
+		files = append(files, templateFile{name: r.templateName(path), path: path})
+	}
+	if notes != "" {
+		files = append(files, templateFile{name: r.templateName(notes), path: notes, notes: true})
+	}
+	for _, path := range templates {
+		files = append(files, templateFile{name: r.templateName(path), path: path, output: true, raw: r.isRawCopy(path)})
+	}
+	return files, nil
+}
+
+// isRawCopy reports whether the chart file at path is under templates/ and
+// matches a rawCopy pattern of Chart.yaml.
+func (r *Renderer) isRawCopy(path string) bool {
+	rel, err := filepath.Rel(filepath.Join(r.cfg.ChartPath, templatesDir), path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return false
+	}
+	return r.chart.IsRawCopy(filepath.ToSlash(rel))
+}
+
+// libraryFiles lists the helpers and templates of a library chart, parsed
+// as helpers named charts/<library>/<file>. Templates of a library chart
+// never produce output of their own.
+func libraryFiles(lib chart.Library) ([]templateFile, error) {
+	paths, err := filepath.Glob(filepath.Join(lib.Dir, "*"+helperSuffix))
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.Join(lib.Dir, templatesDir)
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && (strings.HasSuffix(path, helperSuffix) || lib.Metadata.IsTemplate(path)) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("discover templates of library %s: %w", lib.Metadata.Name, err)
+	}
+	sort.Strings(paths)
+	files := make([]templateFile, 0, len(paths))
+	for _, path := range paths {
+		rel, err := filepath.Rel(lib.Dir, path)
+		if err != nil {
+			return nil, err
+		}
+		name := chart.DependenciesDir + "/" + lib.Metadata.Name + "/" + filepath.ToSlash(rel)
+		files = append(files, templateFile{name: name, path: path})
+	}
+	return files, nil
+}
+
+// templateName names a chart file by its slash-separated path relative to
+// the chart root.
+func (r *Renderer) templateName(path string) string {
+	name, err := filepath.Rel(r.cfg.ChartPath, path)
+	if err != nil {
+		name = path
+	}
+	return filepath.ToSlash(name)
+}
+
+func (r *Renderer) parseFile(parsed *parsedChart, f templateFile) (chartTemplate, error) {
+	name, path, output := f.name, f.path, f.output
+	if overlay, ok := r.overlaid[path]; ok {
+		// Overlays are the operator's, not the chart's, and may live
+		// anywhere.
+		path = overlay
+	} else if err := r.confine(path); err != nil {
+		return chartTemplate{}, fmt.Errorf("read template %s: %w", path, err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return chartTemplate{}, fmt.Errorf("read template %s: %w", path, err)
+	}
+	parsed.sources[name] = string(raw)
+
+	src := string(raw)
+	if f.raw {
+		// The file is a single text node, annotated like template text
+		// so that its lines map to the file.
+		tree := parse.New(name)
+		tree.Root = &parse.ListNode{NodeType: parse.NodeList, Nodes: []parse.Node{&parse.TextNode{NodeType: parse.NodeText, Text: raw}}}
+		annotate(tree, src, 0)
+		if _, err := parsed.tmpl.AddParseTree(name, tree); err != nil {
+			return chartTemplate{}, fmt.Errorf("add raw file %s: %w", name, err)
+		}
+		return chartTemplate{name: name}, nil
+	}
+	if output && isHook(src) {
+		if _, err := parsed.tmpl.New(name).Parse(src); err != nil {
+			return chartTemplate{}, fmt.Errorf("parse template %s: %w", name, err)
+		}
+		return chartTemplate{name: name, hook: true}, nil
+	}
+	var (
+		fm     *frontMatter
+		offset int
+	)
+	if output {
+		fm, src, offset, err = splitFrontMatter(src)
+		if err != nil {
+			return chartTemplate{}, fmt.Errorf("template %s: %w", name, err)
+		}
+	}
+
+	t, err := parsed.tmpl.New(name).Parse(src)
+	if err != nil {
+		return chartTemplate{}, fmt.Errorf("parse template %s: %w", name, err)
+	}
+	if output {
+		annotate(t.Tree, src, offset)
+	}
+	return chartTemplate{name: name, frontMatter: fm}, nil
+}
+
+// IsStackTemplate reports whether the chart file name, with content src,
+// is a template whose output is joined with that of other templates into
+// the stack: a template under templates/ that is neither a hook nor
+// generated from front matter.
+func IsStackTemplate(name, src string) bool {
+	name = filepath.ToSlash(name)
+	if !strings.HasPrefix(name, templatesDir+"/") || !strings.HasSuffix(name, templateSuffix) || isHook(src) {
+		return false
+	}
+	fm, _, _, err := splitFrontMatter(src)
+	return fm == nil && err == nil
+}
+
+// isHook reports whether the leading comment lines of src carry the hook
+// annotation.
+func isHook(src string) bool {
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			return false
+		}
+		if strings.HasPrefix(strings.TrimSpace(strings.TrimPrefix(line, "#")), hookAnnotation) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateYAML decodes every document of data, the rendered output from
+// line offset+1 on, and maps parse errors back to the template line that
+// produced the offending output.
+func validateYAML(data []byte, sm *SourceMap, offset int) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return mapYAMLError(sm, offset, err)
+		}
+	}
+}
+
+func mapYAMLError(sm *SourceMap, offset int, err error) error {
+	match := yamlLinePattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return fmt.Errorf("invalid yaml: %w", err)
+	}
+	line, convErr := strconv.Atoi(match[1])
+	if convErr != nil {
+		return fmt.Errorf("invalid yaml: %w", err)
+	}
+	return sm.Wrap(offset+line, fmt.Errorf("invalid yaml: %s", match[2]))
+}
@@ -0,0 +1,126 @@
+package render
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+)
+
+// Limits of a Sandbox that leaves them unset.
+const (
+	DefaultSandboxTimeout   = 30 * time.Second
+	DefaultSandboxMaxOutput = 64 << 20
+)
+
+// Sandbox confines the rendering of an untrusted chart: templates, helpers
+// and .Files are only read from inside the chart directory, and the
+// execution of its templates is bounded in time and output.
+type Sandbox struct {
+	// Timeout bounds the execution of all templates of a render,
+	// DefaultSandboxTimeout when zero.
+	Timeout time.Duration
+	// MaxOutput bounds the bytes all templates of a render may output,
+	// including named templates whose output is captured by include,
+	// DefaultSandboxMaxOutput when zero.
+	MaxOutput int64
+}
+
+// confine fails in the sandbox when path leads out of the chart.
+func (r *Renderer) confine(path string) error {
+	if r.cfg.Sandbox == nil {
+		return nil
+	}
+	ok, err := chart.Contains(r.cfg.ChartPath, path)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s is outside the chart, which the sandbox does not allow", path)
+	}
+	return nil
+}
+
+// executor executes the templates of one render, within the limits of the
+// sandbox when there is one.
+type executor struct {
+	ctx     context.Context
+	sandbox *Sandbox
+	mu      sync.Mutex
+	// limit is the output the templates may write, remaining what is
+	// left of it.
+	limit, remaining int64
+}
+
+// newExecutor returns the executor of a render and a function releasing
+// it.
+func (r *Renderer) newExecutor(ctx context.Context) (*executor, context.CancelFunc) {
+	sb := r.cfg.Sandbox
+	if sb == nil {
+		return &executor{ctx: ctx}, func() {}
+	}
+	timeout, limit := sb.Timeout, sb.MaxOutput
+	if timeout <= 0 {
+		timeout = DefaultSandboxTimeout
+	}
+	if limit <= 0 {
+		limit = DefaultSandboxMaxOutput
+	}
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, fmt.Errorf("templates exceeded the sandbox time limit of %s", timeout))
+	return &executor{ctx: ctx, sandbox: sb, limit: limit, remaining: limit}, cancel
+}
+
+// execute executes the template name into w. In the sandbox templates run
+// aside, so that one looping without output is abandoned when the time
+// limit passes; nothing it writes afterwards reaches w.
+func (e *executor) execute(w io.Writer, tmpl *template.Template, name string, data any) error {
+	if e.sandbox == nil {
+		return tmpl.ExecuteTemplate(w, name, data)
+	}
+	lw := &limitedWriter{w: w, e: e}
+	done := make(chan error, 1)
+	go func() { done <- tmpl.ExecuteTemplate(lw, name, data) }()
+	select {
+	case err := <-done:
+		return err
+	case <-e.ctx.Done():
+		e.mu.Lock()
+		lw.closed = true
+		e.mu.Unlock()
+		return context.Cause(e.ctx)
+	}
+}
+
+// writer returns w limited by the sandbox, for output templates capture,
+// e.g. with include. A nil executor leaves w as it is.
+func (e *executor) writer(w io.Writer) io.Writer {
+	if e == nil || e.sandbox == nil {
+		return w
+	}
+	return &limitedWriter{w: w, e: e}
+}
+
+// limitedWriter passes template output on to w while the executor has
+// output and time left.
+type limitedWriter struct {
+	w      io.Writer
+	e      *executor
+	closed bool
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	lw.e.mu.Lock()
+	defer lw.e.mu.Unlock()
+	if lw.closed || lw.e.ctx.Err() != nil {
+		return 0, context.Cause(lw.e.ctx)
+	}
+	if int64(len(p)) > lw.e.remaining {
+		return 0, fmt.Errorf("templates exceeded the sandbox output limit of %d bytes", lw.e.limit)
+	}
+	lw.e.remaining -= int64(len(p))
+	return lw.w.Write(p)
+}
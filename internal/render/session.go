@@ -0,0 +1,67 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// sessionTemplate names the template snippets of a Session are parsed as.
+const sessionTemplate = "<debug>"
+
+// Session evaluates template snippets against a chart, with the helpers,
+// functions and dot its templates see. Named templates defined by a
+// snippet stay defined for later ones.
+type Session struct {
+	tmpl *template.Template
+	data map[string]any
+}
+
+// NewSession parses the chart and returns a Session evaluating snippets
+// against values. Library charts can be debugged too, although they
+// cannot be rendered.
+func (r *Renderer) NewSession(values map[string]any) (*Session, error) {
+	parsed, err := r.parse(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{tmpl: parsed.tmpl, data: r.data(values)}, nil
+}
+
+// Eval executes src and returns its output. A snippet without actions is
+// taken as a single pipeline, so ".Values.image | toYaml" evaluates like
+// "{{ .Values.image | toYaml }}".
+func (s *Session) Eval(src string) (string, error) {
+	if !strings.Contains(src, "{{") {
+		src = "{{ " + src + " }}"
+	}
+	t, err := s.tmpl.New(sessionTemplate).Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("parse: %w", err)
+	}
+	var out bytes.Buffer
+	if err := t.Execute(&out, s.data); err != nil {
+		return "", fmt.Errorf("execute: %w", err)
+	}
+	return out.String(), nil
+}
+
+// Data returns the dot snippets are executed with.
+func (s *Session) Data() map[string]any {
+	return s.data
+}
+
+// Templates lists the names of the chart templates and of the named
+// templates defined by helpers and snippets, sorted.
+func (s *Session) Templates() []string {
+	var names []string
+	for _, t := range s.tmpl.Templates() {
+		if name := t.Name(); name != sessionTemplate {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
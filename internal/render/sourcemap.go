@@ -0,0 +1,272 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template/parse"
+
+	"github.com/acebelowzero/tmpl/internal/compose"
+)
+
+// Line markers are injected into template text before execution and stripped
+// from the output afterwards. Each marker records the template line that
+// produced the text that follows it.
+const (
+	markerStart = '\x1e'
+	markerEnd   = '\x1f'
+)
+
+// Location identifies a line in a chart template.
+type Location struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// String formats the location as file:line.
+func (l Location) String() string {
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// SourceMap maps lines of rendered output back to template source lines.
+type SourceMap struct {
+	lines []Location
+}
+
+// Lookup returns the template location for a 1-based rendered output line.
+func (m *SourceMap) Lookup(line int) (Location, bool) {
+	if m == nil || line < 1 || line > len(m.lines) {
+		return Location{}, false
+	}
+	loc := m.lines[line-1]
+	return loc, loc.File != ""
+}
+
+// Wrap attributes err to the template location of a rendered output line.
+// The error is returned unchanged when the line cannot be mapped.
+func (m *SourceMap) Wrap(line int, err error) error {
+	if err == nil {
+		return nil
+	}
+	loc, ok := m.Lookup(line)
+	if !ok {
+		return err
+	}
+	return &Error{Location: loc, RenderedLine: line, Err: err}
+}
+
+// ParseStack parses output rendered with source map m into a compose stack,
+// reporting the documents that are not valid compose at the template line
+// they were rendered from.
+func ParseStack(output []byte, m *SourceMap) (*compose.Stack, error) {
+	s, err := compose.Parse(output)
+	if err == nil || m == nil {
+		return s, err
+	}
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	mapped := make([]error, 0, len(errs))
+	for _, e := range errs {
+		var decodeErr *compose.DecodeError
+		if errors.As(e, &decodeErr) && decodeErr.Line > 0 {
+			if _, ok := m.Lookup(decodeErr.Line); ok {
+				e = m.Wrap(decodeErr.Line, &compose.DecodeError{Document: decodeErr.Document, Err: decodeErr.Err})
+			}
+		}
+		mapped = append(mapped, e)
+	}
+	if len(mapped) == 1 {
+		return nil, mapped[0]
+	}
+	return nil, errors.Join(mapped...)
+}
+
+// Error reports a failure attributed to a template source location.
+type Error struct {
+	Location     Location
+	RenderedLine int
+	Err          error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v (rendered line %d)", e.Location, e.Err, e.RenderedLine)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// annotate injects line markers into the tree: before every action and at
+// the start of every text line. Only the top-level tree of a template file is
+// annotated: text produced by define blocks is routed through include, where
+// markers would leak into values compared or transformed by template code.
+// offset is added to every line for sources that had a prefix removed.
+func annotate(tree *parse.Tree, src string, offset int) {
+	if tree == nil || tree.Root == nil {
+		return
+	}
+	annotateList(tree.Root, src, offset)
+}
+
+func annotateList(list *parse.ListNode, src string, offset int) {
+	if list == nil {
+		return
+	}
+	nodes := make([]parse.Node, 0, len(list.Nodes))
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.TextNode:
+			annotateText(n, src, offset)
+		case *parse.ActionNode, *parse.TemplateNode:
+			var buf bytes.Buffer
+			writeMarker(&buf, offset+lineAt(src, int(n.Position())))
+			nodes = append(nodes, &parse.TextNode{NodeType: parse.NodeText, Pos: n.Position(), Text: buf.Bytes()})
+		case *parse.IfNode:
+			annotateList(n.List, src, offset)
+			annotateList(n.ElseList, src, offset)
+		case *parse.RangeNode:
+			annotateList(n.List, src, offset)
+			annotateList(n.ElseList, src, offset)
+		case *parse.WithNode:
+			annotateList(n.List, src, offset)
+			annotateList(n.ElseList, src, offset)
+		case *parse.ListNode:
+			annotateList(n, src, offset)
+		}
+		nodes = append(nodes, node)
+	}
+	list.Nodes = nodes
+}
+
+func annotateText(n *parse.TextNode, src string, offset int) {
+	if len(n.Text) == 0 {
+		return
+	}
+	line := offset + lineAt(src, int(n.Position()))
+
+	var buf bytes.Buffer
+	buf.Grow(len(n.Text) + 8)
+	writeMarker(&buf, line)
+	for _, b := range n.Text {
+		buf.WriteByte(b)
+		if b == '\n' {
+			line++
+			writeMarker(&buf, line)
+		}
+	}
+	n.Text = buf.Bytes()
+}
+
+func lineAt(src string, pos int) int {
+	if pos > len(src) {
+		pos = len(src)
+	}
+	return 1 + strings.Count(src[:pos], "\n")
+}
+
+func writeMarker(buf *bytes.Buffer, line int) {
+	buf.WriteByte(markerStart)
+	buf.WriteString(strconv.Itoa(line))
+	buf.WriteByte(markerEnd)
+}
+
+// stripMarkers returns rendered without its line markers.
+func stripMarkers(rendered []byte) []byte {
+	var out []byte
+	for len(rendered) > 0 {
+		start := bytes.IndexByte(rendered, markerStart)
+		if start < 0 {
+			return append(out, rendered...)
+		}
+		out = append(out, rendered[:start]...)
+		end := bytes.IndexByte(rendered[start:], markerEnd)
+		if end < 0 {
+			return out
+		}
+		rendered = rendered[start+end+1:]
+	}
+	return out
+}
+
+// sourceMapBuilder accumulates output lines and their locations across
+// several rendered templates.
+type sourceMapBuilder struct {
+	lines []Location
+}
+
+// append strips markers from rendered, writes the result to out and records
+// the template location of every emitted line. A line takes the location of
+// a marker preceding its first byte; lines starting without one continue
+// multi-line action output and take the location of the last marker before
+// them.
+func (b *sourceMapBuilder) append(out *bytes.Buffer, rendered []byte, file string) {
+	var (
+		last      int
+		startLast int
+		current   int
+		lineOpen  bool
+	)
+	endLine := func() {
+		line := current
+		if line == 0 {
+			line = startLast
+		}
+		b.lines = append(b.lines, Location{File: file, Line: line})
+		startLast = last
+		current = 0
+		lineOpen = false
+	}
+
+	for i := 0; i < len(rendered); i++ {
+		c := rendered[i]
+		if c == markerStart {
+			if end := bytes.IndexByte(rendered[i:], markerEnd); end > 0 {
+				if line, err := strconv.Atoi(string(rendered[i+1 : i+end])); err == nil {
+					last = line
+					if current == 0 && !lineOpen {
+						current = line
+					}
+				}
+				i += end
+				continue
+			}
+		}
+		out.WriteByte(c)
+		lineOpen = true
+		if c == '\n' {
+			endLine()
+		}
+	}
+	if lineOpen {
+		endLine()
+	}
+}
+
+func (b *sourceMapBuilder) build() *SourceMap {
+	return &SourceMap{lines: b.lines}
+}
+
+// truncate forgets the lines recorded after the first n.
+func (b *sourceMapBuilder) truncate(n int) {
+	b.lines = b.lines[:n]
+}
+
+// unmapped records n lines without a location.
+func (b *sourceMapBuilder) unmapped(n int) {
+	for i := 0; i < n; i++ {
+		b.lines = append(b.lines, Location{})
+	}
+}
+
+// appendPlain writes text that did not originate from a template, such as
+// document separators, recording its lines without a location.
+func (b *sourceMapBuilder) appendPlain(out *bytes.Buffer, text string) {
+	out.WriteString(text)
+	for i := 0; i < bytes.Count([]byte(text), []byte{'\n'}); i++ {
+		b.lines = append(b.lines, Location{})
+	}
+}
@@ -0,0 +1,99 @@
+package render
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/acebelowzero/tmpl/internal/compose"
+)
+
+func TestSourceMapAfterTemplateWithoutTrailingNewline(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Chart.yaml":       "apiVersion: v1\nname: app\nversion: 0.1.0\n",
+		"templates/a.tmpl": "services:\n  web:\n    image: nginx",
+		"templates/b.tmpl": "services:\n  api:\n    image: {{ .Values.image }}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := New(Config{ChartPath: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := r.Render(context.Background(), map[string]any{"image": "alpine"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "services:\n  web:\n    image: nginx\n---\nservices:\n  api:\n    image: alpine\n"
+	if got := string(result.Output); got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+	tests := []struct {
+		line int
+		want Location
+		ok   bool
+	}{
+		{1, Location{File: "templates/a.tmpl", Line: 1}, true},
+		{3, Location{File: "templates/a.tmpl", Line: 3}, true},
+		{4, Location{}, false},
+		{5, Location{File: "templates/b.tmpl", Line: 1}, true},
+		{7, Location{File: "templates/b.tmpl", Line: 3}, true},
+		{8, Location{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := result.SourceMap.Lookup(tt.line)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Lookup(%d) = %v, %v, want %v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseStackMapsComposeErrorsToTemplates(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Chart.yaml":       "apiVersion: v1\nname: app\nversion: 0.1.0\n",
+		"templates/a.tmpl": "services:\n  web:\n    image: nginx\n",
+		"templates/b.tmpl": "services:\n  api:\n    image: {{ .Values.image }}\n    deploy:\n      replicas: many\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := New(Config{ChartPath: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := r.Render(context.Background(), map[string]any{"image": "alpine"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ParseStack(result.Output, result.SourceMap)
+	var renderErr *Error
+	if !errors.As(err, &renderErr) {
+		t.Fatalf("ParseStack error = %v, want a template location", err)
+	}
+	if want := (Location{File: "templates/b.tmpl", Line: 5}); renderErr.Location != want {
+		t.Errorf("location = %v, want %v", renderErr.Location, want)
+	}
+	var decodeErr *compose.DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Document != 2 {
+		t.Errorf("error = %v, want a decode error of document 2", err)
+	}
+}
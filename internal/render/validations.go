@@ -0,0 +1,106 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+)
+
+// ValidationError reports every validation of a chart the values fail.
+type ValidationError struct {
+	Failures []string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Failures) == 1 {
+		return "values validation failed: " + e.Failures[0]
+	}
+	return fmt.Sprintf("%d values validations failed:\n  %s", len(e.Failures), strings.Join(e.Failures, "\n  "))
+}
+
+// checkValidations evaluates the validations of Chart.yaml against data
+// and returns a *ValidationError listing those that fail. Validations read
+// missing keys as empty even in strict renders, since checking for them is
+// what they are for.
+func (r *Renderer) checkValidations(exec *executor, tmpl *template.Template, data map[string]any) error {
+	if len(r.chart.Validations) == 0 {
+		return nil
+	}
+	lenient, err := tmpl.Clone()
+	if err != nil {
+		return err
+	}
+	lenient.Option("missingkey=zero")
+	var failures []string
+	for i, v := range r.chart.Validations {
+		scopes := []map[string]any{data}
+		if v.Each != "" {
+			items, err := evalItems(exec, lenient, v.Each, data)
+			if err != nil {
+				return fmt.Errorf("validations[%d]: %w", i, err)
+			}
+			scopes = scopes[:0]
+			for _, it := range items {
+				scopes = append(scopes, withItem(data, it))
+			}
+		}
+		for _, scope := range scopes {
+			message, err := checkValidation(exec, lenient, v, scope)
+			if err != nil {
+				return fmt.Errorf("validations[%d]: %w", i, err)
+			}
+			if message != "" {
+				failures = append(failures, message)
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return &ValidationError{Failures: failures}
+	}
+	return nil
+}
+
+// checkValidation evaluates v in scope and returns its message when the
+// values fail it.
+func checkValidation(exec *executor, tmpl *template.Template, v chart.Validation, scope map[string]any) (string, error) {
+	if v.When != "" {
+		when, err := evalPipeline(exec, tmpl, "when", v.When, scope)
+		if err != nil {
+			return "", err
+		}
+		if ok, _ := template.IsTrue(when); !ok {
+			return "", nil
+		}
+	}
+	holds, err := evalPipeline(exec, tmpl, "assert", v.Assert, scope)
+	if err != nil {
+		return "", err
+	}
+	if ok, _ := template.IsTrue(holds); ok {
+		return "", nil
+	}
+	fallback := "assert " + strings.TrimSpace(v.Assert) + " is false"
+	if v.Message == "" {
+		return fallback, nil
+	}
+	clone, err := tmpl.Clone()
+	if err != nil {
+		return "", err
+	}
+	t, err := clone.New("message").Parse(v.Message)
+	if err != nil {
+		return "", fmt.Errorf("parse message: %w", err)
+	}
+	var message bytes.Buffer
+	if err := exec.execute(&message, t, t.Name(), scope); err != nil {
+		return "", fmt.Errorf("execute message: %w", err)
+	}
+	text := strings.TrimSpace(string(stripMarkers(bytes.ReplaceAll(message.Bytes(), []byte("<no value>"), nil))))
+	if text == "" {
+		return fallback, nil
+	}
+	return text, nil
+}
@@ -0,0 +1,180 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/semver"
+)
+
+// IndexFile is the name of the index at the root of a chart repository.
+const IndexFile = "index.yaml"
+
+// Entry describes one packaged chart version in an index.
+type Entry struct {
+	Name        string             `yaml:"name" json:"name"`
+	Version     string             `yaml:"version" json:"version"`
+	AppVersion  string             `yaml:"appVersion,omitempty" json:"appVersion,omitempty"`
+	Description string             `yaml:"description,omitempty" json:"description,omitempty"`
+	Digest      string             `yaml:"digest" json:"digest"`
+	URLs        []string           `yaml:"urls" json:"urls"`
+	Created     time.Time          `yaml:"created,omitempty" json:"created,omitempty"`
+	Type        string             `yaml:"type,omitempty" json:"type,omitempty"`
+	Home        string             `yaml:"home,omitempty" json:"home,omitempty"`
+	Icon        string             `yaml:"icon,omitempty" json:"icon,omitempty"`
+	Sources     []string           `yaml:"sources,omitempty" json:"sources,omitempty"`
+	Keywords    []string           `yaml:"keywords,omitempty" json:"keywords,omitempty"`
+	Maintainers []chart.Maintainer `yaml:"maintainers,omitempty" json:"maintainers,omitempty"`
+	TmplVersion string             `yaml:"tmplVersion,omitempty" json:"tmplVersion,omitempty"`
+	Deprecated  bool               `yaml:"deprecated,omitempty" json:"deprecated,omitempty"`
+	ReplacedBy  string             `yaml:"replacedBy,omitempty" json:"replacedBy,omitempty"`
+}
+
+// Index lists the charts published in a repository.
+type Index struct {
+	APIVersion string             `yaml:"apiVersion"`
+	Generated  time.Time          `yaml:"generated"`
+	Entries    map[string][]Entry `yaml:"entries"`
+}
+
+// LoadIndex decodes an index and sorts each chart's entries newest first.
+func LoadIndex(data []byte) (*Index, error) {
+	idx := &Index{}
+	if err := yaml.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("decode index: %w", err)
+	}
+	if idx.Entries == nil {
+		idx.Entries = map[string][]Entry{}
+	}
+	idx.sort()
+	return idx, nil
+}
+
+// Add records an entry, replacing an existing entry of the same version.
+func (i *Index) Add(e Entry) {
+	if i.Entries == nil {
+		i.Entries = map[string][]Entry{}
+	}
+	entries := i.Entries[e.Name]
+	for n, existing := range entries {
+		if existing.Version == e.Version {
+			entries[n] = e
+			i.sort()
+			return
+		}
+	}
+	i.Entries[e.Name] = append(entries, e)
+	i.sort()
+}
+
+func (i *Index) sort() {
+	for _, entries := range i.Entries {
+		sort.SliceStable(entries, func(a, b int) bool {
+			va, errA := semver.Parse(entries[a].Version)
+			vb, errB := semver.Parse(entries[b].Version)
+			if errA != nil || errB != nil {
+				return entries[a].Version > entries[b].Version
+			}
+			return vb.LessThan(va)
+		})
+	}
+}
+
+// Find returns the newest entry of name satisfying the version constraint.
+// An empty constraint selects the newest release version.
+func (i *Index) Find(name, constraint string) (Entry, error) {
+	entries, ok := i.Entries[name]
+	if !ok {
+		return Entry{}, fmt.Errorf("chart %s not found", name)
+	}
+	c, err := semver.ParseConstraint(constraint)
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, e := range entries {
+		v, err := semver.Parse(e.Version)
+		if err != nil {
+			continue
+		}
+		if c.Check(v) {
+			return e, nil
+		}
+	}
+	if constraint == "" {
+		return Entry{}, fmt.Errorf("chart %s has no release versions", name)
+	}
+	return Entry{}, fmt.Errorf("no version of chart %s matches %q", name, constraint)
+}
+
+// Marshal encodes the index as YAML.
+func (i *Index) Marshal() ([]byte, error) {
+	return yaml.Marshal(i)
+}
+
+// IndexDir builds an index from the packaged charts in dir. Entry URLs are
+// archive file names, prefixed with baseURL when given.
+func IndexDir(dir, baseURL string) (*Index, error) {
+	archives, err := filepath.Glob(filepath.Join(dir, "*.tgz"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(archives)
+
+	idx := &Index{APIVersion: "v1", Generated: time.Now().UTC(), Entries: map[string][]Entry{}}
+	for _, path := range archives {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		meta, err := archiveMetadata(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		url := filepath.Base(path)
+		if baseURL != "" {
+			url = strings.TrimSuffix(baseURL, "/") + "/" + url
+		}
+		idx.Add(Entry{
+			Name:        meta.Name,
+			Version:     meta.Version,
+			AppVersion:  meta.AppVersion,
+			Description: meta.Description,
+			Digest:      chart.Digest(data),
+			URLs:        []string{url},
+			Created:     info.ModTime().UTC(),
+			Type:        meta.Type,
+			Home:        meta.Home,
+			Icon:        meta.Icon,
+			Sources:     meta.Sources,
+			Keywords:    meta.Keywords,
+			Maintainers: meta.Maintainers,
+			TmplVersion: meta.TmplVersion,
+			Deprecated:  meta.Deprecated,
+			ReplacedBy:  meta.ReplacedBy,
+		})
+	}
+	return idx, nil
+}
+
+func archiveMetadata(data []byte) (*chart.Metadata, error) {
+	dir, err := os.MkdirTemp("", "tmpl-index-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if _, err := chart.Extract(data, dir); err != nil {
+		return nil, err
+	}
+	return chart.LoadMetadata(dir)
+}
@@ -0,0 +1,414 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/paths"
+	"github.com/acebelowzero/tmpl/internal/provenance"
+	"github.com/acebelowzero/tmpl/internal/source"
+	"github.com/acebelowzero/tmpl/internal/telemetry"
+)
+
+// RepositoriesFile is the name of the repository list in the config dir.
+const RepositoriesFile = "repositories.yaml"
+
+// Repository is a named chart repository.
+type Repository struct {
+	Name string `yaml:"name" json:"name"`
+	URL  string `yaml:"url" json:"url"`
+}
+
+// File is the list of configured repositories.
+type File struct {
+	Repositories []Repository `yaml:"repositories"`
+}
+
+// Get returns the repository with the given name.
+func (f *File) Get(name string) (Repository, bool) {
+	for _, r := range f.Repositories {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Repository{}, false
+}
+
+// Manager maintains configured repositories and their cached indexes.
+type Manager struct {
+	file     string
+	cacheDir string
+	sources  *source.Factory
+}
+
+// NewManager constructs a Manager using the default config and cache dirs.
+func NewManager() (*Manager, error) {
+	configDir, err := paths.ConfigDir()
+	if err != nil {
+		return nil, fmt.Errorf("resolve config dir: %w", err)
+	}
+	cacheDir, err := paths.CacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("resolve cache dir: %w", err)
+	}
+	return &Manager{
+		file:     filepath.Join(configDir, RepositoriesFile),
+		cacheDir: filepath.Join(cacheDir, "repository"),
+		sources:  source.NewFactory(),
+	}, nil
+}
+
+// Load reads the repository list; a missing file yields an empty list.
+func (m *Manager) Load() (*File, error) {
+	data, err := os.ReadFile(m.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &File{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", m.file, err)
+	}
+	f := &File{}
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", m.file, err)
+	}
+	return f, nil
+}
+
+func (m *Manager) save(f *File) error {
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.file), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(m.file, data, 0o644)
+}
+
+// Add registers a repository and downloads its index.
+func (m *Manager) Add(ctx context.Context, name, url string) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid repository name %q", name)
+	}
+	f, err := m.Load()
+	if err != nil {
+		return err
+	}
+	if _, ok := f.Get(name); ok {
+		return fmt.Errorf("repository %s already exists", name)
+	}
+	r := Repository{Name: name, URL: strings.TrimSuffix(url, "/")}
+	if err := m.updateOne(ctx, r); err != nil {
+		return err
+	}
+	f.Repositories = append(f.Repositories, r)
+	return m.save(f)
+}
+
+// Remove unregisters a repository and drops its cached index.
+func (m *Manager) Remove(name string) error {
+	f, err := m.Load()
+	if err != nil {
+		return err
+	}
+	kept := f.Repositories[:0]
+	found := false
+	for _, r := range f.Repositories {
+		if r.Name == name {
+			found = true
+			continue
+		}
+		kept = append(kept, r)
+	}
+	if !found {
+		return fmt.Errorf("repository %s not found", name)
+	}
+	f.Repositories = kept
+	_ = os.Remove(m.indexPath(name))
+	return m.save(f)
+}
+
+// Update refreshes the cached indexes of the named repositories, or of all
+// repositories when none are given.
+func (m *Manager) Update(ctx context.Context, names ...string) error {
+	f, err := m.Load()
+	if err != nil {
+		return err
+	}
+	targets := f.Repositories
+	if len(names) > 0 {
+		targets = nil
+		for _, name := range names {
+			r, ok := f.Get(name)
+			if !ok {
+				return fmt.Errorf("repository %s not found", name)
+			}
+			targets = append(targets, r)
+		}
+	}
+	var errs []error
+	for _, r := range targets {
+		if err := m.updateOne(ctx, r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Manager) updateOne(ctx context.Context, r Repository) error {
+	data, err := m.fetch(ctx, r.URL+"/"+IndexFile)
+	if err != nil {
+		return fmt.Errorf("fetch index of %s: %w", r.Name, err)
+	}
+	if _, err := LoadIndex(data); err != nil {
+		return fmt.Errorf("repository %s: %w", r.Name, err)
+	}
+	if err := os.MkdirAll(m.cacheDir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(m.indexPath(r.Name), data, 0o644)
+}
+
+func (m *Manager) indexPath(name string) string {
+	return filepath.Join(m.cacheDir, name+"-index.yaml")
+}
+
+// Index returns the cached index of a repository.
+func (m *Manager) Index(name string) (*Index, error) {
+	data, err := os.ReadFile(m.indexPath(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no cached index for repository %s; run 'tmpl repo update'", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return LoadIndex(data)
+}
+
+// SearchResult is an entry matched by Search.
+type SearchResult struct {
+	Repository string `json:"repository"`
+	Entry
+}
+
+// Search returns the newest entry of every chart whose name, description
+// or keywords contain term, across all repositories.
+func (m *Manager) Search(term string) ([]SearchResult, error) {
+	f, err := m.Load()
+	if err != nil {
+		return nil, err
+	}
+	term = strings.ToLower(term)
+	var results []SearchResult
+	for _, r := range f.Repositories {
+		idx, err := m.Index(r.Name)
+		if err != nil {
+			return nil, err
+		}
+		for name, entries := range idx.Entries {
+			if len(entries) == 0 {
+				continue
+			}
+			latest := entries[0]
+			qualified := r.Name + "/" + name
+			if term == "" || strings.Contains(strings.ToLower(qualified), term) || strings.Contains(strings.ToLower(latest.Description), term) ||
+				matchesKeyword(latest.Keywords, term) {
+				results = append(results, SearchResult{Repository: r.Name, Entry: latest})
+			}
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Repository != results[j].Repository {
+			return results[i].Repository < results[j].Repository
+		}
+		return results[i].Name < results[j].Name
+	})
+	return results, nil
+}
+
+// matchesKeyword reports whether one of keywords contains the lower case
+// term.
+func matchesKeyword(keywords []string, term string) bool {
+	for _, k := range keywords {
+		if strings.Contains(strings.ToLower(k), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsReference reports whether ref names a chart in a configured repository
+// ("repo/chart") rather than a local path.
+func (m *Manager) IsReference(ref string) bool {
+	if _, err := os.Stat(ref); err == nil {
+		return false
+	}
+	name, _, ok := strings.Cut(ref, "/")
+	if !ok {
+		return false
+	}
+	f, err := m.Load()
+	if err != nil {
+		return false
+	}
+	_, ok = f.Get(name)
+	return ok
+}
+
+// Fetch resolves "repo/chart" against the cached index, downloads the
+// newest archive satisfying constraint, verifies its digest and extracts
+// it into the cache. It returns the chart directory and the chosen entry.
+// Fetch is safe for concurrent use.
+func (m *Manager) Fetch(ctx context.Context, ref, constraint string) (string, Entry, error) {
+	repoName, chartName, ok := strings.Cut(ref, "/")
+	if !ok {
+		return "", Entry{}, fmt.Errorf("invalid chart reference %q, expected REPO/CHART", ref)
+	}
+	f, err := m.Load()
+	if err != nil {
+		return "", Entry{}, err
+	}
+	r, ok := f.Get(repoName)
+	if !ok {
+		return "", Entry{}, fmt.Errorf("repository %s not found", repoName)
+	}
+	idx, err := m.Index(repoName)
+	if err != nil {
+		return "", Entry{}, err
+	}
+	entry, err := idx.Find(chartName, constraint)
+	if err != nil {
+		return "", Entry{}, fmt.Errorf("repository %s: %w", repoName, err)
+	}
+	if len(entry.URLs) == 0 {
+		return "", Entry{}, fmt.Errorf("chart %s %s has no download URL", ref, entry.Version)
+	}
+
+	if dir, ok := m.chartDir(entry.Digest); ok {
+		if _, err := os.Stat(filepath.Join(dir, chart.MetadataFile)); err == nil {
+			telemetry.CountCacheLookup(ctx, "chart", true)
+			return dir, entry, nil
+		}
+	}
+	telemetry.CountCacheLookup(ctx, "chart", false)
+
+	data, err := m.fetch(ctx, archiveURL(r, entry))
+	if err != nil {
+		return "", Entry{}, fmt.Errorf("download %s %s: %w", ref, entry.Version, err)
+	}
+	digest := chart.Digest(data)
+	if entry.Digest != "" && digest != entry.Digest {
+		return "", Entry{}, fmt.Errorf("digest mismatch for %s %s: index has %s, archive is %s", ref, entry.Version, entry.Digest, digest)
+	}
+
+	// The archive is cached under its own digest, as the index may not
+	// list one.
+	dir, _ := m.chartDir(digest)
+	if err := extractInto(data, dir); err != nil {
+		return "", Entry{}, fmt.Errorf("extract %s %s: %w", ref, entry.Version, err)
+	}
+	return dir, entry, nil
+}
+
+// extractInto extracts the chart archive data into dir, the cache directory
+// of its digest. The archive is extracted next to dir and renamed into
+// place, so readers of the cache never see a chart half extracted or
+// replaced.
+func extractInto(data []byte, dir string) error {
+	parent := filepath.Dir(dir)
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(parent, ".tmpl-chart-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if _, err := chart.Extract(data, tmp); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dir); err == nil {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(dir, chart.MetadataFile)); err == nil {
+		// Another fetch extracted the chart first.
+		return nil
+	}
+	// Left over by an interrupted extraction of older versions.
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
+
+// chartDir returns the cache directory of the chart archive with the given
+// digest. It reports false for digests that are not a sha256 digest, such
+// as the empty digest of index entries that list none.
+func (m *Manager) chartDir(digest string) (string, bool) {
+	hex, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || len(hex) != 64 || strings.Trim(hex, "0123456789abcdef") != "" {
+		return "", false
+	}
+	return filepath.Join(m.cacheDir, "charts", hex), true
+}
+
+// FetchProvenance downloads the provenance file published next to the
+// archive of entry, a chart of the repository of ref, and its signature
+// when there is one.
+func (m *Manager) FetchProvenance(ctx context.Context, ref string, entry Entry) (provenance.Files, error) {
+	repoName, _, _ := strings.Cut(ref, "/")
+	f, err := m.Load()
+	if err != nil {
+		return provenance.Files{}, err
+	}
+	r, ok := f.Get(repoName)
+	if !ok {
+		return provenance.Files{}, fmt.Errorf("repository %s not found", repoName)
+	}
+	if len(entry.URLs) == 0 {
+		return provenance.Files{}, fmt.Errorf("chart %s %s has no download URL", ref, entry.Version)
+	}
+	url := archiveURL(r, entry)
+	var files provenance.Files
+	if files.Provenance, err = m.fetch(ctx, provenance.Path(url)); err != nil {
+		return provenance.Files{}, fmt.Errorf("download provenance of %s %s: %w", ref, entry.Version, err)
+	}
+	// Unsigned provenance is reported by its verification.
+	files.Signature, _ = m.fetch(ctx, provenance.SignaturePath(url))
+	return files, nil
+}
+
+// archiveURL returns the URL of the archive of entry, resolving paths
+// relative to the repository.
+func archiveURL(r Repository, entry Entry) string {
+	url := entry.URLs[0]
+	if source.ParseScheme(url) == source.SchemeLocal && !filepath.IsAbs(url) {
+		url = r.URL + "/" + url
+	}
+	return url
+}
+
+// fetch reads a URL through the source factory, or from disk for local
+// repositories.
+func (m *Manager) fetch(ctx context.Context, url string) ([]byte, error) {
+	if source.ParseScheme(url) == source.SchemeLocal {
+		return os.ReadFile(url)
+	}
+	src, err := m.sources.New(url)
+	if err != nil {
+		return nil, err
+	}
+	return src.Fetch(ctx)
+}
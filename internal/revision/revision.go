@@ -0,0 +1,82 @@
+// Package revision describes rendered charts as the release revisions
+// apply records, for the command and the Go API alike.
+package revision
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/compose"
+	"github.com/acebelowzero/tmpl/internal/hook"
+	"github.com/acebelowzero/tmpl/internal/release"
+	"github.com/acebelowzero/tmpl/internal/render"
+	"github.com/acebelowzero/tmpl/internal/source"
+	"github.com/acebelowzero/tmpl/internal/stack"
+	"github.com/acebelowzero/tmpl/internal/values"
+)
+
+// Rendered is a chart rendered for a release and converted into its stack.
+type Rendered struct {
+	// ChartDir is the directory the chart was rendered from.
+	ChartDir  string
+	Namespace string
+	Result    *render.Result
+	// Values are the merged values the chart was rendered with and
+	// UserValues those of the values files alone, without chart defaults.
+	Values     map[string]any
+	UserValues map[string]any
+	// Encrypted maps the values paths decrypted from sops files to them;
+	// see values.Loader.Encrypted.
+	Encrypted map[string]string
+	// Fetched are the remote values files the values were loaded from.
+	Fetched []values.Fetched
+	Stack   *stack.Stack
+}
+
+// New describes r as a release revision to record, with its secrets
+// redacted from the manifest.
+func New(r Rendered) (*release.Release, error) {
+	meta, err := chart.LoadMetadata(r.ChartDir)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := release.ValuesDigest(r.Values)
+	if err != nil {
+		return nil, err
+	}
+	chartDigest, err := chart.DirDigest(r.ChartDir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := hook.ParseAll(r.Result.Hooks); err != nil {
+		return nil, err
+	}
+	chartSource := release.Source{Kind: "chart", URL: r.ChartDir, Revision: source.GitRevision(r.ChartDir)}
+	if abs, err := filepath.Abs(r.ChartDir); err == nil {
+		chartSource.URL = abs
+	}
+	manifest, err := compose.Redact(r.Result.Output)
+	if err != nil {
+		return nil, fmt.Errorf("redact secrets: %w", err)
+	}
+	sources := []release.Source{chartSource}
+	for _, f := range r.Fetched {
+		sources = append(sources, release.Source{Kind: "values", URL: f.URL, Revision: f.Revision})
+	}
+	return &release.Release{
+		Name:         r.Stack.Name,
+		Namespace:    r.Namespace,
+		Chart:        *meta,
+		ChartDigest:  chartDigest,
+		ValuesDigest: digest,
+		Sources:      sources,
+		Manifest:     string(manifest),
+		Notes:        r.Result.Notes,
+		Hooks:        r.Result.Hooks,
+		Values:       r.Values,
+		UserValues:   r.UserValues,
+		Encrypted:    r.Encrypted,
+		Stack:        r.Stack,
+	}, nil
+}
@@ -0,0 +1,112 @@
+package sbom
+
+import (
+	"strings"
+	"time"
+)
+
+// cdxSpecVersion is the CycloneDX version documents follow.
+const cdxSpecVersion = "1.5"
+
+type cdxDocument struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	SerialNumber string          `json:"serialNumber"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type       string        `json:"type"`
+	BOMRef     string        `json:"bom-ref,omitempty"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	PURL       string        `json:"purl,omitempty"`
+	Hashes     []cdxHash     `json:"hashes,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// cycloneDX describes s as a CycloneDX document: the chart is the
+// component described, library charts and images are its components.
+func cycloneDX(s Stack, tool Tool, now time.Time) *cdxDocument {
+	root := cdxChart(s.Chart)
+	root.Properties = append(root.Properties, cdxProperty{Name: "tmpl:stack", Value: s.Name})
+	doc := &cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  cdxSpecVersion,
+		SerialNumber: "urn:uuid:" + newUUID(),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: now.UTC().Format(time.RFC3339),
+			Tools:     cdxTools{Components: []cdxComponent{{Type: "application", Name: tool.Name, Version: tool.Version}}},
+			Component: root,
+		},
+		Components: []cdxComponent{},
+	}
+
+	var dependsOn []string
+	for _, dep := range s.Dependencies {
+		c := cdxChart(dep)
+		c.Type = "library"
+		doc.Components = append(doc.Components, c)
+		dependsOn = append(dependsOn, c.BOMRef)
+	}
+	for _, image := range s.Images {
+		c := cdxComponent{Type: "container", BOMRef: "image:" + image.Ref, PURL: imagePURL(image.Ref)}
+		repository, tag, digest := imageParts(image.Ref)
+		c.Name, c.Version = repository, tag
+		if digest != "" {
+			c.Version = digest
+			c.Hashes = digestHashes(digest)
+		}
+		c.Properties = []cdxProperty{{Name: "tmpl:services", Value: strings.Join(image.Services, ",")}}
+		doc.Components = append(doc.Components, c)
+		dependsOn = append(dependsOn, c.BOMRef)
+	}
+	doc.Dependencies = []cdxDependency{{Ref: root.BOMRef, DependsOn: dependsOn}}
+	return doc
+}
+
+func cdxChart(c Chart) cdxComponent {
+	comp := cdxComponent{Type: "application", BOMRef: "chart:" + c.Name + "@" + c.Version, Name: c.Name, Version: c.Version}
+	if c.AppVersion != "" {
+		comp.Properties = append(comp.Properties, cdxProperty{Name: "tmpl:appVersion", Value: c.AppVersion})
+	}
+	comp.Hashes = digestHashes(c.Digest)
+	return comp
+}
+
+// digestHashes returns a sha256 digest as CycloneDX hashes.
+func digestHashes(digest string) []cdxHash {
+	if hex, ok := strings.CutPrefix(digest, "sha256:"); ok {
+		return []cdxHash{{Alg: "SHA-256", Content: hex}}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// Package sbom describes a rendered stack, its chart, the library charts
+// it depends on and the images of its services, as a software bill of
+// materials in the CycloneDX or SPDX JSON format.
+package sbom
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Supported document formats.
+const (
+	FormatCycloneDX = "cyclonedx"
+	FormatSPDX      = "spdx"
+)
+
+// Stack is what a bill of materials describes.
+type Stack struct {
+	// Name is the stack the chart is rendered as.
+	Name  string
+	Chart Chart
+	// Dependencies are the library charts the chart depends on, directly
+	// or through other libraries.
+	Dependencies []Chart
+	Images       []Image
+}
+
+// Chart identifies a chart.
+type Chart struct {
+	Name       string
+	Version    string
+	AppVersion string
+	// Digest is the sha256 digest of the packaged chart, if known.
+	Digest string
+}
+
+// Image is a container image of the stack with the services running it.
+type Image struct {
+	Ref      string
+	Services []string
+}
+
+// Tool names what produced a document.
+type Tool struct {
+	Name    string
+	Version string
+}
+
+// Write encodes s in format to w.
+func Write(w io.Writer, format string, s Stack, tool Tool, now time.Time) error {
+	var doc any
+	switch format {
+	case FormatCycloneDX:
+		doc = cycloneDX(s, tool, now)
+	case FormatSPDX:
+		doc = spdx(s, tool, now)
+	default:
+		return fmt.Errorf("unknown SBOM format %q, expected %s or %s", format, FormatCycloneDX, FormatSPDX)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// imageParts splits an image reference into its repository, as
+// REGISTRY/PATH, its tag and its digest, following the docker conventions
+// for references without a registry or tag.
+func imageParts(ref string) (repository, tag, digest string) {
+	name := ref
+	if n, d, ok := strings.Cut(ref, "@"); ok {
+		name, digest = n, d
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	host, path, ok := strings.Cut(name, "/")
+	if !ok || !strings.ContainsAny(host, ".:") && host != "localhost" {
+		host, path = "docker.io", name
+	}
+	if host == "docker.io" && !strings.Contains(path, "/") {
+		path = "library/" + path
+	}
+	if tag == "" && digest == "" {
+		tag = "latest"
+	}
+	return host + "/" + path, tag, digest
+}
+
+// imagePURL returns the package URL of an image: pkg:oci when it is pinned
+// to a digest, pkg:docker otherwise.
+func imagePURL(ref string) string {
+	repository, tag, digest := imageParts(ref)
+	_, path, _ := strings.Cut(repository, "/")
+	if digest != "" {
+		q := url.Values{"repository_url": {repository}}
+		if tag != "" {
+			q.Set("tag", tag)
+		}
+		name := path[strings.LastIndex(path, "/")+1:]
+		return "pkg:oci/" + name + "@" + strings.ReplaceAll(digest, ":", "%3A") + "?" + q.Encode()
+	}
+	purl := "pkg:docker/" + path + "@" + tag
+	if !strings.HasPrefix(repository, "docker.io/") {
+		purl += "?" + url.Values{"repository_url": {strings.TrimSuffix(repository, "/"+path)}}.Encode()
+	}
+	return purl
+}
+
+// newUUID returns a random version 4 UUID, identifying a document.
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+var invalidID = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// spdxID returns an SPDX element ID made of parts, which may only hold
+// letters, digits, dots and dashes.
+func spdxID(parts ...string) string {
+	return "SPDXRef-" + invalidID.ReplaceAllString(strings.Join(parts, "-"), "-")
+}
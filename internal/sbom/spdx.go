@@ -0,0 +1,124 @@
+package sbom
+
+import (
+	"strings"
+	"time"
+)
+
+// spdxVersion is the SPDX version documents follow.
+const spdxVersion = "SPDX-2.3"
+
+// spdxNoAssertion stands for information a document does not provide.
+const spdxNoAssertion = "NOASSERTION"
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name                  string            `json:"name"`
+	SPDXID                string            `json:"SPDXID"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose"`
+	Checksums             []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+	Comment               string            `json:"comment,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// spdx describes s as an SPDX document describing the chart, which depends
+// on library charts and contains the images.
+func spdx(s Stack, tool Tool, now time.Time) *spdxDocument {
+	root := spdxChart(s.Chart)
+	root.PrimaryPackagePurpose = "APPLICATION"
+	root.Comment = "Rendered as stack " + s.Name
+	doc := &spdxDocument{
+		SPDXVersion:       spdxVersion,
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              s.Name,
+		DocumentNamespace: "urn:uuid:" + newUUID(),
+		CreationInfo: spdxCreationInfo{
+			Created:  now.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: " + tool.Name + "-" + tool.Version},
+		},
+		Packages: []spdxPackage{root},
+		Relationships: []spdxRelationship{
+			{SPDXElementID: "SPDXRef-DOCUMENT", RelationshipType: "DESCRIBES", RelatedSPDXElement: root.SPDXID},
+		},
+	}
+
+	for _, dep := range s.Dependencies {
+		p := spdxChart(dep)
+		p.PrimaryPackagePurpose = "LIBRARY"
+		doc.Packages = append(doc.Packages, p)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{SPDXElementID: root.SPDXID, RelationshipType: "DEPENDS_ON", RelatedSPDXElement: p.SPDXID})
+	}
+	for _, image := range s.Images {
+		repository, tag, digest := imageParts(image.Ref)
+		p := spdxPackage{
+			Name:                  repository,
+			SPDXID:                spdxID("Image", image.Ref),
+			VersionInfo:           tag,
+			DownloadLocation:      spdxNoAssertion,
+			PrimaryPackagePurpose: "CONTAINER",
+			ExternalRefs:          []spdxExternalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: imagePURL(image.Ref)}},
+			Comment:               "Image of services " + strings.Join(image.Services, ", "),
+		}
+		if digest != "" {
+			p.VersionInfo = digest
+			p.Checksums = digestChecksums(digest)
+		}
+		doc.Packages = append(doc.Packages, p)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{SPDXElementID: root.SPDXID, RelationshipType: "CONTAINS", RelatedSPDXElement: p.SPDXID})
+	}
+	return doc
+}
+
+func spdxChart(c Chart) spdxPackage {
+	return spdxPackage{
+		Name:             c.Name,
+		SPDXID:           spdxID("Chart", c.Name, c.Version),
+		VersionInfo:      c.Version,
+		DownloadLocation: spdxNoAssertion,
+		Checksums:        digestChecksums(c.Digest),
+	}
+}
+
+// digestChecksums returns a sha256 digest as SPDX checksums.
+func digestChecksums(digest string) []spdxChecksum {
+	if hex, ok := strings.CutPrefix(digest, "sha256:"); ok {
+		return []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: hex}}
+	}
+	return nil
+}
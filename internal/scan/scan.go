@@ -0,0 +1,221 @@
+// Package scan runs vulnerability scanners against container images and
+// classifies their findings by severity.
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Supported scanners.
+const (
+	ScannerTrivy = "trivy"
+	ScannerGrype = "grype"
+)
+
+// Severity ranks vulnerabilities; higher values are more severe.
+type Severity int
+
+const (
+	SeverityUnknown Severity = iota
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+// ParseSeverity converts a textual severity (unknown, low, medium, high,
+// critical) in any case, as both scanners report them.
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "unknown", "negligible":
+		return SeverityUnknown, nil
+	case "low":
+		return SeverityLow, nil
+	case "medium":
+		return SeverityMedium, nil
+	case "high":
+		return SeverityHigh, nil
+	case "critical":
+		return SeverityCritical, nil
+	default:
+		return SeverityUnknown, fmt.Errorf("unknown severity %q, expected low, medium, high or critical", s)
+	}
+}
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityLow:
+		return "low"
+	case SeverityMedium:
+		return "medium"
+	case SeverityHigh:
+		return "high"
+	case SeverityCritical:
+		return "critical"
+	default:
+		return "unknown"
+	}
+}
+
+// Vulnerability is a finding of a scanner in an image.
+type Vulnerability struct {
+	ID       string
+	Severity Severity
+	// Package and Version name the affected package; FixedVersion is the
+	// version fixing it, empty when there is none.
+	Package      string
+	Version      string
+	FixedVersion string
+}
+
+func (v Vulnerability) String() string {
+	s := fmt.Sprintf("%s (%s) in %s %s", v.ID, v.Severity, v.Package, v.Version)
+	if v.FixedVersion != "" {
+		s += ", fixed in " + v.FixedVersion
+	}
+	return s
+}
+
+// Config selects a scanner.
+type Config struct {
+	// Scanner is trivy or grype.
+	Scanner string
+	// Server is the URL of a trivy server to scan with instead of a local
+	// vulnerability database.
+	Server string
+}
+
+// Scanner abstracts image scanning to facilitate testing.
+type Scanner interface {
+	Scan(ctx context.Context, image string) ([]Vulnerability, error)
+}
+
+type execScanner struct {
+	cfg Config
+}
+
+// New constructs a Scanner backed by the trivy or grype CLI.
+func New(cfg Config) (Scanner, error) {
+	switch cfg.Scanner {
+	case ScannerTrivy:
+	case ScannerGrype:
+		if cfg.Server != "" {
+			return nil, fmt.Errorf("scan server is only supported by %s", ScannerTrivy)
+		}
+	default:
+		return nil, fmt.Errorf("unknown scanner %q, expected %s or %s", cfg.Scanner, ScannerTrivy, ScannerGrype)
+	}
+	if _, err := exec.LookPath(cfg.Scanner); err != nil {
+		return nil, fmt.Errorf("%s binary not found: %w", cfg.Scanner, err)
+	}
+	return &execScanner{cfg: cfg}, nil
+}
+
+// Scan runs the scanner against image and returns its findings, ordered
+// by descending severity and ID.
+func (s *execScanner) Scan(ctx context.Context, image string) ([]Vulnerability, error) {
+	var args []string
+	parse := parseTrivy
+	switch s.cfg.Scanner {
+	case ScannerTrivy:
+		args = []string{"image", "--quiet", "--format", "json", "--scanners", "vuln"}
+		if s.cfg.Server != "" {
+			args = append(args, "--server", s.cfg.Server)
+		}
+	case ScannerGrype:
+		args = []string{"--quiet", "--output", "json"}
+		parse = parseGrype
+	}
+	cmd := exec.CommandContext(ctx, s.cfg.Scanner, append(args, image)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w: %s", s.cfg.Scanner, image, err, strings.TrimSpace(stderr.String()))
+	}
+	vulns, err := parse(out)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", s.cfg.Scanner, image, err)
+	}
+	sort.SliceStable(vulns, func(i, j int) bool {
+		if vulns[i].Severity != vulns[j].Severity {
+			return vulns[i].Severity > vulns[j].Severity
+		}
+		return vulns[i].ID < vulns[j].ID
+	})
+	return vulns, nil
+}
+
+type trivyReport struct {
+	Results []struct {
+		Vulnerabilities []struct {
+			VulnerabilityID  string
+			PkgName          string
+			InstalledVersion string
+			FixedVersion     string
+			Severity         string
+		}
+	}
+}
+
+func parseTrivy(data []byte) ([]Vulnerability, error) {
+	var report trivyReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("decode report: %w", err)
+	}
+	var vulns []Vulnerability
+	for _, r := range report.Results {
+		for _, v := range r.Vulnerabilities {
+			severity, _ := ParseSeverity(v.Severity)
+			vulns = append(vulns, Vulnerability{
+				ID:           v.VulnerabilityID,
+				Severity:     severity,
+				Package:      v.PkgName,
+				Version:      v.InstalledVersion,
+				FixedVersion: v.FixedVersion,
+			})
+		}
+	}
+	return vulns, nil
+}
+
+type grypeReport struct {
+	Matches []struct {
+		Vulnerability struct {
+			ID       string `json:"id"`
+			Severity string `json:"severity"`
+			Fix      struct {
+				Versions []string `json:"versions"`
+			} `json:"fix"`
+		} `json:"vulnerability"`
+		Artifact struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"artifact"`
+	} `json:"matches"`
+}
+
+func parseGrype(data []byte) ([]Vulnerability, error) {
+	var report grypeReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("decode report: %w", err)
+	}
+	var vulns []Vulnerability
+	for _, m := range report.Matches {
+		severity, _ := ParseSeverity(m.Vulnerability.Severity)
+		vulns = append(vulns, Vulnerability{
+			ID:           m.Vulnerability.ID,
+			Severity:     severity,
+			Package:      m.Artifact.Name,
+			Version:      m.Artifact.Version,
+			FixedVersion: strings.Join(m.Vulnerability.Fix.Versions, ", "),
+		})
+	}
+	return vulns, nil
+}
@@ -0,0 +1,130 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// GenerateOptions controls Generate.
+type GenerateOptions struct {
+	// Strict rejects keys that values.yaml does not define in objects
+	// that have properties.
+	Strict bool
+}
+
+// Generate infers a schema from the default values of a chart. Every key
+// becomes a property typed after its default and, unless the default is
+// null, a required key. The comment above a key, or at the end of its
+// line, becomes its description. Null defaults accept any type.
+func Generate(data []byte, opts GenerateOptions) (*Schema, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("decode values: %w", err)
+	}
+	s := &Schema{SchemaURI: Draft, Type: Types{"object"}}
+	if len(doc.Content) == 0 {
+		return s, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("values must be an object")
+	}
+	generated := infer(root, opts)
+	generated.SchemaURI = Draft
+	return generated, nil
+}
+
+func infer(n *yaml.Node, opts GenerateOptions) *Schema {
+	if n.Kind == yaml.AliasNode {
+		return infer(n.Alias, opts)
+	}
+	s := &Schema{}
+	switch n.Kind {
+	case yaml.MappingNode:
+		s.Type = Types{"object"}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if key.Value == "<<" {
+				continue
+			}
+			prop := infer(value, opts)
+			prop.Description = description(key, value)
+			if s.Properties == nil {
+				s.Properties = map[string]*Schema{}
+			}
+			s.Properties[key.Value] = prop
+			if len(prop.Type) > 0 {
+				s.Required = append(s.Required, key.Value)
+			}
+		}
+		if opts.Strict && len(s.Properties) > 0 {
+			s.AdditionalProperties = &Additional{Allowed: false}
+		}
+	case yaml.SequenceNode:
+		s.Type = Types{"array"}
+		s.Items = itemSchema(n.Content, opts)
+	case yaml.ScalarNode:
+		switch n.ShortTag() {
+		case "!!null":
+		case "!!bool":
+			s.Type = Types{"boolean"}
+		case "!!int":
+			s.Type = Types{"integer"}
+		case "!!float":
+			s.Type = Types{"number"}
+		default:
+			s.Type = Types{"string"}
+		}
+	}
+	return s
+}
+
+// itemSchema infers the schema of array items when they all share a type.
+// Objects contribute the properties of the first item.
+func itemSchema(items []*yaml.Node, opts GenerateOptions) *Schema {
+	if len(items) == 0 {
+		return nil
+	}
+	first := infer(items[0], opts)
+	for _, item := range items[1:] {
+		s := infer(item, opts)
+		if len(s.Type) != 1 || len(first.Type) != 1 || s.Type[0] != first.Type[0] {
+			if numeric(first.Type) && numeric(s.Type) {
+				first.Type = Types{"number"}
+				continue
+			}
+			return nil
+		}
+	}
+	if len(first.Type) == 0 {
+		return nil
+	}
+	return first
+}
+
+func numeric(t Types) bool {
+	return len(t) == 1 && (t[0] == "integer" || t[0] == "number")
+}
+
+// description returns the comment documenting a key, without the comment
+// markers.
+func description(key, value *yaml.Node) string {
+	comment := key.HeadComment
+	if comment == "" {
+		comment = key.LineComment
+	}
+	if comment == "" && value.Kind == yaml.ScalarNode {
+		comment = value.LineComment
+	}
+	var lines []string
+	for _, line := range strings.Split(comment, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#"))
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, " ")
+}
@@ -0,0 +1,310 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FileName is the values schema at the chart root.
+const FileName = "values.schema.json"
+
+// Draft is the JSON Schema dialect written by Generate.
+const Draft = "http://json-schema.org/draft-07/schema#"
+
+// Schema is the subset of JSON Schema draft-07 tmpl understands: type,
+// enum, properties, required, additionalProperties, items, minimum,
+// maximum, minLength, maxLength and pattern. Other keywords are ignored.
+type Schema struct {
+	SchemaURI   string             `json:"$schema,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Type        Types              `json:"type,omitempty"`
+	Enum        []any              `json:"enum,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	// AdditionalProperties is false, true or a schema.
+	AdditionalProperties *Additional `json:"additionalProperties,omitempty"`
+	Items                *Schema     `json:"items,omitempty"`
+	Minimum              *float64    `json:"minimum,omitempty"`
+	Maximum              *float64    `json:"maximum,omitempty"`
+	MinLength            *int        `json:"minLength,omitempty"`
+	MaxLength            *int        `json:"maxLength,omitempty"`
+	Pattern              string      `json:"pattern,omitempty"`
+}
+
+// Types is the type keyword, a single name or a list of names.
+type Types []string
+
+// UnmarshalJSON accepts a string or an array of strings.
+func (t *Types) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*t = Types{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return errors.New("type must be a string or an array of strings")
+	}
+	*t = many
+	return nil
+}
+
+// MarshalJSON writes a single type as a string.
+func (t Types) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// Additional is the additionalProperties keyword.
+type Additional struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+// UnmarshalJSON accepts a boolean or a schema.
+func (a *Additional) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.Allowed); err == nil {
+		return nil
+	}
+	a.Allowed = true
+	a.Schema = &Schema{}
+	return json.Unmarshal(data, a.Schema)
+}
+
+// MarshalJSON writes a boolean unless a schema is set.
+func (a Additional) MarshalJSON() ([]byte, error) {
+	if a.Schema != nil {
+		return json.Marshal(a.Schema)
+	}
+	return json.Marshal(a.Allowed)
+}
+
+// Load reads the values schema of the chart in dir.
+func Load(dir string) (*Schema, error) {
+	path := filepath.Join(dir, FileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	s := &Schema{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Error is a value that does not satisfy the schema.
+type Error struct {
+	// Chart names the library chart whose schema the value violates;
+	// empty for the chart validated.
+	Chart string `json:"chart,omitempty"`
+	// Path locates the value, e.g. "web.replicas" or "hosts[1]"; empty
+	// for the root.
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e Error) Error() string {
+	msg := e.Message
+	if e.Path != "" {
+		msg = e.Path + ": " + msg
+	}
+	if e.Chart != "" {
+		msg = e.Chart + ": " + msg
+	}
+	return msg
+}
+
+// Validate checks values against s and returns every violation, sorted
+// by path.
+func (s *Schema) Validate(values map[string]any) []Error {
+	var errs []Error
+	s.validate("", values, &errs)
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	return errs
+}
+
+func (s *Schema) validate(path string, v any, errs *[]Error) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, Error{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if len(s.Type) > 0 && !s.Type.match(v) {
+		fail("expected %s, got %s", strings.Join(s.Type, " or "), TypeOf(v))
+		return
+	}
+	if len(s.Enum) > 0 && !s.inEnum(v) {
+		fail("must be one of %s", enumString(s.Enum))
+	}
+
+	switch t := v.(type) {
+	case map[string]any:
+		for _, key := range s.Required {
+			if _, ok := t[key]; !ok {
+				fail("missing required key %q", key)
+			}
+		}
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := join(path, k)
+			if prop, ok := s.Properties[k]; ok {
+				prop.validate(child, t[k], errs)
+				continue
+			}
+			switch a := s.AdditionalProperties; {
+			case a == nil:
+			case a.Schema != nil:
+				a.Schema.validate(child, t[k], errs)
+			case !a.Allowed:
+				*errs = append(*errs, Error{Path: child, Message: "unknown key"})
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range t {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case string:
+		n := len([]rune(t))
+		if s.MinLength != nil && n < *s.MinLength {
+			fail("must be at least %d characters long", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("must be at most %d characters long", *s.MaxLength)
+		}
+		if s.Pattern != "" {
+			re, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				fail("invalid pattern %q in schema: %v", s.Pattern, err)
+			} else if !re.MatchString(t) {
+				fail("must match %s", s.Pattern)
+			}
+		}
+	default:
+		if n, ok := number(v); ok {
+			if s.Minimum != nil && n < *s.Minimum {
+				fail("must be at least %v", *s.Minimum)
+			}
+			if s.Maximum != nil && n > *s.Maximum {
+				fail("must be at most %v", *s.Maximum)
+			}
+		}
+	}
+}
+
+func (t Types) match(v any) bool {
+	actual := TypeOf(v)
+	for _, want := range t {
+		if want == actual || (want == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schema) inEnum(v any) bool {
+	for _, e := range s.Enum {
+		if reflect.DeepEqual(e, v) {
+			return true
+		}
+		a, aok := number(e)
+		b, bok := number(v)
+		if aok && bok && a == b {
+			return true
+		}
+	}
+	return false
+}
+
+func enumString(values []any) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		data, _ := json.Marshal(v)
+		parts[i] = string(data)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// TypeOf returns the JSON Schema type name of a decoded YAML or JSON value.
+func TypeOf(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case float32, float64:
+		if n, _ := number(t); n == math.Trunc(n) && !math.IsInf(n, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	if _, ok := number(v); ok {
+		return "integer"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func number(v any) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// Lookup returns the schema of the value at path, given as keys and list
+// indexes, or nil when the schema does not describe it.
+func (s *Schema) Lookup(path []string) *Schema {
+	current := s
+	for _, segment := range path {
+		switch {
+		case current.Properties[segment] != nil:
+			current = current.Properties[segment]
+		case current.Items != nil && isIndex(segment):
+			current = current.Items
+		case current.AdditionalProperties != nil && current.AdditionalProperties.Schema != nil:
+			current = current.AdditionalProperties.Schema
+		default:
+			return nil
+		}
+	}
+	return current
+}
+
+func isIndex(segment string) bool {
+	_, err := strconv.Atoi(segment)
+	return err == nil
+}
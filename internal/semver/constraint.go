@@ -0,0 +1,210 @@
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Constraint is a set of version ranges such as ">=1.2 <2", "^1.4",
+// "~1.2.3", "1.2.x" or "1.x || >=3". Comparators separated by spaces or
+// commas must all match; alternatives separated by || may match.
+type Constraint struct {
+	raw    string
+	groups [][]comparator
+}
+
+type comparator struct {
+	op      string
+	version Version
+}
+
+// ParseConstraint parses a constraint expression. An empty expression or
+// "*" matches every release version.
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{raw: strings.TrimSpace(s)}
+	for _, alt := range strings.Split(c.raw, "||") {
+		fields := strings.FieldsFunc(alt, func(r rune) bool { return r == ' ' || r == ',' })
+		fields = joinOperators(fields)
+		var group []comparator
+		for _, field := range fields {
+			cmps, err := parseComparator(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid constraint %q: %w", s, err)
+			}
+			group = append(group, cmps...)
+		}
+		c.groups = append(c.groups, group)
+	}
+	return c, nil
+}
+
+// joinOperators merges a bare operator with the version that follows it,
+// so ">= 1.2" parses like ">=1.2".
+func joinOperators(fields []string) []string {
+	out := make([]string, 0, len(fields))
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if strings.Trim(f, "<>=!~^") == "" && i+1 < len(fields) {
+			f += fields[i+1]
+			i++
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+func (c *Constraint) String() string {
+	return c.raw
+}
+
+// Check reports whether v satisfies the constraint. Prerelease versions only
+// match comparators that mention a prerelease of the same version core.
+func (c *Constraint) Check(v Version) bool {
+	for _, group := range c.groups {
+		if matchGroup(group, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchGroup(group []comparator, v Version) bool {
+	if v.Prerelease != "" {
+		allowed := false
+		for _, cmp := range group {
+			if cmp.version.Prerelease != "" && sameCore(cmp.version, v) {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	for _, cmp := range group {
+		if !cmp.match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameCore(a, b Version) bool {
+	return a.Major == b.Major && a.Minor == b.Minor && a.Patch == b.Patch
+}
+
+func (c comparator) match(v Version) bool {
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case "", "=", "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// parseComparator expands one field into primitive comparators.
+func parseComparator(field string) ([]comparator, error) {
+	op := field[:len(field)-len(strings.TrimLeft(field, "<>=!~^"))]
+	rest := field[len(op):]
+	if rest == "*" || rest == "x" || rest == "X" {
+		return nil, nil
+	}
+
+	v, wildcard, err := parsePartial(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "^":
+		upper := v.IncMajor()
+		if v.Major == 0 && wildcard > 1 {
+			upper = v.IncMinor()
+			if v.Minor == 0 && wildcard > 2 {
+				upper = Version{Patch: v.Patch + 1}
+			}
+		}
+		return []comparator{{">=", v}, {"<", upper}}, nil
+	case "~":
+		upper := v.IncMinor()
+		if wildcard == 1 {
+			upper = v.IncMajor()
+		}
+		return []comparator{{">=", v}, {"<", upper}}, nil
+	}
+
+	if wildcard == 3 {
+		return []comparator{{op, v}}, nil
+	}
+	// Partial versions describe ranges: 1.2 means >=1.2.0 <1.3.0.
+	upper := v.IncMajor()
+	if wildcard == 2 {
+		upper = v.IncMinor()
+	}
+	switch op {
+	case "", "=", "==":
+		return []comparator{{">=", v}, {"<", upper}}, nil
+	case ">":
+		return []comparator{{">=", upper}}, nil
+	case "<=":
+		return []comparator{{"<", upper}}, nil
+	case "!=":
+		return nil, fmt.Errorf("!= requires a full version")
+	default:
+		return []comparator{{op, v}}, nil
+	}
+}
+
+// parsePartial parses a version whose minor or patch may be missing or a
+// wildcard. It returns how many components were given explicitly.
+func parsePartial(s string) (Version, int, error) {
+	if s == "" {
+		return Version{}, 0, fmt.Errorf("missing version")
+	}
+	core, suffix := s, ""
+	if idx := strings.IndexAny(s, "-+"); idx >= 0 {
+		core, suffix = s[:idx], s[idx:]
+	}
+	parts := strings.Split(strings.TrimPrefix(core, "v"), ".")
+	if len(parts) > 3 {
+		return Version{}, 0, fmt.Errorf("invalid version %q", s)
+	}
+	nums := [3]uint64{}
+	given := 0
+	for i, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			break
+		}
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return Version{}, 0, fmt.Errorf("invalid version %q", s)
+		}
+		nums[i] = n
+		given++
+	}
+	if given == 0 {
+		return Version{}, 0, fmt.Errorf("invalid version %q", s)
+	}
+	v := Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}
+	if suffix != "" {
+		if given != 3 {
+			return Version{}, 0, fmt.Errorf("prerelease requires a full version in %q", s)
+		}
+		full, err := Parse(core + suffix)
+		if err != nil {
+			return Version{}, 0, err
+		}
+		v = full
+	}
+	return v, given, nil
+}
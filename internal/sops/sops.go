@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"os/exec"
+	"strings"
+	"time"
 )
 
 // Decryptor abstracts secret decryption to facilitate testing.
@@ -25,9 +27,12 @@ func New() (Decryptor, error) {
 }
 
 func (d *execDecryptor) Decrypt(ctx context.Context, data []byte) ([]byte, error) {
-	cmd := exec.CommandContext(ctx, "sops", "-d", "/dev/stdin")
+	cmd := command(ctx, "-d", "/dev/stdin")
 	cmd.Stdin = bytes.NewReader(data)
 	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("sops decrypt: %w", context.Cause(ctx))
+	}
 	if err != nil {
 		return nil, fmt.Errorf("sops decrypt: %w: %s", err, string(out))
 	}
@@ -38,10 +43,91 @@ func (d *execDecryptor) DecryptFile(ctx context.Context, path string) ([]byte, e
 	if path == "" {
 		return nil, errors.New("missing path for sops decrypt")
 	}
-	cmd := exec.CommandContext(ctx, "sops", "-d", path)
+	cmd := command(ctx, "-d", path)
 	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("sops decrypt file %s: %w", path, context.Cause(ctx))
+	}
 	if err != nil {
 		return nil, fmt.Errorf("sops decrypt file %s: %w: %s", path, err, string(out))
 	}
 	return out, nil
 }
+
+// waitDelay bounds how long a cancelled sops process may keep its output
+// open, e.g. through a key service or agent it started.
+const waitDelay = 2 * time.Second
+
+// command prepares a sops invocation that is killed when ctx is done.
+func command(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "sops", args...)
+	cmd.WaitDelay = waitDelay
+	return cmd
+}
+
+// keyFlags maps the types of key specifications, TYPE:KEY, to the sops
+// flags selecting them.
+var keyFlags = map[string]string{
+	"age":              "--age",
+	"pgp":              "--pgp",
+	"kms":              "--kms",
+	"gcp-kms":          "--gcp-kms",
+	"azure-kv":         "--azure-kv",
+	"hc-vault-transit": "--hc-vault-transit",
+}
+
+// ValidateKeys checks key specifications such as "age:age1..." or
+// "kms:arn:aws:kms:...".
+func ValidateKeys(keys []string) error {
+	for _, key := range keys {
+		typ, value, ok := strings.Cut(key, ":")
+		if _, known := keyFlags[typ]; !ok || !known || value == "" {
+			return fmt.Errorf("invalid key %q, expected TYPE:KEY with TYPE one of age, pgp, kms, gcp-kms, azure-kv or hc-vault-transit", key)
+		}
+	}
+	return nil
+}
+
+// Encrypt encrypts data for the given keys, as validated by ValidateKeys,
+// into a sops JSON document. Any one of the keys can decrypt it.
+func Encrypt(ctx context.Context, data []byte, keys []string) ([]byte, error) {
+	if err := ValidateKeys(keys); err != nil {
+		return nil, err
+	}
+	byFlag := map[string][]string{}
+	for _, key := range keys {
+		typ, value, _ := strings.Cut(key, ":")
+		byFlag[keyFlags[typ]] = append(byFlag[keyFlags[typ]], value)
+	}
+	args := []string{"--encrypt", "--input-type", "binary", "--output-type", "json"}
+	for flag, values := range byFlag {
+		args = append(args, flag, strings.Join(values, ","))
+	}
+	return run(ctx, "sops encrypt", data, append(args, "/dev/stdin")...)
+}
+
+// DecryptDocument decrypts a document written by Encrypt, with whichever
+// of its keys sops has access to.
+func DecryptDocument(ctx context.Context, doc []byte) ([]byte, error) {
+	return run(ctx, "sops decrypt", doc, "--decrypt", "--input-type", "json", "--output-type", "binary", "/dev/stdin")
+}
+
+// run runs sops with stdin, returning its standard output. Errors include
+// what sops wrote to stderr.
+func run(ctx context.Context, what string, stdin []byte, args ...string) ([]byte, error) {
+	if _, err := exec.LookPath("sops"); err != nil {
+		return nil, fmt.Errorf("sops binary not found: %w", err)
+	}
+	cmd := command(ctx, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("%s: %w", what, context.Cause(ctx))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", what, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
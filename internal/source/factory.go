@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -13,10 +14,9 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/go-git/go-git/v5"
 	gitplumbing "github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
-	"github.com/google/uuid"
-	"oras.land/oras-go/v2/registry"
-	"oras.land/oras-go/v2/registry/remote"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+
+	"github.com/acebelowzero/tmpl/internal/oci"
 )
 
 const (
@@ -24,6 +24,7 @@ const (
 	SchemeGit   = "git"
 	SchemeS3    = "s3"
 	SchemeOCI   = "oci"
+	SchemeHTTP  = "http"
 )
 
 // Source fetches bytes from different backends.
@@ -31,6 +32,27 @@ type Source interface {
 	Fetch(ctx context.Context) ([]byte, error)
 }
 
+// Revisioner is implemented by sources that can identify the revision they
+// fetched, such as a git commit SHA or an OCI manifest digest. Revision is
+// only meaningful after a successful Fetch.
+type Revisioner interface {
+	Revision() string
+}
+
+// GitRevision returns the commit SHA checked out in the git repository
+// containing dir, or an empty string when dir is not in a repository.
+func GitRevision(dir string) string {
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return ""
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return ""
+	}
+	return head.Hash().String()
+}
+
 // Factory creates Source implementations.
 type Factory struct{}
 
@@ -50,6 +72,9 @@ func (f *Factory) New(raw string) (Source, error) {
 	if strings.HasPrefix(raw, "git+") {
 		return newGitSource(raw)
 	}
+	if strings.HasPrefix(raw, "https://") || strings.HasPrefix(raw, "http://") {
+		return newHTTPSource(raw)
+	}
 	return nil, fmt.Errorf("unsupported source %s", raw)
 }
 
@@ -62,16 +87,19 @@ func ParseScheme(path string) string {
 		return SchemeS3
 	case strings.HasPrefix(path, "oci://"):
 		return SchemeOCI
+	case strings.HasPrefix(path, "https://"), strings.HasPrefix(path, "http://"):
+		return SchemeHTTP
 	default:
 		return SchemeLocal
 	}
 }
 
 type gitSource struct {
-	url    *url.URL
-	subdir string
-	ref    string
-	path   string
+	url      *url.URL
+	subdir   string
+	ref      string
+	path     string
+	revision string
 }
 
 func newGitSource(raw string) (Source, error) {
@@ -84,33 +112,48 @@ func newGitSource(raw string) (Source, error) {
 	ref := u.Fragment
 	u.Fragment = ""
 
+	// The file is separated from the repository by //, as in
+	// git+https://example.com/env.git//prod.yaml. Without it the whole
+	// path names the file.
 	subdir := u.Path
-	u.Path = ""
+	if repo, file, ok := strings.Cut(u.Path, "//"); ok {
+		u.Path, subdir = repo, file
+	} else {
+		u.Path = ""
+	}
+	subdir = strings.TrimPrefix(subdir, "/")
+	if !filepath.IsLocal(filepath.FromSlash(subdir)) {
+		return nil, fmt.Errorf("git source %s: file %q is outside the repository", raw, subdir)
+	}
 
 	return &gitSource{
 		url:    u,
-		subdir: strings.TrimPrefix(subdir, "/"),
+		subdir: subdir,
 		ref:    ref,
 		path:   raw,
 	}, nil
 }
 
 func (g *gitSource) Fetch(ctx context.Context) ([]byte, error) {
-	tempDir := filepath.Join(os.TempDir(), "tmpl-git-"+uuid.NewString())
+	tempDir, err := os.MkdirTemp("", "tmpl-git-")
+	if err != nil {
+		return nil, fmt.Errorf("create clone directory: %w", err)
+	}
+	// Also removes what an aborted clone left behind.
+	defer os.RemoveAll(tempDir)
 	repo, err := git.PlainCloneContext(ctx, tempDir, false, &git.CloneOptions{
 		URL: g.url.String(),
 	})
+	// Attempt to handle basic auth env, unless the clone was cancelled.
+	if auth := basicAuthFromEnv(); err != nil && ctx.Err() == nil && auth != nil {
+		os.RemoveAll(tempDir)
+		repo, err = git.PlainCloneContext(ctx, tempDir, false, &git.CloneOptions{
+			URL:  g.url.String(),
+			Auth: auth,
+		})
+	}
 	if err != nil {
-		// Attempt to handle basic auth env.
-		if auth := basicAuthFromEnv(); auth != nil {
-			repo, err = git.PlainCloneContext(ctx, tempDir, false, &git.CloneOptions{
-				URL:  g.url.String(),
-				Auth: auth,
-			})
-		}
-		if err != nil {
-			return nil, fmt.Errorf("clone git source %s: %w", g.path, err)
-		}
+		return nil, fmt.Errorf("clone git source %s: %w", g.path, err)
 	}
 
 	if g.ref != "" {
@@ -125,7 +168,11 @@ func (g *gitSource) Fetch(ctx context.Context) ([]byte, error) {
 		}
 	}
 
-	target := filepath.Join(tempDir, g.subdir)
+	if head, err := repo.Head(); err == nil {
+		g.revision = head.Hash().String()
+	}
+
+	target := g.file(tempDir)
 	data, err := os.ReadFile(target)
 	if err != nil {
 		return nil, fmt.Errorf("read git file %s: %w", target, err)
@@ -133,18 +180,28 @@ func (g *gitSource) Fetch(ctx context.Context) ([]byte, error) {
 	return data, nil
 }
 
-func basicAuthFromEnv() *http.BasicAuth {
+// file returns the path of the file of the source in the clone at dir.
+func (g *gitSource) file(dir string) string {
+	return filepath.Join(dir, filepath.FromSlash(g.subdir))
+}
+
+func (g *gitSource) Revision() string {
+	return g.revision
+}
+
+func basicAuthFromEnv() *githttp.BasicAuth {
 	user := os.Getenv("TMPL_GIT_USERNAME")
 	pass := os.Getenv("TMPL_GIT_PASSWORD")
 	if user == "" && pass == "" {
 		return nil
 	}
-	return &http.BasicAuth{Username: user, Password: pass}
+	return &githttp.BasicAuth{Username: user, Password: pass}
 }
 
 type s3Source struct {
-	bucket string
-	key    string
+	bucket   string
+	key      string
+	revision string
 }
 
 func newS3Source(raw string) (Source, error) {
@@ -172,11 +229,22 @@ func (s *s3Source) Fetch(ctx context.Context) ([]byte, error) {
 		return nil, err
 	}
 	defer out.Body.Close()
+	switch {
+	case out.VersionId != nil && *out.VersionId != "" && *out.VersionId != "null":
+		s.revision = *out.VersionId
+	case out.ETag != nil:
+		s.revision = strings.Trim(*out.ETag, `"`)
+	}
 	return io.ReadAll(out.Body)
 }
 
+func (s *s3Source) Revision() string {
+	return s.revision
+}
+
 type ociSource struct {
-	ref string
+	ref    string
+	digest string
 }
 
 func newOCISource(raw string) (Source, error) {
@@ -184,22 +252,50 @@ func newOCISource(raw string) (Source, error) {
 }
 
 func (o *ociSource) Fetch(ctx context.Context) ([]byte, error) {
-	repo, err := remote.NewRepository(o.ref)
+	artifact, err := oci.Pull(ctx, o.ref)
 	if err != nil {
 		return nil, err
 	}
-	if err := registry.Login(ctx, repo, registry.LoginOption{}); err != nil {
-		return nil, err
+	o.digest = artifact.Digest
+	return artifact.Data, nil
+}
+
+func (o *ociSource) Revision() string {
+	return o.digest
+}
+
+type httpSource struct {
+	url  string
+	etag string
+}
+
+func newHTTPSource(raw string) (Source, error) {
+	if _, err := url.Parse(raw); err != nil {
+		return nil, fmt.Errorf("parse http source %s: %w", raw, err)
 	}
-	resolver := repo.Blobs()
-	desc, err := repo.Resolve(ctx, o.ref)
+	return &httpSource{url: raw}, nil
+}
+
+func (h *httpSource) Fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
 	if err != nil {
 		return nil, err
 	}
-	rc, err := resolver.Fetch(ctx, desc)
+	if token := os.Getenv("TMPL_HTTP_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("fetch %s: %w", h.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s: unexpected status %s", h.url, resp.Status)
 	}
-	defer rc.Close()
-	return io.ReadAll(rc)
+	h.etag = strings.Trim(resp.Header.Get("ETag"), `"`)
+	return io.ReadAll(resp.Body)
+}
+
+func (h *httpSource) Revision() string {
+	return h.etag
 }
@@ -0,0 +1,25 @@
+//go:build windows
+
+package source
+
+import "testing"
+
+func TestGitSourceFileWindows(t *testing.T) {
+	src, err := newGitSource("git+https://example.com/env.git//envs/prod.yaml#main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := src.(*gitSource).file(`C:\Temp\tmpl-git-1`), `C:\Temp\tmpl-git-1\envs\prod.yaml`; got != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+
+	for _, raw := range []string{
+		`git+https://example.com/env.git//..\secrets.yaml`,
+		`git+https://example.com/env.git//envs\..\..\secrets.yaml`,
+		"git+https://example.com/env.git//C:/secrets.yaml",
+	} {
+		if _, err := newGitSource(raw); err == nil {
+			t.Errorf("newGitSource(%q) accepted a file outside the repository", raw)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package stack
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+)
+
+// Service labels, set with deploy.labels in the chart, that gate --wait on
+// container health.
+const (
+	// LabelWait selects what --wait waits for: WaitRunning, the default,
+	// or WaitHealthy.
+	LabelWait = "tmpl.wait"
+	// LabelHealthyFor overrides how long the tasks of a health-gated
+	// service must keep running, e.g. "45s".
+	LabelHealthyFor = "tmpl.wait-healthy-for"
+)
+
+// Values of LabelWait.
+const (
+	WaitRunning = "running"
+	WaitHealthy = "healthy"
+)
+
+// Engine defaults for healthchecks that leave interval or retries unset.
+const (
+	defaultHealthInterval = 30 * time.Second
+	defaultHealthRetries  = 3
+)
+
+// HealthGate returns how long the tasks of a service must have been
+// running before --wait counts them as healthy, or zero when the service
+// is not health-gated.
+//
+// Swarm reports a task running once its first healthcheck passed. A gated
+// task must then stay running for the number of failed checks that mark
+// a container unhealthy, interval times retries of its healthcheck, so a
+// container that turns unhealthy right after starting fails the wait
+// instead of passing it.
+func HealthGate(spec docker.ServiceSpec) (time.Duration, error) {
+	switch wait := spec.Labels[LabelWait]; wait {
+	case "", WaitRunning:
+		return 0, nil
+	case WaitHealthy:
+	default:
+		return 0, fmt.Errorf("label %s: unknown value %q, expected %s or %s", LabelWait, wait, WaitRunning, WaitHealthy)
+	}
+
+	var hc *docker.HealthConfig
+	if cs := spec.TaskTemplate.ContainerSpec; cs != nil {
+		hc = cs.Healthcheck
+	}
+	if hc != nil && len(hc.Test) > 0 && hc.Test[0] == "NONE" {
+		return 0, fmt.Errorf("label %s=%s needs a healthcheck, but it is disabled", LabelWait, WaitHealthy)
+	}
+	if v := spec.Labels[LabelHealthyFor]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("label %s: invalid duration %q", LabelHealthyFor, v)
+		}
+		return d, nil
+	}
+
+	// Without a healthcheck in the spec the one of the image applies,
+	// which is only known to the engine; assume its defaults.
+	interval, retries := defaultHealthInterval, defaultHealthRetries
+	if hc != nil {
+		if hc.Interval > 0 {
+			interval = hc.Interval
+		}
+		if hc.Retries > 0 {
+			retries = hc.Retries
+		}
+	}
+	return interval * time.Duration(retries), nil
+}
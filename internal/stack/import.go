@@ -0,0 +1,400 @@
+package stack
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acebelowzero/tmpl/internal/compose"
+	"github.com/acebelowzero/tmpl/internal/docker"
+)
+
+// Compose converts the live stack back into the compose stack that would
+// deploy it, the reverse of Convert: names lose the stack prefix, labels
+// set by docker stack deploy and tmpl are dropped and settings equal to
+// the defaults Convert fills in are left out. The engine never returns
+// secret data, so secrets become external ones referring to the live
+// secrets by name; the returned warnings say so, and describe anything
+// else that could not be converted.
+func (l *Live) Compose() (*compose.Stack, []string) {
+	im := &importer{live: l, out: &compose.Stack{
+		Version:  "3.9",
+		Services: map[string]compose.Service{},
+	}}
+	im.objects()
+	for _, name := range l.ServiceNames() {
+		im.service(l.Services[name])
+	}
+	// The default network is implicit unless it has settings.
+	if net, ok := im.out.Networks[defaultNetwork]; ok && net.Driver == "" && len(net.DriverOpts) == 0 &&
+		!net.Attachable && !net.Internal && !net.External && net.Name == "" && len(net.Labels) == 0 {
+		delete(im.out.Networks, defaultNetwork)
+	}
+	if len(im.out.Networks) == 0 {
+		im.out.Networks = nil
+	}
+	return im.out, im.warnings
+}
+
+type importer struct {
+	live     *Live
+	out      *compose.Stack
+	warnings []string
+}
+
+// key returns the compose key of the stack object named full and whether
+// the stack created it: objects the stack creates are prefixed with its
+// name.
+func (im *importer) key(full string) (string, bool) {
+	return strings.CutPrefix(full, im.live.Name+"_")
+}
+
+func (im *importer) warn(format string, args ...any) {
+	im.warnings = append(im.warnings, fmt.Sprintf(format, args...))
+}
+
+// objects converts the configs and secrets of the stack.
+func (im *importer) objects() {
+	for _, full := range sortedKeys(im.live.Configs) {
+		spec := im.live.Configs[full]
+		obj := compose.Object{Content: string(spec.Data), Labels: userLabels(spec.Labels)}
+		if _, prefixed := im.key(full); !prefixed {
+			obj.Name = full
+		}
+		if im.out.Configs == nil {
+			im.out.Configs = map[string]compose.Object{}
+		}
+		im.out.Configs[im.objectKey(full, spec)] = obj
+	}
+	for _, full := range sortedKeys(im.live.Secrets) {
+		key := im.objectKey(full, im.live.Secrets[full])
+		if im.out.Secrets == nil {
+			im.out.Secrets = map[string]compose.Object{}
+		}
+		im.out.Secrets[key] = compose.Object{External: true, Name: full}
+		im.warn("secret %s: secret data cannot be read back; it refers to the live secret %s", key, full)
+	}
+}
+
+// objectKey returns the compose key of a config or secret. Rotated
+// objects are keyed by their unversioned name.
+func (im *importer) objectKey(full string, spec docker.ObjectSpec) string {
+	name := full
+	if unversioned := spec.Labels[LabelObject]; unversioned != "" {
+		name = unversioned
+	}
+	key, _ := im.key(name)
+	return key
+}
+
+// configKey returns the compose key of the config named full.
+func (im *importer) configKey(full string) string {
+	if spec, ok := im.live.Configs[full]; ok {
+		return im.objectKey(full, spec)
+	}
+	return im.external(full, &im.out.Configs)
+}
+
+// secretKey returns the compose key of the secret named full.
+func (im *importer) secretKey(full string) string {
+	if spec, ok := im.live.Secrets[full]; ok {
+		return im.objectKey(full, spec)
+	}
+	return im.external(full, &im.out.Secrets)
+}
+
+// external declares an object the stack does not own as external.
+func (im *importer) external(full string, objects *map[string]compose.Object) string {
+	if *objects == nil {
+		*objects = map[string]compose.Object{}
+	}
+	(*objects)[full] = compose.Object{External: true}
+	return full
+}
+
+// network returns the compose key of the network a service is attached
+// to, declaring it. Attachments name networks by ID.
+func (im *importer) network(target string) string {
+	if im.out.Networks == nil {
+		im.out.Networks = map[string]compose.Network{}
+	}
+	for full, obj := range im.live.NetworkObjects {
+		if obj.ID != target && full != target {
+			continue
+		}
+		spec := im.live.Networks[full]
+		key, prefixed := im.key(full)
+		net := compose.Network{
+			Driver:     spec.Driver,
+			DriverOpts: spec.Options,
+			Attachable: spec.Attachable,
+			Internal:   spec.Internal,
+			Labels:     userLabels(spec.Labels),
+		}
+		if net.Driver == "overlay" {
+			net.Driver = ""
+		}
+		if !prefixed {
+			net.Name = full
+		}
+		im.out.Networks[key] = net
+		return key
+	}
+	im.warn("network %s is not part of the stack; it is declared external", target)
+	im.out.Networks[target] = compose.Network{External: true}
+	return target
+}
+
+func (im *importer) service(spec docker.ServiceSpec) {
+	name, _ := im.key(spec.Name)
+	svc := compose.Service{Deploy: compose.Deploy{Labels: userLabels(spec.Labels)}}
+	if cs := spec.TaskTemplate.ContainerSpec; cs != nil {
+		svc.Image = cs.Image
+		if requested := spec.Labels[LabelImage]; requested != "" {
+			// The engine pins images to digests; the requested reference
+			// is the one to template.
+			svc.Image = requested
+		}
+		svc.Entrypoint = compose.StringList(cs.Command)
+		svc.Command = compose.StringList(cs.Args)
+		svc.Labels = userLabels(cs.Labels)
+		svc.Hostname = cs.Hostname
+		svc.WorkingDir = cs.Dir
+		svc.User = cs.User
+		svc.StopSignal = cs.StopSignal
+		svc.Sysctls = cs.Sysctls
+		if len(cs.Env) > 0 {
+			svc.Environment = compose.Mapping{}
+			for _, pair := range cs.Env {
+				k, v, _ := strings.Cut(pair, "=")
+				svc.Environment[k] = v
+			}
+		}
+		for _, m := range cs.Mounts {
+			svc.Volumes = append(svc.Volumes, im.mount(m))
+		}
+		for _, ref := range cs.Configs {
+			key := im.configKey(ref.ConfigName)
+			svc.Configs = append(svc.Configs, fileReference(key, "/"+key, ref.File))
+		}
+		for _, ref := range cs.Secrets {
+			key := im.secretKey(ref.SecretName)
+			svc.Secrets = append(svc.Secrets, fileReference(key, key, ref.File))
+		}
+		svc.Healthcheck = composeHealthcheck(cs.Healthcheck)
+	}
+
+	task := spec.TaskTemplate
+	if r := task.Resources; r != nil {
+		svc.Deploy.Resources = compose.Resources{Limits: composeResource(r.Limits), Reservations: composeResource(r.Reservations)}
+	}
+	if rp := task.RestartPolicy; rp != nil {
+		svc.Deploy.RestartPolicy = &compose.RestartPolicy{
+			Condition:   rp.Condition,
+			Delay:       durationPointer(rp.Delay),
+			MaxAttempts: rp.MaxAttempts,
+			Window:      durationPointer(rp.Window),
+		}
+	}
+	if p := task.Placement; p != nil {
+		svc.Deploy.Placement = compose.Placement{Constraints: p.Constraints, MaxReplicas: p.MaxReplicas}
+		for _, pref := range p.Preferences {
+			if pref.Spread != nil {
+				svc.Deploy.Placement.Preferences = append(svc.Deploy.Placement.Preferences, compose.PlacementPreference{Spread: pref.Spread.SpreadDescriptor})
+			}
+		}
+	}
+	for _, att := range task.Networks {
+		key := im.network(att.Target)
+		if svc.Networks == nil {
+			svc.Networks = compose.ServiceNetworks{}
+		}
+		var aliases []string
+		for _, alias := range att.Aliases {
+			if alias != name {
+				aliases = append(aliases, alias)
+			}
+		}
+		var attachment *compose.NetworkAttachment
+		if len(aliases) > 0 {
+			attachment = &compose.NetworkAttachment{Aliases: aliases}
+		}
+		svc.Networks[key] = attachment
+	}
+	// Services only attached to the default network need not say so.
+	if _, ok := svc.Networks[defaultNetwork]; ok && len(svc.Networks) == 1 && svc.Networks[defaultNetwork] == nil {
+		svc.Networks = nil
+	}
+
+	switch {
+	case spec.Mode.Global != nil:
+		svc.Deploy.Mode = "global"
+	case spec.Mode.Replicated != nil && spec.Mode.Replicated.Replicas != nil:
+		replicas := *spec.Mode.Replicated.Replicas
+		svc.Deploy.Replicas = &replicas
+	}
+	svc.Deploy.UpdateConfig = composeUpdateConfig(spec.UpdateConfig)
+	svc.Deploy.RollbackConfig = composeUpdateConfig(spec.RollbackConfig)
+
+	if ep := spec.EndpointSpec; ep != nil {
+		if ep.Mode != "vip" {
+			svc.Deploy.EndpointMode = ep.Mode
+		}
+		for _, p := range ep.Ports {
+			port := compose.Port{Target: p.TargetPort, Published: p.PublishedPort, Protocol: p.Protocol, Mode: p.PublishMode}
+			if port.Protocol == "tcp" {
+				port.Protocol = ""
+			}
+			if port.Mode == "ingress" {
+				port.Mode = ""
+			}
+			svc.Ports = append(svc.Ports, port)
+		}
+	}
+	im.out.Services[name] = svc
+}
+
+// mount converts a mount, declaring the named volume it mounts.
+func (im *importer) mount(m docker.Mount) compose.VolumeMount {
+	v := compose.VolumeMount{Type: m.Type, Source: m.Source, Target: m.Target, ReadOnly: m.ReadOnly}
+	if m.Type != "volume" || m.Source == "" {
+		return v
+	}
+	if im.out.Volumes == nil {
+		im.out.Volumes = map[string]compose.Volume{}
+	}
+	key, prefixed := im.key(m.Source)
+	vol := compose.Volume{}
+	if !prefixed {
+		vol.External = true
+	}
+	v.Source = key
+	im.out.Volumes[key] = vol
+	return v
+}
+
+// userLabels returns labels without those docker stack deploy and tmpl
+// set, nil when none are left.
+func userLabels(labels map[string]string) compose.Mapping {
+	var out compose.Mapping
+	for k, v := range labels {
+		if strings.HasPrefix(k, "com.docker.stack.") || strings.HasPrefix(k, "tmpl.") {
+			continue
+		}
+		if out == nil {
+			out = compose.Mapping{}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// fileReference converts the mount of a config or secret, leaving out the
+// target, owner and mode when they are the defaults of Convert.
+func fileReference(key, defaultTarget string, file *docker.FileTarget) compose.FileReference {
+	ref := compose.FileReference{Source: key}
+	if file == nil {
+		return ref
+	}
+	if file.Name != defaultTarget {
+		ref.Target = file.Name
+	}
+	if file.UID != "0" && file.UID != "" {
+		ref.UID = file.UID
+	}
+	if file.GID != "0" && file.GID != "" {
+		ref.GID = file.GID
+	}
+	if file.Mode != 0 && file.Mode != 0o444 {
+		mode := uint32(file.Mode & os.ModePerm)
+		ref.Mode = &mode
+	}
+	return ref
+}
+
+func composeHealthcheck(hc *docker.HealthConfig) *compose.Healthcheck {
+	if hc == nil {
+		return nil
+	}
+	if len(hc.Test) == 1 && hc.Test[0] == "NONE" {
+		return &compose.Healthcheck{Disable: true}
+	}
+	out := &compose.Healthcheck{
+		Test:        compose.StringList(hc.Test),
+		Interval:    duration(hc.Interval),
+		Timeout:     duration(hc.Timeout),
+		StartPeriod: duration(hc.StartPeriod),
+	}
+	if len(hc.Test) == 2 && hc.Test[0] == "CMD-SHELL" {
+		out.Test = compose.StringList{hc.Test[1]}
+	}
+	if hc.Retries > 0 {
+		retries := uint64(hc.Retries)
+		out.Retries = &retries
+	}
+	return out
+}
+
+func composeResource(r *docker.Resources) *compose.Resource {
+	if r == nil || r.NanoCPUs == 0 && r.MemoryBytes == 0 {
+		return nil
+	}
+	out := &compose.Resource{}
+	if r.NanoCPUs > 0 {
+		out.CPUs = strconv.FormatFloat(float64(r.NanoCPUs)/1e9, 'f', -1, 64)
+	}
+	if r.MemoryBytes > 0 {
+		out.Memory = FormatBytes(r.MemoryBytes)
+	}
+	return out
+}
+
+// FormatBytes formats a byte amount as ParseBytes reads it, in the largest
+// unit it is a whole multiple of, e.g. 256M.
+func FormatBytes(n int64) string {
+	for _, u := range []struct {
+		suffix string
+		n      int64
+	}{{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}} {
+		if n%u.n == 0 {
+			return strconv.FormatInt(n/u.n, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func composeUpdateConfig(uc *docker.UpdateConfig) *compose.UpdateConfig {
+	if uc == nil {
+		return nil
+	}
+	out := &compose.UpdateConfig{
+		Delay:           duration(uc.Delay),
+		FailureAction:   uc.FailureAction,
+		Monitor:         duration(uc.Monitor),
+		MaxFailureRatio: float64(uc.MaxFailureRatio),
+		Order:           uc.Order,
+	}
+	if uc.Parallelism != 1 {
+		parallelism := uc.Parallelism
+		out.Parallelism = &parallelism
+	}
+	return out
+}
+
+// duration formats a duration as compose files write them, empty for
+// zero.
+func duration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func durationPointer(d *time.Duration) string {
+	if d == nil {
+		return ""
+	}
+	return duration(*d)
+}
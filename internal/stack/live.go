@@ -0,0 +1,135 @@
+package stack
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+)
+
+// Live is the current state of a stack together with the engine objects
+// backing it, which carry IDs and versions needed for updates.
+type Live struct {
+	*Stack
+	ServiceObjects map[string]docker.Service
+	NetworkObjects map[string]docker.Network
+	ConfigObjects  map[string]docker.SwarmConfig
+	SecretObjects  map[string]docker.SwarmSecret
+}
+
+// Fetch loads every object labelled with the stack namespace.
+func Fetch(ctx context.Context, client *docker.Client, name string) (*Live, error) {
+	filters := docker.Filters{}.Label(LabelNamespace + "=" + name)
+	live := &Live{
+		Stack: &Stack{
+			Name:     name,
+			Services: map[string]docker.ServiceSpec{},
+			Networks: map[string]NetworkSpec{},
+			Configs:  map[string]docker.ObjectSpec{},
+			Secrets:  map[string]docker.ObjectSpec{},
+		},
+		ServiceObjects: map[string]docker.Service{},
+		NetworkObjects: map[string]docker.Network{},
+		ConfigObjects:  map[string]docker.SwarmConfig{},
+		SecretObjects:  map[string]docker.SwarmSecret{},
+	}
+
+	services, err := client.ListServices(ctx, filters)
+	if err != nil {
+		return nil, fmt.Errorf("list services of stack %s: %w", name, err)
+	}
+	for _, svc := range services {
+		live.Services[svc.Spec.Name] = svc.Spec
+		live.ServiceObjects[svc.Spec.Name] = svc
+	}
+
+	networks, err := client.ListNetworks(ctx, filters)
+	if err != nil {
+		return nil, fmt.Errorf("list networks of stack %s: %w", name, err)
+	}
+	for _, net := range networks {
+		live.Networks[net.Name] = NetworkSpec{
+			Name:       net.Name,
+			Driver:     net.Driver,
+			Labels:     net.Labels,
+			Options:    net.Options,
+			Attachable: net.Attachable,
+			Internal:   net.Internal,
+		}
+		live.NetworkObjects[net.Name] = net
+	}
+
+	configs, err := client.ListConfigs(ctx, filters)
+	if err != nil {
+		return nil, fmt.Errorf("list configs of stack %s: %w", name, err)
+	}
+	for _, cfg := range configs {
+		live.Configs[cfg.Spec.Name] = cfg.Spec
+		live.ConfigObjects[cfg.Spec.Name] = cfg
+	}
+
+	secrets, err := client.ListSecrets(ctx, filters)
+	if err != nil {
+		return nil, fmt.Errorf("list secrets of stack %s: %w", name, err)
+	}
+	for _, sec := range secrets {
+		live.Secrets[sec.Spec.Name] = sec.Spec
+		live.SecretObjects[sec.Spec.Name] = sec
+	}
+	return live, nil
+}
+
+// Comparable returns a copy of the stack with fields that are assigned by
+// the engine (object IDs, defaulted modes, secret payloads) or by tmpl on
+// every revision cleared, so that desired and live state can be compared
+// directly.
+func (s *Stack) Comparable() *Stack {
+	out := &Stack{
+		Name:     s.Name,
+		Services: make(map[string]docker.ServiceSpec, len(s.Services)),
+		Networks: make(map[string]NetworkSpec, len(s.Networks)),
+		Configs:  comparableObjects(s.Configs, false),
+		Secrets:  comparableObjects(s.Secrets, true),
+	}
+	for name, spec := range s.Services {
+		out.Services[name] = comparableService(spec)
+	}
+	for name, spec := range s.Networks {
+		spec.Labels = ComparableLabels(spec.Labels)
+		out.Networks[name] = spec
+	}
+	return out
+}
+
+func comparableService(spec docker.ServiceSpec) docker.ServiceSpec {
+	if spec.EndpointSpec != nil {
+		ep := *spec.EndpointSpec
+		if ep.Mode == "vip" {
+			ep.Mode = ""
+		}
+		if ep.Mode == "" && len(ep.Ports) == 0 {
+			spec.EndpointSpec = nil
+		} else {
+			spec.EndpointSpec = &ep
+		}
+	}
+	if cs := spec.TaskTemplate.ContainerSpec; cs != nil {
+		copied := *cs
+		copied.Configs = make([]*docker.ConfigReference, len(cs.Configs))
+		for i, ref := range cs.Configs {
+			r := *ref
+			r.ConfigID = ""
+			copied.Configs[i] = &r
+		}
+		copied.Secrets = make([]*docker.SecretReference, len(cs.Secrets))
+		for i, ref := range cs.Secrets {
+			r := *ref
+			r.SecretID = ""
+			copied.Secrets[i] = &r
+		}
+		spec.TaskTemplate.ContainerSpec = &copied
+	}
+	spec.TaskTemplate.ForceUpdate = 0
+	spec.Labels = ComparableLabels(spec.Labels)
+	return spec
+}
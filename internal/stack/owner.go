@@ -0,0 +1,100 @@
+package stack
+
+import (
+	"strconv"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+)
+
+// Ownership labels set on every object a release creates or updates.
+const (
+	// LabelOwner holds the name of the owning release.
+	LabelOwner = "tmpl.owner"
+	// LabelOwnerRevision holds the revision that last modified the object.
+	// It is ignored when comparing desired and live state.
+	LabelOwnerRevision = "tmpl.owner.revision"
+	// LabelOwnerChart holds the chart name and version.
+	LabelOwnerChart = "tmpl.owner.chart"
+)
+
+// SetOwner labels every object of s as owned by the release named after
+// the stack at the given revision.
+func (s *Stack) SetOwner(revision int, chart string) {
+	for name, spec := range s.Services {
+		spec.Labels = withOwner(spec.Labels, s.Name, revision, chart)
+		s.Services[name] = spec
+	}
+	for name, spec := range s.Networks {
+		spec.Labels = withOwner(spec.Labels, s.Name, revision, chart)
+		s.Networks[name] = spec
+	}
+	for name, spec := range s.Configs {
+		spec.Labels = withOwner(spec.Labels, s.Name, revision, chart)
+		s.Configs[name] = spec
+	}
+	for name, spec := range s.Secrets {
+		spec.Labels = withOwner(spec.Labels, s.Name, revision, chart)
+		s.Secrets[name] = spec
+	}
+}
+
+func withOwner(labels map[string]string, release string, revision int, chart string) map[string]string {
+	out := make(map[string]string, len(labels)+4)
+	for k, v := range labels {
+		out[k] = v
+	}
+	out[LabelManagedBy] = managedBy
+	out[LabelOwner] = release
+	if revision > 0 {
+		out[LabelOwnerRevision] = strconv.Itoa(revision)
+	}
+	if chart != "" {
+		out[LabelOwnerChart] = chart
+	}
+	return out
+}
+
+// Owner returns the release owning an object with labels. Objects created
+// by tmpl before ownership labels existed belong to their stack.
+func Owner(labels map[string]string) string {
+	if owner := labels[LabelOwner]; owner != "" {
+		return owner
+	}
+	if Managed(labels) {
+		return labels[LabelNamespace]
+	}
+	return ""
+}
+
+// Adopt returns labels that hand an object to release, keeping its other
+// labels.
+func Adopt(labels map[string]string, release string) map[string]string {
+	return withOwner(labels, release, 0, "")
+}
+
+// ComparableLabels returns labels without the ones that change on every
+// revision.
+func ComparableLabels(labels map[string]string) map[string]string {
+	if _, ok := labels[LabelOwnerRevision]; !ok {
+		return labels
+	}
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if k != LabelOwnerRevision {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func comparableObjects(objects map[string]docker.ObjectSpec, dropData bool) map[string]docker.ObjectSpec {
+	out := make(map[string]docker.ObjectSpec, len(objects))
+	for name, spec := range objects {
+		spec.Labels = ComparableLabels(spec.Labels)
+		if dropData {
+			spec.Data = nil
+		}
+		out[name] = spec
+	}
+	return out
+}
@@ -0,0 +1,84 @@
+package stack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+)
+
+// Constraint is a parsed placement constraint such as
+// node.labels.zone==eu-west-1a.
+type Constraint struct {
+	Field string
+	// Equal is true for ==, false for !=.
+	Equal bool
+	Value string
+}
+
+// String formats c the way the engine expects it.
+func (c Constraint) String() string {
+	op := "!="
+	if c.Equal {
+		op = "=="
+	}
+	return c.Field + op + c.Value
+}
+
+// constraintFields are the attributes tmpl can evaluate; engine.labels
+// and node.platform constraints are accepted but not checked.
+var constraintFields = []string{"node.id", "node.hostname", "node.role", "node.labels.", "engine.labels.", "node.platform.os", "node.platform.arch"}
+
+// ParseConstraint parses a placement constraint, rejecting syntax the
+// engine would refuse when the service is created.
+func ParseConstraint(s string) (Constraint, error) {
+	op := "=="
+	i := strings.Index(s, op)
+	if j := strings.Index(s, "!="); j >= 0 && (i < 0 || j < i) {
+		op, i = "!=", j
+	}
+	if i < 0 {
+		return Constraint{}, fmt.Errorf("invalid placement constraint %q: expected == or !=", s)
+	}
+	c := Constraint{Field: strings.TrimSpace(s[:i]), Equal: op == "==", Value: strings.TrimSpace(s[i+len(op):])}
+	if c.Value == "" {
+		return Constraint{}, fmt.Errorf("invalid placement constraint %q: missing value", s)
+	}
+	known := false
+	for _, f := range constraintFields {
+		if c.Field == f || strings.HasSuffix(f, ".") && strings.HasPrefix(c.Field, f) && len(c.Field) > len(f) {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return Constraint{}, fmt.Errorf("invalid placement constraint %q: unknown attribute %s", s, c.Field)
+	}
+	if c.Field == "node.role" && c.Value != "manager" && c.Value != "worker" {
+		return Constraint{}, fmt.Errorf("invalid placement constraint %q: node.role is manager or worker", s)
+	}
+	return c, nil
+}
+
+// Match reports whether node satisfies c. Attributes tmpl does not
+// evaluate always match.
+func (c Constraint) Match(node docker.Node) bool {
+	var actual string
+	var ok bool
+	switch {
+	case c.Field == "node.id":
+		actual, ok = node.ID, true
+	case c.Field == "node.hostname":
+		actual, ok = node.Description.Hostname, true
+	case c.Field == "node.role":
+		actual, ok = node.Spec.Role, true
+	case strings.HasPrefix(c.Field, "node.labels."):
+		actual, ok = node.Spec.Labels[strings.TrimPrefix(c.Field, "node.labels.")]
+	default:
+		return true
+	}
+	if c.Equal {
+		return ok && actual == c.Value
+	}
+	return !ok || actual != c.Value
+}
@@ -0,0 +1,143 @@
+package stack
+
+import (
+	"maps"
+	"strings"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+)
+
+// Rename returns a copy of s as the stack name in namespace, for deploying
+// the same objects as another release. s was converted in fromNamespace.
+// Object names and references follow the naming of Convert: stack-scoped
+// names get the new stack prefix, explicit names of stack objects the new
+// namespace prefix, and external objects keep their names. Specs are
+// otherwise unchanged.
+func (s *Stack) Rename(name, fromNamespace, namespace string) *Stack {
+	r := &renamer{from: s.Name, to: name, fromNamespace: fromNamespace, namespace: namespace, names: map[string]string{}}
+	out := &Stack{
+		Name:     name,
+		Services: make(map[string]docker.ServiceSpec, len(s.Services)),
+		Networks: make(map[string]NetworkSpec, len(s.Networks)),
+		Configs:  make(map[string]docker.ObjectSpec, len(s.Configs)),
+		Secrets:  make(map[string]docker.ObjectSpec, len(s.Secrets)),
+	}
+	for old, spec := range s.Networks {
+		spec.Name = r.object(old)
+		spec.Labels = r.labels(spec.Labels)
+		out.Networks[spec.Name] = spec
+	}
+	for old, spec := range s.Configs {
+		spec = r.objectSpec(old, spec)
+		out.Configs[spec.Name] = spec
+	}
+	for old, spec := range s.Secrets {
+		spec = r.objectSpec(old, spec)
+		out.Secrets[spec.Name] = spec
+		if digest, ok := s.SecretDigests[old]; ok {
+			if out.SecretDigests == nil {
+				out.SecretDigests = map[string]string{}
+			}
+			out.SecretDigests[spec.Name] = digest
+		}
+	}
+	for _, spec := range s.Services {
+		spec = r.service(spec)
+		out.Services[spec.Name] = spec
+	}
+	return out
+}
+
+// renamer maps the names of one stack to another. names holds the new
+// name of every object of the stack; other references are external.
+type renamer struct {
+	from, to                 string
+	fromNamespace, namespace string
+	names                    map[string]string
+}
+
+// object returns the new name of the stack object old and remembers it.
+func (r *renamer) object(old string) string {
+	name := r.scoped(old)
+	if !strings.HasPrefix(old, r.from+"_") {
+		name = Namespaced(r.namespace, strings.TrimPrefix(old, Namespaced(r.fromNamespace, "")))
+	}
+	r.names[old] = name
+	return name
+}
+
+// scoped returns the new name of a stack-scoped name, or name unchanged.
+func (r *renamer) scoped(name string) string {
+	if rest, ok := strings.CutPrefix(name, r.from+"_"); ok {
+		return r.to + "_" + rest
+	}
+	return name
+}
+
+// reference returns the new name of a referenced object.
+func (r *renamer) reference(name string) string {
+	if renamed, ok := r.names[name]; ok {
+		return renamed
+	}
+	return name
+}
+
+func (r *renamer) labels(labels map[string]string) map[string]string {
+	out := maps.Clone(labels)
+	if out == nil {
+		out = map[string]string{}
+	}
+	out[LabelNamespace] = r.to
+	delete(out, LabelTenant)
+	if r.namespace != "" {
+		out[LabelTenant] = r.namespace
+	}
+	if object, ok := out[LabelObject]; ok {
+		out[LabelObject] = r.scoped(object)
+	}
+	return out
+}
+
+func (r *renamer) objectSpec(old string, spec docker.ObjectSpec) docker.ObjectSpec {
+	spec.Name = r.object(old)
+	spec.Labels = r.labels(spec.Labels)
+	return spec
+}
+
+func (r *renamer) service(spec docker.ServiceSpec) docker.ServiceSpec {
+	spec.Name = r.scoped(spec.Name)
+	spec.Labels = r.labels(spec.Labels)
+	task := spec.TaskTemplate
+	networks := make([]docker.NetworkAttachmentConfig, len(task.Networks))
+	for i, n := range task.Networks {
+		n.Target = r.reference(n.Target)
+		networks[i] = n
+	}
+	task.Networks = networks
+	if cs := task.ContainerSpec; cs != nil {
+		c := *cs
+		c.Labels = r.labels(c.Labels)
+		c.Mounts = make([]docker.Mount, len(cs.Mounts))
+		for i, m := range cs.Mounts {
+			if m.Type == "volume" {
+				m.Source = r.scoped(m.Source)
+			}
+			c.Mounts[i] = m
+		}
+		c.Configs = make([]*docker.ConfigReference, len(cs.Configs))
+		for i, ref := range cs.Configs {
+			copied := *ref
+			copied.ConfigID, copied.ConfigName = "", r.reference(ref.ConfigName)
+			c.Configs[i] = &copied
+		}
+		c.Secrets = make([]*docker.SecretReference, len(cs.Secrets))
+		for i, ref := range cs.Secrets {
+			copied := *ref
+			copied.SecretID, copied.SecretName = "", r.reference(ref.SecretName)
+			c.Secrets[i] = &copied
+		}
+		task.ContainerSpec = &c
+	}
+	spec.TaskTemplate = task
+	return spec
+}
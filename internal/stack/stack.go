@@ -0,0 +1,640 @@
+package stack
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acebelowzero/tmpl/internal/compose"
+	"github.com/acebelowzero/tmpl/internal/docker"
+)
+
+const (
+	// LabelNamespace is the label docker stack uses to group stack objects.
+	LabelNamespace = "com.docker.stack.namespace"
+	// LabelImage records the image requested for a service.
+	LabelImage = "com.docker.stack.image"
+	// LabelObject records the unversioned name of a rotated config or
+	// secret, shared by all of its versions.
+	LabelObject = "tmpl.object"
+	// LabelManagedBy marks objects created by tmpl; only those are pruned.
+	LabelManagedBy = "tmpl.managed-by"
+	managedBy      = "tmpl"
+	// LabelTenant records the --namespace a stack was deployed in, so teams
+	// sharing a swarm can tell their objects apart.
+	LabelTenant = "tmpl.namespace"
+
+	// contentHashLength is the number of hex digits of the content digest
+	// appended to rotated config and secret names.
+	contentHashLength = 10
+
+	defaultNetwork = "default"
+)
+
+// Stack is the desired or live set of swarm objects of a stack, keyed by
+// their fully qualified names.
+type Stack struct {
+	Name     string                        `json:"name"`
+	Services map[string]docker.ServiceSpec `json:"services,omitempty"`
+	Networks map[string]NetworkSpec        `json:"networks,omitempty"`
+	Configs  map[string]docker.ObjectSpec  `json:"configs,omitempty"`
+	Secrets  map[string]docker.ObjectSpec  `json:"secrets,omitempty"`
+	// SecretDigests holds the content digests of the secrets whose
+	// payloads were left out, as in recorded stacks; see WithoutSecretData.
+	SecretDigests map[string]string `json:"secretDigests,omitempty"`
+}
+
+// NetworkSpec is the desired state of a stack network.
+type NetworkSpec struct {
+	Name       string            `json:"Name"`
+	Driver     string            `json:"Driver,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+	Options    map[string]string `json:"Options,omitempty"`
+	Attachable bool              `json:"Attachable,omitempty"`
+	Internal   bool              `json:"Internal,omitempty"`
+}
+
+// Options controls conversion of a compose document.
+type Options struct {
+	// Name is the stack namespace prefixed to object names.
+	Name string
+	// BaseDir resolves relative config and secret file paths.
+	BaseDir string
+	// Namespace labels every object with LabelTenant and prefixes explicit
+	// object names as Namespaced does. Name is expected to be namespaced
+	// already.
+	Namespace string
+}
+
+// Namespaced returns the stack name of release name in namespace, which
+// prefixes the names of all objects of the stack.
+func Namespaced(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "-" + name
+}
+
+// Convert translates a compose stack into swarm object specs, following the
+// naming and labelling conventions of docker stack deploy.
+func Convert(src *compose.Stack, opts Options) (*Stack, error) {
+	if opts.Name == "" {
+		return nil, fmt.Errorf("stack name is required")
+	}
+	c := &converter{src: src, opts: opts, configNames: map[string]string{}, secretNames: map[string]string{}}
+	out := &Stack{
+		Name:     opts.Name,
+		Services: map[string]docker.ServiceSpec{},
+		Networks: map[string]NetworkSpec{},
+		Configs:  map[string]docker.ObjectSpec{},
+		Secrets:  map[string]docker.ObjectSpec{},
+	}
+
+	// Configs and secrets are converted first: their names depend on their
+	// content and services refer to them by name.
+	// Objects swarm would reject are all reported, before any of the
+	// stack is created.
+	var problems []string
+	for _, name := range sortedKeys(src.Configs) {
+		obj := src.Configs[name]
+		if obj.External {
+			continue
+		}
+		spec, err := c.object(name, obj)
+		if err != nil {
+			return nil, fmt.Errorf("config %s: %w", name, err)
+		}
+		problems = append(problems, checkObject("config", name, spec)...)
+		out.Configs[spec.Name] = spec
+		c.configNames[name] = spec.Name
+	}
+	for _, name := range sortedKeys(src.Secrets) {
+		obj := src.Secrets[name]
+		if obj.External {
+			continue
+		}
+		spec, err := c.object(name, obj)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s: %w", name, err)
+		}
+		problems = append(problems, checkObject("secret", name, spec)...)
+		out.Secrets[spec.Name] = spec
+		c.secretNames[name] = spec.Name
+	}
+	if len(problems) > 0 {
+		return nil, &ObjectError{Stack: opts.Name, Problems: problems}
+	}
+
+	for _, name := range src.ServiceNames() {
+		spec, err := c.service(name, src.Services[name])
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		out.Services[spec.Name] = spec
+	}
+
+	for _, name := range c.usedNetworks() {
+		net := src.Networks[name]
+		if net.External {
+			continue
+		}
+		driver := net.Driver
+		if driver == "" {
+			driver = "overlay"
+		}
+		full := c.scoped(name, net.Name, false)
+		out.Networks[full] = NetworkSpec{
+			Name:       full,
+			Driver:     driver,
+			Labels:     c.labels(net.Labels),
+			Options:    net.DriverOpts,
+			Attachable: net.Attachable,
+			Internal:   net.Internal,
+		}
+	}
+
+	return out, nil
+}
+
+// WithoutSecretData returns a copy of s without secret payloads, which
+// keeps the digest of every secret in SecretDigests, so that the stack can
+// be stored and the payloads, supplied again, checked against it.
+func (s *Stack) WithoutSecretData() *Stack {
+	out := *s
+	out.Secrets = make(map[string]docker.ObjectSpec, len(s.Secrets))
+	out.SecretDigests = make(map[string]string, len(s.Secrets))
+	for name, spec := range s.Secrets {
+		if digest := s.SecretDigest(name); digest != "" {
+			out.SecretDigests[name] = digest
+		}
+		spec.Data = nil
+		out.Secrets[name] = spec
+	}
+	return &out
+}
+
+// SecretDigest returns the digest of the content of the secret name: that
+// of its payload, or the one recorded when the payload was left out.
+func (s *Stack) SecretDigest(name string) string {
+	if data := s.Secrets[name].Data; data != nil {
+		return Digest(data)
+	}
+	return s.SecretDigests[name]
+}
+
+// Digest identifies config and secret content without revealing it.
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ServiceNames returns the sorted fully qualified service names.
+func (s *Stack) ServiceNames() []string {
+	return sortedKeys(s.Services)
+}
+
+type converter struct {
+	src  *compose.Stack
+	opts Options
+	// configNames and secretNames map compose keys to the converted,
+	// possibly versioned, object names.
+	configNames map[string]string
+	secretNames map[string]string
+}
+
+// scoped returns the stack-qualified name of an object. Explicit names of
+// objects the stack creates are only qualified by the namespace; external
+// objects keep their name.
+func (c *converter) scoped(name, explicit string, external bool) string {
+	switch {
+	case external && explicit == "":
+		return name
+	case external:
+		return explicit
+	case explicit != "":
+		return Namespaced(c.opts.Namespace, explicit)
+	}
+	return c.opts.Name + "_" + name
+}
+
+func (c *converter) labels(extra map[string]string) map[string]string {
+	labels := map[string]string{LabelNamespace: c.opts.Name, LabelManagedBy: managedBy}
+	for k, v := range extra {
+		labels[k] = v
+	}
+	if c.opts.Namespace != "" {
+		labels[LabelTenant] = c.opts.Namespace
+	}
+	return labels
+}
+
+// Managed reports whether labels mark an object as created by tmpl.
+func Managed(labels map[string]string) bool {
+	return labels[LabelManagedBy] == managedBy
+}
+
+func (c *converter) usedNetworks() []string {
+	seen := map[string]bool{}
+	for _, svc := range c.src.Services {
+		if len(svc.Networks) == 0 {
+			seen[defaultNetwork] = true
+		}
+		for name := range svc.Networks {
+			seen[name] = true
+		}
+	}
+	return sortedKeys(seen)
+}
+
+func (c *converter) networkName(name string) string {
+	net := c.src.Networks[name]
+	return c.scoped(name, net.Name, net.External)
+}
+
+func (c *converter) service(name string, svc compose.Service) (docker.ServiceSpec, error) {
+	container := &docker.ContainerSpec{
+		Image:      svc.Image,
+		Labels:     c.labels(svc.Labels),
+		Command:    []string(svc.Entrypoint),
+		Args:       []string(svc.Command),
+		Hostname:   svc.Hostname,
+		Env:        svc.Environment.Pairs(),
+		Dir:        svc.WorkingDir,
+		User:       svc.User,
+		StopSignal: svc.StopSignal,
+		Sysctls:    svc.Sysctls,
+	}
+	if len(svc.Entrypoint) == 1 {
+		container.Command = strings.Fields(svc.Entrypoint[0])
+	}
+	if len(svc.Command) == 1 {
+		container.Args = strings.Fields(svc.Command[0])
+	}
+
+	for _, v := range svc.Volumes {
+		mount := docker.Mount{Type: v.Type, Source: v.Source, Target: v.Target, ReadOnly: v.ReadOnly}
+		if mount.Type == "" {
+			mount.Type = "volume"
+		}
+		if mount.Type == "volume" && mount.Source != "" {
+			vol := c.src.Volumes[mount.Source]
+			mount.Source = c.scoped(mount.Source, vol.Name, vol.External)
+		}
+		container.Mounts = append(container.Mounts, mount)
+	}
+
+	for _, ref := range svc.Configs {
+		obj := c.src.Configs[ref.Source]
+		target := ref.Target
+		if target == "" {
+			target = "/" + ref.Source
+		}
+		container.Configs = append(container.Configs, &docker.ConfigReference{
+			ConfigName: c.objectName(ref.Source, obj, c.configNames),
+			File:       fileTarget(target, ref, 0o444),
+		})
+	}
+	for _, ref := range svc.Secrets {
+		obj := c.src.Secrets[ref.Source]
+		target := ref.Target
+		if target == "" {
+			target = ref.Source
+		}
+		container.Secrets = append(container.Secrets, &docker.SecretReference{
+			SecretName: c.objectName(ref.Source, obj, c.secretNames),
+			File:       fileTarget(target, ref, 0o444),
+		})
+	}
+
+	if hc := svc.Healthcheck; hc != nil {
+		health, err := healthcheck(hc)
+		if err != nil {
+			return docker.ServiceSpec{}, err
+		}
+		container.Healthcheck = health
+	}
+
+	task := docker.TaskSpec{ContainerSpec: container}
+	resources, err := resourceRequirements(svc.Deploy.Resources)
+	if err != nil {
+		return docker.ServiceSpec{}, err
+	}
+	task.Resources = resources
+
+	if rp := svc.Deploy.RestartPolicy; rp != nil {
+		policy, err := restartPolicy(rp)
+		if err != nil {
+			return docker.ServiceSpec{}, err
+		}
+		task.RestartPolicy = policy
+	}
+
+	placement := svc.Deploy.Placement
+	for _, c := range placement.Constraints {
+		if _, err := ParseConstraint(c); err != nil {
+			return docker.ServiceSpec{}, err
+		}
+	}
+	if len(placement.Constraints) > 0 || len(placement.Preferences) > 0 || placement.MaxReplicas > 0 {
+		task.Placement = &docker.Placement{Constraints: placement.Constraints, MaxReplicas: placement.MaxReplicas}
+		for _, pref := range placement.Preferences {
+			task.Placement.Preferences = append(task.Placement.Preferences, docker.PlacementPreference{
+				Spread: &docker.SpreadOver{SpreadDescriptor: pref.Spread},
+			})
+		}
+	}
+
+	networks := svc.Networks.Names()
+	if len(networks) == 0 {
+		networks = []string{defaultNetwork}
+	}
+	for _, net := range networks {
+		aliases := []string{name}
+		if att := svc.Networks[net]; att != nil {
+			aliases = append(aliases, att.Aliases...)
+		}
+		task.Networks = append(task.Networks, docker.NetworkAttachmentConfig{Target: c.networkName(net), Aliases: aliases})
+	}
+
+	spec := docker.ServiceSpec{
+		Name:         c.scoped(name, "", false),
+		Labels:       c.labels(svc.Deploy.Labels),
+		TaskTemplate: task,
+	}
+	spec.Labels[LabelImage] = svc.Image
+	if _, err := HealthGate(spec); err != nil {
+		return docker.ServiceSpec{}, err
+	}
+
+	switch svc.Deploy.Mode {
+	case "global":
+		spec.Mode.Global = &docker.GlobalService{}
+	case "", "replicated":
+		replicas := uint64(1)
+		if svc.Deploy.Replicas != nil {
+			replicas = *svc.Deploy.Replicas
+		}
+		spec.Mode.Replicated = &docker.ReplicatedService{Replicas: &replicas}
+	default:
+		return docker.ServiceSpec{}, fmt.Errorf("unsupported deploy mode %q", svc.Deploy.Mode)
+	}
+
+	if spec.UpdateConfig, err = updateConfig(svc.Deploy.UpdateConfig); err != nil {
+		return docker.ServiceSpec{}, fmt.Errorf("update_config: %w", err)
+	}
+	if spec.RollbackConfig, err = updateConfig(svc.Deploy.RollbackConfig); err != nil {
+		return docker.ServiceSpec{}, fmt.Errorf("rollback_config: %w", err)
+	}
+
+	if len(svc.Ports) > 0 || svc.Deploy.EndpointMode != "" {
+		spec.EndpointSpec = &docker.EndpointSpec{Mode: svc.Deploy.EndpointMode}
+		for _, p := range svc.Ports {
+			proto := p.Protocol
+			if proto == "" {
+				proto = "tcp"
+			}
+			mode := p.Mode
+			if mode == "" {
+				mode = "ingress"
+			}
+			spec.EndpointSpec.Ports = append(spec.EndpointSpec.Ports, docker.PortConfig{
+				Protocol:      proto,
+				TargetPort:    p.Target,
+				PublishedPort: p.Published,
+				PublishMode:   mode,
+			})
+		}
+	}
+	return spec, nil
+}
+
+func (c *converter) objectName(name string, obj compose.Object, converted map[string]string) string {
+	if full, ok := converted[name]; ok {
+		return full
+	}
+	return c.scoped(name, obj.Name, obj.External)
+}
+
+func (c *converter) object(name string, obj compose.Object) (docker.ObjectSpec, error) {
+	data := []byte(obj.Content)
+	if obj.File != "" {
+		path := obj.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(c.opts.BaseDir, path)
+		}
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return docker.ObjectSpec{}, fmt.Errorf("read %s: %w", path, err)
+		}
+	}
+	spec := docker.ObjectSpec{
+		Name:   c.scoped(name, obj.Name, false),
+		Labels: c.labels(obj.Labels),
+		Data:   data,
+	}
+	// Swarm configs and secrets are immutable, so objects without an
+	// explicit name are versioned by content and rotated on change.
+	if obj.Name == "" {
+		spec.Labels[LabelObject] = spec.Name
+		spec.Name = versionedName(spec.Name, data)
+	}
+	return spec, nil
+}
+
+// ObjectError reports the configs and secrets of a stack swarm would
+// reject.
+type ObjectError struct {
+	Stack    string
+	Problems []string
+}
+
+func (e *ObjectError) Error() string {
+	return fmt.Sprintf("stack %s has configs or secrets swarm rejects:\n  %s", e.Stack, strings.Join(e.Problems, "\n  "))
+}
+
+// checkObject describes why swarm would reject spec, the config or secret
+// key of kind.
+func checkObject(kind, key string, spec docker.ObjectSpec) []string {
+	var problems []string
+	if err := compose.CheckObjectName(spec.Name); err != nil {
+		problems = append(problems, fmt.Sprintf("%s %s: %v", kind, key, err))
+	}
+	if len(spec.Data) > compose.MaxObjectSize {
+		problems = append(problems, compose.OversizedMessage(kind, key, int64(len(spec.Data))))
+	}
+	return problems
+}
+
+// versionedName returns the name of the version of a config or secret
+// holding data.
+func versionedName(name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	return name + "-" + hex.EncodeToString(sum[:])[:contentHashLength]
+}
+
+func fileTarget(name string, ref compose.FileReference, defaultMode os.FileMode) *docker.FileTarget {
+	uid, gid := ref.UID, ref.GID
+	if uid == "" {
+		uid = "0"
+	}
+	if gid == "" {
+		gid = "0"
+	}
+	mode := defaultMode
+	if ref.Mode != nil {
+		mode = os.FileMode(*ref.Mode)
+	}
+	return &docker.FileTarget{Name: name, UID: uid, GID: gid, Mode: mode}
+}
+
+func healthcheck(hc *compose.Healthcheck) (*docker.HealthConfig, error) {
+	if hc.Disable {
+		return &docker.HealthConfig{Test: []string{"NONE"}}, nil
+	}
+	out := &docker.HealthConfig{Test: []string(hc.Test)}
+	if len(hc.Test) == 1 {
+		out.Test = []string{"CMD-SHELL", hc.Test[0]}
+	}
+	var err error
+	if out.Interval, err = parseDuration(hc.Interval); err != nil {
+		return nil, fmt.Errorf("healthcheck interval: %w", err)
+	}
+	if out.Timeout, err = parseDuration(hc.Timeout); err != nil {
+		return nil, fmt.Errorf("healthcheck timeout: %w", err)
+	}
+	if out.StartPeriod, err = parseDuration(hc.StartPeriod); err != nil {
+		return nil, fmt.Errorf("healthcheck start_period: %w", err)
+	}
+	if hc.Retries != nil {
+		out.Retries = int(*hc.Retries)
+	}
+	return out, nil
+}
+
+func resourceRequirements(r compose.Resources) (*docker.ResourceRequirements, error) {
+	if r.Limits == nil && r.Reservations == nil {
+		return nil, nil
+	}
+	out := &docker.ResourceRequirements{}
+	var err error
+	if out.Limits, err = resources(r.Limits); err != nil {
+		return nil, fmt.Errorf("resource limits: %w", err)
+	}
+	if out.Reservations, err = resources(r.Reservations); err != nil {
+		return nil, fmt.Errorf("resource reservations: %w", err)
+	}
+	return out, nil
+}
+
+func resources(r *compose.Resource) (*docker.Resources, error) {
+	if r == nil {
+		return nil, nil
+	}
+	out := &docker.Resources{}
+	if r.CPUs != "" {
+		cpus, err := strconv.ParseFloat(r.CPUs, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpus %q: %w", r.CPUs, err)
+		}
+		out.NanoCPUs = int64(cpus * 1e9)
+	}
+	if r.Memory != "" {
+		mem, err := ParseBytes(r.Memory)
+		if err != nil {
+			return nil, err
+		}
+		out.MemoryBytes = mem
+	}
+	return out, nil
+}
+
+// ParseBytes converts compose byte amounts such as 512m, 1g or 256MB.
+func ParseBytes(s string) (int64, error) {
+	raw := strings.ToLower(strings.TrimSpace(s))
+	raw = strings.TrimSuffix(raw, "b")
+	mult := int64(1)
+	if n := len(raw); n > 0 {
+		switch raw[n-1] {
+		case 'k':
+			mult = 1 << 10
+		case 'm':
+			mult = 1 << 20
+		case 'g':
+			mult = 1 << 30
+		case 't':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			raw = raw[:n-1]
+		}
+	}
+	val, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid byte amount %q", s)
+	}
+	return int64(val * float64(mult)), nil
+}
+
+func restartPolicy(rp *compose.RestartPolicy) (*docker.RestartPolicy, error) {
+	out := &docker.RestartPolicy{Condition: rp.Condition, MaxAttempts: rp.MaxAttempts}
+	if rp.Delay != "" {
+		d, err := time.ParseDuration(rp.Delay)
+		if err != nil {
+			return nil, fmt.Errorf("restart_policy delay: %w", err)
+		}
+		out.Delay = &d
+	}
+	if rp.Window != "" {
+		w, err := time.ParseDuration(rp.Window)
+		if err != nil {
+			return nil, fmt.Errorf("restart_policy window: %w", err)
+		}
+		out.Window = &w
+	}
+	return out, nil
+}
+
+func updateConfig(uc *compose.UpdateConfig) (*docker.UpdateConfig, error) {
+	if uc == nil {
+		return nil, nil
+	}
+	out := &docker.UpdateConfig{
+		Parallelism:     1,
+		FailureAction:   uc.FailureAction,
+		MaxFailureRatio: float32(uc.MaxFailureRatio),
+		Order:           uc.Order,
+	}
+	if uc.Parallelism != nil {
+		out.Parallelism = *uc.Parallelism
+	}
+	var err error
+	if out.Delay, err = parseDuration(uc.Delay); err != nil {
+		return nil, fmt.Errorf("delay: %w", err)
+	}
+	if out.Monitor, err = parseDuration(uc.Monitor); err != nil {
+		return nil, fmt.Errorf("monitor: %w", err)
+	}
+	return out, nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
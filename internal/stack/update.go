@@ -0,0 +1,56 @@
+package stack
+
+import (
+	"time"
+
+	"github.com/acebelowzero/tmpl/internal/docker"
+)
+
+// Update orders accepted by the engine.
+const (
+	UpdateStartFirst = "start-first"
+	UpdateStopFirst  = "stop-first"
+)
+
+// UpdatePolicy overrides the rolling update settings of every service.
+// Nil and empty fields keep the setting of the chart.
+type UpdatePolicy struct {
+	Parallelism *uint64
+	Delay       *time.Duration
+	Order       string
+	// RollbackOnFailure sets the failure action to rollback.
+	RollbackOnFailure bool
+}
+
+// IsZero reports whether the policy overrides nothing.
+func (p UpdatePolicy) IsZero() bool {
+	return p.Parallelism == nil && p.Delay == nil && p.Order == "" && !p.RollbackOnFailure
+}
+
+// SetUpdatePolicy applies p to the update config of every service of s.
+// Services without one start from the engine defaults.
+func (s *Stack) SetUpdatePolicy(p UpdatePolicy) {
+	if p.IsZero() {
+		return
+	}
+	for name, spec := range s.Services {
+		uc := docker.UpdateConfig{Parallelism: 1}
+		if spec.UpdateConfig != nil {
+			uc = *spec.UpdateConfig
+		}
+		if p.Parallelism != nil {
+			uc.Parallelism = *p.Parallelism
+		}
+		if p.Delay != nil {
+			uc.Delay = *p.Delay
+		}
+		if p.Order != "" {
+			uc.Order = p.Order
+		}
+		if p.RollbackOnFailure {
+			uc.FailureAction = "rollback"
+		}
+		spec.UpdateConfig = &uc
+		s.Services[name] = spec
+	}
+}
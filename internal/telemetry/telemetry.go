@@ -0,0 +1,226 @@
+// Package telemetry exports OpenTelemetry traces and metrics of tmpl
+// commands over OTLP/HTTP when an OTLP endpoint is configured through the
+// standard OTEL_EXPORTER_OTLP_* environment variables, and serves the
+// metrics of long-running commands to Prometheus.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/acebelowzero/tmpl/internal/version"
+)
+
+// scope names the instrumentation of tmpl.
+const scope = "github.com/acebelowzero/tmpl"
+
+// Enabled reports whether the environment configures an OTLP endpoint and
+// does not disable the SDK.
+func Enabled() bool {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return false
+	}
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_METRICS_ENDPOINT"} {
+		if os.Getenv(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// prometheusReader collects the metrics served by MetricsHandler once it
+// was called.
+var prometheusReader sdkmetric.Reader
+
+// MetricsHandler makes Setup collect metrics for Prometheus and returns the
+// handler serving them, with those of the Go runtime, in the Prometheus
+// text format. It must be called before Setup.
+func MetricsHandler() (http.Handler, error) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	exporter, err := otelprometheus.New(otelprometheus.WithRegisterer(registry), otelprometheus.WithoutScopeInfo())
+	if err != nil {
+		return nil, err
+	}
+	prometheusReader = exporter
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{}), nil
+}
+
+// Setup installs the global tracer and meter providers when Enabled and
+// returns the func that flushes and stops them. Without an endpoint the
+// providers stay no-ops and shutdown does nothing, unless MetricsHandler
+// was called, which installs a meter provider for it. A trace context in
+// $TRACEPARENT, as set by CI systems, becomes the parent of the spans of
+// ctx.
+func Setup(ctx context.Context) (_ context.Context, shutdown func(context.Context) error, err error) {
+	shutdown = func(context.Context) error { return nil }
+	if !Enabled() && prometheusReader == nil {
+		return ctx, shutdown, nil
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", "tmpl"),
+			attribute.String("service.version", version.Version),
+		),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return ctx, shutdown, err
+	}
+	meterOptions := []sdkmetric.Option{sdkmetric.WithResource(res)}
+	if prometheusReader != nil {
+		meterOptions = append(meterOptions, sdkmetric.WithReader(prometheusReader))
+	}
+	if !Enabled() {
+		meterProvider := sdkmetric.NewMeterProvider(meterOptions...)
+		otel.SetMeterProvider(meterProvider)
+		return ctx, meterProvider.Shutdown, nil
+	}
+	traceExporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return ctx, shutdown, err
+	}
+	metricExporter, err := otlpmetrichttp.New(ctx)
+	if err != nil {
+		return ctx, shutdown, err
+	}
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+	meterProvider := sdkmetric.NewMeterProvider(append(meterOptions, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)))...)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetMeterProvider(meterProvider)
+	propagator := propagation.TraceContext{}
+	otel.SetTextMapPropagator(propagator)
+	if parent := os.Getenv("TRACEPARENT"); parent != "" {
+		ctx = propagator.Extract(ctx, propagation.MapCarrier{"traceparent": parent})
+	}
+	return ctx, func(ctx context.Context) error {
+		return errors.Join(tracerProvider.Shutdown(ctx), meterProvider.Shutdown(ctx))
+	}, nil
+}
+
+// Operation is a kind of work that is traced and counted.
+type Operation struct {
+	// Name names the spans of the operation.
+	Name string
+	// Counter is the metric counting the operation, and Description
+	// describes it.
+	Counter     string
+	Description string
+}
+
+// Instrumented operations.
+var (
+	Command = Operation{Name: "command", Counter: "tmpl.commands", Description: "Commands run"}
+	Fetch   = Operation{Name: "source.fetch", Counter: "tmpl.source.fetches", Description: "Remote sources fetched"}
+	Decrypt = Operation{Name: "sops.decrypt", Counter: "tmpl.decryptions", Description: "Files decrypted with sops"}
+	Render  = Operation{Name: "render", Counter: "tmpl.renders", Description: "Charts rendered"}
+	Docker  = Operation{Name: "docker.request", Counter: "tmpl.docker.requests", Description: "Docker Engine API requests"}
+)
+
+// instruments holds the metrics of the operations, created on first use
+// from the global meter provider.
+var instruments struct {
+	once     sync.Once
+	counters map[string]metric.Int64Counter
+	duration metric.Float64Histogram
+	cache    metric.Int64Counter
+}
+
+func counter(op Operation) metric.Int64Counter {
+	instruments.once.Do(createInstruments)
+	return instruments.counters[op.Counter]
+}
+
+func createInstruments() {
+	meter := otel.Meter(scope)
+	instruments.counters = map[string]metric.Int64Counter{}
+	for _, o := range []Operation{Command, Fetch, Decrypt, Render, Docker} {
+		c, _ := meter.Int64Counter(o.Counter, metric.WithDescription(o.Description))
+		instruments.counters[o.Counter] = c
+	}
+	instruments.duration, _ = meter.Float64Histogram("tmpl.operation.duration",
+		metric.WithDescription("Duration of traced operations"), metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60))
+	instruments.cache, _ = meter.Int64Counter("tmpl.cache.lookups", metric.WithDescription("Cache lookups"))
+}
+
+// CountCacheLookup counts a lookup in the named cache, such as chart, with
+// a result of hit or miss.
+func CountCacheLookup(ctx context.Context, cache string, hit bool) {
+	instruments.once.Do(createInstruments)
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	instruments.cache.Add(ctx, 1, metric.WithAttributes(attribute.String("cache", cache), attribute.String("result", result)))
+}
+
+// Span is a running operation.
+type Span struct {
+	span  trace.Span
+	op    Operation
+	start time.Time
+	attrs []attribute.KeyValue
+}
+
+// Start starts a span of op as a child of the span in ctx and returns the
+// context carrying it. attrs are set on the span and on the metrics of op,
+// so they must have few distinct values; use Annotate for the others.
+//
+//	ctx, span := telemetry.Start(ctx, telemetry.Render)
+//	defer func() { span.End(err) }()
+func Start(ctx context.Context, op Operation, attrs ...attribute.KeyValue) (context.Context, *Span) {
+	ctx, span := otel.Tracer(scope).Start(ctx, op.Name, trace.WithAttributes(attrs...))
+	return ctx, &Span{span: span, op: op, start: time.Now(), attrs: attrs}
+}
+
+// StartNamed is Start with a span name other than the name of op, such as
+// the command being run.
+func StartNamed(ctx context.Context, op Operation, name string, attrs ...attribute.KeyValue) (context.Context, *Span) {
+	ctx, span := otel.Tracer(scope).Start(ctx, name, trace.WithAttributes(attrs...))
+	return ctx, &Span{span: span, op: op, start: time.Now(), attrs: attrs}
+}
+
+// Annotate sets attributes on the span only.
+func (s *Span) Annotate(attrs ...attribute.KeyValue) {
+	s.span.SetAttributes(attrs...)
+}
+
+// End ends the span with the outcome err and counts the operation with
+// its attributes and a result of ok or error.
+func (s *Span) End(err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+	attrs := metric.WithAttributes(append(slices.Clip(s.attrs), attribute.String("operation", s.op.Name), attribute.String("result", result))...)
+	ctx := context.Background()
+	counter(s.op).Add(ctx, 1, attrs)
+	instruments.duration.Record(ctx, time.Since(s.start).Seconds(), attrs)
+}
@@ -0,0 +1,104 @@
+package timing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Phase is a step of a command whose duration is recorded.
+type Phase string
+
+// Phases in the order a command runs them. Fetch and Decrypt are part of
+// Values.
+const (
+	Values   Phase = "values load"
+	Fetch    Phase = "source fetch"
+	Decrypt  Phase = "decryption"
+	Render   Phase = "render"
+	Validate Phase = "validation"
+	Plan     Phase = "plan"
+	Apply    Phase = "apply"
+	Wait     Phase = "wait"
+)
+
+// Phases lists all phases in order.
+var Phases = []Phase{Values, Fetch, Decrypt, Render, Validate, Plan, Apply, Wait}
+
+// Total is the time spent in a phase.
+type Total struct {
+	Phase    Phase         `json:"phase"`
+	Duration time.Duration `json:"duration"`
+	// Count is how often the phase ran, e.g. one fetch per remote source.
+	Count int `json:"count"`
+}
+
+// Recorder accumulates the time spent in each phase. It is safe for
+// concurrent use.
+type Recorder struct {
+	start  time.Time
+	mu     sync.Mutex
+	totals map[Phase]*Total
+}
+
+// New returns a Recorder whose elapsed time starts now.
+func New() *Recorder {
+	return &Recorder{start: time.Now(), totals: map[Phase]*Total{}}
+}
+
+// Add records d spent in phase.
+func (r *Recorder) Add(phase Phase, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.totals[phase]
+	if !ok {
+		t = &Total{Phase: phase}
+		r.totals[phase] = t
+	}
+	t.Duration += d
+	t.Count++
+}
+
+// Totals returns the recorded phases in the order of Phases.
+func (r *Recorder) Totals() []Total {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []Total
+	for _, phase := range Phases {
+		if t, ok := r.totals[phase]; ok {
+			out = append(out, *t)
+		}
+	}
+	return out
+}
+
+// Elapsed returns the time since the Recorder was created.
+func (r *Recorder) Elapsed() time.Duration {
+	return time.Since(r.start)
+}
+
+type ctxKey struct{}
+
+// WithContext attaches the recorder to the context for downstream retrieval.
+func WithContext(ctx context.Context, r *Recorder) context.Context {
+	return context.WithValue(ctx, ctxKey{}, r)
+}
+
+// FromContext returns the recorder of the context, or nil.
+func FromContext(ctx context.Context) *Recorder {
+	r, _ := ctx.Value(ctxKey{}).(*Recorder)
+	return r
+}
+
+// Track starts timing phase for the recorder of ctx and returns the func
+// that stops it. Without a recorder it does nothing.
+//
+//	defer timing.Track(ctx, timing.Render)()
+func Track(ctx context.Context, phase Phase) func() {
+	r := FromContext(ctx)
+	if r == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { r.Add(phase, time.Since(start)) }
+}
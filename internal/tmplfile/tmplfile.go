@@ -0,0 +1,178 @@
+package tmplfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/acebelowzero/tmpl/internal/source"
+)
+
+// File lists the releases deployed together by 'tmpl apply -f'.
+//
+//	envFiles: [shared.env]
+//	releases:
+//	  - name: db
+//	    chart: ./charts/db
+//	    values: [values/db.yaml]
+//	    context: prod
+//	  - name: api
+//	    chart: myrepo/api
+//	    version: ^1.2
+//	    values: [values/api.yaml]
+//	    context: prod
+//	    needs: [db]
+//
+// Relative paths are resolved against the directory of the file.
+type File struct {
+	// EnvFiles are shared by every release, before its own env files.
+	EnvFiles []string  `yaml:"envFiles"`
+	Releases []Release `yaml:"releases"`
+}
+
+// Release is a chart deployed as a stack.
+type Release struct {
+	// Name is the stack and release name.
+	Name string `yaml:"name"`
+	// Chart is a chart directory, archive or REPO/CHART reference.
+	Chart string `yaml:"chart"`
+	// Version constrains repository charts.
+	Version  string   `yaml:"version"`
+	Values   []string `yaml:"values"`
+	EnvFiles []string `yaml:"envFiles"`
+	// Context is the docker context deployed to; empty uses the
+	// environment like any other command.
+	Context string `yaml:"context"`
+	// Needs names releases of the file that are deployed first.
+	Needs []string `yaml:"needs"`
+}
+
+// IsFile reports whether path names a tmplfile rather than a values file:
+// tmplfile.yaml, tmplfile.yml or a name ending in .tmplfile.yaml.
+func IsFile(path string) bool {
+	base := filepath.Base(path)
+	for _, ext := range []string{".yaml", ".yml"} {
+		if base == "tmplfile"+ext || strings.HasSuffix(base, ".tmplfile"+ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads and validates a tmplfile, resolving relative paths.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read tmplfile: %w", err)
+	}
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse tmplfile %s: %w", path, err)
+	}
+	if err := f.validate(); err != nil {
+		return nil, fmt.Errorf("tmplfile %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	f.EnvFiles = resolvePaths(dir, f.EnvFiles)
+	for i := range f.Releases {
+		r := &f.Releases[i]
+		r.Values = resolvePaths(dir, r.Values)
+		r.EnvFiles = resolvePaths(dir, r.EnvFiles)
+		// Charts that do not exist locally are left as repository
+		// references.
+		if local := resolvePath(dir, r.Chart); local != r.Chart {
+			if _, err := os.Stat(local); err == nil {
+				r.Chart = local
+			}
+		}
+	}
+	return &f, nil
+}
+
+func (f *File) validate() error {
+	if len(f.Releases) == 0 {
+		return errors.New("no releases")
+	}
+	seen := map[string]bool{}
+	for _, r := range f.Releases {
+		switch {
+		case r.Name == "":
+			return errors.New("release without a name")
+		case r.Chart == "":
+			return fmt.Errorf("release %s has no chart", r.Name)
+		case seen[r.Name]:
+			return fmt.Errorf("release %s is listed twice", r.Name)
+		}
+		seen[r.Name] = true
+	}
+	for _, r := range f.Releases {
+		for _, need := range r.Needs {
+			if !seen[need] {
+				return fmt.Errorf("release %s needs unknown release %s", r.Name, need)
+			}
+		}
+	}
+	_, err := f.Ordered()
+	return err
+}
+
+// Ordered returns the releases so that each follows the releases it needs,
+// otherwise keeping the order of the file.
+func (f *File) Ordered() ([]Release, error) {
+	byName := make(map[string]Release, len(f.Releases))
+	for _, r := range f.Releases {
+		byName[r.Name] = r
+	}
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	ordered := make([]Release, 0, len(f.Releases))
+	var visit func(r Release, path []string) error
+	visit = func(r Release, path []string) error {
+		switch state[r.Name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path, r.Name), " -> "))
+		}
+		state[r.Name] = visiting
+		for _, need := range r.Needs {
+			if err := visit(byName[need], append(path, r.Name)); err != nil {
+				return err
+			}
+		}
+		state[r.Name] = done
+		ordered = append(ordered, r)
+		return nil
+	}
+	for _, r := range f.Releases {
+		if err := visit(r, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+func resolvePaths(dir string, paths []string) []string {
+	out := make([]string, len(paths))
+	for i, p := range paths {
+		out[i] = resolvePath(dir, p)
+	}
+	return out
+}
+
+// resolvePath joins relative local paths to dir; absolute paths and
+// remote sources are returned unchanged.
+func resolvePath(dir, p string) string {
+	if p == "" || filepath.IsAbs(p) || source.ParseScheme(p) != source.SchemeLocal {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
@@ -0,0 +1,110 @@
+package values
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/acebelowzero/tmpl/internal/compose"
+	"github.com/acebelowzero/tmpl/internal/sops"
+)
+
+// Redact returns a copy of values in which the content at the paths of
+// encrypted, as reported by Loader.Encrypted, is replaced by a reference
+// to its digest, so that the values can be stored without the secrets
+// decrypted into them. Content that is already such a reference is kept.
+func Redact(values map[string]any, encrypted map[string]string) map[string]any {
+	if len(encrypted) == 0 || values == nil {
+		return values
+	}
+	out := copyValues(values)
+	for p := range encrypted {
+		path, err := SplitPath(p)
+		if err != nil || len(path) == 0 {
+			continue
+		}
+		v, ok := Lookup(out, path)
+		if !ok || isRedacted(v) {
+			continue
+		}
+		content, err := json.Marshal(v)
+		if err != nil {
+			content = []byte(fmt.Sprint(v))
+		}
+		set(out, path, compose.RedactedReference(content))
+	}
+	return out
+}
+
+// Decrypt returns a copy of values redacted by Redact with the content at
+// the paths of encrypted decrypted again from their files. Paths that do
+// not hold a reference of Redact are left as they are.
+func Decrypt(ctx context.Context, values map[string]any, encrypted map[string]string) (map[string]any, error) {
+	if len(encrypted) == 0 {
+		return values, nil
+	}
+	decryptor, err := sops.New()
+	if err != nil {
+		return nil, err
+	}
+	out := copyValues(values)
+	for _, p := range slices.Sorted(maps.Keys(encrypted)) {
+		path, err := SplitPath(p)
+		if err != nil || len(path) == 0 {
+			return nil, fmt.Errorf("encrypted value %q: invalid path", p)
+		}
+		if v, _ := Lookup(out, path); !isRedacted(v) {
+			continue
+		}
+		v, err := decryptFile(ctx, decryptor, encrypted[p])
+		if err != nil {
+			return nil, fmt.Errorf("decrypt %s for %s: %w", encrypted[p], p, err)
+		}
+		set(out, path, v)
+	}
+	return out, nil
+}
+
+// isRedacted reports whether v is a reference of Redact.
+func isRedacted(v any) bool {
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(s, compose.RedactedPrefix)
+}
+
+// set sets the value at path, creating the maps that lead to it. Paths
+// through lists only replace existing items.
+func set(values map[string]any, path []string, v any) {
+	var current any = values
+	for i, segment := range path {
+		last := i == len(path)-1
+		switch node := current.(type) {
+		case map[string]any:
+			if last {
+				node[segment] = v
+				return
+			}
+			next, ok := node[segment]
+			if !ok {
+				next = map[string]any{}
+				node[segment] = next
+			}
+			current = next
+		case []any:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node) {
+				return
+			}
+			if last {
+				node[index] = v
+				return
+			}
+			current = node[index]
+		default:
+			return
+		}
+	}
+}
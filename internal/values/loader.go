@@ -8,33 +8,91 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 
 	"dario.cat/mergo"
+	"go.opentelemetry.io/otel/attribute"
 	"gopkg.in/yaml.v3"
 
+	"github.com/acebelowzero/tmpl/internal/chart"
 	"github.com/acebelowzero/tmpl/internal/env"
+	"github.com/acebelowzero/tmpl/internal/logx"
 	"github.com/acebelowzero/tmpl/internal/sops"
 	"github.com/acebelowzero/tmpl/internal/source"
+	"github.com/acebelowzero/tmpl/internal/telemetry"
+	"github.com/acebelowzero/tmpl/internal/timing"
 )
 
 // LoaderConfig controls optional behaviour of Loader.
 type LoaderConfig struct {
 	EnvFiles []string
+	// Env holds variables for expansion on top of EnvFiles.
+	Env map[string]string
+	// AllowEnv restricts the process environment variables values may
+	// expand, by name or glob pattern. Empty allows all of them.
+	AllowEnv []string
+	// Overrides are merged over all values files as given, without
+	// expansion or decryption.
+	Overrides map[string]any
+	// Isolated keeps values from reaching the host: the process
+	// environment is not expanded and encrypted references are only
+	// resolved inside the chart.
+	Isolated bool
+	// Sandbox is for rendering untrusted charts: remote values sources
+	// are rejected, and the values files and encrypted references of the
+	// chart must not lead out of its directory.
+	Sandbox bool
 }
 
 // Loader merges values from default chart values, additional files, and remote sources.
 type Loader struct {
 	cfg           LoaderConfig
+	chartPath     string
 	env           *env.Resolver
 	sopsDecryptor sops.Decryptor
 	sourceFactory *source.Factory
+	fetched       []Fetched
+	files         []string
+	user          map[string]any
+	layers        []Layer
+	encrypted     map[string]string
+}
+
+// Kinds of values layers, in merge order.
+const (
+	// LayerLibrary is the values.yaml of a library chart.
+	LayerLibrary = "library"
+	// LayerChart is the values.yaml of the chart.
+	LayerChart = "chart"
+	// LayerFile is a values file given to Load.
+	LayerFile = "file"
+	// LayerOverrides is LoaderConfig.Overrides.
+	LayerOverrides = "overrides"
+)
+
+// Layer is the content of one values source merged by Load.
+type Layer struct {
+	Kind string `json:"kind"`
+	// Source is the path or URL of the values file, or "overrides" for
+	// LoaderConfig.Overrides.
+	Source string         `json:"source"`
+	Values map[string]any `json:"values"`
+}
+
+// Fetched records a remote values source read by Load.
+type Fetched struct {
+	URL string `json:"url" yaml:"url"`
+	// Revision identifies the fetched content when the backend reports
+	// one, e.g. a git commit SHA or an OCI digest.
+	Revision string `json:"revision,omitempty" yaml:"revision,omitempty"`
 }
 
 // NewLoader constructs a Loader with the provided dependencies.
 func NewLoader(cfg LoaderConfig) (*Loader, error) {
-	resolver, err := env.NewResolver(env.Config{Files: cfg.EnvFiles})
+	resolver, err := env.NewResolver(env.Config{Files: cfg.EnvFiles, Vars: cfg.Env, Isolated: cfg.Isolated, Allow: cfg.AllowEnv})
 	if err != nil {
 		return nil, err
 	}
@@ -54,32 +112,164 @@ func NewLoader(cfg LoaderConfig) (*Loader, error) {
 
 // Load composes values from defaults, user-specified files, and remote sources.
 func (l *Loader) Load(ctx context.Context, chartPath string, extraFiles ...string) (map[string]any, error) {
+	defer timing.Track(ctx, timing.Values)()
 	if chartPath == "" {
 		chartPath = "."
 	}
 
-	baseValues, err := l.readValuesFile(ctx, filepath.Join(chartPath, "values.yaml"))
+	// Library charts the chart depends on provide defaults below its own.
+	libs, err := chart.Libraries(chartPath)
 	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return nil, err
 	}
-	if baseValues == nil {
-		baseValues = map[string]any{}
+	dirs := make([]string, 0, len(libs)+1)
+	for _, lib := range libs {
+		dirs = append(dirs, lib.Dir)
+	}
+	dirs = append(dirs, chartPath)
+	l.chartPath = chartPath
+	l.files = nil
+	l.encrypted = map[string]string{}
+
+	baseValues := map[string]any{}
+	var layers []Layer
+	for i, dir := range dirs {
+		kind := LayerLibrary
+		if i == len(dirs)-1 {
+			kind = LayerChart
+		}
+		path := filepath.Join(dir, "values.yaml")
+		if err := l.confine(path); err != nil {
+			return nil, err
+		}
+		data, err := l.readValuesFile(ctx, path, true)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, Layer{Kind: kind, Source: path, Values: copyValues(data)})
+		if err := mergo.Merge(&baseValues, data, mergo.WithOverride); err != nil {
+			return nil, fmt.Errorf("merge values from %s: %w", path, err)
+		}
 	}
 
+	user := map[string]any{}
 	for _, file := range extraFiles {
-		data, err := l.readValuesFile(ctx, file)
+		data, err := l.readValuesFile(ctx, file, false)
 		if err != nil {
 			return nil, err
 		}
+		layers = append(layers, Layer{Kind: LayerFile, Source: file, Values: copyValues(data)})
+		if err := mergo.Merge(&user, copyValues(data), mergo.WithOverride); err != nil {
+			return nil, fmt.Errorf("merge values from %s: %w", file, err)
+		}
 		if err := mergo.Merge(&baseValues, data, mergo.WithOverride); err != nil {
 			return nil, fmt.Errorf("merge values from %s: %w", file, err)
 		}
 	}
+	if len(l.cfg.Overrides) > 0 {
+		layers = append(layers, Layer{Kind: LayerOverrides, Source: "overrides", Values: copyValues(l.cfg.Overrides)})
+		if err := mergo.Merge(&user, copyValues(l.cfg.Overrides), mergo.WithOverride); err != nil {
+			return nil, fmt.Errorf("merge values overrides: %w", err)
+		}
+		if err := mergo.Merge(&baseValues, copyValues(l.cfg.Overrides), mergo.WithOverride); err != nil {
+			return nil, fmt.Errorf("merge values overrides: %w", err)
+		}
+	}
+	l.user = user
+	l.layers = layers
 
 	return baseValues, nil
 }
 
-func (l *Loader) readValuesFile(ctx context.Context, path string) (map[string]any, error) {
+// Layers returns the values sources of the last call to Load in merge
+// order, each as it was read: after expansion and decryption, before
+// merging.
+func (l *Loader) Layers() []Layer {
+	return l.layers
+}
+
+// Env returns the environment variables expanded in values read by Load,
+// by name.
+func (l *Loader) Env() map[string]string {
+	return l.env.Expanded()
+}
+
+// Encrypted returns the values paths, in JoinPath form, whose content
+// the last call to Load decrypted, and the encrypted files it was read
+// from. Values set by later layers at the same paths are included.
+func (l *Loader) Encrypted() map[string]string {
+	return l.encrypted
+}
+
+// UserValues returns the values supplied through extra files in the last
+// call to Load, merged without the chart defaults.
+func (l *Loader) UserValues() map[string]any {
+	return l.user
+}
+
+// copyValues deep copies decoded values so merges into one tree don't
+// alias maps of another.
+func copyValues(v map[string]any) map[string]any {
+	out := make(map[string]any, len(v))
+	for k, val := range v {
+		out[k] = copyValue(val)
+	}
+	return out
+}
+
+func copyValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		return copyValues(t)
+	case []any:
+		out := make([]any, len(t))
+		for i, item := range t {
+			out[i] = copyValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// confine fails in the sandbox when path leads out of the chart. Paths
+// that do not exist are left to the read to report.
+func (l *Loader) confine(path string) error {
+	if !l.cfg.Sandbox {
+		return nil
+	}
+	ok, err := chart.Contains(l.chartPath, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s is outside the chart, which the sandbox does not allow", path)
+	}
+	return nil
+}
+
+// Files returns the local files the last call to Load read: values files,
+// including those of library charts, and the files of encrypted
+// references.
+func (l *Loader) Files() []string {
+	return l.files
+}
+
+// Fetched returns the remote sources read by previous calls to Load.
+func (l *Loader) Fetched() []Fetched {
+	return l.fetched
+}
+
+// readValuesFile reads, expands and decrypts a values file. inChart marks
+// values files of the chart, whose encrypted references the sandbox
+// confines to it.
+func (l *Loader) readValuesFile(ctx context.Context, path string, inChart bool) (map[string]any, error) {
 	if path == "" {
 		return nil, errors.New("values file path is empty")
 	}
@@ -92,12 +282,29 @@ func (l *Loader) readValuesFile(ctx context.Context, path string) (map[string]an
 	if scheme == source.SchemeLocal {
 		data, err = os.ReadFile(path)
 		baseDir = filepath.Dir(path)
+		if err == nil {
+			l.files = append(l.files, path)
+		}
 	} else {
-		src, err := l.sourceFactory.New(path)
-		if err != nil {
-			return nil, err
+		if l.cfg.Sandbox {
+			return nil, fmt.Errorf("read values file %s: remote sources are disabled in the sandbox", path)
+		}
+		src, serr := l.sourceFactory.New(path)
+		if serr != nil {
+			return nil, serr
+		}
+		stop := timing.Track(ctx, timing.Fetch)
+		fetchCtx, span := telemetry.Start(ctx, telemetry.Fetch, attribute.String("scheme", scheme))
+		data, err = src.Fetch(fetchCtx)
+		span.End(err)
+		stop()
+		if err == nil {
+			fetched := Fetched{URL: path}
+			if r, ok := src.(source.Revisioner); ok {
+				fetched.Revision = r.Revision()
+			}
+			l.fetched = append(l.fetched, fetched)
 		}
-		data, err = src.Fetch(ctx)
 		baseDir = ""
 	}
 	if err != nil {
@@ -117,7 +324,7 @@ func (l *Loader) readValuesFile(ctx context.Context, path string) (map[string]an
 		return nil, fmt.Errorf("decode yaml %s: %w", path, err)
 	}
 
-	processed, err := l.decryptValues(ctx, decoded, baseDir)
+	processed, err := l.decryptValues(ctx, decoded, nil, baseDir, inChart)
 	if err != nil {
 		return nil, fmt.Errorf("decrypt secrets in %s: %w", path, err)
 	}
@@ -129,7 +336,9 @@ func (l *Loader) readValuesFile(ctx context.Context, path string) (map[string]an
 	return result, nil
 }
 
-func (l *Loader) decryptValues(ctx context.Context, node any, baseDir string) (any, error) {
+// decryptValues replaces the encrypted references in node, found at the
+// values path, by their decrypted content.
+func (l *Loader) decryptValues(ctx context.Context, node any, path []string, baseDir string, inChart bool) (any, error) {
 	switch v := node.(type) {
 	case map[string]any:
 		keys := make([]string, 0, len(v))
@@ -139,18 +348,18 @@ func (l *Loader) decryptValues(ctx context.Context, node any, baseDir string) (a
 		sort.Strings(keys)
 		result := make(map[string]any, len(v))
 		for _, key := range keys {
-			processedValue, err := l.decryptValues(ctx, v[key], baseDir)
+			newKey := strings.TrimSuffix(key, ".enc")
+			processedValue, err := l.decryptValues(ctx, v[key], append(slices.Clip(path), newKey), baseDir, inChart)
 			if err != nil {
 				return nil, err
 			}
-			newKey := strings.TrimSuffix(key, ".enc")
 			result[newKey] = processedValue
 		}
 		return result, nil
 	case []any:
 		result := make([]any, len(v))
 		for i := range v {
-			processedValue, err := l.decryptValues(ctx, v[i], baseDir)
+			processedValue, err := l.decryptValues(ctx, v[i], append(slices.Clip(path), strconv.Itoa(i)), baseDir, inChart)
 			if err != nil {
 				return nil, err
 			}
@@ -161,24 +370,53 @@ func (l *Loader) decryptValues(ctx context.Context, node any, baseDir string) (a
 		if !strings.HasSuffix(v, ".enc") {
 			return v, nil
 		}
-		decrypted, err := l.decryptValue(ctx, v, baseDir)
+		decrypted, file, err := l.decryptValue(ctx, v, baseDir, inChart)
 		if err != nil {
 			return nil, err
 		}
+		l.encrypted[JoinPath(path)] = file
 		return decrypted, nil
 	default:
 		return node, nil
 	}
 }
 
-func (l *Loader) decryptValue(ctx context.Context, ref, baseDir string) (any, error) {
+// decryptValue returns the decrypted content of the encrypted reference
+// ref and the absolute path of the file it was read from.
+func (l *Loader) decryptValue(ctx context.Context, ref, baseDir string, inChart bool) (any, string, error) {
 	path := ref
+	if l.cfg.Isolated && (baseDir == "" || !filepath.IsLocal(ref)) {
+		return nil, "", fmt.Errorf("decrypt %s: encrypted references must be relative to the chart", ref)
+	}
 	if !filepath.IsAbs(path) && baseDir != "" {
 		path = filepath.Join(baseDir, ref)
 	}
-	data, err := l.sopsDecryptor.DecryptFile(ctx, path)
+	if inChart {
+		if err := l.confine(path); err != nil {
+			return nil, "", fmt.Errorf("decrypt %s: %w", ref, err)
+		}
+	}
+	l.files = append(l.files, path)
+	value, err := decryptFile(ctx, l.sopsDecryptor, path)
+	if err != nil {
+		return nil, "", fmt.Errorf("decrypt %s: %w", ref, err)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return value, path, nil
+}
+
+// decryptFile decrypts the sops file at path, whose content is decoded as
+// YAML or, when it is not, kept as a string.
+func decryptFile(ctx context.Context, decryptor sops.Decryptor, path string) (any, error) {
+	stop := timing.Track(ctx, timing.Decrypt)
+	decryptCtx, span := telemetry.Start(ctx, telemetry.Decrypt)
+	data, err := decryptor.DecryptFile(decryptCtx, path)
+	span.End(err)
+	stop()
 	if err != nil {
-		return nil, fmt.Errorf("decrypt %s: %w", ref, err)
+		return nil, err
 	}
 
 	trimmed := bytes.TrimSpace(data)
@@ -186,9 +424,28 @@ func (l *Loader) decryptValue(ctx context.Context, ref, baseDir string) (any, er
 		return "", nil
 	}
 
+	logx.Sensitive(string(trimmed))
 	var parsed any
 	if err := yaml.Unmarshal(trimmed, &parsed); err == nil {
+		registerSensitive(parsed)
 		return parsed, nil
 	}
 	return string(trimmed), nil
 }
+
+// registerSensitive has the logger mask the scalars of a decrypted value.
+func registerSensitive(node any) {
+	switch v := node.(type) {
+	case map[string]any:
+		for _, child := range v {
+			registerSensitive(child)
+		}
+	case []any:
+		for _, child := range v {
+			registerSensitive(child)
+		}
+	case nil, bool:
+	default:
+		logx.Sensitive(fmt.Sprint(v))
+	}
+}
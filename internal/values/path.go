@@ -0,0 +1,104 @@
+package values
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SplitPath splits a values path such as ".Values.ingress.hosts[0].name"
+// into its keys and list indexes. The .Values prefix, as written in
+// templates, is optional.
+func SplitPath(path string) ([]string, error) {
+	rest := strings.TrimPrefix(path, ".")
+	if rest == "Values" {
+		rest = ""
+	}
+	rest = strings.TrimPrefix(rest, "Values.")
+	if rest == "" {
+		return nil, nil
+	}
+	var segments []string
+	for _, part := range strings.Split(rest, ".") {
+		key, indexes, _ := strings.Cut(part, "[")
+		if key == "" && (indexes == "" || len(segments) == 0) {
+			return nil, fmt.Errorf("invalid values path %q: empty key", path)
+		}
+		if key != "" {
+			segments = append(segments, key)
+		}
+		if indexes == "" {
+			continue
+		}
+		for _, index := range strings.Split(strings.TrimSuffix(indexes, "]"), "][") {
+			if _, err := strconv.Atoi(index); err != nil {
+				return nil, fmt.Errorf("invalid values path %q: index %q is not a number", path, index)
+			}
+			segments = append(segments, index)
+		}
+	}
+	return segments, nil
+}
+
+// Lookup returns the value at path, as split by SplitPath, and whether it
+// is set.
+func Lookup(values map[string]any, path []string) (any, bool) {
+	var current any = values
+	for _, segment := range path {
+		switch node := current.(type) {
+		case map[string]any:
+			v, ok := node[segment]
+			if !ok {
+				return nil, false
+			}
+			current = v
+		case []any:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			current = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// JoinPath is the inverse of SplitPath, without the .Values prefix.
+func JoinPath(path []string) string {
+	var b strings.Builder
+	for i, segment := range path {
+		if _, err := strconv.Atoi(segment); err == nil && i > 0 {
+			fmt.Fprintf(&b, "[%s]", segment)
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(segment)
+	}
+	return b.String()
+}
+
+// Keys returns the paths of the leaf keys of values, in JoinPath form and
+// sorted. Lists are leaves, as a values file replaces a list as a whole.
+func Keys(values map[string]any) []string {
+	var keys []string
+	var walk func(prefix []string, node map[string]any)
+	walk = func(prefix []string, node map[string]any) {
+		for key, v := range node {
+			path := append(slices.Clip(prefix), key)
+			if m, ok := v.(map[string]any); ok {
+				walk(path, m)
+				continue
+			}
+			keys = append(keys, JoinPath(path))
+		}
+	}
+	walk(nil, values)
+	sort.Strings(keys)
+	return keys
+}
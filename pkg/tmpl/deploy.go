@@ -0,0 +1,239 @@
+package tmpl
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/acebelowzero/tmpl/internal/deploy"
+	"github.com/acebelowzero/tmpl/internal/docker"
+	"github.com/acebelowzero/tmpl/internal/logx"
+	"github.com/acebelowzero/tmpl/internal/release"
+	"github.com/acebelowzero/tmpl/internal/render"
+	"github.com/acebelowzero/tmpl/internal/revision"
+	"github.com/acebelowzero/tmpl/internal/stack"
+)
+
+// Engine selects the docker engine of a swarm manager. The zero value uses
+// DOCKER_HOST, DOCKER_CONTEXT, DOCKER_TLS_VERIFY and DOCKER_CERT_PATH and
+// then the current docker context, as the command does.
+type Engine struct {
+	// Host is the engine to connect to, e.g. tcp://swarm:2376. It
+	// overrides Context.
+	Host string
+	// Context names a docker CLI context.
+	Context   string
+	TLSVerify bool
+	// CAFile, CertFile and KeyFile are the TLS material of the engine.
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+func (e Engine) client() (*docker.Client, error) {
+	cfg, err := docker.Endpoint(e).Config()
+	if err != nil {
+		return nil, fmt.Errorf("resolve docker endpoint: %w", err)
+	}
+	client, err := docker.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("setup docker client: %w", err)
+	}
+	return client, nil
+}
+
+// PlanOptions tunes Plan.
+type PlanOptions struct {
+	Engine Engine
+	// ReleaseStore is where release history is kept: "swarm" (the
+	// default), "file", "file://DIR" or "s3://BUCKET/PREFIX".
+	ReleaseStore string
+	// Prune plans the removal of objects that are no longer in the chart
+	// and that the release created.
+	Prune bool
+}
+
+// Change is an object-level change of a plan or apply.
+type Change struct {
+	// Kind is network, config, secret or service.
+	Kind string
+	Name string
+	// Action is create, update, delete or unchanged.
+	Action   string
+	Warnings []string
+}
+
+// Planned is the result of Plan: the changes that converge the swarm onto
+// a rendered stack.
+type Planned struct {
+	// Stack is the name of the stack, prefixed with the namespace.
+	Stack string
+	// Engine is the host of the engine the plan was made against.
+	Engine  string
+	Changes []Change
+
+	plan    *deploy.Plan
+	release *release.Release
+	secrets map[string]docker.ObjectSpec
+	client  *docker.Client
+	store   release.Store
+}
+
+// HasChanges reports whether the plan creates, updates or deletes any
+// object.
+func (p *Planned) HasChanges() bool {
+	return p.plan.HasChanges()
+}
+
+// Destructive lists the deletes of the plan that remove services with
+// named volumes, which 'tmpl apply' refuses without --allow-destructive.
+func (p *Planned) Destructive() []Change {
+	return changes(p.plan.Destructive())
+}
+
+// Plan converts r into swarm objects and compares them with the stack in
+// the swarm of opts.Engine, without changing anything.
+func Plan(ctx context.Context, r *Rendered, opts PlanOptions) (*Planned, error) {
+	parsed, err := render.ParseStack(r.Output, r.result.SourceMap)
+	if err != nil {
+		return nil, err
+	}
+	c := r.Chart
+	desired, err := stack.Convert(parsed, stack.Options{Name: stack.Namespaced(r.Namespace, r.Release), BaseDir: c.Dir, Namespace: r.Namespace})
+	if err != nil {
+		return nil, fmt.Errorf("convert stack: %w", err)
+	}
+	rel, err := newRelease(r, desired)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := opts.Engine.client()
+	if err != nil {
+		return nil, err
+	}
+	store, err := release.New(ctx, release.Config{Location: opts.ReleaseStore, Docker: client})
+	if err != nil {
+		return nil, err
+	}
+	if err := release.LabelOwner(ctx, store, rel); err != nil {
+		return nil, err
+	}
+
+	p, err := deploy.New(client).Plan(ctx, desired, deploy.Options{Prune: opts.Prune})
+	if err != nil {
+		return nil, err
+	}
+	return &Planned{
+		Stack:   p.Stack,
+		Engine:  p.Engine,
+		Changes: changes(p.Changes),
+		plan:    p,
+		release: rel,
+		secrets: desired.Secrets,
+		client:  client,
+		store:   store,
+	}, nil
+}
+
+// newRelease describes the stack of r as a release revision to record.
+func newRelease(r *Rendered, desired *stack.Stack) (*release.Release, error) {
+	c := r.Chart
+	return revision.New(revision.Rendered{
+		ChartDir:   c.Dir,
+		Namespace:  r.Namespace,
+		Result:     r.result,
+		Values:     c.Values,
+		UserValues: c.loader.UserValues(),
+		Encrypted:  c.loader.Encrypted(),
+		Fetched:    c.loader.Fetched(),
+		Stack:      desired,
+	})
+}
+
+// ApplyOptions tunes Apply.
+type ApplyOptions struct {
+	// LockTimeout is how long to wait for another operation on the
+	// release to finish. Zero fails at once when the release is locked.
+	LockTimeout time.Duration
+	// HistoryMax is the number of revisions kept per release; older ones
+	// are deleted. Zero keeps all of them.
+	HistoryMax int
+	// Wait waits for the services of the stack to converge, until ctx
+	// ends, and marks the revision failed when they do not.
+	Wait bool
+}
+
+// Applied is the result of Apply.
+type Applied struct {
+	// Revision is the revision the release was recorded as.
+	Revision int
+	Changes  []Change
+	// Notes is the rendered NOTES.txt of the chart.
+	Notes string
+}
+
+// Apply makes the changes of p and records the release in the release
+// store. The swarm is planned again first, and Apply fails without
+// changing anything when it changed since p was made. Deploy hooks of the
+// chart are not run.
+//
+// When the apply fails after changing the swarm, the revision is recorded
+// as failed and the returned Applied holds the changes that were made.
+//
+// Revisions are recorded as by the command with an unencrypted store:
+// secret payloads and the values decrypted from sops files are left out.
+func Apply(ctx context.Context, p *Planned, opts ApplyOptions) (*Applied, error) {
+	rel := *p.release
+	unlock, err := release.Acquire(ctx, p.store, rel.Name, release.NewLockInfo("apply"), opts.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			logx.FromContext(ctx).Warn("could not unlock release", "stack", rel.Name, "error", err)
+		}
+	}()
+
+	deployer := deploy.New(p.client)
+	started := time.Now()
+	result, err := deployer.ApplyPlan(ctx, p.plan, p.secrets)
+	applied := &Applied{Changes: changes(result.Changes), Notes: rel.Notes}
+	if err != nil {
+		if result.Count(deploy.ActionCreate)+result.Count(deploy.ActionUpdate) > 0 {
+			rel.Status, rel.Description = release.StatusFailed, err.Error()
+			if rerr := release.Append(context.WithoutCancel(ctx), p.store, &rel); rerr != nil {
+				logx.FromContext(ctx).Warn("could not record failed release", "stack", rel.Name, "error", rerr)
+			} else {
+				applied.Revision = rel.Revision
+				release.PruneHistory(ctx, p.store, rel.Name, opts.HistoryMax)
+			}
+		}
+		return applied, fmt.Errorf("apply stack %s: %w", rel.Name, err)
+	}
+
+	rel.Status = release.StatusDeployed
+	if err := release.Append(ctx, p.store, &rel); err != nil {
+		return applied, err
+	}
+	applied.Revision = rel.Revision
+	release.PruneHistory(ctx, p.store, rel.Name, opts.HistoryMax)
+	if !opts.Wait {
+		return applied, nil
+	}
+	if err := deployer.Wait(ctx, rel.Stack.ServiceNames(), deploy.WaitOptions{Since: started}); err != nil {
+		if serr := p.store.SetStatus(context.WithoutCancel(ctx), rel.Name, rel.Revision, release.StatusFailed); serr != nil {
+			logx.FromContext(ctx).Warn("could not mark release failed", "stack", rel.Name, "error", serr)
+		}
+		return applied, fmt.Errorf("stack %s: %w", rel.Name, err)
+	}
+	return applied, nil
+}
+
+func changes(in []deploy.Change) []Change {
+	out := make([]Change, len(in))
+	for i, c := range in {
+		out[i] = Change{Kind: string(c.Kind), Name: c.Name, Action: string(c.Action), Warnings: c.Warnings}
+	}
+	return out
+}
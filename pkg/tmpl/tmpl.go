@@ -0,0 +1,153 @@
+// Package tmpl is the Go API of tmpl, for programs that render and deploy
+// charts without running the tmpl command.
+//
+// The steps of 'tmpl apply' are separate functions: Load reads a chart and
+// merges its values, Render renders the templates, Plan compares the
+// rendered stack with a swarm and Apply makes the planned changes and
+// records the release.
+//
+//	chart, err := tmpl.Load(ctx, "./charts/web", tmpl.LoadOptions{ValuesFiles: []string{"values-prod.yaml"}})
+//	if err != nil {
+//		return err
+//	}
+//	rendered, err := tmpl.Render(ctx, chart, tmpl.RenderOptions{})
+//	if err != nil {
+//		return err
+//	}
+//	plan, err := tmpl.Plan(ctx, rendered, tmpl.PlanOptions{Prune: true})
+//	if err != nil {
+//		return err
+//	}
+//	if plan.HasChanges() {
+//		_, err = tmpl.Apply(ctx, plan, tmpl.ApplyOptions{})
+//	}
+//
+// Unlike the command, nothing is read from the tmpl configuration files
+// and nothing is printed. Log records go to the logger set on the context
+// with WithLogger, by default to stderr as JSON.
+package tmpl
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/acebelowzero/tmpl/internal/chart"
+	"github.com/acebelowzero/tmpl/internal/logx"
+	"github.com/acebelowzero/tmpl/internal/render"
+	"github.com/acebelowzero/tmpl/internal/values"
+)
+
+// WithLogger returns a context whose log records, such as those of fetched
+// values sources or of the changes made by Apply, go to logger. Values
+// registered as sensitive are not masked by loggers other than the
+// default.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return logx.WithContext(ctx, logger)
+}
+
+// LoadOptions tunes Load.
+type LoadOptions struct {
+	// ValuesFiles are merged over the values.yaml of the chart in order,
+	// as with -f.
+	ValuesFiles []string
+	// EnvFiles hold variables for the expansion of ${VAR} references in
+	// values files, as with --env-file.
+	EnvFiles []string
+	// Env holds variables for expansion on top of EnvFiles.
+	Env map[string]string
+	// AllowEnv restricts the process environment variables values may
+	// expand, by name or glob pattern. Empty allows all of them.
+	AllowEnv []string
+	// Overrides are merged over all values files as given.
+	Overrides map[string]any
+	// Isolated keeps values from reaching the host: the process
+	// environment is not expanded and encrypted references are only
+	// resolved inside the chart.
+	Isolated bool
+}
+
+// Chart is a chart with its merged values.
+type Chart struct {
+	// Dir is the directory of the chart.
+	Dir     string
+	Name    string
+	Version string
+	// Values are the merged values the chart is rendered with, including
+	// decrypted secrets.
+	Values map[string]any
+
+	meta   *chart.Metadata
+	loader *values.Loader
+}
+
+// Load reads the chart in dir and merges its values with those of opts.
+// Remote and encrypted values are fetched and decrypted as by the command.
+func Load(ctx context.Context, dir string, opts LoadOptions) (*Chart, error) {
+	meta, err := chart.LoadMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	loader, err := values.NewLoader(values.LoaderConfig{
+		EnvFiles:  opts.EnvFiles,
+		Env:       opts.Env,
+		AllowEnv:  opts.AllowEnv,
+		Overrides: opts.Overrides,
+		Isolated:  opts.Isolated,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("setup values loader: %w", err)
+	}
+	merged, err := loader.Load(ctx, dir, opts.ValuesFiles...)
+	if err != nil {
+		return nil, fmt.Errorf("load values: %w", err)
+	}
+	return &Chart{Dir: dir, Name: meta.Name, Version: meta.Version, Values: merged, meta: meta, loader: loader}, nil
+}
+
+// RenderOptions tunes Render.
+type RenderOptions struct {
+	// Release is .Release.Name, the release and stack name. It defaults
+	// to the chart name.
+	Release string
+	// Namespace is .Release.Namespace; a release in a namespace deploys
+	// a stack prefixed with it.
+	Namespace string
+	// SkipEmpty leaves templates that render to whitespace only out of
+	// the output.
+	SkipEmpty bool
+	// Profiles are the active compose profiles. Services with profiles
+	// are left out unless one of them is active; "*" activates all.
+	Profiles []string
+}
+
+// Rendered is the output of a chart.
+type Rendered struct {
+	Chart     *Chart
+	Release   string
+	Namespace string
+	// Output is the rendered stack. Unlike the output of 'tmpl template'
+	// it holds the content of inline secrets.
+	Output []byte
+	// Notes is the rendered NOTES.txt of the chart, if it has one.
+	Notes string
+
+	result *render.Result
+}
+
+// Render renders the templates of c.
+func Render(ctx context.Context, c *Chart, opts RenderOptions) (*Rendered, error) {
+	name := opts.Release
+	if name == "" {
+		name = c.Name
+	}
+	renderer, err := render.New(render.Config{ChartPath: c.Dir, SkipEmpty: opts.SkipEmpty, ReleaseName: name, Namespace: opts.Namespace, Profiles: opts.Profiles})
+	if err != nil {
+		return nil, fmt.Errorf("setup renderer: %w", err)
+	}
+	result, err := renderer.Render(ctx, c.Values)
+	if err != nil {
+		return nil, fmt.Errorf("render templates: %w", err)
+	}
+	return &Rendered{Chart: c, Release: name, Namespace: opts.Namespace, Output: result.Output, Notes: result.Notes, result: result}, nil
+}